@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"watson/budget"
+	"watson/database"
+	"watson/money"
+)
+
+// Limits on a budget CSV. maxBudgetImportCents keeps budgets within the budget column's NUMERIC(10,2).
+const (
+	maxBudgetImportBytes    = 1 << 20
+	maxBudgetImportRows     = 200
+	maxBudgetImportCategory = 255
+	maxBudgetImportCents    = 99_999_999_99
+)
+
+// budgetImportRowError is why a row of a budget CSV was rejected. Row counts the header as row 1, like a
+// spreadsheet does.
+type budgetImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// budgetImportColumns are the header names accepted for each column of a budget CSV, compared case insensitively
+var budgetImportColumns = map[string][]string{
+	"category":   {"category", "name"},
+	"budget":     {"budget", "amount", "monthly amount", "monthly_amount"},
+	"strictness": {"strictness"},
+}
+
+// parseBudgetCSV reads a budget CSV with a header row naming a category column, a budget column and optionally a
+// strictness column. Amounts may carry a currency symbol and thousands separators. It returns every invalid row
+// rather than stopping at the first, so the whole file can be fixed at once.
+func parseBudgetCSV(r io.Reader) ([]database.BudgetCategoryImport, []budgetImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("the CSV is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("the CSV can't be read: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		for column, aliases := range budgetImportColumns {
+			for _, alias := range aliases {
+				if _, seen := columns[column]; name == alias && !seen {
+					columns[column] = i
+				}
+			}
+		}
+	}
+	if _, ok := columns["category"]; !ok {
+		return nil, nil, errors.New("the header row must have a category column")
+	}
+	if _, ok := columns["budget"]; !ok {
+		return nil, nil, errors.New("the header row must have a budget or amount column")
+	}
+
+	imports := []database.BudgetCategoryImport{}
+	rowErrors := []budgetImportRowError{}
+	seen := map[string]int{}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("the CSV can't be read: %v", err)
+		}
+		field := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		category, amount, strictness := field("category"), field("budget"), field("strictness")
+		if category == "" && amount == "" && strictness == "" {
+			continue
+		}
+		if len(imports)+len(rowErrors) >= maxBudgetImportRows {
+			return nil, nil, fmt.Errorf("the CSV can have at most %d categories", maxBudgetImportRows)
+		}
+		rowError := func(message string) {
+			rowErrors = append(rowErrors, budgetImportRowError{Row: row, Error: message})
+		}
+		if category == "" {
+			rowError("category is missing")
+			continue
+		}
+		if len(category) > maxBudgetImportCategory {
+			rowError(fmt.Sprintf("category is longer than %d characters", maxBudgetImportCategory))
+			continue
+		}
+		if first, duplicate := seen[strings.ToLower(category)]; duplicate {
+			rowError(fmt.Sprintf("%s is already budgeted on row %d", category, first))
+			continue
+		}
+		seen[strings.ToLower(category)] = row
+		if amount == "" {
+			rowError("budget is missing")
+			continue
+		}
+		parsed, err := money.Parse(strings.NewReplacer("$", "", ",", "", " ", "").Replace(amount))
+		if err != nil {
+			rowError(fmt.Sprintf("budget %q isn't an amount", amount))
+			continue
+		}
+		if parsed.IsNegative() || parsed.Cents() > maxBudgetImportCents {
+			rowError(fmt.Sprintf("budget %q must be between 0 and %s", amount, money.FromCents(maxBudgetImportCents)))
+			continue
+		}
+		imported := database.BudgetCategoryImport{Category: category, Budget: parsed}
+		if strictness != "" {
+			strictness = strings.ToLower(strictness)
+			if !budget.IsValidStrictness(strictness) {
+				rowError("strictness must be soft or hard")
+				continue
+			}
+			imported.Strictness = &strictness
+		}
+		imports = append(imports, imported)
+	}
+	return imports, rowErrors, nil
+}
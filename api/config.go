@@ -3,6 +3,11 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	"watson/budget"
+	"watson/plaid"
 
 	"github.com/joho/godotenv"
 )
@@ -39,6 +44,127 @@ func GetBankLinkURL() string {
 	return getEnv("BANK_LINK_URL", "http://localhost:5173/")
 }
 
+// GetBillingSuccessURL is where Stripe Checkout sends users after subscribing
+func GetBillingSuccessURL() string {
+	return getEnv("BILLING_SUCCESS_URL", GetBankLinkURL()+"billing/success")
+}
+
+// GetBillingCancelURL is where Stripe Checkout sends users who back out
+func GetBillingCancelURL() string {
+	return getEnv("BILLING_CANCEL_URL", GetBankLinkURL()+"billing/cancel")
+}
+
+// GetGoogleSheetsReturnURL is where users land after authorizing Google Sheets. The result is added as the
+// google_sheets query parameter.
+func GetGoogleSheetsReturnURL() string {
+	return getEnv("GOOGLE_SHEETS_RETURN_URL", GetBankLinkURL()+"integrations/google-sheets")
+}
+
+// GetAdvisorLinkURL is the page advisors open a shared access link on. The link's code is added as the code query
+// parameter.
+func GetAdvisorLinkURL() string {
+	return getEnv("ADVISOR_LINK_URL", GetBankLinkURL()+"advisor")
+}
+
+// IsDemoModeEnabled reports whether demo data seeding is allowed: always against the Plaid sandbox,
+// otherwise only when ENABLE_DEMO_SEED is set for local development
+func IsDemoModeEnabled() bool {
+	return plaid.PLAID_ENV == "sandbox" || getEnv("ENABLE_DEMO_SEED", "") == "true"
+}
+
+// IsPlaidSandbox reports whether the API talks to the Plaid sandbox, the only environment the sandbox endpoints work in
+func IsPlaidSandbox() bool {
+	return plaid.PLAID_ENV == "sandbox"
+}
+
+// GetAdminAPIToken returns the shared secret required by admin endpoints; admin endpoints are disabled when empty
+func GetAdminAPIToken() string {
+	return getEnv("ADMIN_API_TOKEN", "")
+}
+
+// GetTellerEnvironment is the Teller environment enrollments are made in: sandbox, development or production
+func GetTellerEnvironment() string {
+	return getEnv("TELLER_ENVIRONMENT", "sandbox")
+}
+
+// GetTellerSigningKeys returns the base64 token signing keys from the Teller dashboard, comma separated in
+// TELLER_TOKEN_SIGNING_KEYS so a new key can be added before the old one is retired
+func GetTellerSigningKeys() []string {
+	var keys []string
+	for _, key := range strings.Split(getEnv("TELLER_TOKEN_SIGNING_KEYS", ""), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// GetTrustedProxies returns the addresses or CIDRs of the proxies in front of the API, comma separated in
+// TRUSTED_PROXIES. Only they may set the client IP with X-Forwarded-For; with none, it is the connection's address.
+func GetTrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// GetDefaultBudgetStrategy returns the allowance strategy used for users who haven't chosen one. It reads the
+// same BUDGET_REDISTRIBUTION_STRATEGY as the worker so simulations match the daily-balance job.
+func GetDefaultBudgetStrategy() string {
+	strategy := getEnv("BUDGET_REDISTRIBUTION_STRATEGY", budget.DefaultStrategy)
+	if !budget.IsValidStrategy(strategy) {
+		log.Printf("Warning: invalid BUDGET_REDISTRIBUTION_STRATEGY %q, using default", strategy)
+		return budget.DefaultStrategy
+	}
+	return strategy
+}
+
+// defaultAccountRetentionDays is how long a deactivated account's data is kept before it is purged
+const defaultAccountRetentionDays = 30
+
+// GetAccountRetention returns how long a deactivated account can be reactivated before its data is purged,
+// ACCOUNT_RETENTION_DAYS. Changing it only applies to accounts deactivated afterwards.
+func GetAccountRetention() time.Duration {
+	days := defaultAccountRetentionDays
+	if value := getEnv("ACCOUNT_RETENTION_DAYS", ""); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Printf("Warning: invalid ACCOUNT_RETENTION_DAYS %q, using default", value)
+		} else {
+			days = parsed
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GetRateLimit reads a rate limit from RATE_LIMIT_<NAME> formatted as "<requests>/<window>", e.g. "10/1m",
+// falling back to the given default when unset or malformed
+func GetRateLimit(name string, requests int, window time.Duration) RateLimit {
+	limit := RateLimit{Name: name, Requests: requests, Window: window}
+	envKey := "RATE_LIMIT_" + strings.ToUpper(name)
+	value := getEnv(envKey, "")
+	if value == "" {
+		return limit
+	}
+	requestsPart, windowPart, found := strings.Cut(value, "/")
+	parsedRequests, err := strconv.Atoi(requestsPart)
+	if !found || err != nil || parsedRequests <= 0 {
+		log.Printf("Warning: invalid %s %q, using default", envKey, value)
+		return limit
+	}
+	parsedWindow, err := time.ParseDuration(windowPart)
+	if err != nil || parsedWindow < time.Second {
+		log.Printf("Warning: invalid %s %q, using default", envKey, value)
+		return limit
+	}
+	limit.Requests = parsedRequests
+	limit.Window = parsedWindow
+	return limit
+}
+
 // GetConnectionString returns the PostgreSQL connection string
 func (c *Config) GetConnectionString() string {
 	return getEnv("DATABASE_URL", "")
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+	"watson/jobs"
+)
+
+// enqueueDailyBalance enqueues process_daily_balance for the user and month unless one was enqueued within
+// jobs.DailyBalanceDebounceWindow, so repeatedly tapping refresh queues one job rather than one per tap. It
+// returns the ID of the job that will do the work, and whether that job was already queued.
+func enqueueDailyBalance(ctx context.Context, userID int, monthYear int) (string, bool, error) {
+	jobData := map[string]interface{}{
+		"user_id":    userID,
+		"month_year": monthYear,
+	}
+	key := jobs.DailyBalanceDebounceKey(userID, monthYear)
+	return enqueueDebouncedJob(ctx, key, jobs.DailyBalanceDebounceWindow, "process_daily_balance", jobData)
+}
+
+// enqueueDebouncedJob enqueues a job unless key is already claimed, in which case it returns the ID of the job
+// enqueued by whoever claimed it. It shares the rate limiter's Redis client; when Redis is unavailable every
+// request enqueues, as it did before debouncing.
+func enqueueDebouncedJob(ctx context.Context, key string, window time.Duration, jobType string, jobData map[string]interface{}) (string, bool, error) {
+	if rateLimitClient != nil {
+		claimed, jobID, err := jobs.ClaimDebounce(ctx, rateLimitClient, key, window)
+		if err != nil {
+			log.Printf("Debounce check failed for %s, enqueueing anyway: %v", jobType, err)
+		} else if !claimed {
+			return jobID, true, nil
+		}
+	}
+
+	jobID, err := enqueueWorkerJobWithID(ctx, jobType, jobData)
+	if err != nil {
+		if rateLimitClient != nil {
+			jobs.ReleaseDebounce(ctx, rateLimitClient, key)
+		}
+		return "", false, err
+	}
+	if rateLimitClient != nil {
+		if err := jobs.RecordDebouncedJob(ctx, rateLimitClient, key, jobID); err != nil {
+			log.Printf("Failed to record debounced %s job %s: %v", jobType, jobID, err)
+		}
+	}
+	return jobID, false, nil
+}
@@ -1,17 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"watson/database"
+	"watson/jobs"
+	"watson/telemetry"
 
+	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateJWT(userID int, expiry time.Duration) (string, error) {
+func GenerateJWT(userID int, sessionVersion int, expiry time.Duration) (string, error) {
 	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(expiry).Unix(),
+		"user_id":         userID,
+		"session_version": sessionVersion,
+		"exp":             time.Now().Add(expiry).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -19,28 +32,69 @@ func GenerateJWT(userID int, expiry time.Duration) (string, error) {
 	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
 
-func GenerateJWTWithDefaultExpiry(userID int) (string, error) {
-	return GenerateJWT(userID, time.Hour*24)
+func GenerateJWTWithDefaultExpiry(userID int, sessionVersion int) (string, error) {
+	return GenerateJWT(userID, sessionVersion, time.Hour*24)
 }
 
-func GenerateTemporaryJWT(userID int) (string, error) {
-	return GenerateJWT(userID, time.Minute*15)
+func GenerateTemporaryJWT(userID int, sessionVersion int) (string, error) {
+	return GenerateJWT(userID, sessionVersion, time.Minute*15)
 }
 
-func VerifyJWT(tokenString string) (int, error) {
+// JWTScopeAdvisor marks tokens issued to an advisor from a share link, which AuthMiddleware limits to viewing.
+// Tokens without a scope are the user's own sessions.
+const JWTScopeAdvisor = "advisor"
+
+// GenerateAdvisorJWT issues a token for an advisor redeeming the user's advisor access
+func GenerateAdvisorJWT(userID int, sessionVersion int, advisorAccessID int, expiry time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":           userID,
+		"session_version":   sessionVersion,
+		"scope":             JWTScopeAdvisor,
+		"advisor_access_id": advisorAccessID,
+		"exp":               time.Now().Add(expiry).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// JWTClaims are what a verified token was issued for
+type JWTClaims struct {
+	UserID         int
+	SessionVersion int
+	// Scope is empty for the user's own sessions
+	Scope           string
+	AdvisorAccessID int
+}
+
+// VerifyJWT returns the claims of a valid token. Tokens issued before session versions existed are version 0.
+func VerifyJWT(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return []byte(os.Getenv("JWT_SECRET")), nil
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, errors.New("invalid token claims")
+		return nil, errors.New("invalid token claims")
 	}
 
-	return int(claims["user_id"].(float64)), nil
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	sessionVersion, _ := claims["session_version"].(float64)
+	scope, _ := claims["scope"].(string)
+	advisorAccessID, _ := claims["advisor_access_id"].(float64)
+	return &JWTClaims{
+		UserID:          int(userID),
+		SessionVersion:  int(sessionVersion),
+		Scope:           scope,
+		AdvisorAccessID: int(advisorAccessID),
+	}, nil
 }
 
 // returns month and year formatted as MMYYYY
@@ -49,3 +103,94 @@ func GetCurrentMonthYear() int {
 	// return int(now.Month())*10000 + now.Year()
 	return 72025
 }
+
+// postWorkerEnqueue posts an enqueue request to the background worker, passing along the
+// caller's trace context so the job's spans join the request's trace
+func postWorkerEnqueue(ctx context.Context, enqueueJSON []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, workerUrl+"/enqueue", bytes.NewBuffer(enqueueJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return telemetry.HTTPClient.Do(req)
+}
+
+// EnqueueWorkerJob sends a job to the background worker's /enqueue endpoint
+func EnqueueWorkerJob(ctx context.Context, jobType string, jobData map[string]interface{}) error {
+	_, err := enqueueWorkerJobWithID(ctx, jobType, jobData)
+	return err
+}
+
+// enqueueWorkerJobWithID is EnqueueWorkerJob returning the ID the worker gave the job. jobData can be anything
+// JSON encodable, such as the json.RawMessage of a recorded job run.
+func enqueueWorkerJobWithID(ctx context.Context, jobType string, jobData interface{}) (string, error) {
+	enqueueJSON, err := jobs.MarshalEnqueueRequest(jobType, jobData)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal enqueue request: %v", err)
+	}
+	resp, err := postWorkerEnqueue(ctx, enqueueJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to enqueue job, status: %d", resp.StatusCode)
+	}
+	// The job is queued either way, so a response without an ID only loses the ID
+	var response jobs.EnqueueResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return response.JobID, nil
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// listQueryReservedParams are query parameters that control paging or searching rather than filter
+var listQueryReservedParams = map[string]bool{"sort": true, "limit": true, "offset": true, "include_archived": true, "include_hidden": true, "q": true}
+
+// ParseListQuery reads filters, sort and paging from query parameters, e.g.
+// ?amount[gte]=10&category[in]=FOOD_AND_DRINK,TRAVEL&sort=-date&limit=50. A bare field means equality and a
+// leading "-" on sort means descending. Field names and values are checked against the schema when the query runs.
+// ignoredParams are the endpoint's own parameters, which aren't read as filters.
+func ParseListQuery(c *gin.Context, ignoredParams ...string) (database.ListQuery, error) {
+	listQuery := database.ListQuery{Limit: defaultListLimit}
+	for key, values := range c.Request.URL.Query() {
+		if listQueryReservedParams[key] || slices.Contains(ignoredParams, key) {
+			continue
+		}
+		field, op := key, database.FilterEq
+		if open := strings.Index(key, "["); open > 0 && strings.HasSuffix(key, "]") {
+			field, op = key[:open], database.FilterOp(key[open+1:len(key)-1])
+		}
+		for _, value := range values {
+			listQuery.Filters = append(listQuery.Filters, database.Filter{Field: field, Op: op, Value: value})
+		}
+	}
+
+	sort := c.Query("sort")
+	if strings.HasPrefix(sort, "-") {
+		listQuery.Desc = true
+		sort = sort[1:]
+	}
+	listQuery.Sort = sort
+	listQuery.IncludeArchived = c.Query("include_archived") == "true"
+	listQuery.IncludeHidden = c.Query("include_hidden") == "true"
+
+	if val, exists := c.GetQuery("limit"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 || parsed > maxListLimit {
+			return listQuery, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		listQuery.Limit = parsed
+	}
+	if val, exists := c.GetQuery("offset"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 0 {
+			return listQuery, errors.New("offset must be a non-negative integer")
+		}
+		listQuery.Offset = parsed
+	}
+	return listQuery, nil
+}
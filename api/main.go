@@ -2,20 +2,43 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"watson/budget"
 	"watson/database"
+	"watson/errorreport"
+	"watson/googlesheets"
+	"watson/importers"
+	"watson/jobs"
+	"watson/money"
 
 	plaid "watson/plaid"
+	"watson/redisconn"
+	"watson/reports"
+	"watson/stripe"
+	"watson/telemetry"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	// plaid "github.com/plaid/plaid-go/v31/plaid"
 )
 
@@ -29,7 +52,7 @@ type album struct {
 
 // User represents a user registration request
 type User struct {
-	Email    string `json:"email" binding:"required,email"`
+	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required,min=2"`
 }
 
@@ -55,15 +78,31 @@ func login(c *gin.Context) {
 		return
 	}
 
-	dbUser, err := database.GetUserByEmailAndPassword(user.Email, user.Password)
+	dbUser, err := database.GetUserByEmailAndPassword(normalizeEmail(user.Email), user.Password)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Invalid email or password",
 		})
 		return
 	}
+	deactivation, err := database.GetAccountDeactivation(dbUser.UserID)
+	if err != nil {
+		log.Printf("Failed to check account deactivation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to log in",
+		})
+		return
+	}
+	if deactivation != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":       "Account is deactivated, reactivate it to log in",
+			"code":        "ACCOUNT_DEACTIVATED",
+			"purge_after": deactivation.PurgeAfter,
+		})
+		return
+	}
 
-	jwt, err := GenerateJWTWithDefaultExpiry(dbUser.UserID)
+	jwt, err := GenerateJWTWithDefaultExpiry(dbUser.UserID, dbUser.SessionVersion)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to generate JWT",
@@ -79,6 +118,15 @@ func login(c *gin.Context) {
 	})
 }
 
+// POST /register
+// INPUT:
+//
+//	{
+//	  "email": "name@example.com",
+//	  "password": "password1"
+//	}
+//
+// Emails are stored lowercased. Registering an email that is already taken, in any case, responds 409.
 func register(c *gin.Context) {
 	var user User
 
@@ -89,9 +137,31 @@ func register(c *gin.Context) {
 		})
 		return
 	}
+	user.Email = normalizeEmail(user.Email)
+	if err := validateEmail(user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_EMAIL",
+		})
+		return
+	}
+	if err := validatePassword(user.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "WEAK_PASSWORD",
+		})
+		return
+	}
 
 	// Create user in database
 	dbUser, err := database.CreateUser(user.Email, user.Password)
+	if errors.Is(err, database.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Email is already registered",
+			"code":  "EMAIL_TAKEN",
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to create user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -99,7 +169,10 @@ func register(c *gin.Context) {
 		})
 		return
 	}
-	jwt, err := GenerateJWTWithDefaultExpiry(dbUser.UserID)
+	if err := database.CompleteOnboardingStep(dbUser.UserID, database.OnboardingRegistered); err != nil {
+		log.Printf("Failed to record onboarding step: %v", err)
+	}
+	jwt, err := GenerateJWTWithDefaultExpiry(dbUser.UserID, dbUser.SessionVersion)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to generate JWT",
@@ -116,6 +189,229 @@ func register(c *gin.Context) {
 	})
 }
 
+// ChangePasswordRequest is the body of POST /auth/change-password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// POST /auth/change-password
+// INPUT:
+//
+//	{
+//	  "current_password": "old-password",
+//	  "new_password": "new-password"
+//	}
+//
+// Signs out every other session. The response carries a new JWT for the caller to keep using.
+func changePassword(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	var request ChangePasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err := validatePassword(request.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "WEAK_PASSWORD",
+		})
+		return
+	}
+	dbUser, err := database.ChangePassword(userIdInt, request.CurrentPassword, request.NewPassword, auditRequest(c))
+	if err != nil {
+		log.Printf("Failed to change password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to change password",
+		})
+		return
+	}
+	if dbUser == nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Current password is incorrect",
+			"code":  "INVALID_PASSWORD",
+		})
+		return
+	}
+	respondWithNewSession(c, dbUser, "Password changed")
+}
+
+// ChangeEmailRequest is the body of POST /auth/change-email
+type ChangeEmailRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewEmail        string `json:"new_email" binding:"required"`
+}
+
+// POST /auth/change-email
+// INPUT:
+//
+//	{
+//	  "current_password": "password",
+//	  "new_email": "new@example.com"
+//	}
+//
+// Signs out every other session. The response carries a new JWT for the caller to keep using.
+func changeEmail(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	var request ChangeEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	newEmail := normalizeEmail(request.NewEmail)
+	if err := validateEmail(newEmail); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_EMAIL",
+		})
+		return
+	}
+	dbUser, err := database.ChangeEmail(userIdInt, request.CurrentPassword, newEmail, auditRequest(c))
+	if errors.Is(err, database.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Email is already registered",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to change email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to change email",
+		})
+		return
+	}
+	if dbUser == nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Current password is incorrect",
+			"code":  "INVALID_PASSWORD",
+		})
+		return
+	}
+	respondWithNewSession(c, dbUser, "Email changed")
+}
+
+// DeactivateAccountRequest is the body of POST /account/deactivate
+type DeactivateAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// POST /account/deactivate
+// INPUT:
+//
+//	{
+//	  "password": "password"
+//	}
+//
+// Signs out every session and stops syncing the user's accounts. The data is kept until purge_after, and logging
+// in through POST /account/reactivate before then restores the account; after it, the data is deleted.
+func deactivateAccount(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	var request DeactivateAccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	deactivation, err := database.DeactivateUser(userIdInt, request.Password, GetAccountRetention(), auditRequest(c))
+	if err != nil {
+		log.Printf("Failed to deactivate account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to deactivate account",
+		})
+		return
+	}
+	if deactivation == nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Password is incorrect",
+			"code":  "INVALID_PASSWORD",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Account deactivated",
+		"deactivation": deactivation,
+	})
+}
+
+// POST /account/reactivate
+// INPUT:
+//
+//	{
+//	  "email": "name@example.com",
+//	  "password": "password"
+//	}
+//
+// Restores a deactivated account whose data hasn't been purged yet and logs the user in
+func reactivateAccount(c *gin.Context) {
+	var user User
+	if err := c.ShouldBindJSON(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	dbUser, err := database.ReactivateUser(normalizeEmail(user.Email), user.Password, auditRequest(c))
+	if err != nil {
+		log.Printf("Failed to reactivate account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to reactivate account",
+		})
+		return
+	}
+	if dbUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid email or password, or the account can no longer be reactivated",
+		})
+		return
+	}
+	respondWithNewSession(c, dbUser, "Account reactivated")
+}
+
+// auditRequest describes where a request came from for the audit log
+func auditRequest(c *gin.Context) database.AuditRequest {
+	return database.AuditRequest{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// respondWithNewSession answers a credential change with a JWT for the user's new session version
+func respondWithNewSession(c *gin.Context, dbUser *database.DBUser, message string) {
+	jwt, err := GenerateJWTWithDefaultExpiry(dbUser.UserID, dbUser.SessionVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate JWT",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"user": gin.H{
+			"user_id": dbUser.UserID,
+			"email":   dbUser.Email,
+		},
+		"jwt": jwt,
+	})
+}
+
 func isNewUser(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
@@ -140,6 +436,26 @@ func isNewUser(c *gin.Context) {
 	})
 }
 
+// GET /onboarding
+// Returns which onboarding steps the user has completed so the app can resume where they left off
+func getOnboarding(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	progress, err := database.GetOnboardingProgress(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get onboarding progress: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get onboarding progress",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"onboarding": progress,
+	})
+}
+
 func getBalance(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
@@ -185,15 +501,31 @@ func genereateBankLink(c *gin.Context) {
 		return // AuthMiddleware already sent the response
 	}
 	bankLinkUrl := GetBankLinkURL()
-	temporaryJWT, err := GenerateTemporaryJWT(userIdInt)
+	sessionVersion, err := database.GetSessionVersion(userIdInt)
 	if err != nil {
+		log.Printf("Failed to get session version: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to generate temporary JWT",
 		})
 		return
 	}
+	temporaryJWT, err := GenerateTemporaryJWT(userIdInt, sessionVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate temporary JWT",
+		})
+		return
+	}
+	nonce, err := issueTellerNonce(c.Request.Context(), userIdInt)
+	if err != nil {
+		log.Printf("Failed to issue teller nonce: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate bank link",
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"bank_link_url": bankLinkUrl + "?jwt=" + temporaryJWT,
+		"bank_link_url": bankLinkUrl + "?jwt=" + temporaryJWT + "&nonce=" + nonce,
 	})
 }
 
@@ -226,6 +558,28 @@ func genereateBankLink(c *gin.Context) {
 //     })
 //   }
 
+// verifyTellerPayloadOrRespond checks a Teller Connect payload's signatures, answering the request itself and
+// returning false when it can't be accepted
+func verifyTellerPayloadOrRespond(c *gin.Context, userID int, payload database.TellerPayload) bool {
+	err := verifyTellerPayload(c.Request.Context(), userID, payload)
+	if errors.Is(err, errTellerVerification) {
+		log.Printf("Rejected Teller payload for user %d: %v", userID, err)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Teller enrollment could not be verified",
+			"code":  "INVALID_TELLER_SIGNATURE",
+		})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to verify Teller payload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify Teller enrollment",
+		})
+		return false
+	}
+	return true
+}
+
 func handleTellerSuccess(c *gin.Context) {
 	var payload database.TellerPayload
 	userIdInt, err := AuthMiddleware(c)
@@ -239,6 +593,9 @@ func handleTellerSuccess(c *gin.Context) {
 		})
 		return
 	}
+	if !verifyTellerPayloadOrRespond(c, userIdInt, payload) {
+		return
+	}
 	tellerInstitution, err := database.HandleTellerSuccess(userIdInt, payload)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -246,6 +603,9 @@ func handleTellerSuccess(c *gin.Context) {
 		})
 		return
 	}
+	if err := database.CompleteOnboardingStep(userIdInt, database.OnboardingBankLinked); err != nil {
+		log.Printf("Failed to record onboarding step: %v", err)
+	}
 
 	// Enqueue job to process transactions
 	jobData := map[string]interface{}{
@@ -261,17 +621,12 @@ func handleTellerSuccess(c *gin.Context) {
 	log.Printf("Job data: %v", jobData)
 
 	// Send POST request to background worker to enqueue job
-	enqueueRequest := map[string]interface{}{
-		"type": "new_teller_link",
-		"data": jobData,
-	}
-
-	enqueueJSON, err := json.Marshal(enqueueRequest)
+	enqueueJSON, err := jobs.MarshalEnqueueRequest("new_teller_link", jobData)
 	if err != nil {
 		log.Printf("Failed to marshal enqueue request: %v", err)
 	} else {
 		// Make HTTP request to background worker
-		resp, err := http.Post(workerUrl+"/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
+		resp, err := postWorkerEnqueue(c.Request.Context(), enqueueJSON)
 		// resp, err := http.Post("http://worker:8081/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
 		if err != nil {
 			log.Printf("Failed to enqueue job: %v", err)
@@ -291,6 +646,118 @@ func handleTellerSuccess(c *gin.Context) {
 	})
 }
 
+// ** TELLER ENROLLMENTS **
+
+// TellerEnrollmentResponse is a Teller connection with how long ago it was linked
+type TellerEnrollmentResponse struct {
+	database.TellerEnrollment
+	AgeDays int `json:"age_days"`
+	// NeedsReauth means Teller refused the connection's access; open Teller Connect with enrollment_id and
+	// send the result to the relink endpoint
+	NeedsReauth bool `json:"needs_reauth"`
+}
+
+// GET /teller/enrollments
+func getTellerEnrollments(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	enrollments, err := database.GetTellerEnrollments(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get teller enrollments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get Teller enrollments",
+		})
+		return
+	}
+	response := make([]TellerEnrollmentResponse, 0, len(enrollments))
+	for _, enrollment := range enrollments {
+		response = append(response, TellerEnrollmentResponse{
+			TellerEnrollment: enrollment,
+			AgeDays:          int(time.Since(enrollment.CreatedAt).Hours() / 24),
+			NeedsReauth:      enrollment.Status == database.TellerEnrollmentReauthRequired,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enrollments": response,
+	})
+}
+
+// POST /teller/enrollments/:id/relink
+// Takes the onSuccess payload of Teller Connect opened with the enrollment's enrollment_id, stores its access
+// token and syncs the enrollment again
+// INPUT: same as /bank-link-teller/success
+func relinkTellerEnrollment(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var payload database.TellerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.AccessToken == "" || payload.Enrollment.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body, expected the Teller Connect payload",
+		})
+		return
+	}
+	if !verifyTellerPayloadOrRespond(c, userIdInt, payload) {
+		return
+	}
+	enrollment, err := database.RelinkTellerEnrollment(userIdInt, c.Param("id"), payload.Enrollment.ID, payload.AccessToken)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Teller enrollment not found",
+		})
+		return
+	}
+	jobData := map[string]interface{}{
+		"user_id": userIdInt,
+		"token":   payload.AccessToken,
+	}
+	if err := EnqueueWorkerJob(c.Request.Context(), "new_teller_link", jobData); err != nil {
+		log.Printf("Failed to enqueue sync of relinked teller enrollment %s: %v", enrollment.ID, err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Teller enrollment reconnected",
+		"enrollment": enrollment,
+	})
+}
+
+// DELETE /teller/institutions/:id?delete_transactions=true
+// Removes a Teller enrollment: its access token is dropped so it is never synced again and its accounts are
+// deleted. Their transactions are kept unless delete_transactions=true.
+func deleteTellerInstitution(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	deleteTransactions := c.Query("delete_transactions") == "true"
+	enrollment, deleted, err := database.DeleteTellerEnrollment(userIdInt, c.Param("id"), deleteTransactions)
+	if err != nil {
+		log.Printf("Failed to delete teller enrollment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete Teller institution",
+		})
+		return
+	}
+	if enrollment == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Teller institution not found",
+		})
+		return
+	}
+	if deleted > 0 {
+		if _, _, err := enqueueDailyBalance(c.Request.Context(), userIdInt, GetCurrentMonthYear()); err != nil {
+			log.Printf("Failed to enqueue daily balance after deleting teller enrollment %s: %v", enrollment.ID, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "Teller institution deleted",
+		"enrollment":           enrollment,
+		"transactions_deleted": deleted,
+	})
+}
+
 // Health check endpoint
 func healthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -306,7 +773,7 @@ func createLinkToken(c *gin.Context) {
 		return // AuthMiddleware already sent the response
 	}
 
-	linkToken, err := plaid.CreateLinkToken(userIdInt)
+	linkToken, err := plaid.CreateLinkToken(c.Request.Context(), userIdInt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create link token",
@@ -330,13 +797,16 @@ func handlePlaidSuccess(c *gin.Context) {
 		})
 		return
 	}
-	accessToken, itemId, err := plaid.ExchangePublicToken(payload["public_token"].(string), userIdInt)
+	accessToken, itemId, err := plaid.ExchangePublicToken(c.Request.Context(), payload["public_token"].(string), userIdInt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to exchange public token",
 		})
 		return
 	}
+	if err := database.CompleteOnboardingStep(userIdInt, database.OnboardingBankLinked); err != nil {
+		log.Printf("Failed to record onboarding step: %v", err)
+	}
 
 	jobData := map[string]interface{}{
 		"user_id":      userIdInt,
@@ -344,12 +814,7 @@ func handlePlaidSuccess(c *gin.Context) {
 		"item_id":      itemId,
 	}
 
-	enqueueRequest := map[string]interface{}{
-		"type": "initial_plaid_sync",
-		"data": jobData,
-	}
-
-	enqueueJSON, err := json.Marshal(enqueueRequest)
+	enqueueJSON, err := jobs.MarshalEnqueueRequest("initial_plaid_sync", jobData)
 	if err != nil {
 		log.Printf("Failed to marshal enqueue request: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -359,7 +824,7 @@ func handlePlaidSuccess(c *gin.Context) {
 	}
 
 	// Make HTTP request to background worker
-	resp, err := http.Post(workerUrl+"/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
+	resp, err := postWorkerEnqueue(c.Request.Context(), enqueueJSON)
 	// resp, err := http.Post("http://worker:8081/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
 	if err != nil {
 		log.Printf("Failed to enqueue job: %v", err)
@@ -392,8 +857,21 @@ func getPlaidTransactions(c *gin.Context) {
 		return // AuthMiddleware already sent the response
 	}
 	accessToken := c.Query("access_token")
+	startDate := c.DefaultQuery("start_date", time.Now().AddDate(-1, 0, 0).Format("2006-01-02"))
+	endDate := c.DefaultQuery("end_date", time.Now().Format("2006-01-02"))
 
-	transactions, err := plaid.GetTransactions(accessToken, "2025-01-01", "2025-01-31")
+	transactions, err := plaid.GetTransactions(c.Request.Context(), accessToken, startDate, endDate)
+	var partialErr *plaid.PartialFetchError
+	if errors.As(err, &partialErr) {
+		c.JSON(http.StatusOK, gin.H{
+			"transactions": transactions,
+			"partial":      true,
+			"fetched":      partialErr.Fetched,
+			"total":        partialErr.Total,
+			"error":        partialErr.Err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to get transactions",
@@ -402,6 +880,7 @@ func getPlaidTransactions(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"transactions": transactions,
+		"partial":      false,
 	})
 }
 
@@ -411,7 +890,7 @@ func getPlaidAccounts(c *gin.Context) {
 		return // AuthMiddleware already sent the response
 	}
 	accessToken := c.Query("access_token")
-	accounts, err := plaid.GetAccounts(accessToken)
+	accounts, err := plaid.GetAccounts(c.Request.Context(), accessToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to get accounts",
@@ -423,736 +902,6183 @@ func getPlaidAccounts(c *gin.Context) {
 	})
 }
 
-// ** MONTHLY SUMMARY **
+// PlaidWebhook is the part of a Plaid webhook the API acts on
+type PlaidWebhook struct {
+	WebhookType string `json:"webhook_type"`
+	WebhookCode string `json:"webhook_code"`
+	ItemID      string `json:"item_id"`
+}
 
-func hasAnyMonthlySummaries(c *gin.Context) {
-	userIdInt, err := AuthMiddleware(c)
+// POST /plaid/webhook
+// Public endpoint called by Plaid; requests are authenticated by their Plaid-Verification JWT. New transactions
+// on an item sync the user's Plaid accounts, and new accounts at the bank are added and backfilled.
+func plaidWebhook(c *gin.Context) {
+	payload, err := c.GetRawData()
 	if err != nil {
-		return // AuthMiddleware already sent the response
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to read request body",
+		})
+		return
 	}
-	hasAnyMonthlySummaries, err := database.HasAnyMonthlySummaries(userIdInt)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to check if user has any monthly summaries",
+	if err := plaid.VerifyWebhook(c.Request.Context(), payload, c.GetHeader("Plaid-Verification")); err != nil {
+		log.Printf("Rejected plaid webhook: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid signature",
+		})
+		return
+	}
+	var webhook PlaidWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid webhook",
 		})
 		return
 	}
+
+	switch webhook.WebhookType + ":" + webhook.WebhookCode {
+	case "TRANSACTIONS:DEFAULT_UPDATE", "TRANSACTIONS:SYNC_UPDATES_AVAILABLE":
+		userID, err := database.GetUserIdFromItemID(webhook.ItemID)
+		if err != nil {
+			log.Printf("Failed to look up plaid item %s: %v", webhook.ItemID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to process webhook",
+			})
+			return
+		}
+		if userID == 0 {
+			// Acknowledged so Plaid doesn't keep retrying an item we don't know
+			log.Printf("Ignoring plaid webhook %s for unknown item %s", webhook.WebhookCode, webhook.ItemID)
+			break
+		}
+		if err := EnqueueWorkerJob(c.Request.Context(), "sync_plaid_accounts", map[string]interface{}{
+			"user_id": userID,
+		}); err != nil {
+			log.Printf("Failed to enqueue plaid sync for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to process webhook",
+			})
+			return
+		}
+		log.Printf("Enqueued plaid sync for user %d from webhook %s", userID, webhook.WebhookCode)
+	case "ITEM:NEW_ACCOUNTS_AVAILABLE":
+		// The worker looks the item up itself and skips it if it is no longer linked
+		if err := EnqueueWorkerJob(c.Request.Context(), "add_plaid_item_accounts", map[string]interface{}{
+			"item_id": webhook.ItemID,
+		}); err != nil {
+			log.Printf("Failed to enqueue new accounts for plaid item %s: %v", webhook.ItemID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to process webhook",
+			})
+			return
+		}
+		log.Printf("Enqueued new accounts for plaid item %s", webhook.ItemID)
+	default:
+		log.Printf("Ignoring plaid webhook %s %s for item %s", webhook.WebhookType, webhook.WebhookCode, webhook.ItemID)
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"has_any_monthly_summaries": hasAnyMonthlySummaries,
+		"received": true,
 	})
 }
 
-func getMonthlySummaryOrEmpty(c *gin.Context) {
+// GET /transactions?date[gte]=2025-07-01&amount[gt]=20&category[in]=FOOD_AND_DRINK,TRAVEL&description[contains]=coffee&sort=-date&limit=50&offset=0
+// Filterable fields are listed in database.TransactionFilters. Add include_archived=true to search archived transactions.
+func listTransactions(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	monthYear := GetCurrentMonthYear()
-	if val, exists := c.GetQuery("month_year"); exists {
-		if parsed, err := strconv.Atoi(val); err == nil {
-			monthYear = parsed
-		}
-	}
-	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
+	listQuery, err := ParseListQuery(c)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"monthly_summary":                 nil,
-			"monthly_budget_spend_categories": nil,
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	transactions, err := database.ListTransactions(userIdInt, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
 		})
 		return
 	}
-	monthlyBudgetSpendCategories, totalDailyAllowance, err := database.GetMonthlyBudgetSpendCategories(monthlySummary.ID)
 	if err != nil {
-		log.Printf("Failed to get monthly budget spend categories: %v", err)
+		log.Printf("Failed to list transactions: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get monthly budget spend categories",
+			"error": "Failed to list transactions",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"monthly_summary":                 monthlySummary,
-		"monthly_budget_spend_categories": monthlyBudgetSpendCategories,
-		"total_daily_allowance":           totalDailyAllowance,
+		"transactions": transactions,
+		"limit":        listQuery.Limit,
+		"offset":       listQuery.Offset,
 	})
 }
 
-// func getOrCreateMonthlySummary(c *gin.Context) {
-// 	userIdInt, err := AuthMiddleware(c)
-// 	if err != nil {
-// 		return // AuthMiddleware already sent the response
-// 	}
-// 	monthYear := GetCurrentMonthYear()
-// 	monthlySummary, err := database.GetOrCreateMonthlySummary(userIdInt, monthYear)
-// 	if err != nil {
-// 		c.JSON(http.StatusInternalServerError, gin.H{
-// 			"error": "Failed to get or create monthly summary",
-// 		})
-// 		return
-// 	}
-// 	c.JSON(http.StatusOK, gin.H{
-// 		"monthly_summary": monthlySummary,
-// 	})
-// }
-
-// ** CREATE MONTHLY SUMMARY **
-// INPUT:
-//
-//	{
-//		"month_year": 62025,
-//		"income": 10000,
-//		"starting_balance": 1000,
-//		"saved_amount": 100,
-//		"invested": 100,
-//		"fixed_expenses": 100,
-//		"saving_target_percentage": 10,
-//		"budget": 1000
-//	}
-func upsertMonthlySummary(c *gin.Context) {
+// GET /transactions/search?q=ikea&date[gte]=2025-03-01&date[lt]=2025-04-01&limit=20
+// Full-text search over transaction merchants, descriptions and notes, most relevant first unless sort is given. Words
+// match as prefixes and every word must match. Takes the same filters as GET /transactions.
+func searchTransactions(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	search := strings.TrimSpace(c.Query("q"))
+	if search == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
+			"error": "q is required",
 		})
 		return
 	}
-
-	monthYear := GetCurrentMonthYear()
-	if monthYearFromPayload, exists := payload["month_year"]; exists {
-		monthYear = int(monthYearFromPayload.(float64))
-	}
-
-	income := payload["income"].(float64)
-	startingBalance := 0.0
-	if val, exists := payload["starting_balance"]; exists {
-		startingBalance = val.(float64)
-	}
-	savedAmount := 0.0
-	if val, exists := payload["saved_amount"]; exists {
-		savedAmount = val.(float64)
+	listQuery, err := ParseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
-	invested := 0.0
-	if val, exists := payload["invested"]; exists {
-		invested = val.(float64)
+	results, err := database.SearchTransactions(userIdInt, search, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
 	}
-	fixedExpenses := payload["fixed_expenses"].(float64)
-	savingTargetPercentage := payload["saving_target_percentage"].(float64)
-	budget := payload["budget"].(float64)
-
-	monthlySummary, err := database.UpsertMonthlySummary(userIdInt, monthYear, 0.0, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budget)
 	if err != nil {
+		log.Printf("Failed to search transactions: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to upsert monthly summary",
+			"error": "Failed to search transactions",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"monthly_summary": monthlySummary,
+		"transactions": results,
+		"limit":        listQuery.Limit,
+		"offset":       listQuery.Offset,
 	})
 }
 
-func updateMonthlySummary(c *gin.Context) {
+// GET /accounts?type=depository&current_balance[gte]=100&sort=-current_balance
+// Filterable fields are listed in database.AccountFilters. Add include_hidden=true to list hidden accounts.
+func listAccounts(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	listQuery, err := ParseListQuery(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
+			"error": err.Error(),
 		})
 		return
 	}
-
-	monthYear := GetCurrentMonthYear()
-	if monthYearFromPayload, exists := payload["month_year"]; exists {
-		monthYear = int(monthYearFromPayload.(float64))
-	}
-
-	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get monthly summary",
+	accounts, err := database.ListPlaidAccounts(userIdInt, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
 		})
 		return
 	}
-
-	// Update only the fields that are provided in the payload
-	totalSpent := monthlySummary.TotalSpent
-	if val, exists := payload["total_spent"]; exists {
-		totalSpent = val.(float64)
-	}
-
-	startingBalance := monthlySummary.StartingBalance
-	if val, exists := payload["starting_balance"]; exists {
-		startingBalance = val.(float64)
-	}
-
-	income := monthlySummary.Income
-	if val, exists := payload["income"]; exists {
-		income = val.(float64)
-	}
-
-	savedAmount := monthlySummary.SavedAmount
-	if val, exists := payload["saved_amount"]; exists {
-		savedAmount = val.(float64)
-	}
-
-	invested := monthlySummary.Invested
-	if val, exists := payload["invested"]; exists {
-		invested = val.(float64)
-	}
-
-	fixedExpenses := monthlySummary.FixedExpenses
-	if val, exists := payload["fixed_expenses"]; exists {
-		fixedExpenses = val.(float64)
-	}
-
-	savingTargetPercentage := monthlySummary.SavingTargetPercentage
-	if val, exists := payload["saving_target_percentage"]; exists {
-		savingTargetPercentage = val.(float64)
-	}
-
-	monthlySummary, err = database.UpdateMonthlySummary(userIdInt, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage)
 	if err != nil {
+		log.Printf("Failed to list accounts: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update monthly summary",
+			"error": "Failed to list accounts",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"monthly_summary": monthlySummary,
+		"accounts": accounts,
+		"limit":    listQuery.Limit,
+		"offset":   listQuery.Offset,
 	})
 }
 
-// ** MONTHLY BALANCE **
-
-func hasAnyMonthlyBalances(c *gin.Context) {
-
+// GET /accounts/:id/transactions?date[gte]=2025-07-01&date[lt]=2025-08-01&sort=-date&limit=50&offset=0
+// Lists one account's ledger. Filterable fields are listed in database.TransactionFilters. Add
+// include_archived=true to include archived transactions.
+func listAccountTransactions(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	hasAnyMonthlyBalances, err := database.HasAnyMonthlyBalances(userIdInt)
+	listQuery, err := ParseListQuery(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to check if user has any monthly balances",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"has_any_monthly_balances": hasAnyMonthlyBalances,
-	})
-}
-
-func getMonthlyBalanceOrEmpty(c *gin.Context) {
-	userIdInt, err := AuthMiddleware(c)
-	if err != nil {
-		return // AuthMiddleware already sent the response
-	}
-	monthYear := GetCurrentMonthYear()
-	monthlyBalance, err := database.GetMonthlyBalance(userIdInt, monthYear)
+	accountID := c.Param("id")
+	provider, err := database.GetAccountProvider(userIdInt, accountID)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"monthly_balance": nil,
+		log.Printf("Failed to get account provider: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list account transactions",
 		})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"monthly_balance": monthlyBalance,
-	})
-}
-
-func getOrCreateMonthlyBalance(c *gin.Context) {
-	userIdInt, err := AuthMiddleware(c)
-	if err != nil {
-		return // AuthMiddleware already sent the response
+	if provider == "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Account not found",
+		})
+		return
 	}
-	monthYear := GetCurrentMonthYear()
-	if val, exists := c.GetQuery("monthyear"); exists {
-		if parsed, err := strconv.Atoi(val); err == nil {
-			monthYear = parsed
-		}
+	transactions, err := database.ListAccountTransactions(userIdInt, provider, accountID, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
 	}
-	monthlyBalance, err := database.GetOrCreateMonthlyBalance(userIdInt, monthYear)
 	if err != nil {
-		log.Printf("Failed to get or create monthly balance: %v", err)
+		log.Printf("Failed to list account transactions: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get or create monthly balance",
+			"error": "Failed to list account transactions",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"monthly_balance": monthlyBalance,
+		"account_id":   accountID,
+		"provider":     provider,
+		"transactions": transactions,
+		"limit":        listQuery.Limit,
+		"offset":       listQuery.Offset,
 	})
 }
 
-func updateMonthlyBalance(c *gin.Context) {
+// AccountSettingsRequest is the body of PUT /accounts/:provider/:id/settings. Fields left out are unchanged.
+type AccountSettingsRequest struct {
+	ExcludeFromBudget *bool `json:"exclude_from_budget"`
+	IsInvestment      *bool `json:"is_investment"`
+	IsEmergencyFund   *bool `json:"is_emergency_fund"`
+}
+
+// PUT /accounts/:provider/:id/settings
+// Excluding an account leaves its transactions out of spend, budgets and analytics. Marking one as an investment
+// account counts transfers into it as investment contributions, and marking one as an emergency fund counts its
+// balance towards the emergency fund.
+func updateAccountSettings(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	provider := c.Param("provider")
+	if provider != database.ProviderPlaid && provider != database.ProviderTeller {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
+			"error": "provider must be plaid or teller",
 		})
 		return
 	}
-
-	monthYear := GetCurrentMonthYear()
-	if monthYearFromPayload, exists := payload["monthyear"]; exists {
-		log.Printf("Month year from payload: %v", monthYearFromPayload)
-		monthYear = int(monthYearFromPayload.(float64))
-	}
-
-	monthlyBalance, err := database.GetOrCreateMonthlyBalance(userIdInt, monthYear)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get or create monthly balance",
+	var request AccountSettingsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
 		})
 		return
 	}
-	totalOwing := monthlyBalance.TotalOwing
-	if val, exists := payload["total_owing"]; exists {
-		totalOwing = val.(float64)
-	}
-
-	netCash := monthlyBalance.NetCash
-	if val, exists := payload["net_cash"]; exists {
-		netCash = val.(float64)
-	}
-
-	availableBalance := monthlyBalance.AvailableBalance
-	if val, exists := payload["available_balance"]; exists {
-		availableBalance = val.(float64)
-	}
-
-	currentBalance := monthlyBalance.CurrentBalance
-	if val, exists := payload["current_balance"]; exists {
-		currentBalance = val.(float64)
+	if request.ExcludeFromBudget == nil && request.IsInvestment == nil && request.IsEmergencyFund == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No settings to update",
+		})
+		return
 	}
-
-	monthlyBalance, err = database.UpdateMonthlyBalance(userIdInt, monthYear, totalOwing, netCash, availableBalance, currentBalance)
+	settings, err := database.UpsertAccountSettings(userIdInt, provider, c.Param("id"), database.AccountSettingsUpdate{
+		ExcludeFromBudget: request.ExcludeFromBudget,
+		IsInvestment:      request.IsInvestment,
+		IsEmergencyFund:   request.IsEmergencyFund,
+	})
 	if err != nil {
+		log.Printf("Failed to update account settings: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update monthly balance",
+			"error": "Failed to update account settings",
+		})
+		return
+	}
+	if settings == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Account not found",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"monthly_balance": monthlyBalance,
+		"settings": settings,
 	})
 }
 
-// ** MONTHLY BUDGET SPEND CATEGORY **
+// AccountRequest is the body of PATCH /accounts/:id. Fields left out are unchanged and an empty nickname, color or
+// icon clears it.
+type AccountRequest struct {
+	Nickname *string `json:"nickname" binding:"omitempty,max=100"`
+	Color    *string `json:"color"`
+	Icon     *string `json:"icon" binding:"omitempty,max=50"`
+	Hidden   *bool   `json:"hidden"`
+}
 
-func createMonthlyBudgetSpendCategory(c *gin.Context) {
+// accountColorPattern is the #RRGGBB form account colors are stored in
+var accountColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// PATCH /accounts/:id
+// INPUT:
+//
+//	{
+//	  "nickname": "Joint Chequing",
+//	  "color": "#1E88E5",
+//	  "icon": "piggy-bank",
+//	  "hidden": false
+//	}
+//
+// Hidden accounts are left out of GET /accounts, unless include_hidden=true, and out of net worth.
+func updateAccount(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request AccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Nickname == nil && request.Color == nil && request.Icon == nil && request.Hidden == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No account fields to update",
+		})
+		return
+	}
+	if request.Nickname != nil {
+		nickname := strings.TrimSpace(*request.Nickname)
+		request.Nickname = &nickname
+	}
+	if request.Color != nil && *request.Color != "" && !accountColorPattern.MatchString(*request.Color) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "color must be a hex color like #1E88E5",
+		})
+		return
+	}
+	provider, err := database.GetAccountProvider(userIdInt, c.Param("id"))
+	if err != nil {
+		log.Printf("Failed to get account provider: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update account",
+		})
+		return
+	}
+	if provider == "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Account not found",
+		})
+		return
+	}
+	settings, err := database.UpsertAccountSettings(userIdInt, provider, c.Param("id"), database.AccountSettingsUpdate{
+		Nickname: request.Nickname,
+		Color:    request.Color,
+		Icon:     request.Icon,
+		Hidden:   request.Hidden,
+	})
+	if err != nil {
+		log.Printf("Failed to update account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update account",
+		})
+		return
+	}
+	if settings == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Account not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"settings": settings,
+	})
+}
+
+// ** TRANSFERS **
+
+// GET /transfers?status=pending_review
+// Lists matched transfers between the user's own accounts. status defaults to pending_review, the matches
+// waiting for the user to confirm or reject.
+func listTransferMatches(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	status := c.DefaultQuery("status", database.TransferMatchPendingReview)
+	if status != database.TransferMatchPendingReview && status != database.TransferMatchConfirmed && status != database.TransferMatchRejected {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be pending_review, confirmed or rejected",
+		})
+		return
+	}
+	matches, err := database.ListTransferMatches(userIdInt, status)
+	if err != nil {
+		log.Printf("Failed to list transfer matches: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list transfers",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transfers": matches,
+	})
+}
+
+// TransferReviewRequest is the body of POST /transfers/:id/review
+type TransferReviewRequest struct {
+	Confirm *bool `json:"confirm" binding:"required"`
+}
+
+// POST /transfers/:id/review
+// Confirming a match stops both transactions counting as spend; rejecting it counts them again
+func reviewTransferMatch(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	matchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid transfer id",
+		})
+		return
+	}
+	var request TransferReviewRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	match, err := database.ReviewTransferMatch(userIdInt, matchID, *request.Confirm)
+	if err != nil {
+		log.Printf("Failed to review transfer match: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to review transfer",
+		})
+		return
+	}
+	if match == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Transfer not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transfer": match,
+	})
+}
+
+// ** TRANSACTION FLAGS **
+
+// GET /transactions/flagged?status=flagged
+// Lists the transactions the user flagged as suspected fraud or incorrect. status defaults to flagged, the
+// transactions left out of budgets until they are resolved.
+func listTransactionFlags(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	status := c.DefaultQuery("status", database.TransactionFlagOpen)
+	if status != database.TransactionFlagOpen && status != database.TransactionFlagResolved {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be flagged or resolved",
+		})
+		return
+	}
+	flags, err := database.ListTransactionFlags(userIdInt, status)
+	if err != nil {
+		log.Printf("Failed to list transaction flags: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list flagged transactions",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"flags": flags,
+	})
+}
+
+// TransactionFlagRequest is the body of POST /transactions/:id/flag
+type TransactionFlagRequest struct {
+	Reason string  `json:"reason" binding:"required"`
+	Note   *string `json:"note"`
+}
+
+// POST /transactions/:id/flag
+// INPUT:
+//
+//	{
+//		"reason": "fraud",
+//		"note": "I don't recognise this charge"
+//	}
+//
+// reason is fraud or incorrect. The transaction stops counting towards budgets until the flag is resolved.
+func flagTransaction(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request TransactionFlagRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Reason != database.TransactionFlagFraud && request.Reason != database.TransactionFlagIncorrect {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "reason must be fraud or incorrect",
+		})
+		return
+	}
+	flag, err := database.FlagTransaction(userIdInt, c.Param("id"), request.Reason, request.Note)
+	if err != nil {
+		log.Printf("Failed to flag transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to flag transaction",
+		})
+		return
+	}
+	if flag == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Transaction not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"flag": flag,
+	})
+}
+
+// TransactionFlagResolveRequest is the body of POST /transactions/:id/flag/resolve
+type TransactionFlagResolveRequest struct {
+	Resolution string `json:"resolution" binding:"required"`
+}
+
+// POST /transactions/:id/flag/resolve
+// INPUT:
+//
+//	{
+//		"resolution": "valid"
+//	}
+//
+// Resolving a flag as valid counts the transaction towards budgets again; invalid keeps it left out
+func resolveTransactionFlag(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request TransactionFlagResolveRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Resolution != database.TransactionFlagValid && request.Resolution != database.TransactionFlagInvalid {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "resolution must be valid or invalid",
+		})
+		return
+	}
+	flag, err := database.ResolveTransactionFlag(userIdInt, c.Param("id"), request.Resolution == database.TransactionFlagValid)
+	if err != nil {
+		log.Printf("Failed to resolve transaction flag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to resolve flag",
+		})
+		return
+	}
+	if flag == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Flagged transaction not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"flag": flag,
+	})
+}
+
+// TransactionBusinessRequest is the body of PUT /transactions/:id/business
+type TransactionBusinessRequest struct {
+	IsBusiness *bool `json:"is_business" binding:"required"`
+}
+
+// PUT /transactions/:id/business
+// INPUT:
+//
+//	{
+//		"is_business": true
+//	}
+//
+// Marks a transaction as a business or personal expense. Business expenses are exported by
+// GET /exports/business-expenses.
+func setTransactionBusiness(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request TransactionBusinessRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	updated, err := database.SetTransactionBusiness(userIdInt, c.Param("id"), *request.IsBusiness)
+	if err != nil {
+		log.Printf("Failed to set transaction business: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update transaction",
+		})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Transaction not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transaction_id": c.Param("id"),
+		"is_business":    *request.IsBusiness,
+	})
+}
+
+// TransactionNotesRequest is the body of PUT /transactions/:id/notes
+type TransactionNotesRequest struct {
+	Notes *string `json:"notes" binding:"required,max=1000"`
+}
+
+// PUT /transactions/:id/notes
+// INPUT:
+//
+//	{
+//		"notes": "Desk for the home office"
+//	}
+//
+// Replaces the notes on a transaction, which GET /transactions/search matches along with its merchant and
+// description. Empty notes clear them.
+func setTransactionNotes(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request TransactionNotesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	notes := strings.TrimSpace(*request.Notes)
+	updated, err := database.SetTransactionNotes(userIdInt, c.Param("id"), notes)
+	if err != nil {
+		log.Printf("Failed to set transaction notes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update transaction",
+		})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Transaction not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transaction_id": c.Param("id"),
+		"notes":          notes,
+	})
+}
+
+// GET /exports/business-expenses?year=2025&quarter=3
+// Returns the quarter's business expenses as a CSV, grouped by category with a total for each and a grand total.
+// Defaults to the last full quarter.
+func exportBusinessExpenses(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	now := time.Now()
+	year, quarter := now.Year(), (int(now.Month())-1)/3
+	if quarter == 0 {
+		year, quarter = year-1, 4
+	}
+	if val, exists := c.GetQuery("year"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1900 || parsed > now.Year() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid year",
+			})
+			return
+		}
+		year = parsed
+	}
+	if val, exists := c.GetQuery("quarter"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 || parsed > 4 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "quarter must be between 1 and 4",
+			})
+			return
+		}
+		quarter = parsed
+	}
+	start := time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+	expenses, err := database.GetBusinessExpenses(userIdInt, start, start.AddDate(0, 3, 0))
+	if err != nil {
+		log.Printf("Failed to get business expenses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get business expenses",
+		})
+		return
+	}
+	export, err := reports.RenderBusinessExpensesCSV(expenses)
+	if err != nil {
+		log.Printf("Failed to render business expenses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to export business expenses",
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"business-expenses-%d-Q%d.csv\"", year, quarter))
+	c.Data(http.StatusOK, "text/csv", export)
+}
+
+// ** INVESTMENT CONTRIBUTIONS **
+
+// GET /investment-contributions?monthyear=72025
+// Lists the transfers into brokerage accounts counted towards the month's invested amount
+func listInvestmentContributions(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	contributions, err := database.ListInvestmentContributions(userIdInt, monthYear)
+	if err != nil {
+		log.Printf("Failed to list investment contributions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list investment contributions",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"investment_contributions": contributions,
+	})
+}
+
+// InvestmentContributionReviewRequest is the body of POST /investment-contributions/:id/review
+type InvestmentContributionReviewRequest struct {
+	Confirm *bool `json:"confirm" binding:"required"`
+	// Amount corrects the detected amount of a confirmed contribution
+	Amount *money.Money `json:"amount"`
+}
+
+// POST /investment-contributions/:id/review
+// INPUT:
+//
+//	{
+//		"confirm": true,
+//		"amount": 250.00
+//	}
+//
+// Rejecting a contribution takes it back out of the month's invested amount; correcting its amount adjusts it
+func reviewInvestmentContribution(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	contributionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid investment contribution id",
+		})
+		return
+	}
+	var request InvestmentContributionReviewRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Amount != nil && !request.Amount.IsPositive() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "amount must be positive",
+		})
+		return
+	}
+	status := database.InvestmentContributionRejected
+	if *request.Confirm {
+		status = database.InvestmentContributionConfirmed
+	}
+	contribution, err := database.ReviewInvestmentContribution(userIdInt, contributionID, status, request.Amount)
+	if err != nil {
+		log.Printf("Failed to review investment contribution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to review investment contribution",
+		})
+		return
+	}
+	if contribution == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Investment contribution not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"investment_contribution": contribution,
+	})
+}
+
+// ** MONTHLY SUMMARY **
+
+func hasAnyMonthlySummaries(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	hasAnyMonthlySummaries, err := database.HasAnyMonthlySummaries(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check if user has any monthly summaries",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"has_any_monthly_summaries": hasAnyMonthlySummaries,
+	})
+}
+
+// GET /monthly-summary?month_year=72025&over_budget=true&sort=-progress
+// The month's budget categories can be filtered, sorted and paged with the fields in
+// database.BudgetCategoryFilters; total_daily_allowance always covers every category. emergency_fund measures the
+// goals and accounts designated as the emergency fund in months of expenses before the month.
+func getMonthlySummaryOrEmpty(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("month_year"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	listQuery, err := ParseListQuery(c, "month_year")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"monthly_summary":                 nil,
+			"monthly_budget_spend_categories": nil,
+		})
+		return
+	}
+	monthlyBudgetSpendCategories, totalDailyAllowance, err := database.ListMonthlyBudgetSpendCategories(userIdInt, monthlySummary.ID, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get monthly budget spend categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get monthly budget spend categories",
+		})
+		return
+	}
+	incomeBaseline, err := database.GetIncomeBaseline(userIdInt, *monthlySummary)
+	if err != nil {
+		log.Printf("Failed to get income baseline: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get income baseline",
+		})
+		return
+	}
+	emergencyFund, err := database.GetEmergencyFund(userIdInt, monthYear)
+	if err != nil {
+		log.Printf("Failed to get emergency fund: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get emergency fund",
+		})
+		return
+	}
+	periodStart, periodEnd := database.BudgetPeriodBounds(*monthlySummary, time.Now())
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_summary":                 monthlySummary,
+		"income_baseline":                 incomeBaseline,
+		"emergency_fund":                  emergencyFund,
+		"monthly_budget_spend_categories": monthlyBudgetSpendCategories,
+		"total_daily_allowance":           totalDailyAllowance,
+		"period_start":                    periodStart.Format("2006-01-02"),
+		"period_end":                      periodEnd.AddDate(0, 0, -1).Format("2006-01-02"),
+		"days_remaining_in_period":        database.DaysInPeriod(periodStart, periodEnd) - database.DaysIntoPeriod(periodStart, periodEnd, time.Now()),
+	})
+}
+
+// func getOrCreateMonthlySummary(c *gin.Context) {
+// 	userIdInt, err := AuthMiddleware(c)
+// 	if err != nil {
+// 		return // AuthMiddleware already sent the response
+// 	}
+// 	monthYear := GetCurrentMonthYear()
+// 	monthlySummary, err := database.GetOrCreateMonthlySummary(userIdInt, monthYear)
+// 	if err != nil {
+// 		c.JSON(http.StatusInternalServerError, gin.H{
+// 			"error": "Failed to get or create monthly summary",
+// 		})
+// 		return
+// 	}
+// 	c.JSON(http.StatusOK, gin.H{
+// 		"monthly_summary": monthlySummary,
+// 	})
+// }
+
+// parseBudgetPeriod reads the optional "budget_period" and "period_anchor_date" (YYYY-MM-DD) fields,
+// falling back to the given defaults. It sends a 400 response and returns false when either is invalid.
+func parseBudgetPeriod(c *gin.Context, payload map[string]interface{}, defaultPeriod string, defaultAnchor *time.Time) (string, *time.Time, bool) {
+	budgetPeriod := defaultPeriod
+	if val, exists := payload["budget_period"]; exists {
+		period, _ := val.(string)
+		if !database.IsValidBudgetPeriod(period) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "budget_period must be one of weekly, biweekly, monthly",
+			})
+			return "", nil, false
+		}
+		budgetPeriod = period
+	}
+	periodAnchorDate := defaultAnchor
+	if val, exists := payload["period_anchor_date"]; exists {
+		if val == nil {
+			periodAnchorDate = nil
+		} else {
+			anchorString, _ := val.(string)
+			anchor, err := time.Parse("2006-01-02", anchorString)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "period_anchor_date must be formatted as YYYY-MM-DD",
+				})
+				return "", nil, false
+			}
+			periodAnchorDate = &anchor
+		}
+	}
+	return budgetPeriod, periodAnchorDate, true
+}
+
+// parseBudgetStartDate reads the optional "budget_start_date" field (YYYY-MM-DD, or null to clear it), the day a
+// budget started partway through the month. set is false when the payload leaves it out. It sends a 400 response
+// and returns ok false when the date is invalid or outside the summary's month.
+func parseBudgetStartDate(c *gin.Context, payload map[string]interface{}, monthYear int) (budgetStartDate *time.Time, set bool, ok bool) {
+	val, exists := payload["budget_start_date"]
+	if !exists {
+		return nil, false, true
+	}
+	if val == nil {
+		return nil, true, true
+	}
+	startString, _ := val.(string)
+	start, err := time.Parse("2006-01-02", startString)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "budget_start_date must be formatted as YYYY-MM-DD",
+		})
+		return nil, false, false
+	}
+	monthStart := database.MonthYearStart(monthYear)
+	if start.Before(monthStart) || !start.Before(monthStart.AddDate(0, 1, 0)) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "budget_start_date must fall within the summary's month",
+		})
+		return nil, false, false
+	}
+	return &start, true, true
+}
+
+// ** CREATE MONTHLY SUMMARY **
+// INPUT:
+//
+//	{
+//		"month_year": 62025,
+//		"income": 10000,
+//		"starting_balance": 1000,
+//		"saved_amount": 100,
+//		"invested": 100,
+//		"fixed_expenses": 100,
+//		"saving_target_percentage": 10,
+//		"budget": 1000,
+//		"budget_period": "weekly",
+//		"period_anchor_date": "2025-07-04",
+//		"budget_start_date": "2025-06-15"
+//	}
+func upsertMonthlySummary(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	monthYear := GetCurrentMonthYear()
+	if monthYearFromPayload, exists := payload["month_year"]; exists {
+		monthYear = int(monthYearFromPayload.(float64))
+	}
+
+	income := money.FromFloat(payload["income"].(float64))
+	var startingBalance money.Money
+	if val, exists := payload["starting_balance"]; exists {
+		startingBalance = money.FromFloat(val.(float64))
+	}
+	var savedAmount money.Money
+	if val, exists := payload["saved_amount"]; exists {
+		savedAmount = money.FromFloat(val.(float64))
+	}
+	var invested money.Money
+	if val, exists := payload["invested"]; exists {
+		invested = money.FromFloat(val.(float64))
+	}
+	fixedExpenses := money.FromFloat(payload["fixed_expenses"].(float64))
+	savingTargetPercentage := payload["saving_target_percentage"].(float64)
+	budget := money.FromFloat(payload["budget"].(float64))
+
+	// An existing summary keeps its budget period unless the payload changes it
+	budgetPeriod := database.BudgetPeriodMonthly
+	var periodAnchorDate *time.Time
+	if existing, _ := database.GetMonthlySummary(userIdInt, monthYear); existing != nil {
+		budgetPeriod, periodAnchorDate = existing.BudgetPeriod, existing.PeriodAnchorDate
+	}
+	budgetPeriod, periodAnchorDate, ok := parseBudgetPeriod(c, payload, budgetPeriod, periodAnchorDate)
+	if !ok {
+		return
+	}
+	budgetStartDate, setBudgetStartDate, ok := parseBudgetStartDate(c, payload, monthYear)
+	if !ok {
+		return
+	}
+
+	monthlySummary, err := database.UpsertMonthlySummary(userIdInt, monthYear, money.Money{}, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budget, budgetPeriod, periodAnchorDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to upsert monthly summary",
+		})
+		return
+	}
+	if setBudgetStartDate {
+		if err := database.UpdateMonthlySummaryBudgetStartDate(userIdInt, monthYear, budgetStartDate); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update budget start date",
+			})
+			return
+		}
+		monthlySummary.BudgetStartDate = budgetStartDate
+	}
+	if err := database.CompleteOnboardingStep(userIdInt, database.OnboardingBudgetCreated); err != nil {
+		log.Printf("Failed to record onboarding step: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_summary": monthlySummary,
+	})
+}
+
+func updateMonthlySummary(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	monthYear := GetCurrentMonthYear()
+	if monthYearFromPayload, exists := payload["month_year"]; exists {
+		monthYear = int(monthYearFromPayload.(float64))
+	}
+
+	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get monthly summary",
+		})
+		return
+	}
+
+	// Update only the fields that are provided in the payload
+	totalSpent := monthlySummary.TotalSpent
+	if val, exists := payload["total_spent"]; exists {
+		totalSpent = money.FromFloat(val.(float64))
+	}
+
+	startingBalance := monthlySummary.StartingBalance
+	if val, exists := payload["starting_balance"]; exists {
+		startingBalance = money.FromFloat(val.(float64))
+	}
+
+	income := monthlySummary.Income
+	if val, exists := payload["income"]; exists {
+		income = money.FromFloat(val.(float64))
+	}
+
+	savedAmount := monthlySummary.SavedAmount
+	if val, exists := payload["saved_amount"]; exists {
+		savedAmount = money.FromFloat(val.(float64))
+	}
+
+	invested := monthlySummary.Invested
+	if val, exists := payload["invested"]; exists {
+		invested = money.FromFloat(val.(float64))
+	}
+
+	fixedExpenses := monthlySummary.FixedExpenses
+	if val, exists := payload["fixed_expenses"]; exists {
+		fixedExpenses = money.FromFloat(val.(float64))
+	}
+
+	savingTargetPercentage := monthlySummary.SavingTargetPercentage
+	if val, exists := payload["saving_target_percentage"]; exists {
+		savingTargetPercentage = val.(float64)
+	}
+
+	budgetPeriod, periodAnchorDate, ok := parseBudgetPeriod(c, payload, monthlySummary.BudgetPeriod, monthlySummary.PeriodAnchorDate)
+	if !ok {
+		return
+	}
+	budgetStartDate, setBudgetStartDate, ok := parseBudgetStartDate(c, payload, monthYear)
+	if !ok {
+		return
+	}
+
+	monthlySummary, err = database.UpdateMonthlySummary(userIdInt, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budgetPeriod, periodAnchorDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update monthly summary",
+		})
+		return
+	}
+	if setBudgetStartDate {
+		if err := database.UpdateMonthlySummaryBudgetStartDate(userIdInt, monthYear, budgetStartDate); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update budget start date",
+			})
+			return
+		}
+		monthlySummary.BudgetStartDate = budgetStartDate
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_summary": monthlySummary,
+	})
+}
+
+// ** MONTHLY BALANCE **
+
+func hasAnyMonthlyBalances(c *gin.Context) {
+
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	hasAnyMonthlyBalances, err := database.HasAnyMonthlyBalances(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check if user has any monthly balances",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"has_any_monthly_balances": hasAnyMonthlyBalances,
+	})
+}
+
+func getMonthlyBalanceOrEmpty(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	monthlyBalance, err := database.GetMonthlyBalance(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"monthly_balance": nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_balance": monthlyBalance,
+	})
+}
+
+func getOrCreateMonthlyBalance(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	monthlyBalance, err := database.GetOrCreateMonthlyBalance(userIdInt, monthYear)
+	if err != nil {
+		log.Printf("Failed to get or create monthly balance: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get or create monthly balance",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_balance": monthlyBalance,
+	})
+}
+
+func updateMonthlyBalance(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	monthYear := GetCurrentMonthYear()
+	if monthYearFromPayload, exists := payload["monthyear"]; exists {
+		log.Printf("Month year from payload: %v", monthYearFromPayload)
+		monthYear = int(monthYearFromPayload.(float64))
+	}
+
+	monthlyBalance, err := database.GetOrCreateMonthlyBalance(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get or create monthly balance",
+		})
+		return
+	}
+	// Fields set here override the computed balances; setting one to null clears its override
+	values := map[string]*money.Money{
+		"total_owing":       &monthlyBalance.TotalOwing,
+		"net_cash":          &monthlyBalance.NetCash,
+		"available_balance": &monthlyBalance.AvailableBalance,
+		"current_balance":   &monthlyBalance.CurrentBalance,
+	}
+	manualFields := monthlyBalance.ManualFields
+	clearedOverride := false
+	for _, field := range database.MonthlyBalanceFields {
+		val, exists := payload[field]
+		if !exists {
+			continue
+		}
+		if val == nil {
+			manualFields = slices.DeleteFunc(manualFields, func(manual string) bool { return manual == field })
+			clearedOverride = true
+			continue
+		}
+		*values[field] = money.FromFloat(val.(float64))
+		if !slices.Contains(manualFields, field) {
+			manualFields = append(manualFields, field)
+		}
+	}
+
+	monthlyBalance, err = database.UpdateMonthlyBalance(userIdInt, monthYear, monthlyBalance.TotalOwing, monthlyBalance.NetCash, monthlyBalance.AvailableBalance, monthlyBalance.CurrentBalance, manualFields)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update monthly balance",
+		})
+		return
+	}
+	// Linked account balances only describe the current month, so only it is recomputed straight away
+	if clearedOverride && monthYear == GetCurrentMonthYear() {
+		computed, err := database.ComputeMonthlyBalance(userIdInt, monthYear)
+		if err != nil {
+			log.Printf("Failed to compute monthly balance: %v", err)
+		} else {
+			monthlyBalance = computed
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_balance": monthlyBalance,
+	})
+}
+
+// ** MONTHLY BUDGET SPEND CATEGORY **
+
+// POST /monthly-budget-spend-category
+// Strictness is soft, the default, or hard for a category that never borrows from or lends to the others.
+// INPUT:
+//
+//	{
+//		"category": "FOOD_AND_DRINK",
+//		"budget": 400,
+//		"strictness": "hard",
+//		"month_year": 72025
+//	}
+func createMonthlyBudgetSpendCategory(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	monthYear := GetCurrentMonthYear()
+	if monthYearFromPayload, exists := payload["month_year"]; exists {
+		monthYear = int(monthYearFromPayload.(float64))
+	}
+	strictness := budget.DefaultStrictness
+	if value, exists := payload["strictness"]; exists {
+		strictness, _ = value.(string)
+		if !budget.IsValidStrictness(strictness) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "strictness must be soft or hard",
+			})
+			return
+		}
+	}
+
+	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get or create monthly summary",
+		})
+		return
+	}
+	category := payload["category"].(string)
+	budget := money.FromFloat(payload["budget"].(float64))
+
+	// Check if a monthly budget spend category for this user, category, and monthYear already exists
+	existingCategory, err := database.GetMonthlyBudgetSpendCategory(userIdInt, monthlySummary.ID, monthYear, category)
+	if existingCategory != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Monthly budget spend category for this category already exists for this month",
+		})
+		return
+	}
+
+	monthlyBudgetSpendCategory, err := database.CreateMonthlyBudgetSpendCategory(userIdInt, monthlySummary.ID, monthYear, category, budget, strictness)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create monthly budget spend category",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_budget_spend_category": monthlyBudgetSpendCategory,
+	})
+}
+
+// MonthlyBudgetSpendCategoryUpdateRequest changes a budget category's budget or strictness. Fields left out are
+// unchanged.
+type MonthlyBudgetSpendCategoryUpdateRequest struct {
+	Budget     *float64 `json:"budget" binding:"omitempty,min=0"`
+	Strictness *string  `json:"strictness" binding:"omitempty,oneof=soft hard"`
+}
+
+// PATCH /monthly-budget-spend-category/:id
+// Allowances pick up the change the next time the daily balance is processed
+// INPUT:
+//
+//	{
+//		"budget": 450,
+//		"strictness": "soft"
+//	}
+func updateMonthlyBudgetSpendCategory(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request MonthlyBudgetSpendCategoryUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Budget == nil && request.Strictness == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Nothing to update",
+		})
+		return
+	}
+	update := database.MonthlyBudgetSpendCategoryUpdate{Strictness: request.Strictness}
+	if request.Budget != nil {
+		amount := money.FromFloat(*request.Budget)
+		update.Budget = &amount
+	}
+	category, err := database.UpdateMonthlyBudgetSpendCategorySettings(userIdInt, c.Param("id"), update)
+	if err != nil {
+		log.Printf("Failed to update monthly budget spend category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update monthly budget spend category",
+		})
+		return
+	}
+	if category == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Budget category not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthly_budget_spend_category": category,
+	})
+}
+
+// POST /budget/import?month_year=72025
+// Sets the month's budget from a CSV, uploaded as the file field of a multipart form or sent as the body. The
+// header row names a category column, a budget (or amount) column and optionally a strictness column:
+//
+//	category,budget,strictness
+//	Groceries,450,hard
+//	Dining Out,"$1,200.00",
+//
+// Categories the month already has are updated, matched case insensitively, and the rest are created. Nothing is
+// saved unless every row is valid; the rows that aren't are listed in errors. Allowances pick up the new budgets
+// the next time the daily balance is processed.
+func importBudget(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("month_year"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid month_year",
+			})
+			return
+		}
+		monthYear = parsed
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBudgetImportBytes)
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Upload the CSV as the file field",
+				"details": err.Error(),
+			})
+			return
+		}
+		upload, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Failed to read the uploaded file",
+			})
+			return
+		}
+		defer upload.Close()
+		body = upload
+	}
+	imports, rowErrors, err := parseBudgetCSV(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if len(rowErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Some rows are invalid, nothing was imported",
+			"errors": rowErrors,
+		})
+		return
+	}
+	if len(imports) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "The CSV has no categories",
+		})
+		return
+	}
+
+	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Monthly summary not found, create it before importing a budget",
+		})
+		return
+	}
+	created, updated, err := database.ImportBudgetCategories(userIdInt, monthlySummary.ID, monthYear, imports)
+	if err != nil {
+		log.Printf("Failed to import budget: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to import budget",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"month_year": monthYear,
+		"created":    created,
+		"updated":    updated,
+	})
+}
+
+// GET /monthly-budget-spend-category/simulate?monthyear=72025&strategy=pooled
+// Works out today's allowances under a strategy without saving them, defaulting to the user's chosen strategy
+func simulateBudgetAllowances(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	strategyName := c.Query("strategy")
+	if strategyName == "" {
+		preferences, err := database.GetUserPreferences(userIdInt)
+		if err != nil {
+			log.Printf("Failed to get preferences: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get preferences",
+			})
+			return
+		}
+		strategyName = GetDefaultBudgetStrategy()
+		if preferences.AllowanceStrategy != nil {
+			strategyName = *preferences.AllowanceStrategy
+		}
+	}
+	strategy, err := budget.StrategyNamed(strategyName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid allowance strategy",
+		})
+		return
+	}
+
+	if _, err := database.GetMonthlySummary(userIdInt, monthYear); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Monthly summary not found",
+		})
+		return
+	}
+	allowances, err := database.CalculateBudgetAllowances(userIdInt, monthYear, strategy, time.Now())
+	if err != nil {
+		log.Printf("Failed to simulate allowances: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to simulate allowances",
+		})
+		return
+	}
+	categories := make([]gin.H, 0, len(allowances.Categories))
+	for i, category := range allowances.Categories {
+		categories = append(categories, gin.H{
+			"category":        category.Category,
+			"budget":          category.Budget,
+			"total_spent":     category.TotalSpent,
+			"left_to_spend":   allowances.Allowances[i].LeftToSpend,
+			"daily_allowance": category.DailyAllowance,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"strategy":         strategyName,
+		"window_start":     allowances.Window.Start,
+		"window_end":       allowances.Window.PeriodEnd,
+		"days_into_window": allowances.Window.DaysIntoWindow,
+		"days_in_window":   allowances.Window.DaysInWindow,
+		"total_spent":      allowances.TotalSpent,
+		"reserved":         allowances.Reserved,
+		"categories":       categories,
+	})
+}
+
+// GET /budget/pacing?monthyear=72025
+// Compares each budget category's spend with how far through the budget window the user is
+func getBudgetPacing(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	if _, err := database.GetMonthlySummary(userIdInt, monthYear); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Monthly summary not found",
+		})
+		return
+	}
+	pacing, window, err := database.GetBudgetPacing(userIdInt, monthYear, time.Now())
+	if err != nil {
+		log.Printf("Failed to get budget pacing: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get budget pacing",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"window_start":     window.Start,
+		"window_end":       window.PeriodEnd,
+		"days_into_window": window.DaysIntoWindow,
+		"days_in_window":   window.DaysInWindow,
+		"categories":       pacing,
+	})
+}
+
+// GET /budget/suggestions?monthyear=72025&trim=0.05
+// Proposes a budget per category for the month from the average spend of the three months before it, to pre-fill a
+// new monthly summary. trim is the share of each category's highest spending days capped as outliers, from 0 to 0.5.
+func getBudgetSuggestions(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	trim := database.DefaultBudgetSuggestionTrim
+	if val, exists := c.GetQuery("trim"); exists {
+		parsed, err := strconv.ParseFloat(val, 64)
+		if err != nil || parsed < 0 || parsed > database.MaxBudgetSuggestionTrim {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("trim must be between 0 and %.1f", database.MaxBudgetSuggestionTrim),
+			})
+			return
+		}
+		trim = parsed
+	}
+	suggestions, historyMonths, err := database.GetBudgetSuggestions(userIdInt, monthYear, trim)
+	if err != nil {
+		log.Printf("Failed to get budget suggestions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get budget suggestions",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthyear":      monthYear,
+		"months":         database.BudgetSuggestionMonths,
+		"history_months": historyMonths,
+		"trim":           trim,
+		"suggestions":    suggestions,
+	})
+}
+
+// GET /budget/categories/:id?limit=50&offset=0
+// Returns a budget category with its budget, spend and allowance history, and a page of its transactions in the
+// current budget window, newest first
+func getBudgetCategoryDetail(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	listQuery, err := ParseListQuery(c)
+	if err == nil && len(listQuery.Filters) > 0 {
+		err = errors.New("only limit and offset are supported")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	detail, err := database.GetBudgetCategoryDetail(userIdInt, c.Param("id"), listQuery.Limit, listQuery.Offset, time.Now())
+	if err != nil {
+		log.Printf("Failed to get budget category detail: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get budget category",
+		})
+		return
+	}
+	if detail == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Budget category not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"category":          detail.Category,
+		"window_start":      detail.Window.Start,
+		"window_end":        detail.Window.PeriodEnd,
+		"days_into_window":  detail.Window.DaysIntoWindow,
+		"days_in_window":    detail.Window.DaysInWindow,
+		"window_budget":     detail.WindowBudget,
+		"allowance_history": detail.AllowanceHistory,
+		"transactions":      detail.Transactions,
+		"total":             detail.TransactionCount,
+		"limit":             listQuery.Limit,
+		"offset":            listQuery.Offset,
+	})
+}
+
+// ** SAVING GOALS **
+
+// GET /saving-goals?status=active&sort=-progress&limit=50&offset=0
+// Newest first by default, leaving out archived and redeemed goals unless status is given. status is active,
+// redeemed or archived; other filterable fields are listed in database.SavingsGoalFilters.
+func getSavingGoals(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	listQuery, err := ParseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	savingsGoals, err := database.ListSavingsGoals(userIdInt, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list savings goals: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get savings goals",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"savings_goals": savingsGoals,
+		"limit":         listQuery.Limit,
+		"offset":        listQuery.Offset,
+	})
+}
+
+// SavingGoalArchiveRequest is the body of POST /saving-goal/:id/archive
+type SavingGoalArchiveRequest struct {
+	Archived *bool `json:"archived" binding:"required"`
+}
+
+// POST /saving-goal/:id/archive
+// INPUT:
+//
+//	{
+//		"archived": true
+//	}
+//
+// Archived goals keep their contributions but are only listed when asked for with status=archived
+func archiveSavingGoal(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	goalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid savings goal id",
+		})
+		return
+	}
+	var request SavingGoalArchiveRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	savingsGoal, err := database.SetSavingsGoalArchived(userIdInt, goalID, *request.Archived)
+	if err != nil {
+		log.Printf("Failed to archive savings goal: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to archive savings goal",
+		})
+		return
+	}
+	if savingsGoal == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Savings goal not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"savings_goal": savingsGoal,
+	})
+}
+
+// SavingGoalEmergencyFundRequest is the body of POST /saving-goal/:id/emergency-fund
+type SavingGoalEmergencyFundRequest struct {
+	EmergencyFund *bool `json:"emergency_fund" binding:"required"`
+}
+
+// POST /saving-goal/:id/emergency-fund
+// INPUT:
+//
+//	{
+//		"emergency_fund": true
+//	}
+//
+// What emergency fund goals have saved counts towards the emergency fund shown on the monthly summary
+func setSavingGoalEmergencyFund(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	goalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid savings goal id",
+		})
+		return
+	}
+	var request SavingGoalEmergencyFundRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	savingsGoal, err := database.SetSavingsGoalEmergencyFund(userIdInt, goalID, *request.EmergencyFund)
+	if err != nil {
+		log.Printf("Failed to update savings goal emergency fund: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update savings goal",
+		})
+		return
+	}
+	if savingsGoal == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Savings goal not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"savings_goal": savingsGoal,
+	})
+}
+
+// SavingGoalRedeemRequest is the body of POST /saving-goal/:id/redeem
+type SavingGoalRedeemRequest struct {
+	RecordExpense bool `json:"record_expense"`
+}
+
+// POST /saving-goal/:id/redeem
+// INPUT:
+//
+//	{
+//		"record_expense": true
+//	}
+//
+// Marks the goal redeemed and archives it. What it saved comes off this month's saved amount, and with
+// record_expense it is also recorded as a planned expense that occurred today. The body is optional.
+func redeemSavingGoal(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	goalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid savings goal id",
+		})
+		return
+	}
+	var request SavingGoalRedeemRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	redemption, err := database.RedeemSavingsGoal(userIdInt, goalID, request.RecordExpense)
+	if errors.Is(err, database.ErrSavingsGoalRedeemed) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to redeem savings goal: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to redeem savings goal",
+		})
+		return
+	}
+	if redemption == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Savings goal not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, redemption)
+}
+
+func createSavingGoal(c *gin.Context) {
+
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	name := payload["name"].(string)
+	totalAmount := payload["total"].(float64)
+	currentSaved := 0.0
+
+	var targetDate *time.Time
+	if val, exists := payload["target_date"]; exists && val != nil {
+		targetDateString, _ := val.(string)
+		parsed, err := time.Parse("2006-01-02", targetDateString)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "target_date must be formatted as YYYY-MM-DD",
+			})
+			return
+		}
+		targetDate = &parsed
+	}
+
+	savingsGoal, err := database.CreateSavingsGoal(userIdInt, name, totalAmount, currentSaved, targetDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create savings goal",
+		})
+		return
+	}
+	database.ProjectSavingsGoal(savingsGoal, 0, time.Now())
+	if err := database.UpdateSavingsGoalProjection(*savingsGoal); err != nil {
+		log.Printf("Failed to update savings goal projection: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"savings_goal": savingsGoal,
+	})
+}
+
+// POST /saving-goal/:id/contribution
+// INPUT:
+//
+//	{
+//		"amount": 50,
+//		"contributed_at": "2025-07-04"
+//	}
+func addSavingGoalContribution(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	goalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid savings goal id",
+		})
+		return
+	}
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	amount, ok := payload["amount"].(float64)
+	if !ok || amount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "amount is required",
+		})
+		return
+	}
+	contributedAt := time.Now()
+	if val, exists := payload["contributed_at"]; exists {
+		contributedAtString, _ := val.(string)
+		parsed, err := time.Parse("2006-01-02", contributedAtString)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "contributed_at must be formatted as YYYY-MM-DD",
+			})
+			return
+		}
+		contributedAt = parsed
+	}
+
+	contribution, err := database.AddSavingsGoalContribution(userIdInt, goalID, money.FromFloat(amount), contributedAt)
+	if err != nil {
+		log.Printf("Failed to add savings goal contribution: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Savings goal not found",
+		})
+		return
+	}
+
+	// Refresh the goal's pace and projections in the background
+	if err := EnqueueWorkerJob(c.Request.Context(), "recalculate_saving_goals", map[string]interface{}{"user_id": userIdInt}); err != nil {
+		log.Printf("Failed to enqueue savings goal recalculation: %v", err)
+	}
+
+	savingsGoal, err := database.GetSavingsGoal(userIdInt, goalID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get savings goal",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"contribution": contribution,
+		"savings_goal": savingsGoal,
+	})
+}
+
+// ** PLANNED EXPENSES **
+
+// PlannedExpenseRequest plans a one-off expense. The budget sets aside an even share of the amount each month
+// until target_monthyear; merchant, when given, must appear in the matching transaction.
+type PlannedExpenseRequest struct {
+	Description     string  `json:"description" binding:"required,max=255"`
+	Amount          float64 `json:"amount" binding:"required,gt=0"`
+	TargetMonthYear int     `json:"target_monthyear" binding:"required"`
+	Merchant        *string `json:"merchant" binding:"omitempty,max=255"`
+}
+
+// PlannedExpenseUpdateRequest changes a planned expense. Fields left out are unchanged, an empty merchant clears it,
+// and status marks the expense occurred or planned again by hand.
+type PlannedExpenseUpdateRequest struct {
+	Description     *string  `json:"description" binding:"omitempty,min=1,max=255"`
+	Amount          *float64 `json:"amount" binding:"omitempty,gt=0"`
+	TargetMonthYear *int     `json:"target_monthyear"`
+	Merchant        *string  `json:"merchant" binding:"omitempty,max=255"`
+	Status          *string  `json:"status" binding:"omitempty,oneof=planned occurred"`
+}
+
+// validTargetMonthYear reports whether monthYear is a MMYYYY month
+func validTargetMonthYear(monthYear int) bool {
+	month, year := monthYear/10000, monthYear%10000
+	return month >= 1 && month <= 12 && year >= 2000
+}
+
+// GET /planned-expenses?status=planned
+func getPlannedExpenses(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	status := c.Query("status")
+	if status != "" && status != database.PlannedExpensePlanned && status != database.PlannedExpenseOccurred {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be planned or occurred",
+		})
+		return
+	}
+	expenses, err := database.GetPlannedExpenses(userIdInt, status)
+	if err != nil {
+		log.Printf("Failed to get planned expenses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get planned expenses",
+		})
+		return
+	}
+	monthYear := GetCurrentMonthYear()
+	reserved := money.Money{}
+	for _, expense := range expenses {
+		reserved = reserved.Add(expense.MonthlyReservation(monthYear))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"planned_expenses":    expenses,
+		"reserved_this_month": reserved,
+	})
+}
+
+// POST /planned-expenses
+// INPUT:
+//
+//	{
+//		"description": "Car insurance",
+//		"amount": 1200,
+//		"target_monthyear": 32026,
+//		"merchant": "Geico"
+//	}
+func createPlannedExpense(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request PlannedExpenseRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !validTargetMonthYear(request.TargetMonthYear) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "target_monthyear must be a month formatted as MMYYYY",
+		})
+		return
+	}
+	if database.MonthYearStart(request.TargetMonthYear).Before(database.MonthYearStart(GetCurrentMonthYear())) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "target_monthyear can't be in the past",
+		})
+		return
+	}
+	expense, err := database.CreatePlannedExpense(userIdInt, request.Description, money.FromFloat(request.Amount), request.TargetMonthYear, request.Merchant)
+	if err != nil {
+		log.Printf("Failed to create planned expense: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create planned expense",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"planned_expense": expense,
+	})
+}
+
+// PATCH /planned-expenses/:id
+// INPUT:
+//
+//	{
+//		"amount": 1350,
+//		"status": "occurred"
+//	}
+func updatePlannedExpense(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	expenseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid planned expense id",
+		})
+		return
+	}
+	var request PlannedExpenseUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Description == nil && request.Amount == nil && request.TargetMonthYear == nil && request.Merchant == nil && request.Status == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Nothing to update",
+		})
+		return
+	}
+	if request.TargetMonthYear != nil && !validTargetMonthYear(*request.TargetMonthYear) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "target_monthyear must be a month formatted as MMYYYY",
+		})
+		return
+	}
+	update := database.PlannedExpenseUpdate{
+		Description:     request.Description,
+		TargetMonthYear: request.TargetMonthYear,
+		Merchant:        request.Merchant,
+		Status:          request.Status,
+	}
+	if request.Amount != nil {
+		amount := money.FromFloat(*request.Amount)
+		update.Amount = &amount
+	}
+	expense, err := database.UpdatePlannedExpense(userIdInt, expenseID, update)
+	if err != nil {
+		log.Printf("Failed to update planned expense: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update planned expense",
+		})
+		return
+	}
+	if expense == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Planned expense not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"planned_expense": expense,
+	})
+}
+
+// DELETE /planned-expenses/:id
+func deletePlannedExpense(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	expenseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid planned expense id",
+		})
+		return
+	}
+	if err := database.DeletePlannedExpense(userIdInt, expenseID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Planned expense not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Planned expense deleted",
+	})
+}
+
+// ** TRIPS **
+
+// TripRequest creates a trip. Dates are YYYY-MM-DD and both are part of the trip. cities and countries, when
+// given, only count purchases made there; countries are two letter codes such as FR.
+type TripRequest struct {
+	Name      string   `json:"name" binding:"required,max=255"`
+	StartDate string   `json:"start_date" binding:"required"`
+	EndDate   string   `json:"end_date" binding:"required"`
+	Cities    []string `json:"cities" binding:"max=20,dive,min=1,max=255"`
+	Countries []string `json:"countries" binding:"max=20,dive,len=2"`
+	Budget    *float64 `json:"budget" binding:"omitempty,gt=0"`
+}
+
+// TripUpdateRequest changes a trip. Fields left out are unchanged, empty cities or countries stop filtering on
+// them and a zero budget clears it.
+type TripUpdateRequest struct {
+	Name      *string   `json:"name" binding:"omitempty,min=1,max=255"`
+	StartDate *string   `json:"start_date"`
+	EndDate   *string   `json:"end_date"`
+	Cities    *[]string `json:"cities" binding:"omitempty,max=20,dive,min=1,max=255"`
+	Countries *[]string `json:"countries" binding:"omitempty,max=20,dive,len=2"`
+	Budget    *float64  `json:"budget" binding:"omitempty,min=0"`
+}
+
+// tripLocations trims cities and upper cases countries, the way providers report them
+func tripLocations(cities []string, countries []string) ([]string, []string) {
+	trimmedCities := make([]string, 0, len(cities))
+	for _, city := range cities {
+		trimmedCities = append(trimmedCities, strings.TrimSpace(city))
+	}
+	upperCountries := make([]string, 0, len(countries))
+	for _, country := range countries {
+		upperCountries = append(upperCountries, strings.ToUpper(country))
+	}
+	return trimmedCities, upperCountries
+}
+
+// validTripDates reports why a trip's dates are invalid, or "" when they are fine
+func validTripDates(start time.Time, end time.Time) string {
+	if end.Before(start) {
+		return "end_date can't be before start_date"
+	}
+	if int(end.Sub(start).Hours()/24)+1 > database.MaxTripDays {
+		return fmt.Sprintf("A trip can't last more than %d days", database.MaxTripDays)
+	}
+	return ""
+}
+
+// tripFromParam returns the trip named by the :id parameter, sending the response and returning nil when it isn't
+// one of the user's
+func tripFromParam(c *gin.Context, userID int) *database.Trip {
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid trip id",
+		})
+		return nil
+	}
+	trip, err := database.GetTrip(userID, tripID)
+	if err != nil {
+		log.Printf("Failed to get trip: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get trip",
+		})
+		return nil
+	}
+	if trip == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Trip not found",
+		})
+		return nil
+	}
+	return trip
+}
+
+// GET /trips
+func getTrips(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	trips, err := database.GetTrips(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get trips: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get trips",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"trips": trips,
+	})
+}
+
+// POST /trips
+// INPUT:
+//
+//	{
+//		"name": "Lisbon",
+//		"start_date": "2025-09-12",
+//		"end_date": "2025-09-20",
+//		"countries": ["PT"],
+//		"budget": 2500
+//	}
+func createTrip(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request TripRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	startDate, startErr := time.Parse("2006-01-02", request.StartDate)
+	endDate, endErr := time.Parse("2006-01-02", request.EndDate)
+	if startErr != nil || endErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start_date and end_date must be formatted as YYYY-MM-DD",
+		})
+		return
+	}
+	if reason := validTripDates(startDate, endDate); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": reason,
+		})
+		return
+	}
+	trip := database.Trip{Name: request.Name, StartDate: startDate, EndDate: endDate}
+	trip.Cities, trip.Countries = tripLocations(request.Cities, request.Countries)
+	if request.Budget != nil {
+		budget := money.FromFloat(*request.Budget)
+		trip.Budget = &budget
+	}
+	created, err := database.CreateTrip(userIdInt, trip)
+	if err != nil {
+		log.Printf("Failed to create trip: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create trip",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"trip": created,
+	})
+}
+
+// PATCH /trips/:id
+// INPUT:
+//
+//	{
+//		"end_date": "2025-09-22",
+//		"cities": ["Lisbon", "Porto"]
+//	}
+func updateTrip(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	trip := tripFromParam(c, userIdInt)
+	if trip == nil {
+		return
+	}
+	var request TripUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Name == nil && request.StartDate == nil && request.EndDate == nil && request.Cities == nil && request.Countries == nil && request.Budget == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Nothing to update",
+		})
+		return
+	}
+	update := database.TripUpdate{Name: request.Name}
+	startDate, endDate := trip.StartDate, trip.EndDate
+	if request.StartDate != nil {
+		if startDate, err = time.Parse("2006-01-02", *request.StartDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "start_date must be formatted as YYYY-MM-DD",
+			})
+			return
+		}
+		update.StartDate = &startDate
+	}
+	if request.EndDate != nil {
+		if endDate, err = time.Parse("2006-01-02", *request.EndDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "end_date must be formatted as YYYY-MM-DD",
+			})
+			return
+		}
+		update.EndDate = &endDate
+	}
+	if reason := validTripDates(startDate, endDate); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": reason,
+		})
+		return
+	}
+	if request.Cities != nil {
+		cities, _ := tripLocations(*request.Cities, nil)
+		update.Cities = &cities
+	}
+	if request.Countries != nil {
+		_, countries := tripLocations(nil, *request.Countries)
+		update.Countries = &countries
+	}
+	if request.Budget != nil {
+		budget := money.FromFloat(*request.Budget)
+		update.Budget = &budget
+	}
+	updated, err := database.UpdateTrip(userIdInt, trip.ID, update)
+	if err != nil {
+		log.Printf("Failed to update trip: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update trip",
+		})
+		return
+	}
+	if updated == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Trip not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"trip": updated,
+	})
+}
+
+// DELETE /trips/:id
+// Deleting a trip leaves its transactions as they are
+func deleteTrip(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid trip id",
+		})
+		return
+	}
+	if err := database.DeleteTrip(userIdInt, tripID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Trip not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trip deleted",
+	})
+}
+
+// GET /trips/:id/transactions?sort=-amount&limit=50&offset=0
+// The trip's purchases, taking the same filters as GET /transactions
+func listTripTransactions(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	trip := tripFromParam(c, userIdInt)
+	if trip == nil {
+		return
+	}
+	listQuery, err := ParseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	transactions, err := database.ListTripTransactions(*trip, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list trip transactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list trip transactions",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"limit":        listQuery.Limit,
+		"offset":       listQuery.Offset,
+	})
+}
+
+// GET /trips/:id/summary
+// The trip's spending by category, city and day, with its daily average and what is left of its budget
+func getTripSummary(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	trip := tripFromParam(c, userIdInt)
+	if trip == nil {
+		return
+	}
+	summary, err := database.GetTripSummary(*trip, time.Now())
+	if err != nil {
+		log.Printf("Failed to get trip summary: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get trip summary",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"summary": summary,
+	})
+}
+
+// ** ROUND UPS **
+
+// RoundUpSettingsRequest opts in or out of round-up savings
+type RoundUpSettingsRequest struct {
+	Enabled      bool `json:"enabled"`
+	SavingGoalID *int `json:"saving_goal_id"`
+}
+
+// GET /round-ups?monthyear=72025
+func getRoundUps(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	settings, err := database.GetRoundUpSettings(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get round up settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get round up settings",
+		})
+		return
+	}
+	monthStart := database.MonthYearStart(monthYear)
+	roundUps, err := database.GetRoundUps(userIdInt, monthStart, monthStart.AddDate(0, 1, 0))
+	if err != nil {
+		log.Printf("Failed to get round ups: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get round ups",
+		})
+		return
+	}
+	totalAccrued := 0.0
+	totalContributed := 0.0
+	for _, roundUp := range roundUps {
+		totalAccrued += roundUp.Amount
+		if roundUp.ContributionID != nil {
+			totalContributed += roundUp.Amount
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"settings":          settings,
+		"monthyear":         monthYear,
+		"total_accrued":     totalAccrued,
+		"total_contributed": totalContributed,
+		"total_pending":     totalAccrued - totalContributed,
+		"round_ups":         roundUps,
+	})
+}
+
+// PUT /round-ups/settings
+// INPUT:
+//
+//	{
+//		"enabled": true,
+//		"saving_goal_id": 3
+//	}
+func updateRoundUpSettings(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request RoundUpSettingsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.Enabled && request.SavingGoalID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "saving_goal_id is required to enable round ups",
+		})
+		return
+	}
+	if request.SavingGoalID != nil {
+		if _, err := database.GetSavingsGoal(userIdInt, *request.SavingGoalID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Savings goal not found",
+			})
+			return
+		}
+	}
+	settings, err := database.UpsertRoundUpSettings(userIdInt, request.Enabled, request.SavingGoalID)
+	if err != nil {
+		log.Printf("Failed to update round up settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update round up settings",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"settings": settings,
+	})
+}
+
+// ** DASHBOARD CONFIG **
+
+// DashboardConfigRequest is the body of PUT /dashboard-config
+type DashboardConfigRequest struct {
+	Widgets []string `json:"widgets" binding:"required"`
+}
+
+// GET /dashboard-config
+func getDashboardConfig(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	config, err := database.GetDashboardConfig(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get dashboard config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get dashboard config",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dashboard_config":  config,
+		"available_widgets": database.DefaultDashboardWidgets,
+	})
+}
+
+// PUT /dashboard-config
+// INPUT:
+//
+//	{
+//		"widgets": ["pacing", "safe_to_spend", "goals"]
+//	}
+//
+// Widgets are shown in the order given; those left out are hidden
+func updateDashboardConfig(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request DashboardConfigRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err := database.ValidateDashboardWidgets(request.Widgets); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid widgets",
+			"details": err.Error(),
+		})
+		return
+	}
+	config, err := database.UpsertDashboardConfig(userIdInt, request.Widgets)
+	if err != nil {
+		log.Printf("Failed to update dashboard config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update dashboard config",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dashboard_config": config,
+	})
+}
+
+// ** PREFERENCES **
+
+// PreferencesRequest updates a user's notification and budgeting preferences. Fields left out are unchanged.
+type PreferencesRequest struct {
+	DigestFrequency *string `json:"digest_frequency" binding:"omitempty,oneof=none weekly monthly"`
+	// DigestEmail turns off emailing the digest while it is still sent to notification channels subscribed to it
+	DigestEmail *bool `json:"digest_email"`
+	// AllowanceStrategy is proportional, strict, pooled or envelope, or empty to use the default
+	AllowanceStrategy *string `json:"allowance_strategy"`
+	// IncomeMode smoothed budgets from the average income detected over the last income_smoothing_months months
+	// rather than the income entered on the monthly summary
+	IncomeMode            *string `json:"income_mode" binding:"omitempty,oneof=fixed smoothed"`
+	IncomeSmoothingMonths *int    `json:"income_smoothing_months" binding:"omitempty,min=2,max=24"`
+	// EmergencyFundThresholdMonths alerts the user when their emergency fund covers fewer months of expenses; 0
+	// turns the alert off
+	EmergencyFundThresholdMonths *float64 `json:"emergency_fund_threshold_months" binding:"omitempty,min=0,max=60"`
+	// Timezone is an IANA time zone such as America/Toronto; the user's daily balance is recomputed shortly after
+	// their local midnight
+	Timezone *string `json:"timezone" binding:"omitempty,max=64"`
+	// SyncHistoryMonths is how many months of transaction history newly linked accounts are backfilled with
+	SyncHistoryMonths *int `json:"sync_history_months" binding:"omitempty,min=1,max=24"`
+}
+
+// GET /preferences
+func getPreferences(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	preferences, err := database.GetUserPreferences(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get preferences",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"preferences": preferences,
+	})
+}
+
+// PUT /preferences
+// INPUT:
+//
+//	{
+//		"digest_frequency": "weekly",
+//		"digest_email": false,
+//		"allowance_strategy": "envelope",
+//		"income_mode": "smoothed",
+//		"income_smoothing_months": 6,
+//		"emergency_fund_threshold_months": 3,
+//		"timezone": "America/Toronto",
+//		"sync_history_months": 3
+//	}
+func updatePreferences(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request PreferencesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if request.DigestFrequency == nil && request.DigestEmail == nil && request.AllowanceStrategy == nil &&
+		request.IncomeMode == nil && request.IncomeSmoothingMonths == nil && request.EmergencyFundThresholdMonths == nil &&
+		request.Timezone == nil && request.SyncHistoryMonths == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No preferences to update",
+		})
+		return
+	}
+	if request.AllowanceStrategy != nil && *request.AllowanceStrategy != "" && !budget.IsValidStrategy(*request.AllowanceStrategy) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid allowance strategy",
+		})
+		return
+	}
+	// time.LoadLocation treats "" and "Local" as the server's own zone, which means nothing to the worker
+	if request.Timezone != nil {
+		if _, err := time.LoadLocation(*request.Timezone); err != nil || *request.Timezone == "" || *request.Timezone == "Local" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid timezone",
+			})
+			return
+		}
+	}
+	preferences, err := database.UpdateUserPreferences(userIdInt, database.UserPreferencesUpdate{
+		DigestFrequency:              request.DigestFrequency,
+		DigestEmail:                  request.DigestEmail,
+		AllowanceStrategy:            request.AllowanceStrategy,
+		IncomeMode:                   request.IncomeMode,
+		IncomeSmoothingMonths:        request.IncomeSmoothingMonths,
+		EmergencyFundThresholdMonths: request.EmergencyFundThresholdMonths,
+		Timezone:                     request.Timezone,
+		SyncHistoryMonths:            request.SyncHistoryMonths,
+	})
+	if err != nil {
+		log.Printf("Failed to update preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update preferences",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"preferences": preferences,
+	})
+}
+
+// GET/POST /unsubscribe?token=...
+// Public endpoint linked from digest emails; POST serves one click unsubscribe from mail clients
+func unsubscribeDigest(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "token is required",
+		})
+		return
+	}
+	found, err := database.UnsubscribeDigest(token)
+	if err != nil {
+		log.Printf("Failed to unsubscribe: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to unsubscribe",
+		})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Unsubscribe link is invalid",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "You have been unsubscribed from digest emails",
+	})
+}
+
+// ** HOUSEHOLD **
+
+// HouseholdRequest creates a household, which other users join with its invite code
+type HouseholdRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+type JoinHouseholdRequest struct {
+	InviteCode string `json:"invite_code" binding:"required,max=32"`
+}
+
+// HouseholdCategoryRequest shares a budget category between household members. Percents must add up to 100.
+// Members only see the split in budgets that have a category with the same name.
+type HouseholdCategoryRequest struct {
+	Category string                   `json:"category" binding:"required,max=255"`
+	Splits   []database.CategorySplit `json:"splits" binding:"required,min=2,dive"`
+}
+
+// GET /household
+func getHousehold(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	household, err := database.GetHousehold(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get household",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"household": household,
+	})
+}
+
+// POST /household
+// INPUT:
+//
+//	{
+//		"name": "Home"
+//	}
+func createHousehold(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request HouseholdRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	household, err := database.CreateHousehold(userIdInt, request.Name)
+	if errors.Is(err, database.ErrAlreadyInHousehold) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "You already belong to a household",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create household",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"household": household,
+	})
+}
+
+// POST /household/join
+// INPUT:
+//
+//	{
+//		"invite_code": "3f9c2a71b04e"
+//	}
+func joinHousehold(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request JoinHouseholdRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	household, err := database.JoinHousehold(userIdInt, request.InviteCode)
+	if errors.Is(err, database.ErrAlreadyInHousehold) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "You already belong to a household",
+		})
+		return
+	}
+	if errors.Is(err, database.ErrHouseholdFull) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("A household can have at most %d members", database.MaxHouseholdMembers),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to join household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to join household",
+		})
+		return
+	}
+	if household == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Invite code is invalid",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"household": household,
+	})
+}
+
+// DELETE /household/membership
+// Categories shared with the user stop being shared with the rest of the household too
+func leaveHousehold(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	left, err := database.LeaveHousehold(userIdInt)
+	if err != nil {
+		log.Printf("Failed to leave household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to leave household",
+		})
+		return
+	}
+	if !left {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "You don't belong to a household",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Left household",
+	})
+}
+
+// PUT /household/categories
+// Each member's spend in the category becomes their percent of every member's spend in it, so their allowance
+// only covers their share. Allowances pick up the change the next time the daily balance is processed.
+// INPUT:
+//
+//	{
+//		"category": "Groceries",
+//		"splits": [
+//			{"user_id": 1, "percent": 60},
+//			{"user_id": 2, "percent": 40}
+//		]
+//	}
+func setHouseholdCategory(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request HouseholdCategoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	household, err := database.GetHousehold(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get household",
+		})
+		return
+	}
+	if household == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "You don't belong to a household",
+		})
+		return
+	}
+	// general is whatever the user's named categories don't claim, so it differs per member and can't be split
+	if strings.EqualFold(strings.TrimSpace(request.Category), "general") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "The general category can't be shared",
+		})
+		return
+	}
+	members := map[int]bool{}
+	for _, member := range household.Members {
+		members[member.UserID] = true
+	}
+	seen := map[int]bool{}
+	var totalBasisPoints int64
+	for _, split := range request.Splits {
+		if !members[split.UserID] || seen[split.UserID] {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Each split must be for a different member of the household",
+			})
+			return
+		}
+		basisPoints := int64(math.Round(split.Percent * 100))
+		if basisPoints <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Each split percent must be positive",
+			})
+			return
+		}
+		seen[split.UserID] = true
+		totalBasisPoints += basisPoints
+	}
+	if totalBasisPoints != 10000 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Split percents must add up to 100",
+		})
+		return
+	}
+	if err := database.SetHouseholdCategorySplits(household.ID, strings.TrimSpace(request.Category), request.Splits); err != nil {
+		log.Printf("Failed to share household category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to share category",
+		})
+		return
+	}
+	household, err = database.GetHousehold(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get household",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"household": household,
+	})
+}
+
+// DELETE /household/categories/:category
+func deleteHouseholdCategory(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	household, err := database.GetHousehold(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get household",
+		})
+		return
+	}
+	if household == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "You don't belong to a household",
+		})
+		return
+	}
+	deleted, err := database.DeleteHouseholdCategorySplits(household.ID, c.Param("category"))
+	if err != nil {
+		log.Printf("Failed to unshare household category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to unshare category",
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Category is not shared",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Category is no longer shared",
+	})
+}
+
+// ** SUBSCRIPTIONS **
+
+// GET /subscription
+func getSubscription(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	entitlement, err := database.GetEntitlement(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get entitlement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get subscription",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"subscription": entitlement,
+		"active":       entitlement.IsActive(time.Now()),
+	})
+}
+
+// POST /stripe/checkout-session
+// Returns a Stripe Checkout URL for the web client to redirect to
+func createStripeCheckoutSession(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	entitlement, err := database.GetEntitlement(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get entitlement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get subscription",
+		})
+		return
+	}
+	if entitlement.IsActive(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "You already have an active subscription",
+		})
+		return
+	}
+	customerID := ""
+	if entitlement.StripeCustomerID != nil {
+		customerID = *entitlement.StripeCustomerID
+	} else {
+		user, err := database.GetUserByID(userIdInt)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "User not found",
+			})
+			return
+		}
+		customerID, err = stripe.CreateCustomer(user.Email, userIdInt)
+		if err != nil {
+			log.Printf("Failed to create stripe customer: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Failed to start checkout",
+			})
+			return
+		}
+		if err := database.SetStripeCustomerID(userIdInt, customerID); err != nil {
+			log.Printf("Failed to save stripe customer: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to start checkout",
+			})
+			return
+		}
+	}
+	session, err := stripe.CreateCheckoutSession(customerID, userIdInt, GetBillingSuccessURL(), GetBillingCancelURL())
+	if err != nil {
+		log.Printf("Failed to create checkout session: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to start checkout",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": session.ID,
+		"url":        session.URL,
+	})
+}
+
+// POST /stripe/webhook
+// Public endpoint called by Stripe; requests are authenticated by their signature
+func stripeWebhook(c *gin.Context) {
+	payload, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to read request body",
+		})
+		return
+	}
+	event, err := stripe.ConstructEvent(payload, c.GetHeader("Stripe-Signature"), time.Now())
+	if err != nil {
+		log.Printf("Rejected stripe webhook: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid signature",
+		})
+		return
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			log.Printf("Failed to parse checkout session: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid event",
+			})
+			return
+		}
+		// The customer is normally saved when checkout starts; this covers sessions created elsewhere
+		if userID, err := strconv.Atoi(session.ClientReferenceID); err == nil && session.Customer != "" {
+			if err := database.SetStripeCustomerID(userID, session.Customer); err != nil {
+				log.Printf("Failed to save stripe customer for user %d: %v", userID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to process event",
+				})
+				return
+			}
+		}
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var subscription stripe.Subscription
+		if err := json.Unmarshal(event.Data.Object, &subscription); err != nil {
+			log.Printf("Failed to parse subscription: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid event",
+			})
+			return
+		}
+		plan := database.PlanPremium
+		if event.Type == "customer.subscription.deleted" {
+			plan = database.PlanFree
+		}
+		userID, updated, err := database.UpdateStripeSubscription(subscription.Customer, subscription.ID, plan, subscription.Status,
+			subscription.PeriodEnd(), time.Unix(event.Created, 0))
+		if err != nil {
+			log.Printf("Failed to sync stripe subscription %s: %v", subscription.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to process event",
+			})
+			return
+		}
+		if userID == 0 {
+			// Acknowledged so Stripe doesn't keep retrying a customer we don't know
+			log.Printf("Ignoring stripe subscription %s for unknown customer %s", subscription.ID, subscription.Customer)
+			break
+		}
+		if !updated {
+			log.Printf("Ignoring stripe event %s (%s) for user %d, the plan was synced from a newer event", event.ID, event.Type, userID)
+			break
+		}
+		log.Printf("Synced stripe subscription %s for user %d: %s %s", subscription.ID, userID, plan, subscription.Status)
+	default:
+		log.Printf("Ignoring stripe event %s (%s)", event.ID, event.Type)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"received": true,
+	})
+}
+
+// ** DEAD LETTERS **
+
+// DeadLetterReplayRequest selects dead letters to replay in bulk, either by id or by the same filters as the list
+type DeadLetterReplayRequest struct {
+	IDs           []int  `json:"ids"`
+	JobType       string `json:"job_type"`
+	ErrorContains string `json:"error"`
+	TimedOut      bool   `json:"timed_out"`
+}
+
+const maxDeadLetters = 500
+
+// GET /admin/dead-letters?job_type=fetch_plaid_transactions&error=timeout&timed_out=true&include_replayed=true&limit=100
+// Secrets in a dead letter's data, such as access tokens, are [REDACTED]; replaying it puts them back.
+func getDeadLetters(c *gin.Context) {
+	if err := AdminMiddleware(c); err != nil {
+		return // AdminMiddleware already sent the response
+	}
+	filter := database.DeadLetterFilter{
+		JobType:         c.Query("job_type"),
+		ErrorContains:   c.Query("error"),
+		TimedOut:        c.Query("timed_out") == "true",
+		IncludeReplayed: c.Query("include_replayed") == "true",
+		Limit:           100,
+	}
+	if val, exists := c.GetQuery("limit"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed > 0 && parsed <= maxDeadLetters {
+			filter.Limit = parsed
+		}
+	}
+	deadLetters, err := database.GetDeadLetterJobs(filter)
+	if err != nil {
+		log.Printf("Failed to get dead letters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get dead letters",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": deadLetters,
+	})
+}
+
+// replayDeadLetter enqueues a dead letter's job again with its original data, its secrets put back in
+func replayDeadLetter(ctx context.Context, deadLetter database.DeadLetterJob) error {
+	secrets, err := database.GetDeadLetterJobSecrets(deadLetter.ID)
+	if err != nil {
+		return err
+	}
+	data, err := jobs.RestoreSecrets(deadLetter.Data, secrets)
+	if err != nil {
+		return err
+	}
+	var jobData map[string]interface{}
+	if err := json.Unmarshal(data, &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %v", err)
+	}
+	if err := EnqueueWorkerJob(ctx, deadLetter.JobType, jobData); err != nil {
+		return err
+	}
+	return database.MarkDeadLetterReplayed(deadLetter.ID)
+}
+
+// POST /admin/dead-letters/:id/replay
+func replayDeadLetterByID(c *gin.Context) {
+	if err := AdminMiddleware(c); err != nil {
+		return // AdminMiddleware already sent the response
+	}
+	deadLetterID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid dead letter id",
+		})
+		return
+	}
+	deadLetter, err := database.GetDeadLetterJob(deadLetterID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Dead letter not found",
+		})
+		return
+	}
+	if err := replayDeadLetter(c.Request.Context(), *deadLetter); err != nil {
+		log.Printf("Failed to replay dead letter %d: %v", deadLetterID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to replay dead letter",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Dead letter replayed",
+	})
+}
+
+// POST /admin/dead-letters/replay
+// Replays every unreplayed dead letter matching the ids or filters. At least one must be given.
+// INPUT:
+//
+//	{
+//		"job_type": "fetch_plaid_transactions",
+//		"error": "timeout",
+//		"timed_out": true
+//	}
+func replayDeadLetters(c *gin.Context) {
+	if err := AdminMiddleware(c); err != nil {
+		return // AdminMiddleware already sent the response
+	}
+	var request DeadLetterReplayRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if len(request.IDs) == 0 && request.JobType == "" && request.ErrorContains == "" && !request.TimedOut {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Provide ids, job_type, error or timed_out to select dead letters",
+		})
+		return
+	}
+
+	var deadLetters []database.DeadLetterJob
+	if len(request.IDs) > 0 {
+		for _, id := range request.IDs {
+			deadLetter, err := database.GetDeadLetterJob(id)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": fmt.Sprintf("Dead letter %d not found", id),
+				})
+				return
+			}
+			deadLetters = append(deadLetters, *deadLetter)
+		}
+	} else {
+		var err error
+		deadLetters, err = database.GetDeadLetterJobs(database.DeadLetterFilter{
+			JobType:       request.JobType,
+			ErrorContains: request.ErrorContains,
+			TimedOut:      request.TimedOut,
+			Limit:         maxDeadLetters,
+		})
+		if err != nil {
+			log.Printf("Failed to get dead letters: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get dead letters",
+			})
+			return
+		}
+	}
+
+	replayed := []int{}
+	failed := []int{}
+	for _, deadLetter := range deadLetters {
+		if err := replayDeadLetter(c.Request.Context(), deadLetter); err != nil {
+			log.Printf("Failed to replay dead letter %d: %v", deadLetter.ID, err)
+			failed = append(failed, deadLetter.ID)
+			continue
+		}
+		replayed = append(replayed, deadLetter.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"replayed": replayed,
+		"failed":   failed,
+	})
+}
+
+// POST /admin/jobs/:run_id/replay
+// Enqueues a job the worker processed again with the data it ran with, to reproduce a bug. run_id is the job
+// run's id or its job id. Secrets were redacted when the data was recorded, so jobs that need them, such as
+// syncs needing an access token, won't replay faithfully; payload_redacted says whether that happened.
+func replayJobRun(c *gin.Context) {
+	if err := AdminMiddleware(c); err != nil {
+		return // AdminMiddleware already sent the response
+	}
+	runID := c.Param("run_id")
+	run, err := database.GetJobRun(runID)
+	if err != nil {
+		log.Printf("Failed to get job run %s: %v", runID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get job run",
+		})
+		return
+	}
+	if run == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Job run not found",
+		})
+		return
+	}
+	if run.Payload == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Job run has no recorded payload",
+		})
+		return
+	}
+	jobID, err := enqueueWorkerJobWithID(c.Request.Context(), run.JobType, run.Payload)
+	if err != nil {
+		log.Printf("Failed to replay job run %d: %v", run.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to replay job run",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Job run replayed",
+		"run_id":           run.ID,
+		"job_id":           jobID,
+		"job_type":         run.JobType,
+		"payload_redacted": run.PayloadRedacted,
+	})
+}
+
+// ** DEMO **
+
+// DemoSeedRequest chooses how many months of demo history to generate
+type DemoSeedRequest struct {
+	Months int `json:"months" binding:"omitempty,min=1,max=12"`
+}
+
+// POST /demo/seed
+// Sandbox and development only. Generates demo accounts, transactions, budgets and goals in the background.
+// INPUT:
+//
+//	{
+//		"months": 3
+//	}
+func seedDemoData(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	if !IsDemoModeEnabled() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Demo seeding is only available in sandbox mode",
+		})
+		return
+	}
+	var request DemoSeedRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	if request.Months == 0 {
+		request.Months = 3
+	}
+	if err := EnqueueWorkerJob(c.Request.Context(), "seed_demo_data", map[string]interface{}{
+		"user_id": userIdInt,
+		"months":  request.Months,
+	}); err != nil {
+		log.Printf("Failed to enqueue demo seed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to enqueue demo seed",
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Demo data is being generated",
+		"months":  request.Months,
+	})
+}
+
+// ** SANDBOX **
+
+// SandboxPlaidRequest picks the Plaid item a sandbox endpoint acts on; without an item id it acts on all of them
+type SandboxPlaidRequest struct {
+	ItemID      string `json:"item_id"`
+	WebhookCode string `json:"webhook_code" binding:"omitempty,oneof=DEFAULT_UPDATE SYNC_UPDATES_AVAILABLE NEW_ACCOUNTS_AVAILABLE"`
+}
+
+// SandboxItemResult is the outcome of a sandbox call for one item
+type SandboxItemResult struct {
+	ItemID string `json:"item_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+// sandboxPlaidItems binds the request and returns the items it picks. It sends the response and returns false
+// outside the Plaid sandbox or when the request is invalid.
+func sandboxPlaidItems(c *gin.Context, userID int) (SandboxPlaidRequest, []database.PlaidItem, bool) {
+	var request SandboxPlaidRequest
+	if !IsPlaidSandbox() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Sandbox endpoints are only available against the Plaid sandbox",
+		})
+		return request, nil, false
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return request, nil, false
+		}
+	}
+	items, err := database.GetPlaidItems(userID)
+	if err != nil {
+		log.Printf("Failed to get plaid items: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get Plaid items",
+		})
+		return request, nil, false
+	}
+	if request.ItemID != "" {
+		items = slices.DeleteFunc(items, func(item database.PlaidItem) bool {
+			return item.ItemID != request.ItemID
+		})
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "No Plaid items found",
+		})
+		return request, nil, false
+	}
+	return request, items, true
+}
+
+// POST /sandbox/plaid/fire-webhook
+// Plaid sandbox only. Has Plaid send the items' webhook to PLAID_WEBHOOK_URL, which syncs them through
+// /plaid/webhook as real bank activity would. The code defaults to DEFAULT_UPDATE; NEW_ACCOUNTS_AVAILABLE
+// fires the item webhook for accounts added at the bank.
+// INPUT:
+//
+//	{
+//		"item_id": "eVBnVMp7zdTJLkRNr33Rs6zr7KNJqBFL9DrE6",
+//		"webhook_code": "DEFAULT_UPDATE"
+//	}
+func fireSandboxPlaidWebhook(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	request, items, ok := sandboxPlaidItems(c, userIdInt)
+	if !ok {
+		return
+	}
+	if plaid.PLAID_WEBHOOK_URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "PLAID_WEBHOOK_URL is not set, so Plaid has nowhere to send webhooks",
+		})
+		return
+	}
+	if request.WebhookCode == "" {
+		request.WebhookCode = "DEFAULT_UPDATE"
+	}
+	results := make([]SandboxItemResult, 0, len(items))
+	for _, item := range items {
+		result := SandboxItemResult{ItemID: item.ItemID}
+		// Items linked before PLAID_WEBHOOK_URL was set have no webhook to fire
+		err := plaid.SetItemWebhook(c.Request.Context(), item.AccessToken)
+		if err == nil {
+			err = plaid.FireSandboxWebhook(c.Request.Context(), item.AccessToken, request.WebhookCode)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"webhook_code": request.WebhookCode,
+		"items":        results,
+	})
+}
+
+// POST /sandbox/plaid/transactions/refresh
+// Plaid sandbox only. Has Plaid generate new transactions on the items, then syncs the user's Plaid accounts.
+// Plaid adds the transactions in the background; with PLAID_WEBHOOK_URL set its DEFAULT_UPDATE webhook syncs
+// again once they are in.
+// INPUT:
+//
+//	{
+//		"item_id": "eVBnVMp7zdTJLkRNr33Rs6zr7KNJqBFL9DrE6"
+//	}
+func refreshSandboxPlaidTransactions(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	_, items, ok := sandboxPlaidItems(c, userIdInt)
+	if !ok {
+		return
+	}
+	results := make([]SandboxItemResult, 0, len(items))
+	for _, item := range items {
+		result := SandboxItemResult{ItemID: item.ItemID}
+		if err := plaid.RefreshTransactions(c.Request.Context(), item.AccessToken); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	if err := EnqueueWorkerJob(c.Request.Context(), "sync_plaid_accounts", map[string]interface{}{
+		"user_id": userIdInt,
+	}); err != nil {
+		log.Printf("Failed to enqueue plaid sync: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to enqueue Plaid sync",
+			"items": results,
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Transactions are being refreshed",
+		"items":   results,
+	})
+}
+
+// ** NOTIFICATIONS **
+
+const maxNotifications = 100
+
+// GET /notifications?unread=true&limit=50
+func getNotifications(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	limit := 50
+	if val, exists := c.GetQuery("limit"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed > 0 && parsed <= maxNotifications {
+			limit = parsed
+		}
+	}
+	notifications, err := database.GetNotifications(userIdInt, c.Query("unread") == "true", limit)
+	if err != nil {
+		log.Printf("Failed to get notifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get notifications",
+		})
+		return
+	}
+	unreadCount, err := database.GetUnreadNotificationCount(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get unread notification count: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get notifications",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"unread_count":  unreadCount,
+	})
+}
+
+// GET /notifications/unread-count
+// Lightweight badge count for polling
+func getUnreadNotificationCount(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	unreadCount, err := database.GetUnreadNotificationCount(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get unread notification count: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get unread notification count",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"unread_count": unreadCount,
+	})
+}
+
+// POST /notifications/:id/read
+func markNotificationRead(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	notificationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid notification id",
+		})
+		return
+	}
+	if err := database.MarkNotificationRead(userIdInt, notificationID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Notification not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Notification marked as read",
+	})
+}
+
+// POST /notifications/read-all
+func markAllNotificationsRead(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	if err := database.MarkAllNotificationsRead(userIdInt); err != nil {
+		log.Printf("Failed to mark notifications read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to mark notifications read",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "All notifications marked as read",
+	})
+}
+
+// ** NOTIFICATION CHANNELS **
+
+// maxNotificationChannels caps how many Slack and Discord channels a user can add
+const maxNotificationChannels = 10
+
+// NotificationChannelRequest adds a Slack or Discord incoming webhook. Without notification types the channel
+// receives every notification, including the digest.
+type NotificationChannelRequest struct {
+	Kind              string   `json:"kind" binding:"required,oneof=slack discord"`
+	WebhookURL        string   `json:"webhook_url" binding:"required,url,max=2048"`
+	Name              string   `json:"name" binding:"max=255"`
+	NotificationTypes []string `json:"notification_types"`
+}
+
+// validChannelWebhookURL reports whether the URL is an incoming webhook of the channel's service, so channels
+// can't be pointed at arbitrary hosts
+func validChannelWebhookURL(kind string, webhookURL string) bool {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || parsed.Scheme != "https" {
+		return false
+	}
+	switch kind {
+	case database.NotificationChannelSlack:
+		return parsed.Hostname() == "hooks.slack.com" && strings.HasPrefix(parsed.Path, "/services/")
+	case database.NotificationChannelDiscord:
+		host := parsed.Hostname()
+		return (host == "discord.com" || host == "discordapp.com") && strings.HasPrefix(parsed.Path, "/api/webhooks/")
+	}
+	return false
+}
+
+// POST /notification-channels
+// Adds a Slack or Discord channel notifications are also sent to
+// INPUT:
+//
+//	{
+//		"kind": "slack",
+//		"webhook_url": "https://hooks.slack.com/services/T000/B000/XXXX",
+//		"name": "#budget",
+//		"notification_types": ["budget_warning", "digest"]
+//	}
+func createNotificationChannel(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request NotificationChannelRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !validChannelWebhookURL(request.Kind, request.WebhookURL) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("webhook_url must be a %s incoming webhook URL", request.Kind),
+		})
+		return
+	}
+	for _, notificationType := range request.NotificationTypes {
+		if !slices.Contains(database.NotificationChannelTypes, notificationType) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":              fmt.Sprintf("Unknown notification type %q", notificationType),
+				"notification_types": database.NotificationChannelTypes,
+			})
+			return
+		}
+	}
+
+	channels, err := database.GetNotificationChannels(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get notification channels: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create notification channel",
+		})
+		return
+	}
+	if len(channels) >= maxNotificationChannels {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("You can add at most %d notification channels", maxNotificationChannels),
+		})
+		return
+	}
+
+	channel, err := database.CreateNotificationChannel(userIdInt, request.Kind, request.WebhookURL, request.Name, request.NotificationTypes)
+	if err != nil {
+		log.Printf("Failed to create notification channel: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create notification channel",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"channel": channel,
+	})
+}
+
+// GET /notification-channels
+func getNotificationChannels(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	channels, err := database.GetNotificationChannels(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get notification channels: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get notification channels",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"channels":           channels,
+		"notification_types": database.NotificationChannelTypes,
+	})
+}
+
+// DELETE /notification-channels/:id
+func deleteNotificationChannel(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	channelID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid notification channel id",
+		})
+		return
+	}
+	if err := database.DeleteNotificationChannel(userIdInt, channelID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Notification channel not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Notification channel deleted",
+	})
+}
+
+// ** WEBHOOKS **
+
+// maxWebhookEndpoints caps how many endpoints a user can register
+const maxWebhookEndpoints = 10
+
+// WebhookEndpointRequest registers a callback URL. Without event types the endpoint receives every event.
+type WebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required,url,max=2048"`
+	Description string   `json:"description" binding:"max=255"`
+	EventTypes  []string `json:"event_types"`
+}
+
+// generateWebhookSecret returns a random secret for signing an endpoint's deliveries
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// POST /webhooks
+// Registers a webhook endpoint. The response carries the secret deliveries are signed with; it isn't shown again.
+// INPUT:
+//
+//	{
+//		"url": "https://example.com/watson/webhooks",
+//		"description": "Sync to my spreadsheet",
+//		"event_types": ["transaction.created", "budget.exceeded", "sync.completed"]
+//	}
+func createWebhookEndpoint(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if parsed, err := url.Parse(request.URL); err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Webhook URL must be an https URL",
+		})
+		return
+	}
+	for _, eventType := range request.EventTypes {
+		if !slices.Contains(database.WebhookEventTypes, eventType) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       fmt.Sprintf("Unknown event type %q", eventType),
+				"event_types": database.WebhookEventTypes,
+			})
+			return
+		}
+	}
+
+	endpoints, err := database.GetWebhookEndpoints(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get webhook endpoints: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook endpoint",
+		})
+		return
+	}
+	if len(endpoints) >= maxWebhookEndpoints {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("You can register at most %d webhook endpoints", maxWebhookEndpoints),
+		})
+		return
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		log.Printf("Failed to generate webhook secret: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook endpoint",
+		})
+		return
+	}
+	endpoint, err := database.CreateWebhookEndpoint(userIdInt, request.URL, request.Description, request.EventTypes, secret)
+	if err != nil {
+		log.Printf("Failed to create webhook endpoint: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook endpoint",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": endpoint,
+	})
+}
+
+// GET /webhooks
+func getWebhookEndpoints(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	endpoints, err := database.GetWebhookEndpoints(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get webhook endpoints: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get webhook endpoints",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks":    endpoints,
+		"event_types": database.WebhookEventTypes,
+	})
+}
+
+// DELETE /webhooks/:id
+func deleteWebhookEndpoint(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	endpointID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid webhook id",
+		})
+		return
+	}
+	if err := database.DeleteWebhookEndpoint(userIdInt, endpointID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Webhook not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook deleted",
+	})
+}
+
+// GET /webhooks/deliveries?status=failed&event_type=budget.exceeded&endpoint_id=3&sort=-created_at&limit=50&offset=0
+// The delivery log, newest first. Filterable fields are listed in database.WebhookDeliveryFilters.
+func getWebhookDeliveries(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	listQuery, err := ParseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	deliveries, err := database.ListWebhookDeliveries(userIdInt, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list webhook deliveries",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+	})
+}
+
+// POST /webhooks/deliveries/:id/redeliver
+// Sends a delivery again, e.g. one that ran out of attempts while the receiver was down
+func redeliverWebhook(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	deliveryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid delivery id",
+		})
+		return
+	}
+	if err := database.RedeliverWebhook(userIdInt, deliveryID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Webhook delivery not found",
+		})
+		return
+	}
+	if err := EnqueueWorkerJob(c.Request.Context(), "deliver_webhooks", map[string]interface{}{}); err != nil {
+		// The worker's scheduler sends it within a minute anyway
+		log.Printf("Failed to enqueue webhook delivery: %v", err)
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Webhook delivery queued",
+	})
+}
+
+// ** GOOGLE SHEETS **
+
+// googleSheetsStateTTL is how long a user has to finish authorizing Google Sheets
+const googleSheetsStateTTL = 15 * time.Minute
+
+// googleSheetsStateKey is where the user an authorization state was issued to is kept, namespaced like every
+// other key
+func googleSheetsStateKey(state string) string {
+	return redisconn.Key("google_sheets_state:" + state)
+}
+
+// POST /integrations/google-sheets/authorize
+// Returns the Google consent URL for the web client to redirect to. Google sends the user back to
+// /integrations/google-sheets/callback.
+func authorizeGoogleSheets(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	if rateLimitClient == nil {
+		// The callback is identified by its state alone, so it can't be checked without Redis
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Google Sheets is unavailable",
+		})
+		return
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start authorization",
+		})
+		return
+	}
+	state := hex.EncodeToString(raw)
+	authorizationURL, err := googlesheets.AuthCodeURL(state)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Google Sheets is not configured",
+		})
+		return
+	}
+	if err := rateLimitClient.Set(c.Request.Context(), googleSheetsStateKey(state), userIdInt, googleSheetsStateTTL).Err(); err != nil {
+		log.Printf("Failed to store google sheets state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start authorization",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"authorization_url": authorizationURL,
+	})
+}
+
+// GET /integrations/google-sheets/callback?code=...&state=...
+// Public endpoint Google redirects to after consent; the state ties it to the user who started it. Redirects to
+// GetGoogleSheetsReturnURL with google_sheets=connected or google_sheets=error.
+func googleSheetsCallback(c *gin.Context) {
+	returnURL, err := url.Parse(GetGoogleSheetsReturnURL())
+	if err != nil {
+		log.Printf("Invalid GOOGLE_SHEETS_RETURN_URL: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to complete authorization",
+		})
+		return
+	}
+	redirect := func(result string) {
+		query := returnURL.Query()
+		query.Set("google_sheets", result)
+		returnURL.RawQuery = query.Encode()
+		c.Redirect(http.StatusFound, returnURL.String())
+	}
+
+	state := c.Query("state")
+	if state == "" || rateLimitClient == nil {
+		redirect("error")
+		return
+	}
+	owner, err := rateLimitClient.GetDel(c.Request.Context(), googleSheetsStateKey(state)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Failed to check google sheets state: %v", err)
+		}
+		redirect("error")
+		return
+	}
+	userIdInt, err := strconv.Atoi(owner)
+	if err != nil || c.Query("error") != "" || c.Query("code") == "" {
+		// The user declined consent, or Google sent something other than a code
+		redirect("error")
+		return
+	}
+	token, err := googlesheets.ExchangeCode(c.Request.Context(), c.Query("code"))
+	if err != nil {
+		log.Printf("Failed to exchange google sheets code for user %d: %v", userIdInt, err)
+		redirect("error")
+		return
+	}
+	if _, err := database.UpsertGoogleSheetsConnection(userIdInt, token.RefreshToken); err != nil {
+		log.Printf("Failed to save google sheets connection: %v", err)
+		redirect("error")
+		return
+	}
+	redirect("connected")
+}
+
+// GET /integrations/google-sheets
+// Returns the user's connection, or null when they haven't authorized Google Sheets
+func getGoogleSheetsConnection(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	connection, err := database.GetGoogleSheetsConnection(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get google sheets connection: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get Google Sheets connection",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"connection": connection,
+	})
+}
+
+// GoogleSheetsSpreadsheetRequest picks an existing spreadsheet by its id, or names a new one to create
+type GoogleSheetsSpreadsheetRequest struct {
+	SpreadsheetID string `json:"spreadsheet_id" binding:"omitempty,max=255"`
+	Title         string `json:"title" binding:"omitempty,max=100"`
+}
+
+// PUT /integrations/google-sheets/spreadsheet
+// INPUT:
+//
+//	{
+//	  "spreadsheet_id": "1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms"
+//	}
+//
+// or {"title": "Watson"} to create a new spreadsheet. The export sheets are added to it and the last completed
+// month is exported straight away; later months follow as they complete.
+func setGoogleSheetsSpreadsheet(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	var request GoogleSheetsSpreadsheetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	request.SpreadsheetID = strings.TrimSpace(request.SpreadsheetID)
+	request.Title = strings.TrimSpace(request.Title)
+	if (request.SpreadsheetID == "") == (request.Title == "") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Provide either spreadsheet_id or title",
+		})
+		return
+	}
+	connection, err := database.GetGoogleSheetsConnection(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get google sheets connection: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get Google Sheets connection",
+		})
+		return
+	}
+	if connection == nil || connection.Status != database.GoogleSheetsActive {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Authorize Google Sheets first",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	accessToken, err := googlesheets.RefreshAccessToken(ctx, connection.RefreshToken)
+	var spreadsheet *googlesheets.Spreadsheet
+	if err == nil {
+		if request.Title != "" {
+			spreadsheet, err = googlesheets.CreateSpreadsheet(ctx, accessToken, request.Title)
+		} else if err = googlesheets.PrepareSpreadsheet(ctx, accessToken, request.SpreadsheetID); err == nil {
+			spreadsheet, err = googlesheets.GetSpreadsheet(ctx, accessToken, request.SpreadsheetID)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to set up google sheets spreadsheet for user %d: %v", userIdInt, err)
+		switch {
+		case errors.Is(err, googlesheets.ErrAuthorizationRevoked):
+			if recordErr := database.RecordGoogleSheetsExportError(userIdInt, err.Error(), true); recordErr != nil {
+				log.Printf("Failed to record google sheets error: %v", recordErr)
+			}
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Google no longer accepts Watson's access, authorize Google Sheets again",
+			})
+		case errors.Is(err, googlesheets.ErrSpreadsheetNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Spreadsheet not found or not shared with the authorized Google account",
+			})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Failed to set up spreadsheet",
+			})
+		}
+		return
+	}
+
+	connection, err = database.SetGoogleSheetsSpreadsheet(userIdInt, spreadsheet.SpreadsheetID, spreadsheet.SpreadsheetURL)
+	if err != nil || connection == nil {
+		log.Printf("Failed to save google sheets spreadsheet: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save spreadsheet",
+		})
+		return
+	}
+	if err := EnqueueWorkerJob(ctx, "export_google_sheets", map[string]interface{}{"user_id": userIdInt}); err != nil {
+		// The scheduled export picks it up within a day anyway
+		log.Printf("Failed to enqueue google sheets export: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"connection": connection,
+	})
+}
+
+// DELETE /integrations/google-sheets
+// Stops exports and revokes Watson's access to the user's Google account. The spreadsheet is left as it is.
+func deleteGoogleSheetsConnection(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	connection, err := database.DeleteGoogleSheetsConnection(userIdInt)
+	if err != nil {
+		log.Printf("Failed to delete google sheets connection: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to disconnect Google Sheets",
+		})
+		return
+	}
+	if connection == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Google Sheets is not connected",
+		})
+		return
+	}
+	if err := googlesheets.RevokeToken(c.Request.Context(), connection.RefreshToken); err != nil {
+		// The token is gone from our side either way; the user can also remove access from their Google account
+		log.Printf("Failed to revoke google sheets token for user %d: %v", userIdInt, err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Google Sheets disconnected",
+	})
+}
+
+// ** HISTORY IMPORTS **
+
+// maxHistoryImportBytes caps an upload's files together. They're stored with the import rather than in the job, so
+// job runs and dead letters never hold a copy of them.
+const maxHistoryImportBytes = 3 << 20
+
+// POST /imports
+// Imports transaction history, and for YNAB past budgets, from another budgeting app's export. The files are
+// checked straight away and loaded in the background; GET /imports/:id follows the import.
+// INPUT: multipart form
+//
+//	source     ynab or mint
+//	register   YNAB's register CSV or Mint's transactions CSV
+//	budget     optional, YNAB's budget CSV
+//	day_first  optional, true when the export writes dates day first, like 31/01/2024
+func createHistoryImport(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	// The form's own fields and boundaries get a little room on top of the files
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxHistoryImportBytes+64<<10)
+	if err := c.Request.ParseMultipartForm(maxHistoryImportBytes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   fmt.Sprintf("Upload the export as a multipart form of at most %dMB", maxHistoryImportBytes>>20),
+			"details": err.Error(),
+		})
+		return
+	}
+	source := strings.ToLower(c.PostForm("source"))
+	if !slices.Contains(importers.Sources, source) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "source must be ynab or mint",
+		})
+		return
+	}
+	readFile := func(field string) ([]byte, error) {
+		file, err := c.FormFile(field)
+		if err == http.ErrMissingFile {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		upload, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer upload.Close()
+		return io.ReadAll(upload)
+	}
+	register, err := readFile("register")
+	if err != nil || len(register) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Upload the export's transactions CSV as the register field",
+		})
+		return
+	}
+	budgetFile, err := readFile("budget")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to read the uploaded budget file",
+		})
+		return
+	}
+	if len(register)+len(budgetFile) > maxHistoryImportBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("The export's files can be at most %dMB together", maxHistoryImportBytes>>20),
+		})
+		return
+	}
+	dayFirst, _ := strconv.ParseBool(c.PostForm("day_first"))
+
+	// Parsing here rejects files that aren't exports before anything is queued; the job parses them again to load them
+	var budgetReader io.Reader
+	if budgetFile != nil {
+		budgetReader = bytes.NewReader(budgetFile)
+	}
+	transactions, budgets, err := importers.Parse(source, bytes.NewReader(register), budgetReader, importers.Options{DayFirst: dayFirst})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	dataImport, err := database.CreateDataImport(userIdInt, source, database.DataImportFiles{Register: register, Budget: budgetFile})
+	if err != nil {
+		log.Printf("Failed to create data import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start import",
+		})
+		return
+	}
+	if err := EnqueueWorkerJob(c.Request.Context(), "import_history", map[string]interface{}{
+		"import_id": dataImport.ID,
+		"user_id":   userIdInt,
+		"source":    source,
+		"day_first": dayFirst,
+	}); err != nil {
+		log.Printf("Failed to enqueue history import: %v", err)
+		if err := database.FailDataImport(dataImport.ID, "the import couldn't be queued"); err != nil {
+			log.Printf("%v", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start import",
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"import":       dataImport,
+		"transactions": len(transactions),
+		"budgets":      len(budgets),
+	})
+}
+
+// GET /imports
+// Lists the user's history imports, newest first
+func getHistoryImports(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	dataImports, err := database.GetDataImports(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get data imports: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get imports",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, dataImports)
+}
+
+// GET /imports/:id
+// Returns a history import's status and, once completed, how many transactions and budget months it loaded
+func getHistoryImport(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	importID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid import id",
+		})
+		return
+	}
+	dataImport, err := database.GetDataImport(userIdInt, importID)
+	if err != nil {
+		log.Printf("Failed to get data import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get import",
+		})
+		return
+	}
+	if dataImport == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Import not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, dataImport)
+}
+
+// ** API KEYS **
+
+// maxAPIKeys caps how many unrevoked keys a user can hold
+const maxAPIKeys = 25
+
+// APIKeyRequest creates an API key. Without scopes the key is read only; without expires_in_days it never expires.
+type APIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"min=0,max=3650"`
+}
+
+// POST /api-keys
+// Creates an API key, sent as "Authorization: Bearer <key>" in place of a JWT. The response is the only time
+// the key is shown. API keys can't manage API keys.
+// INPUT:
+//
+//	{
+//		"name": "Spreadsheet export",
+//		"scopes": ["read"],
+//		"expires_in_days": 90
+//	}
+func createAPIKey(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	var request APIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	scopes := []string{database.APIKeyScopeRead}
+	if len(request.Scopes) > 0 {
+		scopes = []string{}
+		for _, scope := range request.Scopes {
+			if !slices.Contains(database.APIKeyScopes, scope) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":  fmt.Sprintf("Unknown scope %q", scope),
+					"scopes": database.APIKeyScopes,
+				})
+				return
+			}
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	keys, err := database.GetAPIKeys(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get api keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create API key",
+		})
+		return
+	}
+	if len(keys) >= maxAPIKeys {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("You can have at most %d API keys, revoke one first", maxAPIKeys),
+		})
+		return
+	}
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		log.Printf("Failed to generate api key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create API key",
+		})
+		return
+	}
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		expiry := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		expiresAt = &expiry
+	}
+	apiKey, err := database.CreateAPIKey(userIdInt, request.Name, prefix, hashAPIKey(key), scopes, expiresAt)
+	if err != nil {
+		log.Printf("Failed to create api key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create API key",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+// GET /api-keys
+func getAPIKeys(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	keys, err := database.GetAPIKeys(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get api keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get API keys",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+		"scopes":   database.APIKeyScopes,
+	})
+}
+
+// DELETE /api-keys/:id
+// Revokes a key; requests using it are rejected from then on
+func revokeAPIKey(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid API key id",
+		})
+		return
+	}
+	if err := database.RevokeAPIKey(userIdInt, keyID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API key not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key revoked",
+	})
+}
+
+// ** ADVISOR ACCESS **
+
+// advisorTokenExpiry caps how long a token redeemed from an advisor access link lasts. Advisors redeem the link
+// again for a new one until the access expires.
+const advisorTokenExpiry = 24 * time.Hour
+
+// AdvisorAccessRequest shares read-only access with an advisor. Without expires_in_days it lasts
+// DefaultAdvisorAccessDays.
+type AdvisorAccessRequest struct {
+	Name          string `json:"name" binding:"required,max=100"`
+	ExpiresInDays int    `json:"expires_in_days" binding:"min=0,max=90"`
+}
+
+// POST /advisor-access
+// Creates a link that gives an advisor or accountant read-only access to the user's summaries, reports and
+// transactions until it expires or is revoked. The response is the only time the link is shown.
+// INPUT:
+//
+//	{
+//		"name": "Jane, my accountant",
+//		"expires_in_days": 30
+//	}
+func createAdvisorAccess(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	var request AdvisorAccessRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	days := request.ExpiresInDays
+	if days == 0 {
+		days = database.DefaultAdvisorAccessDays
+	}
+	code, err := generateAdvisorCode()
+	if err != nil {
+		log.Printf("Failed to generate advisor code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create advisor access",
+		})
+		return
+	}
+	access, err := database.CreateAdvisorAccess(userIdInt, request.Name, hashAPIKey(code), time.Now().AddDate(0, 0, days))
+	if err != nil {
+		log.Printf("Failed to create advisor access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create advisor access",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"advisor_access": access,
+		"code":           code,
+		"link":           GetAdvisorLinkURL() + "?code=" + url.QueryEscape(code),
+	})
+}
+
+// GET /advisor-access
+func getAdvisorAccess(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	accesses, err := database.GetAdvisorAccesses(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get advisor access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get advisor access",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"advisor_access": accesses,
+	})
+}
+
+// DELETE /advisor-access/:id
+// Revokes advisor access; the advisor's requests are rejected from then on
+func revokeAdvisorAccess(c *gin.Context) {
+	userIdInt, err := SessionAuthMiddleware(c)
+	if err != nil {
+		return // SessionAuthMiddleware already sent the response
+	}
+	accessID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid advisor access id",
+		})
+		return
+	}
+	if err := database.RevokeAdvisorAccess(userIdInt, accessID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Advisor access not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Advisor access revoked",
+	})
+}
+
+// AdvisorRedeemRequest is the body of POST /advisor-access/redeem
+type AdvisorRedeemRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// POST /advisor-access/redeem
+// INPUT:
+//
+//	{
+//		"code": "adv_..."
+//	}
+//
+// Exchanges the code of an advisor access link for a token that can only view the user's summaries, reports and
+// transactions. The token lasts a day at most and stops working as soon as the access is revoked.
+func redeemAdvisorAccess(c *gin.Context) {
+	var request AdvisorRedeemRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	access, err := database.GetActiveAdvisorAccessByHash(hashAPIKey(request.Code))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid, revoked or expired advisor link",
+			"code":  "INVALID_ADVISOR_CODE",
+		})
+		return
+	}
+	sessionVersion, err := database.GetSessionVersion(access.UserID)
+	if err != nil {
+		log.Printf("Failed to get session version: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to redeem advisor link",
+		})
+		return
+	}
+	expiry := time.Until(access.ExpiresAt)
+	if expiry > advisorTokenExpiry {
+		expiry = advisorTokenExpiry
+	}
+	token, err := GenerateAdvisorJWT(access.UserID, sessionVersion, access.ID, expiry)
+	if err != nil {
+		log.Printf("Failed to generate advisor token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to redeem advisor link",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":          token,
+		"expires_at":     time.Now().Add(expiry),
+		"advisor_access": access,
+	})
+}
+
+// ** MONTHLY REPORTS **
+
+// GET /reports/:monthyear?format=pdf
+// Returns the generated "month in review" report as JSON, or as a PDF when format=pdf
+func getMonthlyReport(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear, err := strconv.Atoi(c.Param("monthyear"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid monthyear",
+		})
+		return
+	}
+	report, err := database.GetMonthlyReport(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Report not found",
+		})
+		return
+	}
+	if c.Query("format") == "pdf" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"report-%d.pdf\"", monthYear))
+		c.Data(http.StatusOK, "application/pdf", reports.RenderMonthlyReportPDF(*report))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
+
+// GET /reports/tax?year=2025&format=csv
+// Returns the year's spending in tax relevant categories (donations, medical and business) with a total for each
+// category, or every expense as a CSV when format=csv. Defaults to last year. Categories are designated through
+// /tax-categories, and transactions marked as business expenses count as business.
+func getTaxReport(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	year := time.Now().Year() - 1
+	if val, exists := c.GetQuery("year"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1900 || parsed > time.Now().Year() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid year",
+			})
+			return
+		}
+		year = parsed
+	}
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	expenses, err := database.GetTaxExpenses(userIdInt, start, start.AddDate(1, 0, 0))
+	if err != nil {
+		log.Printf("Failed to get tax expenses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get tax report",
+		})
+		return
+	}
+	if c.Query("format") == "csv" {
+		export, err := reports.RenderTaxReportCSV(expenses)
+		if err != nil {
+			log.Printf("Failed to render tax report: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to export tax report",
+			})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"tax-report-%d.csv\"", year))
+		c.Data(http.StatusOK, "text/csv", export)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"year":       year,
+		"categories": database.SummarizeTaxExpenses(expenses),
+	})
+}
+
+// ** DEBT PLAN **
+
+// DebtPlanRequest chooses a payoff strategy and how much extra to pay each month
+type DebtPlanRequest struct {
+	Strategy            string  `json:"strategy" binding:"required,oneof=avalanche snowball"`
+	ExtraMonthlyPayment float64 `json:"extra_monthly_payment" binding:"min=0"`
+}
+
+func getDebtPlan(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	plan, err := database.GetDebtPlan(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"debt_plan": nil,
+		})
+		return
+	}
+	debts, err := database.GetDebts(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get debts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get debts",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"debt_plan": plan,
+		"debts":     debts,
+	})
+}
+
+// POST /debt-plan
+// INPUT:
+//
+//	{
+//		"strategy": "avalanche",
+//		"extra_monthly_payment": 200
+//	}
+func createDebtPlan(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request DebtPlanRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	debts, err := database.GetDebts(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get debts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get debts",
+		})
+		return
+	}
+	// Pull liabilities the first time a plan is made, after that the worker keeps them fresh
+	if len(debts) == 0 {
+		if err := plaid.SyncLiabilities(c.Request.Context(), userIdInt); err != nil {
+			log.Printf("Failed to sync liabilities: %v", err)
+		}
+		debts, err = database.GetDebts(userIdInt)
+		if err != nil {
+			log.Printf("Failed to get debts: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get debts",
+			})
+			return
+		}
+	}
+	if len(debts) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "No debts found for linked accounts",
+		})
+		return
+	}
+
+	plan := database.BuildDebtPlan(userIdInt, debts, request.Strategy, request.ExtraMonthlyPayment, time.Now())
+	if err := database.UpsertDebtPlan(plan); err != nil {
+		log.Printf("Failed to save debt plan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save debt plan",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"debt_plan": plan,
+		"debts":     debts,
+	})
+}
+
+// ** TRANSACTIONS **
+func processDailyBalance(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	monthYear := GetCurrentMonthYear()
+	if monthYearFromPayload, exists := payload["month_year"]; exists {
+		monthYear = int(monthYearFromPayload.(float64))
+	}
+
+	// Requests for the same user and month within the debounce window share one job
+	jobID, coalesced, err := enqueueDailyBalance(c.Request.Context(), userIdInt, monthYear)
+	if err != nil {
+		log.Printf("Failed to enqueue job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to enqueue job",
+		})
+		return
+	}
+	if coalesced {
+		log.Printf("Daily balance job for user %d already queued: %s", userIdInt, jobID)
+	} else {
+		log.Printf("Successfully enqueued transaction processing job for user %d", userIdInt)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Successfully enqueued transaction processing job for user " + strconv.Itoa(userIdInt),
+		"job_id":    jobID,
+		"coalesced": coalesced,
+	})
+}
+
+func processDailyBalanceSync(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	monthYear := GetCurrentMonthYear()
+	if monthYearFromPayload, exists := payload["month_year"]; exists {
+		monthYear = int(monthYearFromPayload.(float64))
+	}
+
+	allAccountsSynced, err := database.GetAllAccountsSynced(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get all accounts synced",
+		})
+		return
+	}
+
+	// If not synced, poll every 2 seconds up to 5 times
+	if !allAccountsSynced {
+		maxAttempts := 5
+		attempt := 0
+
+		for attempt < maxAttempts {
+			time.Sleep(2 * time.Second) // Wait 2 seconds
+			attempt++
+
+			allAccountsSynced, err = database.GetAllAccountsSynced(userIdInt)
+			if err != nil {
+				log.Printf("Error checking accounts synced on attempt %d: %v", attempt, err)
+				continue
+			}
+
+			if allAccountsSynced {
+				log.Printf("All accounts synced after %d attempts", attempt)
+				break
+			}
+
+			log.Printf("Attempt %d: Accounts not yet synced, waiting...", attempt)
+		}
+
+		// If still not synced after all attempts, log it but continue
+		if !allAccountsSynced {
+			log.Printf("Warning: Accounts not synced after %d attempts", maxAttempts)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Accounts not synced after " + strconv.Itoa(maxAttempts) + " attempts",
+			})
+			return
+		}
+	}
+
+	// Requests for the same user and month within the debounce window share one job
+	jobID, coalesced, err := enqueueDailyBalance(c.Request.Context(), userIdInt, monthYear)
+	if err != nil {
+		log.Printf("Failed to enqueue job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to enqueue job",
+		})
+		return
+	}
+	if coalesced {
+		log.Printf("Daily balance job for user %d already queued: %s", userIdInt, jobID)
+	} else {
+		log.Printf("Successfully enqueued transaction processing job for user %d", userIdInt)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Successfully enqueued transaction processing job for user " + strconv.Itoa(userIdInt),
+		"job_id":    jobID,
+		"coalesced": coalesced,
+	})
+
+}
+
+// POST /transactions/by-category
+// INPUT:
+//
+//	{
+//	  "category": "Dining",
+//	  "month_year": 52025
+//	}
+//
+// Returns the month's transactions in a budget category, matched by category mapping, personal finance category
+// or the provider's own category whatever its casing, so Plaid, Teller and manual rows are all included. "general"
+// returns what no other budget category claims and "all" every transaction in the month.
+func getTransactionsByCategory(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var payload map[string]interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	category, _ := payload["category"].(string)
+	if category == "" {
+		category = "general"
+	}
+	monthYear := GetCurrentMonthYear()
+	if monthYearFromPayload, exists := payload["month_year"]; exists {
+		monthYearFloat, ok := monthYearFromPayload.(float64)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "month_year must be a number",
+			})
+			return
+		}
+		monthYear = int(monthYearFloat)
+	}
+
+	var transactions []database.Transaction
+	if category == "general" {
+		categoriesToExclude, err := database.GetCategoriesToExclude(userIdInt, monthYear)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get categories to exclude",
+			})
+			return
+		}
+		transactions, err = database.GetTransactionsExcludingCategories(userIdInt, categoriesToExclude, monthYear)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get transactions by category",
+			})
+			return
+		}
+	} else if category == "all" {
+		transactions, err = database.GetAllTransactions(userIdInt, monthYear)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get all transactions",
+			})
+			return
+		}
+	} else {
+		transactions, err = database.GetTransactionsByCategory(userIdInt, category, monthYear)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get transactions by category",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+	})
+}
+
+// POST /transactions/sync-plaid-accounts?history_months=12
+// Resyncs the user's Plaid accounts. history_months, from 1 to 24, backfills the accounts' history that far back
+// when it goes further than their sync history preference did; without it only recent transactions are fetched.
+func syncPlaidAccounts(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	jobData := map[string]interface{}{
+		"user_id": userIdInt,
+	}
+	if val, exists := c.GetQuery("history_months"); exists {
+		months, err := strconv.Atoi(val)
+		if err != nil || months < database.MinSyncHistoryMonths || months > database.MaxSyncHistoryMonths {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("history_months must be between %d and %d", database.MinSyncHistoryMonths, database.MaxSyncHistoryMonths),
+			})
+			return
+		}
+		jobData["history_months"] = months
+	}
+	enqueueJSON, err := jobs.MarshalEnqueueRequest("sync_plaid_accounts", jobData)
+	if err != nil {
+		log.Printf("Failed to marshal enqueue request: %v", err)
+	} else {
+		// Make HTTP request to background worker
+		resp, err := postWorkerEnqueue(c.Request.Context(), enqueueJSON)
+		if err != nil {
+			log.Printf("Failed to enqueue job: %v", err)
+		} else {
+			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				log.Printf("Successfully enqueued sync plaid accounts job for user %d", userIdInt)
+			} else {
+				log.Printf("Failed to enqueue job, status: %d", resp.StatusCode)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Successfully enqueued sync plaid accounts job for user " + strconv.Itoa(userIdInt),
+	})
+}
+
+func allAccountsSynced(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	allAccountsSynced, err := database.GetAllAccountsSynced(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get all accounts synced",
+		})
+		return
+	}
+
+	// If not synced, poll every 2 seconds up to 5 times
+	if !allAccountsSynced {
+		maxAttempts := 5
+		attempt := 0
+
+		for attempt < maxAttempts {
+			time.Sleep(2 * time.Second) // Wait 2 seconds
+			attempt++
+
+			allAccountsSynced, err = database.GetAllAccountsSynced(userIdInt)
+			if err != nil {
+				log.Printf("Error checking accounts synced on attempt %d: %v", attempt, err)
+				continue
+			}
+
+			if allAccountsSynced {
+				log.Printf("All accounts synced after %d attempts", attempt)
+				break
+			}
+
+			log.Printf("Attempt %d: Accounts not yet synced, waiting...", attempt)
+		}
+
+		// If still not synced after all attempts, log it but continue
+		if !allAccountsSynced {
+			log.Printf("Warning: Accounts not synced after %d attempts", maxAttempts)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"all_accounts_synced": allAccountsSynced,
+	})
+}
+
+// ** ANALYTICS **
+
+// percentChange returns the percent change from previous to current, or nil when there is no baseline
+func percentChange(current float64, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := (current - previous) / previous * 100
+	return &change
+}
+
+// GET /analytics/compare?monthyear=72025&against=3
+func getSpendingComparison(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
+	}
+	against := 1
+	if val, exists := c.GetQuery("against"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 || parsed > 12 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "against must be between 1 and 12",
+			})
+			return
+		}
+		against = parsed
+	}
 
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Monthly summary not found",
+		})
+		return
+	}
+	budgetCategories, _, err := database.GetMonthlyBudgetSpendCategories(userIdInt, monthlySummary.ID)
+	if err != nil {
+		log.Printf("Failed to get monthly budget spend categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get monthly budget spend categories",
+		})
+		return
+	}
+	spends, err := database.GetCategorySpendByMonth(userIdInt, monthYear, against)
+	if err != nil {
+		log.Printf("Failed to get category spend by month: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to compare spending",
+		})
+		return
+	}
+
+	spendByCategoryMonth := map[string]map[int]float64{}
+	for _, spend := range spends {
+		if spendByCategoryMonth[spend.Category] == nil {
+			spendByCategoryMonth[spend.Category] = map[int]float64{}
+		}
+		spendByCategoryMonth[spend.Category][spend.MonthYear] = spend.TotalSpent
+	}
+
+	monthStart := database.MonthYearStart(monthYear)
+	categories := []gin.H{}
+	for _, budgetCategory := range budgetCategories {
+		current := spendByCategoryMonth[budgetCategory.Category][monthYear]
+		previous := []gin.H{}
+		previousTotal := 0.0
+		lastMonth := 0.0
+		for i := 1; i <= against; i++ {
+			previousMonthYear := database.ToMonthYear(monthStart.AddDate(0, -i, 0))
+			spent := spendByCategoryMonth[budgetCategory.Category][previousMonthYear]
+			if i == 1 {
+				lastMonth = spent
+			}
+			previousTotal += spent
+			previous = append(previous, gin.H{
+				"monthyear":   previousMonthYear,
+				"total_spent": spent,
+			})
+		}
+		previousAverage := previousTotal / float64(against)
+
+		var budgetUsedPct *float64
+		if budgetCategory.Budget.IsPositive() {
+			used := current / budgetCategory.Budget.Float64() * 100
+			budgetUsedPct = &used
+		}
+		categories = append(categories, gin.H{
+			"category":                 budgetCategory.Category,
+			"budget":                   budgetCategory.Budget,
+			"total_spent":              current,
+			"previous":                 previous,
+			"previous_average":         previousAverage,
+			"change_vs_last_month_pct": percentChange(current, lastMonth),
+			"change_vs_average_pct":    percentChange(current, previousAverage),
+			"budget_used_pct":          budgetUsedPct,
+			"remaining_budget":         budgetCategory.Budget.Sub(money.FromFloat(current)),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"monthyear":  monthYear,
+		"against":    against,
+		"categories": categories,
+	})
+}
+
+// GET /analytics/categories?date[gte]=2025-07-01&date[lt]=2025-08-01&account_id=...&include_archived=true
+// Totals spend per category over the transactions matching the same filters as GET /transactions
+func getSpendByCategory(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	listQuery, err := ParseListQuery(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
+			"error": err.Error(),
+		})
+		return
+	}
+	spends, err := database.GetSpendByCategory(userIdInt, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get spend by category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get spend by category",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"categories": spends,
+	})
+}
+
+// GET /analytics/cities?date[gte]=2025-07-01&date[lt]=2025-08-01&country=CA&include_archived=true
+// Totals spend per city over the in store purchases matching the same filters as GET /transactions, for tracking
+// spending while travelling. lat and lon are the average position of the city's purchases, for plotting on a map.
+func getSpendByCity(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	listQuery, err := ParseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
+	spends, err := database.GetSpendByCity(userIdInt, listQuery)
+	var filterErr *database.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": filterErr.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get spend by city: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get spend by city",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"cities": spends,
+	})
+}
 
+// GET /analytics/heatmap?monthyear=72025
+// Total spend on each day of the month, for a spending heatmap. Every day is listed, and max_spent is the busiest
+// day's spend so the app can scale its colors.
+func getSpendingHeatmap(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
 	monthYear := GetCurrentMonthYear()
-	if monthYearFromPayload, exists := payload["month_year"]; exists {
-		monthYear = int(monthYearFromPayload.(float64))
+	if val, exists := c.GetQuery("monthyear"); exists {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed/10000 < 1 || parsed/10000 > 12 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "monthyear must be formatted as MMYYYY",
+			})
+			return
+		}
+		monthYear = parsed
+	}
+	days, err := database.GetDailySpend(userIdInt, monthYear)
+	if err != nil {
+		log.Printf("Failed to get daily spend: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get spending heatmap",
+		})
+		return
+	}
+	var total, maxSpent money.Money
+	for _, day := range days {
+		total = total.Add(day.TotalSpent)
+		if day.TotalSpent.Cmp(maxSpent) > 0 {
+			maxSpent = day.TotalSpent
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"monthyear":   monthYear,
+		"days":        days,
+		"total_spent": total,
+		"max_spent":   maxSpent,
+	})
+}
+
+// ** SYNC HEALTH **
+
+// syncStaleAfter is how long an account can go without a successful sync before it's shown as stale
+const syncStaleAfter = 48 * time.Hour
+
+// GET /sync/health
+// Per connection and account sync state for the connections screen: last successful sync, recent failures,
+// jobs still queued or running and the latest transaction date. States are healthy, pending (never synced),
+// syncing, stale (no sync in 48 hours) and error (the latest sync failed).
+func getSyncHealth(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	health, err := database.GetSyncHealth(userIdInt, syncStaleAfter)
+	if err != nil {
+		log.Printf("Failed to get sync health: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get sync health",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sync_health":  health,
+		"generated_at": time.Now(),
+	})
+}
+
+// ** SAFE TO SPEND **
+
+// GET /safe-to-spend?monthyear=72025
+// Safe to spend is the available balance less the fixed expenses still due before the next payday
+// and whatever is left of this month's savings target, a share of the user's income baseline.
+func getSafeToSpend(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	monthYear := GetCurrentMonthYear()
+	if val, exists := c.GetQuery("monthyear"); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			monthYear = parsed
+		}
 	}
 
 	monthlySummary, err := database.GetMonthlySummary(userIdInt, monthYear)
 	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Monthly summary not found",
+		})
+		return
+	}
+
+	// Prefer live balances from linked accounts, falling back to the monthly balance snapshot
+	availableBalance, accountCount, err := database.GetDepositoryAvailableBalance(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get available balance: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get or create monthly summary",
+			"error": "Failed to get available balance",
 		})
 		return
 	}
-	category := payload["category"].(string)
-	budget := payload["budget"].(float64)
+	balanceSource := "accounts"
+	if accountCount == 0 {
+		balanceSource = "monthly_balance"
+		availableBalance = money.Money{}
+		if monthlyBalance, err := database.GetMonthlyBalance(userIdInt, monthYear); err == nil {
+			availableBalance = monthlyBalance.AvailableBalance
+		}
+	}
 
-	// Check if a monthly budget spend category for this user, category, and monthYear already exists
-	existingCategory, err := database.GetMonthlyBudgetSpendCategory(userIdInt, monthlySummary.ID, monthYear, category)
-	if existingCategory != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Monthly budget spend category for this category already exists for this month",
+	incomeDates, err := database.GetRecentIncomeDates(userIdInt, 2)
+	if err != nil {
+		log.Printf("Failed to get income dates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to detect income",
 		})
 		return
 	}
+	now := time.Now()
+	nextPayday, paydayDetected := database.NextPayday(incomeDates, now)
+
+	// Fixed expenses are tracked as a monthly total, so spread them evenly over the month
+	// and count the share falling between today and payday
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	daysInMonth := database.DaysInPeriod(monthStart, monthStart.AddDate(0, 1, 0))
+	daysUntilPayday := database.DaysInPeriod(today, nextPayday)
+	upcomingFixedExpenses := monthlySummary.FixedExpenses
+	if daysUntilPayday < daysInMonth {
+		upcomingFixedExpenses = monthlySummary.FixedExpenses.Prorate(int64(daysUntilPayday), int64(daysInMonth))
+	}
 
-	monthlyBudgetSpendCategory, err := database.CreateMonthlyBudgetSpendCategory(userIdInt, monthlySummary.ID, monthYear, category, budget)
+	incomeBaseline, err := database.GetIncomeBaseline(userIdInt, *monthlySummary)
 	if err != nil {
+		log.Printf("Failed to get income baseline: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create monthly budget spend category",
+			"error": "Failed to get income baseline",
 		})
 		return
 	}
+	committedSavings := incomeBaseline.Amount.Mul(monthlySummary.SavingTargetPercentage / 100).Sub(monthlySummary.SavedAmount)
+	if committedSavings.IsNegative() {
+		committedSavings = money.Money{}
+	}
+
+	safeToSpend := availableBalance.Sub(upcomingFixedExpenses).Sub(committedSavings)
+	dailySafeToSpend := safeToSpend
+	if daysUntilPayday > 0 {
+		dailySafeToSpend = safeToSpend.Prorate(1, int64(daysUntilPayday))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"monthly_budget_spend_category": monthlyBudgetSpendCategory,
+		"safe_to_spend":           safeToSpend,
+		"daily_safe_to_spend":     dailySafeToSpend,
+		"available_balance":       availableBalance,
+		"balance_source":          balanceSource,
+		"upcoming_fixed_expenses": upcomingFixedExpenses,
+		"committed_savings":       committedSavings,
+		"income_baseline":         incomeBaseline,
+		"next_payday":             nextPayday.Format("2006-01-02"),
+		"payday_detected":         paydayDetected,
+		"days_until_payday":       daysUntilPayday,
 	})
 }
 
-// ** SAVING GOALS **
+// ** CALENDAR **
 
-func getSavingGoals(c *gin.Context) {
+// GET /calendar?month=2025-07
+// Bills, fixed expenses, planned expenses, income and savings contributions for a month as calendar events,
+// defaulting to the current month. Past events come from the user's history and upcoming ones are projected.
+func getCalendar(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	savingsGoals, err := database.GetSavingsGoals(userIdInt)
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if val := c.Query("month"); val != "" {
+		parsed, err := time.Parse("2006-01", val)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "month must be formatted as YYYY-MM",
+			})
+			return
+		}
+		monthStart = parsed
+	}
+	events, err := database.BuildCalendar(userIdInt, monthStart, now)
 	if err != nil {
+		log.Printf("Failed to build calendar: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get savings goals",
+			"error": "Failed to build calendar",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"savings_goals": savingsGoals,
+		"month":  monthStart.Format("2006-01"),
+		"events": events,
 	})
 }
 
-func createSavingGoal(c *gin.Context) {
+// ** CATEGORY MAPPINGS **
+
+// CategoryMappingRequest maps a provider category onto a budget category
+type CategoryMappingRequest struct {
+	Provider         string `json:"provider" binding:"required,oneof=plaid teller"`
+	ProviderCategory string `json:"provider_category" binding:"required"`
+	BudgetCategory   string `json:"budget_category" binding:"required"`
+}
 
+func getCategoryMappings(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
+	mappings, err := database.GetCategoryMappings(userIdInt)
+	if err != nil {
+		log.Printf("Failed to get category mappings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get category mappings",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"category_mappings": mappings,
+	})
+}
 
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+func upsertCategoryMapping(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	var request CategoryMappingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
+			"error":   "Invalid request body",
+			"details": err.Error(),
 		})
 		return
 	}
+	mapping, err := database.UpsertCategoryMapping(&userIdInt, request.Provider, request.ProviderCategory, request.BudgetCategory)
+	if err != nil {
+		log.Printf("Failed to upsert category mapping: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save category mapping",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"category_mapping": mapping,
+	})
+}
 
-	name := payload["name"].(string)
-	totalAmount := payload["total"].(float64)
-	currentSaved := 0.0
+func deleteCategoryMapping(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	mappingID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid category mapping id",
+		})
+		return
+	}
+	if err := database.DeleteCategoryMapping(&userIdInt, mappingID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Category mapping not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Category mapping deleted",
+	})
+}
+
+func upsertGlobalCategoryMapping(c *gin.Context) {
+	if err := AdminMiddleware(c); err != nil {
+		return // AdminMiddleware already sent the response
+	}
+	var request CategoryMappingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	mapping, err := database.UpsertCategoryMapping(nil, request.Provider, request.ProviderCategory, request.BudgetCategory)
+	if err != nil {
+		log.Printf("Failed to upsert global category mapping: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save category mapping",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"category_mapping": mapping,
+	})
+}
+
+func deleteGlobalCategoryMapping(c *gin.Context) {
+	if err := AdminMiddleware(c); err != nil {
+		return // AdminMiddleware already sent the response
+	}
+	mappingID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid category mapping id",
+		})
+		return
+	}
+	if err := database.DeleteCategoryMapping(nil, mappingID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Category mapping not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Category mapping deleted",
+	})
+}
+
+// ** TAX CATEGORIES **
+
+// TaxCategoryRequest designates a budget category or Plaid personal finance category as tax relevant
+type TaxCategoryRequest struct {
+	Category string `json:"category" binding:"required,max=255"`
+	TaxType  string `json:"tax_type" binding:"required,oneof=donations medical business none"`
+}
 
-	savingsGoal, err := database.CreateSavingsGoal(userIdInt, name, totalAmount, currentSaved)
+// GET /tax-categories
+// Returns the user's tax categories followed by the global defaults, which the user's own override
+func getTaxCategories(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
+	}
+	categories, err := database.GetTaxCategories(userIdInt)
 	if err != nil {
+		log.Printf("Failed to get tax categories: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create savings goal",
+			"error": "Failed to get tax categories",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"savings_goal": savingsGoal,
+		"tax_categories": categories,
 	})
 }
 
-// ** TRANSACTIONS **
-func processDailyBalance(c *gin.Context) {
+// POST /tax-categories
+// INPUT:
+//
+//	{
+//		"category": "Charity",
+//		"tax_type": "donations"
+//	}
+//
+// tax_type none leaves a category out of the tax report even when a global default includes it
+func upsertTaxCategory(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	var request TaxCategoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
+			"error":   "Invalid request body",
+			"details": err.Error(),
 		})
 		return
 	}
-	monthYear := GetCurrentMonthYear()
-	if monthYearFromPayload, exists := payload["month_year"]; exists {
-		monthYear = int(monthYearFromPayload.(float64))
-	}
-
-	jobData := map[string]interface{}{
-		"user_id":    userIdInt,
-		"month_year": monthYear,
-	}
-
-	enqueueRequest := map[string]interface{}{
-		"type": "process_daily_balance",
-		"data": jobData,
-	}
-
-	enqueueJSON, err := json.Marshal(enqueueRequest)
+	category, err := database.UpsertTaxCategory(userIdInt, strings.TrimSpace(request.Category), request.TaxType)
 	if err != nil {
-		log.Printf("Failed to marshal enqueue request: %v", err)
+		log.Printf("Failed to upsert tax category: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to marshal enqueue request",
+			"error": "Failed to save tax category",
 		})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"tax_category": category,
+	})
+}
 
-	// Make HTTP request to background worker
-	resp, err := http.Post(workerUrl+"/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
-	// resp, err := http.Post("http://worker:8081/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
+func deleteTaxCategory(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
-		log.Printf("Failed to enqueue job: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to enqueue job",
+		return // AuthMiddleware already sent the response
+	}
+	taxCategoryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid tax category id",
 		})
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
-		log.Printf("Successfully enqueued transaction processing job for user %d", userIdInt)
-	} else {
-		log.Printf("Failed to enqueue job, status: %d", resp.StatusCode)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to enqueue job",
+	if err := database.DeleteTaxCategory(userIdInt, taxCategoryID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Tax category not found",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Successfully enqueued transaction processing job for user " + strconv.Itoa(userIdInt),
+		"message": "Tax category deleted",
 	})
 }
 
-func processDailyBalanceSync(c *gin.Context) {
+// ** LEDGER EXPORT **
+
+// maxLedgerExportYears bounds how much history one ledger export covers
+const maxLedgerExportYears = 10
+
+// LedgerAccountMappingRequest maps one of the user's accounts, by id, or categories, by name, to a ledger account
+type LedgerAccountMappingRequest struct {
+	Kind          string `json:"kind" binding:"required,oneof=account category"`
+	Source        string `json:"source" binding:"required,max=255"`
+	LedgerAccount string `json:"ledger_account" binding:"required,max=255"`
+}
+
+// GET /export/ledger?from=2025-01-01&to=2025-12-31&format=beancount
+// Returns the user's posted transactions between from and to, and their accounts' balances at either end, in
+// beancount or ledger syntax. format is beancount or ledger, defaulting to beancount. The range defaults to the
+// year so far. Accounts and categories post to the ledger accounts set with /export/ledger/mappings.
+func exportLedger(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	format := c.DefaultQuery("format", reports.LedgerFormatBeancount)
+	if format != reports.LedgerFormatBeancount && format != reports.LedgerFormatLedger {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-		})
-		return
-	}
-	monthYear := GetCurrentMonthYear()
-	if monthYearFromPayload, exists := payload["month_year"]; exists {
-		monthYear = int(monthYearFromPayload.(float64))
-	}
-
-	allAccountsSynced, err := database.GetAllAccountsSynced(userIdInt)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get all accounts synced",
+			"error": "format must be beancount or ledger",
 		})
 		return
 	}
-
-	// If not synced, poll every 2 seconds up to 5 times
-	if !allAccountsSynced {
-		maxAttempts := 5
-		attempt := 0
-
-		for attempt < maxAttempts {
-			time.Sleep(2 * time.Second) // Wait 2 seconds
-			attempt++
-
-			allAccountsSynced, err = database.GetAllAccountsSynced(userIdInt)
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	for param, date := range map[string]*time.Time{"from": &from, "to": &to} {
+		if val, exists := c.GetQuery(param); exists {
+			parsed, err := time.Parse("2006-01-02", val)
 			if err != nil {
-				log.Printf("Error checking accounts synced on attempt %d: %v", attempt, err)
-				continue
-			}
-
-			if allAccountsSynced {
-				log.Printf("All accounts synced after %d attempts", attempt)
-				break
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Invalid %s, use YYYY-MM-DD", param),
+				})
+				return
 			}
-
-			log.Printf("Attempt %d: Accounts not yet synced, waiting...", attempt)
-		}
-
-		// If still not synced after all attempts, log it but continue
-		if !allAccountsSynced {
-			log.Printf("Warning: Accounts not synced after %d attempts", maxAttempts)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Accounts not synced after " + strconv.Itoa(maxAttempts) + " attempts",
-			})
-			return
+			*date = parsed
 		}
 	}
-
-	jobData := map[string]interface{}{
-		"user_id":    userIdInt,
-		"month_year": monthYear,
-	}
-
-	enqueueRequest := map[string]interface{}{
-		"type": "process_daily_balance",
-		"data": jobData,
-	}
-
-	enqueueJSON, err := json.Marshal(enqueueRequest)
-	if err != nil {
-		log.Printf("Failed to marshal enqueue request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to marshal enqueue request",
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "to must not be before from",
 		})
 		return
 	}
-
-	// Make HTTP request to background worker
-	resp, err := http.Post(workerUrl+"/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
-	// resp, err := http.Post("http://worker:8081/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
-	if err != nil {
-		log.Printf("Failed to enqueue job: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to enqueue job",
+	if from.AddDate(maxLedgerExportYears, 0, 0).Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("A ledger export can cover at most %d years", maxLedgerExportYears),
 		})
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
-		log.Printf("Successfully enqueued transaction processing job for user %d", userIdInt)
-	} else {
-		log.Printf("Failed to enqueue job, status: %d", resp.StatusCode)
+
+	fail := func(err error) {
+		log.Printf("Failed to export ledger: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to enqueue job",
+			"error": "Failed to export ledger",
 		})
+	}
+	end := to.AddDate(0, 0, 1)
+	export := reports.LedgerExport{Format: format, From: from, To: to}
+	if export.Accounts, err = database.GetLedgerAccounts(userIdInt); err != nil {
+		fail(err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Successfully enqueued transaction processing job for user " + strconv.Itoa(userIdInt),
-	})
-
-}
-
-func getTransactionsByCategory(c *gin.Context) {
-	userIdInt, err := AuthMiddleware(c)
-	if err != nil {
-		return // AuthMiddleware already sent the response
+	if export.Transactions, err = database.GetLedgerTransactions(userIdInt, from, end); err != nil {
+		fail(err)
+		return
 	}
-	var payload map[string]interface{}
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-		})
+	if export.Mappings, err = database.GetLedgerAccountMappings(userIdInt); err != nil {
+		fail(err)
 		return
 	}
-	category := payload["category"].(string)
-	if category == "" {
-		category = "general"
+	if export.ActivityFrom, err = database.GetLedgerActivitySince(userIdInt, from); err != nil {
+		fail(err)
+		return
 	}
-	monthYear := GetCurrentMonthYear()
-	if monthYearFromPayload, exists := payload["month_year"]; exists {
-		monthYear = int(monthYearFromPayload.(float64))
+	if export.ActivityAfter, err = database.GetLedgerActivitySince(userIdInt, end); err != nil {
+		fail(err)
+		return
 	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"watson-%s-%s.%s\"", from.Format("2006-01-02"), to.Format("2006-01-02"), format))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", reports.RenderLedger(export))
+}
 
-	var transactions []database.Transaction
-	if category == "general" {
-		categoriesToExclude, err := database.GetCategoriesToExclude(userIdInt, monthYear)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to get categories to exclude",
-			})
-		}
-		transactions, err = database.GetTransactionsExcludingCategories(userIdInt, categoriesToExclude, monthYear)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to get transactions by category",
-			})
-		}
-	} else if category == "all" {
-		transactions, err = database.GetAllTransactions(userIdInt, monthYear)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to get all transactions",
-			})
-		}
-	} else {
-		transactions, err = database.GetTransactionsByCategory(userIdInt, category, monthYear)
+// GET /export/ledger/mappings
+// Returns the ledger accounts the user mapped their accounts and categories to
+func getLedgerAccountMappings(c *gin.Context) {
+	userIdInt, err := AuthMiddleware(c)
+	if err != nil {
+		return // AuthMiddleware already sent the response
 	}
-
+	mappings, err := database.GetLedgerAccountMappings(userIdInt)
 	if err != nil {
+		log.Printf("Failed to get ledger account mappings: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get transactions by category",
+			"error": "Failed to get ledger account mappings",
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"transactions": transactions,
+		"mappings": mappings,
 	})
 }
 
-func syncPlaidAccounts(c *gin.Context) {
+// PUT /export/ledger/mappings
+// INPUT:
+//
+//	{
+//		"kind": "category",
+//		"source": "Loan Payments",
+//		"ledger_account": "Liabilities:Mortgage"
+//	}
+//
+// source is an account id for kind account, and a category as reports label it for kind category
+func upsertLedgerAccountMapping(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	jobData := map[string]interface{}{
-		"user_id": userIdInt,
+	var request LedgerAccountMappingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
 	}
-	enqueueRequest := map[string]interface{}{
-		"type": "sync_plaid_accounts",
-		"data": jobData,
+	if !reports.IsValidLedgerAccount(request.LedgerAccount) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "ledger_account must start with Assets, Liabilities, Equity, Income or Expenses, followed by colon separated names starting with a capital letter or digit, like Expenses:Food",
+		})
+		return
 	}
-
-	enqueueJSON, err := json.Marshal(enqueueRequest)
+	mapping, err := database.UpsertLedgerAccountMapping(userIdInt, request.Kind, strings.TrimSpace(request.Source), request.LedgerAccount)
 	if err != nil {
-		log.Printf("Failed to marshal enqueue request: %v", err)
-	} else {
-		// Make HTTP request to background worker
-		resp, err := http.Post(workerUrl+"/enqueue", "application/json", bytes.NewBuffer(enqueueJSON))
-		if err != nil {
-			log.Printf("Failed to enqueue job: %v", err)
-		} else {
-			defer resp.Body.Close()
-			if resp.StatusCode == http.StatusOK {
-				log.Printf("Successfully enqueued sync plaid accounts job for user %d", userIdInt)
-			} else {
-				log.Printf("Failed to enqueue job, status: %d", resp.StatusCode)
-			}
-		}
+		log.Printf("Failed to upsert ledger account mapping: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save ledger account mapping",
+		})
+		return
 	}
-
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Successfully enqueued sync plaid accounts job for user " + strconv.Itoa(userIdInt),
+		"mapping": mapping,
 	})
 }
 
-func allAccountsSynced(c *gin.Context) {
+// DELETE /export/ledger/mappings/:id
+func deleteLedgerAccountMapping(c *gin.Context) {
 	userIdInt, err := AuthMiddleware(c)
 	if err != nil {
 		return // AuthMiddleware already sent the response
 	}
-	allAccountsSynced, err := database.GetAllAccountsSynced(userIdInt)
+	mappingID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get all accounts synced",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ledger account mapping id",
 		})
 		return
 	}
-
-	// If not synced, poll every 2 seconds up to 5 times
-	if !allAccountsSynced {
-		maxAttempts := 5
-		attempt := 0
-
-		for attempt < maxAttempts {
-			time.Sleep(2 * time.Second) // Wait 2 seconds
-			attempt++
-
-			allAccountsSynced, err = database.GetAllAccountsSynced(userIdInt)
-			if err != nil {
-				log.Printf("Error checking accounts synced on attempt %d: %v", attempt, err)
-				continue
-			}
-
-			if allAccountsSynced {
-				log.Printf("All accounts synced after %d attempts", attempt)
-				break
-			}
-
-			log.Printf("Attempt %d: Accounts not yet synced, waiting...", attempt)
-		}
-
-		// If still not synced after all attempts, log it but continue
-		if !allAccountsSynced {
-			log.Printf("Warning: Accounts not synced after %d attempts", maxAttempts)
-		}
+	if err := database.DeleteLedgerAccountMapping(userIdInt, mappingID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Ledger account mapping not found",
+		})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"all_accounts_synced": allAccountsSynced,
+		"message": "Ledger account mapping deleted",
 	})
 }
 
@@ -1172,6 +7098,11 @@ func main() {
 		log.Printf("Warning: .env file not found, using environment variables or defaults")
 	}
 
+	shutdownTracing := telemetry.Init("watson-api")
+	defer shutdownTracing(context.Background())
+	errorreport.Init("watson-api")
+	defer errorreport.Flush(2 * time.Second)
+
 	// Get database connection string from environment variable
 	dbConnStr := os.Getenv("DATABASE_URL")
 	if dbConnStr == "" {
@@ -1183,41 +7114,77 @@ func main() {
 		workerUrl = "http://localhost:8081"
 	}
 
+	InitRateLimiter()
+	authLimit := RateLimitByIP(GetRateLimit("auth", 10, time.Minute))
+	analyticsLimit := RateLimitByUser(GetRateLimit("analytics", 30, time.Minute))
+
 	plaid.InitPlaid()
+	stripe.InitStripe()
+	googlesheets.InitGoogleSheets()
 	// Initialize shared database connection
 	if err := database.InitDB(dbConnStr); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.CloseDB()
+	if replicaConnStr := os.Getenv("DATABASE_REPLICA_URL"); replicaConnStr != "" {
+		if err := database.InitReadReplica(replicaConnStr); err != nil {
+			log.Printf("Failed to initialize read replica, reading from primary: %v", err)
+		}
+	}
 
-	router := gin.Default()
+	router := gin.New()
+	// The client IP keys the auth rate limits and the audit log, so forwarded headers from anyone else are ignored
+	if err := router.SetTrustedProxies(GetTrustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router.Use(gin.Logger(), RecoveryMiddleware())
 
 	// Add CORS middleware
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: false, // Must be false when AllowOrigins is "*"
 	}))
 
+	// Trace every request, continuing any trace started by the caller
+	router.Use(otelgin.Middleware("watson-api"))
+
 	router.GET("/validate-jwt", validateJWT)
 	// Routes
-	router.POST("/register", register)
+	router.POST("/register", authLimit, register)
 	router.GET("/users/", getUser)
 	router.GET("/balances", getBalance)
-	router.POST("/login", login)
+	router.POST("/login", authLimit, login)
+	router.POST("/auth/change-password", authLimit, changePassword)
+	router.POST("/auth/change-email", authLimit, changeEmail)
+	router.POST("/account/deactivate", authLimit, deactivateAccount)
+	router.POST("/account/reactivate", authLimit, reactivateAccount)
 
 	// User
 	router.GET("/user/is-new", isNewUser)
+	router.GET("/onboarding", getOnboarding)
 
 	// Bank
 	router.GET("/bank-link", genereateBankLink)
 	router.POST("/bank-link-teller/success", handleTellerSuccess)
+	router.GET("/teller/enrollments", getTellerEnrollments)
+	router.POST("/teller/enrollments/:id/relink", relinkTellerEnrollment)
+	router.DELETE("/teller/institutions/:id", deleteTellerInstitution)
 	router.GET("/create-link-token", createLinkToken)
 	router.POST("/bank-link-plaid/success", handlePlaidSuccess)
 	router.GET("/plaid/transactions", getPlaidTransactions)
 	router.GET("/plaid/accounts", getPlaidAccounts)
+	router.POST("/plaid/webhook", plaidWebhook)
+	router.GET("/accounts", listAccounts)
+	router.GET("/accounts/:id/transactions", listAccountTransactions)
+	router.PATCH("/accounts/:id", updateAccount)
+	router.PUT("/accounts/:provider/:id/settings", updateAccountSettings)
+	router.GET("/transfers", listTransferMatches)
+	router.POST("/transfers/:id/review", reviewTransferMatch)
+	router.GET("/investment-contributions", listInvestmentContributions)
+	router.POST("/investment-contributions/:id/review", reviewInvestmentContribution)
 	// Monthly Summary
 	router.GET("/monthly-summary", getMonthlySummaryOrEmpty)
 	router.GET("/monthly-summary/has-any", hasAnyMonthlySummaries)
@@ -1230,17 +7197,169 @@ func main() {
 
 	// Monthly Budget Spend Category
 	router.POST("/monthly-budget-spend-category", createMonthlyBudgetSpendCategory)
+	router.PATCH("/monthly-budget-spend-category/:id", updateMonthlyBudgetSpendCategory)
+	router.POST("/budget/import", importBudget)
+	router.GET("/monthly-budget-spend-category/simulate", analyticsLimit, simulateBudgetAllowances)
+	router.GET("/budget/pacing", analyticsLimit, getBudgetPacing)
+	router.GET("/budget/suggestions", analyticsLimit, getBudgetSuggestions)
+	router.GET("/budget/categories/:id", analyticsLimit, getBudgetCategoryDetail)
 
 	// Transactions
+	router.GET("/transactions", analyticsLimit, listTransactions)
+	router.GET("/transactions/search", analyticsLimit, searchTransactions)
 	router.POST("/transactions/process-daily-balance", processDailyBalance)
-	router.POST("/transactions/process-daily-balance/sync", processDailyBalanceSync)
-	router.POST("/transactions/by-category", getTransactionsByCategory)
+	router.POST("/transactions/process-daily-balance/sync", analyticsLimit, processDailyBalanceSync)
+	router.POST("/transactions/by-category", analyticsLimit, getTransactionsByCategory)
 	router.POST("/transactions/sync-plaid-accounts", syncPlaidAccounts)
 	router.GET("/transactions/all-accounts-synced", allAccountsSynced)
+	router.GET("/transactions/flagged", listTransactionFlags)
+	router.POST("/transactions/:id/flag", flagTransaction)
+	router.POST("/transactions/:id/flag/resolve", resolveTransactionFlag)
+	router.PUT("/transactions/:id/business", setTransactionBusiness)
+	router.PUT("/transactions/:id/notes", setTransactionNotes)
+
+	// Analytics
+	router.GET("/analytics/compare", analyticsLimit, getSpendingComparison)
+	router.GET("/analytics/categories", analyticsLimit, getSpendByCategory)
+	router.GET("/analytics/cities", analyticsLimit, getSpendByCity)
+	router.GET("/analytics/heatmap", analyticsLimit, getSpendingHeatmap)
+
+	// Sync Health
+	router.GET("/sync/health", getSyncHealth)
+
+	// Safe to Spend
+	router.GET("/safe-to-spend", analyticsLimit, getSafeToSpend)
+
+	// Calendar
+	router.GET("/calendar", analyticsLimit, getCalendar)
+
+	// Category Mappings
+	router.GET("/category-mappings", getCategoryMappings)
+	router.POST("/category-mappings", upsertCategoryMapping)
+	router.DELETE("/category-mappings/:id", deleteCategoryMapping)
+
+	// Tax Categories
+	router.GET("/tax-categories", getTaxCategories)
+	router.POST("/tax-categories", upsertTaxCategory)
+	router.DELETE("/tax-categories/:id", deleteTaxCategory)
+
+	// Admin
+	router.POST("/admin/category-mappings", upsertGlobalCategoryMapping)
+	router.DELETE("/admin/category-mappings/:id", deleteGlobalCategoryMapping)
+	router.GET("/admin/dead-letters", getDeadLetters)
+	router.POST("/admin/dead-letters/replay", replayDeadLetters)
+	router.POST("/admin/dead-letters/:id/replay", replayDeadLetterByID)
+	router.POST("/admin/jobs/:run_id/replay", replayJobRun)
 
 	//Saving Goals
 	router.GET("/saving-goals", getSavingGoals)
 	router.POST("/saving-goal", createSavingGoal)
+	router.POST("/saving-goal/:id/contribution", addSavingGoalContribution)
+	router.POST("/saving-goal/:id/archive", archiveSavingGoal)
+	router.POST("/saving-goal/:id/redeem", redeemSavingGoal)
+	router.POST("/saving-goal/:id/emergency-fund", setSavingGoalEmergencyFund)
+
+	// Planned Expenses
+	router.GET("/planned-expenses", getPlannedExpenses)
+	router.POST("/planned-expenses", createPlannedExpense)
+	router.PATCH("/planned-expenses/:id", updatePlannedExpense)
+	router.DELETE("/planned-expenses/:id", deletePlannedExpense)
+
+	// Trips
+	router.GET("/trips", getTrips)
+	router.POST("/trips", createTrip)
+	router.PATCH("/trips/:id", updateTrip)
+	router.DELETE("/trips/:id", deleteTrip)
+	router.GET("/trips/:id/transactions", analyticsLimit, listTripTransactions)
+	router.GET("/trips/:id/summary", analyticsLimit, getTripSummary)
+
+	// Debt Plan
+	router.GET("/debt-plan", getDebtPlan)
+	router.POST("/debt-plan", createDebtPlan)
+
+	// Reports
+	router.GET("/reports/:monthyear", analyticsLimit, getMonthlyReport)
+	router.GET("/reports/tax", analyticsLimit, getTaxReport)
+	router.GET("/exports/business-expenses", analyticsLimit, exportBusinessExpenses)
+	router.GET("/export/ledger", analyticsLimit, exportLedger)
+	router.GET("/export/ledger/mappings", getLedgerAccountMappings)
+	router.PUT("/export/ledger/mappings", upsertLedgerAccountMapping)
+	router.DELETE("/export/ledger/mappings/:id", deleteLedgerAccountMapping)
+
+	// Subscriptions
+	router.GET("/subscription", getSubscription)
+	router.POST("/stripe/checkout-session", createStripeCheckoutSession)
+	router.POST("/stripe/webhook", stripeWebhook)
+
+	// Demo
+	router.POST("/demo/seed", seedDemoData)
+
+	// Sandbox
+	router.POST("/sandbox/plaid/fire-webhook", fireSandboxPlaidWebhook)
+	router.POST("/sandbox/plaid/transactions/refresh", refreshSandboxPlaidTransactions)
+
+	// Notifications
+	router.GET("/notifications", getNotifications)
+	router.GET("/notifications/unread-count", getUnreadNotificationCount)
+	router.POST("/notifications/read-all", markAllNotificationsRead)
+	router.POST("/notifications/:id/read", markNotificationRead)
+
+	// Notification Channels
+	router.GET("/notification-channels", getNotificationChannels)
+	router.POST("/notification-channels", createNotificationChannel)
+	router.DELETE("/notification-channels/:id", deleteNotificationChannel)
+
+	// Webhooks
+	router.GET("/webhooks", getWebhookEndpoints)
+	router.POST("/webhooks", createWebhookEndpoint)
+	router.DELETE("/webhooks/:id", deleteWebhookEndpoint)
+	router.GET("/webhooks/deliveries", getWebhookDeliveries)
+	router.POST("/webhooks/deliveries/:id/redeliver", redeliverWebhook)
+
+	// Google Sheets
+	router.GET("/integrations/google-sheets", getGoogleSheetsConnection)
+	router.POST("/integrations/google-sheets/authorize", authLimit, authorizeGoogleSheets)
+	router.GET("/integrations/google-sheets/callback", authLimit, googleSheetsCallback)
+	router.PUT("/integrations/google-sheets/spreadsheet", setGoogleSheetsSpreadsheet)
+	router.DELETE("/integrations/google-sheets", deleteGoogleSheetsConnection)
+
+	// History Imports
+	router.GET("/imports", getHistoryImports)
+	router.GET("/imports/:id", getHistoryImport)
+	router.POST("/imports", createHistoryImport)
+
+	// API Keys
+	router.GET("/api-keys", getAPIKeys)
+	router.POST("/api-keys", createAPIKey)
+	router.DELETE("/api-keys/:id", revokeAPIKey)
+
+	// Advisor Access
+	router.GET("/advisor-access", getAdvisorAccess)
+	router.POST("/advisor-access", createAdvisorAccess)
+	router.DELETE("/advisor-access/:id", revokeAdvisorAccess)
+	router.POST("/advisor-access/redeem", authLimit, redeemAdvisorAccess)
+
+	// Preferences
+	router.GET("/preferences", getPreferences)
+	router.PUT("/preferences", updatePreferences)
+	router.GET("/unsubscribe", unsubscribeDigest)
+	router.POST("/unsubscribe", unsubscribeDigest)
+
+	// Household
+	router.GET("/household", getHousehold)
+	router.POST("/household", createHousehold)
+	router.POST("/household/join", joinHousehold)
+	router.DELETE("/household/membership", leaveHousehold)
+	router.PUT("/household/categories", setHouseholdCategory)
+	router.DELETE("/household/categories/:category", deleteHouseholdCategory)
+
+	// Dashboard
+	router.GET("/dashboard-config", getDashboardConfig)
+	router.PUT("/dashboard-config", updateDashboardConfig)
+
+	// Round Ups
+	router.GET("/round-ups", getRoundUps)
+	router.PUT("/round-ups/settings", updateRoundUpSettings)
 	// Health check
 	router.GET("/health", healthCheck)
 
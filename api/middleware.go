@@ -1,9 +1,17 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"time"
+	"watson/database"
+	"watson/errorreport"
 
 	"github.com/gin-gonic/gin"
 )
@@ -36,8 +44,12 @@ func AuthMiddleware(c *gin.Context) (int, error) {
     tokenString := authHeader[7:] // Remove "Bearer " prefix
     log.Printf("AuthMiddleware: Token extracted (first 20 chars): %s...", tokenString[:min(len(tokenString), 20)])
     
+	if strings.HasPrefix(tokenString, apiKeyPrefix) {
+		return authenticateAPIKey(c, tokenString)
+	}
+
     // Verify JWT and extract user ID
-    tokenUserID, err := VerifyJWT(tokenString)
+    claims, err := VerifyJWT(tokenString)
     if err != nil {
         log.Printf("AuthMiddleware: JWT verification failed: %v", err)
         c.JSON(http.StatusUnauthorized, gin.H{
@@ -47,7 +59,38 @@ func AuthMiddleware(c *gin.Context) (int, error) {
         })
         return -1, errors.New("invalid or expired token")
     }
-    
+	tokenUserID, tokenSessionVersion := claims.UserID, claims.SessionVersion
+
+	// Changing a password or email bumps the user's session version, signing out tokens issued before it
+	sessionVersion, err := database.GetSessionVersion(tokenUserID)
+	if err != nil {
+		log.Printf("AuthMiddleware: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired token",
+			"code":  "INVALID_TOKEN",
+		})
+		return -1, err
+	}
+	if tokenSessionVersion != sessionVersion {
+		log.Printf("AuthMiddleware: Token for user ID %d has been signed out", tokenUserID)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Session has been signed out, please log in again",
+			"code":  "SESSION_REVOKED",
+		})
+		return -1, errors.New("session revoked")
+	}
+	if claims.Scope == JWTScopeAdvisor {
+		return authorizeAdvisor(c, claims)
+	}
+	if claims.Scope != "" {
+		log.Printf("AuthMiddleware: Token for user ID %d has unknown scope %q", tokenUserID, claims.Scope)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired token",
+			"code":  "INVALID_TOKEN",
+		})
+		return -1, errors.New("unknown token scope")
+	}
+
     log.Printf("AuthMiddleware: Authentication successful for user ID: %d", tokenUserID)
     return tokenUserID, nil
 }
@@ -58,4 +101,220 @@ func min(a, b int) int {
         return a
     }
     return b
-}
\ No newline at end of file
+}
+// apiKeyPrefix starts every API key, telling them apart from JWTs in the Authorization header
+const apiKeyPrefix = "wat_"
+
+// apiKeyContextKey holds the *database.APIKey of requests authenticated with an API key
+const apiKeyContextKey = "api_key"
+
+// generateAPIKey returns a new random API key and the prefix shown to identify it
+func generateAPIKey() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+	return key, key[:len(apiKeyPrefix)+8], nil
+}
+
+// hashAPIKey returns the hex SHA-256 of a key, which is all that is stored. Keys are random enough that
+// a fast unsalted hash can't be brute forced.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIKey accepts an API key in place of a JWT. Keys with the read scope may make GET requests,
+// anything else needs the write scope.
+func authenticateAPIKey(c *gin.Context, key string) (int, error) {
+	apiKey, err := database.GetActiveAPIKeyByHash(hashAPIKey(key))
+	if err != nil {
+		log.Printf("AuthMiddleware: API key verification failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid, revoked or expired API key",
+			"code":  "INVALID_API_KEY",
+		})
+		return -1, errors.New("invalid api key")
+	}
+
+	scope := database.APIKeyScopeWrite
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		scope = database.APIKeyScopeRead
+	}
+	if !apiKey.HasScope(scope) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "API key is missing the " + scope + " scope",
+			"code":  "INSUFFICIENT_SCOPE",
+		})
+		return -1, errors.New("api key is missing the " + scope + " scope")
+	}
+
+	if err := database.TouchAPIKey(apiKey.ID); err != nil {
+		log.Printf("AuthMiddleware: %v", err)
+	}
+	c.Set(apiKeyContextKey, apiKey)
+	log.Printf("AuthMiddleware: Authentication successful for user ID %d with API key %d", apiKey.UserID, apiKey.ID)
+	return apiKey.UserID, nil
+}
+
+// advisorAccessContextKey holds the *database.AdvisorAccess of requests made by an advisor
+const advisorAccessContextKey = "advisor_access"
+
+// advisorCodePrefix starts the codes in advisor access links
+const advisorCodePrefix = "adv_"
+
+// generateAdvisorCode returns a new random code for an advisor access link. Codes are stored hashed like API keys.
+func generateAdvisorCode() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return advisorCodePrefix + hex.EncodeToString(secret), nil
+}
+
+// advisorRoutes are the routes an advisor may view: the user's accounts, summaries, reports and transactions.
+// Everything else, and any request other than GET, is refused to them.
+var advisorRoutes = map[string]bool{
+	"/balances":                  true,
+	"/accounts":                  true,
+	"/accounts/:id/transactions": true,
+	"/transfers":                 true,
+	"/investment-contributions":  true,
+	"/monthly-summary":           true,
+	"/monthly-summary/has-any":   true,
+	"/monthly-balance":           true,
+	"/monthly-balance/has-any":   true,
+	"/budget/pacing":             true,
+	"/budget/categories/:id":     true,
+	"/transactions":              true,
+	"/transactions/search":       true,
+	"/analytics/compare":         true,
+	"/analytics/categories":      true,
+	"/analytics/cities":          true,
+	"/analytics/heatmap":         true,
+	"/safe-to-spend":             true,
+	"/calendar":                  true,
+	"/saving-goals":              true,
+	"/planned-expenses":          true,
+	"/trips":                     true,
+	"/trips/:id/transactions":    true,
+	"/trips/:id/summary":         true,
+	"/debt-plan":                 true,
+	"/reports/:monthyear":        true,
+	"/reports/tax":               true,
+	"/exports/business-expenses": true,
+	"/export/ledger":             true,
+}
+
+// authorizeAdvisor accepts an advisor's token while the access it was redeemed from is still active, and only
+// for viewing one of advisorRoutes
+func authorizeAdvisor(c *gin.Context, claims *JWTClaims) (int, error) {
+	access, err := database.GetActiveAdvisorAccess(claims.AdvisorAccessID)
+	if err != nil || access.UserID != claims.UserID {
+		log.Printf("AuthMiddleware: Advisor access %d for user ID %d is no longer active", claims.AdvisorAccessID, claims.UserID)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Advisor access has expired or been revoked",
+			"code":  "ADVISOR_ACCESS_REVOKED",
+		})
+		return -1, errors.New("advisor access revoked")
+	}
+	readOnly := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+	if !readOnly || !advisorRoutes[c.FullPath()] {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Advisor access is limited to viewing summaries, reports and transactions",
+			"code":  "INSUFFICIENT_SCOPE",
+		})
+		return -1, errors.New("outside advisor scope")
+	}
+
+	if err := database.TouchAdvisorAccess(access.ID); err != nil {
+		log.Printf("AuthMiddleware: %v", err)
+	}
+	c.Set(advisorAccessContextKey, access)
+	log.Printf("AuthMiddleware: Authentication successful for user ID %d with advisor access %d", access.UserID, access.ID)
+	return access.UserID, nil
+}
+
+// SessionAuthMiddleware authenticates like AuthMiddleware but only accepts the user's own JWT, for endpoints an
+// API key or advisor mustn't reach such as managing API keys themselves
+func SessionAuthMiddleware(c *gin.Context) (int, error) {
+	userID, err := AuthMiddleware(c)
+	if err != nil {
+		return -1, err
+	}
+	if _, isAPIKey := c.Get(apiKeyContextKey); isAPIKey {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "This endpoint can't be used with an API key",
+			"code":  "SESSION_REQUIRED",
+		})
+		return -1, errors.New("session required")
+	}
+	if _, isAdvisor := c.Get(advisorAccessContextKey); isAdvisor {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "This endpoint can't be used with advisor access",
+			"code":  "SESSION_REQUIRED",
+		})
+		return -1, errors.New("session required")
+	}
+	return userID, nil
+}
+
+// AdminMiddleware checks the X-Admin-Token header against ADMIN_API_TOKEN for operator-only endpoints
+func AdminMiddleware(c *gin.Context) error {
+	adminToken := GetAdminAPIToken()
+	if adminToken == "" {
+		log.Printf("AdminMiddleware: ADMIN_API_TOKEN is not configured, rejecting request to %s", c.Request.URL.Path)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Admin endpoints are disabled",
+			"code":  "ADMIN_DISABLED",
+		})
+		return errors.New("admin endpoints are disabled")
+	}
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(adminToken)) != 1 {
+		log.Printf("AdminMiddleware: Invalid admin token for request to %s", c.Request.URL.Path)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid admin token",
+			"code":  "INVALID_ADMIN_TOKEN",
+		})
+		return errors.New("invalid admin token")
+	}
+	return nil
+}
+
+// EntitlementMiddleware rejects users without an active paid plan. It only reads the entitlement columns on
+// users, so it applies the same way whichever billing provider the subscription came from.
+func EntitlementMiddleware(c *gin.Context, userID int) error {
+	entitlement, err := database.GetEntitlement(userID)
+	if err != nil {
+		log.Printf("EntitlementMiddleware: Failed to get entitlement for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check subscription",
+		})
+		return err
+	}
+	if !entitlement.IsActive(time.Now()) {
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error": "An active subscription is required",
+			"code":  "SUBSCRIPTION_REQUIRED",
+		})
+		return errors.New("subscription required")
+	}
+	return nil
+}
+
+// RecoveryMiddleware turns a panicking handler into a 500 and reports the panic along with the request that caused it.
+// Only the path is reported, as some routes take an access token in the query string.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		errorreport.CapturePanic(recovered, map[string]string{
+			"method": c.Request.Method,
+			"route":  c.FullPath(),
+		}, map[string]interface{}{
+			"path": c.Request.URL.Path,
+		})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Internal server error",
+		})
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"watson/redisconn"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimit allows Requests per Window for each caller. Name keeps the counters of different limits apart.
+type RateLimit struct {
+	Name     string
+	Requests int
+	Window   time.Duration
+}
+
+var rateLimitClient redis.UniversalClient
+
+// InitRateLimiter connects to the Redis instance that holds the rate limit counters. Without a usable Redis
+// configuration requests are not limited.
+func InitRateLimiter() {
+	client, err := redisconn.NewClient("localhost:6379")
+	if err != nil {
+		log.Printf("Failed to configure Redis, rate limiting disabled: %v", err)
+		return
+	}
+	rateLimitClient = client
+}
+
+// RateLimitByIP limits requests per client IP, for routes called before a user has a token
+func RateLimitByIP(limit RateLimit) gin.HandlerFunc {
+	return rateLimitMiddleware(limit, func(c *gin.Context) string {
+		return "ip:" + c.ClientIP()
+	})
+}
+
+// RateLimitByUser limits requests per authenticated user, or per key for API keys. Requests without a valid
+// token are limited by client IP instead and are then rejected by AuthMiddleware in the handler.
+func RateLimitByUser(limit RateLimit) gin.HandlerFunc {
+	return rateLimitMiddleware(limit, func(c *gin.Context) string {
+		authHeader := c.GetHeader("Authorization")
+		if strings.HasPrefix(authHeader, "Bearer "+apiKeyPrefix) {
+			// Each API key gets its own allowance, without a database lookup before the limit is checked
+			return "apikey:" + hashAPIKey(authHeader[7:])[:16]
+		}
+		if strings.HasPrefix(authHeader, "Bearer ") {
+			if claims, err := VerifyJWT(authHeader[7:]); err == nil {
+				return "user:" + strconv.Itoa(claims.UserID)
+			}
+		}
+		return "ip:" + c.ClientIP()
+	})
+}
+
+// rateLimitMiddleware counts requests in fixed windows and sets the RateLimit-* headers from the IETF
+// draft on every response. Redis errors let the request through rather than taking the API down with it.
+func rateLimitMiddleware(limit RateLimit, subject func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rateLimitClient == nil {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		windowStart := now.Truncate(limit.Window)
+		key := redisconn.Key(fmt.Sprintf("ratelimit:%s:%s:%d", limit.Name, subject(c), windowStart.Unix()))
+
+		pipe := rateLimitClient.TxPipeline()
+		incr := pipe.Incr(c.Request.Context(), key)
+		pipe.Expire(c.Request.Context(), key, limit.Window)
+		if _, err := pipe.Exec(c.Request.Context()); err != nil {
+			log.Printf("Rate limit check failed for %s, allowing request: %v", limit.Name, err)
+			c.Next()
+			return
+		}
+
+		count := int(incr.Val())
+		resetSeconds := int(math.Ceil(windowStart.Add(limit.Window).Sub(now).Seconds()))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Window.Seconds())))
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(max(limit.Requests-count, 0)))
+		c.Header("RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if count > limit.Requests {
+			c.Header("Retry-After", strconv.Itoa(resetSeconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": "Too many requests, please try again later",
+				"code":  "RATE_LIMITED",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"watson/database"
+	"watson/redisconn"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Teller Connect signs what it hands back on enrollment with the application's token signing key. The signed
+// message is the nonce the app started Connect with, the access token, the Teller user and enrollment ids and
+// the environment, joined with dots. Nonces are issued with the bank link and can be used once.
+const tellerNonceTTL = 15 * time.Minute
+
+// errTellerVerification marks Teller Connect payloads that failed verification
+var errTellerVerification = errors.New("teller payload could not be verified")
+
+// issueTellerNonce returns a new nonce for the user to start Teller Connect with. It shares the rate limiter's
+// Redis client; without Redis the nonce isn't stored, so a signed enrollment made with it is refused.
+func issueTellerNonce(ctx context.Context, userID int) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(raw)
+	if rateLimitClient != nil {
+		if err := rateLimitClient.Set(ctx, tellerNonceKey(nonce), userID, tellerNonceTTL).Err(); err != nil {
+			return "", fmt.Errorf("failed to store teller nonce: %v", err)
+		}
+	}
+	return nonce, nil
+}
+
+// tellerNonceKey is where a nonce's owner is kept, namespaced like every other key
+func tellerNonceKey(nonce string) string {
+	return redisconn.Key("teller_nonce:" + nonce)
+}
+
+// consumeTellerNonce checks the nonce was issued to the user and hasn't expired or been used, then uses it up.
+// Without Redis there is no way to tell, so the nonce is refused.
+func consumeTellerNonce(ctx context.Context, userID int, nonce string) error {
+	if rateLimitClient == nil {
+		return errors.New("redis unavailable to check teller nonce")
+	}
+	owner, err := rateLimitClient.GetDel(ctx, tellerNonceKey(nonce)).Result()
+	if errors.Is(err, redis.Nil) {
+		return fmt.Errorf("%w: unknown, expired or reused nonce", errTellerVerification)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check teller nonce: %v", err)
+	}
+	if owner != strconv.Itoa(userID) {
+		return fmt.Errorf("%w: nonce was issued to another user", errTellerVerification)
+	}
+	return nil
+}
+
+// verifyTellerPayload checks a Teller Connect payload was signed by Teller for a nonce issued to the user.
+// Without signing keys, payloads are only accepted in the sandbox environment.
+func verifyTellerPayload(ctx context.Context, userID int, payload database.TellerPayload) error {
+	environment := GetTellerEnvironment()
+	keys, err := parseTellerSigningKeys(GetTellerSigningKeys())
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		if environment == "sandbox" {
+			log.Printf("TELLER_TOKEN_SIGNING_KEYS is not set, accepting unverified sandbox enrollment for user %d", userID)
+			return nil
+		}
+		return errors.New("TELLER_TOKEN_SIGNING_KEYS is not set")
+	}
+	if payload.Nonce == "" || len(payload.Signatures) == 0 {
+		return fmt.Errorf("%w: missing nonce or signatures", errTellerVerification)
+	}
+
+	message := []byte(strings.Join([]string{payload.Nonce, payload.AccessToken, payload.User.ID, payload.Enrollment.ID, environment}, "."))
+	if !anyTellerSignatureValid(keys, message, payload.Signatures) {
+		return fmt.Errorf("%w: no valid signature", errTellerVerification)
+	}
+	return consumeTellerNonce(ctx, userID, payload.Nonce)
+}
+
+// anyTellerSignatureValid reports whether one of the signatures verifies against one of the keys. Teller sends
+// a signature per active key so keys can be rotated.
+func anyTellerSignatureValid(keys []ed25519.PublicKey, message []byte, signatures []string) bool {
+	for _, encoded := range signatures {
+		signature, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(signature) != ed25519.SignatureSize {
+			continue
+		}
+		for _, key := range keys {
+			if ed25519.Verify(key, message, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTellerSigningKeys decodes base64 Ed25519 public keys, either the raw 32 bytes or DER encoded
+func parseTellerSigningKeys(encodedKeys []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(encodedKeys))
+	for _, encoded := range encodedKeys {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Teller signing key: %v", err)
+		}
+		if len(raw) == ed25519.PublicKeySize {
+			keys = append(keys, ed25519.PublicKey(raw))
+			continue
+		}
+		parsed, err := x509.ParsePKIXPublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Teller signing key: %v", err)
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("invalid Teller signing key: not an Ed25519 key")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on the credentials users register with. maxEmailLength matches the users.email column, and passwords
+// keep the same length bounds as changing a password.
+const (
+	maxEmailLength    = 300
+	minPasswordLength = 8
+	maxPasswordLength = 50
+)
+
+var (
+	errInvalidEmail = errors.New("email must be a valid address like name@example.com")
+	errWeakPassword = errors.New("password must be 8 to 50 characters and contain at least one letter and one number")
+)
+
+// normalizeEmail trims and lowercases an email so the same address is only registered once whatever its case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateEmail checks a normalized email is a bare address with a domain that has a dot in it
+func validateEmail(email string) error {
+	if email == "" || len(email) > maxEmailLength {
+		return errInvalidEmail
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errInvalidEmail
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return errInvalidEmail
+	}
+	return nil
+}
+
+// validatePassword checks a new password is long enough and mixes letters and numbers
+func validatePassword(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < minPasswordLength || length > maxPasswordLength {
+		return errWeakPassword
+	}
+	hasLetter, hasDigit := false, false
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return errWeakPassword
+	}
+	return nil
+}
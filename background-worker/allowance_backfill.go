@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// allowanceBackfillStep is the backfill_allowance_history job for the user's current month, which ends the
+// workflow of a first sync in place of process_daily_balance
+func allowanceBackfillStep(userID int) WorkflowStep {
+	return NewWorkflowStep("backfill_allowance_history", map[string]interface{}{
+		"user_id":    userID,
+		"month_year": database.ToMonthYear(time.Now()),
+	})
+}
+
+// processBackfillAllowanceHistory fills in the daily allowance history of the days of the month before the user
+// linked their accounts, from the transactions the first sync imported, so the month's chart doesn't start on the
+// link date. Each day is worked out with the spend up to its end. Today is left to process_daily_balance, which it
+// enqueues once the history is in.
+func (jp *JobProcessor) processBackfillAllowanceHistory(job *jobs.Job) error {
+	log.Printf("🔄 Processing backfill allowance history job: %s", job.ID)
+	var jobData struct {
+		UserID    int `json:"user_id"`
+		MonthYear int `json:"month_year"`
+	}
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	userID, monthYear := jobData.UserID, jobData.MonthYear
+
+	hasSummary, err := database.HasMonthlySummary(userID, monthYear)
+	if err != nil {
+		return err
+	}
+	backfilled := 0
+	if hasSummary {
+		strategy, err := userBudgetStrategy(userID)
+		if err != nil {
+			return fmt.Errorf("failed to get allowance strategy: %w", err)
+		}
+		preferences, err := database.GetUserPreferences(userID)
+		if err != nil {
+			return err
+		}
+		local := userLocalNow(time.Now(), preferences.Timezone)
+		today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		monthStart := database.MonthYearStart(monthYear)
+		end := monthStart.AddDate(0, 1, 0)
+		if today.Before(end) {
+			end = today
+		}
+		for day := monthStart; day.Before(end); day = day.AddDate(0, 0, 1) {
+			allowances, err := database.CalculateBudgetAllowancesOn(userID, monthYear, strategy, day)
+			if err != nil {
+				return fmt.Errorf("failed to calculate allowances for %s: %w", day.Format(iso8601TimeFormat), err)
+			}
+			// Days before the user started budgeting don't count towards it
+			if allowances.Window.DaysIntoWindow == 0 {
+				continue
+			}
+			if err := database.RecordBudgetAllowanceHistory(allowances.Categories, day); err != nil {
+				return err
+			}
+			backfilled++
+		}
+	}
+
+	if err := jp.enqueueDailyBalance(job.Context(), userID, monthYear, dailyBalanceStep(userID)); err != nil {
+		return fmt.Errorf("failed to enqueue daily balance job: %w", err)
+	}
+	log.Printf("✅ Completed backfill allowance history job: %s (%d days for user %d)", job.ID, backfilled, userID)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// anomalyScanWindow is how far back detect_transaction_anomalies looks for newly imported transactions. It runs
+// hourly, so consecutive runs overlap and a late one doesn't miss any; notifications are deduplicated per
+// transaction and kind.
+const anomalyScanWindow = 2 * time.Hour
+
+// processDetectTransactionAnomalies asks users to review recently imported transactions that look unusual for
+// them: far above their usual spend at the merchant or in the category, their first charge in a foreign currency,
+// or a duplicate of a charge with the same amount the same day. Users flag the ones they don't recognize from the
+// notification. Jobs carrying a user_id only check that user.
+func (jp *JobProcessor) processDetectTransactionAnomalies(job *jobs.Job) error {
+	log.Printf("🔄 Processing detect transaction anomalies job: %s", job.ID)
+	var jobData struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	anomalies, err := database.DetectTransactionAnomalies(time.Now().Add(-anomalyScanWindow), jobData.UserID)
+	if err != nil {
+		return err
+	}
+
+	notified := 0
+	for _, anomaly := range anomalies {
+		title, body := anomalyMessage(anomaly)
+		data := map[string]interface{}{
+			"transaction_id": anomaly.TransactionID,
+			"kind":           anomaly.Kind,
+			"amount":         anomaly.Amount,
+			"currency":       anomaly.Currency,
+			"date":           anomaly.Date.Format(iso8601TimeFormat),
+			"merchant":       anomaly.Merchant,
+			"usual_amount":   anomaly.UsualAmount,
+		}
+		created, err := database.CreateNotification(anomaly.UserID, database.NotificationTypeUnusualTransaction, title, body, data,
+			fmt.Sprintf("unusual_transaction:%s:%s", anomaly.Kind, anomaly.TransactionID))
+		if err != nil {
+			log.Printf("❌ Failed to notify user %d of unusual transaction %s: %v", anomaly.UserID, anomaly.TransactionID, err)
+			continue
+		}
+		if created {
+			notified++
+		}
+	}
+	log.Printf("✅ Completed detect transaction anomalies job: %s (%d of %d unusual transactions notified)", job.ID, notified, len(anomalies))
+	return nil
+}
+
+// anomalyMessage is the notification title and body for an unusual transaction
+func anomalyMessage(anomaly database.TransactionAnomaly) (string, string) {
+	name := anomaly.Merchant
+	if name == "" {
+		name = anomaly.Description
+	}
+	day := anomaly.Date.Format("Jan 2")
+	switch anomaly.Kind {
+	case database.AnomalyHighAmount:
+		return fmt.Sprintf("Unusually large charge at %s", name),
+			fmt.Sprintf("$%.2f on %s is well above the $%.2f you usually spend. Flag it if you don't recognize it.",
+				anomaly.Amount.Float64(), day, anomaly.UsualAmount.Float64())
+	case database.AnomalyForeignCurrency:
+		return fmt.Sprintf("First charge in %s", anomaly.Currency),
+			fmt.Sprintf("%s charged %.2f %s on %s, your first charge in %s. Flag it if you don't recognize it.",
+				name, anomaly.Amount.Float64(), anomaly.Currency, day, anomaly.Currency)
+	default:
+		return fmt.Sprintf("Possible duplicate charge at %s", name),
+			fmt.Sprintf("You were charged $%.2f twice on %s. Flag the second one if you were only charged once.",
+				anomaly.Amount.Float64(), day)
+	}
+}
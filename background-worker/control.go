@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+	"watson/jobs"
+	"watson/redisconn"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Operators pause the whole worker or single job types, and cap how many workers run, through the worker's
+// HTTP API. The settings live in Redis so every replica obeys them; each replica polls them every
+// controlRefreshInterval. Jobs of a paused type that get dequeued are held in a list per type and put back
+// on the queue once the type is resumed. Keys are namespaced with redisconn.Key.
+const (
+	workerPausedKey        = "worker:paused"
+	pausedJobTypesKey      = "worker:paused_job_types"
+	heldJobTypesKey        = "worker:held_job_types"
+	workerConcurrencyKey   = "worker:concurrency"
+	heldJobQueuePrefix     = jobs.QueueKey + ":held:"
+	controlRefreshInterval = 2 * time.Second
+)
+
+// defaultWorkerConcurrency is how many workers a replica starts, overridable with WORKER_CONCURRENCY
+const defaultWorkerConcurrency = 10
+
+// ControlState is the pause and concurrency settings a replica is running under
+type ControlState struct {
+	Paused         bool     `json:"paused"`
+	PausedJobTypes []string `json:"paused_job_types"`
+	// Concurrency is how many of the replica's MaxConcurrency workers take jobs
+	Concurrency    int `json:"concurrency"`
+	MaxConcurrency int `json:"max_concurrency"`
+}
+
+// ControlRequest is the body of the pause and resume endpoints. Without job types they apply to the whole worker.
+type ControlRequest struct {
+	JobTypes []string `json:"job_types"`
+}
+
+// ConcurrencyRequest is the body of the concurrency endpoint
+type ConcurrencyRequest struct {
+	Workers int `json:"workers"`
+}
+
+// workerConcurrency returns how many workers a replica starts
+func workerConcurrency() int {
+	if val := os.Getenv("WORKER_CONCURRENCY"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 {
+			log.Printf("❌ Invalid WORKER_CONCURRENCY %q, using %d", val, defaultWorkerConcurrency)
+		} else {
+			return parsed
+		}
+	}
+	return defaultWorkerConcurrency
+}
+
+func (state *ControlState) jobTypePaused(jobType string) bool {
+	return slices.Contains(state.PausedJobTypes, jobType)
+}
+
+// controls returns the settings last read from Redis
+func (jp *JobProcessor) controls() *ControlState {
+	if state := jp.control.Load(); state != nil {
+		return state
+	}
+	return &ControlState{PausedJobTypes: []string{}, Concurrency: jp.maxWorkers, MaxConcurrency: jp.maxWorkers}
+}
+
+// loadControlState reads the settings from Redis and makes them the replica's current ones
+func (jp *JobProcessor) loadControlState() (*ControlState, error) {
+	pipe := jp.rdb.Pipeline()
+	paused := pipe.Exists(ctx, redisconn.Key(workerPausedKey))
+	pausedTypes := pipe.SMembers(ctx, redisconn.Key(pausedJobTypesKey))
+	concurrency := pipe.Get(ctx, redisconn.Key(workerConcurrencyKey))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to read worker controls: %w", err)
+	}
+
+	state := &ControlState{
+		Paused:         paused.Val() > 0,
+		PausedJobTypes: pausedTypes.Val(),
+		Concurrency:    jp.maxWorkers,
+		MaxConcurrency: jp.maxWorkers,
+	}
+	slices.Sort(state.PausedJobTypes)
+	if limit, err := concurrency.Int(); err == nil && limit >= 0 && limit < jp.maxWorkers {
+		state.Concurrency = limit
+	}
+	jp.control.Store(state)
+	return state, nil
+}
+
+// StartControlRefresher keeps the replica's settings in step with Redis and puts held jobs of resumed
+// types back on the queue
+func (jp *JobProcessor) StartControlRefresher() {
+	if _, err := jp.loadControlState(); err != nil {
+		log.Printf("❌ %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(controlRefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			state, err := jp.loadControlState()
+			if err != nil {
+				log.Printf("❌ %v", err)
+				continue
+			}
+			if err := jp.releaseHeldJobs(state); err != nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+	}()
+}
+
+// holdJob sets aside a job whose type is paused until the type is resumed
+func (jp *JobProcessor) holdJob(job *jobs.Job) error {
+	if err := jp.encodeJobPayload(ctx, job); err != nil {
+		return err
+	}
+	jobJSON, err := jobs.Encode(job)
+	if err != nil {
+		return err
+	}
+	pipe := jp.rdb.TxPipeline()
+	pipe.LPush(ctx, redisconn.Key(heldJobQueuePrefix+job.Type), jobJSON)
+	pipe.SAdd(ctx, redisconn.Key(heldJobTypesKey), job.Type)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to hold job: %w", err)
+	}
+	log.Printf("⏸️ Held job %s until %s is resumed", job.ID, job.Type)
+	return nil
+}
+
+// releaseHeldJobs moves held jobs of types that are no longer paused back to the front of the queue, oldest first
+func (jp *JobProcessor) releaseHeldJobs(state *ControlState) error {
+	heldTypes, err := jp.rdb.SMembers(ctx, redisconn.Key(heldJobTypesKey)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read held job types: %w", err)
+	}
+	for _, jobType := range heldTypes {
+		if state.jobTypePaused(jobType) {
+			continue
+		}
+		// Removed before draining so a job held meanwhile re-adds its type and is picked up next time
+		if err := jp.rdb.SRem(ctx, redisconn.Key(heldJobTypesKey), jobType).Err(); err != nil {
+			return fmt.Errorf("failed to clear held job type %s: %w", jobType, err)
+		}
+		released := 0
+		for {
+			// BRPOP takes from the right, so moving newest first onto the right leaves the oldest next in line
+			err := jp.rdb.LMove(ctx, redisconn.Key(heldJobQueuePrefix+jobType), redisconn.Key(jobs.QueueKey), "LEFT", "RIGHT").Err()
+			if errors.Is(err, redis.Nil) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to release held %s jobs: %w", jobType, err)
+			}
+			released++
+		}
+		if released > 0 {
+			log.Printf("▶️ Released %d held %s jobs", released, jobType)
+		}
+	}
+	return nil
+}
+
+// decodeControlRequest reads an optional ControlRequest body
+func decodeControlRequest(r *http.Request) (ControlRequest, error) {
+	var req ControlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return req, err
+	}
+	return req, nil
+}
+
+// writeControlState answers with the settings just written
+func (jp *JobProcessor) writeControlState(w http.ResponseWriter) {
+	state, err := jp.loadControlState()
+	if err != nil {
+		http.Error(w, "Failed to read worker controls", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// requireOperatorToken only lets through requests whose X-Admin-Token header matches ADMIN_API_TOKEN, like the
+// API's admin endpoints. Without a token configured the endpoint is disabled.
+func requireOperatorToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken := os.Getenv("ADMIN_API_TOKEN")
+		if adminToken == "" {
+			log.Printf("❌ ADMIN_API_TOKEN is not configured, rejecting request to %s", r.URL.Path)
+			http.Error(w, "Operator endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(adminToken)) != 1 {
+			log.Printf("❌ Invalid admin token for request to %s", r.URL.Path)
+			http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// POST /worker/pause
+// Pauses every worker, or only the given job types. Paused job types are still enqueued but held until resumed.
+// INPUT (optional):
+//
+//	{
+//		"job_types": ["fetch_plaid_transactions", "initial_plaid_sync"]
+//	}
+func (jp *JobProcessor) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := decodeControlRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.JobTypes) == 0 {
+		err = jp.rdb.Set(ctx, redisconn.Key(workerPausedKey), time.Now().Format(time.RFC3339), 0).Err()
+	} else {
+		err = jp.rdb.SAdd(ctx, redisconn.Key(pausedJobTypesKey), req.JobTypes).Err()
+	}
+	if err != nil {
+		http.Error(w, "Failed to pause worker", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("⏸️ Paused worker %v", req.JobTypes)
+	jp.writeControlState(w)
+}
+
+// POST /worker/resume
+// Resumes the given job types, or without any, the whole worker and every paused job type
+func (jp *JobProcessor) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := decodeControlRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.JobTypes) == 0 {
+		err = jp.rdb.Del(ctx, redisconn.Key(workerPausedKey), redisconn.Key(pausedJobTypesKey)).Err()
+	} else {
+		err = jp.rdb.SRem(ctx, redisconn.Key(pausedJobTypesKey), req.JobTypes).Err()
+	}
+	if err != nil {
+		http.Error(w, "Failed to resume worker", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("▶️ Resumed worker %v", req.JobTypes)
+	jp.writeControlState(w)
+}
+
+// POST /worker/concurrency
+// Sets how many workers each replica runs, up to its WORKER_CONCURRENCY. Workers over the limit finish
+// their current job and then wait.
+// INPUT:
+//
+//	{
+//		"workers": 4
+//	}
+func (jp *JobProcessor) handleConcurrency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ConcurrencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Workers < 0 {
+		http.Error(w, "Workers must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := jp.rdb.Set(ctx, redisconn.Key(workerConcurrencyKey), req.Workers, 0).Err(); err != nil {
+		http.Error(w, "Failed to set worker concurrency", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("🔧 Set worker concurrency to %d", req.Workers)
+	jp.writeControlState(w)
+}
+
+// GET /worker/status
+func (jp *JobProcessor) handleWorkerStatus(w http.ResponseWriter, r *http.Request) {
+	jp.writeControlState(w)
+}
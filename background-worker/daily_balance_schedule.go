@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"watson/database"
+	"watson/jobs"
+	"watson/redisconn"
+)
+
+// Daily balances are recomputed once a day for every user with a budget, shortly after midnight in their own time
+// zone rather than in one global run. schedule_daily_balances runs every dailyBalanceScheduleInterval and enqueues
+// the users of each time zone together, the first time it runs after dailyBalanceLocalDelay past midnight there, so
+// the load is spread around the clock. A key per time zone and local date makes sure each is enqueued once a day
+// however many replicas run the scheduler.
+const (
+	dailyBalanceScheduleInterval = 15 * time.Minute
+	dailyBalanceLocalDelay       = 15 * time.Minute
+	dailyBalanceScheduledTTL     = 48 * time.Hour
+)
+
+func dailyBalanceScheduledKey(timezone string, localDate string) string {
+	return redisconn.Key(fmt.Sprintf("daily_balance_scheduled:%s:%s", timezone, localDate))
+}
+
+// userLocation loads a user's time zone, falling back to DefaultTimezone when it isn't known
+func userLocation(timezone string) *time.Location {
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" || timezone == "Local" {
+		log.Printf("❌ Unknown timezone %q, using %s", timezone, database.DefaultTimezone)
+		return time.UTC
+	}
+	return location
+}
+
+// userLocalNow is the wall clock time in the user's time zone, expressed in UTC like the dates budgets are kept in
+func userLocalNow(now time.Time, timezone string) time.Time {
+	local := now.In(userLocation(timezone))
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+}
+
+// processScheduleDailyBalances enqueues process_daily_balance for the users of every time zone where the day has
+// just started and hasn't been scheduled yet
+func (jp *JobProcessor) processScheduleDailyBalances(job *jobs.Job) error {
+	log.Printf("🔄 Processing schedule daily balances job: %s", job.ID)
+	usersByTimezone, err := database.GetDailyBalanceUsersByTimezone()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	scheduledZones, enqueued := 0, 0
+	for timezone, userIDs := range usersByTimezone {
+		local := userLocalNow(now, timezone)
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		if local.Sub(midnight) < dailyBalanceLocalDelay {
+			continue
+		}
+		localDate := local.Format(iso8601TimeFormat)
+		claimed, err := jp.rdb.SetNX(job.Context(), dailyBalanceScheduledKey(timezone, localDate), job.ID, dailyBalanceScheduledTTL).Result()
+		if err != nil {
+			return fmt.Errorf("failed to claim daily balances for %s: %w", timezone, err)
+		}
+		if !claimed {
+			continue
+		}
+
+		monthYear := database.ToMonthYear(local)
+		for _, userID := range userIDs {
+			step := NewWorkflowStep("process_daily_balance", map[string]interface{}{
+				"user_id":    userID,
+				"month_year": monthYear,
+				"timezone":   timezone,
+			})
+			if err := jp.enqueueDailyBalance(job.Context(), userID, monthYear, step); err != nil {
+				log.Printf("❌ Failed to enqueue daily balance job for user %d: %v", userID, err)
+				continue
+			}
+			enqueued++
+		}
+		scheduledZones++
+		log.Printf("🔄 Scheduled daily balances for %d users in %s (%s)", len(userIDs), timezone, localDate)
+	}
+	log.Printf("✅ Completed schedule daily balances job: %s (%d jobs across %d time zones)", job.ID, enqueued, scheduledZones)
+	return nil
+}
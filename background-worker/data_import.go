@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+	"watson/database"
+	"watson/importers"
+	"watson/jobs"
+	"watson/money"
+)
+
+// dataImportFileRetention is how long the files of an upload that never completed are kept for a retry. Completed
+// imports delete theirs straight away.
+const dataImportFileRetention = 7 * 24 * time.Hour
+
+// processImportHistory loads the export files a user uploaded from another budgeting app: their transactions, and
+// for YNAB the budgets of the months before this one. The upload's data_imports row follows it from running to
+// completed with what was loaded, or to failed with why. The files are read from data_import_files rather than the
+// job, so the job's records never hold the user's history.
+func (jp *JobProcessor) processImportHistory(job *jobs.Job) error {
+	log.Printf("🔄 Processing import history job: %s", job.ID)
+	var jobData struct {
+		ImportID int    `json:"import_id"`
+		UserID   int    `json:"user_id"`
+		Source   string `json:"source"`
+		DayFirst bool   `json:"day_first"`
+	}
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	if err := database.StartDataImport(jobData.ImportID); err != nil {
+		return err
+	}
+
+	fail := func(err error) error {
+		if recordErr := database.FailDataImport(jobData.ImportID, err.Error()); recordErr != nil {
+			log.Printf("❌ %v", recordErr)
+		}
+		return fmt.Errorf("failed to import history for user %d: %w", jobData.UserID, err)
+	}
+	files, err := database.GetDataImportFiles(jobData.UserID, jobData.ImportID)
+	if err != nil {
+		return err
+	}
+	if files == nil {
+		return fail(errors.New("the uploaded files are no longer kept; upload the export again"))
+	}
+	var budget io.Reader
+	if len(files.Budget) > 0 {
+		budget = bytes.NewReader(files.Budget)
+	}
+	transactions, budgets, err := importers.Parse(jobData.Source, bytes.NewReader(files.Register), budget,
+		importers.Options{DayFirst: jobData.DayFirst})
+	if err != nil {
+		return fail(err)
+	}
+	created, skipped, err := database.ImportTransactions(job.Context(), jobData.UserID, jobData.Source, transactions)
+	if err != nil {
+		return fail(err)
+	}
+	for _, transaction := range created {
+		jp.emitWebhookEvent(job.Context(), jobData.UserID, database.WebhookEventTransactionCreated, transaction.TransactionID, transaction)
+	}
+	imported := len(created)
+	budgetMonths, err := importBudgetHistory(jobData.UserID, budgets, time.Now())
+	if err != nil {
+		return fail(err)
+	}
+	if err := database.CompleteDataImport(jobData.ImportID, imported, skipped, budgetMonths); err != nil {
+		return err
+	}
+	log.Printf("✅ Completed import history job: %s (%d transactions imported, %d skipped, %d budget months for user %d)",
+		job.ID, imported, skipped, budgetMonths, jobData.UserID)
+	return nil
+}
+
+// importBudgetHistory sets the budgets of the months before now's from an export. Source categories are budgeted
+// under the category they map to, so several can add up to one budget, and categories the month already has are
+// updated in place. Months without a summary get one. It returns how many months it set.
+func importBudgetHistory(userID int, budgets []importers.Budget, now time.Time) (int, error) {
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	months := map[int]map[string]money.Money{}
+	for _, imported := range budgets {
+		category := importers.BudgetCategory(imported.Category...)
+		if category == "" || !imported.Month.Before(thisMonth) {
+			continue
+		}
+		monthYear := database.ToMonthYear(imported.Month)
+		if months[monthYear] == nil {
+			months[monthYear] = map[string]money.Money{}
+		}
+		months[monthYear][category] = months[monthYear][category].Add(imported.Amount)
+	}
+
+	for monthYear, categories := range months {
+		hasSummary, err := database.HasMonthlySummary(userID, monthYear)
+		if err != nil {
+			return 0, err
+		}
+		var summary *database.MonthlySummary
+		if hasSummary {
+			summary, err = database.GetMonthlySummary(userID, monthYear)
+		} else {
+			summary, err = database.CreateMonthlySummary(userID, monthYear, money.Money{}, money.Money{}, money.Money{}, money.Money{}, money.Money{}, money.Money{}, 0, money.Money{}, database.BudgetPeriodMonthly, nil)
+		}
+		if err != nil {
+			return 0, err
+		}
+		names := make([]string, 0, len(categories))
+		for name := range categories {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		imports := make([]database.BudgetCategoryImport, 0, len(names))
+		for _, name := range names {
+			imports = append(imports, database.BudgetCategoryImport{Category: name, Budget: categories[name]})
+		}
+		if _, _, err := database.ImportBudgetCategories(userID, summary.ID, monthYear, imports); err != nil {
+			return 0, err
+		}
+	}
+	return len(months), nil
+}
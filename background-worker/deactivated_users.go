@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// Users who deactivate their account are left out of the queries scheduled jobs pick users with, and jobs queued
+// for them, such as syncs from bank webhooks, are dropped. Their data stays untouched until the retention window
+// chosen when they deactivated passes, then purge_deactivated_users deletes it.
+
+// skipDeactivatedUserJob reports whether a job is for a user who deactivated their account. Jobs whose user
+// can't be checked still run.
+func skipDeactivatedUserJob(job *jobs.Job) bool {
+	userID, _, ok := jobRunSubject(job.Data)
+	if !ok {
+		return false
+	}
+	deactivation, err := database.GetAccountDeactivation(userID)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return false
+	}
+	return deactivation != nil
+}
+
+// processPurgeDeactivatedUsers deletes the users whose retention window has passed since they deactivated their
+// account, along with all their data
+func (jp *JobProcessor) processPurgeDeactivatedUsers(job *jobs.Job) error {
+	log.Printf("🔄 Processing purge deactivated users job: %s", job.ID)
+	userIDs, err := database.GetUsersDueForPurge(time.Now())
+	if err != nil {
+		return err
+	}
+	purged := 0
+	for _, userID := range userIDs {
+		ok, err := database.PurgeUser(job.Context(), userID)
+		if err != nil {
+			log.Printf("❌ Failed to purge user %d: %v", userID, err)
+			continue
+		}
+		if ok {
+			purged++
+		}
+	}
+	log.Printf("✅ Completed purge deactivated users job: %s (%d of %d purged)", job.ID, purged, len(userIDs))
+	return nil
+}
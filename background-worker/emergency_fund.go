@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// processCheckEmergencyFunds alerts users whose emergency fund covers fewer months of expenses than the threshold
+// they set. Each user is alerted at most once a month while their fund stays below it.
+func (jp *JobProcessor) processCheckEmergencyFunds(job *jobs.Job) error {
+	log.Printf("🔄 Processing check emergency funds job: %s", job.ID)
+	userIDs, err := database.GetUsersWithEmergencyFundThreshold()
+	if err != nil {
+		return err
+	}
+	monthYear := database.ToMonthYear(time.Now())
+	alerted := 0
+	for _, userID := range userIDs {
+		fund, err := database.GetEmergencyFund(userID, monthYear)
+		if err != nil {
+			log.Printf("❌ Failed to check emergency fund for user %d: %v", userID, err)
+			continue
+		}
+		if !fund.BelowThreshold {
+			continue
+		}
+		created, err := database.CreateNotification(userID, database.NotificationTypeEmergencyFundLow,
+			"Your emergency fund is running low",
+			fmt.Sprintf("Your emergency fund of $%.2f covers %.1f months of expenses, below the %.1f months you aimed for.",
+				fund.Balance.Float64(), *fund.MonthsCovered, *fund.ThresholdMonths),
+			map[string]interface{}{
+				"balance":          fund.Balance,
+				"months_covered":   *fund.MonthsCovered,
+				"threshold_months": *fund.ThresholdMonths,
+				"monthyear":        monthYear,
+			},
+			fmt.Sprintf("emergency_fund_low:%d", monthYear))
+		if err != nil {
+			log.Printf("❌ Failed to alert user %d of a low emergency fund: %v", userID, err)
+			continue
+		}
+		if created {
+			alerted++
+		}
+	}
+	log.Printf("✅ Completed check emergency funds job: %s (%d of %d alerted)", job.ID, alerted, len(userIDs))
+	return nil
+}
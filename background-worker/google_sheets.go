@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"watson/database"
+	"watson/googlesheets"
+	"watson/jobs"
+)
+
+// maxGoogleSheetsCatchUpMonths bounds how many missed months one export appends, so a connection that was paused
+// for a long time catches up without flooding the spreadsheet in one go
+const maxGoogleSheetsCatchUpMonths = 12
+
+// processExportGoogleSheets appends each completed month to the spreadsheets users connected: the month's summary,
+// its budget category spend, its transactions and a snapshot of savings goals. Without a user_id it covers every
+// connection still missing last month.
+func (jp *JobProcessor) processExportGoogleSheets(job *jobs.Job) error {
+	log.Printf("🔄 Processing export Google Sheets job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	latestMonthYear := database.ToMonthYear(time.Now().AddDate(0, -1, 0))
+
+	var connections []database.GoogleSheetsConnection
+	if userIDFloat, ok := jobData["user_id"].(float64); ok {
+		connection, err := database.GetGoogleSheetsConnection(int(userIDFloat))
+		if err != nil {
+			return err
+		}
+		if connection != nil && connection.Status == database.GoogleSheetsActive && connection.SpreadsheetID != nil {
+			connections = append(connections, *connection)
+		}
+	} else {
+		var err error
+		connections, err = database.GetGoogleSheetsConnectionsDueForExport(latestMonthYear)
+		if err != nil {
+			return err
+		}
+	}
+
+	exported := 0
+	for _, connection := range connections {
+		months := googleSheetsExportMonths(connection.LastExportedMonthYear, latestMonthYear)
+		if len(months) == 0 {
+			continue
+		}
+		if err := jp.exportGoogleSheets(job, connection, months); err != nil {
+			log.Printf("❌ Failed to export to Google Sheets for user %d: %v", connection.UserID, err)
+			reauthRequired := errors.Is(err, googlesheets.ErrAuthorizationRevoked)
+			if recordErr := database.RecordGoogleSheetsExportError(connection.UserID, err.Error(), reauthRequired); recordErr != nil {
+				log.Printf("❌ %v", recordErr)
+			}
+			if reauthRequired {
+				notifyGoogleSheetsReauth(connection.UserID, latestMonthYear)
+			}
+			continue
+		}
+		exported++
+	}
+	log.Printf("✅ Completed export Google Sheets job: %s (%d of %d exported)", job.ID, exported, len(connections))
+	return nil
+}
+
+// googleSheetsExportMonths returns the months after lastExported up to and including latest, oldest first.
+// Connections that haven't exported yet start with latest.
+func googleSheetsExportMonths(lastExported *int, latest int) []int {
+	latestStart := database.MonthYearStart(latest)
+	start := latestStart
+	if lastExported != nil {
+		start = database.MonthYearStart(*lastExported).AddDate(0, 1, 0)
+		if earliest := latestStart.AddDate(0, -(maxGoogleSheetsCatchUpMonths - 1), 0); start.Before(earliest) {
+			start = earliest
+		}
+	}
+	var months []int
+	for month := start; !month.After(latestStart); month = month.AddDate(0, 1, 0) {
+		months = append(months, database.ToMonthYear(month))
+	}
+	return months
+}
+
+// exportGoogleSheets appends the months to the connection's spreadsheet, recording each once it is written
+func (jp *JobProcessor) exportGoogleSheets(job *jobs.Job, connection database.GoogleSheetsConnection, months []int) error {
+	ctx := job.Context()
+	accessToken, err := googlesheets.RefreshAccessToken(ctx, connection.RefreshToken)
+	if err != nil {
+		return err
+	}
+	spreadsheetID := *connection.SpreadsheetID
+	if err := googlesheets.PrepareSpreadsheet(ctx, accessToken, spreadsheetID); err != nil {
+		return err
+	}
+	for _, monthYear := range months {
+		sheets, err := buildGoogleSheetsRows(connection.UserID, monthYear)
+		if err != nil {
+			return err
+		}
+		for _, sheet := range googlesheets.ExportSheets {
+			if err := googlesheets.AppendRows(ctx, accessToken, spreadsheetID, sheet.Title, sheets[sheet.Title]); err != nil {
+				return err
+			}
+		}
+		if err := database.MarkGoogleSheetsExported(connection.UserID, monthYear); err != nil {
+			return err
+		}
+		log.Printf("📊 Exported %d to Google Sheets for user %d", monthYear, connection.UserID)
+	}
+	return nil
+}
+
+// buildGoogleSheetsRows returns the rows a month adds to each export sheet, in the order of its header
+func buildGoogleSheetsRows(userID int, monthYear int) (map[string][][]interface{}, error) {
+	monthStart := database.MonthYearStart(monthYear)
+	month := monthStart.Format("2006-01")
+	sheets := map[string][][]interface{}{}
+
+	report, err := database.BuildMonthlyReport(userID, monthYear)
+	if err != nil {
+		return nil, err
+	}
+	sheets[googlesheets.SheetMonthlySummary] = [][]interface{}{{
+		month, report.Income, report.TotalSpent, report.TransactionCount,
+		optionalCell(report.SavingsRate), optionalCell(report.ChangeVsPreviousMonthPct),
+	}}
+
+	// Months without a budget have no summary, and nothing to add to the category sheet
+	if summary, err := database.GetMonthlySummary(userID, monthYear); err == nil {
+		categories, _, err := database.GetMonthlyBudgetSpendCategories(summary.UserID, summary.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, category := range categories {
+			sheets[googlesheets.SheetCategorySpend] = append(sheets[googlesheets.SheetCategorySpend], []interface{}{
+				month, category.Category, category.Budget.Float64(), category.TotalSpent.Float64(),
+				category.Budget.Sub(category.TotalSpent).Float64(),
+			})
+		}
+	}
+
+	transactions, err := database.GetExportTransactions(userID, monthStart, monthStart.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range transactions {
+		sheets[googlesheets.SheetTransactions] = append(sheets[googlesheets.SheetTransactions], []interface{}{
+			month, transaction.Date.Format("2006-01-02"), transaction.Description, transaction.Merchant,
+			transaction.Category, transaction.Amount, transaction.Currency,
+		})
+	}
+
+	goals, err := database.GetSavingsGoals(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, goal := range goals {
+		targetDate := ""
+		if goal.TargetDate != nil {
+			targetDate = goal.TargetDate.Format("2006-01-02")
+		}
+		sheets[googlesheets.SheetGoals] = append(sheets[googlesheets.SheetGoals], []interface{}{
+			month, goal.Name, goal.CurrentSaved, goal.TotalAmount, targetDate, goal.MonthlyPace, goal.Redeemed,
+		})
+	}
+	return sheets, nil
+}
+
+// optionalCell leaves a cell blank for a value that couldn't be computed
+func optionalCell(value *float64) interface{} {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// notifyGoogleSheetsReauth asks the user to authorize Google Sheets again after Google refused their token
+func notifyGoogleSheetsReauth(userID int, monthYear int) {
+	_, err := database.CreateNotification(userID, database.NotificationTypeReauthRequired,
+		"Reconnect Google Sheets",
+		"Google no longer accepts Watson's access to your spreadsheet, so monthly exports are paused. Reconnect Google Sheets to resume them.",
+		map[string]interface{}{"integration": "google_sheets"},
+		fmt.Sprintf("google_sheets_reauth:%d", monthYear))
+	if err != nil {
+		log.Printf("❌ Failed to notify user %d to reconnect Google Sheets: %v", userID, err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"watson/database"
+	"watson/jobs"
+	"watson/plaid"
+)
+
+// processRefreshInstitutions caches the name, logo, color and website of every institution users linked with
+// Plaid. It first records the institution of items linked without one, then fetches institutions that haven't
+// been fetched or were fetched over a week ago, so it is cheap to run after each link as well as weekly.
+func (jp *JobProcessor) processRefreshInstitutions(job *jobs.Job) error {
+	log.Printf("🔄 Processing refresh institutions job: %s", job.ID)
+	items, err := database.GetPlaidItemsMissingInstitution()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		institutionID, err := plaid.GetItemInstitutionID(job.Context(), item.AccessToken)
+		if err != nil || institutionID == "" {
+			log.Printf("❌ Failed to get institution of plaid item %s: %v", item.PlaidTokenID, err)
+			continue
+		}
+		if err := database.SetPlaidItemInstitution(item.PlaidTokenID, institutionID); err != nil {
+			return err
+		}
+	}
+
+	institutionIDs, err := database.GetInstitutionsDueForRefresh()
+	if err != nil {
+		return err
+	}
+	refreshed := 0
+	for _, institutionID := range institutionIDs {
+		institution, err := plaid.GetInstitution(job.Context(), institutionID)
+		if err != nil {
+			log.Printf("❌ Failed to fetch institution %s: %v", institutionID, err)
+			continue
+		}
+		if err := database.UpsertInstitution(institution); err != nil {
+			return fmt.Errorf("failed to store institution %s: %w", institutionID, err)
+		}
+		refreshed++
+	}
+	log.Printf("✅ Completed refresh institutions job: %s (%d of %d refreshed)", job.ID, refreshed, len(institutionIDs))
+	return nil
+}
@@ -0,0 +1,326 @@
+//go:build integration
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"watson/database"
+	"watson/jobs"
+	"watson/redisconn"
+	"watson/testenv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var testRedis redis.UniversalClient
+
+func TestMain(m *testing.M) {
+	env, err := testenv.Start()
+	if err != nil {
+		log.Fatalf("Failed to start test environment: %v", err)
+	}
+	code := func() int {
+		defer env.Close()
+		if err := database.InitDB(env.DatabaseURL); err != nil {
+			log.Printf("Failed to connect to test database: %v", err)
+			return 1
+		}
+		defer database.CloseDB()
+		if err := testenv.Migrate(database.DB); err != nil {
+			log.Printf("Failed to migrate test database: %v", err)
+			return 1
+		}
+		testRedis = redis.NewClient(&redis.Options{Addr: env.RedisAddr})
+		defer testRedis.Close()
+		return m.Run()
+	}()
+	os.Exit(code)
+}
+
+// fakeTeller stands in for api.teller.io, answering each request path with a canned JSON body. When status is
+// set, every request fails with it instead.
+type fakeTeller struct {
+	responses map[string]string
+	status    int
+}
+
+func (teller *fakeTeller) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, body := http.StatusOK, teller.responses[req.URL.Path]
+	if teller.status != 0 {
+		status, body = teller.status, `{"error":{"code":"bad_gateway","message":"upstream unavailable"}}`
+	} else if body == "" {
+		status, body = http.StatusNotFound, `{"error":{"code":"not_found","message":"not found"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// newTestProcessor returns a worker talking to the fake Teller, with the queue emptied
+func newTestProcessor(t *testing.T, teller *fakeTeller) *JobProcessor {
+	t.Helper()
+	if err := testRedis.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("failed to flush redis: %v", err)
+	}
+	return &JobProcessor{
+		rdb:            testRedis,
+		httpClient:     &http.Client{Transport: teller},
+		webhookClient:  newWebhookClient(),
+		payloadLimits:  loadPayloadLimits(),
+		replicaID:      "integration-test",
+		startedAt:      time.Now(),
+		jobRunPayloads: JobRunPayloadsAll,
+	}
+}
+
+// linkTestTeller registers a user with a Teller enrollment, returning the user's id, the access token and the enrollment
+func linkTestTeller(t *testing.T) (int, string, *database.TellerInstitution) {
+	t.Helper()
+	user, err := database.CreateUser(fmt.Sprintf("%s-%d@example.com", t.Name(), time.Now().UnixNano()), "password")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	accessToken := fmt.Sprintf("token_%d", user.UserID)
+	institution, err := database.UpsertTellerInstitution(user.UserID, "Test Bank", fmt.Sprintf("enr_%d", user.UserID), accessToken)
+	if err != nil {
+		t.Fatalf("UpsertTellerInstitution: %v", err)
+	}
+	return user.UserID, accessToken, institution
+}
+
+// runNextJob takes the next job off the queue and handles it as a worker would
+func runNextJob(t *testing.T, jp *JobProcessor) *jobs.Job {
+	t.Helper()
+	job, err := jobs.Dequeue(ctx, jp.rdb, time.Second)
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if job == nil {
+		t.Fatal("no job was queued")
+	}
+	jp.handleJob(1, job)
+	return job
+}
+
+// queuedJobTypes empties the queue and returns the types of the jobs that were on it, oldest first
+func queuedJobTypes(t *testing.T, jp *JobProcessor) []string {
+	t.Helper()
+	var types []string
+	for {
+		job, err := jobs.Dequeue(ctx, jp.rdb, time.Second)
+		if err != nil {
+			t.Fatalf("Dequeue: %v", err)
+		}
+		if job == nil {
+			return types
+		}
+		types = append(types, job.Type)
+	}
+}
+
+func TestNewTellerLinkSyncsAccountsAndTransactions(t *testing.T) {
+	userID, accessToken, institution := linkTestTeller(t)
+	accountID := fmt.Sprintf("acc_%d", userID)
+	today := time.Now().Format("2006-01-02")
+	teller := &fakeTeller{responses: map[string]string{
+		"/accounts": fmt.Sprintf(`[{
+			"id": %[1]q, "enrollment_id": %[2]q, "name": "Checking", "type": "depository", "subtype": "checking",
+			"currency": "USD", "last_four": "1234", "status": "open",
+			"institution": {"id": "test_bank", "name": "Test Bank"},
+			"links": {"self": "https://api.teller.io/accounts/%[1]s", "transactions": "https://api.teller.io/accounts/%[1]s/transactions"}
+		}]`, accountID, institution.TellerID),
+		"/accounts/" + accountID + "/transactions": fmt.Sprintf(`[
+			{"id": "txn_%[1]d_1", "account_id": %[2]q, "amount": "-12.50", "description": "Coffee", "date": %[3]q,
+				"type": "card_payment", "status": "posted", "running_balance": null,
+				"details": {"processing_status": "complete", "category": "dining", "counterparty": {"name": "Cafe", "type": "organization"}}},
+			{"id": "txn_%[1]d_2", "account_id": %[2]q, "amount": "2500.00", "description": "Payroll", "date": %[3]q,
+				"type": "ach", "status": "posted", "running_balance": null,
+				"details": {"processing_status": "complete", "category": "income", "counterparty": {"name": "Employer", "type": "organization"}}}
+		]`, userID, accountID, today),
+	}}
+	jp := newTestProcessor(t, teller)
+
+	data, _ := json.Marshal(map[string]interface{}{"token": accessToken, "user_id": userID})
+	if err := jp.EnqueueJob("new_teller_link", data); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	job := runNextJob(t, jp)
+
+	var saved int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = $1 AND provider_type = 'teller'", userID).Scan(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved != 2 {
+		t.Errorf("saved %d transactions, want 2", saved)
+	}
+	if synced, err := database.GetAllTellerAccountsSynced(userID); err != nil || !synced {
+		t.Errorf("GetAllTellerAccountsSynced = %v, %v; want true", synced, err)
+	}
+	progress, err := database.GetOnboardingProgress(userID)
+	if err != nil {
+		t.Fatalf("GetOnboardingProgress: %v", err)
+	}
+	for _, step := range progress.Steps {
+		if step.Step == database.OnboardingFirstSyncComplete && step.CompletedAt == nil {
+			t.Error("first sync wasn't recorded as complete")
+		}
+	}
+	run, err := database.GetJobRun(job.ID)
+	if err != nil {
+		t.Fatalf("GetJobRun: %v", err)
+	}
+	if run == nil || run.Status != database.JobRunSucceeded {
+		t.Fatalf("job run = %+v, want succeeded", run)
+	}
+	if strings.Contains(string(run.Payload), accessToken) || !run.PayloadRedacted {
+		t.Errorf("job run payload %s wasn't redacted", run.Payload)
+	}
+	if types := queuedJobTypes(t, jp); !slices.Contains(types, "backfill_allowance_history") {
+		t.Errorf("queued jobs = %v, want the allowance history backfill among them", types)
+	}
+}
+
+func TestFetchTransactionsRetriedThenDeadLettered(t *testing.T) {
+	userID, accessToken, institution := linkTestTeller(t)
+	accountID := fmt.Sprintf("acc_%d", userID)
+	jp := newTestProcessor(t, &fakeTeller{status: http.StatusBadGateway})
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"transactions_link":     "https://api.teller.io/accounts/" + accountID + "/transactions",
+		"access_token":          accessToken,
+		"user_id":               userID,
+		"teller_institution_id": institution.ID,
+		"account_id":            accountID,
+	})
+	if err := jp.EnqueueJob("fetch_transactions", data); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+
+	var job *jobs.Job
+	for attempt := 1; attempt <= jobs.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			promoted, err := jobs.PromoteDueRetries(ctx, jp.rdb, time.Now().Add(jobs.RetryDelay(attempt-1)))
+			if err != nil || promoted != 1 {
+				t.Fatalf("PromoteDueRetries before attempt %d = %d, %v; want 1", attempt, promoted, err)
+			}
+		}
+		job = runNextJob(t, jp)
+		if job.Attempts != attempt-1 {
+			t.Errorf("attempt %d ran with %d previous attempts", attempt, job.Attempts)
+		}
+		wantRetries := int64(1)
+		if attempt == jobs.MaxAttempts {
+			wantRetries = 0
+		}
+		if retries := jp.rdb.ZCard(ctx, redisconn.Key(jobs.RetryKey)).Val(); retries != wantRetries {
+			t.Fatalf("attempt %d left %d retries scheduled, want %d", attempt, retries, wantRetries)
+		}
+	}
+
+	deadLetters, err := database.GetDeadLetterJobs(database.DeadLetterFilter{JobType: "fetch_transactions", Limit: 100})
+	if err != nil {
+		t.Fatalf("GetDeadLetterJobs: %v", err)
+	}
+	deadLettered := 0
+	for _, deadLetter := range deadLetters {
+		if deadLetter.JobID == job.ID {
+			deadLettered++
+			if !strings.Contains(deadLetter.Error, "502") {
+				t.Errorf("dead letter error = %q, want the Teller status", deadLetter.Error)
+			}
+		}
+	}
+	if deadLettered != 1 {
+		t.Errorf("job %s was dead lettered %d times, want once", job.ID, deadLettered)
+	}
+	run, err := database.GetJobRun(job.ID)
+	if err != nil {
+		t.Fatalf("GetJobRun: %v", err)
+	}
+	if run == nil || run.Status != database.JobRunFailed {
+		t.Errorf("job run = %+v, want failed", run)
+	}
+}
+
+func TestSchedulerEnqueuesEachPeriodOnce(t *testing.T) {
+	jp := newTestProcessor(t, &fakeTeller{})
+	replica := &JobProcessor{
+		rdb:            testRedis,
+		payloadLimits:  loadPayloadLimits(),
+		replicaID:      "integration-test-replica",
+		startedAt:      time.Now(),
+		jobRunPayloads: JobRunPayloadsAll,
+	}
+	now := time.Date(2026, time.March, 4, 10, 30, 0, 0, time.UTC)
+
+	jp.enqueueDueScheduledJobs(ctx, now)
+	if types := queuedJobTypes(t, jp); len(types) != len(scheduledJobs) {
+		t.Fatalf("first tick queued %v, want every scheduled job once", types)
+	}
+
+	// Another replica, or this one after a restart, reaches the same periods
+	replica.enqueueDueScheduledJobs(ctx, now.Add(30*time.Second))
+	if types := queuedJobTypes(t, jp); len(types) != 0 {
+		t.Fatalf("second tick in the same periods queued %v, want nothing", types)
+	}
+
+	// An hour later only the jobs whose period has rolled over run again
+	replica.enqueueDueScheduledJobs(ctx, now.Add(time.Hour))
+	types := queuedJobTypes(t, jp)
+	for _, scheduled := range scheduledJobs {
+		want := scheduled.Interval <= time.Hour
+		if queued := slices.Contains(types, scheduled.Type); queued != want {
+			t.Errorf("%s queued an hour later = %v, want %v", scheduled.Type, queued, want)
+		}
+	}
+}
+
+func TestControlEndpointsRequireOperatorToken(t *testing.T) {
+	jp := newTestProcessor(t, &fakeTeller{})
+	pause := requireOperatorToken(jp.handlePause)
+	post := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/worker/pause", strings.NewReader(`{"job_types":["sync_teller_accounts"]}`))
+		if token != "" {
+			req.Header.Set("X-Admin-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		pause(rec, req)
+		return rec.Code
+	}
+
+	t.Setenv("ADMIN_API_TOKEN", "")
+	if code := post("anything"); code != http.StatusForbidden {
+		t.Errorf("pause without ADMIN_API_TOKEN configured = %d, want %d", code, http.StatusForbidden)
+	}
+
+	t.Setenv("ADMIN_API_TOKEN", "operator-secret")
+	for _, token := range []string{"", "wrong"} {
+		if code := post(token); code != http.StatusUnauthorized {
+			t.Errorf("pause with token %q = %d, want %d", token, code, http.StatusUnauthorized)
+		}
+	}
+	if paused, err := testRedis.SMembers(ctx, redisconn.Key(pausedJobTypesKey)).Result(); err != nil || len(paused) != 0 {
+		t.Fatalf("paused job types after rejected requests = %v (%v), want none", paused, err)
+	}
+
+	if code := post("operator-secret"); code != http.StatusOK {
+		t.Fatalf("pause with the operator token = %d, want %d", code, http.StatusOK)
+	}
+	t.Cleanup(func() { testRedis.Del(ctx, redisconn.Key(pausedJobTypesKey)) })
+	if paused, err := testRedis.SMembers(ctx, redisconn.Key(pausedJobTypesKey)).Result(); err != nil || !slices.Equal(paused, []string{"sync_teller_accounts"}) {
+		t.Errorf("paused job types = %v (%v), want [sync_teller_accounts]", paused, err)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"watson/jobs"
+	"watson/redisconn"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The worker's queue endpoints let an ops dashboard see what the worker is doing without connecting to Redis.
+// Every replica heartbeats into Redis with the job each of its workers is running, and counts the jobs it
+// finishes in per-minute buckets, so any replica can answer for all of them. Tracking is best effort: failures
+// are logged and never fail a job. Keys are namespaced with redisconn.Key.
+const (
+	// workerReplicasKey is a sorted set of replica ids scored by their last heartbeat
+	workerReplicasKey = "worker:replicas"
+	// workerReplicaPrefix is a hash per replica holding its details and a "worker:<n>" field per busy worker
+	workerReplicaPrefix = "worker:replica:"
+	// throughputKeyPrefix is a hash per minute counting finished jobs in "<job type>:succeeded" and ":failed" fields
+	throughputKeyPrefix = "worker:throughput:"
+
+	replicaHeartbeatInterval = 5 * time.Second
+	// replicaTTL is how long a replica that stopped heartbeating is still listed
+	replicaTTL       = 30 * time.Second
+	throughputWindow = 60 * time.Minute
+
+	defaultQueueJobsLimit = 50
+	maxQueueJobsLimit     = 200
+)
+
+// InFlightJob is a job one of a replica's workers is running
+type InFlightJob struct {
+	JobID     string    `json:"job_id"`
+	JobType   string    `json:"job_type"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// WorkerSlot is one of a replica's workers, with the job it is running or nil while it is idle
+type WorkerSlot struct {
+	WorkerID int          `json:"worker_id"`
+	Job      *InFlightJob `json:"job"`
+}
+
+// ReplicaStatus is a running worker replica and what each of its workers is doing
+type ReplicaStatus struct {
+	ID         string       `json:"id"`
+	Hostname   string       `json:"hostname"`
+	StartedAt  time.Time    `json:"started_at"`
+	LastSeenAt time.Time    `json:"last_seen_at"`
+	MaxWorkers int          `json:"max_workers"`
+	Busy       int          `json:"busy"`
+	Workers    []WorkerSlot `json:"workers"`
+}
+
+// QueueStatus is the depth of one queue. Held queues keep the jobs of a paused type until it is resumed.
+type QueueStatus struct {
+	Name    string `json:"name"`
+	JobType string `json:"job_type,omitempty"`
+	Depth   int64  `json:"depth"`
+	Paused  bool   `json:"paused"`
+	// OldestCreatedAt is when the job next in line was enqueued, nil for an empty queue
+	OldestCreatedAt *time.Time `json:"oldest_created_at"`
+}
+
+// ThroughputCount is how many jobs finished
+type ThroughputCount struct {
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+}
+
+// MinuteThroughput is how many jobs finished in the minute starting at Minute
+type MinuteThroughput struct {
+	Minute time.Time `json:"minute"`
+	ThroughputCount
+}
+
+// Throughput is how many jobs every replica finished over the last WindowMinutes
+type Throughput struct {
+	WindowMinutes int `json:"window_minutes"`
+	ThroughputCount
+	ByJobType map[string]ThroughputCount `json:"by_job_type"`
+	// PerMinute is oldest first, including minutes without any jobs
+	PerMinute []MinuteThroughput `json:"per_minute"`
+}
+
+// QueuedJob describes a job waiting on a queue. Its data is left out, as job data can hold access tokens.
+type QueuedJob struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	CreatedAt  time.Time `json:"created_at"`
+	WorkflowID string    `json:"workflow_id,omitempty"`
+	Encoding   string    `json:"encoding,omitempty"`
+	DataBytes  int       `json:"data_bytes"`
+	Offloaded  bool      `json:"offloaded"`
+}
+
+// replicaID names this replica by its host and process
+func replicaID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+func workerSlotField(workerID int) string {
+	return "worker:" + strconv.Itoa(workerID)
+}
+
+func throughputKey(minute time.Time) string {
+	return redisconn.Key(throughputKeyPrefix + strconv.FormatInt(minute.Unix(), 10))
+}
+
+// StartHeartbeat keeps the replica listed on /workers while it runs
+func (jp *JobProcessor) StartHeartbeat() {
+	jp.heartbeat()
+	go func() {
+		ticker := time.NewTicker(replicaHeartbeatInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			jp.heartbeat()
+		}
+	}()
+}
+
+func (jp *JobProcessor) heartbeat() {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	replicaKey := redisconn.Key(workerReplicaPrefix + jp.replicaID)
+	pipe := jp.rdb.Pipeline()
+	pipe.ZAdd(ctx, redisconn.Key(workerReplicasKey), redis.Z{Score: float64(now.Unix()), Member: jp.replicaID})
+	pipe.ZRemRangeByScore(ctx, redisconn.Key(workerReplicasKey), "-inf", strconv.FormatInt(now.Add(-replicaTTL).Unix(), 10))
+	pipe.HSet(ctx, replicaKey, "hostname", hostname, "started_at", jp.startedAt.Format(time.RFC3339), "max_workers", jp.maxWorkers)
+	pipe.Expire(ctx, replicaKey, replicaTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("❌ Failed to record worker heartbeat: %v", err)
+	}
+}
+
+// trackJobStarted records the job a worker picked up
+func (jp *JobProcessor) trackJobStarted(workerID int, job *jobs.Job) {
+	inFlight, err := json.Marshal(InFlightJob{JobID: job.ID, JobType: job.Type, StartedAt: time.Now()})
+	if err != nil {
+		log.Printf("❌ Failed to marshal in-flight job: %v", err)
+		return
+	}
+	if err := jp.rdb.HSet(ctx, redisconn.Key(workerReplicaPrefix+jp.replicaID), workerSlotField(workerID), inFlight).Err(); err != nil {
+		log.Printf("❌ Failed to track job %s: %v", job.ID, err)
+	}
+}
+
+// trackJobFinished frees the worker and counts the job towards this minute's throughput
+func (jp *JobProcessor) trackJobFinished(workerID int, job *jobs.Job, jobErr error) {
+	outcome := "succeeded"
+	if jobErr != nil {
+		outcome = "failed"
+	}
+	key := throughputKey(time.Now().Truncate(time.Minute))
+	pipe := jp.rdb.Pipeline()
+	pipe.HDel(ctx, redisconn.Key(workerReplicaPrefix+jp.replicaID), workerSlotField(workerID))
+	pipe.HIncrBy(ctx, key, job.Type+":"+outcome, 1)
+	pipe.Expire(ctx, key, throughputWindow+time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("❌ Failed to track finished job %s: %v", job.ID, err)
+	}
+}
+
+// queueStatus reads a queue's depth and when its next job was enqueued
+func (jp *JobProcessor) queueStatus(name string) (QueueStatus, error) {
+	status := QueueStatus{Name: name}
+	pipe := jp.rdb.Pipeline()
+	depth := pipe.LLen(ctx, redisconn.Key(name))
+	// Jobs are pushed on the left and popped from the right, so the rightmost is next in line
+	next := pipe.LIndex(ctx, redisconn.Key(name), -1)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return status, fmt.Errorf("failed to read queue %s: %w", name, err)
+	}
+	status.Depth = depth.Val()
+	if next.Val() != "" {
+		if job, err := jobs.Decode([]byte(next.Val())); err == nil {
+			status.OldestCreatedAt = &job.CreatedAt
+		}
+	}
+	return status, nil
+}
+
+// readThroughput sums the jobs finished in each minute of the window
+func (jp *JobProcessor) readThroughput() (Throughput, error) {
+	throughput := Throughput{WindowMinutes: int(throughputWindow / time.Minute), ByJobType: map[string]ThroughputCount{}}
+	first := time.Now().Truncate(time.Minute).Add(-throughputWindow + time.Minute)
+	pipe := jp.rdb.Pipeline()
+	buckets := make([]*redis.MapStringStringCmd, throughput.WindowMinutes)
+	for i := range buckets {
+		buckets[i] = pipe.HGetAll(ctx, throughputKey(first.Add(time.Duration(i)*time.Minute)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return throughput, fmt.Errorf("failed to read throughput: %w", err)
+	}
+	for i, bucket := range buckets {
+		minute := MinuteThroughput{Minute: first.Add(time.Duration(i) * time.Minute)}
+		for field, value := range bucket.Val() {
+			count, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			at := strings.LastIndex(field, ":")
+			if at < 0 {
+				continue
+			}
+			jobType, outcome := field[:at], field[at+1:]
+			byType := throughput.ByJobType[jobType]
+			if outcome == "failed" {
+				minute.Failed += count
+				byType.Failed += count
+			} else {
+				minute.Succeeded += count
+				byType.Succeeded += count
+			}
+			throughput.ByJobType[jobType] = byType
+		}
+		throughput.Succeeded += minute.Succeeded
+		throughput.Failed += minute.Failed
+		throughput.PerMinute = append(throughput.PerMinute, minute)
+	}
+	return throughput, nil
+}
+
+// GET /queues
+// The depth of the job queue and of the held queue of each paused job type, with the jobs every replica finished
+// over the last hour
+func (jp *JobProcessor) handleQueues(w http.ResponseWriter, r *http.Request) {
+	state, err := jp.loadControlState()
+	if err != nil {
+		http.Error(w, "Failed to read worker controls", http.StatusInternalServerError)
+		return
+	}
+	heldTypes, err := jp.rdb.SMembers(ctx, redisconn.Key(heldJobTypesKey)).Result()
+	if err != nil {
+		http.Error(w, "Failed to read held job types", http.StatusInternalServerError)
+		return
+	}
+	for _, jobType := range state.PausedJobTypes {
+		if !slices.Contains(heldTypes, jobType) {
+			heldTypes = append(heldTypes, jobType)
+		}
+	}
+	slices.Sort(heldTypes)
+
+	jobQueue, err := jp.queueStatus(jobs.QueueKey)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		http.Error(w, "Failed to read queues", http.StatusInternalServerError)
+		return
+	}
+	jobQueue.Paused = state.Paused
+	queues := []QueueStatus{jobQueue}
+	for _, jobType := range heldTypes {
+		held, err := jp.queueStatus(heldJobQueuePrefix + jobType)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			http.Error(w, "Failed to read queues", http.StatusInternalServerError)
+			return
+		}
+		held.JobType = jobType
+		held.Paused = state.jobTypePaused(jobType)
+		queues = append(queues, held)
+	}
+
+	throughput, err := jp.readThroughput()
+	if err != nil {
+		log.Printf("❌ %v", err)
+		http.Error(w, "Failed to read throughput", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queues":     queues,
+		"throughput": throughput,
+	})
+}
+
+// GET /queues/{name}/jobs?limit=50&offset=0
+// The jobs waiting on the job queue or a held queue, next in line first
+func (jp *JobProcessor) handleQueueJobs(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name != jobs.QueueKey && (!strings.HasPrefix(name, heldJobQueuePrefix) || name == heldJobQueuePrefix) {
+		http.Error(w, "Unknown queue", http.StatusNotFound)
+		return
+	}
+	limit := defaultQueueJobsLimit
+	if val := r.URL.Query().Get("limit"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 || parsed > maxQueueJobsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxQueueJobsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	offset := 0
+	if val := r.URL.Query().Get("offset"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 0 {
+			http.Error(w, "offset must not be negative", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	pipe := jp.rdb.Pipeline()
+	depth := pipe.LLen(ctx, redisconn.Key(name))
+	// Counted from the right, where the next job is popped
+	page := pipe.LRange(ctx, redisconn.Key(name), int64(-(offset + limit)), int64(-(offset + 1)))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("❌ Failed to read queue %s: %v", name, err)
+		http.Error(w, "Failed to read queue", http.StatusInternalServerError)
+		return
+	}
+	encoded := page.Val()
+	queued := make([]QueuedJob, 0, len(encoded))
+	for i := len(encoded) - 1; i >= 0; i-- {
+		job, err := jobs.Decode([]byte(encoded[i]))
+		if err != nil {
+			log.Printf("❌ %v", err)
+			continue
+		}
+		queued = append(queued, QueuedJob{
+			ID:         job.ID,
+			Type:       job.Type,
+			CreatedAt:  job.CreatedAt,
+			WorkflowID: job.WorkflowID,
+			Encoding:   job.Encoding,
+			DataBytes:  len(job.Data),
+			Offloaded:  job.DataRef != "",
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queue":  name,
+		"depth":  depth.Val(),
+		"jobs":   queued,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GET /workers
+// Every replica that heartbeated recently and the job each of its workers is running
+func (jp *JobProcessor) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	cutoff := strconv.FormatInt(time.Now().Add(-replicaTTL).Unix(), 10)
+	replicas, err := jp.rdb.ZRangeByScoreWithScores(ctx, redisconn.Key(workerReplicasKey), &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	if err != nil {
+		log.Printf("❌ Failed to read worker replicas: %v", err)
+		http.Error(w, "Failed to read workers", http.StatusInternalServerError)
+		return
+	}
+	pipe := jp.rdb.Pipeline()
+	details := make([]*redis.MapStringStringCmd, len(replicas))
+	for i, replica := range replicas {
+		details[i] = pipe.HGetAll(ctx, redisconn.Key(workerReplicaPrefix+fmt.Sprint(replica.Member)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("❌ Failed to read worker replicas: %v", err)
+		http.Error(w, "Failed to read workers", http.StatusInternalServerError)
+		return
+	}
+
+	statuses := []ReplicaStatus{}
+	for i, replica := range replicas {
+		fields := details[i].Val()
+		if len(fields) == 0 {
+			continue
+		}
+		status := ReplicaStatus{
+			ID:         fmt.Sprint(replica.Member),
+			Hostname:   fields["hostname"],
+			LastSeenAt: time.Unix(int64(replica.Score), 0),
+			Workers:    []WorkerSlot{},
+		}
+		status.StartedAt, _ = time.Parse(time.RFC3339, fields["started_at"])
+		status.MaxWorkers, _ = strconv.Atoi(fields["max_workers"])
+		for workerID := 1; workerID <= status.MaxWorkers; workerID++ {
+			slot := WorkerSlot{WorkerID: workerID}
+			if value, ok := fields[workerSlotField(workerID)]; ok {
+				var job InFlightJob
+				if err := json.Unmarshal([]byte(value), &job); err == nil {
+					slot.Job = &job
+					status.Busy++
+				}
+			}
+			status.Workers = append(status.Workers, slot)
+		}
+		statuses = append(statuses, status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"replicas": statuses,
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// jobRunProviders are the sync jobs recorded in job_runs for the sync health endpoint, with the provider
+// they sync. Jobs carrying an account_id also update that account's sync status when they finish.
+var jobRunProviders = map[string]string{
+	"initial_plaid_sync":       "plaid",
+	"fetch_plaid_transactions": "plaid",
+	"sync_plaid_accounts":      "plaid",
+	"backfill_plaid_history":   "plaid",
+	"new_teller_link":          "teller",
+	"fetch_transactions":       "teller",
+}
+
+// jobRunRetention is how long job runs are kept; sync health only looks at the last week
+const jobRunRetention = 30 * 24 * time.Hour
+
+// jobRunSubject reads the user and account a sync job is about from its unencoded data
+func jobRunSubject(data json.RawMessage) (int, string, bool) {
+	var subject struct {
+		UserID    *float64 `json:"user_id"`
+		AccountID string   `json:"account_id"`
+	}
+	if err := json.Unmarshal(data, &subject); err != nil || subject.UserID == nil {
+		return 0, "", false
+	}
+	return int(*subject.UserID), subject.AccountID, true
+}
+
+// Job run payload modes, set with JOB_RUN_PAYLOADS. In JobRunPayloadsAll every job is recorded with its data so
+// it can be replayed, in JobRunPayloadsSync only sync jobs are recorded, and in JobRunPayloadsOff sync jobs are
+// recorded without their data.
+const (
+	JobRunPayloadsAll  = "all"
+	JobRunPayloadsSync = "sync"
+	JobRunPayloadsOff  = "off"
+)
+
+// loadJobRunPayloads reads the job run payload mode from the environment
+func loadJobRunPayloads() string {
+	switch val := os.Getenv("JOB_RUN_PAYLOADS"); val {
+	case "":
+		return JobRunPayloadsAll
+	case JobRunPayloadsAll, JobRunPayloadsSync, JobRunPayloadsOff:
+		return val
+	default:
+		log.Printf("❌ Invalid JOB_RUN_PAYLOADS %q, using %s", val, JobRunPayloadsAll)
+		return JobRunPayloadsAll
+	}
+}
+
+// redactJobPayload returns a job's data with the values of secret keys replaced, and whether any were. Data
+// that isn't JSON is dropped entirely, as it can't be checked.
+func redactJobPayload(data json.RawMessage) (json.RawMessage, bool) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return json.RawMessage("null"), false
+	}
+	redacted, secrets, err := jobs.SplitSecrets(data)
+	if err != nil {
+		return json.RawMessage("null"), true
+	}
+	return redacted, secrets != nil
+}
+
+// recordJobRun records a job's status in job_runs, and once a sync job has finished, the outcome for its account.
+// Which jobs are recorded, and whether with their data, depends on the JOB_RUN_PAYLOADS mode. Tracking is best
+// effort: failures are logged and never fail the job.
+func (jp *JobProcessor) recordJobRun(job *jobs.Job, status string, jobErr error) {
+	provider, isSync := jobRunProviders[job.Type]
+	if !isSync && jp.jobRunPayloads != JobRunPayloadsAll {
+		return
+	}
+	userID, accountID, ok := jobRunSubject(job.Data)
+	if isSync && !ok {
+		return
+	}
+	errMessage := ""
+	if jobErr != nil {
+		errMessage = jobErr.Error()
+	}
+	// The data is recorded once the job starts running, when it has been decoded
+	var payload json.RawMessage
+	payloadRedacted := false
+	if status == database.JobRunRunning && jp.jobRunPayloads != JobRunPayloadsOff {
+		payload, payloadRedacted = redactJobPayload(job.Data)
+	}
+	if err := database.RecordJobRun(job.ID, job.Type, userID, provider, accountID, status, errMessage, payload, payloadRedacted); err != nil {
+		log.Printf("❌ %v", err)
+	}
+	if isSync && accountID != "" && (status == database.JobRunSucceeded || status == database.JobRunFailed) {
+		if err := database.RecordAccountSync(provider, accountID, userID, jobErr); err != nil {
+			log.Printf("❌ %v", err)
+		}
+	}
+}
@@ -1,23 +1,105 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"watson/blobstore"
+	"watson/budget"
 	"watson/database"
+	"watson/email"
+	"watson/errorreport"
+	"watson/faultinject"
+	"watson/googlesheets"
+	"watson/jobs"
+	"watson/money"
 	"watson/plaid"
+	"watson/redisconn"
+	"watson/telemetry"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 )
 
 var ctx = context.Background()
 
+var tracer = telemetry.Tracer("watson/background-worker")
+
+// errJobPanicked marks job errors that came from a recovered panic, which are reported when recovered
+var errJobPanicked = errors.New("job panicked")
+
+// errJobTimedOut marks job errors from a job that ran past its timeout
+var errJobTimedOut = errors.New("job timed out")
+
+// defaultJobTimeout bounds job types missing from jobTimeouts
+const defaultJobTimeout = 2 * time.Minute
+
+// jobTimeouts bound how long each job type may run before its context is cancelled. Syncs call out to
+// banks; backfills, archiving and the jobs that loop over every user get longer. JOB_TIMEOUT_<TYPE>,
+// e.g. JOB_TIMEOUT_INITIAL_PLAID_SYNC=10m, overrides a type's timeout.
+var jobTimeouts = map[string]time.Duration{
+	"new_teller_link":                    5 * time.Minute,
+	"fetch_transactions":                 5 * time.Minute,
+	"initial_plaid_sync":                 5 * time.Minute,
+	"fetch_plaid_transactions":           5 * time.Minute,
+	"sync_plaid_accounts":                5 * time.Minute,
+	"seed_demo_data":                     5 * time.Minute,
+	"recalculate_saving_goals":           10 * time.Minute,
+	"contribute_round_ups":               10 * time.Minute,
+	"update_debt_plans":                  10 * time.Minute,
+	"compute_monthly_balances":           10 * time.Minute,
+	"generate_monthly_report":            10 * time.Minute,
+	"send_email_digests":                 10 * time.Minute,
+	"deliver_webhooks":                   10 * time.Minute,
+	"deliver_channel_messages":           10 * time.Minute,
+	"refresh_institutions":               10 * time.Minute,
+	"flag_stale_accounts":                5 * time.Minute,
+	"export_google_sheets":               10 * time.Minute,
+	"backfill_plaid_history":             30 * time.Minute,
+	"backfill_personal_finance_category": 30 * time.Minute,
+	"archive_old_transactions":           30 * time.Minute,
+	"purge_deactivated_users":            30 * time.Minute,
+	"check_emergency_funds":              10 * time.Minute,
+	"schedule_daily_balances":            5 * time.Minute,
+	"backfill_allowance_history":         10 * time.Minute,
+	"detect_transaction_anomalies":       10 * time.Minute,
+	"detect_price_increases":             10 * time.Minute,
+	"add_plaid_item_accounts":            5 * time.Minute,
+	"import_history":                     15 * time.Minute,
+}
+
+// jobTimeout returns how long a job of jobType may run
+func jobTimeout(jobType string) time.Duration {
+	envKey := "JOB_TIMEOUT_" + strings.ToUpper(jobType)
+	if val := os.Getenv(envKey); val != "" {
+		parsed, err := time.ParseDuration(val)
+		if err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Printf("❌ Invalid %s %q, using the default", envKey, val)
+	}
+	if timeout, ok := jobTimeouts[jobType]; ok {
+		return timeout
+	}
+	return defaultJobTimeout
+}
+
 // TellerAccount represents an account from the Teller API
 type TellerAccount struct {
 	ID                  string `json:"id"`
@@ -41,6 +123,14 @@ type TellerAccount struct {
 	} `json:"links"`
 }
 
+// TellerBalances represents an account's balances from the Teller API. Either can be empty when the institution
+// doesn't report it.
+type TellerBalances struct {
+	AccountID string `json:"account_id"`
+	Ledger    string `json:"ledger"`
+	Available string `json:"available"`
+}
+
 // TellerTransaction represents a transaction from the Teller API
 type TellerTransaction struct {
 	ID             string `json:"id"`
@@ -63,42 +153,52 @@ type TellerTransaction struct {
 		Self    string `json:"self"`
 		Account string `json:"account"`
 	} `json:"links"`
+	// dbID is the transaction's row id, set once it is saved
+	dbID string
+	// inserted is set once it is saved when the save stored it for the first time
+	inserted bool
 }
 
-// Job represents a task to be processed
-type Job struct {
-	ID        string          `json:"id"`
-	Type      string          `json:"type"`
-	Data      json.RawMessage `json:"data"`
-	CreatedAt time.Time       `json:"created_at"`
-}
-
-// EnqueueRequest represents the request body for enqueueing jobs
-type EnqueueRequest struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
-}
-
-// EnqueueResponse represents the response when enqueueing a job
-type EnqueueResponse struct {
-	Success bool   `json:"success"`
-	JobID   string `json:"job_id,omitempty"`
-	Message string `json:"message,omitempty"`
+// createdTransaction is the transaction.created webhook payload of a saved Teller transaction, in the shape
+// Plaid's are sent in
+func (transaction TellerTransaction) createdTransaction(userID int) database.Transaction {
+	amount, _ := strconv.ParseFloat(transaction.Amount, 64)
+	// Dates come back from the database with a time of day, Teller sends them without
+	date, _ := time.Parse(iso8601TimeFormat, transaction.Date[:min(len(transaction.Date), len(iso8601TimeFormat))])
+	return database.Transaction{
+		TransactionID:   transaction.dbID,
+		UserID:          userID,
+		Description:     transaction.Description,
+		Amount:          amount,
+		TransactionDate: date,
+		Category:        transaction.Details.Category,
+		Status:          transaction.Status,
+		Type:            transaction.Type,
+		ProviderType:    database.ProviderTeller,
+	}
 }
 
 // JobProcessor handles job processing
 type JobProcessor struct {
-	rdb        *redis.Client
+	rdb        redis.UniversalClient
 	httpClient *http.Client
+	// webhookClient sends webhook deliveries to user supplied URLs, see newWebhookClient
+	webhookClient *http.Client
+	// blobs holds offloaded job data, nil when no blob store is configured
+	blobs         blobstore.Store
+	payloadLimits PayloadLimits
+	// maxWorkers is how many workers this replica started; control holds the pause and concurrency settings
+	maxWorkers int
+	control    atomic.Pointer[ControlState]
+	// replicaID and startedAt identify the replica on the /workers endpoint
+	replicaID string
+	startedAt time.Time
+	// jobRunPayloads is the JOB_RUN_PAYLOADS mode, see recordJobRun
+	jobRunPayloads string
 }
 
 // NewJobProcessor creates a new job processor
-func NewJobProcessor(addr string) *JobProcessor {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: "",
-		DB:       0,
-	})
+func NewJobProcessor(rdb redis.UniversalClient, blobs blobstore.Store) *JobProcessor {
 	// Load client certificates
 	cert, err := tls.LoadX509KeyPair("./certs/certificate.pem", "./certs/private_key.pem")
 	if err != nil {
@@ -111,68 +211,122 @@ func NewJobProcessor(addr string) *JobProcessor {
 		MinVersion:   tls.VersionTLS12,
 	}
 
-	// Create HTTP client with custom transport
+	// Create HTTP client with custom transport. Faults are injected into Teller requests when configured, see
+	// faultinject.LoadConfig.
 	httpClient := &http.Client{
-		Transport: &http.Transport{
+		Transport: telemetry.WrapTransport(faultinject.WrapTransport("teller", os.Getenv("TELLER_ENVIRONMENT"), &http.Transport{
 			TLSClientConfig: tlsConfig,
-		},
+		})),
 	}
-	return &JobProcessor{rdb: rdb, httpClient: httpClient}
+	return &JobProcessor{rdb: rdb, httpClient: httpClient, webhookClient: newWebhookClient(), blobs: blobs, payloadLimits: loadPayloadLimits(),
+		replicaID: replicaID(), startedAt: time.Now(), jobRunPayloads: loadJobRunPayloads()}
 }
 
 // EnqueueJob adds a job to the queue
 func (jp *JobProcessor) EnqueueJob(jobType string, data json.RawMessage) error {
-	job := Job{
-		ID:        fmt.Sprintf("job_%d", time.Now().UnixNano()),
-		Type:      jobType,
-		Data:      data,
-		CreatedAt: time.Now(),
-	}
+	return jp.EnqueueJobContext(ctx, jobType, data)
+}
 
-	jobJSON, err := json.Marshal(job)
-	if err != nil {
-		return fmt.Errorf("failed to marshal job: %w", err)
-	}
+// EnqueueJobContext adds a job to the queue as part of the trace in parent
+func (jp *JobProcessor) EnqueueJobContext(parent context.Context, jobType string, data json.RawMessage) error {
+	return jp.enqueue(parent, jobs.New(parent, jobType, data))
+}
 
-	// Add job to the queue (using LPUSH to add to the left of the list)
-	err = jp.rdb.LPush(ctx, "job_queue", jobJSON).Err()
-	if err != nil {
-		return fmt.Errorf("failed to enqueue job: %w", err)
+// enqueue offloads the job's data if needed, queues it and records it as queued
+func (jp *JobProcessor) enqueue(parent context.Context, job jobs.Job) error {
+	queued := job
+	if err := jp.encodeJobPayload(parent, &job); err != nil {
+		return err
+	}
+	if err := jobs.Enqueue(ctx, jp.rdb, &job); err != nil {
+		return err
 	}
 
+	jp.recordJobRun(&queued, database.JobRunQueued, nil)
 	log.Printf("✅ Enqueued job: %s (Type: %s)", job.ID, job.Type)
 	return nil
 }
 
-// DequeueJob removes and returns a job from the queue
-func (jp *JobProcessor) DequeueJob() (*Job, error) {
-	// Use BRPOP to block until a job is available (timeout: 5 seconds)
-	result, err := jp.rdb.BRPop(ctx, 5*time.Second, "job_queue").Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil, nil // No jobs available
-		}
-		return nil, fmt.Errorf("failed to dequeue job: %w", err)
-	}
+// DequeueJob removes and returns a job from the queue, or nil when none arrived within 5 seconds
+func (jp *JobProcessor) DequeueJob() (*jobs.Job, error) {
+	return jobs.Dequeue(ctx, jp.rdb, 5*time.Second)
+}
 
-	if len(result) < 2 {
-		return nil, fmt.Errorf("unexpected result format")
-	}
+// ProcessJob handles the actual job processing
+func (jp *JobProcessor) ProcessJob(job *jobs.Job) error {
+	log.Printf("🔄 Processing job: %s (Type: %s)", job.ID, job.Type)
+
+	spanCtx, span := tracer.Start(telemetry.ExtractMap(ctx, job.TraceContext), "job "+job.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("job.id", job.ID),
+			attribute.String("job.type", job.Type),
+		),
+	)
+	defer span.End()
+
+	timeout := jobTimeout(job.Type)
+	jobCtx, cancel := context.WithTimeout(spanCtx, timeout)
+	defer cancel()
+	job.SetContext(jobCtx)
 
-	jobJSON := result[1] // result[0] is the key name, result[1] is the value
-	var job Job
-	err = json.Unmarshal([]byte(jobJSON), &job)
+	err := jp.decodeJobPayload(jobCtx, job)
+	if err == nil && skipDeactivatedUserJob(job) {
+		log.Printf("⏸️ Skipping job %s (Type: %s), its user deactivated their account", job.ID, job.Type)
+		return nil
+	}
+	if err == nil {
+		jp.recordJobRun(job, database.JobRunRunning, nil)
+		err = jp.runJobRecovered(job)
+	}
+	if err != nil && errors.Is(jobCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %s: %w", errJobTimedOut, timeout, err)
+	}
+	if err != nil {
+		jp.recordJobRun(job, database.JobRunFailed, err)
+	} else {
+		jp.recordJobRun(job, database.JobRunSucceeded, nil)
+	}
+	span.SetAttributes(attribute.Bool("job.timed_out", errors.Is(err, errJobTimedOut)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	return err
+}
 
-	return &job, nil
+// runJobRecovered runs a job, turning a panic into an error so one bad job can't take down its worker
+func (jp *JobProcessor) runJobRecovered(job *jobs.Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tags, extra := jobReportContext(job)
+			errorreport.CapturePanic(recovered, tags, extra)
+			err = fmt.Errorf("%w: %v", errJobPanicked, recovered)
+		}
+	}()
+	return jp.runJob(job)
 }
 
-// ProcessJob handles the actual job processing
-func (jp *JobProcessor) ProcessJob(job *Job) error {
-	log.Printf("🔄 Processing job: %s (Type: %s)", job.ID, job.Type)
+// jobReportContext describes a job for error reports. The data has secrets such as access tokens redacted as it is
+// for job_runs, since reports leave for the error tracker.
+func jobReportContext(job *jobs.Job) (map[string]string, map[string]interface{}) {
+	tags := map[string]string{
+		"job.type": job.Type,
+		"job.id":   job.ID,
+	}
+	if spanContext := trace.SpanContextFromContext(job.Context()); spanContext.HasTraceID() {
+		tags["trace_id"] = spanContext.TraceID().String()
+	}
+	data, _ := redactJobPayload(job.Data)
+	extra := map[string]interface{}{
+		"job.data":       string(data),
+		"job.created_at": job.CreatedAt,
+	}
+	return tags, extra
+}
 
+// runJob dispatches a job to its handler
+func (jp *JobProcessor) runJob(job *jobs.Job) error {
 	switch job.Type {
 	case "hello_world":
 		return jp.processHelloWorld(job)
@@ -188,15 +342,63 @@ func (jp *JobProcessor) ProcessJob(job *Job) error {
 		return jp.processFetchPlaidTransactions(job)
 	case "sync_plaid_accounts":
 		return jp.syncPlaidAccounts(job)
+	case "add_plaid_item_accounts":
+		return jp.processAddPlaidItemAccounts(job)
+	case "backfill_plaid_history":
+		return jp.processBackfillPlaidHistory(job)
+	case "backfill_personal_finance_category":
+		return jp.processBackfillPersonalFinanceCategory(job)
 	case "process_daily_balance":
 		return jp.processDailyBalnce(job)
+	case "schedule_daily_balances":
+		return jp.processScheduleDailyBalances(job)
+	case "backfill_allowance_history":
+		return jp.processBackfillAllowanceHistory(job)
+	case "detect_transaction_anomalies":
+		return jp.processDetectTransactionAnomalies(job)
+	case "detect_price_increases":
+		return jp.processDetectPriceIncreases(job)
+	case "recalculate_saving_goals":
+		return jp.processRecalculateSavingGoals(job)
+	case "contribute_round_ups":
+		return jp.processContributeRoundUps(job)
+	case "update_debt_plans":
+		return jp.processUpdateDebtPlans(job)
+	case "compute_monthly_balances":
+		return jp.processComputeMonthlyBalances(job)
+	case "generate_monthly_report":
+		return jp.processGenerateMonthlyReport(job)
+	case "send_email_digests":
+		return jp.processSendEmailDigests(job)
+	case "seed_demo_data":
+		return jp.processSeedDemoData(job)
+	case "archive_old_transactions":
+		return jp.processArchiveOldTransactions(job)
+	case "deliver_webhooks":
+		return jp.processDeliverWebhooks(job)
+	case "deliver_channel_messages":
+		return jp.processDeliverChannelMessages(job)
+	case "flag_stale_accounts":
+		return jp.processFlagStaleAccounts(job)
+	case "refresh_institutions":
+		return jp.processRefreshInstitutions(job)
+	case "remind_teller_reauth":
+		return jp.processRemindTellerReauth(job)
+	case "export_google_sheets":
+		return jp.processExportGoogleSheets(job)
+	case "purge_deactivated_users":
+		return jp.processPurgeDeactivatedUsers(job)
+	case "check_emergency_funds":
+		return jp.processCheckEmergencyFunds(job)
+	case "import_history":
+		return jp.processImportHistory(job)
 	default:
 		return fmt.Errorf("unknown job type: %s", job.Type)
 	}
 }
 
 // processHelloWorld handles hello world jobs
-func (jp *JobProcessor) processHelloWorld(job *Job) error {
+func (jp *JobProcessor) processHelloWorld(job *jobs.Job) error {
 	fmt.Printf("🌍 Hello World! Job ID: %s, Data: %s\n", job.ID, string(job.Data))
 
 	// Simulate some processing time
@@ -207,7 +409,7 @@ func (jp *JobProcessor) processHelloWorld(job *Job) error {
 }
 
 // processPrintMessage handles print message jobs
-func (jp *JobProcessor) processPrintMessage(job *Job) error {
+func (jp *JobProcessor) processPrintMessage(job *jobs.Job) error {
 	fmt.Printf("📝 Message: %s (Job ID: %s)\n", string(job.Data), job.ID)
 
 	// Simulate some processing time
@@ -217,7 +419,7 @@ func (jp *JobProcessor) processPrintMessage(job *Job) error {
 	return nil
 }
 
-func (jp *JobProcessor) processFetchTransactions(job *Job) error {
+func (jp *JobProcessor) processFetchTransactions(job *jobs.Job) error {
 	log.Printf("🔄 Processing fetch transactions job: %s", job.ID)
 
 	// Parse job data to get account ID and user ID
@@ -251,26 +453,68 @@ func (jp *JobProcessor) processFetchTransactions(job *Job) error {
 		return fmt.Errorf("account_id not found in job data")
 	}
 
-	transactions, err := jp.fetchTellerTransactions(transactions_link, access_token)
-	if err != nil {
-		return fmt.Errorf("failed to fetch transactions: %w", err)
+	switch tellerEnrollmentStatus(int(user_id), access_token) {
+	case database.TellerEnrollmentReauthRequired:
+		log.Printf("⏸️ Skipping Teller transactions fetch for account %s until its enrollment is reconnected", account_id)
+		return nil
+	case database.TellerEnrollmentRemoved:
+		log.Printf("⏸️ Skipping Teller transactions fetch for account %s, its enrollment was removed", account_id)
+		return nil
 	}
 
-	// Save all transactions to the database in a single batch
-	savedTransactions, err := jp.SaveTellerTransactions(int(user_id), teller_institution_id, account_id, transactions)
+	saved, err := jp.syncTellerTransactions(job.Context(), int(user_id), teller_institution_id, account_id, transactions_link, access_token)
+	jp.recordTellerSyncOutcome(int(user_id), access_token, err)
 	if err != nil {
-		return fmt.Errorf("failed to save transactions: %w", err)
+		return err
+	}
+
+	// Recorded here as well as when the job finishes, so this account counts as synced for the first sync check
+	if err := database.RecordAccountSync("teller", account_id, int(user_id), nil); err != nil {
+		log.Printf("❌ %v", err)
 	}
+	completeFirstSyncIfAllSynced(int(user_id))
+	jp.emitWebhookEvent(job.Context(), int(user_id), database.WebhookEventSyncCompleted, job.ID, map[string]interface{}{
+		"provider":           "teller",
+		"account_id":         account_id,
+		"transactions_saved": saved,
+	})
+	jp.enqueueDailyBalanceIfSynced(job.Context(), int(user_id))
 
-	log.Printf("✅ Fetched and saved %d transactions for account: %s", len(savedTransactions), transactions_link)
+	log.Printf("✅ Fetched and saved %d transactions for account: %s", saved, transactions_link)
 	return nil
 }
 
-func (jp *JobProcessor) fetchTellerTransactions(transactions_link string, access_token string) ([]TellerTransaction, error) {
+// syncTellerTransactions fetches one account's transactions from Teller and saves them, returning how many were saved
+func (jp *JobProcessor) syncTellerTransactions(ctx context.Context, userID int, tellerInstitutionID string, accountID string, transactionsLink string, accessToken string) (int, error) {
+	transactions, err := jp.fetchTellerTransactions(ctx, transactionsLink, accessToken)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch transactions: %w", err)
+	}
+
+	// Save all transactions to the database in a single batch
+	savedTransactions, err := jp.SaveTellerTransactions(ctx, userID, tellerInstitutionID, accountID, transactions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save transactions: %w", err)
+	}
+	transactionIDs := make([]string, 0, len(savedTransactions))
+	for _, transaction := range savedTransactions {
+		transactionIDs = append(transactionIDs, transaction.dbID)
+		if transaction.inserted {
+			jp.emitWebhookEvent(ctx, userID, database.WebhookEventTransactionCreated, transaction.dbID, transaction.createdTransaction(userID))
+		}
+	}
+	jp.matchTransfers(ctx, userID)
+	jp.detectInvestmentContributions(ctx, userID)
+	jp.matchPlannedExpenses(ctx, userID)
+	jp.createBudgetAlerts(userID, transactionIDs)
+	return len(savedTransactions), nil
+}
+
+func (jp *JobProcessor) fetchTellerTransactions(reqCtx context.Context, transactions_link string, access_token string) ([]TellerTransaction, error) {
 	log.Printf("🔄 Fetching Teller transactions for link: %s", transactions_link)
 
 	// Create request to Teller API
-	req, err := http.NewRequest("GET", transactions_link, nil)
+	req, err := http.NewRequestWithContext(reqCtx, "GET", transactions_link, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -293,7 +537,7 @@ func (jp *JobProcessor) fetchTellerTransactions(transactions_link string, access
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, tellerAPIError(resp.StatusCode, body)
 	}
 
 	// Parse accounts from response
@@ -307,7 +551,7 @@ func (jp *JobProcessor) fetchTellerTransactions(transactions_link string, access
 }
 
 // processTellerSuccess handles Teller success jobs
-func (jp *JobProcessor) processTellerSuccess(job *Job) error {
+func (jp *JobProcessor) processTellerSuccess(job *jobs.Job) error {
 	log.Printf("🔄 Processing Teller success job: %s", job.ID)
 
 	// Parse job data to get access token and user ID
@@ -327,49 +571,145 @@ func (jp *JobProcessor) processTellerSuccess(job *Job) error {
 		return fmt.Errorf("user_id not found in job data")
 	}
 
+	switch tellerEnrollmentStatus(int(userID), accessToken) {
+	case database.TellerEnrollmentReauthRequired:
+		log.Printf("⏸️ Skipping Teller sync for user %d until the enrollment is reconnected", int(userID))
+		return nil
+	case database.TellerEnrollmentRemoved:
+		log.Printf("⏸️ Skipping Teller sync for user %d, the enrollment was removed", int(userID))
+		return nil
+	}
+
 	// Call the Teller API to fetch accounts
-	accounts, err := jp.fetchTellerAccounts(accessToken)
+	accounts, err := jp.fetchTellerAccounts(job.Context(), accessToken)
 	if err != nil {
+		jp.recordTellerSyncOutcome(int(userID), accessToken, err)
 		return fmt.Errorf("failed to fetch Teller accounts: %w", err)
 	}
 
-	createdAccounts := []TellerAccount{}
-	// Save each account to the database
-	for _, account := range accounts {
-		savedAccount, err := jp.SaveTellerAccount(int(userID), accessToken, account)
+	// Each account is saved and its transactions fetched concurrently. One account failing doesn't stop the
+	// others; failures are reported together once every account has been tried.
+	results := make([]error, len(accounts))
+	saved := make([]int, len(accounts))
+	var group errgroup.Group
+	group.SetLimit(tellerSyncParallelism())
+	for i, account := range accounts {
+		group.Go(func() error {
+			saved[i], results[i] = jp.syncTellerAccount(job.Context(), int(userID), accessToken, account)
+			return nil
+		})
+	}
+	group.Wait()
+
+	var failures []error
+	for i, err := range results {
+		if recordErr := database.RecordAccountSync("teller", accounts[i].ID, int(userID), err); recordErr != nil {
+			log.Printf("❌ %v", recordErr)
+		}
 		if err != nil {
-			log.Printf("❌ Failed to save account %s: %v", account.ID, err)
+			log.Printf("❌ Failed to sync Teller account %s: %v", accounts[i].ID, err)
+			failures = append(failures, fmt.Errorf("account %s: %w", accounts[i].ID, err))
 			continue
 		}
-		log.Printf("✅ Saved account: %s (%s) - %s", savedAccount.Name, savedAccount.Type, savedAccount.Institution.Name)
-		createdAccounts = append(createdAccounts, *savedAccount)
+		log.Printf("✅ Synced Teller account %s: %d transactions", accounts[i].ID, saved[i])
 	}
 
-	log.Printf("✅ Completed Teller success job: %s", job.ID)
-	// enqueue job to fetch transactions for each teller account
-	for _, account := range createdAccounts {
-		// Create JSON data for the fetch_transactions job
-		jobData := map[string]interface{}{
-			"account_id":            account.ID,
-			"user_id":               int(userID),
-			"access_token":          accessToken,
-			"transactions_link":     account.Links.Transactions,
-			"teller_institution_id": account.TellerInstitutionID,
-		}
-		jobDataJSON, _ := json.Marshal(jobData)
-		jp.EnqueueJob("fetch_transactions", jobDataJSON)
+	jp.recordTellerSyncOutcome(int(userID), accessToken, errors.Join(failures...))
+
+	completeFirstSyncIfAllSynced(int(userID))
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to sync %d of %d Teller accounts: %w", len(failures), len(accounts), errors.Join(failures...))
+	}
+	transactionsSaved := 0
+	for _, count := range saved {
+		transactionsSaved += count
+	}
+	jp.emitWebhookEvent(job.Context(), int(userID), database.WebhookEventSyncCompleted, job.ID, map[string]interface{}{
+		"provider":           "teller",
+		"accounts":           len(accounts),
+		"transactions_saved": transactionsSaved,
+	})
+	// A new enrollment is a first sync like the Plaid one, so the month's allowance history is filled in before the
+	// daily balance
+	backfill := allowanceBackfillStep(int(userID))
+	if err := jp.EnqueueJobContext(job.Context(), backfill.Type, backfill.Data); err != nil {
+		log.Printf("❌ Failed to enqueue allowance history backfill for user %d: %v", int(userID), err)
 	}
+	log.Printf("✅ Completed Teller success job: %s (%d accounts)", job.ID, len(accounts))
 	return nil
 }
 
-// SaveTellerTransactions saves multiple Teller transactions to the database in a single batch
-func (jp *JobProcessor) SaveTellerTransactions(userID int, teller_institution_id string, teller_account_id string, transactions []TellerTransaction) ([]TellerTransaction, error) {
+// defaultTellerSyncParallelism is how many accounts of one enrollment are synced at once, overridable with
+// TELLER_SYNC_PARALLELISM
+const defaultTellerSyncParallelism = 4
+
+func tellerSyncParallelism() int {
+	if val := os.Getenv("TELLER_SYNC_PARALLELISM"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 {
+			log.Printf("❌ Invalid TELLER_SYNC_PARALLELISM %q, using %d", val, defaultTellerSyncParallelism)
+		} else {
+			return parsed
+		}
+	}
+	return defaultTellerSyncParallelism
+}
+
+// syncTellerAccount saves a Teller account and its transactions, returning how many transactions were saved
+func (jp *JobProcessor) syncTellerAccount(ctx context.Context, userID int, accessToken string, account TellerAccount) (int, error) {
+	savedAccount, err := jp.SaveTellerAccount(ctx, userID, accessToken, account)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("✅ Saved account: %s (%s) - %s", savedAccount.Name, savedAccount.Type, savedAccount.Institution.Name)
+	// Balances feed safe-to-spend but aren't needed to sync transactions, so failing to get them doesn't fail the
+	// account
+	if err := jp.syncTellerBalances(ctx, userID, savedAccount.ID, savedAccount.Links.Balances, accessToken); err != nil {
+		log.Printf("❌ Failed to sync balances of Teller account %s: %v", savedAccount.ID, err)
+	}
+	return jp.syncTellerTransactions(ctx, userID, savedAccount.TellerInstitutionID, savedAccount.ID, savedAccount.Links.Transactions, accessToken)
+}
+
+// providerTransaction adapts the transaction into the columns every provider shares, reading the decimal strings
+// Teller sends for amounts. The running balance is returned separately, nil when Teller doesn't report one.
+func (transaction TellerTransaction) providerTransaction(accountID string) (database.ProviderTransaction, *money.Money, error) {
+	amount, err := money.Parse(transaction.Amount)
+	if err != nil {
+		return database.ProviderTransaction{}, nil, fmt.Errorf("failed to read amount: %w", err)
+	}
+	var runningBalance *money.Money
+	if transaction.RunningBalance != "" {
+		balance, err := money.Parse(transaction.RunningBalance)
+		if err != nil {
+			return database.ProviderTransaction{}, nil, fmt.Errorf("failed to read running balance: %w", err)
+		}
+		runningBalance = &balance
+	}
+	// Teller sends a single category where Plaid sends a list
+	var category []string
+	if transaction.Details.Category != "" {
+		category = []string{transaction.Details.Category}
+	}
+	return database.ProviderTransaction{
+		Provider:              database.ProviderTeller,
+		ProviderTransactionID: transaction.ID,
+		AccountRef:            accountID,
+		Amount:                amount,
+		Merchant:              transaction.Details.Counterparty.Name,
+		Category:              category,
+	}, runningBalance, nil
+}
+
+// SaveTellerTransactions saves multiple Teller transactions to the database in a single batch.
+// Transactions whose amounts can't be read are skipped and reported rather than failing the batch.
+func (jp *JobProcessor) SaveTellerTransactions(reqCtx context.Context, userID int, teller_institution_id string, teller_account_id string, transactions []TellerTransaction) ([]TellerTransaction, error) {
 	if len(transactions) == 0 {
 		return []TellerTransaction{}, nil
 	}
 
 	// Start a transaction for batch insert
-	tx, err := database.DB.Begin()
+	tx, err := database.DB.BeginTx(reqCtx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -381,10 +721,11 @@ func (jp *JobProcessor) SaveTellerTransactions(userID int, teller_institution_id
 			user_id, teller_institution_id, teller_account_id, teller_transaction_id,
 			amount, description, date, type, status, running_balance,
 			processing_status, category, counterparty_name, counterparty_type,
+			provider_type, account_ref, merchant, provider_transaction_id,
 			self_link, account_link, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
-		) ON CONFLICT (id) DO UPDATE SET
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''), $4, $18, $19, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		) ON CONFLICT (provider_type, provider_transaction_id) DO UPDATE SET
 			amount = EXCLUDED.amount,
 			description = EXCLUDED.description,
 			date = EXCLUDED.date,
@@ -395,39 +736,47 @@ func (jp *JobProcessor) SaveTellerTransactions(userID int, teller_institution_id
 			category = EXCLUDED.category,
 			counterparty_name = EXCLUDED.counterparty_name,
 			counterparty_type = EXCLUDED.counterparty_type,
+			merchant = EXCLUDED.merchant,
 			self_link = EXCLUDED.self_link,
 			account_link = EXCLUDED.account_link,
 			updated_at = CURRENT_TIMESTAMP
-		RETURNING id, user_id, teller_transaction_id, amount, description, date, type, status, running_balance,
-			processing_status, category, counterparty_name, counterparty_type, self_link, account_link, created_at, updated_at
+		RETURNING id, user_id, teller_transaction_id, amount, description, date, type, status, COALESCE(running_balance::text, ''),
+			processing_status, COALESCE(category->>0, ''), counterparty_name, counterparty_type, self_link, account_link, created_at, updated_at,
+			xmax = 0
 	`
 
-	// Prepare the statement
-	stmt, err := tx.Prepare(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %w", err)
-	}
-	defer stmt.Close()
-
 	// Insert all transactions
+	scope := database.ScopeToUser(userID).InTx(tx)
 	var savedTransactions []TellerTransaction
 	for _, transaction := range transactions {
 		var savedTransaction TellerTransaction
-		var dbID string
 		var dbUserID int
 		var createdAt, updatedAt time.Time
 
-		err := stmt.QueryRow(
-			userID, teller_institution_id, teller_account_id, transaction.ID,
-			transaction.Amount, transaction.Description, transaction.Date, transaction.Type, transaction.Status, transaction.RunningBalance,
-			transaction.Details.ProcessingStatus, transaction.Details.Category, transaction.Details.Counterparty.Name, transaction.Details.Counterparty.Type,
+		normalized, runningBalance, err := transaction.providerTransaction(teller_account_id)
+		if err != nil {
+			// One malformed transaction shouldn't hold back the rest of the account's history
+			log.Printf("❌ Skipping Teller transaction %s: %v", transaction.ID, err)
+			errorreport.CaptureError(err,
+				map[string]string{"teller.transaction_id": transaction.ID, "teller.account_id": teller_account_id},
+				map[string]interface{}{"teller.amount": transaction.Amount, "teller.running_balance": transaction.RunningBalance},
+			)
+			continue
+		}
+		err = scope.QueryRowContext(reqCtx, query,
+			teller_institution_id, teller_account_id, normalized.ProviderTransactionID,
+			normalized.Amount, transaction.Description, transaction.Date, transaction.Type, transaction.Status, runningBalance,
+			transaction.Details.ProcessingStatus, normalized.CategoryJSON(), transaction.Details.Counterparty.Name, transaction.Details.Counterparty.Type,
+			normalized.Provider, normalized.AccountRef, normalized.Merchant,
 			transaction.Links.Self, transaction.Links.Account,
 		).Scan(
-			&dbID, &dbUserID, &savedTransaction.ID, &savedTransaction.Amount, &savedTransaction.Description,
+			&savedTransaction.dbID, &dbUserID, &savedTransaction.ID, &savedTransaction.Amount, &savedTransaction.Description,
 			&savedTransaction.Date, &savedTransaction.Type, &savedTransaction.Status, &savedTransaction.RunningBalance,
 			&savedTransaction.Details.ProcessingStatus, &savedTransaction.Details.Category,
 			&savedTransaction.Details.Counterparty.Name, &savedTransaction.Details.Counterparty.Type,
 			&savedTransaction.Links.Self, &savedTransaction.Links.Account, &createdAt, &updatedAt,
+			// xmax is only zero on rows the statement inserted rather than updated
+			&savedTransaction.inserted,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to insert transaction %s: %w", transaction.ID, err)
@@ -447,10 +796,10 @@ func (jp *JobProcessor) SaveTellerTransactions(userID int, teller_institution_id
 }
 
 // SaveTellerAccount saves a Teller account to the database and returns the saved account
-func (jp *JobProcessor) SaveTellerAccount(userID int, accessToken string, account TellerAccount) (*TellerAccount, error) {
+func (jp *JobProcessor) SaveTellerAccount(reqCtx context.Context, userID int, accessToken string, account TellerAccount) (*TellerAccount, error) {
 	query := `
 		INSERT INTO teller_accounts (
-			id, user_id, teller_institution_id, enrollment_id, 
+			user_id, id, teller_institution_id, enrollment_id,
 			account_name, account_type, account_subtype, currency, last_four, status,
 			institution_id, institution_name, self_link, details_link, balances_link, transactions_link
 		) VALUES (
@@ -467,6 +816,8 @@ func (jp *JobProcessor) SaveTellerAccount(userID int, accessToken string, accoun
 			details_link = EXCLUDED.details_link,
 			balances_link = EXCLUDED.balances_link,
 			transactions_link = EXCLUDED.transactions_link,
+			teller_institution_id = EXCLUDED.teller_institution_id,
+			deleted_at = NULL,
 			updated_at = CURRENT_TIMESTAMP
 		RETURNING id, user_id, account_name, account_type, account_subtype, institution_name, self_link, details_link, balances_link, transactions_link,
 			created_at, updated_at
@@ -474,9 +825,9 @@ func (jp *JobProcessor) SaveTellerAccount(userID int, accessToken string, accoun
 
 	// Get the teller_institution_id for this user and enrollment
 	var tellerInstitutionID string
-	err := database.DB.QueryRow(
+	err := database.ScopeToUser(userID).QueryRowContext(reqCtx,
 		"SELECT id FROM teller_institutions WHERE user_id = $1 AND access_token = $2",
-		userID, accessToken,
+		accessToken,
 	).Scan(&tellerInstitutionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get teller institution ID: %w", err)
@@ -487,8 +838,8 @@ func (jp *JobProcessor) SaveTellerAccount(userID int, accessToken string, accoun
 	var dbUserID int
 	var createdAt, updatedAt time.Time
 
-	err = database.DB.QueryRow(query,
-		account.ID, userID, tellerInstitutionID, account.EnrollmentID,
+	err = database.ScopeToUser(userID).QueryRowContext(reqCtx, query,
+		account.ID, tellerInstitutionID, account.EnrollmentID,
 		account.Name, account.Type, account.Subtype, account.Currency, account.LastFour, account.Status,
 		account.Institution.ID, account.Institution.Name,
 		account.Links.Self, account.Links.Details, account.Links.Balances, account.Links.Transactions,
@@ -506,12 +857,67 @@ func (jp *JobProcessor) SaveTellerAccount(userID int, accessToken string, accoun
 	return &savedAccount, nil
 }
 
+// syncTellerBalances fetches an account's balances and saves them on its teller_accounts row
+func (jp *JobProcessor) syncTellerBalances(ctx context.Context, userID int, accountID string, balancesLink string, accessToken string) error {
+	if balancesLink == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", balancesLink, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(accessToken, "")
+	resp, err := jp.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return tellerAPIError(resp.StatusCode, body)
+	}
+	var balances TellerBalances
+	if err := json.Unmarshal(body, &balances); err != nil {
+		return fmt.Errorf("failed to parse balances response: %w", err)
+	}
+
+	parse := func(amount string) (*money.Money, error) {
+		if amount == "" {
+			return nil, nil
+		}
+		parsed, err := money.Parse(amount)
+		if err != nil {
+			return nil, err
+		}
+		return &parsed, nil
+	}
+	available, err := parse(balances.Available)
+	if err != nil {
+		return fmt.Errorf("failed to read available balance: %w", err)
+	}
+	ledger, err := parse(balances.Ledger)
+	if err != nil {
+		return fmt.Errorf("failed to read ledger balance: %w", err)
+	}
+	_, err = database.ScopeToUser(userID).ExecContext(ctx,
+		"UPDATE teller_accounts SET available_balance = $2, ledger_balance = $3, balances_updated_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id = $4",
+		available, ledger, accountID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save teller balances: %w", err)
+	}
+	return nil
+}
+
 // fetchTellerAccounts fetches accounts from Teller API using client certificates
-func (jp *JobProcessor) fetchTellerAccounts(accessToken string) ([]TellerAccount, error) {
+func (jp *JobProcessor) fetchTellerAccounts(reqCtx context.Context, accessToken string) ([]TellerAccount, error) {
 	log.Printf("🔄 Fetching Teller accounts for token: %.10s...", accessToken)
 
 	// Create request to Teller API
-	req, err := http.NewRequest("GET", "https://api.teller.io/accounts", nil)
+	req, err := http.NewRequestWithContext(reqCtx, "GET", "https://api.teller.io/accounts", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -534,7 +940,7 @@ func (jp *JobProcessor) fetchTellerAccounts(accessToken string) ([]TellerAccount
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, tellerAPIError(resp.StatusCode, body)
 	}
 
 	// Parse accounts from response
@@ -549,7 +955,7 @@ func (jp *JobProcessor) fetchTellerAccounts(accessToken string) ([]TellerAccount
 
 // PLAID
 
-func (jp *JobProcessor) processInitialPlaidSync(job *Job) error {
+func (jp *JobProcessor) processInitialPlaidSync(job *jobs.Job) error {
 	log.Printf("🔄 Processing initial Plaid sync job: %s", job.ID)
 
 	var jobData map[string]interface{}
@@ -558,7 +964,7 @@ func (jp *JobProcessor) processInitialPlaidSync(job *Job) error {
 	}
 	// log.Printf("🔄 Job data: %v", jobData)
 	accessToken := jobData["access_token"].(string)
-	accounts, err := plaid.GetAccounts(accessToken)
+	accounts, err := plaid.GetAccounts(job.Context(), accessToken)
 	if err != nil {
 		return fmt.Errorf("failed to get transactions: %w", err)
 	}
@@ -574,26 +980,105 @@ func (jp *JobProcessor) processInitialPlaidSync(job *Job) error {
 		return fmt.Errorf("failed to mark plaid token as processed: %w", err)
 	}
 
-	err = database.CreatePlaidAccount(userID, plaidTokenID, accounts)
+	err = database.CreatePlaidAccount(job.Context(), userID, plaidTokenID, accounts)
 	if err != nil {
 		return fmt.Errorf("failed to create plaid account: %w", err)
 	}
 
 	log.Printf("✅ Completed initial Plaid sync job: %s", job.ID)
 
-	// enqueue job to fetch transactions for each plaid account
+	balanceJSON, _ := json.Marshal(map[string]interface{}{"user_id": userID})
+	jp.EnqueueJobContext(job.Context(), "compute_monthly_balances", balanceJSON)
+	// Records the new item's institution and fetches its branding if no one linked it before
+	jp.EnqueueJobContext(job.Context(), "refresh_institutions", json.RawMessage(`{}`))
+
+	// Fetch transactions for each plaid account, then fill in the month's allowance history and work out the daily
+	// balance once they are all in. History backfills run on their own since they can take much longer.
+	var steps []WorkflowStep
 	for _, account := range accounts {
 		jobData := map[string]interface{}{
 			"account_id": account.GetAccountId(),
 			"user_id":    userID,
 		}
 		jobDataJSON, _ := json.Marshal(jobData)
-		jp.EnqueueJob("fetch_plaid_transactions", jobDataJSON)
+		steps = append(steps, WorkflowStep{Type: "fetch_plaid_transactions", Data: jobDataJSON})
+		jp.EnqueueJobContext(job.Context(), "backfill_plaid_history", jobDataJSON)
+	}
+	if _, err := jp.StartWorkflow(job.Context(), steps, allowanceBackfillStep(userID)); err != nil {
+		return fmt.Errorf("failed to start transaction fetch workflow: %w", err)
+	}
+	return nil
+}
+
+// completeFirstSyncIfAllSynced records the first_sync_complete onboarding step once every account the user has
+// linked, Plaid or Teller, has synced. Failures are only logged.
+func completeFirstSyncIfAllSynced(userID int) {
+	plaidSynced, err := database.GetAllAccountsSynced(userID)
+	if err != nil {
+		log.Printf("❌ Failed to check accounts synced for user %d: %v", userID, err)
+		return
+	}
+	tellerSynced, err := database.GetAllTellerAccountsSynced(userID)
+	if err != nil {
+		log.Printf("❌ Failed to check Teller accounts synced for user %d: %v", userID, err)
+		return
+	}
+	if !plaidSynced || !tellerSynced {
+		return
+	}
+	if err := database.CompleteOnboardingStep(userID, database.OnboardingFirstSyncComplete); err != nil {
+		log.Printf("❌ Failed to record onboarding step for user %d: %v", userID, err)
+	}
+}
+
+// enqueueDailyBalanceIfSynced recomputes the user's budget for the current month once every linked account has
+// synced, so budgets reflect new transactions without the app asking. It is debounced together with the API's
+// requests, so a burst of account syncs queues a single recompute. Failures are only logged.
+func (jp *JobProcessor) enqueueDailyBalanceIfSynced(parent context.Context, userID int) {
+	allSynced, err := database.GetAllAccountsSynced(userID)
+	if err != nil {
+		log.Printf("❌ Failed to check accounts synced for user %d: %v", userID, err)
+		return
+	}
+	if !allSynced {
+		return
+	}
+	if err := jp.enqueueDailyBalance(parent, userID, database.ToMonthYear(time.Now()), dailyBalanceStep(userID)); err != nil {
+		log.Printf("❌ Failed to enqueue daily balance job for user %d: %v", userID, err)
+	}
+}
+
+// enqueueDailyBalance enqueues the user's process_daily_balance step for monthYear, unless one for the same user
+// and month was enqueued within the debounce window
+func (jp *JobProcessor) enqueueDailyBalance(parent context.Context, userID int, monthYear int, step WorkflowStep) error {
+	key := jobs.DailyBalanceDebounceKey(userID, monthYear)
+	claimed, jobID, err := jobs.ClaimDebounce(ctx, jp.rdb, key, jobs.DailyBalanceDebounceWindow)
+	if err != nil {
+		log.Printf("❌ Daily balance debounce failed for user %d, enqueueing anyway: %v", userID, err)
+	} else if !claimed {
+		log.Printf("🔄 Daily balance job for user %d already queued: %s", userID, jobID)
+		return nil
+	}
+	job := jobs.New(parent, step.Type, step.Data)
+	if err := jp.enqueue(parent, job); err != nil {
+		jobs.ReleaseDebounce(ctx, jp.rdb, key)
+		return err
+	}
+	if err := jobs.RecordDebouncedJob(ctx, jp.rdb, key, job.ID); err != nil {
+		log.Printf("❌ %v", err)
 	}
 	return nil
 }
 
-func (jp *JobProcessor) syncPlaidAccounts(job *Job) error {
+// dailyBalanceStep is the process_daily_balance job for the user's current month, which ends sync workflows
+func dailyBalanceStep(userID int) WorkflowStep {
+	return NewWorkflowStep("process_daily_balance", map[string]interface{}{
+		"user_id":    userID,
+		"month_year": database.ToMonthYear(time.Now()),
+	})
+}
+
+func (jp *JobProcessor) syncPlaidAccounts(job *jobs.Job) error {
 	var jobData map[string]interface{}
 	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
 		return fmt.Errorf("failed to parse job data: %w", err)
@@ -605,18 +1090,34 @@ func (jp *JobProcessor) syncPlaidAccounts(job *Job) error {
 	if err != nil {
 		return fmt.Errorf("failed to get plaid accounts by user id: %w", err)
 	}
+	var steps []WorkflowStep
 	for _, accountID := range accounts {
-		jobData := map[string]interface{}{
+		steps = append(steps, NewWorkflowStep("fetch_plaid_transactions", map[string]interface{}{
 			"account_id": accountID,
 			"user_id":    userID,
+		}))
+	}
+	if _, err := jp.StartWorkflow(job.Context(), steps, dailyBalanceStep(userID)); err != nil {
+		return fmt.Errorf("failed to start transaction fetch workflow: %w", err)
+	}
+	// A resync asking for more history than the accounts have extends their backfill to it
+	if months, ok := jobData["history_months"].(float64); ok {
+		for _, accountID := range accounts {
+			backfillJSON, _ := json.Marshal(map[string]interface{}{
+				"account_id": accountID,
+				"user_id":    userID,
+				"months":     int(months),
+			})
+			jp.EnqueueJobContext(job.Context(), "backfill_plaid_history", backfillJSON)
 		}
-		jobDataJSON, _ := json.Marshal(jobData)
-		jp.EnqueueJob("fetch_plaid_transactions", jobDataJSON)
 	}
+	// Fill in personal finance categories for rows stored before they were captured
+	backfillJSON, _ := json.Marshal(map[string]interface{}{"user_id": userID})
+	jp.EnqueueJobContext(job.Context(), "backfill_personal_finance_category", backfillJSON)
 	return nil
 }
 
-func (jp *JobProcessor) processFetchPlaidTransactions(job *Job) error {
+func (jp *JobProcessor) processFetchPlaidTransactions(job *jobs.Job) error {
 	log.Printf("🔄 Processing Plaid transactions fetch job: %s", job.ID)
 	var jobData map[string]interface{}
 	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
@@ -639,62 +1140,239 @@ func (jp *JobProcessor) processFetchPlaidTransactions(job *Job) error {
 	month := int(monthYear / 10000)
 	year := int(monthYear % 10000)
 	location := time.Now().Location()
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, location).Format(time.RFC3339)
-	endDate := time.Date(year, time.Month(month+1), 0, 23, 59, 59, 0, location).Format(time.RFC3339)
+	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, location).Format(iso8601TimeFormat)
+	endDate := time.Date(year, time.Month(month+1), 0, 23, 59, 59, 0, location).Format(iso8601TimeFormat)
 	log.Printf("🔄 Fetching transactions from %s to %s", startDate, endDate)
-	transactions, err := plaid.GetTransactions(accessToken, startDate, endDate)
-	if err != nil {
-		return fmt.Errorf("failed to get transactions: %w", err)
+	transactions, fetchErr := plaid.GetTransactions(job.Context(), accessToken, startDate, endDate)
+	var partialErr *plaid.PartialFetchError
+	if fetchErr != nil && !errors.As(fetchErr, &partialErr) {
+		return fmt.Errorf("failed to get transactions: %w", fetchErr)
 	}
 	log.Printf("✅ Fetched %d transactions from Plaid", len(transactions))
-	// save transactions to database
-	err = database.CreatePlaidTransactions(userID, accountID, transactions)
+	// save transactions to database, even if only some pages were fetched
+	created, err := database.CreatePlaidTransactions(job.Context(), userID, transactions)
 	if err != nil {
 		return fmt.Errorf("failed to create plaid transactions: %w", err)
 	}
+	createdIDs := make([]string, 0, len(created))
+	for _, transaction := range created {
+		createdIDs = append(createdIDs, transaction.TransactionID)
+		jp.emitWebhookEvent(job.Context(), userID, database.WebhookEventTransactionCreated, transaction.TransactionID, transaction)
+	}
+	// Accrue round-ups on any new card purchases for users who opted in
+	if accrued, err := database.AccrueRoundUps(userID); err != nil {
+		log.Printf("❌ Failed to accrue round ups for user %d: %v", userID, err)
+	} else if accrued > 0 {
+		log.Printf("🔄 Accrued %d round ups for user %d", accrued, userID)
+	}
+	jp.matchTransfers(job.Context(), userID)
+	jp.detectInvestmentContributions(job.Context(), userID)
+	jp.matchPlannedExpenses(job.Context(), userID)
+	jp.createBudgetAlerts(userID, createdIDs)
+	if partialErr != nil {
+		// Leave the account unsynced so the job is retried for the remaining pages
+		return fmt.Errorf("partially fetched transactions: %w", partialErr)
+	}
 
 	// Mark plaid Account as synced
 	err = database.MarkPlaidAccountAsSynced(accountID)
 	if err != nil {
 		return fmt.Errorf("failed to mark plaid account as synced: %w", err)
 	}
+	jp.emitWebhookEvent(job.Context(), userID, database.WebhookEventSyncCompleted, job.ID, map[string]interface{}{
+		"provider":             "plaid",
+		"account_id":           accountID,
+		"transactions_fetched": len(transactions),
+		"transactions_created": len(created),
+	})
+	completeFirstSyncIfAllSynced(userID)
+	// Workflows end with their own daily balance job once every account has been fetched
+	if job.WorkflowID == "" {
+		jp.enqueueDailyBalanceIfSynced(job.Context(), userID)
+	}
 	log.Printf("✅ Completed Plaid transactions fetch job: %s", job.ID)
 	return nil
 }
 
-func calculateSpendByCategory(category database.MonthlyBudgetSpendCategory) (float64, error) {
-	transactions, err := database.GetTransactionsByCategory(category.UserID, category.Category, category.MonthYear)
+const (
+	maxBackfillMonths = database.MaxSyncHistoryMonths
+	backfillPageSize  = 500
+	iso8601TimeFormat = "2006-01-02"
+)
+
+// processBackfillPlaidHistory walks back month by month for a single account, paging through each month
+// with offset/count and persisting progress after every page so a failed run resumes where it stopped. It goes
+// back the months in the job data, or the user's sync history preference without them.
+func (jp *JobProcessor) processBackfillPlaidHistory(job *jobs.Job) error {
+	log.Printf("🔄 Processing Plaid history backfill job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	accountID, ok := jobData["account_id"].(string)
+	if !ok {
+		return fmt.Errorf("account_id not found in job data")
+	}
+	userIDFloat, ok := jobData["user_id"].(float64)
+	if !ok {
+		return fmt.Errorf("user_id not found in job data")
+	}
+	userID := int(userIDFloat)
+	var months int
+	if val, ok := jobData["months"].(float64); ok && int(val) >= database.MinSyncHistoryMonths && int(val) <= maxBackfillMonths {
+		months = int(val)
+	} else {
+		preferences, err := database.GetUserPreferences(userID)
+		if err != nil {
+			return err
+		}
+		months = preferences.SyncHistoryMonths
+	}
+
+	accessToken, err := database.GetAccessTokenFromAccountID(accountID)
 	if err != nil {
-		log.Printf("❌ Failed to get transactions by category: %v", err)
-		return 0, fmt.Errorf("failed to get transactions by category: %w", err)
+		return fmt.Errorf("failed to get access token from account id: %w", err)
 	}
-	totalSpent := 0.0
-	for _, transaction := range transactions {
-		totalSpent += transaction.Amount
+
+	progress, err := database.GetOrCreatePlaidBackfillProgress(userID, accountID, months)
+	if err != nil {
+		return fmt.Errorf("failed to get backfill progress: %w", err)
+	}
+	if progress.MonthsCompleted >= progress.MonthsRequested {
+		log.Printf("✅ Backfill already completed for account %s", accountID)
+		return nil
+	}
+	log.Printf("🔄 Resuming backfill for account %s at month %d/%d, offset %d", accountID, progress.MonthsCompleted, progress.MonthsRequested, progress.NextOffset)
+
+	progress.Status = "running"
+	progress.LastError = ""
+	if err := database.UpdatePlaidBackfillProgress(*progress); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	currentMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	for progress.MonthsCompleted < progress.MonthsRequested {
+		monthStart := currentMonthStart.AddDate(0, -progress.MonthsCompleted, 0)
+		monthEnd := monthStart.AddDate(0, 1, -1)
+		if monthEnd.After(now) {
+			monthEnd = now
+		}
+		startDate := monthStart.Format(iso8601TimeFormat)
+		endDate := monthEnd.Format(iso8601TimeFormat)
+
+		for {
+			transactions, total, err := plaid.GetTransactionsPage(job.Context(), accessToken, accountID, startDate, endDate, progress.NextOffset, backfillPageSize)
+			if err != nil {
+				return jp.failBackfill(progress, fmt.Errorf("failed to get transactions for %s to %s: %w", startDate, endDate, err))
+			}
+			if _, err := database.CreatePlaidTransactions(job.Context(), userID, transactions); err != nil {
+				return jp.failBackfill(progress, fmt.Errorf("failed to save transactions for %s to %s: %w", startDate, endDate, err))
+			}
+			progress.NextOffset += len(transactions)
+			if err := database.UpdatePlaidBackfillProgress(*progress); err != nil {
+				return err
+			}
+			log.Printf("🔄 Backfilled %d/%d transactions for %s to %s", progress.NextOffset, total, startDate, endDate)
+			if len(transactions) == 0 || progress.NextOffset >= total {
+				break
+			}
+		}
+
+		progress.MonthsCompleted++
+		progress.NextOffset = 0
+		if err := database.UpdatePlaidBackfillProgress(*progress); err != nil {
+			return err
+		}
+	}
+
+	progress.Status = "completed"
+	if err := database.UpdatePlaidBackfillProgress(*progress); err != nil {
+		return err
 	}
-	return totalSpent, nil
+	log.Printf("✅ Completed Plaid history backfill job: %s (%d months)", job.ID, progress.MonthsCompleted)
+	return nil
 }
 
-func calculateSpendExcludingCategories(category database.MonthlyBudgetSpendCategory, categoriesToExclude []string) (float64, error) {
-	transactions, err := database.GetTransactionsExcludingCategories(category.UserID, categoriesToExclude, category.MonthYear)
+// processBackfillPersonalFinanceCategory re-fetches a user's older Plaid transactions and fills in
+// personal finance categories on rows that were stored before the option was enabled
+func (jp *JobProcessor) processBackfillPersonalFinanceCategory(job *jobs.Job) error {
+	log.Printf("🔄 Processing personal finance category backfill job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	userIDFloat, ok := jobData["user_id"].(float64)
+	if !ok {
+		return fmt.Errorf("user_id not found in job data")
+	}
+	userID := int(userIDFloat)
+
+	windows, err := database.GetPlaidTokensMissingPersonalFinanceCategory(userID)
 	if err != nil {
-		log.Printf("❌ Failed to get transactions by category: %v", err)
-		return 0, fmt.Errorf("failed to get transactions by category: %w", err)
+		return fmt.Errorf("failed to get plaid tokens missing personal finance category: %w", err)
+	}
+	endDate := time.Now().Format(iso8601TimeFormat)
+	var updated int64
+	for _, window := range windows {
+		transactions, err := plaid.GetTransactions(job.Context(), window.AccessToken, window.StartDate.Format(iso8601TimeFormat), endDate)
+		if err != nil && len(transactions) == 0 {
+			return fmt.Errorf("failed to get transactions: %w", err)
+		}
+		count, updateErr := database.UpdatePlaidTransactionPersonalFinanceCategories(job.Context(), transactions)
+		if updateErr != nil {
+			return fmt.Errorf("failed to update personal finance categories: %w", updateErr)
+		}
+		updated += count
+		if err != nil {
+			return fmt.Errorf("partially backfilled personal finance categories: %w", err)
+		}
 	}
-	totalSpent := 0.0
-	for _, transaction := range transactions {
-		totalSpent += transaction.Amount
+	log.Printf("✅ Completed personal finance category backfill job: %s (%d rows updated)", job.ID, updated)
+	return nil
+}
+
+// failBackfill records the error against the backfill progress so the next run can resume from the last saved page
+func (jp *JobProcessor) failBackfill(progress *database.PlaidBackfillProgress, cause error) error {
+	progress.Status = "failed"
+	progress.LastError = cause.Error()
+	if err := database.UpdatePlaidBackfillProgress(*progress); err != nil {
+		log.Printf("❌ Failed to record backfill failure: %v", err)
+	}
+	return cause
+}
+
+// budgetStrategy returns the allowance redistribution strategy named by BUDGET_REDISTRIBUTION_STRATEGY
+func budgetStrategy() budget.Strategy {
+	if val := os.Getenv("BUDGET_REDISTRIBUTION_STRATEGY"); val != "" {
+		strategy, err := budget.StrategyNamed(val)
+		if err == nil {
+			return strategy
+		}
+		log.Printf("❌ Invalid BUDGET_REDISTRIBUTION_STRATEGY %q, using %s", val, budget.DefaultStrategy)
 	}
-	return totalSpent, nil
+	strategy, _ := budget.StrategyNamed(budget.DefaultStrategy)
+	return strategy
 }
 
-func calculateDailyLeftToSpend(spent float64, monthly_budget float64, daysIntoMonth int) float64 {
-	daily_budget := monthly_budget / 30
-	allowance_up_to_now := daily_budget * float64(daysIntoMonth)
-	return allowance_up_to_now - spent
+// userBudgetStrategy returns the allowance strategy the user chose in their preferences, or the configured default
+func userBudgetStrategy(userID int) (budget.Strategy, error) {
+	preferences, err := database.GetUserPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	if preferences.AllowanceStrategy == nil {
+		return budgetStrategy(), nil
+	}
+	strategy, err := budget.StrategyNamed(*preferences.AllowanceStrategy)
+	if err != nil {
+		log.Printf("❌ Invalid allowance strategy %q for user %d, using the default", *preferences.AllowanceStrategy, userID)
+		return budgetStrategy(), nil
+	}
+	return strategy, nil
 }
 
-func (jp *JobProcessor) processDailyBalnce(job *Job) error {
+func (jp *JobProcessor) processDailyBalnce(job *jobs.Job) error {
 	log.Printf("🔄 Processing daily balance job: %s", job.ID)
 	var jobData map[string]interface{}
 	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
@@ -703,132 +1381,613 @@ func (jp *JobProcessor) processDailyBalnce(job *Job) error {
 	log.Printf("🔄 Job data: %v", jobData)
 	userID := int(jobData["user_id"].(float64))
 	monthYear := int(jobData["month_year"].(float64))
+	// Recompute requests from here on queue another job, so changes this run may have missed aren't dropped
+	if err := jobs.ReleaseDebouncedJob(ctx, jp.rdb, jobs.DailyBalanceDebounceKey(userID, monthYear), job.ID); err != nil {
+		log.Printf("❌ %v", err)
+	}
+	// Scheduled jobs carry the user's time zone, so the day counted is theirs rather than the server's
+	now := time.Now()
+	if timezone, ok := jobData["timezone"].(string); ok {
+		now = userLocalNow(now, timezone)
+	}
 
-	monthlySummary, err := database.GetMonthlySummary(userID, monthYear)
+	// Sync workflows end with this job, including for users who haven't set up this month's budget yet
+	hasSummary, err := database.HasMonthlySummary(userID, monthYear)
 	if err != nil {
-		return fmt.Errorf("failed to get monthly summary: %w", err)
+		return err
 	}
-	log.Printf("🔄 Monthly summary: %v", monthlySummary)
-	monthlyBudgetSpendCategories, _, err := database.GetMonthlyBudgetSpendCategories(monthlySummary.ID)
-	if err != nil {
-		return fmt.Errorf("failed to get monthly budget spend categories: %w", err)
+	if !hasSummary {
+		log.Printf("✅ No monthly summary for user %d in %d, skipping daily balance job: %s", userID, monthYear, job.ID)
+		return nil
 	}
-	// log.Printf("🔄 Monthly budget spend categories: %v", monthlyBudgetSpendCategories)
-	overallTotalSpent := 0.0
-	// // Build a list of all categories except "general"
-	// categoriesToExclude := []string{}
-	// for _, category := range monthlyBudgetSpendCategories {
-	// 	if category.Category != "general" {
-	// 		categoriesToExclude = append(categoriesToExclude, category.Category)
-	// 	}
-	// }
 
-	categoriesToExclude, err := database.GetCategoriesToExclude(userID, monthYear)
+	strategy, err := userBudgetStrategy(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get allowance strategy: %w", err)
+	}
+	// Categories under their allowance cover those over it, as the user's strategy decides
+	allowances, err := database.CalculateBudgetAllowances(userID, monthYear, strategy, now)
 	if err != nil {
-		return fmt.Errorf("failed to get categories to exclude: %w", err)
+		return err
 	}
+	window := allowances.Window
+	log.Printf("🔄 %s budget window %s to %s, day %d of %d (proration %.2f)", allowances.Summary.BudgetPeriod, window.Start.Format(iso8601TimeFormat), window.PeriodEnd.Format(iso8601TimeFormat), window.DaysIntoWindow, window.DaysInWindow, window.ProrationFactor)
 
-	// Calculate spend for each category and store initial daily allowances
-	type CategoryWithAllowance struct {
-		Category       database.MonthlyBudgetSpendCategory
-		DailyAllowance float64
-		IsNegative     bool
+	for i, category := range allowances.Categories {
+		if err := database.UpdateMonthlyBudgetSpendCategory(category); err != nil {
+			log.Printf("❌ Failed to update %s allowance: %v", category.Category, err)
+		}
+		log.Printf("🔄 %s total spent: %s, daily left to spend: %s, after redistribution: %s", category.Category, category.TotalSpent, allowances.Allowances[i].LeftToSpend, category.DailyAllowance)
+	}
+	if err := database.RecordBudgetAllowanceHistory(allowances.Categories, now); err != nil {
+		log.Printf("❌ %v", err)
 	}
 
-	var categoriesWithAllowances []CategoryWithAllowance
-	var totalNegativeAllowance float64
-	var totalPositiveAllowance float64
+	log.Printf("🔄 Total spent: %s", allowances.TotalSpent)
+	monthlySummary := allowances.Summary
+	monthlySummary.TotalSpent = allowances.TotalSpent
+	monthlySummary.UpdatedAt = time.Now()
+	updatedSummary, err := database.UpdateMonthlySummaryTotalSpent(monthlySummary)
+	if err != nil {
+		return fmt.Errorf("failed to update monthly summary: %w", err)
+	}
+	log.Printf("🔄 Updated monthly summary: %v", updatedSummary)
+	log.Printf("✅ Finished daily balance job: %s", job.ID)
+	return nil
+}
 
-	// First pass: calculate initial daily allowances
-	for _, category := range monthlyBudgetSpendCategories {
-		var totalSpent float64
-		if category.Category == "general" {
-			totalSpent, err = calculateSpendExcludingCategories(category, categoriesToExclude)
-			if err != nil {
-				return fmt.Errorf("failed to calculate spend by category: %w", err)
+// processRecalculateSavingGoals refreshes the pace, required contribution and projected completion date of
+// savings goals. Jobs carrying a user_id recalculate that user's goals, otherwise every active goal is refreshed.
+func (jp *JobProcessor) processRecalculateSavingGoals(job *jobs.Job) error {
+	log.Printf("🔄 Processing recalculate saving goals job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	var userIDs []int
+	if userIDFloat, ok := jobData["user_id"].(float64); ok {
+		userIDs = []int{int(userIDFloat)}
+	} else {
+		var err error
+		userIDs, err = database.GetUsersWithActiveSavingsGoals()
+		if err != nil {
+			return fmt.Errorf("failed to get users with savings goals: %w", err)
+		}
+	}
+
+	now := time.Now()
+	recalculated := 0
+	for _, userID := range userIDs {
+		savingsGoals, err := database.GetSavingsGoals(userID)
+		if err != nil {
+			return fmt.Errorf("failed to get savings goals: %w", err)
+		}
+		for _, savingsGoal := range savingsGoals {
+			if savingsGoal.Redeemed {
+				continue
 			}
-		} else {
-			totalSpent, err = calculateSpendByCategory(category)
+			// Average over the pace window, or over the goal's lifetime if it is younger than that
+			since := now.AddDate(0, -database.SavingsPaceWindowMonths, 0)
+			months := float64(database.SavingsPaceWindowMonths)
+			if savingsGoal.CreatedAt.After(since) {
+				since = savingsGoal.CreatedAt
+				months = now.Sub(since).Hours() / 24 / database.AverageDaysPerMonth
+				if months < 1 {
+					months = 1
+				}
+			}
+			contributed, err := database.GetSavingsGoalContributionTotal(savingsGoal.UserID, savingsGoal.ID, since)
 			if err != nil {
-				return fmt.Errorf("failed to calculate spend by category: %w", err)
+				return fmt.Errorf("failed to get savings goal contributions: %w", err)
+			}
+			database.ProjectSavingsGoal(&savingsGoal, contributed/months, now)
+			if err := database.UpdateSavingsGoalProjection(savingsGoal); err != nil {
+				return fmt.Errorf("failed to update savings goal projection: %w", err)
 			}
+			recalculated++
+		}
+	}
+	log.Printf("✅ Completed recalculate saving goals job: %s (%d goals)", job.ID, recalculated)
+	return nil
+}
+
+// processContributeRoundUps pays each user's accrued round-ups into their savings goals as weekly contributions
+func (jp *JobProcessor) processContributeRoundUps(job *jobs.Job) error {
+	log.Printf("🔄 Processing contribute round ups job: %s", job.ID)
+	userIDs, err := database.GetUsersWithPendingRoundUps()
+	if err != nil {
+		return fmt.Errorf("failed to get users with pending round ups: %w", err)
+	}
+	now := time.Now()
+	for _, userID := range userIDs {
+		contributions, err := database.ContributeRoundUps(userID, now)
+		if err != nil {
+			log.Printf("❌ Failed to contribute round ups for user %d: %v", userID, err)
+			continue
 		}
+		for _, contribution := range contributions {
+			log.Printf("🔄 Contributed %s in round ups to goal %d for user %d", contribution.Amount, contribution.SavingGoalID, userID)
+		}
+		recalculateJSON, _ := json.Marshal(map[string]interface{}{"user_id": userID})
+		jp.EnqueueJobContext(job.Context(), "recalculate_saving_goals", recalculateJSON)
+	}
+	log.Printf("✅ Completed contribute round ups job: %s (%d users)", job.ID, len(userIDs))
+	return nil
+}
 
-		daysIntoMonth := time.Now().Day()
-		dailyLeftToSpend := calculateDailyLeftToSpend(totalSpent, category.Budget, daysIntoMonth)
-		log.Printf("🔄 %s initial daily left to spend: %f", category.Category, dailyLeftToSpend)
-		log.Printf("🔄 %s total spent: %f", category.Category, totalSpent)
+// processComputeMonthlyBalances writes the current month's balance from the latest balances of each user's linked
+// accounts, leaving the fields users set by hand alone. Jobs carrying a user_id compute that user's balance only.
+func (jp *JobProcessor) processComputeMonthlyBalances(job *jobs.Job) error {
+	log.Printf("🔄 Processing compute monthly balances job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	var userIDs []int
+	if userIDFloat, ok := jobData["user_id"].(float64); ok {
+		userIDs = []int{int(userIDFloat)}
+	} else {
+		var err error
+		userIDs, err = database.GetUsersWithPlaidAccounts()
+		if err != nil {
+			return fmt.Errorf("failed to get users with plaid accounts: %w", err)
+		}
+	}
 
-		category.TotalSpent = totalSpent
-		overallTotalSpent += totalSpent
+	monthYear := database.ToMonthYear(time.Now())
+	for _, userID := range userIDs {
+		if _, err := database.ComputeMonthlyBalance(userID, monthYear); err != nil {
+			log.Printf("❌ Failed to compute monthly balance for user %d: %v", userID, err)
+		}
+	}
+	log.Printf("✅ Completed compute monthly balances job: %s (%d users)", job.ID, len(userIDs))
+	return nil
+}
 
-		isNegative := dailyLeftToSpend < 0
-		if isNegative {
-			totalNegativeAllowance += dailyLeftToSpend
-		} else {
-			totalPositiveAllowance += dailyLeftToSpend
+// processUpdateDebtPlans refreshes liabilities from Plaid and regenerates payoff schedules with the latest balances.
+// Jobs carrying a user_id update that user's plan, otherwise every plan is updated.
+func (jp *JobProcessor) processUpdateDebtPlans(job *jobs.Job) error {
+	log.Printf("🔄 Processing update debt plans job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	var userIDs []int
+	if userIDFloat, ok := jobData["user_id"].(float64); ok {
+		userIDs = []int{int(userIDFloat)}
+	} else {
+		var err error
+		userIDs, err = database.GetDebtPlanUserIDs()
+		if err != nil {
+			return fmt.Errorf("failed to get debt plan users: %w", err)
 		}
+	}
 
-		categoriesWithAllowances = append(categoriesWithAllowances, CategoryWithAllowance{
-			Category:       category,
-			DailyAllowance: dailyLeftToSpend,
-			IsNegative:     isNegative,
-		})
+	now := time.Now()
+	for _, userID := range userIDs {
+		plan, err := database.GetDebtPlan(userID)
+		if err != nil {
+			log.Printf("❌ Failed to get debt plan for user %d: %v", userID, err)
+			continue
+		}
+		if err := plaid.SyncLiabilities(job.Context(), userID); err != nil {
+			log.Printf("❌ Failed to sync liabilities for user %d: %v", userID, err)
+		}
+		debts, err := database.GetDebts(userID)
+		if err != nil {
+			return fmt.Errorf("failed to get debts: %w", err)
+		}
+		updated := database.BuildDebtPlan(userID, debts, plan.Strategy, plan.ExtraMonthlyPayment, now)
+		if err := database.UpsertDebtPlan(updated); err != nil {
+			return fmt.Errorf("failed to update debt plan: %w", err)
+		}
+		log.Printf("🔄 Updated %s debt plan for user %d: %d months, %.2f interest saved", updated.Strategy, userID, updated.MonthsToPayoff, updated.InterestSaved)
 	}
+	log.Printf("✅ Completed update debt plans job: %s (%d plans)", job.ID, len(userIDs))
+	return nil
+}
 
-	log.Printf("🔄 Total negative allowance: %f", totalNegativeAllowance)
-	log.Printf("🔄 Total positive allowance: %f", totalPositiveAllowance)
+// processGenerateMonthlyReport builds and stores "month in review" reports. Without a month_year it reports
+// on the previous calendar month, and without a user_id it covers every user still missing that report.
+func (jp *JobProcessor) processGenerateMonthlyReport(job *jobs.Job) error {
+	log.Printf("🔄 Processing generate monthly report job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	monthYear := database.ToMonthYear(time.Now().AddDate(0, -1, 0))
+	if monthYearFloat, ok := jobData["month_year"].(float64); ok {
+		monthYear = int(monthYearFloat)
+	}
+	var userIDs []int
+	if userIDFloat, ok := jobData["user_id"].(float64); ok {
+		userIDs = []int{int(userIDFloat)}
+	} else {
+		var err error
+		userIDs, err = database.GetUsersMissingMonthlyReport(monthYear)
+		if err != nil {
+			return fmt.Errorf("failed to get users missing monthly report: %w", err)
+		}
+	}
 
-	// Second pass: redistribute allowances
-	if totalNegativeAllowance < 0 && totalPositiveAllowance > 0 {
-		// Calculate how much we can borrow from positive categories
-		borrowableAmount := totalPositiveAllowance
-		neededAmount := -totalNegativeAllowance
+	for _, userID := range userIDs {
+		report, err := database.BuildMonthlyReport(userID, monthYear)
+		if err != nil {
+			log.Printf("❌ Failed to build monthly report for user %d: %v", userID, err)
+			continue
+		}
+		if _, err := database.UpsertMonthlyReport(userID, monthYear, *report); err != nil {
+			return fmt.Errorf("failed to save monthly report: %w", err)
+		}
+		log.Printf("🔄 Generated %d monthly report for user %d: %.2f spent", monthYear, userID, report.TotalSpent)
+		month := database.MonthYearStart(monthYear).Format("January")
+		_, err = database.CreateNotification(userID, database.NotificationTypeInsight,
+			"Your "+month+" month in review is ready",
+			fmt.Sprintf("You spent $%.2f across %d purchases in %s.", report.TotalSpent, report.TransactionCount, month),
+			map[string]interface{}{"monthyear": monthYear},
+			fmt.Sprintf("monthly_report:%d", monthYear))
+		if err != nil {
+			log.Printf("❌ Failed to notify user %d of monthly report: %v", userID, err)
+		}
+	}
+	log.Printf("✅ Completed generate monthly report job: %s (%d reports)", job.ID, len(userIDs))
+	return nil
+}
 
-		// If we have enough positive allowance to cover all negative, redistribute
-		if borrowableAmount >= neededAmount {
-			// Calculate redistribution ratio
-			redistributionRatio := neededAmount / borrowableAmount
+// processSendEmailDigests sends a spending digest to every user whose weekly or monthly digest is due, by email
+// and to their notification channels subscribed to it
+func (jp *JobProcessor) processSendEmailDigests(job *jobs.Job) error {
+	log.Printf("🔄 Processing send email digests job: %s", job.ID)
+	now := time.Now()
+	recipients, err := database.GetUsersDueForDigest(now)
+	if err != nil {
+		return fmt.Errorf("failed to get digest recipients: %w", err)
+	}
 
-			for i := range categoriesWithAllowances {
-				if categoriesWithAllowances[i].IsNegative {
-					// Set negative categories to 0
-					categoriesWithAllowances[i].DailyAllowance = 0
-				} else {
-					// Reduce positive categories proportionally
-					categoriesWithAllowances[i].DailyAllowance = categoriesWithAllowances[i].DailyAllowance * (1 - redistributionRatio)
-				}
+	emailConfig := email.LoadConfig()
+	if emailConfig.Host == "" {
+		// Channels still get their digests; email is skipped quietly rather than dead lettering the job every hour
+		log.Printf("🔄 Skipping digest emails: %v", email.ErrNotConfigured)
+	}
+	publicURL := os.Getenv("API_PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+	sent := 0
+	for _, recipient := range recipients {
+		sendEmail := recipient.DigestEmail && emailConfig.Host != ""
+		if !sendEmail && !recipient.DigestChannels {
+			continue
+		}
+		digest, err := database.BuildDigest(recipient.UserID, recipient.DigestFrequency, now)
+		if err != nil {
+			log.Printf("❌ Failed to build digest for user %d: %v", recipient.UserID, err)
+			continue
+		}
+		delivered := false
+		if recipient.DigestChannels {
+			queued, err := jp.queueDigestForChannels(recipient.UserID, digest)
+			if err != nil {
+				log.Printf("❌ Failed to queue digest for user %d's channels: %v", recipient.UserID, err)
 			}
-		} else {
-			// Not enough positive allowance to cover all negative; keep original values (no redistribution)
-			// This preserves negative daily allowances to reflect true deficit
+			delivered = queued > 0
+		}
+		if sendEmail {
+			unsubscribeURL := publicURL + "/unsubscribe?token=" + url.QueryEscape(recipient.UnsubscribeToken)
+			body, err := email.Render("digest.html", map[string]interface{}{
+				"Digest":         digest,
+				"UnsubscribeURL": unsubscribeURL,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to render digest: %w", err)
+			}
+			subject := fmt.Sprintf("Your %s spending digest", recipient.DigestFrequency)
+			if err := email.Send(emailConfig, recipient.Email, subject, body, unsubscribeURL); err != nil {
+				log.Printf("❌ Failed to send digest to user %d: %v", recipient.UserID, err)
+			} else {
+				delivered = true
+			}
+		}
+		if !delivered {
+			continue
+		}
+		if err := database.MarkDigestSent(recipient.UserID, now); err != nil {
+			return fmt.Errorf("failed to mark digest sent: %w", err)
 		}
+		sent++
 	}
+	log.Printf("✅ Completed send email digests job: %s (%d of %d sent)", job.ID, sent, len(recipients))
+	return nil
+}
 
-	// Update database with final allowances
-	for _, catWithAllowance := range categoriesWithAllowances {
-		catWithAllowance.Category.DailyAllowance = catWithAllowance.DailyAllowance
-		database.UpdateMonthlyBudgetSpendCategory(catWithAllowance.Category)
-		log.Printf("🔄 %s final daily left to spend: %f", catWithAllowance.Category.Category, catWithAllowance.DailyAllowance)
+// processSeedDemoData fills a user's account with generated demo data. Only enqueued by the API in sandbox mode.
+func (jp *JobProcessor) processSeedDemoData(job *jobs.Job) error {
+	log.Printf("🔄 Processing seed demo data job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	userIDFloat, ok := jobData["user_id"].(float64)
+	if !ok {
+		return fmt.Errorf("user_id not found in job data")
+	}
+	months := 3
+	if monthsFloat, ok := jobData["months"].(float64); ok {
+		months = int(monthsFloat)
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	result, err := database.SeedDemoData(int(userIDFloat), months, time.Now(), rng)
+	if err != nil {
+		return fmt.Errorf("failed to seed demo data: %w", err)
 	}
+	log.Printf("✅ Completed seed demo data job: %s (%d transactions over %d months)", job.ID, result.Transactions, result.Months)
+	return nil
+}
 
-	log.Printf("🔄 Total spent: %f", overallTotalSpent)
-	monthlySummary.TotalSpent = overallTotalSpent
-	monthlySummary.UpdatedAt = time.Now()
-	monthlySummary, err = database.UpdateMonthlySummaryTotalSpent(*monthlySummary)
+// defaultArchiveAfterMonths is how old a transaction gets before it is archived, overridable with
+// TRANSACTION_ARCHIVE_AFTER_MONTHS. It must stay beyond maxBackfillMonths or a backfill would re-insert
+// archived transactions into the live table.
+const defaultArchiveAfterMonths = 36
+
+// processArchiveOldTransactions moves transactions older than the archive cutoff out of the live table
+func (jp *JobProcessor) processArchiveOldTransactions(job *jobs.Job) error {
+	log.Printf("🔄 Processing archive old transactions job: %s", job.ID)
+	archiveAfterMonths := defaultArchiveAfterMonths
+	if val := os.Getenv("TRANSACTION_ARCHIVE_AFTER_MONTHS"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed <= maxBackfillMonths {
+			log.Printf("❌ Invalid TRANSACTION_ARCHIVE_AFTER_MONTHS %q, must be more than %d, using %d", val, maxBackfillMonths, defaultArchiveAfterMonths)
+		} else {
+			archiveAfterMonths = parsed
+		}
+	}
+	now := time.Now().UTC()
+	cutoff := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -archiveAfterMonths, 0)
+	archived, err := database.ArchiveTransactionsBefore(job.Context(), cutoff)
 	if err != nil {
-		return fmt.Errorf("failed to update monthly summary: %w", err)
+		return fmt.Errorf("failed to archive transactions: %w", err)
 	}
-	log.Printf("🔄 Updated monthly summary: %v", monthlySummary)
-	log.Printf("✅ Finished daily balance job: %s", job.ID)
+	pruned, err := database.PruneJobRuns(time.Now().Add(-jobRunRetention))
+	if err != nil {
+		return err
+	}
+	prunedFiles, err := database.PruneDataImportFiles(time.Now().Add(-dataImportFileRetention))
+	if err != nil {
+		return err
+	}
+	log.Printf("✅ Completed archive old transactions job: %s (%d transactions before %s, %d job runs and %d import files pruned)", job.ID, archived, cutoff.Format(iso8601TimeFormat), pruned, prunedFiles)
 	return nil
 }
 
+// syncJobTypes are the jobs whose failure means a user's bank data may be out of date
+var syncJobTypes = map[string]bool{
+	"initial_plaid_sync":       true,
+	"fetch_plaid_transactions": true,
+	"sync_plaid_accounts":      true,
+	"backfill_plaid_history":   true,
+}
+
+// notifySyncFailure tells the user when one of their sync jobs fails, at most once per job type per day
+func (jp *JobProcessor) notifySyncFailure(job *jobs.Job, jobErr error) {
+	if !syncJobTypes[job.Type] {
+		return
+	}
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return
+	}
+	userIDFloat, ok := jobData["user_id"].(float64)
+	if !ok {
+		return
+	}
+	userID := int(userIDFloat)
+	data := map[string]interface{}{"job_type": job.Type}
+	if accountID, ok := jobData["account_id"].(string); ok {
+		data["account_id"] = accountID
+	}
+	dedupeKey := fmt.Sprintf("sync_failure:%s:%s", job.Type, time.Now().Format("2006-01-02"))
+	_, err := database.CreateNotification(userID, database.NotificationTypeSyncFailure,
+		"We couldn't sync your accounts",
+		"Some of your recent transactions may be missing. We'll keep trying, or you can reconnect your bank.",
+		data, dedupeKey)
+	if err != nil {
+		log.Printf("❌ Failed to notify user %d of sync failure: %v (job error: %v)", userID, err, jobErr)
+	}
+}
+
+// transferMatchLookback is how far back a sync looks for transfers between the user's own accounts
+const transferMatchLookback = 60 * 24 * time.Hour
+
+// matchTransfers marks transfers between the user's own accounts so they stop counting as spend. It runs before
+// budget alerts so a card payment doesn't set one off. Failures are only logged; the next sync matches again.
+func (jp *JobProcessor) matchTransfers(ctx context.Context, userID int) {
+	result, err := database.MatchTransfers(ctx, userID, time.Now().Add(-transferMatchLookback))
+	if err != nil {
+		log.Printf("❌ Failed to match transfers for user %d: %v", userID, err)
+		return
+	}
+	if result.Confirmed > 0 || result.PendingReview > 0 {
+		log.Printf("✅ Matched transfers for user %d: %d confirmed, %d pending review", userID, result.Confirmed, result.PendingReview)
+	}
+}
+
+// detectInvestmentContributions counts transfers into brokerage accounts towards the month's invested amount. It
+// runs after matchTransfers, since contributions are found among the confirmed transfers. Failures are only logged.
+func (jp *JobProcessor) detectInvestmentContributions(ctx context.Context, userID int) {
+	detected, err := database.DetectInvestmentContributions(ctx, userID, time.Now().Add(-transferMatchLookback))
+	if err != nil {
+		log.Printf("❌ Failed to detect investment contributions for user %d: %v", userID, err)
+		return
+	}
+	if detected > 0 {
+		log.Printf("✅ Detected %d investment contributions for user %d", detected, userID)
+	}
+}
+
+// matchPlannedExpenses marks planned expenses whose transaction has appeared as occurred, so the budget stops
+// setting money aside for them, and lets the user know. It runs after matchTransfers so a transfer isn't taken for
+// the expense. Failures are only logged; the next sync matches again.
+func (jp *JobProcessor) matchPlannedExpenses(ctx context.Context, userID int) {
+	matched, err := database.MatchPlannedExpenses(ctx, userID)
+	if err != nil {
+		log.Printf("❌ Failed to match planned expenses for user %d: %v", userID, err)
+		return
+	}
+	for _, expense := range matched {
+		_, err := database.CreateNotification(userID, database.NotificationTypeInsight,
+			"Planned expense paid",
+			fmt.Sprintf("%s for $%.2f has gone through, so your budget no longer sets money aside for it.", expense.Description, expense.Amount.Float64()),
+			map[string]interface{}{"planned_expense_id": expense.ID, "transaction_id": expense.TransactionID},
+			fmt.Sprintf("planned_expense:%d", expense.ID))
+		if err != nil {
+			log.Printf("❌ Failed to notify user %d of planned expense %d: %v", userID, expense.ID, err)
+		}
+	}
+	if len(matched) > 0 {
+		log.Printf("✅ Matched %d planned expenses for user %d", len(matched), userID)
+	}
+}
+
+// budgetWarningThreshold is the share of a category's budget spent before the user is warned
+const budgetWarningThreshold = 0.8
+
+// createBudgetAlerts warns the user once per category and month when spending nears and then passes its budget.
+// Syncs call it with the transactions they just wrote, so only the categories those land in this month are
+// rechecked and an overspend is flagged as soon as the transaction that caused it arrives.
+func (jp *JobProcessor) createBudgetAlerts(userID int, transactionIDs []string) {
+	if len(transactionIDs) == 0 {
+		return
+	}
+	monthYear := database.ToMonthYear(time.Now())
+	spends, err := database.GetCategorySpendForTransactions(userID, monthYear, transactionIDs)
+	if err != nil {
+		log.Printf("❌ Failed to get category spend for budget alerts for user %d: %v", userID, err)
+		return
+	}
+	for _, spend := range spends {
+		if spend.Budget <= 0 || spend.TotalSpent < spend.Budget*budgetWarningThreshold {
+			continue
+		}
+		level := "near"
+		title := fmt.Sprintf("You've used %.0f%% of your %s budget", spend.TotalSpent/spend.Budget*100, spend.Category)
+		if spend.TotalSpent >= spend.Budget {
+			level = "over"
+			title = fmt.Sprintf("You're over your %s budget", spend.Category)
+		}
+		body := fmt.Sprintf("$%.2f spent of $%.2f this month.", spend.TotalSpent, spend.Budget)
+		data := map[string]interface{}{
+			"category":    spend.Category,
+			"budget":      spend.Budget,
+			"total_spent": spend.TotalSpent,
+			"monthyear":   monthYear,
+		}
+		dedupeKey := fmt.Sprintf("budget_%s:%s:%d", level, spend.Category, monthYear)
+		created, err := database.CreateNotification(userID, database.NotificationTypeBudgetWarning, title, body, data, dedupeKey)
+		if err != nil {
+			log.Printf("❌ Failed to create budget alert for user %d: %v", userID, err)
+			continue
+		}
+		// The notification's dedupe key makes this fire once per category and month too
+		if created && level == "over" {
+			jp.emitWebhookEvent(ctx, userID, database.WebhookEventBudgetExceeded, dedupeKey, data)
+		}
+	}
+}
+
+// scheduledJob is a job the scheduler enqueues on a fixed interval
+type scheduledJob struct {
+	Type     string
+	Interval time.Duration
+	Data     json.RawMessage
+}
+
+var scheduledJobs = []scheduledJob{
+	{Type: "recalculate_saving_goals", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	{Type: "contribute_round_ups", Interval: 7 * 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	{Type: "update_debt_plans", Interval: 30 * 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	{Type: "compute_monthly_balances", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	// Runs daily but only reports on users missing last month's report, so each month is generated once
+	{Type: "generate_monthly_report", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	// Checked hourly; each user's digest goes out once their weekly or monthly period has elapsed
+	{Type: "send_email_digests", Interval: time.Hour, Data: json.RawMessage(`{}`)},
+	{Type: "archive_old_transactions", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	// Events enqueue their own delivery; this sends retries once they're due
+	{Type: "deliver_webhooks", Interval: time.Minute, Data: json.RawMessage(`{}`)},
+	// Notifications queue their channel messages as they are created; this sends them and their retries
+	{Type: "deliver_channel_messages", Interval: time.Minute, Data: json.RawMessage(`{}`)},
+	{Type: "remind_teller_reauth", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	{Type: "flag_stale_accounts", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	// Only fetches institutions whose cached details are over a week old, so each is refreshed weekly
+	{Type: "refresh_institutions", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	// Runs daily but only exports to connections missing last month, so each month is appended once
+	{Type: "export_google_sheets", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	{Type: "purge_deactivated_users", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	{Type: "check_emergency_funds", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+	// Each time zone's daily balances are enqueued once a day, shortly after midnight there
+	{Type: "schedule_daily_balances", Interval: dailyBalanceScheduleInterval, Data: json.RawMessage(`{}`)},
+	// Looks over the transactions imported since the last run, see anomalyScanWindow
+	{Type: "detect_transaction_anomalies", Interval: time.Hour, Data: json.RawMessage(`{}`)},
+	// Looks over the charges imported since the last run, see priceIncreaseScanWindow
+	{Type: "detect_price_increases", Interval: 24 * time.Hour, Data: json.RawMessage(`{}`)},
+}
+
+// Each scheduled job runs once per period of its interval, counted from the zero time in UTC so every replica
+// agrees on where periods start: daily jobs at midnight UTC, weekly ones on Monday. The scheduler checks every
+// schedulerTick and enqueues a job only when it claims the job's current period in Redis, so it runs once a period
+// however many replicas run the scheduler and however often they restart.
+const schedulerTick = time.Minute
+
+func scheduledPeriodKey(jobType string, periodStart time.Time) string {
+	return redisconn.Key(fmt.Sprintf("scheduled_period:%s:%d", jobType, periodStart.Unix()))
+}
+
+// claimScheduledPeriod reports whether this replica is the first to reach the period now falls in for a scheduled
+// job. The claim outlives the period so a late tick can't claim it again.
+func (jp *JobProcessor) claimScheduledPeriod(ctx context.Context, scheduled scheduledJob, now time.Time) (bool, error) {
+	periodStart := now.UTC().Truncate(scheduled.Interval)
+	return jp.rdb.SetNX(ctx, scheduledPeriodKey(scheduled.Type, periodStart), now.UTC().Format(time.RFC3339), 2*scheduled.Interval).Result()
+}
+
+// enqueueDueScheduledJobs enqueues every scheduled job whose current period hasn't been claimed yet
+func (jp *JobProcessor) enqueueDueScheduledJobs(ctx context.Context, now time.Time) {
+	for _, scheduled := range scheduledJobs {
+		claimed, err := jp.claimScheduledPeriod(ctx, scheduled, now)
+		if err != nil {
+			log.Printf("❌ Scheduler: failed to claim %s: %v", scheduled.Type, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := jp.EnqueueJob(scheduled.Type, scheduled.Data); err != nil {
+			log.Printf("❌ Scheduler: failed to enqueue %s: %v", scheduled.Type, err)
+		}
+	}
+}
+
+// StartScheduler enqueues each scheduled job once per period of its interval, see schedulerTick
+func (jp *JobProcessor) StartScheduler() {
+	log.Printf("🚀 Starting scheduler with %d jobs...", len(scheduledJobs))
+	go func() {
+		ticker := time.NewTicker(schedulerTick)
+		defer ticker.Stop()
+		for {
+			jp.enqueueDueScheduledJobs(ctx, time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
 // StartWorker starts a single background worker
 func (jp *JobProcessor) StartWorker(workerID int) {
 	log.Printf("🚀 Starting worker %d...", workerID)
 
 	for {
+		if state := jp.controls(); state.Paused || workerID > state.Concurrency {
+			time.Sleep(controlRefreshInterval)
+			continue
+		}
+
 		job, err := jp.DequeueJob()
 		if err != nil {
 			log.Printf("❌ Worker %d: Error dequeuing job: %v", workerID, err)
@@ -840,18 +1999,104 @@ func (jp *JobProcessor) StartWorker(workerID int) {
 			continue
 		}
 
-		// Process the job
-		log.Printf("🔄 Worker %d: Processing job: %s (Type: %s)", workerID, job.ID, job.Type)
-		err = jp.ProcessJob(job)
-		if err != nil {
-			log.Printf("❌ Worker %d: Error processing job %s: %v", workerID, job.ID, err)
+		if jp.controls().jobTypePaused(job.Type) {
+			if err := jp.holdJob(job); err == nil {
+				continue
+			}
+			log.Printf("❌ Worker %d: Failed to hold paused job %s, processing it: %v", workerID, job.ID, err)
+		}
+
+		jp.handleJob(workerID, job)
+	}
+}
+
+// handleJob processes a dequeued job. A failed job is retried, or once it is out of attempts dead lettered, and
+// the workflow it is a step of moves on when it succeeds or finally fails.
+func (jp *JobProcessor) handleJob(workerID int, job *jobs.Job) {
+	log.Printf("🔄 Worker %d: Processing job: %s (Type: %s)", workerID, job.ID, job.Type)
+	jp.trackJobStarted(workerID, job)
+	err := jp.ProcessJob(job)
+	jp.trackJobFinished(workerID, job, err)
+	if err != nil {
+		log.Printf("❌ Worker %d: Error processing job %s: %v", workerID, job.ID, err)
+		timedOut := errors.Is(err, errJobTimedOut)
+		if !errors.Is(err, errJobPanicked) {
+			tags, extra := jobReportContext(job)
+			tags["job.timed_out"] = strconv.FormatBool(timedOut)
+			errorreport.CaptureError(err, tags, extra)
+		}
+		if jp.retryJob(job, err) {
+			// The workflow step finishes when its last attempt does
+			return
+		}
+		if dlErr := jp.deadLetterJob(job, err, timedOut); dlErr != nil {
+			log.Printf("❌ Worker %d: Failed to dead letter job %s: %v", workerID, job.ID, dlErr)
 		}
+		jp.notifySyncFailure(job, err)
 	}
+	jp.finishWorkflowStep(job, err)
 }
 
-// StartWorkers starts multiple background workers
+// deadLetterJob records a job that finally failed. Its secrets are kept apart from its data, which the dead letter
+// endpoints return, and only read back to replay it.
+func (jp *JobProcessor) deadLetterJob(job *jobs.Job, jobErr error, timedOut bool) error {
+	var data, secrets json.RawMessage
+	if len(bytes.TrimSpace(job.Data)) > 0 {
+		var err error
+		if data, secrets, err = jobs.SplitSecrets(job.Data); err != nil {
+			// Data that can't be checked for secrets isn't kept
+			log.Printf("❌ Dead lettering job %s without its data: %v", job.ID, err)
+			data, secrets = nil, nil
+		}
+	}
+	return database.CreateDeadLetterJob(job.ID, job.Type, data, secrets, jobErr.Error(), timedOut)
+}
+
+// retryPromoteInterval is how often retries that have come due are moved back onto the queue
+const retryPromoteInterval = 5 * time.Second
+
+// retryJob schedules another attempt at a failed sync job, reporting whether it did. Sync jobs mostly fail on
+// provider outages that clear up by themselves; other jobs, panics and jobs out of attempts are dead lettered.
+func (jp *JobProcessor) retryJob(job *jobs.Job, jobErr error) bool {
+	if _, isSync := jobRunProviders[job.Type]; !isSync || errors.Is(jobErr, errJobPanicked) {
+		return false
+	}
+	retry := *job
+	if err := jp.encodeJobPayload(ctx, &retry); err != nil {
+		log.Printf("❌ Failed to encode job %s for retry: %v", job.ID, err)
+		return false
+	}
+	retried, err := jobs.Retry(ctx, jp.rdb, &retry, time.Now())
+	if err != nil {
+		log.Printf("❌ Failed to retry job %s: %v", job.ID, err)
+		return false
+	}
+	if retried {
+		log.Printf("🔁 Retrying job %s (Type: %s) in %s, attempt %d of %d", job.ID, job.Type, jobs.RetryDelay(retry.Attempts), retry.Attempts+1, jobs.MaxAttempts)
+	}
+	return retried
+}
+
+// StartRetryPromoter moves retries onto the queue as they come due
+func (jp *JobProcessor) StartRetryPromoter() {
+	go func() {
+		ticker := time.NewTicker(retryPromoteInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := jobs.PromoteDueRetries(ctx, jp.rdb, time.Now()); err != nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+	}()
+}
+
+// StartWorkers starts multiple background workers, which follow the pause and concurrency controls
 func (jp *JobProcessor) StartWorkers(numWorkers int) {
 	log.Printf("🚀 Starting %d background workers...", numWorkers)
+	jp.maxWorkers = numWorkers
+	jp.StartControlRefresher()
+	jp.StartHeartbeat()
+	jp.StartRetryPromoter()
 
 	for i := 1; i <= numWorkers; i++ {
 		go jp.StartWorker(i)
@@ -882,8 +2127,14 @@ func (jp *JobProcessor) handleEnqueueJob(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var req EnqueueRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var req jobs.EnqueueRequest
+	body := http.MaxBytesReader(w, r.Body, jp.payloadLimits.MaxBytes)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("Job payload is larger than the %d byte limit; upload it to the blob store and enqueue its key as data_ref", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
@@ -892,30 +2143,30 @@ func (jp *JobProcessor) handleEnqueueJob(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Job type is required", http.StatusBadRequest)
 		return
 	}
-
-	// Create job
-	job := Job{
-		ID:        fmt.Sprintf("job_%d", time.Now().UnixNano()),
-		Type:      req.Type,
-		Data:      req.Data,
-		CreatedAt: time.Now(),
+	if req.DataRef != "" && (len(req.Data) > 0 || jp.blobs == nil) {
+		http.Error(w, "data_ref needs a configured blob store and can't be combined with data", http.StatusBadRequest)
+		return
 	}
 
-	jobJSON, err := json.Marshal(job)
-	if err != nil {
-		http.Error(w, "Failed to marshal job", http.StatusInternalServerError)
+	// Create job
+	job := jobs.New(r.Context(), req.Type, req.Data)
+	job.DataRef = req.DataRef
+	queued := job
+	if err := jp.encodeJobPayload(r.Context(), &job); err != nil {
+		log.Printf("❌ Failed to encode job payload: %v", err)
+		http.Error(w, "Failed to store job payload", http.StatusInternalServerError)
 		return
 	}
 
-	// Enqueue job
-	err = jp.rdb.LPush(ctx, "job_queue", jobJSON).Err()
-	if err != nil {
+	if err := jobs.Enqueue(ctx, jp.rdb, &job); err != nil {
+		log.Printf("❌ %v", err)
 		http.Error(w, "Failed to enqueue job", http.StatusInternalServerError)
 		return
 	}
+	jp.recordJobRun(&queued, database.JobRunQueued, nil)
 
 	// Return response
-	response := EnqueueResponse{
+	response := jobs.EnqueueResponse{
 		Success: true,
 		JobID:   job.ID,
 		Message: fmt.Sprintf("Job enqueued successfully: %s", job.ID),
@@ -935,13 +2186,27 @@ func (jp *JobProcessor) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // StartHTTPServer starts the HTTP server
 func (jp *JobProcessor) StartHTTPServer(port string) {
-	http.HandleFunc("/enqueue", jp.handleEnqueueJob)
+	http.Handle("/enqueue", telemetry.WrapHandler(http.HandlerFunc(jp.handleEnqueueJob), "POST /enqueue"))
 	http.HandleFunc("/health", jp.handleHealth)
+	http.HandleFunc("/worker/pause", requireOperatorToken(jp.handlePause))
+	http.HandleFunc("/worker/resume", requireOperatorToken(jp.handleResume))
+	http.HandleFunc("/worker/concurrency", requireOperatorToken(jp.handleConcurrency))
+	http.HandleFunc("/worker/status", jp.handleWorkerStatus)
+	http.HandleFunc("GET /queues", jp.handleQueues)
+	http.HandleFunc("GET /queues/{name}/jobs", jp.handleQueueJobs)
+	http.HandleFunc("GET /workers", jp.handleWorkers)
 
 	log.Printf("🌐 Starting HTTP server on port %s", port)
 	log.Printf("📋 Available endpoints:")
-	log.Printf("   POST /enqueue - Enqueue a new job")
-	log.Printf("   GET  /health  - Health check")
+	log.Printf("   POST /enqueue            - Enqueue a new job")
+	log.Printf("   GET  /health             - Health check")
+	log.Printf("   POST /worker/pause       - Pause the worker or job types (X-Admin-Token)")
+	log.Printf("   POST /worker/resume      - Resume the worker or job types (X-Admin-Token)")
+	log.Printf("   POST /worker/concurrency - Set how many workers run (X-Admin-Token)")
+	log.Printf("   GET  /worker/status      - Pause and concurrency settings")
+	log.Printf("   GET  /queues             - Queue depths and recent throughput")
+	log.Printf("   GET  /queues/:name/jobs  - Jobs waiting on a queue")
+	log.Printf("   GET  /workers            - In-flight jobs per worker")
 
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Failed to start HTTP server:", err)
@@ -949,11 +2214,23 @@ func (jp *JobProcessor) StartHTTPServer(port string) {
 }
 
 func main() {
+	shutdownTracing := telemetry.Init("watson-worker")
+	defer shutdownTracing(context.Background())
+	errorreport.Init("watson-worker")
+	defer errorreport.Flush(2 * time.Second)
+
 	plaid.InitPlaid()
-	// Get Redis address from environment variable
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379" // fallback
+	googlesheets.InitGoogleSheets()
+	// Connect to Redis as configured by REDIS_URL or REDIS_ADDR and friends
+	rdb, err := redisconn.NewClient("localhost:6379")
+	if err != nil {
+		log.Fatal("Failed to configure Redis:", err)
+	}
+
+	// Large job payloads are offloaded to object storage when a bucket is configured
+	blobs, err := blobstore.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure blob store:", err)
 	}
 
 	// Get worker port from environment variable
@@ -978,10 +2255,10 @@ func main() {
 	log.Println("✅ Connected to database successfully!")
 
 	// Create job processor
-	processor := NewJobProcessor(redisAddr)
+	processor := NewJobProcessor(rdb, blobs)
 
 	// Test Redis connection
-	_, err := processor.rdb.Ping(ctx).Result()
+	_, err = processor.rdb.Ping(ctx).Result()
 	if err != nil {
 		log.Fatal("Failed to connect to Redis:", err)
 	}
@@ -990,8 +2267,11 @@ func main() {
 	// Enqueue some sample jobs
 	processor.EnqueueSampleJobs()
 
-	// Start 3 background workers
-	processor.StartWorkers(10)
+	// Start background workers
+	processor.StartWorkers(workerConcurrency())
+
+	// Enqueue periodic jobs
+	processor.StartScheduler()
 
 	// Start the HTTP server
 	processor.StartHTTPServer(workerPort)
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"watson/database"
+	"watson/jobs"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Notifications are queued as one message per subscribed Slack or Discord channel when they are created, and sent
+// by the deliver_channel_messages job, which the scheduler runs every minute. Messages are retried with the same
+// backoff and attempt limit as webhook deliveries, over the same client that refuses private addresses.
+const (
+	channelMessageBatchSize   = 100
+	channelMessageParallelism = 8
+	// maxDiscordMessageLength is Discord's limit on a message's content
+	maxDiscordMessageLength = 2000
+)
+
+// errChannelGone marks messages to a webhook Slack or Discord says no longer exists, which is not retried
+var errChannelGone = errors.New("channel webhook no longer exists")
+
+// processDeliverChannelMessages sends every channel message that is due
+func (jp *JobProcessor) processDeliverChannelMessages(job *jobs.Job) error {
+	lease := webhookRequestTimeout * time.Duration(channelMessageBatchSize/channelMessageParallelism+1)
+	sent, failed := 0, 0
+	for {
+		messages, err := database.ClaimDueChannelMessages(job.Context(), channelMessageBatchSize, lease)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		results := make([]bool, len(messages))
+		var group errgroup.Group
+		group.SetLimit(channelMessageParallelism)
+		for i := range messages {
+			group.Go(func() error {
+				results[i] = jp.deliverChannelMessage(job.Context(), &messages[i])
+				return nil
+			})
+		}
+		group.Wait()
+		for _, ok := range results {
+			if ok {
+				sent++
+			} else {
+				failed++
+			}
+		}
+		if len(messages) < channelMessageBatchSize {
+			break
+		}
+	}
+	log.Printf("✅ Completed deliver channel messages job: %s (%d sent, %d failed)", job.ID, sent, failed)
+	return nil
+}
+
+// deliverChannelMessage sends one message and records the outcome, reporting whether the channel accepted it.
+// A channel whose webhook was removed is deactivated so nothing more is queued for it.
+func (jp *JobProcessor) deliverChannelMessage(reqCtx context.Context, message *database.DueChannelMessage) bool {
+	err := jp.sendChannelMessage(reqCtx, message)
+	status, lastError, nextAttemptAt := database.WebhookDeliverySucceeded, "", time.Now()
+	if err != nil {
+		lastError = err.Error()
+		if message.Attempts >= maxWebhookAttempts || errors.Is(err, errWebhookAddressBlocked) || errors.Is(err, errChannelGone) {
+			status = database.WebhookDeliveryFailed
+		} else {
+			status = database.WebhookDeliveryPending
+			nextAttemptAt = time.Now().Add(webhookRetryDelay(message.Attempts))
+		}
+		log.Printf("❌ %s message %d for user %d failed (attempt %d): %v", message.Kind, message.ID, message.UserID, message.Attempts, err)
+		if errors.Is(err, errChannelGone) {
+			if deactivateErr := database.DeactivateNotificationChannel(reqCtx, message.ChannelID); deactivateErr != nil {
+				log.Printf("❌ %v", deactivateErr)
+			}
+		}
+	}
+	if recordErr := database.RecordChannelMessageAttempt(reqCtx, message.ID, status, lastError, nextAttemptAt); recordErr != nil {
+		log.Printf("❌ %v", recordErr)
+	}
+	return err == nil
+}
+
+// sendChannelMessage posts a message in the format of the channel's service
+func (jp *JobProcessor) sendChannelMessage(reqCtx context.Context, message *database.DueChannelMessage) error {
+	payload, err := json.Marshal(channelMessagePayload(message.Kind, message.Title, message.Body))
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, message.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Watson-Notifications/1.0")
+
+	resp, err := jp.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookErrorBody))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("%w: status %d: %s", errChannelGone, resp.StatusCode, body)
+	}
+	return fmt.Errorf("channel responded with status %d: %s", resp.StatusCode, body)
+}
+
+// channelMessagePayload formats a notification as a Slack or Discord incoming webhook message
+func channelMessagePayload(kind string, title string, body string) map[string]interface{} {
+	if kind == database.NotificationChannelDiscord {
+		content := []rune("**" + title + "**\n" + body)
+		if len(content) > maxDiscordMessageLength {
+			content = append(content[:maxDiscordMessageLength-1], '…')
+		}
+		// Notification text comes from transaction descriptions, so mentions in it must not ping anyone
+		return map[string]interface{}{
+			"content":          string(content),
+			"allowed_mentions": map[string]interface{}{"parse": []string{}},
+		}
+	}
+	return map[string]interface{}{
+		"text": "*" + slackEscape(title) + "*\n" + slackEscape(body),
+	}
+}
+
+// slackEscape escapes the characters Slack treats as markup for links and mentions
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// queueDigestForChannels sends a digest to the user's channels subscribed to it, reporting how many were queued
+func (jp *JobProcessor) queueDigestForChannels(userID int, digest *database.Digest) (int64, error) {
+	title := fmt.Sprintf("Your %s spending digest", digest.Frequency)
+	lines := []string{
+		fmt.Sprintf("You spent $%.2f from %s to %s.", digest.TotalSpent, digest.PeriodStart.Format("Jan 2"), digest.PeriodEnd.Format("Jan 2")),
+	}
+	if digest.MonthBudget > 0 {
+		lines = append(lines, fmt.Sprintf("This month: $%.2f of $%.2f budgeted.", digest.MonthSpent, digest.MonthBudget))
+	}
+	for _, category := range digest.BudgetCategories {
+		lines = append(lines, fmt.Sprintf("• %s: $%.2f of $%.2f", category.Category, category.TotalSpent, category.Budget))
+	}
+	if len(digest.UpcomingBills) > 0 {
+		lines = append(lines, "Upcoming bills:")
+		for _, bill := range digest.UpcomingBills {
+			lines = append(lines, fmt.Sprintf("• %s: $%.2f on %s", bill.Description, bill.Amount, bill.ExpectedDate))
+		}
+	}
+	queued, err := database.QueueChannelMessages(userID, database.NotificationTypeDigest, title, strings.Join(lines, "\n"))
+	if err != nil {
+		return 0, err
+	}
+	if queued > 0 {
+		if err := jp.EnqueueJob("deliver_channel_messages", json.RawMessage(`{}`)); err != nil {
+			log.Printf("❌ Failed to enqueue channel message delivery, the scheduler will pick it up: %v", err)
+		}
+	}
+	return queued, nil
+}
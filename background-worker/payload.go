@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"watson/jobs"
+)
+
+// Job data larger than the compression threshold is gzipped before it is queued, and if it is still larger than
+// the offload threshold and a blob store is configured, it is stored there and the job carries a reference.
+// Either way handlers only ever see the original data.
+const (
+	payloadEncodingGzip  = "gzip"
+	jobPayloadBlobPrefix = "job-payloads/"
+)
+
+// PayloadLimits bound the size of job data
+type PayloadLimits struct {
+	// MaxBytes is the largest request /enqueue accepts, JOB_MAX_PAYLOAD_BYTES
+	MaxBytes int64
+	// CompressAbove is the data size from which it is gzipped, JOB_COMPRESS_THRESHOLD_BYTES
+	CompressAbove int
+	// OffloadAbove is the compressed size from which data goes to the blob store, JOB_OFFLOAD_THRESHOLD_BYTES
+	OffloadAbove int
+	// MaxDecodedBytes caps data read back from gzip or the blob store, guarding against decompression bombs
+	MaxDecodedBytes int64
+}
+
+// loadPayloadLimits reads the payload limits from the environment
+func loadPayloadLimits() PayloadLimits {
+	limits := PayloadLimits{
+		MaxBytes:        5 << 20,
+		CompressAbove:   8 << 10,
+		OffloadAbove:    256 << 10,
+		MaxDecodedBytes: 64 << 20,
+	}
+	limits.MaxBytes = int64(payloadLimitFromEnv("JOB_MAX_PAYLOAD_BYTES", int(limits.MaxBytes)))
+	limits.CompressAbove = payloadLimitFromEnv("JOB_COMPRESS_THRESHOLD_BYTES", limits.CompressAbove)
+	limits.OffloadAbove = payloadLimitFromEnv("JOB_OFFLOAD_THRESHOLD_BYTES", limits.OffloadAbove)
+	return limits
+}
+
+func payloadLimitFromEnv(key string, fallback int) int {
+	if val := os.Getenv(key); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 {
+			log.Printf("❌ Invalid %s %q, using %d", key, val, fallback)
+		} else {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// encodeJobPayload compresses a job's data and offloads it to the blob store when it is large enough
+func (jp *JobProcessor) encodeJobPayload(reqCtx context.Context, job *jobs.Job) error {
+	if job.Encoding != "" || job.DataRef != "" || len(job.Data) < jp.payloadLimits.CompressAbove {
+		return nil
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(job.Data); err != nil {
+		return fmt.Errorf("failed to compress job data: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress job data: %w", err)
+	}
+
+	if jp.blobs != nil && compressed.Len() > jp.payloadLimits.OffloadAbove {
+		key := jobPayloadBlobPrefix + job.ID
+		if err := jp.blobs.Put(reqCtx, key, compressed.Bytes()); err != nil {
+			return fmt.Errorf("failed to offload job data: %w", err)
+		}
+		job.Data, job.DataRef, job.Encoding = nil, key, payloadEncodingGzip
+		return nil
+	}
+
+	// A []byte marshals as a base64 string, which keeps the job valid JSON
+	encoded, err := json.Marshal(compressed.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to encode job data: %w", err)
+	}
+	job.Data, job.Encoding = encoded, payloadEncodingGzip
+	return nil
+}
+
+// decodeJobPayload restores the original data of a job encoded by encodeJobPayload or enqueued with a data_ref
+func (jp *JobProcessor) decodeJobPayload(reqCtx context.Context, job *jobs.Job) error {
+	var raw []byte
+	switch {
+	case job.DataRef != "":
+		if jp.blobs == nil {
+			return fmt.Errorf("job data is in blob %s but no blob store is configured", job.DataRef)
+		}
+		data, err := jp.blobs.Get(reqCtx, job.DataRef, jp.payloadLimits.MaxDecodedBytes)
+		if err != nil {
+			return err
+		}
+		raw = data
+	case job.Encoding != "":
+		if err := json.Unmarshal(job.Data, &raw); err != nil {
+			return fmt.Errorf("failed to decode job data: %w", err)
+		}
+	default:
+		return nil
+	}
+
+	switch job.Encoding {
+	case "":
+	case payloadEncodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return fmt.Errorf("failed to decompress job data: %w", err)
+		}
+		decompressed, err := io.ReadAll(io.LimitReader(reader, jp.payloadLimits.MaxDecodedBytes+1))
+		if err != nil {
+			return fmt.Errorf("failed to decompress job data: %w", err)
+		}
+		if int64(len(decompressed)) > jp.payloadLimits.MaxDecodedBytes {
+			return fmt.Errorf("job data decompresses to more than %d bytes", jp.payloadLimits.MaxDecodedBytes)
+		}
+		raw = decompressed
+	default:
+		return fmt.Errorf("unknown job data encoding: %s", job.Encoding)
+	}
+
+	if !json.Valid(raw) {
+		return fmt.Errorf("job data is not valid JSON")
+	}
+	job.Data, job.DataRef, job.Encoding = json.RawMessage(raw), "", ""
+	return nil
+}
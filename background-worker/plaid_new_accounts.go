@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"watson/database"
+	"watson/jobs"
+	"watson/plaid"
+
+	plaidapi "github.com/plaid/plaid-go/v31/plaid"
+)
+
+// processAddPlaidItemAccounts picks up accounts the user opened at a bank they already linked, after Plaid's
+// NEW_ACCOUNTS_AVAILABLE webhook. It refetches the item's accounts, stores them, asks the user whether the new ones
+// count towards their budget and fetches their transactions like a first sync would: the current month before the
+// daily balance, and their history in the background.
+func (jp *JobProcessor) processAddPlaidItemAccounts(job *jobs.Job) error {
+	log.Printf("🔄 Processing add Plaid item accounts job: %s", job.ID)
+	var jobData struct {
+		ItemID string `json:"item_id"`
+	}
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	token, err := database.GetPlaidItemToken(jobData.ItemID)
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		log.Printf("✅ Completed add Plaid item accounts job: %s (item %s no longer linked)", job.ID, jobData.ItemID)
+		return nil
+	}
+	known, err := database.GetPlaidItemAccountIDs(token.ID)
+	if err != nil {
+		return err
+	}
+	accounts, err := plaid.GetAccounts(job.Context(), token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to get accounts: %w", err)
+	}
+	// Existing accounts are upserted too, which refreshes their balances
+	if err := database.CreatePlaidAccount(job.Context(), token.UserID, token.ID, accounts); err != nil {
+		return fmt.Errorf("failed to create plaid account: %w", err)
+	}
+	var added []plaidapi.AccountBase
+	for _, account := range accounts {
+		if !slices.Contains(known, account.GetAccountId()) {
+			added = append(added, account)
+		}
+	}
+	if len(added) == 0 {
+		log.Printf("✅ Completed add Plaid item accounts job: %s (no new accounts on item %s)", job.ID, jobData.ItemID)
+		return nil
+	}
+
+	names := make([]string, 0, len(added))
+	accountIDs := make([]string, 0, len(added))
+	var steps []WorkflowStep
+	for _, account := range added {
+		names = append(names, account.GetName())
+		accountIDs = append(accountIDs, account.GetAccountId())
+		accountJSON, _ := json.Marshal(map[string]interface{}{
+			"account_id": account.GetAccountId(),
+			"user_id":    token.UserID,
+		})
+		steps = append(steps, WorkflowStep{Type: "fetch_plaid_transactions", Data: accountJSON})
+		jp.EnqueueJobContext(job.Context(), "backfill_plaid_history", accountJSON)
+	}
+	if _, err := jp.StartWorkflow(job.Context(), steps, dailyBalanceStep(token.UserID)); err != nil {
+		return fmt.Errorf("failed to start transaction fetch workflow: %w", err)
+	}
+	balanceJSON, _ := json.Marshal(map[string]interface{}{"user_id": token.UserID})
+	jp.EnqueueJobContext(job.Context(), "compute_monthly_balances", balanceJSON)
+
+	title := "New account found"
+	body := fmt.Sprintf("%s was added to your linked bank. It counts towards your budget; exclude it in its account settings if it shouldn't.", names[0])
+	if len(added) > 1 {
+		title = fmt.Sprintf("%d new accounts found", len(added))
+		body = fmt.Sprintf("%s were added to your linked bank. They count towards your budget; exclude any that shouldn't in their account settings.", strings.Join(names, ", "))
+	}
+	_, err = database.CreateNotification(token.UserID, database.NotificationTypeNewAccounts, title, body,
+		map[string]interface{}{"item_id": jobData.ItemID, "account_ids": accountIDs},
+		fmt.Sprintf("new_accounts:%s", accountIDs[0]))
+	if err != nil {
+		log.Printf("❌ Failed to notify user %d of new accounts: %v", token.UserID, err)
+	}
+	log.Printf("✅ Completed add Plaid item accounts job: %s (%d new accounts for user %d)", job.ID, len(added), token.UserID)
+	return nil
+}
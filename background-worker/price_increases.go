@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// priceIncreaseScanWindow is how far back detect_price_increases looks for newly imported charges. It runs daily,
+// so consecutive runs overlap and a late one doesn't miss any; insights are deduplicated per charge.
+const priceIncreaseScanWindow = 48 * time.Hour
+
+// processDetectPriceIncreases lets users know when a subscription they pay each month charges more than it used
+// to, with how much more and what that adds up to over a year. Jobs carrying a user_id only check that user.
+func (jp *JobProcessor) processDetectPriceIncreases(job *jobs.Job) error {
+	log.Printf("🔄 Processing detect price increases job: %s", job.ID)
+	var jobData struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	increases, err := database.DetectSubscriptionPriceIncreases(time.Now().Add(-priceIncreaseScanWindow), jobData.UserID)
+	if err != nil {
+		return err
+	}
+
+	notified := 0
+	for _, increase := range increases {
+		delta, annual := increase.Delta(), increase.AnnualImpact()
+		data := map[string]interface{}{
+			"transaction_id":  increase.TransactionID,
+			"merchant":        increase.Merchant,
+			"date":            increase.Date.Format(iso8601TimeFormat),
+			"amount":          increase.Amount,
+			"previous_amount": increase.PreviousAmount,
+			"previous_date":   increase.PreviousDate.Format(iso8601TimeFormat),
+			"delta":           delta,
+			"annual_impact":   annual,
+			"currency":        increase.Amount.Currency(),
+		}
+		created, err := database.CreateNotification(increase.UserID, database.NotificationTypeInsight,
+			fmt.Sprintf("%s raised its price", increase.Merchant),
+			fmt.Sprintf("%s charged $%.2f on %s, up $%.2f from $%.2f. That's $%.2f more a year.",
+				increase.Merchant, increase.Amount.Float64(), increase.Date.Format("Jan 2"), delta.Float64(),
+				increase.PreviousAmount.Float64(), annual.Float64()),
+			data, fmt.Sprintf("subscription_price_increase:%s", increase.TransactionID))
+		if err != nil {
+			log.Printf("❌ Failed to notify user %d of price increase %s: %v", increase.UserID, increase.TransactionID, err)
+			continue
+		}
+		if created {
+			notified++
+		}
+	}
+	log.Printf("✅ Completed detect price increases job: %s (%d of %d price increases notified)", job.ID, notified, len(increases))
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// defaultStaleAccountDays is how many days an account can go without a successful sync before it is flagged
+const defaultStaleAccountDays = 3
+
+// processFlagStaleAccounts flags accounts that haven't synced successfully in stale_after_days, so they show as
+// stale in sync health, and tells each user which of their accounts stopped syncing. An account is flagged once
+// until it syncs again, so users aren't told about it every day.
+func (jp *JobProcessor) processFlagStaleAccounts(job *jobs.Job) error {
+	log.Printf("🔄 Processing flag stale accounts job: %s", job.ID)
+	var jobData map[string]interface{}
+	if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
+		return fmt.Errorf("failed to parse job data: %w", err)
+	}
+	staleAfterDays := defaultStaleAccountDays
+	if days, ok := jobData["stale_after_days"].(float64); ok && days >= 1 {
+		staleAfterDays = int(days)
+	}
+
+	accounts, err := database.FlagStaleAccounts(time.Duration(staleAfterDays) * 24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	byUser := map[int][]database.StaleAccount{}
+	var userIDs []int
+	for _, account := range accounts {
+		if _, ok := byUser[account.UserID]; !ok {
+			userIDs = append(userIDs, account.UserID)
+		}
+		byUser[account.UserID] = append(byUser[account.UserID], account)
+	}
+	for _, userID := range userIDs {
+		notifyStaleAccounts(userID, byUser[userID], staleAfterDays)
+	}
+	log.Printf("✅ Completed flag stale accounts job: %s (%d accounts for %d users)", job.ID, len(accounts), len(userIDs))
+	return nil
+}
+
+// notifyStaleAccounts tells the user which of their accounts stopped syncing and that their data is missing
+func notifyStaleAccounts(userID int, accounts []database.StaleAccount, staleAfterDays int) {
+	names := make([]string, 0, len(accounts))
+	refs := make([]map[string]interface{}, 0, len(accounts))
+	for _, account := range accounts {
+		names = append(names, account.Name)
+		refs = append(refs, map[string]interface{}{
+			"provider":       account.Provider,
+			"account_id":     account.AccountID,
+			"last_synced_at": account.LastSyncedAt,
+		})
+	}
+	title := "An account stopped syncing"
+	if len(accounts) > 1 {
+		title = fmt.Sprintf("%d accounts stopped syncing", len(accounts))
+	}
+	body := fmt.Sprintf("%s hasn't synced in over %d days, so recent transactions are missing from your budgets. Check the connection in your account settings.",
+		strings.Join(names, ", "), staleAfterDays)
+	if len(accounts) > 1 {
+		body = fmt.Sprintf("%s haven't synced in over %d days, so recent transactions are missing from your budgets. Check their connections in your account settings.",
+			strings.Join(names, ", "), staleAfterDays)
+	}
+	_, err := database.CreateNotification(userID, database.NotificationTypeSyncFailure, title, body,
+		map[string]interface{}{"accounts": refs},
+		// Accounts are flagged once per stretch without syncing, so the first one identifies this batch
+		fmt.Sprintf("stale_accounts:%s:%s:%s", time.Now().Format("2006-01-02"), accounts[0].Provider, accounts[0].AccountID))
+	if err != nil {
+		log.Printf("❌ Failed to notify user %d of stale accounts: %v", userID, err)
+	}
+}
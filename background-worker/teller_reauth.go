@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"watson/database"
+	"watson/jobs"
+)
+
+// A Teller enrollment whose access Teller refuses with a 401 is flagged as needing reauthorization. Its syncs
+// are skipped rather than failing over and over, and the user is notified, then reminded every
+// tellerReauthRemindEvery up to maxTellerReauthReminders times, until they reconnect it with Teller Connect.
+const (
+	tellerReauthRemindEvery  = 3 * 24 * time.Hour
+	maxTellerReauthReminders = 4
+)
+
+// errTellerUnauthorized marks Teller API calls refused because the enrollment's access has lapsed
+var errTellerUnauthorized = errors.New("teller access is no longer authorized")
+
+// tellerAPIError describes a failed Teller API response
+func tellerAPIError(statusCode int, body []byte) error {
+	if statusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %s", errTellerUnauthorized, body)
+	}
+	return fmt.Errorf("API request failed with status %d: %s", statusCode, string(body))
+}
+
+// tellerEnrollmentStatus returns the status of the enrollment an access token belongs to. Syncs are skipped
+// while it waits for the user to reconnect it and once the user removed it.
+func tellerEnrollmentStatus(userID int, accessToken string) string {
+	status, err := database.GetTellerEnrollmentStatus(userID, accessToken)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return database.TellerEnrollmentActive
+	}
+	return status
+}
+
+// recordTellerSyncOutcome notes a successful sync against the enrollment, or when Teller refused its access,
+// flags it and asks the user to reconnect. Other failures leave it as it was.
+func (jp *JobProcessor) recordTellerSyncOutcome(userID int, accessToken string, syncErr error) {
+	if syncErr == nil {
+		if err := database.RecordTellerSyncSuccess(userID, accessToken); err != nil {
+			log.Printf("❌ %v", err)
+		}
+		return
+	}
+	if !errors.Is(syncErr, errTellerUnauthorized) {
+		return
+	}
+
+	enrollment, err := database.FlagTellerReauthRequired(userID, accessToken, syncErr.Error())
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return
+	}
+	if enrollment == nil {
+		return // Already flagged, reminders take it from here
+	}
+	log.Printf("🔐 Teller enrollment %s for user %d needs reauthorization", enrollment.ID, userID)
+	notifyTellerReauth(enrollment, 1)
+}
+
+// notifyTellerReauth asks the user to reconnect an enrollment; reminder counts from 1 for the first notice
+func notifyTellerReauth(enrollment *database.TellerEnrollment, reminder int) {
+	data := map[string]interface{}{
+		"teller_institution_id": enrollment.ID,
+		"enrollment_id":         enrollment.EnrollmentID,
+		"institution":           enrollment.Name,
+	}
+	dedupeKey := fmt.Sprintf("teller_reauth:%s:%d", enrollment.ID, reminder)
+	_, err := database.CreateNotification(enrollment.UserID, database.NotificationTypeReauthRequired,
+		fmt.Sprintf("Reconnect %s", enrollment.Name),
+		fmt.Sprintf("Your connection to %s has expired, so we can't sync new transactions. Reconnect it to catch up.", enrollment.Name),
+		data, dedupeKey)
+	if err != nil {
+		log.Printf("❌ Failed to notify user %d to reconnect %s: %v", enrollment.UserID, enrollment.ID, err)
+	}
+}
+
+// processRemindTellerReauth reminds users of enrollments still waiting to be reconnected
+func (jp *JobProcessor) processRemindTellerReauth(job *jobs.Job) error {
+	log.Printf("🔄 Processing remind Teller reauth job: %s", job.ID)
+	enrollments, err := database.GetTellerEnrollmentsDueReauthReminder(tellerReauthRemindEvery, maxTellerReauthReminders)
+	if err != nil {
+		return err
+	}
+	for i := range enrollments {
+		reminder, err := database.MarkTellerReauthReminded(enrollments[i].ID)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			continue
+		}
+		notifyTellerReauth(&enrollments[i], reminder)
+	}
+	log.Printf("✅ Completed remind Teller reauth job: %s (%d reminders)", job.ID, len(enrollments))
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+	"watson/database"
+	"watson/jobs"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Events are queued as one webhook delivery per subscribed endpoint and sent by the deliver_webhooks job,
+// which emitting an event enqueues and the scheduler also runs every minute to pick up retries. Each request
+// is signed so receivers can verify it: Watson-Signature is "t=<unix time>,v1=<hex HMAC-SHA256 of
+// "<unix time>.<body>" keyed with the endpoint's secret>". Failed deliveries are retried with exponential
+// backoff until maxWebhookAttempts.
+const (
+	webhookBatchSize      = 100
+	webhookParallelism    = 8
+	webhookRequestTimeout = 10 * time.Second
+	maxWebhookAttempts    = 8
+	webhookRetryBase      = time.Minute
+	webhookRetryMax       = 6 * time.Hour
+	// maxWebhookErrorBody caps how much of a failed response is kept in the delivery log
+	maxWebhookErrorBody = 1 << 10
+)
+
+// WebhookEvent is the body of every webhook request
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// errWebhookAddressBlocked marks deliveries refused because the URL resolved to a private address
+var errWebhookAddressBlocked = errors.New("webhook address is not public")
+
+// newWebhookClient builds the client deliveries are sent with. It refuses to connect to loopback, private and
+// link local addresses, checked after DNS resolution so a public name pointing inside the network is refused
+// too, and doesn't follow redirects. WEBHOOK_ALLOW_PRIVATE_URLS=true lifts the address check for local testing.
+func newWebhookClient() *http.Client {
+	allowPrivate := os.Getenv("WEBHOOK_ALLOW_PRIVATE_URLS") == "true"
+	dialer := &net.Dialer{
+		Timeout: webhookRequestTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return fmt.Errorf("%w: %s", errWebhookAddressBlocked, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookRequestTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookRequestTimeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookEventID derives an event's id from its type and the object it is about, so emitting it again, as a
+// retried job does, queues no second delivery
+func webhookEventID(eventType string, sourceID string) string {
+	sum := sha256.Sum256([]byte(eventType + ":" + sourceID))
+	return "evt_" + hex.EncodeToString(sum[:16])
+}
+
+// emitWebhookEvent queues an event for the user's subscribed endpoints and enqueues its delivery. sourceID names
+// what the event is about, such as the transaction created, and makes the event's id. Webhooks are best effort
+// from the caller's point of view, so failures are logged rather than failing the job that emitted it.
+func (jp *JobProcessor) emitWebhookEvent(reqCtx context.Context, userID int, eventType string, sourceID string, data interface{}) {
+	event := WebhookEvent{
+		ID:        webhookEventID(eventType, sourceID),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("❌ Failed to marshal %s webhook event for user %d: %v", eventType, userID, err)
+		return
+	}
+	queued, err := database.CreateWebhookDeliveries(userID, event.ID, eventType, payload)
+	if err != nil {
+		log.Printf("❌ Failed to queue %s webhook event for user %d: %v", eventType, userID, err)
+		return
+	}
+	if queued == 0 {
+		return
+	}
+	if err := jp.EnqueueJobContext(reqCtx, "deliver_webhooks", json.RawMessage(`{}`)); err != nil {
+		log.Printf("❌ Failed to enqueue webhook delivery, the scheduler will pick it up: %v", err)
+	}
+}
+
+// processDeliverWebhooks sends every webhook delivery that is due
+func (jp *JobProcessor) processDeliverWebhooks(job *jobs.Job) error {
+	// Claims outlast a full round of requests so a delivery still in flight isn't claimed again
+	lease := webhookRequestTimeout * time.Duration(webhookBatchSize/webhookParallelism+1)
+	sent, failed := 0, 0
+	for {
+		deliveries, err := database.ClaimDueWebhookDeliveries(job.Context(), webhookBatchSize, lease)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+
+		results := make([]bool, len(deliveries))
+		var group errgroup.Group
+		group.SetLimit(webhookParallelism)
+		for i := range deliveries {
+			group.Go(func() error {
+				results[i] = jp.deliverWebhook(job.Context(), &deliveries[i])
+				return nil
+			})
+		}
+		group.Wait()
+		for _, ok := range results {
+			if ok {
+				sent++
+			} else {
+				failed++
+			}
+		}
+		if len(deliveries) < webhookBatchSize {
+			break
+		}
+	}
+	log.Printf("✅ Completed deliver webhooks job: %s (%d sent, %d failed)", job.ID, sent, failed)
+	return nil
+}
+
+// deliverWebhook sends one delivery and records the outcome, reporting whether the endpoint accepted it
+func (jp *JobProcessor) deliverWebhook(reqCtx context.Context, delivery *database.DueWebhookDelivery) bool {
+	responseStatus, err := jp.sendWebhook(reqCtx, delivery)
+	status, lastError, nextAttemptAt := database.WebhookDeliverySucceeded, "", time.Now()
+	if err != nil {
+		lastError = err.Error()
+		if delivery.Attempts >= maxWebhookAttempts || errors.Is(err, errWebhookAddressBlocked) {
+			status = database.WebhookDeliveryFailed
+		} else {
+			status = database.WebhookDeliveryPending
+			nextAttemptAt = time.Now().Add(webhookRetryDelay(delivery.Attempts))
+		}
+		log.Printf("❌ Webhook delivery %d to %s failed (attempt %d): %v", delivery.ID, delivery.URL, delivery.Attempts, err)
+	}
+	if recordErr := database.RecordWebhookDeliveryAttempt(reqCtx, delivery.ID, status, responseStatus, lastError, nextAttemptAt); recordErr != nil {
+		log.Printf("❌ %v", recordErr)
+	}
+	return err == nil
+}
+
+// sendWebhook posts a signed delivery, returning the response status when one came back
+func (jp *JobProcessor) sendWebhook(reqCtx context.Context, delivery *database.DueWebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Watson-Webhooks/1.0")
+	req.Header.Set("Watson-Event", delivery.EventType)
+	req.Header.Set("Watson-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("Watson-Signature", "t="+timestamp+",v1="+signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := jp.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookErrorBody))
+		return resp.StatusCode, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookErrorBody))
+	return resp.StatusCode, fmt.Errorf("endpoint responded with status %d: %s", resp.StatusCode, body)
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<payload>" keyed with secret
+func signWebhook(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay doubles the wait after each failed attempt, from webhookRetryBase up to webhookRetryMax
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(float64(webhookRetryBase) * math.Pow(2, float64(attempts-1)))
+	if delay > webhookRetryMax || delay <= 0 {
+		return webhookRetryMax
+	}
+	return delay
+}
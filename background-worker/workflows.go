@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"watson/jobs"
+	"watson/redisconn"
+)
+
+// A workflow fans a job out into steps that run in parallel and enqueues a completion job once every step has
+// finished, so pipelines such as initial_plaid_sync → fetch_plaid_transactions per account → process_daily_balance
+// end with a defined terminal step. Steps that fail, and are dead lettered, still count as finished so one bad
+// account can't hold the completion back. Jobs a step enqueues itself are not part of the workflow.
+//
+// Each workflow is a Redis hash, namespaced with redisconn.Key, holding how many steps are still outstanding,
+// how many failed, and the completion job. It expires after workflowTTL in case steps are lost.
+const (
+	workflowKeyPrefix = "workflow:"
+	workflowTTL       = 48 * time.Hour
+)
+
+// WorkflowStep is a job to enqueue as part of a workflow
+type WorkflowStep struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// NewWorkflowStep builds a step from data of any JSON encodable type
+func NewWorkflowStep(jobType string, data interface{}) WorkflowStep {
+	dataJSON, _ := json.Marshal(data)
+	return WorkflowStep{Type: jobType, Data: dataJSON}
+}
+
+func workflowKey(workflowID string) string {
+	return redisconn.Key(workflowKeyPrefix + workflowID)
+}
+
+// StartWorkflow enqueues the steps as one workflow, with onComplete enqueued once they have all finished. Without
+// any steps onComplete is enqueued straight away. It returns the workflow's ID.
+func (jp *JobProcessor) StartWorkflow(parent context.Context, steps []WorkflowStep, onComplete WorkflowStep) (string, error) {
+	if len(steps) == 0 {
+		return "", jp.EnqueueJobContext(parent, onComplete.Type, onComplete.Data)
+	}
+	workflowID := fmt.Sprintf("wf_%d", time.Now().UnixNano())
+	completionJSON, err := json.Marshal(onComplete)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal workflow completion: %w", err)
+	}
+	pipe := jp.rdb.TxPipeline()
+	pipe.HSet(ctx, workflowKey(workflowID), "pending", len(steps), "failed", 0, "on_complete", completionJSON)
+	pipe.Expire(ctx, workflowKey(workflowID), workflowTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("failed to create workflow: %w", err)
+	}
+
+	for _, step := range steps {
+		job := jobs.New(parent, step.Type, step.Data)
+		job.WorkflowID = workflowID
+		if err := jp.enqueue(parent, job); err != nil {
+			// The step will never finish, so count it as failed to keep the completion from waiting on it
+			log.Printf("❌ Failed to enqueue %s step of workflow %s: %v", step.Type, workflowID, err)
+			jp.finishWorkflowStep(&job, err)
+		}
+	}
+	log.Printf("✅ Started workflow %s with %d steps, then %s", workflowID, len(steps), onComplete.Type)
+	return workflowID, nil
+}
+
+// finishWorkflowStep records that a workflow step finished, successfully or not, and enqueues the workflow's
+// completion job when it was the last one outstanding. Jobs outside a workflow are ignored.
+func (jp *JobProcessor) finishWorkflowStep(job *jobs.Job, stepErr error) {
+	if job.WorkflowID == "" {
+		return
+	}
+	key := workflowKey(job.WorkflowID)
+	if stepErr != nil {
+		if err := jp.rdb.HIncrBy(ctx, key, "failed", 1).Err(); err != nil {
+			log.Printf("❌ Failed to record failed step %s of workflow %s: %v", job.ID, job.WorkflowID, err)
+		}
+	}
+	pending, err := jp.rdb.HIncrBy(ctx, key, "pending", -1).Result()
+	if err != nil {
+		log.Printf("❌ Failed to record finished step %s of workflow %s: %v", job.ID, job.WorkflowID, err)
+		return
+	}
+	if pending > 0 {
+		return
+	}
+	if pending < 0 {
+		// The workflow expired or already completed, and the decrement recreated its hash
+		jp.rdb.Del(ctx, key)
+		return
+	}
+	// Only the step that took pending to zero gets here, so the completion is enqueued once
+	state, err := jp.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		log.Printf("❌ Failed to read workflow %s: %v", job.WorkflowID, err)
+		return
+	}
+	jp.rdb.Del(ctx, key)
+	var onComplete WorkflowStep
+	if err := json.Unmarshal([]byte(state["on_complete"]), &onComplete); err != nil {
+		// The hash expired before the last step finished, so there is nothing left to run
+		log.Printf("❌ Workflow %s has no completion job: %v", job.WorkflowID, err)
+		return
+	}
+	if state["failed"] != "" && state["failed"] != "0" {
+		log.Printf("❌ Workflow %s finished with %s failed steps", job.WorkflowID, state["failed"])
+	}
+	// The step's own context is cancelled once it has been processed, but the completion joins its trace
+	if err := jp.EnqueueJobContext(context.WithoutCancel(job.Context()), onComplete.Type, onComplete.Data); err != nil {
+		log.Printf("❌ Failed to enqueue %s completing workflow %s: %v", onComplete.Type, job.WorkflowID, err)
+		return
+	}
+	log.Printf("✅ Finished workflow %s", job.WorkflowID)
+}
@@ -72,10 +72,12 @@ function App() {
     setStatus('loading')
     setMessage('Connecting your bank account via Teller...')
     
+    // Teller Connect doesn't hand back the nonce it signed, so send the one from the bank link
+    const nonce = new URLSearchParams(window.location.search).get('nonce')
     const url = import.meta.env.VITE_BACKEND_URL || 'http://0.0.0.0:8080'
     const response = await fetch(url + '/bank-link-teller/success', {
       method: 'POST',
-      body: JSON.stringify(authorization),
+      body: JSON.stringify({ ...authorization, nonce }),
       headers: {
         'Content-Type': 'application/json',
         'Authorization': `Bearer ${jwt}`
@@ -3,12 +3,19 @@ import { useTellerConnect } from 'teller-connect-react';
 
 interface TellerLinkProps {
   onSuccess: (authorization: any) => void;
+  // Reconnects an existing enrollment that needs reauthorization instead of linking a new one
+  enrollmentId?: string;
 }
 
-export default function TellerLink({ onSuccess }: TellerLinkProps) {
+export default function TellerLink({ onSuccess, enrollmentId }: TellerLinkProps) {
+  // Issued with the bank link; Teller signs the enrollment with it so the backend can check it
+  const nonce = new URLSearchParams(window.location.search).get('nonce') ?? undefined;
+
   const { open, ready } = useTellerConnect({
     applicationId: import.meta.env.VITE_TELLER_APPLICATION_ID,
     environment: 'sandbox',
+    enrollmentId,
+    nonce,
     onSuccess,
     // You can add onEvent, onExit, etc. here if needed
   });
@@ -0,0 +1,82 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Store keeps blobs too large to carry inline, such as big job payloads, in object storage
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string, maxBytes int64) ([]byte, error)
+}
+
+// NewFromEnv connects to the S3 compatible bucket configured by the environment, or returns nil when
+// BLOB_STORE_BUCKET is not set. GCS works through its interoperability endpoint.
+//
+//	BLOB_STORE_ENDPOINT    host of the S3 API, e.g. storage.googleapis.com or s3.us-east-1.amazonaws.com
+//	BLOB_STORE_BUCKET      bucket holding the blobs
+//	BLOB_STORE_ACCESS_KEY  and BLOB_STORE_SECRET_KEY, or neither to use the instance's IAM role
+//	BLOB_STORE_REGION      optional bucket region
+//	BLOB_STORE_INSECURE    "true" to connect without TLS, for local emulators
+func NewFromEnv() (Store, error) {
+	bucket := os.Getenv("BLOB_STORE_BUCKET")
+	if bucket == "" {
+		log.Printf("BLOB_STORE_BUCKET not set, large blobs can't be offloaded")
+		return nil, nil
+	}
+
+	creds := credentials.NewIAM("")
+	if accessKey := os.Getenv("BLOB_STORE_ACCESS_KEY"); accessKey != "" {
+		creds = credentials.NewStaticV4(accessKey, os.Getenv("BLOB_STORE_SECRET_KEY"), "")
+	}
+	client, err := minio.New(os.Getenv("BLOB_STORE_ENDPOINT"), &minio.Options{
+		Creds:  creds,
+		Secure: os.Getenv("BLOB_STORE_INSECURE") != "true",
+		Region: os.Getenv("BLOB_STORE_REGION"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob store client: %w", err)
+	}
+	log.Printf("Blob store enabled with bucket %s", bucket)
+	return &bucketStore{client: client, bucket: bucket}, nil
+}
+
+type bucketStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func (s *bucketStore) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get reads a blob, failing rather than reading more than maxBytes
+func (s *bucketStore) Get(ctx context.Context, key string, maxBytes int64) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob %s: %w", key, err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(io.LimitReader(object, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("blob %s is larger than %d bytes", key, maxBytes)
+	}
+	return data, nil
+}
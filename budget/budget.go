@@ -0,0 +1,230 @@
+package budget
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"watson/money"
+)
+
+// Category is what the engine needs to know about one budget category for a day's allowances
+type Category struct {
+	Name string
+	// Budget is the category's budget for the window, already prorated for users who started part way through it
+	Budget money.Money
+	Spent  money.Money
+	// Hard categories are left out of redistribution, see StrictnessHard
+	Hard bool
+}
+
+// A category's strictness decides whether it takes part in redistribution. Soft categories lend to and borrow
+// from each other as the strategy decides. Hard categories never borrow from the others, nor lend to them, so
+// their allowance is always their own left to spend and overspending shows as a negative allowance.
+const (
+	StrictnessSoft = "soft"
+	StrictnessHard = "hard"
+)
+
+// DefaultStrictness is the strictness categories are created with
+const DefaultStrictness = StrictnessSoft
+
+// IsValidStrictness reports whether strictness is soft or hard
+func IsValidStrictness(strictness string) bool {
+	return strictness == StrictnessSoft || strictness == StrictnessHard
+}
+
+// Window is how far through the budget window the calculation is made
+type Window struct {
+	DaysInto int
+	Days     int
+}
+
+// Allowance is one category's result, in the order the categories were given
+type Allowance struct {
+	Name string
+	// LeftToSpend is how far under (positive) or over (negative) its pro-rata allowance the category is
+	LeftToSpend money.Money
+	// Allowance is LeftToSpend after the strategy has moved money between categories
+	Allowance money.Money
+}
+
+// LeftToSpend returns how far under (positive) or over (negative) the pro-rata allowance a category is, given
+// its budget for the whole window and how many of the window's days have elapsed
+func LeftToSpend(spent money.Money, windowBudget money.Money, window Window) money.Money {
+	if window.Days <= 0 {
+		return windowBudget.Sub(spent)
+	}
+	return windowBudget.Prorate(int64(window.DaysInto), int64(window.Days)).Sub(spent)
+}
+
+// Strategy decides how categories under their allowance cover categories over it. Redistribute receives the
+// categories and each one's left to spend, and returns the allowances in the same order. Strategies must never
+// allocate more than the categories have left unspent: a strategy that moves money between categories keeps their
+// total left to spend to the cent, and one that doesn't never gives a category more than its own left to spend.
+type Strategy interface {
+	Redistribute(categories []Category, leftToSpend []money.Money) []money.Money
+}
+
+// Allocate works out every category's allowance for the window, redistributing between the soft categories
+// with the strategy. Hard categories keep their own left to spend.
+func Allocate(categories []Category, window Window, strategy Strategy) []Allowance {
+	leftToSpend := make([]money.Money, len(categories))
+	var soft []int
+	for i, category := range categories {
+		leftToSpend[i] = LeftToSpend(category.Spent, category.Budget, window)
+		if !category.Hard {
+			soft = append(soft, i)
+		}
+	}
+	allowances := append([]money.Money(nil), leftToSpend...)
+	if len(soft) > 0 {
+		softCategories := make([]Category, len(soft))
+		softLeftToSpend := make([]money.Money, len(soft))
+		for j, i := range soft {
+			softCategories[j], softLeftToSpend[j] = categories[i], leftToSpend[i]
+		}
+		for j, allowance := range strategy.Redistribute(softCategories, softLeftToSpend) {
+			allowances[soft[j]] = allowance
+		}
+	}
+	results := make([]Allowance, len(categories))
+	for i, category := range categories {
+		results[i] = Allowance{Name: category.Name, LeftToSpend: leftToSpend[i], Allowance: allowances[i]}
+	}
+	return results
+}
+
+const (
+	StrategyProportional = "proportional"
+	StrategyStrict       = "strict"
+	StrategyPooled       = "pooled"
+	StrategyEnvelope     = "envelope"
+)
+
+// DefaultStrategy is the strategy used unless another is configured
+const DefaultStrategy = StrategyProportional
+
+// StrategyNamed returns the strategy configured by name
+func StrategyNamed(name string) (Strategy, error) {
+	switch name {
+	case StrategyProportional:
+		return Proportional{}, nil
+	case StrategyStrict:
+		return Strict{}, nil
+	case StrategyPooled:
+		return Pooled{}, nil
+	case StrategyEnvelope:
+		return Envelope{}, nil
+	}
+	return nil, fmt.Errorf("unknown budget strategy %q", name)
+}
+
+// IsValidStrategy reports whether name is one of the supported strategies
+func IsValidStrategy(name string) bool {
+	_, err := StrategyNamed(name)
+	return err == nil
+}
+
+// Strict leaves every category with its own left to spend, so overspending shows as a negative allowance
+type Strict struct{}
+
+func (Strict) Redistribute(categories []Category, leftToSpend []money.Money) []money.Money {
+	return append([]money.Money(nil), leftToSpend...)
+}
+
+// Envelope treats each category as an envelope that can't borrow from the others. Overspent envelopes are
+// empty rather than negative, and the rest keep everything they have left.
+type Envelope struct{}
+
+func (Envelope) Redistribute(categories []Category, leftToSpend []money.Money) []money.Money {
+	allowances := append([]money.Money(nil), leftToSpend...)
+	for i, left := range leftToSpend {
+		if left.IsNegative() {
+			allowances[i] = money.Money{}.In(left.Currency())
+		}
+	}
+	return allowances
+}
+
+// Pooled treats the categories as one pot: whatever is left across all of them, or the overall deficit, is shared
+// out in proportion to their budgets, so spending anywhere lowers every allowance. Without any budgets to weigh by,
+// each category keeps its own left to spend.
+type Pooled struct{}
+
+func (Pooled) Redistribute(categories []Category, leftToSpend []money.Money) []money.Money {
+	weights := make([]int64, len(categories))
+	var total money.Money
+	var totalWeight int64
+	for i, category := range categories {
+		total = total.Add(leftToSpend[i])
+		if category.Budget.IsPositive() {
+			weights[i] = category.Budget.Cents()
+			totalWeight += weights[i]
+		}
+	}
+	if totalWeight == 0 {
+		return append([]money.Money(nil), leftToSpend...)
+	}
+	return apportion(total, weights)
+}
+
+// Proportional zeroes the categories that are over their allowance and takes what they overspent from the
+// categories under it, in proportion to how much each has left. When the categories under their allowance can't
+// cover the overspending, nothing is moved so the allowances show the true deficit.
+type Proportional struct{}
+
+func (Proportional) Redistribute(categories []Category, leftToSpend []money.Money) []money.Money {
+	var borrowable, overspent money.Money
+	weights := make([]int64, len(leftToSpend))
+	for i, left := range leftToSpend {
+		if left.IsNegative() {
+			overspent = overspent.Add(left.Neg())
+		} else {
+			borrowable = borrowable.Add(left)
+			weights[i] = left.Cents()
+		}
+	}
+	if !overspent.IsPositive() || !borrowable.IsPositive() || borrowable.Cmp(overspent) < 0 {
+		return append([]money.Money(nil), leftToSpend...)
+	}
+	return apportion(borrowable.Sub(overspent), weights)
+}
+
+// apportion splits total between the weights, which must be non-negative with a positive sum. Shares are rounded
+// towards zero and the cents that don't divide evenly go to the largest remainders, so the shares always add up to
+// exactly total and a zero weight always gets nothing.
+func apportion(total money.Money, weights []int64) []money.Money {
+	var totalWeight int64
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	totalCents := total.Cents()
+	sign := int64(1)
+	if totalCents < 0 {
+		sign, totalCents = -1, -totalCents
+	}
+
+	type share struct {
+		index     int
+		remainder *big.Int
+	}
+	shares := make([]share, len(weights))
+	allowances := make([]money.Money, len(weights))
+	allocated := int64(0)
+	for i, weight := range weights {
+		// weight * total / totalWeight, rounded down, with the remainder kept to hand out the leftover cents
+		numerator := new(big.Int).Mul(big.NewInt(weight), big.NewInt(totalCents))
+		quotient, remainder := new(big.Int).QuoRem(numerator, big.NewInt(totalWeight), new(big.Int))
+		allowances[i] = money.FromCents(sign * quotient.Int64()).In(total.Currency())
+		allocated += quotient.Int64()
+		shares[i] = share{index: i, remainder: remainder}
+	}
+	sort.SliceStable(shares, func(a, b int) bool {
+		return shares[a].remainder.Cmp(shares[b].remainder) > 0
+	})
+	for i := int64(0); i < totalCents-allocated; i++ {
+		index := shares[i].index
+		allowances[index] = allowances[index].Add(money.FromCents(sign))
+	}
+	return allowances
+}
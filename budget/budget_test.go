@@ -0,0 +1,226 @@
+package budget
+
+import (
+	"math/rand/v2"
+	"testing"
+	"watson/money"
+)
+
+// strategies are every configurable strategy by name
+var strategies = []string{StrategyProportional, StrategyStrict, StrategyPooled, StrategyEnvelope}
+
+// movesMoney reports whether a strategy moves money between categories rather than only clamping each one
+func movesMoney(name string) bool {
+	return name != StrategyEnvelope
+}
+
+func cents(amounts ...int64) []money.Money {
+	result := make([]money.Money, len(amounts))
+	for i, amount := range amounts {
+		result[i] = money.FromCents(amount)
+	}
+	return result
+}
+
+func sum(amounts []money.Money) int64 {
+	var total int64
+	for _, amount := range amounts {
+		total += amount.Cents()
+	}
+	return total
+}
+
+// unspent is what the categories with money left have between them, the most any strategy may hand out
+func unspent(leftToSpend []money.Money) int64 {
+	var total int64
+	for _, left := range leftToSpend {
+		if left.IsPositive() {
+			total += left.Cents()
+		}
+	}
+	return total
+}
+
+func TestStrategyNamed(t *testing.T) {
+	for _, name := range strategies {
+		if _, err := StrategyNamed(name); err != nil {
+			t.Errorf("StrategyNamed(%q): %v", name, err)
+		}
+	}
+	if _, err := StrategyNamed("greedy"); err == nil {
+		t.Error("StrategyNamed of an unknown strategy returned no error")
+	}
+}
+
+func TestLeftToSpend(t *testing.T) {
+	tests := []struct {
+		name   string
+		spent  int64
+		budget int64
+		window Window
+		want   int64
+	}{
+		{"start of window", 0, 30000, Window{DaysInto: 0, Days: 30}, 0},
+		{"part way", 5000, 30000, Window{DaysInto: 10, Days: 30}, 5000},
+		{"overspent", 15000, 30000, Window{DaysInto: 10, Days: 30}, -5000},
+		{"odd cents round half away from zero", 0, 100, Window{DaysInto: 1, Days: 8}, 13},
+		{"no window days", 2500, 10000, Window{}, 7500},
+	}
+	for _, tt := range tests {
+		got := LeftToSpend(money.FromCents(tt.spent), money.FromCents(tt.budget), tt.window)
+		if got.Cents() != tt.want {
+			t.Errorf("%s: LeftToSpend = %d cents, want %d", tt.name, got.Cents(), tt.want)
+		}
+	}
+}
+
+func TestRedistribute(t *testing.T) {
+	categories := func(budgets ...int64) []Category {
+		result := make([]Category, len(budgets))
+		for i, budget := range budgets {
+			result[i] = Category{Budget: money.FromCents(budget)}
+		}
+		return result
+	}
+	tests := []struct {
+		name        string
+		strategy    Strategy
+		categories  []Category
+		leftToSpend []money.Money
+		want        []money.Money
+	}{
+		{"strict keeps deficits", Strict{}, categories(100, 100), cents(-30, 50), cents(-30, 50)},
+		{"envelope empties overspent", Envelope{}, categories(100, 100), cents(-30, 50), cents(0, 50)},
+		{"proportional covers overspending", Proportional{}, categories(100, 100, 100), cents(-30, 60, 30), cents(0, 40, 20)},
+		{"proportional splits odd cents", Proportional{}, categories(100, 100, 100, 100), cents(-1, 1, 1, 1), cents(0, 1, 1, 0)},
+		{"proportional shows an uncovered deficit", Proportional{}, categories(100, 100), cents(-80, 50), cents(-80, 50)},
+		{"proportional without overspending", Proportional{}, categories(100, 100), cents(10, 20), cents(10, 20)},
+		{"pooled by budget", Pooled{}, categories(100, 300), cents(-20, 100), cents(20, 60)},
+		{"pooled splits odd cents", Pooled{}, categories(1, 1, 1), cents(100, 0, 0), cents(34, 33, 33)},
+		{"pooled shares a deficit", Pooled{}, categories(100, 100), cents(-101, 0), cents(-51, -50)},
+		{"pooled without budgets", Pooled{}, categories(0, 0), cents(-5, 10), cents(-5, 10)},
+	}
+	for _, tt := range tests {
+		got := tt.strategy.Redistribute(tt.categories, tt.leftToSpend)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d allowances, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if got[i].Cents() != tt.want[i].Cents() {
+				t.Errorf("%s: allowances = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAllocateKeepsHardCategoriesOutOfRedistribution(t *testing.T) {
+	categories := []Category{
+		{Name: "Dining", Budget: money.FromCents(30000), Spent: money.FromCents(20000)},
+		{Name: "Rent", Budget: money.FromCents(150000), Spent: money.FromCents(160000), Hard: true},
+		{Name: "Groceries", Budget: money.FromCents(30000), Spent: money.FromCents(0)},
+	}
+	allowances := Allocate(categories, Window{DaysInto: 15, Days: 30}, Proportional{})
+
+	want := []struct {
+		name        string
+		leftToSpend int64
+		allowance   int64
+	}{
+		{"Dining", -5000, 0},
+		{"Rent", -85000, -85000},
+		{"Groceries", 15000, 10000},
+	}
+	for i, w := range want {
+		got := allowances[i]
+		if got.Name != w.name || got.LeftToSpend.Cents() != w.leftToSpend || got.Allowance.Cents() != w.allowance {
+			t.Errorf("allowance %d = %s %d/%d, want %s %d/%d", i, got.Name, got.LeftToSpend.Cents(), got.Allowance.Cents(),
+				w.name, w.leftToSpend, w.allowance)
+		}
+	}
+}
+
+// TestAllocateNeverOverAllocates checks random budgets against every strategy: no strategy hands out more than the
+// categories have left unspent, those that move money keep the total to the cent, and hard categories keep
+// their own left to spend.
+func TestAllocateNeverOverAllocates(t *testing.T) {
+	rng := rand.New(rand.NewPCG(2912, 1))
+	for run := 0; run < 2000; run++ {
+		categories := make([]Category, 1+rng.IntN(8))
+		for i := range categories {
+			categories[i] = Category{
+				// Odd budgets and spending make prorations and shares fall between cents
+				Budget: money.FromCents(rng.Int64N(100001)),
+				Spent:  money.FromCents(rng.Int64N(150001)),
+				Hard:   rng.IntN(4) == 0,
+			}
+		}
+		days := 28 + rng.IntN(4)
+		window := Window{DaysInto: rng.IntN(days + 1), Days: days}
+
+		for _, name := range strategies {
+			strategy, _ := StrategyNamed(name)
+			allowances := Allocate(categories, window, strategy)
+
+			var softLeft, softAllowed []money.Money
+			for i, allowance := range allowances {
+				left := LeftToSpend(categories[i].Spent, categories[i].Budget, window)
+				if allowance.LeftToSpend.Cents() != left.Cents() {
+					t.Fatalf("run %d %s: category %d left to spend = %s, want %s", run, name, i, allowance.LeftToSpend, left)
+				}
+				if categories[i].Hard {
+					if allowance.Allowance.Cents() != left.Cents() {
+						t.Fatalf("run %d %s: hard category %d allowance = %s, want its own left to spend %s", run, name, i, allowance.Allowance, left)
+					}
+					continue
+				}
+				if !movesMoney(name) && allowance.Allowance.Cents() > max(left.Cents(), 0) {
+					t.Fatalf("run %d %s: category %d allowance %s is more than its own left to spend %s", run, name, i, allowance.Allowance, left)
+				}
+				softLeft = append(softLeft, left)
+				softAllowed = append(softAllowed, allowance.Allowance)
+			}
+
+			if allocated, available := sum(softAllowed), unspent(softLeft); allocated > available {
+				t.Fatalf("run %d %s: allocated %d cents with only %d left unspent (left to spend %v, allowances %v)",
+					run, name, allocated, available, softLeft, softAllowed)
+			}
+			if movesMoney(name) && sum(softAllowed) != sum(softLeft) {
+				t.Fatalf("run %d %s: allowances add up to %d cents, want the %d left to spend (left to spend %v, allowances %v)",
+					run, name, sum(softAllowed), sum(softLeft), softLeft, softAllowed)
+			}
+		}
+	}
+}
+
+func TestApportionAddsUpExactly(t *testing.T) {
+	rng := rand.New(rand.NewPCG(2912, 2))
+	for run := 0; run < 2000; run++ {
+		weights := make([]int64, 1+rng.IntN(10))
+		var totalWeight int64
+		for i := range weights {
+			if rng.IntN(3) > 0 {
+				weights[i] = rng.Int64N(100000)
+			}
+			totalWeight += weights[i]
+		}
+		if totalWeight == 0 {
+			weights[0], totalWeight = 1, 1
+		}
+		total := money.FromCents(rng.Int64N(2000001) - 1000000)
+
+		shares := apportion(total, weights)
+		if sum(shares) != total.Cents() {
+			t.Fatalf("run %d: apportion(%s, %v) = %v, adding up to %d cents", run, total, weights, shares, sum(shares))
+		}
+		for i, share := range shares {
+			if weights[i] == 0 && !share.IsZero() {
+				t.Fatalf("run %d: apportion(%s, %v) gave %s to a zero weight", run, total, weights, share)
+			}
+			if share.Cents()*total.Cents() < 0 {
+				t.Fatalf("run %d: apportion(%s, %v) gave %s, against the sign of the total", run, total, weights, share)
+			}
+		}
+	}
+}
@@ -1,12 +1,27 @@
 package database
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"watson/budget"
+	"watson/importers"
+	"watson/money"
+	"watson/telemetry"
 
 	"github.com/lib/pq"
 	plaid "github.com/plaid/plaid-go/v31/plaid"
@@ -20,6 +35,8 @@ type DBUser struct {
 	UserID   int    `json:"user_id"`
 	Email    string `json:"email"`
 	Password string `json:"-"` // Don't expose password in JSON
+	// SessionVersion is carried in the user's JWTs; tokens with an older version have been signed out
+	SessionVersion int `json:"-"`
 }
 
 // Transaction represents a transaction in the database
@@ -34,8 +51,39 @@ type Transaction struct {
 	Status          string    `json:"status"`
 	Type            string    `json:"type"`
 	ProviderType    string    `json:"provider_type"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	// Plaid personal finance category, preferred over the legacy Category array when present
+	PersonalFinanceCategoryPrimary  string `json:"personal_finance_category_primary"`
+	PersonalFinanceCategoryDetailed string `json:"personal_finance_category_detailed"`
+	// IsBusiness marks a business expense, for exporting to the user's accountant
+	IsBusiness bool `json:"is_business"`
+	// Location is where the purchase was made, nil when the provider didn't say
+	Location  *TransactionLocation `json:"location"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+// TransactionLocation is where an in store purchase was made, as far as the provider knows
+type TransactionLocation struct {
+	City    *string  `json:"city"`
+	Region  *string  `json:"region"`
+	Country *string  `json:"country"`
+	Lat     *float64 `json:"lat"`
+	Lon     *float64 `json:"lon"`
+}
+
+// transactionLocationColumns are the columns scanned by TransactionLocation.dest
+const transactionLocationColumns = "location_city, location_region, location_country, location_lat, location_lon"
+
+func (location *TransactionLocation) dest() []interface{} {
+	return []interface{}{&location.City, &location.Region, &location.Country, &location.Lat, &location.Lon}
+}
+
+// orNil returns the location, or nil when none of it is known
+func (location TransactionLocation) orNil() *TransactionLocation {
+	if location == (TransactionLocation{}) {
+		return nil
+	}
+	return &location
 }
 
 type TellerInstitution struct {
@@ -59,59 +107,101 @@ type TellerPayload struct {
 		} `json:"institution"`
 	} `json:"enrollment"`
 	Signatures []string `json:"signatures"`
+	// Nonce is the one Teller Connect was started with, issued with the bank link and covered by the signatures
+	Nonce string `json:"nonce"`
 }
 
 type MonthlySummary struct {
-	ID                     int       `json:"id"`
-	UserID                 int       `json:"user_id"`
-	MonthYear              int       `json:"monthyear"`
-	TotalSpent             float64   `json:"total_spent"`
-	FixedExpenses          float64   `json:"fixed_expenses"`
-	SavingTargetPercentage float64   `json:"saving_target_percentage"`
-	StartingBalance        float64   `json:"starting_balance"`
-	Income                 float64   `json:"income"`
-	SavedAmount            float64   `json:"saved_amount"`
-	Invested               float64   `json:"invested"`
-	CreatedAt              time.Time `json:"created_at"`
-	UpdatedAt              time.Time `json:"updated_at"`
+	ID                     int         `json:"id"`
+	UserID                 int         `json:"user_id"`
+	MonthYear              int         `json:"monthyear"`
+	TotalSpent             money.Money `json:"total_spent"`
+	FixedExpenses          money.Money `json:"fixed_expenses"`
+	SavingTargetPercentage float64     `json:"saving_target_percentage"`
+	StartingBalance        money.Money `json:"starting_balance"`
+	Income                 money.Money `json:"income"`
+	SavedAmount            money.Money `json:"saved_amount"`
+	Invested               money.Money `json:"invested"`
+	BudgetPeriod           string      `json:"budget_period"`
+	PeriodAnchorDate       *time.Time  `json:"period_anchor_date"`
+	BudgetStartDate        *time.Time  `json:"budget_start_date"`
+	CreatedAt              time.Time   `json:"created_at"`
+	UpdatedAt              time.Time   `json:"updated_at"`
 }
 
 type MonthlyBudgetSpendCategory struct {
-	ID               string    `json:"id"`
-	UserID           int       `json:"user_id"`
-	MonthlySummaryID int       `json:"monthly_summary_id"`
-	MonthYear        int       `json:"monthyear"`
-	Category         string    `json:"category"`
-	Budget           float64   `json:"budget"`
-	DailyAllowance   float64   `json:"daily_allowance"`
-	TotalSpent       float64   `json:"total_spent"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               string      `json:"id"`
+	UserID           int         `json:"user_id"`
+	MonthlySummaryID int         `json:"monthly_summary_id"`
+	MonthYear        int         `json:"monthyear"`
+	Category         string      `json:"category"`
+	Budget           money.Money `json:"budget"`
+	// Strictness is budget.StrictnessSoft or budget.StrictnessHard
+	Strictness     string      `json:"strictness"`
+	DailyAllowance money.Money `json:"daily_allowance"`
+	TotalSpent     money.Money `json:"total_spent"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
 }
 
 // Monthly Balance
 type MonthlyBalance struct {
-	ID               int       `json:"id"`
-	UserID           int       `json:"user_id"`
-	MonthYear        int       `json:"monthyear"`
-	TotalOwing       float64   `json:"total_owing"`
-	NetCash          float64   `json:"net_cash"`
-	AvailableBalance float64   `json:"available_balance"`
-	CurrentBalance   float64   `json:"current_balance"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               int         `json:"id"`
+	UserID           int         `json:"user_id"`
+	MonthYear        int         `json:"monthyear"`
+	TotalOwing       money.Money `json:"total_owing"`
+	NetCash          money.Money `json:"net_cash"`
+	AvailableBalance money.Money `json:"available_balance"`
+	CurrentBalance   money.Money `json:"current_balance"`
+	// ManualFields are the fields the user set by hand, which ComputeMonthlyBalance leaves alone
+	ManualFields []string   `json:"manual_fields"`
+	ComputedAt   *time.Time `json:"computed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 }
 
 // Saving Goals
 type SavingsGoal struct {
-	ID           int       `json:"id"`
-	UserID       int       `json:"user_id"`
-	Name         string    `json:"name"`
-	TotalAmount  float64   `json:"total_amount"`
-	Redeemed     bool      `json:"redeemed"`
-	CurrentSaved float64   `json:"current_saved"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	Name         string     `json:"name"`
+	TotalAmount  float64    `json:"total_amount"`
+	Redeemed     bool       `json:"redeemed"`
+	CurrentSaved float64    `json:"current_saved"`
+	TargetDate   *time.Time `json:"target_date"`
+	// Computed by the recalculate_saving_goals job from recent contributions
+	MonthlyPace                 float64    `json:"monthly_pace"`
+	RequiredMonthlyContribution *float64   `json:"required_monthly_contribution"`
+	ProjectedCompletionDate     *time.Time `json:"projected_completion_date"`
+	CalculatedAt                *time.Time `json:"calculated_at"`
+	ArchivedAt                  *time.Time `json:"archived_at"`
+	// IsEmergencyFund counts the goal's savings towards the user's emergency fund
+	IsEmergencyFund bool      `json:"is_emergency_fund"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SavingsGoalContribution is a single deposit towards a savings goal
+type SavingsGoalContribution struct {
+	ID            int         `json:"id"`
+	SavingGoalID  int         `json:"saving_goal_id"`
+	UserID        int         `json:"user_id"`
+	Amount        money.Money `json:"amount"`
+	ContributedAt time.Time   `json:"contributed_at"`
+}
+
+// PlaidBackfillProgress tracks how far a historical backfill has walked back for an account
+type PlaidBackfillProgress struct {
+	ID              int       `json:"id"`
+	UserID          int       `json:"user_id"`
+	PlaidAccountID  string    `json:"plaid_account_id"`
+	MonthsRequested int       `json:"months_requested"`
+	MonthsCompleted int       `json:"months_completed"`
+	NextOffset      int       `json:"next_offset"`
+	Status          string    `json:"status"`
+	LastError       string    `json:"last_error"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 // InitDB initializes the database connection
@@ -119,7 +209,8 @@ func InitDB(connStr string) error {
 	log.Printf("Connecting to database: %s", connStr)
 
 	var err error
-	DB, err = sql.Open("postgres", connStr)
+	// Queries are traced; spans join the current trace when run with a context
+	DB, err = telemetry.OpenDB("postgres", connStr)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
@@ -143,14 +234,108 @@ func CloseDB() {
 	if DB != nil {
 		DB.Close()
 	}
+	if ReadDB != nil {
+		ReadDB.Close()
+	}
+}
+
+// ReadDB is an optional read replica; nil when none is configured. Only listings, analytics and report reads
+// go to it: anything that reads back a row the same request just wrote stays on the primary to avoid replica lag.
+var ReadDB *sql.DB
+
+var readReplicaHealthy atomic.Bool
+
+const readReplicaCheckInterval = 15 * time.Second
+
+// InitReadReplica connects to a read replica. Reads routed to it fall back to the primary while it is
+// unreachable, so a replica outage only costs the primary extra load.
+func InitReadReplica(connStr string) error {
+	var err error
+	ReadDB, err = telemetry.OpenDB("postgres", connStr)
+	if err != nil {
+		return fmt.Errorf("failed to open read replica: %v", err)
+	}
+	ReadDB.SetMaxOpenConns(25)
+	ReadDB.SetMaxIdleConns(25)
+	ReadDB.SetConnMaxLifetime(5 * time.Minute)
+
+	healthy := pingReadReplica()
+	readReplicaHealthy.Store(healthy)
+	if healthy {
+		log.Println("Read replica connection established successfully")
+	} else {
+		log.Println("Read replica unreachable, reading from primary until it recovers")
+	}
+	go monitorReadReplica()
+	return nil
+}
+
+func pingReadReplica() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return ReadDB.PingContext(ctx) == nil
+}
+
+// monitorReadReplica keeps readReplicaHealthy current so reads move back to the replica once it recovers
+func monitorReadReplica() {
+	ticker := time.NewTicker(readReplicaCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		healthy := pingReadReplica()
+		if healthy != readReplicaHealthy.Swap(healthy) {
+			if healthy {
+				log.Println("Read replica reachable again, routing reads to it")
+			} else {
+				log.Println("Read replica unreachable, reading from primary until it recovers")
+			}
+		}
+	}
+}
+
+// readDB returns the connection read-only queries should use
+func readDB() *sql.DB {
+	if ReadDB != nil && readReplicaHealthy.Load() {
+		return ReadDB
+	}
+	return DB
+}
+
+// readQuery runs a read-only query on the replica, retrying on the primary when the replica can't be reached.
+// Errors reported by Postgres itself are returned as is since the primary would fail the same way.
+func readQuery(query string, args ...interface{}) (*sql.Rows, error) {
+	db := readDB()
+	rows, err := db.Query(query, args...)
+	if err != nil && db != DB {
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) {
+			log.Printf("Read replica query failed, falling back to primary: %v", err)
+			readReplicaHealthy.Store(false)
+			return DB.Query(query, args...)
+		}
+	}
+	return rows, err
+}
+
+// readQueryRow runs a single row read-only query on the replica while it is healthy
+func readQueryRow(query string, args ...interface{}) *sql.Row {
+	return readDB().QueryRow(query, args...)
 }
 
-// CreateUser creates a new user in the database
+// CreateUser creates a new user in the database, returning ErrEmailTaken when a user already registered the email
+// in any case
 func CreateUser(email, password string) (*DBUser, error) {
 	var user DBUser
-	query := "INSERT INTO users (email, password) VALUES ($1, $2) RETURNING user_id, email"
+	query := `
+		INSERT INTO users (email, password)
+		SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))
+		RETURNING user_id, email, session_version
+	`
 
-	err := DB.QueryRow(query, email, password).Scan(&user.UserID, &user.Email)
+	err := DB.QueryRow(query, email, password).Scan(&user.UserID, &user.Email, &user.SessionVersion)
+	var pqErr *pq.Error
+	if err == sql.ErrNoRows || (errors.As(err, &pqErr) && pqErr.Code == "23505") {
+		return nil, ErrEmailTaken
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
@@ -160,9 +345,9 @@ func CreateUser(email, password string) (*DBUser, error) {
 
 func GetUserByEmailAndPassword(email, password string) (*DBUser, error) {
 	var user DBUser
-	query := "SELECT user_id, email, password FROM users WHERE email = $1 AND password = $2"
+	query := "SELECT user_id, email, password, session_version FROM users WHERE LOWER(email) = LOWER($1) AND password = $2"
 
-	err := DB.QueryRow(query, email, password).Scan(&user.UserID, &user.Email, &user.Password)
+	err := DB.QueryRow(query, email, password).Scan(&user.UserID, &user.Email, &user.Password, &user.SessionVersion)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -173,12 +358,12 @@ func GetUserByEmailAndPassword(email, password string) (*DBUser, error) {
 	return &user, nil
 }
 
-// GetUserByEmail retrieves a user by email
+// GetUserByEmail retrieves a user by email, ignoring case
 func GetUserByEmail(email string) (*DBUser, error) {
 	var user DBUser
-	query := "SELECT user_id, email, password FROM users WHERE email = $1"
+	query := "SELECT user_id, email, password, session_version FROM users WHERE LOWER(email) = LOWER($1)"
 
-	err := DB.QueryRow(query, email).Scan(&user.UserID, &user.Email, &user.Password)
+	err := DB.QueryRow(query, email).Scan(&user.UserID, &user.Email, &user.Password, &user.SessionVersion)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -192,9 +377,9 @@ func GetUserByEmail(email string) (*DBUser, error) {
 // GetUserByID retrieves a user by ID
 func GetUserByID(userID int) (*DBUser, error) {
 	var user DBUser
-	query := "SELECT user_id, email, password FROM users WHERE user_id = $1"
+	query := "SELECT user_id, email, password, session_version FROM users WHERE user_id = $1"
 
-	err := DB.QueryRow(query, userID).Scan(&user.UserID, &user.Email, &user.Password)
+	err := ScopeToUser(userID).QueryRow(query).Scan(&user.UserID, &user.Email, &user.Password, &user.SessionVersion)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -205,20 +390,108 @@ func GetUserByID(userID int) (*DBUser, error) {
 	return &user, nil
 }
 
-func CreateTellerInstitution(userID int, name string, tellerID string, accessToken string) (*TellerInstitution, error) {
+// ErrEmailTaken is returned when registering or changing to an email another user already registered with
+var ErrEmailTaken = errors.New("email is already registered")
+
+// GetSessionVersion returns the session version the user's JWTs must carry
+func GetSessionVersion(userID int) (int, error) {
+	var version int
+	err := ScopeToUser(userID).QueryRow("SELECT session_version FROM users WHERE user_id = $1").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get session version: %v", err)
+	}
+	return version, nil
+}
+
+// ChangePassword replaces the user's password if currentPassword matches, signing out their other sessions and
+// recording the change in the audit log. It returns nil when currentPassword is wrong.
+func ChangePassword(userID int, currentPassword string, newPassword string, request AuditRequest) (*DBUser, error) {
+	query := `
+		UPDATE users SET password = $3, session_version = session_version + 1
+		WHERE user_id = $1 AND password = $2
+		RETURNING user_id, email, password, session_version
+	`
+	return changeCredentials(userID, AuditPasswordChanged, nil, request, query, currentPassword, newPassword)
+}
+
+// ChangeEmail replaces the user's email if currentPassword matches, signing out their other sessions and
+// recording the change in the audit log. It returns nil when currentPassword is wrong and ErrEmailTaken when
+// another user has the email.
+func ChangeEmail(userID int, currentPassword string, newEmail string, request AuditRequest) (*DBUser, error) {
+	var previousEmail string
+	if err := ScopeToUser(userID).QueryRow("SELECT email FROM users WHERE user_id = $1").Scan(&previousEmail); err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+	query := `
+		UPDATE users SET email = $3, session_version = session_version + 1
+		WHERE user_id = $1 AND password = $2
+		RETURNING user_id, email, password, session_version
+	`
+	details := map[string]interface{}{"previous_email": previousEmail, "new_email": newEmail}
+	return changeCredentials(userID, AuditEmailChanged, details, request, query, currentPassword, newEmail)
+}
+
+// changeCredentials runs a credential update and its audit log entry in one transaction
+func changeCredentials(userID int, action string, details map[string]interface{}, request AuditRequest, query string, args ...interface{}) (*DBUser, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var user DBUser
+	err = ScopeToUser(userID).InTx(tx).QueryRow(query, args...).Scan(&user.UserID, &user.Email, &user.Password, &user.SessionVersion)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to change credentials: %v", err)
+	}
+	if err := recordAuditEvent(tx, userID, action, details, request); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit credential change: %v", err)
+	}
+	return &user, nil
+}
+
+// UpsertTellerInstitution stores a Teller enrollment, or on relinking one already stored updates its row with the
+// new access token and makes it active again, so each enrollment has a single row
+func UpsertTellerInstitution(userID int, name string, tellerID string, accessToken string) (*TellerInstitution, error) {
 	var tellerInstitution TellerInstitution
-	query := "INSERT INTO teller_institutions (user_id, name, teller_id, access_token) VALUES ($1, $2, $3, $4) RETURNING id, user_id, name, teller_id, access_token"
-	err := DB.QueryRow(query, userID, name, tellerID, accessToken).Scan(&tellerInstitution.ID, &tellerInstitution.UserID, &tellerInstitution.Name, &tellerInstitution.TellerID, &tellerInstitution.AccessToken)
+	query := `
+		INSERT INTO teller_institutions (user_id, name, teller_id, access_token) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (teller_id) DO UPDATE SET
+			name = EXCLUDED.name,
+			access_token = EXCLUDED.access_token,
+			status = 'active',
+			auth_failed_at = NULL,
+			last_auth_error = NULL,
+			reauth_reminded_at = NULL,
+			reauth_reminder_count = 0,
+			deleted_at = NULL
+		WHERE teller_institutions.user_id = EXCLUDED.user_id
+		RETURNING id, user_id, name, teller_id, access_token
+	`
+	err := ScopeToUser(userID).QueryRow(query, name, tellerID, accessToken).Scan(&tellerInstitution.ID, &tellerInstitution.UserID, &tellerInstitution.Name, &tellerInstitution.TellerID, &tellerInstitution.AccessToken)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("teller enrollment %s is linked to another user", tellerID)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create teller institution: %v", err)
+		return nil, fmt.Errorf("failed to upsert teller institution: %v", err)
 	}
 	return &tellerInstitution, nil
 }
 
-// HandleTellerSuccess processes the Teller webhook payload and creates a TellerInstitution record
+// HandleTellerSuccess processes the Teller webhook payload and stores its TellerInstitution record, returning the
+// existing record when the enrollment was linked before
 func HandleTellerSuccess(userID int, payload TellerPayload) (*TellerInstitution, error) {
-	// Create the teller institution record
-	tellerInstitution, err := CreateTellerInstitution(
+	tellerInstitution, err := UpsertTellerInstitution(
 		userID,
 		payload.Enrollment.Institution.Name,
 		payload.Enrollment.ID, // Using enrollment ID as teller_id
@@ -240,7 +513,7 @@ func CreateTransaction(userID int, description string, amount float64, transacti
 		RETURNING transaction_id, user_id, description, amount, transaction_date, created_at, updated_at
 	`
 
-	err := DB.QueryRow(query, userID, description, amount, transactionDate).Scan(
+	err := ScopeToUser(userID).QueryRow(query, description, amount, transactionDate).Scan(
 		&transaction.TransactionID, &transaction.UserID, &transaction.Description,
 		&transaction.Amount, &transaction.TransactionDate, &transaction.CreatedAt, &transaction.UpdatedAt,
 	)
@@ -260,7 +533,7 @@ func GetTransactionsByUserID(userID int) ([]Transaction, error) {
 		ORDER BY transaction_date DESC, created_at DESC
 	`
 
-	rows, err := DB.Query(query, userID)
+	rows, err := ScopeToUser(userID).Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query transactions: %v", err)
 	}
@@ -286,16 +559,16 @@ func GetTransactionsByUserID(userID int) ([]Transaction, error) {
 	return transactions, nil
 }
 
-// GetTransactionByID retrieves a specific transaction
-func GetTransactionByID(transactionID int) (*Transaction, error) {
+// GetTransactionByID retrieves a specific transaction of the user
+func GetTransactionByID(userID int, transactionID int) (*Transaction, error) {
 	var transaction Transaction
 	query := `
 		SELECT transaction_id, user_id, description, amount, transaction_date, created_at, updated_at
 		FROM transactions 
-		WHERE transaction_id = $1
+		WHERE user_id = $1 AND transaction_id = $2
 	`
 
-	err := DB.QueryRow(query, transactionID).Scan(
+	err := ScopeToUser(userID).QueryRow(query, transactionID).Scan(
 		&transaction.TransactionID, &transaction.UserID, &transaction.Description,
 		&transaction.Amount, &transaction.TransactionDate, &transaction.CreatedAt, &transaction.UpdatedAt,
 	)
@@ -309,17 +582,17 @@ func GetTransactionByID(transactionID int) (*Transaction, error) {
 	return &transaction, nil
 }
 
-// UpdateTransaction updates an existing transaction
-func UpdateTransaction(transactionID int, description string, amount float64, transactionDate time.Time) (*Transaction, error) {
+// UpdateTransaction updates an existing transaction of the user
+func UpdateTransaction(userID int, transactionID int, description string, amount float64, transactionDate time.Time) (*Transaction, error) {
 	var transaction Transaction
 	query := `
 		UPDATE transactions 
-		SET description = $2, amount = $3, transaction_date = $4
-		WHERE transaction_id = $1
+		SET description = $3, amount = $4, transaction_date = $5
+		WHERE user_id = $1 AND transaction_id = $2
 		RETURNING transaction_id, user_id, description, amount, transaction_date, created_at, updated_at
 	`
 
-	err := DB.QueryRow(query, transactionID, description, amount, transactionDate).Scan(
+	err := ScopeToUser(userID).QueryRow(query, transactionID, description, amount, transactionDate).Scan(
 		&transaction.TransactionID, &transaction.UserID, &transaction.Description,
 		&transaction.Amount, &transaction.TransactionDate, &transaction.CreatedAt, &transaction.UpdatedAt,
 	)
@@ -333,11 +606,11 @@ func UpdateTransaction(transactionID int, description string, amount float64, tr
 	return &transaction, nil
 }
 
-// DeleteTransaction deletes a transaction
-func DeleteTransaction(transactionID int) error {
-	query := "DELETE FROM transactions WHERE transaction_id = $1"
+// DeleteTransaction deletes a transaction of the user
+func DeleteTransaction(userID int, transactionID int) error {
+	query := "DELETE FROM transactions WHERE user_id = $1 AND transaction_id = $2"
 
-	result, err := DB.Exec(query, transactionID)
+	result, err := ScopeToUser(userID).Exec(query, transactionID)
 	if err != nil {
 		return fmt.Errorf("failed to delete transaction: %v", err)
 	}
@@ -354,6 +627,21 @@ func DeleteTransaction(transactionID int) error {
 	return nil
 }
 
+// SetTransactionNotes replaces the notes on one of the user's transactions, clearing them when notes is empty. It
+// returns false when they have no such transaction.
+func SetTransactionNotes(userID int, transactionID string, notes string) (bool, error) {
+	result, err := ScopeToUser(userID).Exec("UPDATE transactions SET notes = NULLIF($3, '') WHERE user_id = $1 AND id::text = $2",
+		transactionID, notes)
+	if err != nil {
+		return false, fmt.Errorf("failed to set transaction notes: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected > 0, nil
+}
+
 // GetTransactionStats returns basic statistics for a user's transactions
 func GetTransactionStats(userID int) (map[string]interface{}, error) {
 	query := `
@@ -375,7 +663,7 @@ func GetTransactionStats(userID int) (map[string]interface{}, error) {
 		MaxAmount         float64 `db:"max_amount"`
 	}
 
-	err := DB.QueryRow(query, userID).Scan(
+	err := ScopeToUser(userID).ReadQueryRow(query).Scan(
 		&stats.TotalTransactions, &stats.TotalAmount, &stats.AverageAmount,
 		&stats.MinAmount, &stats.MaxAmount,
 	)
@@ -392,575 +680,9301 @@ func GetTransactionStats(userID int) (map[string]interface{}, error) {
 	}, nil
 }
 
-// ********** PLAID **********
+// ********** PROVIDER TRANSACTIONS **********
 
-func CreatePlaidToken(userID int, accessToken string, itemID string) error {
-	query := "INSERT INTO plaid_tokens (user_id, access_token, item_id) VALUES ($1, $2, $3)"
-	_, err := DB.Exec(query, userID, accessToken, itemID)
-	if err != nil {
-		return fmt.Errorf("failed to create plaid token: %v", err)
-	}
-	return nil
+// Providers transactions are synced from, as stored in transactions.provider_type
+const (
+	ProviderPlaid  = "plaid"
+	ProviderTeller = "teller"
+)
+
+// ProviderTransaction is what every provider reports about a transaction, in the columns all providers share.
+// Each save path adapts its provider's records into one, so rows read the same whichever provider they came from.
+type ProviderTransaction struct {
+	Provider              string
+	ProviderTransactionID string
+	// AccountRef is the provider's id for the account, a plaid_accounts or teller_accounts id
+	AccountRef string
+	Amount     money.Money
+	Merchant   string
+	Category   []string
 }
 
-func MarkPlaidAccountAsSynced(accountID string) error {
-	query := "UPDATE plaid_accounts SET is_processed = TRUE WHERE id = $1"
-	_, err := DB.Exec(query, accountID)
-	if err != nil {
-		return fmt.Errorf("failed to mark plaid account as synced: %v", err)
+// CategoryJSON returns the category as the JSON list stored in transactions.category
+func (t ProviderTransaction) CategoryJSON() string {
+	category := t.Category
+	if category == nil {
+		category = []string{}
 	}
-	log.Printf("Marked plaid account as synced: %s", accountID)
-	return nil
+	categoryJSON, _ := json.Marshal(category)
+	return string(categoryJSON)
 }
 
-func GetAccessTokenFromAccountID(accountId string) (string, error) {
-	query := "SELECT p.access_token FROM plaid_accounts as a JOIN plaid_tokens as p ON a.plaid_token_id = p.id WHERE a.id = $1"
-	var accessToken string
-	err := DB.QueryRow(query, accountId).Scan(&accessToken)
-	if err != nil {
-		return "", fmt.Errorf("failed to get access token from account id: %v", err)
+// NewPlaidProviderTransaction adapts a Plaid transaction on the account Plaid reports it on
+func NewPlaidProviderTransaction(transaction plaid.Transaction) ProviderTransaction {
+	return ProviderTransaction{
+		Provider:              ProviderPlaid,
+		ProviderTransactionID: transaction.GetTransactionId(),
+		AccountRef:            transaction.GetAccountId(),
+		Amount:                money.FromFloat(transaction.GetAmount()),
+		Merchant:              transaction.GetMerchantName(),
+		Category:              transaction.GetCategory(),
 	}
-	return accessToken, nil
 }
 
-func GetUserIdFromAccessToken(accessToken string) (string, int, error) {
-	query := "SELECT id, user_id, is_processed FROM plaid_tokens WHERE access_token = $1"
-	var plaidTokenID string
-	var userID int
-	var isProcessed bool
-	err := DB.QueryRow(query, accessToken).Scan(&plaidTokenID, &userID, &isProcessed)
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to get user id from access token: %v", err)
+// ********** ACCOUNT SETTINGS **********
+
+// AccountSettings are a user's settings for one linked account, keyed like transactions by provider and the
+// provider's account id
+type AccountSettings struct {
+	Provider  string `json:"provider"`
+	AccountID string `json:"account_id"`
+	// ExcludeFromBudget leaves the account's transactions out of spend, for a business card or a partner's account
+	ExcludeFromBudget bool `json:"exclude_from_budget"`
+	// IsInvestment marks a brokerage account, so transfers into it count as investment contributions. Plaid
+	// investment accounts are treated as brokerage accounts without it.
+	IsInvestment bool `json:"is_investment"`
+	// Nickname, Color and Icon change how the account is shown; nil shows the bank's name and the default style
+	Nickname *string `json:"nickname"`
+	Color    *string `json:"color"`
+	Icon     *string `json:"icon"`
+	// Hidden leaves the account out of the accounts list and net worth, for a closed card
+	Hidden bool `json:"hidden"`
+	// IsEmergencyFund counts the account's balance towards the user's emergency fund
+	IsEmergencyFund bool `json:"is_emergency_fund"`
+}
+
+// AccountSettingsUpdate changes the settings that are set, leaving the rest as they were. An empty Nickname,
+// Color or Icon clears it.
+type AccountSettingsUpdate struct {
+	ExcludeFromBudget *bool
+	IsInvestment      *bool
+	Nickname          *string
+	Color             *string
+	Icon              *string
+	Hidden            *bool
+	IsEmergencyFund   *bool
+}
+
+// budgetedTransactionFilter leaves out transfers between the user's own accounts, transactions flagged for review
+// and transactions on accounts excluded from budgeting. daily_category_spend never counts them, so it is only
+// needed by queries over the transactions themselves.
+const budgetedTransactionFilter = `
+	AND NOT transactions.is_transfer
+	AND NOT transactions.is_flagged
+	AND NOT account_excluded_from_budget(transactions.user_id, transactions.provider_type, transactions.account_ref)`
+
+// UpsertAccountSettings saves the settings of one of the user's linked accounts, returning nil when no such
+// account is linked to the user. Excluding or including an account moves its transactions out of or back into
+// the daily spend aggregates.
+func UpsertAccountSettings(userID int, provider string, accountID string, update AccountSettingsUpdate) (*AccountSettings, error) {
+	query := `
+		INSERT INTO account_settings (user_id, provider_type, account_ref, exclude_from_budget, is_investment, nickname, color, icon, hidden, is_emergency_fund)
+		SELECT $1, $2, $3, COALESCE($4, FALSE), COALESCE($5, FALSE), NULLIF($6::varchar, ''), NULLIF($7::varchar, ''), NULLIF($8::varchar, ''), COALESCE($9, FALSE), COALESCE($10, FALSE)
+		WHERE EXISTS (
+			SELECT 1 FROM plaid_accounts WHERE $2 = 'plaid' AND user_id = $1 AND id = $3
+			UNION ALL
+			SELECT 1 FROM teller_accounts WHERE $2 = 'teller' AND user_id = $1 AND id::text = $3 AND deleted_at IS NULL
+		)
+		ON CONFLICT (user_id, provider_type, account_ref) DO UPDATE SET
+			exclude_from_budget = COALESCE($4, account_settings.exclude_from_budget),
+			is_investment = COALESCE($5, account_settings.is_investment),
+			nickname = CASE WHEN $6::varchar IS NULL THEN account_settings.nickname ELSE NULLIF($6::varchar, '') END,
+			color = CASE WHEN $7::varchar IS NULL THEN account_settings.color ELSE NULLIF($7::varchar, '') END,
+			icon = CASE WHEN $8::varchar IS NULL THEN account_settings.icon ELSE NULLIF($8::varchar, '') END,
+			hidden = COALESCE($9, account_settings.hidden),
+			is_emergency_fund = COALESCE($10, account_settings.is_emergency_fund)
+		RETURNING provider_type, account_ref, exclude_from_budget, is_investment, nickname, color, icon, hidden, is_emergency_fund
+	`
+	var settings AccountSettings
+	err := ScopeToUser(userID).QueryRow(query, provider, accountID, update.ExcludeFromBudget, update.IsInvestment, update.Nickname, update.Color, update.Icon, update.Hidden, update.IsEmergencyFund).Scan(
+		&settings.Provider, &settings.AccountID, &settings.ExcludeFromBudget, &settings.IsInvestment, &settings.Nickname, &settings.Color, &settings.Icon, &settings.Hidden, &settings.IsEmergencyFund)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	if isProcessed {
-		return "", 0, fmt.Errorf("access token already processed")
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert account settings: %v", err)
 	}
-	return plaidTokenID, userID, nil
+	return &settings, nil
 }
 
-func MarkPlaidTokenAsProcessed(plaidTokenID string) error {
-	query := "UPDATE plaid_tokens SET is_processed = TRUE WHERE id = $1"
-	_, err := DB.Exec(query, plaidTokenID)
+// GetAccountProvider returns which provider the user's account with this id is linked through, or "" when they
+// have no such account
+func GetAccountProvider(userID int, accountID string) (string, error) {
+	query := `
+		SELECT 'plaid' FROM plaid_accounts WHERE user_id = $1 AND id = $2
+		UNION ALL
+		SELECT 'teller' FROM teller_accounts WHERE user_id = $1 AND id::text = $2 AND deleted_at IS NULL
+		LIMIT 1
+	`
+	var provider string
+	err := ScopeToUser(userID).QueryRow(query, accountID).Scan(&provider)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to mark plaid token as processed: %v", err)
+		return "", fmt.Errorf("failed to get account provider: %v", err)
 	}
-	return nil
+	return provider, nil
 }
 
-func GetAllAccountsSynced(userID int) (bool, error) {
-	query := "SELECT COUNT(*) FROM plaid_accounts WHERE user_id = $1 AND is_processed = FALSE"
-	var count int
-	err := DB.QueryRow(query, userID).Scan(&count)
+// ********** TRANSFERS **********
+
+const (
+	TransferMatchConfirmed     = "confirmed"
+	TransferMatchPendingReview = "pending_review"
+	TransferMatchRejected      = "rejected"
+)
+
+// transferMatchWindowDays is how many days apart the two sides of a transfer may be dated, as each bank posts
+// its side on its own schedule
+const transferMatchWindowDays = 3
+
+// TransferMatch pairs an outflow on one of the user's accounts with an equal inflow on another
+type TransferMatch struct {
+	ID         int          `json:"id"`
+	Status     string       `json:"status"`
+	Outflow    TransferSide `json:"outflow"`
+	Inflow     TransferSide `json:"inflow"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ReviewedAt *time.Time   `json:"reviewed_at"`
+}
+
+// TransferSide is one of the two transactions of a transfer match
+type TransferSide struct {
+	TransactionID string      `json:"transaction_id"`
+	Provider      string      `json:"provider"`
+	AccountID     string      `json:"account_id"`
+	Description   string      `json:"description"`
+	Amount        money.Money `json:"amount"`
+	Date          time.Time   `json:"date"`
+}
+
+// TransferMatchResult counts the matches one MatchTransfers run recorded
+type TransferMatchResult struct {
+	Confirmed     int `json:"confirmed"`
+	PendingReview int `json:"pending_review"`
+}
+
+// MatchTransfers pairs the user's transactions dated since `since` with equal and opposite transactions on their
+// other accounts within transferMatchWindowDays. A pair whose sides have no other candidates is confirmed and both
+// are marked as transfers, leaving them out of spend. Pairs with competing candidates are left pending for the user
+// to review. Transactions already in a confirmed or pending match, and pairs the user rejected, are skipped.
+func MatchTransfers(ctx context.Context, userID int, since time.Time) (TransferMatchResult, error) {
+	query := `
+		WITH candidates AS (
+			SELECT o.id AS outflow_id, i.id AS inflow_id
+			FROM transactions o
+			JOIN transactions i ON i.user_id = o.user_id AND i.amount = -o.amount AND i.account_ref <> o.account_ref
+				AND i.date BETWEEN o.date - $3::int AND o.date + $3::int
+			WHERE o.user_id = $1 AND o.date >= $2 AND o.amount > 0 AND NOT o.is_transfer AND NOT i.is_transfer
+				AND NOT EXISTS (
+					SELECT 1 FROM transfer_matches m
+					WHERE (m.outflow_transaction_id = o.id AND m.inflow_transaction_id = i.id)
+						OR (m.status <> 'rejected' AND (m.outflow_transaction_id IN (o.id, i.id) OR m.inflow_transaction_id IN (o.id, i.id)))
+				)
+		),
+		ranked AS (
+			SELECT outflow_id, inflow_id,
+				COUNT(*) OVER (PARTITION BY outflow_id) AS outflow_candidates,
+				COUNT(*) OVER (PARTITION BY inflow_id) AS inflow_candidates
+			FROM candidates
+		),
+		inserted AS (
+			INSERT INTO transfer_matches (user_id, outflow_transaction_id, inflow_transaction_id, status)
+			SELECT $1, outflow_id, inflow_id,
+				CASE WHEN outflow_candidates = 1 AND inflow_candidates = 1 THEN 'confirmed' ELSE 'pending_review' END
+			FROM ranked
+			ON CONFLICT (outflow_transaction_id, inflow_transaction_id) DO NOTHING
+			RETURNING status, outflow_transaction_id, inflow_transaction_id
+		),
+		marked AS (
+			UPDATE transactions t SET is_transfer = TRUE
+			FROM inserted
+			WHERE t.user_id = $1 AND inserted.status = 'confirmed' AND t.id IN (inserted.outflow_transaction_id, inserted.inflow_transaction_id)
+			RETURNING t.id
+		)
+		SELECT COUNT(*) FILTER (WHERE status = 'confirmed'), COUNT(*) FILTER (WHERE status = 'pending_review') FROM inserted
+	`
+	var result TransferMatchResult
+	err := ScopeToUser(userID).QueryRowContext(ctx, query, since, transferMatchWindowDays).Scan(&result.Confirmed, &result.PendingReview)
 	if err != nil {
-		return false, fmt.Errorf("failed to get all accounts synced: %v", err)
+		return TransferMatchResult{}, fmt.Errorf("failed to match transfers: %v", err)
 	}
-	return count == 0, nil
+	return result, nil
 }
 
-func GetPlaidAccountsByUserID(userID int) ([]string, error) {
-	query := "SELECT id FROM plaid_accounts WHERE user_id = $1"
-	rows, err := DB.Query(query, userID)
+const transferMatchSelect = `
+	SELECT m.id, m.status, m.created_at, m.reviewed_at,
+		o.id, o.provider_type, COALESCE(o.account_ref, ''), COALESCE(o.description, ''), o.amount, o.date,
+		i.id, i.provider_type, COALESCE(i.account_ref, ''), COALESCE(i.description, ''), i.amount, i.date
+	FROM transfer_matches m
+	JOIN transactions o ON o.id = m.outflow_transaction_id
+	JOIN transactions i ON i.id = m.inflow_transaction_id`
+
+func scanTransferMatch(row interface{ Scan(...interface{}) error }) (TransferMatch, error) {
+	var match TransferMatch
+	err := row.Scan(&match.ID, &match.Status, &match.CreatedAt, &match.ReviewedAt,
+		&match.Outflow.TransactionID, &match.Outflow.Provider, &match.Outflow.AccountID, &match.Outflow.Description, &match.Outflow.Amount, &match.Outflow.Date,
+		&match.Inflow.TransactionID, &match.Inflow.Provider, &match.Inflow.AccountID, &match.Inflow.Description, &match.Inflow.Amount, &match.Inflow.Date)
+	return match, err
+}
+
+// ListTransferMatches returns the user's transfer matches with the given status, most recent transfers first
+func ListTransferMatches(userID int, status string) ([]TransferMatch, error) {
+	query := transferMatchSelect + " WHERE m.user_id = $1 AND m.status = $2 ORDER BY o.date DESC, m.id DESC"
+	rows, err := ScopeToUser(userID).ReadQuery(query, status)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get plaid accounts by user id: %v", err)
+		return nil, fmt.Errorf("failed to query transfer matches: %v", err)
 	}
 	defer rows.Close()
-	var accounts []string
+	matches := []TransferMatch{}
 	for rows.Next() {
-		var accountID string
-		err := rows.Scan(&accountID)
+		match, err := scanTransferMatch(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan plaid account: %v", err)
+			return nil, fmt.Errorf("failed to scan transfer match: %v", err)
 		}
-		accounts = append(accounts, accountID)
+		matches = append(matches, match)
 	}
-	return accounts, nil
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transfer matches: %v", err)
+	}
+	return matches, nil
 }
 
-func CreatePlaidAccount(userID int, plaidTokenID string, accounts []plaid.AccountBase) error {
-	if len(accounts) == 0 {
-		return nil
+// ReviewTransferMatch confirms or rejects one of the user's transfer matches, returning nil when there is no such
+// match or it was already rejected. Confirming marks both transactions as transfers and rejects the other pending
+// matches either one was in; rejecting a confirmed match counts both as spending again.
+func ReviewTransferMatch(userID int, matchID int, confirm bool) (*TransferMatch, error) {
+	status := TransferMatchRejected
+	if confirm {
+		status = TransferMatchConfirmed
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	// Build bulk insert query
-	query := "INSERT INTO plaid_accounts (id, user_id, plaid_token_id, available_balance, current_balance, currency, account_name, official_name, account_type, account_subtype) VALUES "
+	scope := ScopeToUser(userID).InTx(tx)
+	var outflowID, inflowID string
+	err = scope.QueryRow(`
+		UPDATE transfer_matches SET status = $3, reviewed_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id = $2 AND status <> 'rejected'
+		RETURNING outflow_transaction_id, inflow_transaction_id
+	`, matchID, status).Scan(&outflowID, &inflowID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to review transfer match: %v", err)
+	}
+	if _, err := scope.Exec("UPDATE transactions SET is_transfer = $4 WHERE user_id = $1 AND id IN ($2, $3) AND is_transfer <> $4", outflowID, inflowID, confirm); err != nil {
+		return nil, fmt.Errorf("failed to mark transfer transactions: %v", err)
+	}
+	if confirm {
+		_, err := scope.Exec(`
+			UPDATE transfer_matches SET status = 'rejected', reviewed_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND id <> $2 AND status = 'pending_review'
+				AND (outflow_transaction_id IN ($3, $4) OR inflow_transaction_id IN ($3, $4))
+		`, matchID, outflowID, inflowID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reject competing transfer matches: %v", err)
+		}
+	}
+	match, err := scanTransferMatch(scope.QueryRow(transferMatchSelect+" WHERE m.user_id = $1 AND m.id = $2", matchID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transfer match: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transfer review: %v", err)
+	}
+	return &match, nil
+}
 
-	values := make([]interface{}, 0, len(accounts)*10)
-	placeholders := make([]string, 0, len(accounts))
+// ********** TRANSACTION FLAGS **********
 
-	for i, account := range accounts {
-		// Create placeholder string for this account
-		start := i * 10
-		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
-			start+1, start+2, start+3, start+4, start+5, start+6, start+7, start+8, start+9, start+10))
+const (
+	TransactionFlagFraud     = "fraud"
+	TransactionFlagIncorrect = "incorrect"
 
-		// Extract values from Plaid account
-		var availableBalance, currentBalance float64
-		if account.Balances.Available.Get() != nil {
-			availableBalance = *account.Balances.Available.Get()
-		}
-		if account.Balances.Current.Get() != nil {
-			currentBalance = *account.Balances.Current.Get()
-		}
+	TransactionFlagOpen     = "flagged"
+	TransactionFlagResolved = "resolved"
 
-		values = append(values,
-			account.GetAccountId(),
-			userID,
-			plaidTokenID,
-			availableBalance,
-			currentBalance,
-			account.Balances.GetIsoCurrencyCode(),
-			account.GetName(),
-			account.GetOfficialName(),
-			string(account.GetType()),
-			string(account.GetSubtype()),
-		)
-	}
+	// TransactionFlagValid resolves a flag by counting the transaction again; TransactionFlagInvalid keeps it out
+	TransactionFlagValid   = "valid"
+	TransactionFlagInvalid = "invalid"
+)
 
-	query += strings.Join(placeholders, ", ")
-	query += " ON CONFLICT (id) DO UPDATE SET " +
-		"user_id = EXCLUDED.user_id, " +
-		"plaid_token_id = EXCLUDED.plaid_token_id, " +
-		"available_balance = EXCLUDED.available_balance, " +
-		"current_balance = EXCLUDED.current_balance, " +
-		"currency = EXCLUDED.currency, " +
-		"account_name = EXCLUDED.account_name, " +
-		"official_name = EXCLUDED.official_name, " +
-		"account_type = EXCLUDED.account_type, " +
-		"account_subtype = EXCLUDED.account_subtype"
+// TransactionFlag is a transaction the user marked as suspected fraud or incorrect. It is left out of spend while
+// flagged, and after being resolved as invalid.
+type TransactionFlag struct {
+	TransactionID string      `json:"transaction_id"`
+	Reason        string      `json:"reason"`
+	Note          *string     `json:"note"`
+	Status        string      `json:"status"`
+	Resolution    *string     `json:"resolution"`
+	Provider      string      `json:"provider"`
+	AccountID     string      `json:"account_id"`
+	Description   string      `json:"description"`
+	Amount        money.Money `json:"amount"`
+	Date          time.Time   `json:"date"`
+	CreatedAt     time.Time   `json:"created_at"`
+	ResolvedAt    *time.Time  `json:"resolved_at"`
+}
 
-	_, err := DB.Exec(query, values...)
-	if err != nil {
-		return fmt.Errorf("failed to upsert plaid accounts: %v", err)
-	}
+const transactionFlagSelect = `
+	SELECT f.transaction_id, f.reason, f.note, f.status, f.resolution,
+		t.provider_type, COALESCE(t.account_ref, ''), COALESCE(t.description, ''), t.amount, t.date, f.created_at, f.resolved_at
+	FROM transaction_flags f
+	JOIN transactions t ON t.id = f.transaction_id`
 
-	return nil
+func scanTransactionFlag(row interface{ Scan(...interface{}) error }) (TransactionFlag, error) {
+	var flag TransactionFlag
+	err := row.Scan(&flag.TransactionID, &flag.Reason, &flag.Note, &flag.Status, &flag.Resolution,
+		&flag.Provider, &flag.AccountID, &flag.Description, &flag.Amount, &flag.Date, &flag.CreatedAt, &flag.ResolvedAt)
+	return flag, err
 }
 
-func CreatePlaidTransactions(userID int, accountID string, transactions []plaid.Transaction) error {
-	if len(transactions) == 0 {
-		return nil
+// FlagTransaction flags one of the user's transactions, leaving it out of spend until the flag is resolved. It
+// returns nil when the user has no such transaction. Flagging a transaction again reopens its flag.
+func FlagTransaction(userID int, transactionID string, reason string, note *string) (*TransactionFlag, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	// Build bulk insert query
-	query := "INSERT INTO transactions (user_id, plaid_account_id, plaid_transaction_id, amount, date, description, category, currency, status, type, provider_type) VALUES "
-
-	values := make([]interface{}, 0, len(transactions)*11)
-	placeholders := make([]string, 0, len(transactions))
+	scope := ScopeToUser(userID).InTx(tx)
+	var id string
+	err = scope.QueryRow("UPDATE transactions SET is_flagged = TRUE WHERE user_id = $1 AND id::text = $2 RETURNING id",
+		transactionID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to flag transaction: %v", err)
+	}
+	_, err = scope.Exec(`
+		INSERT INTO transaction_flags (user_id, transaction_id, reason, note)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (transaction_id) DO UPDATE SET reason = EXCLUDED.reason, note = EXCLUDED.note, status = 'flagged',
+			resolution = NULL, created_at = CURRENT_TIMESTAMP, resolved_at = NULL
+	`, id, reason, note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save transaction flag: %v", err)
+	}
+	flag, err := scanTransactionFlag(scope.QueryRow(transactionFlagSelect+" WHERE f.user_id = $1 AND f.transaction_id = $2", id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction flag: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction flag: %v", err)
+	}
+	return &flag, nil
+}
 
-	for i, transaction := range transactions {
-		start := i * 11
-		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
-			start+1, start+2, start+3, start+4, start+5, start+6, start+7, start+8, start+9, start+10, start+11))
-
-		// Handle category array - convert to JSONB format
-		categorySlice := transaction.GetCategory()
-		var category interface{}
-		if len(categorySlice) == 0 {
-			category = "[]" // Empty array as JSON string
-		} else {
-			// Convert slice to JSON string for JSONB insertion
-			categoryJSON, err := json.Marshal(categorySlice)
-			if err != nil {
-				return fmt.Errorf("failed to marshal category: %v", err)
+// ListTransactionFlags returns the user's transaction flags with the given status, most recent transactions first
+func ListTransactionFlags(userID int, status string) ([]TransactionFlag, error) {
+	query := transactionFlagSelect + " WHERE f.user_id = $1 AND f.status = $2 ORDER BY t.date DESC, f.created_at DESC"
+	rows, err := ScopeToUser(userID).ReadQuery(query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transaction flags: %v", err)
+	}
+	defer rows.Close()
+	flags := []TransactionFlag{}
+	for rows.Next() {
+		flag, err := scanTransactionFlag(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction flag: %v", err)
+		}
+		flags = append(flags, flag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction flags: %v", err)
+	}
+	return flags, nil
+}
+
+// ResolveTransactionFlag resolves the open flag on one of the user's transactions, returning nil when there is no
+// such flag. A transaction resolved as valid counts as spend again; one resolved as invalid stays left out.
+func ResolveTransactionFlag(userID int, transactionID string, valid bool) (*TransactionFlag, error) {
+	resolution := TransactionFlagInvalid
+	if valid {
+		resolution = TransactionFlagValid
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	scope := ScopeToUser(userID).InTx(tx)
+	var id string
+	err = scope.QueryRow(`
+		UPDATE transaction_flags SET status = 'resolved', resolution = $3, resolved_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND transaction_id::text = $2 AND status = 'flagged'
+		RETURNING transaction_id
+	`, transactionID, resolution).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve transaction flag: %v", err)
+	}
+	if valid {
+		if _, err := scope.Exec("UPDATE transactions SET is_flagged = FALSE WHERE user_id = $1 AND id = $2", id); err != nil {
+			return nil, fmt.Errorf("failed to unflag transaction: %v", err)
+		}
+	}
+	flag, err := scanTransactionFlag(scope.QueryRow(transactionFlagSelect+" WHERE f.user_id = $1 AND f.transaction_id = $2", id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction flag: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction flag resolution: %v", err)
+	}
+	return &flag, nil
+}
+
+// ********** BUSINESS EXPENSES **********
+
+// SetTransactionBusiness marks one of the user's transactions as a business expense or a personal one, returning
+// false when they have no such transaction
+func SetTransactionBusiness(userID int, transactionID string, isBusiness bool) (bool, error) {
+	result, err := ScopeToUser(userID).Exec("UPDATE transactions SET is_business = $3 WHERE user_id = $1 AND id::text = $2",
+		transactionID, isBusiness)
+	if err != nil {
+		return false, fmt.Errorf("failed to set transaction business: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// BusinessExpense is a purchase the user marked as a business expense, with the category it is reported under
+type BusinessExpense struct {
+	TransactionID string
+	Date          time.Time
+	Category      string
+	Description   string
+	Merchant      string
+	Amount        money.Money
+	Account       string
+}
+
+// GetBusinessExpenses returns the user's business expenses dated in [start, end), archived ones included, ordered
+// by category and then date. Unlike spend reports it keeps accounts excluded from budgeting, since a business card
+// usually is; transfers and transactions flagged for review are left out.
+func GetBusinessExpenses(userID int, start time.Time, end time.Time) ([]BusinessExpense, error) {
+	query := "SELECT transactions.id, transactions.date, " + reportCategoryLabel + " AS category," +
+		" COALESCE(transactions.description, ''), COALESCE(transactions.merchant, ''), transactions.amount::numeric," +
+		" COALESCE(transactions.currency, '" + money.DefaultCurrency + "'), COALESCE(a.account_name, '')" +
+		" FROM " + transactionSource(true) + mappedCategoryJoin +
+		" LEFT JOIN plaid_accounts a ON a.id = transactions.plaid_account_id" +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3 AND transactions.is_business" +
+		" AND transactions.amount::numeric > 0 AND NOT transactions.is_transfer AND NOT transactions.is_flagged" +
+		" ORDER BY 3, 2, 1"
+	rows, err := ScopeToUser(userID).ReadQuery(query, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query business expenses: %v", err)
+	}
+	defer rows.Close()
+	expenses := []BusinessExpense{}
+	for rows.Next() {
+		var expense BusinessExpense
+		var currency string
+		if err := rows.Scan(&expense.TransactionID, &expense.Date, &expense.Category, &expense.Description, &expense.Merchant,
+			&expense.Amount, &currency, &expense.Account); err != nil {
+			return nil, fmt.Errorf("failed to scan business expense: %v", err)
+		}
+		expense.Amount = expense.Amount.In(currency)
+		expenses = append(expenses, expense)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating business expenses: %v", err)
+	}
+	return expenses, nil
+}
+
+// ********** TAX REPORT **********
+
+// Tax types a category can be designated as. TaxTypeNone opts a category out of a global default.
+const (
+	TaxTypeDonations = "donations"
+	TaxTypeMedical   = "medical"
+	TaxTypeBusiness  = "business"
+	TaxTypeNone      = "none"
+)
+
+// TaxCategory designates a category as tax relevant. Category is a budget category name or a Plaid personal finance
+// category. A nil UserID marks a global default.
+type TaxCategory struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id"`
+	Category  string    `json:"category"`
+	TaxType   string    `json:"tax_type"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetTaxCategories returns the user's own tax categories followed by the global defaults
+func GetTaxCategories(userID int) ([]TaxCategory, error) {
+	query := "SELECT id, user_id, category, tax_type, created_at, updated_at FROM tax_categories WHERE (user_id = $1 OR user_id IS NULL) ORDER BY user_id IS NULL, category"
+	rows, err := ScopeToUser(userID).Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tax categories: %v", err)
+	}
+	defer rows.Close()
+	categories := []TaxCategory{}
+	for rows.Next() {
+		var category TaxCategory
+		var categoryUserID sql.NullInt64
+		if err := rows.Scan(&category.ID, &categoryUserID, &category.Category, &category.TaxType, &category.CreatedAt, &category.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tax category: %v", err)
+		}
+		if categoryUserID.Valid {
+			id := int(categoryUserID.Int64)
+			category.UserID = &id
+		}
+		categories = append(categories, category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tax categories: %v", err)
+	}
+	return categories, nil
+}
+
+// UpsertTaxCategory designates one of the user's categories as a tax type, replacing any designation it had
+func UpsertTaxCategory(userID int, category string, taxType string) (*TaxCategory, error) {
+	query := "INSERT INTO tax_categories (user_id, category, tax_type) VALUES ($1, $2, $3) ON CONFLICT (COALESCE(user_id, 0), LOWER(category)) DO UPDATE SET category = EXCLUDED.category, tax_type = EXCLUDED.tax_type RETURNING id, category, tax_type, created_at, updated_at"
+	taxCategory := TaxCategory{UserID: &userID}
+	err := ScopeToUser(userID).QueryRow(query, category, taxType).Scan(&taxCategory.ID, &taxCategory.Category, &taxCategory.TaxType, &taxCategory.CreatedAt, &taxCategory.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert tax category: %v", err)
+	}
+	return &taxCategory, nil
+}
+
+// DeleteTaxCategory deletes one of the user's tax categories, so the category falls back to the global default
+func DeleteTaxCategory(userID int, taxCategoryID int) error {
+	result, err := ScopeToUser(userID).Exec("DELETE FROM tax_categories WHERE user_id = $1 AND id = $2", taxCategoryID)
+	if err != nil {
+		return fmt.Errorf("failed to delete tax category: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("tax category not found")
+	}
+	return nil
+}
+
+// taxTypeJoin resolves the tax type of each transaction's category as tax.tax_type, NULL when it has none. The
+// user's own designations win over global ones, and the budget category wins over the detailed and then primary
+// Plaid category. It needs mappedCategoryJoin first.
+const taxTypeJoin = ` CROSS JOIN LATERAL (SELECT ` + reportCategoryLabel + ` AS label) cl
+	LEFT JOIN LATERAL (SELECT tc.tax_type FROM tax_categories tc
+		WHERE (tc.user_id = transactions.user_id OR tc.user_id IS NULL)
+			AND LOWER(tc.category) IN (LOWER(cl.label), LOWER(transactions.personal_finance_category_detailed), LOWER(transactions.personal_finance_category_primary))
+		ORDER BY tc.user_id IS NULL,
+			CASE LOWER(tc.category)
+				WHEN LOWER(cl.label) THEN 0
+				WHEN LOWER(transactions.personal_finance_category_detailed) THEN 1
+				ELSE 2
+			END
+		LIMIT 1) tax ON TRUE`
+
+// TaxExpense is a purchase in a tax relevant category, with the tax type and category it is reported under
+type TaxExpense struct {
+	TransactionID string
+	Date          time.Time
+	TaxType       string
+	Category      string
+	Description   string
+	Merchant      string
+	Amount        money.Money
+	Account       string
+}
+
+// TaxCategoryTotal is the total of a tax relevant category in one currency
+type TaxCategoryTotal struct {
+	TaxType  string      `json:"tax_type"`
+	Category string      `json:"category"`
+	Total    money.Money `json:"total"`
+	Currency string      `json:"currency"`
+	Count    int         `json:"count"`
+}
+
+// GetTaxExpenses returns the user's purchases in [start, end) that are tax relevant, archived ones included, ordered
+// by tax type, category and date. Transactions marked as business expenses are business whatever their category.
+// Like GetBusinessExpenses it keeps accounts excluded from budgeting and leaves out transfers and flagged
+// transactions.
+func GetTaxExpenses(userID int, start time.Time, end time.Time) ([]TaxExpense, error) {
+	query := "SELECT transactions.id, transactions.date," +
+		" CASE WHEN transactions.is_business THEN '" + TaxTypeBusiness + "' ELSE tax.tax_type END AS tax_type, cl.label," +
+		" COALESCE(transactions.description, ''), COALESCE(transactions.merchant, ''), transactions.amount::numeric," +
+		" COALESCE(transactions.currency, '" + money.DefaultCurrency + "'), COALESCE(a.account_name, '')" +
+		" FROM " + transactionSource(true) + mappedCategoryJoin + taxTypeJoin +
+		" LEFT JOIN plaid_accounts a ON a.id = transactions.plaid_account_id" +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3" +
+		" AND (transactions.is_business OR tax.tax_type IN ($4, $5, $6))" +
+		" AND transactions.amount::numeric > 0 AND NOT transactions.is_transfer AND NOT transactions.is_flagged" +
+		" ORDER BY 3, 4, 2, 1"
+	rows, err := ScopeToUser(userID).ReadQuery(query, start, end, TaxTypeDonations, TaxTypeMedical, TaxTypeBusiness)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tax expenses: %v", err)
+	}
+	defer rows.Close()
+	expenses := []TaxExpense{}
+	for rows.Next() {
+		var expense TaxExpense
+		var currency string
+		if err := rows.Scan(&expense.TransactionID, &expense.Date, &expense.TaxType, &expense.Category, &expense.Description,
+			&expense.Merchant, &expense.Amount, &currency, &expense.Account); err != nil {
+			return nil, fmt.Errorf("failed to scan tax expense: %v", err)
+		}
+		expense.Amount = expense.Amount.In(currency)
+		expenses = append(expenses, expense)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tax expenses: %v", err)
+	}
+	return expenses, nil
+}
+
+// SummarizeTaxExpenses totals tax expenses by tax type, category and currency, in the order GetTaxExpenses returns
+// them
+func SummarizeTaxExpenses(expenses []TaxExpense) []TaxCategoryTotal {
+	totals := []TaxCategoryTotal{}
+	index := map[[3]string]int{}
+	for _, expense := range expenses {
+		key := [3]string{expense.TaxType, expense.Category, expense.Amount.Currency()}
+		i, ok := index[key]
+		if !ok {
+			i = len(totals)
+			index[key] = i
+			totals = append(totals, TaxCategoryTotal{TaxType: key[0], Category: key[1], Total: money.Money{}.In(key[2]), Currency: key[2]})
+		}
+		totals[i].Total = totals[i].Total.Add(expense.Amount)
+		totals[i].Count++
+	}
+	return totals
+}
+
+// ********** LEDGER EXPORT **********
+
+// Kinds of ledger account mappings, by what they map
+const (
+	LedgerMappingAccount  = "account"
+	LedgerMappingCategory = "category"
+)
+
+// LedgerAccountMapping names the ledger account the ledger export posts one of the user's accounts or categories to.
+// Source is the account's id for LedgerMappingAccount, and the category as reports label it for LedgerMappingCategory.
+type LedgerAccountMapping struct {
+	ID            int       `json:"id"`
+	Kind          string    `json:"kind"`
+	Source        string    `json:"source"`
+	LedgerAccount string    `json:"ledger_account"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+const ledgerAccountMappingColumns = "id, kind, source, ledger_account, created_at, updated_at"
+
+func (m *LedgerAccountMapping) dest() []interface{} {
+	return []interface{}{&m.ID, &m.Kind, &m.Source, &m.LedgerAccount, &m.CreatedAt, &m.UpdatedAt}
+}
+
+// GetLedgerAccountMappings returns the user's ledger account mappings, accounts first
+func GetLedgerAccountMappings(userID int) ([]LedgerAccountMapping, error) {
+	rows, err := ScopeToUser(userID).Query("SELECT " + ledgerAccountMappingColumns + " FROM ledger_account_mappings WHERE user_id = $1 ORDER BY kind, LOWER(source)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger account mappings: %v", err)
+	}
+	defer rows.Close()
+	mappings := []LedgerAccountMapping{}
+	for rows.Next() {
+		var mapping LedgerAccountMapping
+		if err := rows.Scan(mapping.dest()...); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger account mapping: %v", err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ledger account mappings: %v", err)
+	}
+	return mappings, nil
+}
+
+// UpsertLedgerAccountMapping maps one of the user's accounts or categories to a ledger account, replacing any
+// mapping it had
+func UpsertLedgerAccountMapping(userID int, kind string, source string, ledgerAccount string) (*LedgerAccountMapping, error) {
+	query := "INSERT INTO ledger_account_mappings (user_id, kind, source, ledger_account) VALUES ($1, $2, $3, $4)" +
+		" ON CONFLICT (user_id, kind, LOWER(source)) DO UPDATE SET source = EXCLUDED.source, ledger_account = EXCLUDED.ledger_account" +
+		" RETURNING " + ledgerAccountMappingColumns
+	var mapping LedgerAccountMapping
+	if err := ScopeToUser(userID).QueryRow(query, kind, source, ledgerAccount).Scan(mapping.dest()...); err != nil {
+		return nil, fmt.Errorf("failed to upsert ledger account mapping: %v", err)
+	}
+	return &mapping, nil
+}
+
+// DeleteLedgerAccountMapping deletes one of the user's ledger account mappings, so the export names the account
+// itself again
+func DeleteLedgerAccountMapping(userID int, mappingID int) error {
+	result, err := ScopeToUser(userID).Exec("DELETE FROM ledger_account_mappings WHERE user_id = $1 AND id = $2", mappingID)
+	if err != nil {
+		return fmt.Errorf("failed to delete ledger account mapping: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("ledger account mapping not found")
+	}
+	return nil
+}
+
+// LedgerAccountKey identifies an account the way transactions do, by provider and the provider's account id
+type LedgerAccountKey struct {
+	Provider   string
+	AccountRef string
+}
+
+// LedgerAccount is one of the user's linked accounts as the ledger export posts to it
+type LedgerAccount struct {
+	LedgerAccountKey
+	Name string
+	// Type is the provider's account type, such as depository, credit or loan
+	Type     string
+	Currency string
+	// Balance is the current balance the provider last reported, nil for providers that don't report balances.
+	// Credit and loan balances are what is owed.
+	Balance *money.Money
+}
+
+// GetLedgerAccounts returns the user's linked Plaid and Teller accounts, named as the user nicknamed them
+func GetLedgerAccounts(userID int) ([]LedgerAccount, error) {
+	query := `
+		SELECT 'plaid', id, COALESCE(nickname, account_name, official_name, id), COALESCE(account_type, ''),
+			COALESCE(currency, '` + money.DefaultCurrency + `'), current_balance::numeric
+		FROM ` + plaidAccountSource + `
+		WHERE user_id = $1
+		UNION ALL
+		SELECT 'teller', a.id::text, COALESCE(s.nickname, a.account_name), a.account_type, a.currency, NULL::numeric
+		FROM teller_accounts a
+		LEFT JOIN account_settings s ON s.user_id = a.user_id AND s.provider_type = 'teller' AND s.account_ref = a.id::text
+		WHERE a.user_id = $1 AND a.deleted_at IS NULL
+		ORDER BY 1, 3, 2
+	`
+	rows, err := ScopeToUser(userID).ReadQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger accounts: %v", err)
+	}
+	defer rows.Close()
+	accounts := []LedgerAccount{}
+	for rows.Next() {
+		var account LedgerAccount
+		if err := rows.Scan(&account.Provider, &account.AccountRef, &account.Name, &account.Type, &account.Currency, &account.Balance); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger account: %v", err)
+		}
+		if account.Balance != nil {
+			balance := account.Balance.In(account.Currency)
+			account.Balance = &balance
+		}
+		accounts = append(accounts, account)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ledger accounts: %v", err)
+	}
+	return accounts, nil
+}
+
+// LedgerTransaction is a posted transaction as the ledger export records it
+type LedgerTransaction struct {
+	TransactionID string
+	Date          time.Time
+	Account       LedgerAccountKey
+	Description   string
+	Merchant      string
+	// Category is the category as reports label it
+	Category   string
+	PFCPrimary string
+	IsTransfer bool
+	// Amount is positive for money out
+	Amount money.Money
+}
+
+// GetLedgerTransactions returns the user's posted transactions in [start, end), archived ones included, in date
+// order. Unlike spending reports it keeps every account and transfer, since a ledger balances each account.
+func GetLedgerTransactions(userID int, start time.Time, end time.Time) ([]LedgerTransaction, error) {
+	query := "SELECT transactions.id, transactions.date, COALESCE(transactions.provider_type, ''), COALESCE(transactions.account_ref, '')," +
+		" COALESCE(transactions.description, ''), COALESCE(transactions.merchant, ''), " + reportCategoryLabel + "," +
+		" COALESCE(transactions.personal_finance_category_primary, ''), transactions.is_transfer, transactions.amount::numeric," +
+		" COALESCE(transactions.currency, '" + money.DefaultCurrency + "')" +
+		" FROM " + transactionSource(true) + mappedCategoryJoin +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3 AND COALESCE(transactions.status, '') <> 'pending'" +
+		" ORDER BY transactions.date, transactions.id"
+	rows, err := ScopeToUser(userID).ReadQuery(query, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger transactions: %v", err)
+	}
+	defer rows.Close()
+	transactions := []LedgerTransaction{}
+	for rows.Next() {
+		var transaction LedgerTransaction
+		var currency string
+		if err := rows.Scan(&transaction.TransactionID, &transaction.Date, &transaction.Account.Provider, &transaction.Account.AccountRef,
+			&transaction.Description, &transaction.Merchant, &transaction.Category, &transaction.PFCPrimary, &transaction.IsTransfer,
+			&transaction.Amount, &currency); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger transaction: %v", err)
+		}
+		transaction.Amount = transaction.Amount.In(currency)
+		transactions = append(transactions, transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ledger transactions: %v", err)
+	}
+	return transactions, nil
+}
+
+// GetLedgerActivitySince totals each account's posted transactions dated on or after since, archived ones included,
+// positive for money out. Undoing it works current balances back to the balances as since began.
+func GetLedgerActivitySince(userID int, since time.Time) (map[LedgerAccountKey]money.Money, error) {
+	query := "SELECT COALESCE(transactions.provider_type, ''), COALESCE(transactions.account_ref, ''), SUM(transactions.amount::numeric)," +
+		" MIN(COALESCE(transactions.currency, '" + money.DefaultCurrency + "'))" +
+		" FROM " + transactionSource(true) +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND COALESCE(transactions.status, '') <> 'pending'" +
+		" GROUP BY 1, 2"
+	rows, err := ScopeToUser(userID).ReadQuery(query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger activity: %v", err)
+	}
+	defer rows.Close()
+	activity := map[LedgerAccountKey]money.Money{}
+	for rows.Next() {
+		var key LedgerAccountKey
+		var total money.Money
+		var currency string
+		if err := rows.Scan(&key.Provider, &key.AccountRef, &total, &currency); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger activity: %v", err)
+		}
+		activity[key] = total.In(currency)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ledger activity: %v", err)
+	}
+	return activity, nil
+}
+
+// ********** TRANSACTION ANOMALIES **********
+
+// Kinds of unusual transaction
+const (
+	// AnomalyHighAmount is a purchase far above what the user usually spends at the merchant, or in the category
+	// when they haven't bought there often enough to tell
+	AnomalyHighAmount = "high_amount"
+	// AnomalyForeignCurrency is the user's first charge in a currency other than the one they mostly spend in
+	AnomalyForeignCurrency = "foreign_currency"
+	// AnomalyDuplicate is a charge with the same amount, merchant and date as one already recorded
+	AnomalyDuplicate = "duplicate"
+)
+
+const (
+	// anomalyAmountMultiple is how many times the usual amount a purchase must be to stand out, and
+	// anomalyMinExcess how far over it in dollars, so small purchases don't stand out on ratio alone
+	anomalyAmountMultiple = 3
+	anomalyMinExcess      = 50
+	// anomalyMerchantHistory and anomalyCategoryHistory are how many earlier purchases make a usual amount, taken
+	// from the anomalyHistoryDays before the purchase
+	anomalyMerchantHistory = 3
+	anomalyCategoryHistory = 5
+	anomalyHistoryDays     = 180
+	// AnomalyMaxAge is how old a transaction can be and still be flagged, so a first sync's history isn't
+	AnomalyMaxAge = 7 * 24 * time.Hour
+)
+
+// TransactionAnomaly is a recently imported transaction that looks unusual for its user
+type TransactionAnomaly struct {
+	TransactionID string      `json:"transaction_id"`
+	UserID        int         `json:"user_id"`
+	Kind          string      `json:"kind"`
+	Date          time.Time   `json:"date"`
+	Description   string      `json:"description"`
+	Merchant      string      `json:"merchant"`
+	Amount        money.Money `json:"amount"`
+	Currency      string      `json:"currency"`
+	// UsualAmount is the median of the purchases a high amount was compared with, nil for other kinds
+	UsualAmount *money.Money `json:"usual_amount"`
+}
+
+// DetectTransactionAnomalies returns the purchases created since the cutoff and dated within AnomalyMaxAge that
+// look unusual, for every active user or only userID when it isn't 0. A transaction can be unusual in more than
+// one way. Transfers and transactions the user already flagged are skipped; flagging stays the user's decision.
+func DetectTransactionAnomalies(since time.Time, userID int) ([]TransactionAnomaly, error) {
+	query := `
+		WITH candidates AS MATERIALIZED (
+			SELECT t.id, t.user_id, t.date, t.description, t.merchant, t.amount::numeric AS amount,
+				COALESCE(t.currency, '` + money.DefaultCurrency + `') AS currency, t.personal_finance_category_primary AS category, t.created_at
+			FROM transactions t
+			WHERE t.created_at >= $1 AND t.date >= $2 AND ($3 = 0 OR t.user_id = $3)
+				AND t.amount::numeric > 0 AND NOT t.is_transfer AND NOT t.is_flagged` + activeUserFilter("t.user_id") + `
+		)
+		SELECT c.id::text, c.user_id, '` + AnomalyHighAmount + `', c.date, c.description, COALESCE(c.merchant, ''), c.amount, c.currency,
+			CASE WHEN m.n >= $6 THEN m.usual ELSE k.usual END
+		FROM candidates c
+		CROSS JOIN LATERAL (
+			SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY h.amount::numeric)::numeric(12,2) AS usual, COUNT(*) AS n
+			FROM transactions h
+			WHERE h.user_id = c.user_id AND h.id <> c.id AND h.merchant = c.merchant
+				AND h.amount::numeric > 0 AND NOT h.is_transfer AND h.date >= c.date - $8::int AND h.date <= c.date
+		) m
+		CROSS JOIN LATERAL (
+			SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY h.amount::numeric)::numeric(12,2) AS usual, COUNT(*) AS n
+			FROM transactions h
+			WHERE h.user_id = c.user_id AND h.id <> c.id AND h.personal_finance_category_primary = c.category
+				AND h.amount::numeric > 0 AND NOT h.is_transfer AND h.date >= c.date - $8::int AND h.date <= c.date
+		) k
+		WHERE (m.n >= $6 AND c.amount > m.usual * $4 AND c.amount - m.usual >= $5)
+			OR (m.n < $6 AND k.n >= $7 AND c.amount > k.usual * $4 AND c.amount - k.usual >= $5)
+		UNION ALL
+		SELECT c.id::text, c.user_id, '` + AnomalyForeignCurrency + `', c.date, c.description, COALESCE(c.merchant, ''), c.amount, c.currency, NULL
+		FROM candidates c
+		WHERE c.currency <> (
+				SELECT COALESCE(h.currency, '` + money.DefaultCurrency + `') FROM transactions h
+				WHERE h.user_id = c.user_id GROUP BY 1 ORDER BY COUNT(*) DESC, 1 LIMIT 1)
+			AND NOT EXISTS (
+				SELECT 1 FROM transactions h
+				WHERE h.user_id = c.user_id AND h.id <> c.id AND COALESCE(h.currency, '` + money.DefaultCurrency + `') = c.currency
+					AND (h.date, h.created_at) < (c.date, c.created_at))
+		UNION ALL
+		SELECT c.id::text, c.user_id, '` + AnomalyDuplicate + `', c.date, c.description, COALESCE(c.merchant, ''), c.amount, c.currency, NULL
+		FROM candidates c
+		WHERE EXISTS (
+			SELECT 1 FROM transactions h
+			WHERE h.user_id = c.user_id AND h.id <> c.id AND h.date = c.date AND h.amount::numeric = c.amount
+				AND COALESCE(h.merchant, h.description) = COALESCE(c.merchant, c.description) AND NOT h.is_transfer
+				AND (h.created_at, h.id::text) < (c.created_at, c.id::text))
+		ORDER BY 2, 4, 1
+	`
+	rows, err := DB.Query(query, since, time.Now().Add(-AnomalyMaxAge).Format("2006-01-02"), userID,
+		anomalyAmountMultiple, anomalyMinExcess, anomalyMerchantHistory, anomalyCategoryHistory, anomalyHistoryDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect transaction anomalies: %v", err)
+	}
+	defer rows.Close()
+	anomalies := []TransactionAnomaly{}
+	for rows.Next() {
+		var anomaly TransactionAnomaly
+		if err := rows.Scan(&anomaly.TransactionID, &anomaly.UserID, &anomaly.Kind, &anomaly.Date, &anomaly.Description,
+			&anomaly.Merchant, &anomaly.Amount, &anomaly.Currency, &anomaly.UsualAmount); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction anomaly: %v", err)
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction anomalies: %v", err)
+	}
+	return anomalies, nil
+}
+
+// ********** SUBSCRIPTION PRICE INCREASES **********
+
+const (
+	// priceIncreaseHistory is how many earlier months a charge must have been made at the same price for it to
+	// count as a subscription, taken from the priceIncreaseHistoryDays before the new charge
+	priceIncreaseHistory     = 2
+	priceIncreaseHistoryDays = 2 * recurringChargeActiveDays
+	// priceIncreaseMinDelta is the smallest rise in dollars worth telling the user about
+	priceIncreaseMinDelta = 0.5
+	// PriceIncreaseMaxAge is how old a charge can be and still be reported, so a first sync's history isn't
+	PriceIncreaseMaxAge = recurringChargeActiveDays * 24 * time.Hour
+)
+
+// SubscriptionPriceIncrease is a recently imported charge from a merchant the user pays the same amount each
+// month, for more than the usual amount
+type SubscriptionPriceIncrease struct {
+	TransactionID string      `json:"transaction_id"`
+	UserID        int         `json:"user_id"`
+	Date          time.Time   `json:"date"`
+	Merchant      string      `json:"merchant"`
+	Amount        money.Money `json:"amount"`
+	// PreviousAmount is what the merchant charged each of the months before
+	PreviousAmount money.Money `json:"previous_amount"`
+	PreviousDate   time.Time   `json:"previous_date"`
+}
+
+// Delta is how much more the new charge is than the previous ones
+func (increase SubscriptionPriceIncrease) Delta() money.Money {
+	return increase.Amount.Sub(increase.PreviousAmount)
+}
+
+// AnnualImpact is how much more the subscription costs over a year at the new price
+func (increase SubscriptionPriceIncrease) AnnualImpact() money.Money {
+	return increase.Delta().Mul(12)
+}
+
+// DetectSubscriptionPriceIncreases returns the charges created since the cutoff and dated within
+// PriceIncreaseMaxAge that raise the price of a subscription, for every active user or only userID when it isn't
+// 0. A subscription is a merchant, or a description when the merchant isn't known, charged once in each of the
+// priceIncreaseHistory months before at one unchanged amount. Only the first charge of a month is compared, so a
+// second purchase from the merchant isn't taken for a new price.
+func DetectSubscriptionPriceIncreases(since time.Time, userID int) ([]SubscriptionPriceIncrease, error) {
+	query := `
+		WITH candidates AS MATERIALIZED (
+			SELECT t.id, t.user_id, t.date, COALESCE(t.merchant, t.description) AS payee, t.amount::numeric AS amount,
+				COALESCE(t.currency, '` + money.DefaultCurrency + `') AS currency, t.created_at
+			FROM transactions t
+			WHERE t.created_at >= $1 AND t.date >= $2 AND ($3 = 0 OR t.user_id = $3)
+				AND t.amount::numeric > 0 AND NOT t.is_transfer AND NOT t.is_flagged` + activeUserFilter("t.user_id") + `
+		)
+		SELECT c.id::text, c.user_id, c.date, c.payee, c.amount, c.currency, p.amount, p.last_date
+		FROM candidates c
+		CROSS JOIN LATERAL (
+			SELECT MAX(h.amount) AS amount, MAX(h.date) AS last_date,
+				COUNT(DISTINCT date_trunc('month', h.date)) AS months, COUNT(DISTINCT h.amount) AS prices
+			FROM (
+				SELECT h.date, h.amount::numeric AS amount FROM transactions h
+				WHERE h.user_id = c.user_id AND COALESCE(h.merchant, h.description) = c.payee
+					AND COALESCE(h.currency, '` + money.DefaultCurrency + `') = c.currency
+					AND h.amount::numeric > 0 AND NOT h.is_transfer AND NOT h.is_flagged
+					AND h.date < date_trunc('month', c.date) AND h.date >= c.date - $4::int
+				ORDER BY h.date DESC
+				LIMIT $5
+			) h
+		) p
+		WHERE p.months = $5 AND p.prices = 1 AND c.amount - p.amount >= $6
+			AND NOT EXISTS (
+				SELECT 1 FROM transactions h
+				WHERE h.user_id = c.user_id AND h.id <> c.id AND COALESCE(h.merchant, h.description) = c.payee
+					AND h.amount::numeric > 0 AND NOT h.is_transfer
+					AND date_trunc('month', h.date) = date_trunc('month', c.date)
+					AND (h.date, h.created_at, h.id::text) < (c.date, c.created_at, c.id::text))
+		ORDER BY c.user_id, c.date, c.id
+	`
+	rows, err := DB.Query(query, since, time.Now().Add(-PriceIncreaseMaxAge).Format("2006-01-02"), userID,
+		priceIncreaseHistoryDays, priceIncreaseHistory, priceIncreaseMinDelta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect subscription price increases: %v", err)
+	}
+	defer rows.Close()
+	increases := []SubscriptionPriceIncrease{}
+	for rows.Next() {
+		var increase SubscriptionPriceIncrease
+		var currency string
+		if err := rows.Scan(&increase.TransactionID, &increase.UserID, &increase.Date, &increase.Merchant,
+			&increase.Amount, &currency, &increase.PreviousAmount, &increase.PreviousDate); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription price increase: %v", err)
+		}
+		increase.Amount = increase.Amount.In(currency)
+		increase.PreviousAmount = increase.PreviousAmount.In(currency)
+		increases = append(increases, increase)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating subscription price increases: %v", err)
+	}
+	return increases, nil
+}
+
+// ********** INVESTMENT CONTRIBUTIONS **********
+
+const (
+	InvestmentContributionDetected  = "detected"
+	InvestmentContributionConfirmed = "confirmed"
+	InvestmentContributionRejected  = "rejected"
+)
+
+// InvestmentContribution is a transfer from one of the user's accounts into a brokerage account, counted towards
+// the invested amount of the month it was made in
+type InvestmentContribution struct {
+	ID            int         `json:"id"`
+	TransactionID string      `json:"transaction_id"`
+	Provider      string      `json:"provider"`
+	AccountID     string      `json:"account_id"`
+	Description   string      `json:"description"`
+	Date          time.Time   `json:"date"`
+	MonthYear     int         `json:"month_year"`
+	Amount        money.Money `json:"amount"`
+	Status        string      `json:"status"`
+	CreatedAt     time.Time   `json:"created_at"`
+	ReviewedAt    *time.Time  `json:"reviewed_at"`
+}
+
+// DetectInvestmentContributions records the confirmed transfers dated since `since` that move money from one of
+// the user's accounts into a brokerage account, then adds them to the invested amount of their months. It returns
+// how many contributions were new.
+func DetectInvestmentContributions(ctx context.Context, userID int, since time.Time) (int, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := ScopeToUser(userID).InTx(tx).ExecContext(ctx, `
+		INSERT INTO investment_contributions (user_id, transaction_id, month_year, amount)
+		SELECT $1, o.id, EXTRACT(MONTH FROM o.date)::int * 10000 + EXTRACT(YEAR FROM o.date)::int, o.amount::numeric
+		FROM transfer_matches m
+		JOIN transactions o ON o.id = m.outflow_transaction_id
+		JOIN transactions i ON i.id = m.inflow_transaction_id
+		WHERE m.user_id = $1 AND m.status = 'confirmed' AND o.date >= $2
+			AND account_is_investment(i.user_id, i.provider_type, i.account_ref)
+			AND NOT account_is_investment(o.user_id, o.provider_type, o.account_ref)
+		ON CONFLICT (transaction_id) DO NOTHING
+	`, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to detect investment contributions: %v", err)
+	}
+	detected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if err := applyInvestmentContributions(ctx, tx, userID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit investment contributions: %v", err)
+	}
+	return int(detected), nil
+}
+
+// applyInvestmentContributions brings the invested amount of each of the user's monthly summaries in line with
+// their contributions, adding the difference between what each contribution now counts for (nothing once
+// rejected) and what was already added. Contributions for months without a summary wait until one is created.
+func applyInvestmentContributions(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := ScopeToUser(userID).InTx(tx).ExecContext(ctx, `
+		WITH changed AS (
+			UPDATE investment_contributions c
+			SET applied_amount = CASE WHEN c.status = 'rejected' THEN 0 ELSE c.amount END
+			FROM (SELECT id, applied_amount FROM investment_contributions WHERE user_id = $1 FOR UPDATE) previous, monthly_summary s
+			WHERE c.user_id = $1 AND c.id = previous.id AND s.user_id = c.user_id AND s.monthyear = c.month_year
+				AND c.applied_amount IS DISTINCT FROM (CASE WHEN c.status = 'rejected' THEN 0 ELSE c.amount END)
+			RETURNING c.month_year, c.applied_amount - COALESCE(previous.applied_amount, 0) AS difference
+		)
+		UPDATE monthly_summary s SET invested = s.invested + changed.total
+		FROM (SELECT month_year, SUM(difference) AS total FROM changed GROUP BY month_year) changed
+		WHERE s.user_id = $1 AND s.monthyear = changed.month_year
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to apply investment contributions: %v", err)
+	}
+	return nil
+}
+
+const investmentContributionSelect = `
+	SELECT c.id, c.transaction_id, t.provider_type, COALESCE(t.account_ref, ''), COALESCE(t.description, ''), t.date,
+		c.month_year, c.amount, c.status, c.created_at, c.reviewed_at
+	FROM investment_contributions c
+	JOIN transactions t ON t.id = c.transaction_id`
+
+func scanInvestmentContribution(row interface{ Scan(...interface{}) error }) (InvestmentContribution, error) {
+	var contribution InvestmentContribution
+	err := row.Scan(&contribution.ID, &contribution.TransactionID, &contribution.Provider, &contribution.AccountID, &contribution.Description, &contribution.Date,
+		&contribution.MonthYear, &contribution.Amount, &contribution.Status, &contribution.CreatedAt, &contribution.ReviewedAt)
+	return contribution, err
+}
+
+// ListInvestmentContributions returns the user's investment contributions for a month, most recent first
+func ListInvestmentContributions(userID int, monthYear int) ([]InvestmentContribution, error) {
+	query := investmentContributionSelect + " WHERE c.user_id = $1 AND c.month_year = $2 ORDER BY t.date DESC, c.id DESC"
+	rows, err := ScopeToUser(userID).ReadQuery(query, monthYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query investment contributions: %v", err)
+	}
+	defer rows.Close()
+	contributions := []InvestmentContribution{}
+	for rows.Next() {
+		contribution, err := scanInvestmentContribution(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan investment contribution: %v", err)
+		}
+		contributions = append(contributions, contribution)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating investment contributions: %v", err)
+	}
+	return contributions, nil
+}
+
+// ReviewInvestmentContribution confirms, corrects or rejects one of the user's investment contributions, returning
+// nil when there is no such contribution. A nil amount keeps the detected amount. The month's invested amount is
+// adjusted by however much the contribution's share changed.
+func ReviewInvestmentContribution(userID int, contributionID int, status string, amount *money.Money) (*InvestmentContribution, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	scope := ScopeToUser(userID).InTx(tx)
+	result, err := scope.Exec(`
+		UPDATE investment_contributions SET status = $3, amount = COALESCE($4, amount), reviewed_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id = $2
+	`, contributionID, status, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to review investment contribution: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return nil, nil
+	}
+	if err := applyInvestmentContributions(context.Background(), tx, userID); err != nil {
+		return nil, err
+	}
+	contribution, err := scanInvestmentContribution(scope.QueryRow(investmentContributionSelect+" WHERE c.user_id = $1 AND c.id = $2", contributionID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get investment contribution: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit investment contribution review: %v", err)
+	}
+	return &contribution, nil
+}
+
+// ********** TRANSACTION ARCHIVE **********
+
+// transactionArchiveBatchSize bounds how many rows one archive statement moves, keeping each transaction short
+const transactionArchiveBatchSize = 5000
+
+// transactionArchiveColumns are the columns moved into transactions_archive. They are named rather than taken by
+// position, so a migration adding a column to transactions adds it to the archive and here, in any order.
+const transactionArchiveColumns = "id, user_id, teller_institution_id, teller_account_id, teller_transaction_id, amount," +
+	" description, date, type, status, running_balance, processing_status, counterparty_name, counterparty_type," +
+	" self_link, account_link, created_at, updated_at, plaid_transaction_id, plaid_account_id, currency, provider_type," +
+	" category, personal_finance_category_primary, personal_finance_category_detailed, provider_transaction_id," +
+	" account_ref, merchant, is_transfer, search_vector, is_flagged, is_business, notes, " + transactionLocationColumns
+
+// ArchiveTransactionsBefore moves transactions dated before cutoff into transactions_archive, returning how many
+// moved. Transactions with round ups or flags stay in the live table since deleting them would cascade to those.
+func ArchiveTransactionsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		WITH moved AS (
+			DELETE FROM transactions WHERE id IN (
+				SELECT t.id FROM transactions t
+				WHERE t.date < $1 AND NOT EXISTS (SELECT 1 FROM round_ups r WHERE r.transaction_id = t.id)
+					AND NOT EXISTS (SELECT 1 FROM transaction_flags f WHERE f.transaction_id = t.id)
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING ` + transactionArchiveColumns + `
+		)
+		INSERT INTO transactions_archive (` + transactionArchiveColumns + `, archived_at)
+		SELECT ` + transactionArchiveColumns + `, CURRENT_TIMESTAMP FROM moved
+	`
+	var total int64
+	for {
+		result, err := DB.ExecContext(ctx, query, cutoff, transactionArchiveBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to archive transactions: %v", err)
+		}
+		moved, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to count archived transactions: %v", err)
+		}
+		total += moved
+		if moved < transactionArchiveBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// transactionSourceColumns are the columns list and analytics queries read, present in both the live and archive tables
+const transactionSourceColumns = "id, user_id, amount, date, description, category, currency, status, type, provider_type," +
+	" personal_finance_category_primary, personal_finance_category_detailed, plaid_account_id, account_ref, is_transfer," +
+	" is_flagged, merchant, notes, search_vector, is_business, " + transactionLocationColumns
+
+// transactionSource is what list and analytics queries select from, always named transactions. Archived rows are
+// only scanned when asked for so the common case stays on the live table.
+func transactionSource(includeArchived bool) string {
+	if !includeArchived {
+		return "transactions"
+	}
+	return "(SELECT " + transactionSourceColumns + " FROM transactions UNION ALL SELECT " + transactionSourceColumns +
+		" FROM transactions_archive) transactions"
+}
+
+// ********** TELLER ENROLLMENTS **********
+
+const (
+	TellerEnrollmentActive         = "active"
+	TellerEnrollmentReauthRequired = "reauth_required"
+	// TellerEnrollmentRemoved is reported for access tokens no enrollment holds any more, once the user removed it
+	TellerEnrollmentRemoved = "removed"
+)
+
+// TellerEnrollment is a Teller connection as shown to its user, without the access token. EnrollmentID is
+// what Teller Connect needs to reconnect it.
+type TellerEnrollment struct {
+	ID            string     `json:"id"`
+	UserID        int        `json:"user_id"`
+	Name          string     `json:"name"`
+	EnrollmentID  string     `json:"enrollment_id"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	AuthFailedAt  *time.Time `json:"auth_failed_at"`
+	LastAuthError *string    `json:"last_auth_error"`
+}
+
+const tellerEnrollmentColumns = "id, user_id, name, teller_id, status, created_at, last_success_at, auth_failed_at, last_auth_error"
+
+func scanTellerEnrollments(rows *sql.Rows) ([]TellerEnrollment, error) {
+	defer rows.Close()
+	enrollments := []TellerEnrollment{}
+	for rows.Next() {
+		var enrollment TellerEnrollment
+		if err := rows.Scan(&enrollment.ID, &enrollment.UserID, &enrollment.Name, &enrollment.EnrollmentID, &enrollment.Status, &enrollment.CreatedAt, &enrollment.LastSuccessAt, &enrollment.AuthFailedAt, &enrollment.LastAuthError); err != nil {
+			return nil, fmt.Errorf("failed to scan teller enrollment: %v", err)
+		}
+		enrollments = append(enrollments, enrollment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating teller enrollments: %v", err)
+	}
+	return enrollments, nil
+}
+
+// GetTellerEnrollments returns the user's Teller connections, oldest first
+func GetTellerEnrollments(userID int) ([]TellerEnrollment, error) {
+	rows, err := ScopeToUser(userID).ReadQuery("SELECT " + tellerEnrollmentColumns + " FROM teller_institutions WHERE user_id = $1 AND deleted_at IS NULL ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query teller enrollments: %v", err)
+	}
+	return scanTellerEnrollments(rows)
+}
+
+// GetTellerEnrollmentStatus returns the status of the enrollment an access token belongs to, or
+// TellerEnrollmentRemoved when the user has removed it
+func GetTellerEnrollmentStatus(userID int, accessToken string) (string, error) {
+	var status string
+	err := ScopeToUser(userID).QueryRow("SELECT status FROM teller_institutions WHERE user_id = $1 AND access_token = $2", accessToken).Scan(&status)
+	if err == sql.ErrNoRows {
+		return TellerEnrollmentRemoved, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get teller enrollment status: %v", err)
+	}
+	return status, nil
+}
+
+// RecordTellerSyncSuccess notes a successful sync of the enrollment an access token belongs to
+func RecordTellerSyncSuccess(userID int, accessToken string) error {
+	query := "UPDATE teller_institutions SET last_success_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND access_token = $2"
+	if _, err := ScopeToUser(userID).Exec(query, accessToken); err != nil {
+		return fmt.Errorf("failed to record teller sync success: %v", err)
+	}
+	return nil
+}
+
+// FlagTellerReauthRequired marks the enrollment an access token belongs to as needing the user to reconnect,
+// counting the user as reminded once. It returns nil when the enrollment was already flagged.
+func FlagTellerReauthRequired(userID int, accessToken string, authErr string) (*TellerEnrollment, error) {
+	query := "UPDATE teller_institutions SET status = 'reauth_required', auth_failed_at = CURRENT_TIMESTAMP, last_auth_error = $3," +
+		" reauth_reminded_at = CURRENT_TIMESTAMP, reauth_reminder_count = 1" +
+		" WHERE user_id = $1 AND access_token = $2 AND status <> 'reauth_required' RETURNING " + tellerEnrollmentColumns
+	rows, err := ScopeToUser(userID).Query(query, accessToken, authErr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to flag teller enrollment: %v", err)
+	}
+	enrollments, err := scanTellerEnrollments(rows)
+	if err != nil || len(enrollments) == 0 {
+		return nil, err
+	}
+	return &enrollments[0], nil
+}
+
+// GetTellerEnrollmentsDueReauthReminder returns flagged enrollments whose user was last reminded longer than
+// remindEvery ago and has been reminded fewer than maxReminders times
+func GetTellerEnrollmentsDueReauthReminder(remindEvery time.Duration, maxReminders int) ([]TellerEnrollment, error) {
+	query := "SELECT " + tellerEnrollmentColumns + " FROM teller_institutions" +
+		" WHERE status = 'reauth_required' AND deleted_at IS NULL AND reauth_reminded_at < $1 AND reauth_reminder_count < $2" +
+		activeUserFilter("teller_institutions.user_id") + " ORDER BY reauth_reminded_at"
+	rows, err := DB.Query(query, time.Now().Add(-remindEvery), maxReminders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query teller enrollments due a reminder: %v", err)
+	}
+	return scanTellerEnrollments(rows)
+}
+
+// MarkTellerReauthReminded records another reminder and returns how many the user has had
+func MarkTellerReauthReminded(id string) (int, error) {
+	query := "UPDATE teller_institutions SET reauth_reminded_at = CURRENT_TIMESTAMP, reauth_reminder_count = reauth_reminder_count + 1 WHERE id = $1 RETURNING reauth_reminder_count"
+	var count int
+	if err := DB.QueryRow(query, id).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to mark teller reauth reminded: %v", err)
+	}
+	return count, nil
+}
+
+// RelinkTellerEnrollment stores the access token Teller Connect returned on reconnecting an enrollment and
+// makes it active again. enrollmentID must match the enrollment being reconnected.
+func RelinkTellerEnrollment(userID int, id string, enrollmentID string, accessToken string) (*TellerEnrollment, error) {
+	query := "UPDATE teller_institutions SET access_token = $4, status = 'active', auth_failed_at = NULL, last_auth_error = NULL," +
+		" reauth_reminded_at = NULL, reauth_reminder_count = 0" +
+		" WHERE user_id = $1 AND id::text = $2 AND teller_id = $3 AND deleted_at IS NULL RETURNING " + tellerEnrollmentColumns
+	rows, err := ScopeToUser(userID).Query(query, id, enrollmentID, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to relink teller enrollment: %v", err)
+	}
+	enrollments, err := scanTellerEnrollments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(enrollments) == 0 {
+		return nil, fmt.Errorf("teller enrollment not found")
+	}
+	return &enrollments[0], nil
+}
+
+// DeleteTellerEnrollment removes one of the user's Teller enrollments, returning nil when they have no such
+// enrollment. Its access token is dropped, so queued and future syncs of it are skipped, and it and its accounts
+// are marked deleted. The accounts' transactions are deleted too when deleteTransactions is set, otherwise they
+// are kept. It also returns how many transactions were deleted.
+func DeleteTellerEnrollment(userID int, id string, deleteTransactions bool) (*TellerEnrollment, int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	scope := ScopeToUser(userID).InTx(tx)
+
+	query := "UPDATE teller_institutions SET access_token = NULL, deleted_at = CURRENT_TIMESTAMP" +
+		" WHERE user_id = $1 AND id::text = $2 AND deleted_at IS NULL RETURNING " + tellerEnrollmentColumns
+	rows, err := scope.Query(query, id)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to delete teller enrollment: %v", err)
+	}
+	enrollments, err := scanTellerEnrollments(rows)
+	if err != nil || len(enrollments) == 0 {
+		return nil, 0, err
+	}
+
+	_, err = scope.Exec("UPDATE teller_accounts SET deleted_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND teller_institution_id::text = $2 AND deleted_at IS NULL", id)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to delete teller accounts: %v", err)
+	}
+
+	var deleted int64
+	if deleteTransactions {
+		for _, table := range []string{"transactions", "transactions_archive"} {
+			result, err := scope.Exec("DELETE FROM "+table+" WHERE user_id = $1 AND teller_account_id IN"+
+				" (SELECT id FROM teller_accounts WHERE user_id = $1 AND teller_institution_id::text = $2)", id)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to delete teller transactions: %v", err)
+			}
+			count, err := result.RowsAffected()
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to get rows affected: %v", err)
+			}
+			deleted += count
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("failed to commit teller enrollment deletion: %v", err)
+	}
+	return &enrollments[0], deleted, nil
+}
+
+// ********** PLAID **********
+
+func CreatePlaidToken(userID int, accessToken string, itemID string) error {
+	query := "INSERT INTO plaid_tokens (user_id, access_token, item_id) VALUES ($1, $2, $3)"
+	_, err := ScopeToUser(userID).Exec(query, accessToken, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to create plaid token: %v", err)
+	}
+	return nil
+}
+
+func MarkPlaidAccountAsSynced(accountID string) error {
+	query := "UPDATE plaid_accounts SET is_processed = TRUE WHERE id = $1"
+	_, err := DB.Exec(query, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to mark plaid account as synced: %v", err)
+	}
+	log.Printf("Marked plaid account as synced: %s", accountID)
+	return nil
+}
+
+func GetAccessTokenFromAccountID(accountId string) (string, error) {
+	query := "SELECT p.access_token FROM plaid_accounts as a JOIN plaid_tokens as p ON a.plaid_token_id = p.id WHERE a.id = $1"
+	var accessToken string
+	err := DB.QueryRow(query, accountId).Scan(&accessToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token from account id: %v", err)
+	}
+	return accessToken, nil
+}
+
+func GetUserIdFromAccessToken(accessToken string) (string, int, error) {
+	query := "SELECT id, user_id, is_processed FROM plaid_tokens WHERE access_token = $1"
+	var plaidTokenID string
+	var userID int
+	var isProcessed bool
+	err := DB.QueryRow(query, accessToken).Scan(&plaidTokenID, &userID, &isProcessed)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get user id from access token: %v", err)
+	}
+	if isProcessed {
+		return "", 0, fmt.Errorf("access token already processed")
+	}
+	return plaidTokenID, userID, nil
+}
+
+// PlaidItem is one of a user's Plaid items, a bank login linked with Plaid Link
+type PlaidItem struct {
+	ItemID      string
+	AccessToken string
+}
+
+// GetPlaidItems returns the user's Plaid items, oldest first
+func GetPlaidItems(userID int) ([]PlaidItem, error) {
+	rows, err := ScopeToUser(userID).Query("SELECT item_id, access_token FROM plaid_tokens WHERE user_id = $1 ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plaid items: %v", err)
+	}
+	defer rows.Close()
+	items := []PlaidItem{}
+	for rows.Next() {
+		var item PlaidItem
+		if err := rows.Scan(&item.ItemID, &item.AccessToken); err != nil {
+			return nil, fmt.Errorf("failed to scan plaid item: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plaid items: %v", err)
+	}
+	return items, nil
+}
+
+// GetUserIdFromItemID returns the user a Plaid item belongs to, or 0 for unknown items
+func GetUserIdFromItemID(itemID string) (int, error) {
+	var userID int
+	err := DB.QueryRow("SELECT user_id FROM plaid_tokens WHERE item_id = $1", itemID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user id from item id: %v", err)
+	}
+	return userID, nil
+}
+
+// PlaidItemToken is the stored access token of a Plaid item and who it belongs to
+type PlaidItemToken struct {
+	ID          string
+	UserID      int
+	AccessToken string
+}
+
+// GetPlaidItemToken returns the access token of a Plaid item, or nil for unknown items
+func GetPlaidItemToken(itemID string) (*PlaidItemToken, error) {
+	var token PlaidItemToken
+	err := DB.QueryRow("SELECT id, user_id, access_token FROM plaid_tokens WHERE item_id = $1", itemID).Scan(&token.ID, &token.UserID, &token.AccessToken)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plaid item token: %v", err)
+	}
+	return &token, nil
+}
+
+// GetPlaidItemAccountIDs returns the ids of the accounts stored for a Plaid item
+func GetPlaidItemAccountIDs(plaidTokenID string) ([]string, error) {
+	rows, err := DB.Query("SELECT id FROM plaid_accounts WHERE plaid_token_id = $1", plaidTokenID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plaid item accounts: %v", err)
+	}
+	defer rows.Close()
+	accountIDs := []string{}
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, fmt.Errorf("failed to scan plaid item account: %v", err)
+		}
+		accountIDs = append(accountIDs, accountID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plaid item accounts: %v", err)
+	}
+	return accountIDs, nil
+}
+
+func MarkPlaidTokenAsProcessed(plaidTokenID string) error {
+	query := "UPDATE plaid_tokens SET is_processed = TRUE WHERE id = $1"
+	_, err := DB.Exec(query, plaidTokenID)
+	if err != nil {
+		return fmt.Errorf("failed to mark plaid token as processed: %v", err)
+	}
+	return nil
+}
+
+func GetAllAccountsSynced(userID int) (bool, error) {
+	query := "SELECT COUNT(*) FROM plaid_accounts WHERE user_id = $1 AND is_processed = FALSE"
+	var count int
+	err := ScopeToUser(userID).QueryRow(query).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to get all accounts synced: %v", err)
+	}
+	return count == 0, nil
+}
+
+// GetAllTellerAccountsSynced reports whether every open Teller account the user has linked has synced successfully
+func GetAllTellerAccountsSynced(userID int) (bool, error) {
+	query := `
+		SELECT COUNT(*) FROM teller_accounts a
+		WHERE a.user_id = $1 AND a.status = 'open' AND a.deleted_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM account_sync_status s
+				WHERE s.provider = 'teller' AND s.account_id = a.id AND s.last_success_at IS NOT NULL
+			)
+	`
+	var count int
+	err := ScopeToUser(userID).QueryRow(query).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to get all teller accounts synced: %v", err)
+	}
+	return count == 0, nil
+}
+
+func GetPlaidAccountsByUserID(userID int) ([]string, error) {
+	query := "SELECT id FROM plaid_accounts WHERE user_id = $1"
+	rows, err := ScopeToUser(userID).Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plaid accounts by user id: %v", err)
+	}
+	defer rows.Close()
+	var accounts []string
+	for rows.Next() {
+		var accountID string
+		err := rows.Scan(&accountID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan plaid account: %v", err)
+		}
+		accounts = append(accounts, accountID)
+	}
+	return accounts, nil
+}
+
+func CreatePlaidAccount(ctx context.Context, userID int, plaidTokenID string, accounts []plaid.AccountBase) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+
+	// Build bulk insert query
+	query := "INSERT INTO plaid_accounts (user_id, id, plaid_token_id, available_balance, current_balance, currency, account_name, official_name, account_type, account_subtype) VALUES "
+
+	values := make([]interface{}, 0, len(accounts)*9)
+	placeholders := make([]string, 0, len(accounts))
+
+	for i, account := range accounts {
+		// Create placeholder string for this account, every account sharing $1 for the user
+		start := 1 + i*9
+		placeholders = append(placeholders, fmt.Sprintf("($1, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			start+1, start+2, start+3, start+4, start+5, start+6, start+7, start+8, start+9))
+
+		// Extract values from Plaid account
+		var availableBalance, currentBalance float64
+		if account.Balances.Available.Get() != nil {
+			availableBalance = *account.Balances.Available.Get()
+		}
+		if account.Balances.Current.Get() != nil {
+			currentBalance = *account.Balances.Current.Get()
+		}
+
+		values = append(values,
+			account.GetAccountId(),
+			plaidTokenID,
+			availableBalance,
+			currentBalance,
+			account.Balances.GetIsoCurrencyCode(),
+			account.GetName(),
+			account.GetOfficialName(),
+			string(account.GetType()),
+			string(account.GetSubtype()),
+		)
+	}
+
+	query += strings.Join(placeholders, ", ")
+	query += " ON CONFLICT (id) DO UPDATE SET " +
+		"user_id = EXCLUDED.user_id, " +
+		"plaid_token_id = EXCLUDED.plaid_token_id, " +
+		"available_balance = EXCLUDED.available_balance, " +
+		"current_balance = EXCLUDED.current_balance, " +
+		"currency = EXCLUDED.currency, " +
+		"account_name = EXCLUDED.account_name, " +
+		"official_name = EXCLUDED.official_name, " +
+		"account_type = EXCLUDED.account_type, " +
+		"account_subtype = EXCLUDED.account_subtype"
+
+	_, err := ScopeToUser(userID).ExecContext(ctx, query, values...)
+	if err != nil {
+		return fmt.Errorf("failed to upsert plaid accounts: %v", err)
+	}
+
+	return nil
+}
+
+// CreatePlaidTransactions upserts Plaid transactions and returns the ones that weren't stored before. Each is stored
+// on its own account, since a fetch by access token returns the transactions of every account on the item.
+func CreatePlaidTransactions(ctx context.Context, userID int, transactions []plaid.Transaction) ([]Transaction, error) {
+	if len(transactions) == 0 {
+		return []Transaction{}, nil
+	}
+
+	// Build bulk insert query
+	query := "INSERT INTO transactions (user_id, plaid_account_id, plaid_transaction_id, amount, date, description, category, currency, status, type, provider_type, personal_finance_category_primary, personal_finance_category_detailed, provider_transaction_id, account_ref, merchant, " + transactionLocationColumns + ") VALUES "
+
+	values := make([]interface{}, 0, len(transactions)*20)
+	placeholders := make([]string, 0, len(transactions))
+
+	for i, transaction := range transactions {
+		start := 1 + i*20
+		placeholders = append(placeholders, fmt.Sprintf("($1, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, NULLIF($%d, ''), NULLIF($%d, ''), NULLIF($%d, ''), NULLIF($%d, ''), $%d, $%d)",
+			start+1, start+2, start+3, start+4, start+5, start+6, start+7, start+8, start+9, start+10, start+11, start+12, start+13, start+14, start+15,
+			start+16, start+17, start+18, start+19, start+20))
+		normalized := NewPlaidProviderTransaction(transaction)
+		location := transaction.GetLocation()
+		lat, _ := location.GetLatOk()
+		lon, _ := location.GetLonOk()
+
+		var pfcPrimary, pfcDetailed interface{}
+		if pfc, ok := transaction.GetPersonalFinanceCategoryOk(); ok && pfc != nil {
+			pfcPrimary = pfc.GetPrimary()
+			pfcDetailed = pfc.GetDetailed()
+		}
+
+		var status string
+		if transaction.GetPending() {
+			status = "pending"
+		} else {
+			status = "posted"
+		}
+
+		values = append(values,
+			normalized.AccountRef,
+			normalized.ProviderTransactionID,
+			normalized.Amount,
+			transaction.GetDate(),
+			transaction.GetName(),
+			normalized.CategoryJSON(),
+			transaction.GetIsoCurrencyCode(),
+			status,
+			transaction.GetPaymentChannel(),
+			normalized.Provider,
+			pfcPrimary,
+			pfcDetailed,
+			normalized.ProviderTransactionID,
+			normalized.AccountRef,
+			normalized.Merchant,
+			location.GetCity(),
+			location.GetRegion(),
+			location.GetCountry(),
+			lat,
+			lon,
+		)
+	}
+
+	query += strings.Join(placeholders, ", ")
+	query += " ON CONFLICT (provider_type, provider_transaction_id) DO UPDATE SET " +
+		"amount = EXCLUDED.amount, " +
+		"date = EXCLUDED.date, " +
+		"description = EXCLUDED.description, " +
+		"category = EXCLUDED.category, " +
+		"status = EXCLUDED.status, " +
+		"type = EXCLUDED.type, " +
+		"merchant = EXCLUDED.merchant, " +
+		"location_city = EXCLUDED.location_city, " +
+		"location_region = EXCLUDED.location_region, " +
+		"location_country = EXCLUDED.location_country, " +
+		"location_lat = EXCLUDED.location_lat, " +
+		"location_lon = EXCLUDED.location_lon, " +
+		"personal_finance_category_primary = EXCLUDED.personal_finance_category_primary, " +
+		"personal_finance_category_detailed = EXCLUDED.personal_finance_category_detailed" +
+		// xmax is only zero on rows the statement inserted rather than updated
+		" RETURNING id, amount::numeric, date, COALESCE(description, ''), COALESCE(currency, ''), COALESCE(status, ''), COALESCE(type, '')," +
+		" COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, ''), xmax = 0"
+	rows, err := ScopeToUser(userID).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert plaid transactions: %v", err)
+	}
+	defer rows.Close()
+	created := []Transaction{}
+	for rows.Next() {
+		transaction := Transaction{UserID: userID, ProviderType: ProviderPlaid}
+		var inserted bool
+		if err := rows.Scan(&transaction.TransactionID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.PersonalFinanceCategoryPrimary, &transaction.PersonalFinanceCategoryDetailed, &inserted); err != nil {
+			return nil, fmt.Errorf("failed to scan upserted plaid transaction: %v", err)
+		}
+		if inserted {
+			created = append(created, transaction)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating upserted plaid transactions: %v", err)
+	}
+	return created, nil
+}
+
+// PlaidTokenBackfillWindow is a Plaid access token with the earliest transaction date still missing data
+type PlaidTokenBackfillWindow struct {
+	AccessToken string
+	StartDate   time.Time
+}
+
+// GetPlaidTokensMissingPersonalFinanceCategory returns, per access token, the earliest transaction date
+// for the user's Plaid transactions that were stored before personal finance categories were captured
+func GetPlaidTokensMissingPersonalFinanceCategory(userID int) ([]PlaidTokenBackfillWindow, error) {
+	query := `
+		SELECT p.access_token, MIN(t.date)
+		FROM transactions t
+		JOIN plaid_accounts a ON t.plaid_account_id = a.id
+		JOIN plaid_tokens p ON a.plaid_token_id = p.id
+		WHERE t.user_id = $1 AND t.provider_type = 'plaid' AND t.personal_finance_category_primary IS NULL
+		GROUP BY p.access_token
+	`
+	rows, err := ScopeToUser(userID).Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plaid tokens missing personal finance category: %v", err)
+	}
+	defer rows.Close()
+	var windows []PlaidTokenBackfillWindow
+	for rows.Next() {
+		var window PlaidTokenBackfillWindow
+		if err := rows.Scan(&window.AccessToken, &window.StartDate); err != nil {
+			return nil, fmt.Errorf("failed to scan plaid token backfill window: %v", err)
+		}
+		windows = append(windows, window)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plaid token backfill windows: %v", err)
+	}
+	return windows, nil
+}
+
+// UpdatePlaidTransactionPersonalFinanceCategories fills in personal finance categories for existing rows that lack them
+func UpdatePlaidTransactionPersonalFinanceCategories(ctx context.Context, transactions []plaid.Transaction) (int64, error) {
+	ids := make([]string, 0, len(transactions))
+	primaries := make([]string, 0, len(transactions))
+	detaileds := make([]string, 0, len(transactions))
+	for _, transaction := range transactions {
+		pfc, ok := transaction.GetPersonalFinanceCategoryOk()
+		if !ok || pfc == nil {
+			continue
+		}
+		ids = append(ids, transaction.GetTransactionId())
+		primaries = append(primaries, pfc.GetPrimary())
+		detaileds = append(detaileds, pfc.GetDetailed())
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	query := `
+		UPDATE transactions t
+		SET personal_finance_category_primary = v.pfc_primary, personal_finance_category_detailed = v.pfc_detailed
+		FROM unnest($1::text[], $2::text[], $3::text[]) AS v(txn_id, pfc_primary, pfc_detailed)
+		WHERE t.plaid_transaction_id = v.txn_id AND t.personal_finance_category_primary IS NULL
+	`
+	result, err := DB.ExecContext(ctx, query, pq.Array(ids), pq.Array(primaries), pq.Array(detaileds))
+	if err != nil {
+		return 0, fmt.Errorf("failed to update personal finance categories: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// ********** INSTITUTIONS **********
+
+// institutionRefreshInterval is how long fetched institution details are used before they are fetched again
+const institutionRefreshInterval = 7 * 24 * time.Hour
+
+// Institution is a bank's details from Plaid, for showing its branding next to its accounts. Logo is a base64
+// encoded PNG.
+type Institution struct {
+	InstitutionID string  `json:"institution_id"`
+	Name          string  `json:"name"`
+	Logo          *string `json:"logo"`
+	PrimaryColor  *string `json:"primary_color"`
+	URL           *string `json:"url"`
+}
+
+// PlaidItemRef is a Plaid item whose institution hasn't been recorded yet
+type PlaidItemRef struct {
+	PlaidTokenID string
+	AccessToken  string
+}
+
+// GetPlaidItemsMissingInstitution returns the Plaid items linked without their institution being recorded
+func GetPlaidItemsMissingInstitution() ([]PlaidItemRef, error) {
+	rows, err := DB.Query("SELECT id, access_token FROM plaid_tokens WHERE institution_id IS NULL ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plaid items missing institution: %v", err)
+	}
+	defer rows.Close()
+	items := []PlaidItemRef{}
+	for rows.Next() {
+		var item PlaidItemRef
+		if err := rows.Scan(&item.PlaidTokenID, &item.AccessToken); err != nil {
+			return nil, fmt.Errorf("failed to scan plaid item: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plaid items: %v", err)
+	}
+	return items, nil
+}
+
+// SetPlaidItemInstitution records the institution a Plaid item is at
+func SetPlaidItemInstitution(plaidTokenID string, institutionID string) error {
+	_, err := DB.Exec("UPDATE plaid_tokens SET institution_id = $2 WHERE id = $1", plaidTokenID, institutionID)
+	if err != nil {
+		return fmt.Errorf("failed to set plaid item institution: %v", err)
+	}
+	return nil
+}
+
+// GetInstitutionsDueForRefresh returns the ids of institutions linked by some user whose details haven't been
+// fetched, or were fetched more than institutionRefreshInterval ago
+func GetInstitutionsDueForRefresh() ([]string, error) {
+	query := `
+		SELECT DISTINCT plaid_tokens.institution_id FROM plaid_tokens
+		LEFT JOIN institutions ON institutions.institution_id = plaid_tokens.institution_id
+		WHERE plaid_tokens.institution_id IS NOT NULL
+			AND (institutions.fetched_at IS NULL OR institutions.fetched_at < $1)
+		ORDER BY 1
+	`
+	rows, err := DB.Query(query, time.Now().Add(-institutionRefreshInterval))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get institutions due for refresh: %v", err)
+	}
+	defer rows.Close()
+	institutionIDs := []string{}
+	for rows.Next() {
+		var institutionID string
+		if err := rows.Scan(&institutionID); err != nil {
+			return nil, fmt.Errorf("failed to scan institution id: %v", err)
+		}
+		institutionIDs = append(institutionIDs, institutionID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating institution ids: %v", err)
+	}
+	return institutionIDs, nil
+}
+
+// UpsertInstitution stores freshly fetched institution details
+func UpsertInstitution(institution Institution) error {
+	query := `
+		INSERT INTO institutions (institution_id, name, logo, primary_color, url, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		ON CONFLICT (institution_id) DO UPDATE SET name = EXCLUDED.name, logo = EXCLUDED.logo,
+			primary_color = EXCLUDED.primary_color, url = EXCLUDED.url, fetched_at = EXCLUDED.fetched_at
+	`
+	_, err := DB.Exec(query, institution.InstitutionID, institution.Name, institution.Logo, institution.PrimaryColor, institution.URL)
+	if err != nil {
+		return fmt.Errorf("failed to upsert institution: %v", err)
+	}
+	return nil
+}
+
+// ********** PLAID BACKFILL **********
+
+func GetOrCreatePlaidBackfillProgress(userID int, accountID string, monthsRequested int) (*PlaidBackfillProgress, error) {
+	query := "INSERT INTO plaid_backfill_progress (user_id, plaid_account_id, months_requested) VALUES ($1, $2, $3) ON CONFLICT (plaid_account_id) DO UPDATE SET months_requested = GREATEST(plaid_backfill_progress.months_requested, EXCLUDED.months_requested) RETURNING id, user_id, plaid_account_id, months_requested, months_completed, next_offset, status, COALESCE(last_error, ''), created_at, updated_at"
+	var progress PlaidBackfillProgress
+	err := ScopeToUser(userID).QueryRow(query, accountID, monthsRequested).Scan(&progress.ID, &progress.UserID, &progress.PlaidAccountID, &progress.MonthsRequested, &progress.MonthsCompleted, &progress.NextOffset, &progress.Status, &progress.LastError, &progress.CreatedAt, &progress.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get or create plaid backfill progress: %v", err)
+	}
+	return &progress, nil
+}
+
+func UpdatePlaidBackfillProgress(progress PlaidBackfillProgress) error {
+	query := "UPDATE plaid_backfill_progress SET months_completed = $1, next_offset = $2, status = $3, last_error = NULLIF($4, '') WHERE id = $5"
+	_, err := DB.Exec(query, progress.MonthsCompleted, progress.NextOffset, progress.Status, progress.LastError, progress.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update plaid backfill progress: %v", err)
+	}
+	return nil
+}
+
+// ********** MONTHLY SUMMARY **********
+
+// mappedCategoryJoin exposes mc.mapped_category, the budget category a transaction maps to via category_mappings
+const mappedCategoryJoin = " CROSS JOIN LATERAL (SELECT mapped_budget_category(transactions.user_id, transactions.provider_type, transactions.category, transactions.personal_finance_category_primary, transactions.personal_finance_category_detailed) AS mapped_category) mc"
+
+// dailySpendSource reads daily_category_spend aliased as transactions, with the category columns restored to NULL
+// where the transactions had none, so category matching written for transactions works on it unchanged. Each row
+// holds total_amount and transaction_count for its day and category, and spend_amount and spend_count for outflows.
+const dailySpendSource = `(SELECT user_id, NULLIF(provider_type, '') AS provider_type, date, NULLIF(category, 'null'::jsonb) AS category,
+	NULLIF(personal_finance_category_primary, '') AS personal_finance_category_primary,
+	NULLIF(personal_finance_category_detailed, '') AS personal_finance_category_detailed,
+	total_amount, transaction_count, spend_amount, spend_count
+	FROM daily_category_spend) transactions`
+
+// budgetCategoryMatchOn is true when the transaction belongs to the budget category in b.category, given the
+// expression holding its mapped category. It mirrors GetTransactionsByCategory: mapped category first, then
+// personal finance category, then the legacy or Teller category array compared by key (see category_keys).
+func budgetCategoryMatchOn(mappedCategory string) string {
+	return `CASE WHEN ` + mappedCategory + ` IS NOT NULL THEN LOWER(` + mappedCategory + `) = LOWER(b.category)
+	WHEN transactions.personal_finance_category_primary IS NOT NULL
+	THEN transactions.personal_finance_category_primary = UPPER(regexp_replace(trim(b.category), '\s+', '_', 'g'))
+		OR transactions.personal_finance_category_detailed = UPPER(regexp_replace(trim(b.category), '\s+', '_', 'g'))
+	ELSE category_keys(transactions.category) @> ARRAY[UPPER(regexp_replace(trim(b.category), '\s+', '_', 'g'))] END`
+}
+
+// budgetCategoryMatch is budgetCategoryMatchOn for queries using mappedCategoryJoin on the transactions table
+var budgetCategoryMatch = budgetCategoryMatchOn("mc.mapped_category")
+
+// excludedCategoriesMatch is true when the transaction belongs to any of the categories in $4, given with their
+// personal finance category keys in $5 and lowercased in $6 (see excludedCategoryArgs). Rows with a personal
+// finance category are matched on it; older and Teller rows fall back to the keys of their category array.
+// Requires mappedCategoryJoin.
+const excludedCategoriesMatch = "COALESCE(CASE WHEN mc.mapped_category IS NOT NULL" +
+	" THEN LOWER(mc.mapped_category) = ANY($6::text[])" +
+	" WHEN personal_finance_category_primary IS NOT NULL" +
+	" THEN personal_finance_category_primary = ANY($5::text[]) OR personal_finance_category_detailed = ANY($5::text[])" +
+	" ELSE category_keys(category) && $5::text[] END, FALSE)"
+
+// excludedCategoryArgs returns the $4, $5 and $6 parameters for excludedCategoriesMatch
+func excludedCategoryArgs(categories []string) (interface{}, interface{}, interface{}) {
+	categoryKeys := make([]string, 0, len(categories))
+	lowerCategories := make([]string, 0, len(categories))
+	for _, category := range categories {
+		categoryKeys = append(categoryKeys, CategoryKey(category))
+		lowerCategories = append(lowerCategories, strings.ToLower(category))
+	}
+	return pq.Array(categories), pq.Array(categoryKeys), pq.Array(lowerCategories)
+}
+
+// MonthYearStart returns the first day of a MMYYYY month in UTC
+func MonthYearStart(monthYear int) time.Time {
+	return time.Date(monthYear%10000, time.Month(monthYear/10000), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ToMonthYear formats a date as MMYYYY
+func ToMonthYear(t time.Time) int {
+	return int(t.Month())*10000 + t.Year()
+}
+
+// CategoryKey normalizes a budget category name into Plaid's personal finance category format,
+// e.g. "Food and Drink" becomes "FOOD_AND_DRINK"
+func CategoryKey(category string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(category), "_"))
+}
+
+func GetTransactionsExcludingCategories(userID int, categoriesToExclude []string, monthYear int) ([]Transaction, error) {
+	startDate := MonthYearStart(monthYear)
+	return GetTransactionsExcludingCategoriesInRange(userID, categoriesToExclude, startDate, startDate.AddDate(0, 1, 0))
+}
+
+// GetTransactionsExcludingCategoriesInRange returns budgeted transactions between startDate and endDate that match none of the given categories
+func GetTransactionsExcludingCategoriesInRange(userID int, categoriesToExclude []string, startDate time.Time, endDate time.Time) ([]Transaction, error) {
+	monthYear := ToMonthYear(startDate)
+	log.Printf("Getting transactions for user %d, categories to exclude %v, month %d", userID, categoriesToExclude, monthYear)
+	log.Printf("Start date: %s, End date: %s", startDate, endDate)
+	// Build the query to exclude transactions that contain any of the specified categories
+	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type, COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, '') FROM transactions WHERE user_id = $1 AND date >= $2 AND date < $3" + budgetedTransactionFilter
+
+	var rows *sql.Rows
+	var err error
+
+	// If there are categories to exclude, add the exclusion condition
+	if len(categoriesToExclude) > 0 {
+		query = strings.Replace(query, " FROM transactions ", " FROM transactions"+mappedCategoryJoin+" ", 1)
+		query += " AND NOT " + excludedCategoriesMatch
+		categories, categoryKeys, lowerCategories := excludedCategoryArgs(categoriesToExclude)
+		rows, err = ScopeToUser(userID).Query(query, startDate, endDate, categories, categoryKeys, lowerCategories)
+	} else {
+		// If no categories to exclude, just get all transactions
+		rows, err = ScopeToUser(userID).Query(query, startDate, endDate)
+	}
+	if err != nil {
+		log.Printf("Failed to query transactions: %v", err)
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	defer rows.Close()
+	var transactions []Transaction
+	for rows.Next() {
+		var transaction Transaction
+		err := rows.Scan(&transaction.TransactionID, &transaction.UserID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Category, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.ProviderType, &transaction.PersonalFinanceCategoryPrimary, &transaction.PersonalFinanceCategoryDetailed)
+		if err != nil {
+			log.Printf("Failed to scan transaction: %v", err)
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
+
+func GetTransactionsByCategory(userID int, category string, monthYear int) ([]Transaction, error) {
+	startDate := MonthYearStart(monthYear)
+	return GetTransactionsByCategoryInRange(userID, category, startDate, startDate.AddDate(0, 1, 0))
+}
+
+// categoryCandidateKeys and categoryCandidateLegacy list what a transaction matching budget category $4 must carry:
+// a provider category mapped to $4 or the personal finance category key $5, or a legacy array whose keys hold $5
+// or the key of a mapped legacy path's top level. Expects $1 to be the user.
+const (
+	categoryCandidateKeys = `(SELECT array_append(array_agg(provider_category::text), $5::text) FROM category_mappings
+		WHERE LOWER(budget_category) = LOWER($4) AND (user_id = $1 OR user_id IS NULL))`
+	categoryCandidateLegacy = `(SELECT array_append(array_agg(UPPER(regexp_replace(trim(split_part(provider_category, ' > ', 1)), '\s+', '_', 'g'))), $5::text) FROM category_mappings
+		WHERE LOWER(budget_category) = LOWER($4) AND (user_id = $1 OR user_id IS NULL))`
+)
+
+// categoryCandidateFilter narrows a by-category query to rows that could match before the exact CASE runs. Its
+// branches only use predicates the user's personal finance category and category_keys indexes can answer, so the
+// per-row category mapping is evaluated for candidates rather than every transaction in the user's date range.
+const categoryCandidateFilter = " AND (personal_finance_category_primary = ANY(" + categoryCandidateKeys + ")" +
+	" OR personal_finance_category_detailed = ANY(" + categoryCandidateKeys + ")" +
+	" OR category_keys(category) && " + categoryCandidateLegacy + ")"
+
+// GetTransactionsByCategoryInRange returns budgeted transactions between startDate and endDate that belong to the category
+func GetTransactionsByCategoryInRange(userID int, category string, startDate time.Time, endDate time.Time) ([]Transaction, error) {
+	monthYear := ToMonthYear(startDate)
+	log.Printf("Getting transactions for user %d, category %s, month %d", userID, category, monthYear)
+	log.Printf("Start date: %s, End date: %s", startDate, endDate)
+	var rows *sql.Rows
+	var err error
+	// if category == "general" {
+	// 	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type FROM transactions WHERE user_id = $1 AND date BETWEEN $2 AND $3"
+	// 	rows, err = DB.Query(query, userID, startDate, endDate)
+	// 	if err != nil {
+	// 		log.Printf("Failed to query transactions: %v", err)
+	// 		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	// 	}
+	// 	defer rows.Close()
+	// } else {
+	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type, COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, '') FROM transactions" + mappedCategoryJoin + " WHERE user_id = $1 AND date >= $2 AND date < $3" +
+		budgetedTransactionFilter + categoryCandidateFilter +
+		" AND CASE WHEN mc.mapped_category IS NOT NULL" +
+		" THEN LOWER(mc.mapped_category) = LOWER($4)" +
+		" WHEN personal_finance_category_primary IS NOT NULL" +
+		" THEN personal_finance_category_primary = $5 OR personal_finance_category_detailed = $5" +
+		" ELSE category_keys(category) @> ARRAY[$5::text] END"
+	rows, err = ScopeToUser(userID).Query(query, startDate, endDate, category, CategoryKey(category))
+	if err != nil {
+		log.Printf("Failed to query transactions: %v", err)
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	defer rows.Close()
+	// }
+	var transactions []Transaction
+	for rows.Next() {
+		var transaction Transaction
+		err := rows.Scan(&transaction.TransactionID, &transaction.UserID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Category, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.ProviderType, &transaction.PersonalFinanceCategoryPrimary, &transaction.PersonalFinanceCategoryDetailed)
+		if err != nil {
+			log.Printf("Failed to scan transaction: %v", err)
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions, nil
+}
+
+// GetBudgetCategorySpendInRange totals spend between startDate and endDate for each budget category in one pass,
+// matching transactions the same way GetTransactionsByCategoryInRange does. Categories without spend are 0.
+func GetBudgetCategorySpendInRange(userID int, categories []string, startDate time.Time, endDate time.Time) (map[string]money.Money, error) {
+	spend := make(map[string]money.Money, len(categories))
+	if len(categories) == 0 {
+		return spend, nil
+	}
+	// Materialized so each day's mapped category is resolved once rather than once per budget category
+	query := `
+		WITH scoped AS MATERIALIZED (
+			SELECT transactions.total_amount AS amount, transactions.category,
+				transactions.personal_finance_category_primary, transactions.personal_finance_category_detailed,
+				mc.mapped_category
+			FROM ` + dailySpendSource + mappedCategoryJoin + `
+			WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3
+		)
+		SELECT b.category, COALESCE(SUM(transactions.amount), 0)
+		FROM unnest($4::text[]) AS b(category)
+		LEFT JOIN scoped transactions ON ` + budgetCategoryMatchOn("transactions.mapped_category") + `
+		GROUP BY b.category
+	`
+	rows, err := ScopeToUser(userID).Query(query, startDate, endDate, pq.Array(categories))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query budget category spend: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var total money.Money
+		if err := rows.Scan(&category, &total); err != nil {
+			return nil, fmt.Errorf("failed to scan budget category spend: %v", err)
+		}
+		spend[category] = total
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating budget category spend: %v", err)
+	}
+	return spend, nil
+}
+
+// GetSpendExcludingCategoriesInRange totals spend between startDate and endDate that matches none of the given
+// categories, the sum of what GetTransactionsExcludingCategoriesInRange lists
+func GetSpendExcludingCategoriesInRange(userID int, categoriesToExclude []string, startDate time.Time, endDate time.Time) (money.Money, error) {
+	query := "SELECT COALESCE(SUM(transactions.total_amount), 0) FROM " + dailySpendSource + mappedCategoryJoin +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3 AND NOT " + excludedCategoriesMatch
+	categories, categoryKeys, lowerCategories := excludedCategoryArgs(categoriesToExclude)
+	var total money.Money
+	err := ScopeToUser(userID).QueryRow(query, startDate, endDate, categories, categoryKeys, lowerCategories).Scan(&total)
+	if err != nil {
+		return money.Money{}, fmt.Errorf("failed to sum spend excluding categories: %v", err)
+	}
+	return total, nil
+}
+
+func GetAllTransactions(userID int, monthYear int) ([]Transaction, error) {
+	year := monthYear % 10000
+	month := monthYear / 10000
+	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	endDate := startDate.AddDate(0, 1, 0)
+	log.Printf("Getting all transactions for user %d, month %d", userID, monthYear)
+	log.Printf("Start date: %s, End date: %s", startDate, endDate)
+	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type, COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, '') FROM transactions WHERE user_id = $1 AND date >= $2 AND date < $3"
+	rows, err := ScopeToUser(userID).Query(query, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all transactions: %v", err)
+	}
+	defer rows.Close()
+	var transactions []Transaction
+	for rows.Next() {
+		var transaction Transaction
+		err := rows.Scan(&transaction.TransactionID, &transaction.UserID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Category, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.ProviderType, &transaction.PersonalFinanceCategoryPrimary, &transaction.PersonalFinanceCategoryDetailed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
+
+// func GetOrCreateMonthlySummary(userID int, monthYear int) (*MonthlySummary, error) {
+// 	monthlySummary, _ := GetMonthlySummary(userID, monthYear)
+// 	if monthlySummary != nil {
+// 		return monthlySummary, nil
+// 	}
+// 	monthlySummary, err := CreateMonthlySummary(userID, monthYear)
+// 	if err != nil {
+// 		log.Printf("Failed to create monthly summary: %v", err)
+// 		return nil, fmt.Errorf("failed to create monthly summary: %v", err)
+// 	}
+// 	return monthlySummary, nil
+// }
+
+func UpsertMonthlySummary(userID int, monthYear int, totalSpent money.Money, startingBalance money.Money, income money.Money, savedAmount money.Money, invested money.Money, fixedExpenses money.Money, savingTargetPercentage float64, budget money.Money, budgetPeriod string, periodAnchorDate *time.Time) (*MonthlySummary, error) {
+	existingMonthlySummary, _ := GetMonthlySummary(userID, monthYear)
+	if existingMonthlySummary == nil {
+		return CreateMonthlySummary(userID, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budget, budgetPeriod, periodAnchorDate)
+	}
+
+	query := "INSERT INTO monthly_summary (user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT (user_id, monthyear) DO UPDATE SET total_spent = $3, starting_balance = $4, income = $5, saved_amount = $6, invested = $7, fixed_expenses = $8, saving_target_percentage = $9, budget_period = $10, period_anchor_date = $11 RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date, budget_start_date, created_at, updated_at"
+	var monthlySummary MonthlySummary
+
+	err := ScopeToUser(userID).QueryRow(query, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budgetPeriod, periodAnchorDate).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.BudgetPeriod, &monthlySummary.PeriodAnchorDate, &monthlySummary.BudgetStartDate, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
+	if err != nil {
+		log.Printf("Failed to upsert monthly summary: %v", err)
+		return nil, fmt.Errorf("failed to upsert monthly summary: %v", err)
+	}
+	return &monthlySummary, nil
+}
+
+func CreateMonthlySummary(userID int, monthYear int, totalSpent money.Money, startingBalance money.Money, income money.Money, savedAmount money.Money, invested money.Money, fixedExpenses money.Money, savingTargetPercentage float64, budget money.Money, budgetPeriod string, periodAnchorDate *time.Time) (*MonthlySummary, error) {
+	query := "INSERT INTO monthly_summary (user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date, budget_start_date, created_at, updated_at"
+	var monthlySummary MonthlySummary
+
+	err := ScopeToUser(userID).QueryRow(query, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budgetPeriod, periodAnchorDate).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.BudgetPeriod, &monthlySummary.PeriodAnchorDate, &monthlySummary.BudgetStartDate, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
+	if err != nil {
+		log.Printf("Failed to create monthly summary: %v", err)
+		return nil, fmt.Errorf("failed to create monthly summary: %v", err)
+	}
+
+	_, err = CreateMonthlyBudgetSpendCategory(userID, monthlySummary.ID, monthYear, "general", budget, defaultCategoryStrictness)
+	if err != nil {
+		log.Printf("Failed to create monthly budget spend category: %v", err)
+		return nil, fmt.Errorf("failed to create monthly budget spend category: %v", err)
+	}
+	return &monthlySummary, nil
+}
+
+func HasAnyMonthlySummaries(userID int) (bool, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM monthly_summary WHERE user_id = $1"
+	err := ScopeToUser(userID).QueryRow(query).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to count monthly summaries: %v", err)
+	}
+	return count > 0, nil
+}
+
+// HasMonthlySummary reports whether the user has a summary for the month
+func HasMonthlySummary(userID int, monthYear int) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM monthly_summary WHERE user_id = $1 AND monthyear = $2)"
+	err := ScopeToUser(userID).QueryRow(query, monthYear).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check monthly summary: %v", err)
+	}
+	return exists, nil
+}
+
+func GetMonthlySummary(userID int, monthYear int) (*MonthlySummary, error) {
+	query := "SELECT id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date, budget_start_date, created_at, updated_at FROM monthly_summary WHERE user_id = $1 AND monthyear = $2"
+	var monthlySummary MonthlySummary
+	err := ScopeToUser(userID).QueryRow(query, monthYear).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.BudgetPeriod, &monthlySummary.PeriodAnchorDate, &monthlySummary.BudgetStartDate, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
+	if err != nil {
+		log.Printf("Failed to get monthly summary: %v", err)
+		return nil, fmt.Errorf("failed to get monthly summary: %v", err)
+	}
+	return &monthlySummary, nil
+}
+
+func UpdateMonthlySummaryTotalSpent(monthlySummary MonthlySummary) (*MonthlySummary, error) {
+	query := "UPDATE monthly_summary SET total_spent = $2 WHERE user_id = $1 AND id = $3 RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date, budget_start_date, created_at, updated_at"
+	var updatedMonthlySummary MonthlySummary
+	err := ScopeToUser(monthlySummary.UserID).QueryRow(query, monthlySummary.TotalSpent, monthlySummary.ID).Scan(&updatedMonthlySummary.ID, &updatedMonthlySummary.UserID, &updatedMonthlySummary.MonthYear, &updatedMonthlySummary.TotalSpent, &updatedMonthlySummary.StartingBalance, &updatedMonthlySummary.Income, &updatedMonthlySummary.SavedAmount, &updatedMonthlySummary.Invested, &updatedMonthlySummary.FixedExpenses, &updatedMonthlySummary.SavingTargetPercentage, &updatedMonthlySummary.BudgetPeriod, &updatedMonthlySummary.PeriodAnchorDate, &updatedMonthlySummary.BudgetStartDate, &updatedMonthlySummary.CreatedAt, &updatedMonthlySummary.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update monthly summary: %v", err)
+	}
+	return &updatedMonthlySummary, nil
+}
+
+func UpdateMonthlySummary(userID int, monthYear int, totalSpent money.Money, startingBalance money.Money, income money.Money, savedAmount money.Money, invested money.Money, fixedExpenses money.Money, savingTargetPercentage float64, budgetPeriod string, periodAnchorDate *time.Time) (*MonthlySummary, error) {
+	query := "UPDATE monthly_summary SET total_spent = $2, starting_balance = $3, income = $4, saved_amount = $5, invested = $6, fixed_expenses = $7, saving_target_percentage = $8, budget_period = $9, period_anchor_date = $10 WHERE user_id = $1 AND monthyear = $11 RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date, budget_start_date, created_at, updated_at"
+	var monthlySummary MonthlySummary
+	err := ScopeToUser(userID).QueryRow(query, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budgetPeriod, periodAnchorDate, monthYear).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.BudgetPeriod, &monthlySummary.PeriodAnchorDate, &monthlySummary.BudgetStartDate, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update monthly summary: %v", err)
+	}
+	return &monthlySummary, nil
+}
+
+func UpdateMonthlySummaryBudgetStartDate(userID int, monthYear int, budgetStartDate *time.Time) error {
+	query := "UPDATE monthly_summary SET budget_start_date = $2 WHERE user_id = $1 AND monthyear = $3"
+	_, err := ScopeToUser(userID).Exec(query, budgetStartDate, monthYear)
+	if err != nil {
+		return fmt.Errorf("failed to update monthly summary budget start date: %v", err)
+	}
+	return nil
+}
+
+// IncomeBaseline is the monthly income a summary's budget plans around
+type IncomeBaseline struct {
+	Amount money.Money `json:"amount"`
+	// Mode is where Amount came from. Smoothing falls back to the summary's income until there is income to average.
+	Mode string `json:"mode"`
+	// MonthsAveraged is how many complete months a smoothed amount averages, 0 for fixed income
+	MonthsAveraged int `json:"months_averaged"`
+}
+
+// GetSmoothedIncome averages the income detected over up to `months` complete months before the MMYYYY month and
+// returns how many months it averaged. Months before the user's first transaction in that range aren't counted, so
+// new users aren't averaged against months they weren't tracking; later months without income count as nothing.
+func GetSmoothedIncome(userID int, monthYear int, months int) (money.Money, int, error) {
+	monthStart := MonthYearStart(monthYear)
+	query := "SELECT COALESCE(-SUM(amount) FILTER (WHERE amount < 0 AND personal_finance_category_primary = 'INCOME'), 0), MIN(date)" +
+		" FROM transactions WHERE user_id = $1 AND date >= $2 AND date < $3"
+	var total money.Money
+	var firstDate *time.Time
+	if err := ScopeToUser(userID).ReadQueryRow(query, monthStart.AddDate(0, -months, 0), monthStart).Scan(&total, &firstDate); err != nil {
+		return money.Money{}, 0, fmt.Errorf("failed to get smoothed income: %v", err)
+	}
+	if firstDate == nil {
+		return money.Money{}, 0, nil
+	}
+	averaged := (monthStart.Year()-firstDate.Year())*12 + int(monthStart.Month()) - int(firstDate.Month())
+	if averaged <= 0 {
+		return money.Money{}, 0, nil
+	}
+	return total.Prorate(1, int64(averaged)), averaged, nil
+}
+
+// GetIncomeBaseline returns the income the summary's budget plans around: the income entered on the summary, or
+// for users who chose income smoothing, the average of their detected income
+func GetIncomeBaseline(userID int, summary MonthlySummary) (IncomeBaseline, error) {
+	baseline := IncomeBaseline{Amount: summary.Income, Mode: IncomeModeFixed}
+	preferences, err := GetUserPreferences(userID)
+	if err != nil {
+		return IncomeBaseline{}, err
+	}
+	if preferences.IncomeMode != IncomeModeSmoothed {
+		return baseline, nil
+	}
+	smoothed, months, err := GetSmoothedIncome(userID, summary.MonthYear, preferences.IncomeSmoothingMonths)
+	if err != nil {
+		return IncomeBaseline{}, err
+	}
+	if months > 0 && smoothed.IsPositive() {
+		baseline = IncomeBaseline{Amount: smoothed, Mode: IncomeModeSmoothed, MonthsAveraged: months}
+	}
+	return baseline, nil
+}
+
+// ********** BUDGET PERIODS **********
+
+const (
+	BudgetPeriodWeekly   = "weekly"
+	BudgetPeriodBiweekly = "biweekly"
+	BudgetPeriodMonthly  = "monthly"
+)
+
+// IsValidBudgetPeriod reports whether period is one of the supported budgeting periods
+func IsValidBudgetPeriod(period string) bool {
+	return period == BudgetPeriodWeekly || period == BudgetPeriodBiweekly || period == BudgetPeriodMonthly
+}
+
+// BudgetPeriodBounds returns the [start, end) dates of the summary's budgeting period containing now.
+// Monthly periods cover the summary's month. Weekly and biweekly periods repeat from the anchor date,
+// which defaults to the Monday on or before the first of the month.
+func BudgetPeriodBounds(summary MonthlySummary, now time.Time) (time.Time, time.Time) {
+	monthStart := MonthYearStart(summary.MonthYear)
+	if summary.BudgetPeriod != BudgetPeriodWeekly && summary.BudgetPeriod != BudgetPeriodBiweekly {
+		return monthStart, monthStart.AddDate(0, 1, 0)
+	}
+	periodDays := 7
+	if summary.BudgetPeriod == BudgetPeriodBiweekly {
+		periodDays = 14
+	}
+	var anchor time.Time
+	if summary.PeriodAnchorDate != nil {
+		anchor = time.Date(summary.PeriodAnchorDate.Year(), summary.PeriodAnchorDate.Month(), summary.PeriodAnchorDate.Day(), 0, 0, 0, 0, time.UTC)
+	} else {
+		anchor = monthStart.AddDate(0, 0, -((int(monthStart.Weekday()) + 6) % 7))
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	daysSinceAnchor := int(today.Sub(anchor).Hours() / 24)
+	periodsSinceAnchor := daysSinceAnchor / periodDays
+	if daysSinceAnchor < 0 && daysSinceAnchor%periodDays != 0 {
+		periodsSinceAnchor--
+	}
+	start := anchor.AddDate(0, 0, periodsSinceAnchor*periodDays)
+	return start, start.AddDate(0, 0, periodDays)
+}
+
+// DaysInPeriod returns the number of days in [start, end)
+func DaysInPeriod(start time.Time, end time.Time) int {
+	return int(end.Sub(start).Hours()/24 + 0.5)
+}
+
+// DaysIntoPeriod returns how many days of [start, end) have started by now, counting today, clamped to the period length
+func DaysIntoPeriod(start time.Time, end time.Time, now time.Time) int {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if today.Before(start) {
+		return 0
+	}
+	days := DaysInPeriod(start, today) + 1
+	if total := DaysInPeriod(start, end); days > total {
+		return total
+	}
+	return days
+}
+
+// BudgetWindow is the part of a budgeting period that counts towards the budget. It is the whole period
+// unless the user started budgeting part way through it, in which case budgets are prorated.
+type BudgetWindow struct {
+	PeriodStart     time.Time
+	PeriodEnd       time.Time
+	Start           time.Time
+	DaysInPeriod    int
+	DaysInWindow    int
+	DaysIntoWindow  int
+	ProrationFactor float64
+}
+
+// GetBudgetWindow returns the budget window containing now for the summary
+func GetBudgetWindow(summary MonthlySummary, now time.Time) BudgetWindow {
+	periodStart, periodEnd := BudgetPeriodBounds(summary, now)
+	window := BudgetWindow{
+		PeriodStart:     periodStart,
+		PeriodEnd:       periodEnd,
+		Start:           periodStart,
+		DaysInPeriod:    DaysInPeriod(periodStart, periodEnd),
+		ProrationFactor: 1,
+	}
+	if summary.BudgetStartDate != nil {
+		budgetStart := time.Date(summary.BudgetStartDate.Year(), summary.BudgetStartDate.Month(), summary.BudgetStartDate.Day(), 0, 0, 0, 0, time.UTC)
+		if budgetStart.After(periodStart) && budgetStart.Before(periodEnd) {
+			window.Start = budgetStart
+		}
+	}
+	window.DaysInWindow = DaysInPeriod(window.Start, periodEnd)
+	window.DaysIntoWindow = DaysIntoPeriod(window.Start, periodEnd, now)
+	if window.DaysInPeriod > 0 {
+		window.ProrationFactor = float64(window.DaysInWindow) / float64(window.DaysInPeriod)
+	}
+	return window
+}
+
+// ********** MONTHLY BUDGET SPEND CATEGORY **********
+
+func GetMonthlyBudgetSpendCategory(userID int, monthlySummaryID int, monthYear int, category string) (*MonthlyBudgetSpendCategory, error) {
+	query := "SELECT id, user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent, daily_allowance, created_at, updated_at FROM monthly_budget_spend_category WHERE user_id = $1 AND monthly_summary_id = $2 AND month_year = $3 AND category = $4"
+	var monthlyBudgetSpendCategory MonthlyBudgetSpendCategory
+	err := ScopeToUser(userID).QueryRow(query, monthlySummaryID, monthYear, category).Scan(&monthlyBudgetSpendCategory.ID, &monthlyBudgetSpendCategory.UserID, &monthlyBudgetSpendCategory.MonthlySummaryID, &monthlyBudgetSpendCategory.MonthYear, &monthlyBudgetSpendCategory.Category, &monthlyBudgetSpendCategory.Budget, &monthlyBudgetSpendCategory.Strictness, &monthlyBudgetSpendCategory.TotalSpent, &monthlyBudgetSpendCategory.DailyAllowance, &monthlyBudgetSpendCategory.CreatedAt, &monthlyBudgetSpendCategory.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly budget spend category: %v", err)
+	}
+	return &monthlyBudgetSpendCategory, nil
+}
+
+func CreateMonthlyBudgetSpendCategory(userID int, monthlySummaryID int, monthYear int, category string, budget money.Money, strictness string) (*MonthlyBudgetSpendCategory, error) {
+	query := "INSERT INTO monthly_budget_spend_category (user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent, created_at, updated_at"
+	var monthlyBudgetSpendCategory MonthlyBudgetSpendCategory
+	err := ScopeToUser(userID).QueryRow(query, monthlySummaryID, monthYear, category, budget, strictness, 0).Scan(&monthlyBudgetSpendCategory.ID, &monthlyBudgetSpendCategory.UserID, &monthlyBudgetSpendCategory.MonthlySummaryID, &monthlyBudgetSpendCategory.MonthYear, &monthlyBudgetSpendCategory.Category, &monthlyBudgetSpendCategory.Budget, &monthlyBudgetSpendCategory.Strictness, &monthlyBudgetSpendCategory.TotalSpent, &monthlyBudgetSpendCategory.CreatedAt, &monthlyBudgetSpendCategory.UpdatedAt)
+	if err != nil {
+		log.Printf("Failed to create monthly budget spend category: %v", err)
+		return nil, fmt.Errorf("failed to create monthly budget spend category: %v", err)
+	}
+	return &monthlyBudgetSpendCategory, nil
+}
+
+func GetCategoriesToExclude(userID int, monthYear int) ([]string, error) {
+	monthlySummary, err := GetMonthlySummary(userID, monthYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly summary: %w", err)
+	}
+	monthlyBudgetSpendCategories, _, err := GetMonthlyBudgetSpendCategories(userID, monthlySummary.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly budget spend categories: %w", err)
+	}
+	log.Printf("🔄 Monthly budget spend categories: %v", monthlyBudgetSpendCategories)
+	// Build a list of all categories except "general"
+	categoriesToExclude := []string{}
+	for _, category := range monthlyBudgetSpendCategories {
+		if category.Category != "general" {
+			categoriesToExclude = append(categoriesToExclude, category.Category)
+		}
+	}
+	return categoriesToExclude, nil
+}
+
+func GetMonthlyBudgetSpendCategories(userID int, monthlySummaryID int) ([]MonthlyBudgetSpendCategory, money.Money, error) {
+	query := "SELECT id, user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent, daily_allowance, created_at, updated_at FROM monthly_budget_spend_category WHERE user_id = $1 AND monthly_summary_id = $2"
+	var monthlyBudgetSpendCategories []MonthlyBudgetSpendCategory
+	rows, err := ScopeToUser(userID).Query(query, monthlySummaryID)
+	if err != nil {
+		return nil, money.Money{}, fmt.Errorf("failed to get monthly budget spend categories: %v", err)
+	}
+	defer rows.Close()
+	var totalDailyAllowance money.Money
+	for rows.Next() {
+		var monthlyBudgetSpendCategory MonthlyBudgetSpendCategory
+		err := rows.Scan(&monthlyBudgetSpendCategory.ID, &monthlyBudgetSpendCategory.UserID, &monthlyBudgetSpendCategory.MonthlySummaryID, &monthlyBudgetSpendCategory.MonthYear, &monthlyBudgetSpendCategory.Category, &monthlyBudgetSpendCategory.Budget, &monthlyBudgetSpendCategory.Strictness, &monthlyBudgetSpendCategory.TotalSpent, &monthlyBudgetSpendCategory.DailyAllowance, &monthlyBudgetSpendCategory.CreatedAt, &monthlyBudgetSpendCategory.UpdatedAt)
+		if err != nil {
+			return nil, money.Money{}, fmt.Errorf("failed to scan monthly budget spend category: %v", err)
+		}
+		monthlyBudgetSpendCategories = append(monthlyBudgetSpendCategories, monthlyBudgetSpendCategory)
+		totalDailyAllowance = totalDailyAllowance.Add(monthlyBudgetSpendCategory.DailyAllowance)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, money.Money{}, fmt.Errorf("error iterating monthly budget spend categories: %v", err)
+	}
+	return monthlyBudgetSpendCategories, totalDailyAllowance, nil
+}
+
+// BudgetCategoryFilters are the fields a month's budget categories can be filtered and sorted by. progress is the
+// fraction of the budget spent so far.
+var BudgetCategoryFilters = FilterSchema{
+	"category":    {Column: "category", Type: FilterString, Ops: textFilterOps, Sortable: true},
+	"strictness":  {Column: "strictness", Type: FilterString, Ops: stringFilterOps},
+	"budget":      {Column: "budget", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"total_spent": {Column: "total_spent", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"progress":    {Column: "total_spent / NULLIF(budget, 0)", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"over_budget": {Column: "total_spent > budget", Type: FilterBool, Ops: []FilterOp{FilterEq}},
+	"created_at":  {Column: "created_at", Type: FilterDate, Ops: rangeFilterOps, Sortable: true},
+}
+
+// ListMonthlyBudgetSpendCategories returns a page of the summary's budget categories matching the filters, in
+// the order they were created by default, along with the total daily allowance of all its categories
+func ListMonthlyBudgetSpendCategories(userID int, monthlySummaryID int, listQuery ListQuery) ([]MonthlyBudgetSpendCategory, money.Money, error) {
+	scope := ScopeToUser(userID)
+	var totalDailyAllowance money.Money
+	err := scope.ReadQueryRow("SELECT COALESCE(SUM(daily_allowance), 0) FROM monthly_budget_spend_category WHERE user_id = $1 AND monthly_summary_id = $2", monthlySummaryID).
+		Scan(&totalDailyAllowance)
+	if err != nil {
+		return nil, money.Money{}, fmt.Errorf("failed to get total daily allowance: %v", err)
+	}
+	qb := scope.QueryBuilder("user_id")
+	qb.Where("monthly_summary_id = " + qb.Arg(monthlySummaryID))
+	if err := qb.Apply(BudgetCategoryFilters, listQuery.Filters); err != nil {
+		return nil, money.Money{}, err
+	}
+	orderAndPage, err := qb.OrderAndPage(BudgetCategoryFilters, listQuery, "created_at", "id")
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+	query := "SELECT id, user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent, daily_allowance, created_at, updated_at" +
+		" FROM monthly_budget_spend_category" + qb.WhereClause() + orderAndPage
+	rows, err := scope.ReadQuery(query, qb.Args()...)
+	if err != nil {
+		return nil, money.Money{}, fmt.Errorf("failed to list monthly budget spend categories: %v", err)
+	}
+	defer rows.Close()
+	categories := []MonthlyBudgetSpendCategory{}
+	for rows.Next() {
+		var category MonthlyBudgetSpendCategory
+		err := rows.Scan(&category.ID, &category.UserID, &category.MonthlySummaryID, &category.MonthYear, &category.Category, &category.Budget, &category.Strictness, &category.TotalSpent, &category.DailyAllowance, &category.CreatedAt, &category.UpdatedAt)
+		if err != nil {
+			return nil, money.Money{}, fmt.Errorf("failed to scan monthly budget spend category: %v", err)
+		}
+		categories = append(categories, category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, money.Money{}, fmt.Errorf("error iterating monthly budget spend categories: %v", err)
+	}
+	return categories, totalDailyAllowance, nil
+}
+
+// defaultCategoryStrictness is budget.DefaultStrictness, for functions where budget is an amount
+const defaultCategoryStrictness = budget.DefaultStrictness
+
+// MonthlyBudgetSpendCategoryUpdate changes a budget category's settings. Nil fields are left as they are.
+type MonthlyBudgetSpendCategoryUpdate struct {
+	Budget     *money.Money
+	Strictness *string
+}
+
+// UpdateMonthlyBudgetSpendCategorySettings applies the update to one of the user's budget categories and returns
+// it, or nil when the user has no such category
+func UpdateMonthlyBudgetSpendCategorySettings(userID int, categoryID string, update MonthlyBudgetSpendCategoryUpdate) (*MonthlyBudgetSpendCategory, error) {
+	query := "UPDATE monthly_budget_spend_category SET budget = COALESCE($3, budget), strictness = COALESCE($4, strictness)" +
+		" WHERE user_id = $1 AND id::text = $2" +
+		" RETURNING id, user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent, daily_allowance, created_at, updated_at"
+	var category MonthlyBudgetSpendCategory
+	err := ScopeToUser(userID).QueryRow(query, categoryID, update.Budget, update.Strictness).Scan(&category.ID, &category.UserID, &category.MonthlySummaryID, &category.MonthYear, &category.Category, &category.Budget, &category.Strictness, &category.TotalSpent, &category.DailyAllowance, &category.CreatedAt, &category.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update monthly budget spend category: %v", err)
+	}
+	return &category, nil
+}
+
+// BudgetCategoryImport is one category of a budget imported in bulk. A nil Strictness keeps an existing category's
+// strictness, and new categories get the default.
+type BudgetCategoryImport struct {
+	Category   string
+	Budget     money.Money
+	Strictness *string
+}
+
+// ImportBudgetCategories sets the budget of each imported category for the month in one transaction, updating the
+// category when the month already has it, matched case insensitively, and creating it otherwise. It returns the
+// categories created and updated, in import order.
+func ImportBudgetCategories(userID int, monthlySummaryID int, monthYear int, imports []BudgetCategoryImport) ([]MonthlyBudgetSpendCategory, []MonthlyBudgetSpendCategory, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	scope := ScopeToUser(userID).InTx(tx)
+
+	const returning = " RETURNING id, user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent, daily_allowance, created_at, updated_at"
+	updateQuery := "UPDATE monthly_budget_spend_category SET budget = $4, strictness = COALESCE($5, strictness)" +
+		" WHERE user_id = $1 AND monthly_summary_id = $2 AND month_year = $3 AND LOWER(category) = LOWER($6)" + returning
+	insertQuery := "INSERT INTO monthly_budget_spend_category (user_id, monthly_summary_id, month_year, budget, strictness, category, total_spent)" +
+		" VALUES ($1, $2, $3, $4, COALESCE($5, '" + defaultCategoryStrictness + "'), $6, 0)" + returning
+	created, updated := []MonthlyBudgetSpendCategory{}, []MonthlyBudgetSpendCategory{}
+	for _, imported := range imports {
+		var category MonthlyBudgetSpendCategory
+		dest := []interface{}{&category.ID, &category.UserID, &category.MonthlySummaryID, &category.MonthYear, &category.Category, &category.Budget, &category.Strictness, &category.TotalSpent, &category.DailyAllowance, &category.CreatedAt, &category.UpdatedAt}
+		args := []interface{}{monthlySummaryID, monthYear, imported.Budget, imported.Strictness, imported.Category}
+		err := scope.QueryRow(updateQuery, args...).Scan(dest...)
+		if err == nil {
+			updated = append(updated, category)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("failed to update budget category %q: %v", imported.Category, err)
+		}
+		if err := scope.QueryRow(insertQuery, args...).Scan(dest...); err != nil {
+			return nil, nil, fmt.Errorf("failed to create budget category %q: %v", imported.Category, err)
+		}
+		created = append(created, category)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit budget import: %v", err)
+	}
+	return created, updated, nil
+}
+
+func UpdateMonthlyBudgetSpendCategory(monthlyBudgetSpendCategory MonthlyBudgetSpendCategory) error {
+	query := "UPDATE monthly_budget_spend_category SET total_spent = $2, daily_allowance = $3 WHERE user_id = $1 AND id = $4"
+	_, err := ScopeToUser(monthlyBudgetSpendCategory.UserID).Exec(query, monthlyBudgetSpendCategory.TotalSpent, monthlyBudgetSpendCategory.DailyAllowance, monthlyBudgetSpendCategory.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update monthly budget spend category: %v", err)
+	}
+	return nil
+}
+
+// RecordBudgetAllowanceHistory keeps each category's spend and daily allowance as of day, replacing what an
+// earlier run the same day recorded
+func RecordBudgetAllowanceHistory(categories []MonthlyBudgetSpendCategory, day time.Time) error {
+	query := `
+		INSERT INTO budget_allowance_history (budget_category_id, date, total_spent, daily_allowance)
+		VALUES ($1, $2::date, $3, $4)
+		ON CONFLICT (budget_category_id, date) DO UPDATE SET
+			total_spent = EXCLUDED.total_spent,
+			daily_allowance = EXCLUDED.daily_allowance,
+			recorded_at = CURRENT_TIMESTAMP
+	`
+	for _, category := range categories {
+		if _, err := DB.Exec(query, category.ID, day.Format("2006-01-02"), category.TotalSpent, category.DailyAllowance); err != nil {
+			return fmt.Errorf("failed to record budget allowance history: %v", err)
+		}
+	}
+	return nil
+}
+
+// BudgetAllowanceHistoryEntry is a budget category's spend and daily allowance as of one day
+type BudgetAllowanceHistoryEntry struct {
+	Date           time.Time   `json:"date"`
+	TotalSpent     money.Money `json:"total_spent"`
+	DailyAllowance money.Money `json:"daily_allowance"`
+}
+
+// BudgetCategoryDetail is everything about one budget category in the window containing a given day
+type BudgetCategoryDetail struct {
+	// Category has TotalSpent set to its spend in the window
+	Category         MonthlyBudgetSpendCategory
+	Window           BudgetWindow
+	WindowBudget     money.Money
+	AllowanceHistory []BudgetAllowanceHistoryEntry
+	// Transactions is the requested page of the category's transactions in the window, newest first, out of
+	// TransactionCount
+	Transactions     []Transaction
+	TransactionCount int
+}
+
+// GetBudgetCategoryDetail returns one of the user's budget categories with its spend and a page of its
+// transactions in the window containing now, and its allowance history. It returns nil when the user has no such
+// category. Transactions are matched the same way the daily-balance job counts spend, with general holding what
+// the named categories don't claim.
+func GetBudgetCategoryDetail(userID int, categoryID string, limit int, offset int, now time.Time) (*BudgetCategoryDetail, error) {
+	query := "SELECT id, user_id, monthly_summary_id, month_year, category, budget, strictness, total_spent, daily_allowance, created_at, updated_at FROM monthly_budget_spend_category WHERE user_id = $1 AND id::text = $2"
+	var category MonthlyBudgetSpendCategory
+	err := ScopeToUser(userID).QueryRow(query, categoryID).Scan(&category.ID, &category.UserID, &category.MonthlySummaryID, &category.MonthYear, &category.Category, &category.Budget, &category.Strictness, &category.TotalSpent, &category.DailyAllowance, &category.CreatedAt, &category.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly budget spend category: %v", err)
+	}
+	monthlySummary, err := GetMonthlySummary(userID, category.MonthYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly summary: %w", err)
+	}
+	window := GetBudgetWindow(*monthlySummary, now)
+
+	var transactions []Transaction
+	if category.Category == "general" {
+		namedCategories, err := GetCategoriesToExclude(userID, category.MonthYear)
+		if err != nil {
+			return nil, err
+		}
+		if category.TotalSpent, err = GetSpendExcludingCategoriesInRange(userID, namedCategories, window.Start, window.PeriodEnd); err != nil {
+			return nil, fmt.Errorf("failed to calculate spend excluding categories: %w", err)
+		}
+		if transactions, err = GetTransactionsExcludingCategoriesInRange(userID, namedCategories, window.Start, window.PeriodEnd); err != nil {
+			return nil, err
+		}
+	} else {
+		spend, err := GetAttributedCategorySpendInRange(userID, []string{category.Category}, window.Start, window.PeriodEnd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate spend by category: %w", err)
+		}
+		category.TotalSpent = spend[category.Category]
+		if transactions, err = GetTransactionsByCategoryInRange(userID, category.Category, window.Start, window.PeriodEnd); err != nil {
+			return nil, err
+		}
+	}
+	if transactions == nil {
+		transactions = []Transaction{}
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].TransactionDate.After(transactions[j].TransactionDate)
+	})
+	detail := &BudgetCategoryDetail{
+		Category:         category,
+		Window:           window,
+		WindowBudget:     category.Budget.Prorate(int64(window.DaysInWindow), int64(window.DaysInPeriod)),
+		Transactions:     transactions[min(offset, len(transactions)):min(offset+limit, len(transactions))],
+		TransactionCount: len(transactions),
+	}
+
+	rows, err := DB.Query("SELECT date, total_spent, daily_allowance FROM budget_allowance_history WHERE budget_category_id = $1 ORDER BY date", category.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query budget allowance history: %v", err)
+	}
+	defer rows.Close()
+	detail.AllowanceHistory = []BudgetAllowanceHistoryEntry{}
+	for rows.Next() {
+		var entry BudgetAllowanceHistoryEntry
+		if err := rows.Scan(&entry.Date, &entry.TotalSpent, &entry.DailyAllowance); err != nil {
+			return nil, fmt.Errorf("failed to scan budget allowance history: %v", err)
+		}
+		detail.AllowanceHistory = append(detail.AllowanceHistory, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating budget allowance history: %v", err)
+	}
+	return detail, nil
+}
+
+// BudgetAllowances is a summary's allowances for the budget window containing a given day
+type BudgetAllowances struct {
+	Summary MonthlySummary
+	Window  BudgetWindow
+	// Categories have TotalSpent set to their spend in the window and DailyAllowance to their allowance
+	Categories []MonthlyBudgetSpendCategory
+	Allowances []budget.Allowance
+	TotalSpent money.Money
+	// Reserved is what planned expenses set aside from the general budget in the window
+	Reserved money.Money
+}
+
+// CalculateBudgetAllowances sums each budget category's spend in the window containing now and works out the
+// allowances with the strategy. Nothing is saved, so the daily-balance job and allowance simulations can share it.
+func CalculateBudgetAllowances(userID int, monthYear int, strategy budget.Strategy, now time.Time) (*BudgetAllowances, error) {
+	return calculateBudgetAllowances(userID, monthYear, strategy, now, time.Time{})
+}
+
+// CalculateBudgetAllowancesOn works out the allowances as they stood at the end of day, counting only spend up to
+// and including it, to fill in allowance history for days before transactions were imported
+func CalculateBudgetAllowancesOn(userID int, monthYear int, strategy budget.Strategy, day time.Time) (*BudgetAllowances, error) {
+	dayEnd := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	return calculateBudgetAllowances(userID, monthYear, strategy, day, dayEnd)
+}
+
+// calculateBudgetAllowances counts spend in the window up to spendEnd, or the whole window when it is zero
+func calculateBudgetAllowances(userID int, monthYear int, strategy budget.Strategy, now time.Time, spendEnd time.Time) (*BudgetAllowances, error) {
+	monthlySummary, err := GetMonthlySummary(userID, monthYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly summary: %w", err)
+	}
+	categories, _, err := GetMonthlyBudgetSpendCategories(userID, monthlySummary.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly budget spend categories: %w", err)
+	}
+
+	// Category budgets apply to the summary's budgeting period (week, two weeks, or month),
+	// prorated from the budget start date for users who started part way through it
+	window := GetBudgetWindow(*monthlySummary, now)
+	if spendEnd.IsZero() || spendEnd.After(window.PeriodEnd) {
+		spendEnd = window.PeriodEnd
+	}
+
+	// Spend is summed in the database: one grouped query for the named categories, and one for general,
+	// which is everything the named categories don't claim
+	namedCategories := []string{}
+	for _, category := range categories {
+		if category.Category != "general" {
+			namedCategories = append(namedCategories, category.Category)
+		}
+	}
+	spendByCategory, err := GetAttributedCategorySpendInRange(userID, namedCategories, window.Start, spendEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate spend by category: %w", err)
+	}
+
+	// Planned expenses set their monthly share aside from general spending, spread over the window's share of the month
+	monthlyReservation, err := GetPlannedExpenseReservation(userID, monthYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate planned expense reservation: %w", err)
+	}
+	monthStart := MonthYearStart(monthYear)
+	reserved := monthlyReservation.Prorate(int64(window.DaysInWindow), int64(DaysInPeriod(monthStart, monthStart.AddDate(0, 1, 0))))
+
+	result := BudgetAllowances{Summary: *monthlySummary, Window: window, Categories: categories}
+	budgetCategories := make([]budget.Category, 0, len(categories))
+	for i, category := range categories {
+		totalSpent := spendByCategory[category.Category]
+		categoryBudget := category.Budget.Prorate(int64(window.DaysInWindow), int64(window.DaysInPeriod))
+		if category.Category == "general" {
+			totalSpent, err = GetSpendExcludingCategoriesInRange(userID, namedCategories, window.Start, spendEnd)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate spend excluding categories: %w", err)
+			}
+			if reserved.Cmp(categoryBudget) > 0 {
+				reserved = categoryBudget
+			}
+			result.Reserved = reserved
+			categoryBudget = categoryBudget.Sub(reserved)
+		}
+		result.Categories[i].TotalSpent = totalSpent
+		result.TotalSpent = result.TotalSpent.Add(totalSpent)
+		budgetCategories = append(budgetCategories, budget.Category{
+			Name:   category.Category,
+			Budget: categoryBudget,
+			Spent:  totalSpent,
+			Hard:   category.Strictness == budget.StrictnessHard,
+		})
+	}
+
+	result.Allowances = budget.Allocate(budgetCategories, budget.Window{DaysInto: window.DaysIntoWindow, Days: window.DaysInWindow}, strategy)
+	for i, allowance := range result.Allowances {
+		result.Categories[i].DailyAllowance = allowance.Allowance
+	}
+	return &result, nil
+}
+
+// ********** BUDGET PACING **********
+
+const (
+	PacingAhead   = "ahead"
+	PacingOnTrack = "on-track"
+	PacingBehind  = "behind"
+)
+
+// pacingTolerancePercent is how many percentage points spend can run ahead of or behind the window and still be on track
+const pacingTolerancePercent = 5
+
+// CategoryPacing compares how much of a category's budget is used with how much of the budget window has passed.
+// A category is ahead when it is spending faster than the window is elapsing and behind when slower.
+type CategoryPacing struct {
+	Category       string      `json:"category"`
+	Budget         money.Money `json:"budget"`
+	Spent          money.Money `json:"spent"`
+	PercentUsed    float64     `json:"percent_used"`
+	PercentElapsed float64     `json:"percent_elapsed"`
+	// DaysUntilExhausted is how many days of spending at the current daily rate the rest of the budget covers,
+	// nil when nothing has been spent so the budget never runs out
+	DaysUntilExhausted *int   `json:"days_until_exhausted"`
+	Status             string `json:"status"`
+}
+
+// GetBudgetPacing works out each budget category's pacing in the budget window containing now. Spend is read from
+// the daily aggregates, as the daily-balance job reads it, so the two agree.
+func GetBudgetPacing(userID int, monthYear int, now time.Time) ([]CategoryPacing, *BudgetWindow, error) {
+	monthlySummary, err := GetMonthlySummary(userID, monthYear)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get monthly summary: %w", err)
+	}
+	categories, _, err := GetMonthlyBudgetSpendCategories(userID, monthlySummary.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get monthly budget spend categories: %w", err)
+	}
+	window := GetBudgetWindow(*monthlySummary, now)
+
+	namedCategories := []string{}
+	for _, category := range categories {
+		if category.Category != "general" {
+			namedCategories = append(namedCategories, category.Category)
+		}
+	}
+	spendByCategory, err := GetAttributedCategorySpendInRange(userID, namedCategories, window.Start, window.PeriodEnd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to calculate spend by category: %w", err)
+	}
+
+	percentElapsed := 0.0
+	if window.DaysInWindow > 0 {
+		percentElapsed = float64(window.DaysIntoWindow) / float64(window.DaysInWindow) * 100
+	}
+	pacing := make([]CategoryPacing, 0, len(categories))
+	for _, category := range categories {
+		spent := spendByCategory[category.Category]
+		if category.Category == "general" {
+			spent, err = GetSpendExcludingCategoriesInRange(userID, namedCategories, window.Start, window.PeriodEnd)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to calculate spend excluding categories: %w", err)
+			}
+		}
+		pacing = append(pacing, categoryPacing(category.Category, category.Budget.Prorate(int64(window.DaysInWindow), int64(window.DaysInPeriod)), spent, window.DaysIntoWindow, percentElapsed))
+	}
+	return pacing, &window, nil
+}
+
+// categoryPacing paces spent against windowBudget, daysInto days into the window
+func categoryPacing(category string, windowBudget money.Money, spent money.Money, daysInto int, percentElapsed float64) CategoryPacing {
+	pacing := CategoryPacing{
+		Category:       category,
+		Budget:         windowBudget,
+		Spent:          spent,
+		PercentUsed:    math.Round(spent.Ratio(windowBudget)*10000) / 100,
+		PercentElapsed: math.Round(percentElapsed*100) / 100,
+		Status:         PacingOnTrack,
+	}
+	remaining := windowBudget.Sub(spent)
+	switch {
+	case !remaining.IsPositive():
+		days := 0
+		pacing.DaysUntilExhausted = &days
+	case spent.IsPositive() && daysInto > 0:
+		// remaining / (spent / daysInto), rounded down to whole days
+		days := int(remaining.Cents() * int64(daysInto) / spent.Cents())
+		pacing.DaysUntilExhausted = &days
+	}
+
+	if !windowBudget.IsPositive() {
+		if spent.IsPositive() {
+			pacing.Status = PacingAhead
+		}
+		return pacing
+	}
+	if pacing.PercentUsed > percentElapsed+pacingTolerancePercent {
+		pacing.Status = PacingAhead
+	} else if pacing.PercentUsed < percentElapsed-pacingTolerancePercent {
+		pacing.Status = PacingBehind
+	}
+	return pacing
+}
+
+// ********** MONTHLY BALANCE **********
+
+func GetOrCreateMonthlyBalance(userID int, monthYear int) (*MonthlyBalance, error) {
+	monthlyBalance, _ := GetMonthlyBalance(userID, monthYear)
+	if monthlyBalance != nil {
+		return monthlyBalance, nil
+	}
+	monthlyBalance, err := CreateMonthlyBalance(userID, monthYear)
+	if err != nil {
+		log.Printf("Failed to create monthly balance: %v", err)
+		return nil, fmt.Errorf("failed to create monthly balance: %v", err)
+	}
+	return monthlyBalance, nil
+}
+
+func HasAnyMonthlyBalances(userID int) (bool, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM monthly_balance WHERE user_id = $1"
+	err := ScopeToUser(userID).QueryRow(query).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to count monthly balances: %v", err)
+	}
+	return count > 0, nil
+}
+
+// MonthlyBalanceFields are the monthly balance fields that can be computed or set by hand
+var MonthlyBalanceFields = []string{"total_owing", "net_cash", "available_balance", "current_balance"}
+
+const monthlyBalanceColumns = "id, user_id, monthyear, total_owing, net_cash, available_balance, current_balance, manual_fields, computed_at, created_at, updated_at"
+
+func scanMonthlyBalance(row interface{ Scan(...interface{}) error }) (*MonthlyBalance, error) {
+	var monthlyBalance MonthlyBalance
+	err := row.Scan(&monthlyBalance.ID, &monthlyBalance.UserID, &monthlyBalance.MonthYear, &monthlyBalance.TotalOwing, &monthlyBalance.NetCash, &monthlyBalance.AvailableBalance, &monthlyBalance.CurrentBalance, pq.Array(&monthlyBalance.ManualFields), &monthlyBalance.ComputedAt, &monthlyBalance.CreatedAt, &monthlyBalance.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if monthlyBalance.ManualFields == nil {
+		monthlyBalance.ManualFields = []string{}
+	}
+	return &monthlyBalance, nil
+}
+
+func GetMonthlyBalance(userID int, monthYear int) (*MonthlyBalance, error) {
+	query := "SELECT " + monthlyBalanceColumns + " FROM monthly_balance WHERE user_id = $1 AND monthyear = $2"
+	monthlyBalance, err := scanMonthlyBalance(ScopeToUser(userID).QueryRow(query, monthYear))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly balance: %v", err)
+	}
+	return monthlyBalance, nil
+}
+
+func CreateMonthlyBalance(userID int, monthYear int) (*MonthlyBalance, error) {
+	query := "INSERT INTO monthly_balance (user_id, monthyear, total_owing, net_cash, available_balance, current_balance) VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + monthlyBalanceColumns
+	monthlyBalance, err := scanMonthlyBalance(ScopeToUser(userID).QueryRow(query, monthYear, 0, 0, 0, 0))
+	if err != nil {
+		log.Printf("Failed to create monthly balance: %v", err)
+		return nil, fmt.Errorf("failed to create monthly balance: %v", err)
+	}
+	return monthlyBalance, nil
+}
+
+// UpdateMonthlyBalance saves the balances along with which of them were set by hand
+func UpdateMonthlyBalance(userID int, monthYear int, totalOwing money.Money, netCash money.Money, availableBalance money.Money, currentBalance money.Money, manualFields []string) (*MonthlyBalance, error) {
+	query := "UPDATE monthly_balance SET total_owing = $2, net_cash = $3, available_balance = $4, current_balance = $5, manual_fields = $6 WHERE user_id = $1 AND monthyear = $7 RETURNING " + monthlyBalanceColumns
+	monthlyBalance, err := scanMonthlyBalance(ScopeToUser(userID).QueryRow(query, totalOwing, netCash, availableBalance, currentBalance, pq.Array(manualFields), monthYear))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update monthly balance: %v", err)
+	}
+	return monthlyBalance, nil
+}
+
+// ComputeMonthlyBalance writes the user's monthly balance for monthYear from the latest balances of their linked
+// accounts that are included in budgeting and not hidden: total_owing sums credit and loan balances, current_balance and
+// available_balance sum depository accounts, and net_cash is current_balance less total_owing. Fields in
+// manual_fields keep the value the user set, and net_cash is worked out from whichever values end up stored.
+func ComputeMonthlyBalance(userID int, monthYear int) (*MonthlyBalance, error) {
+	query := `
+		WITH computed AS (
+			SELECT
+				COALESCE(SUM(current_balance) FILTER (WHERE account_type IN ('credit', 'loan')), 0) AS total_owing,
+				COALESCE(SUM(current_balance) FILTER (WHERE account_type = 'depository'), 0) AS current_balance,
+				COALESCE(SUM(COALESCE(available_balance, current_balance)) FILTER (WHERE account_type = 'depository'), 0) AS available_balance
+			FROM plaid_accounts
+			WHERE user_id = $1 AND NOT ` + plaidAccountExcludedFromBudget + ` AND NOT ` + plaidAccountHidden + `
+		),
+		existing AS (
+			SELECT total_owing, current_balance, manual_fields FROM monthly_balance WHERE user_id = $1 AND monthyear = $2
+		),
+		effective AS (
+			SELECT
+				CASE WHEN 'total_owing' = ANY(e.manual_fields) THEN e.total_owing ELSE c.total_owing END AS total_owing,
+				CASE WHEN 'current_balance' = ANY(e.manual_fields) THEN e.current_balance ELSE c.current_balance END AS current_balance,
+				c.available_balance
+			FROM computed c LEFT JOIN existing e ON TRUE
+		)
+		INSERT INTO monthly_balance (user_id, monthyear, total_owing, net_cash, available_balance, current_balance, computed_at)
+		SELECT $1, $2, total_owing, current_balance - total_owing, available_balance, current_balance, CURRENT_TIMESTAMP FROM effective
+		ON CONFLICT (user_id, monthyear) DO UPDATE SET
+			total_owing = EXCLUDED.total_owing,
+			current_balance = EXCLUDED.current_balance,
+			net_cash = CASE WHEN 'net_cash' = ANY(monthly_balance.manual_fields) THEN monthly_balance.net_cash ELSE EXCLUDED.net_cash END,
+			available_balance = CASE WHEN 'available_balance' = ANY(monthly_balance.manual_fields) THEN monthly_balance.available_balance ELSE EXCLUDED.available_balance END,
+			computed_at = EXCLUDED.computed_at
+		RETURNING ` + monthlyBalanceColumns
+	monthlyBalance, err := scanMonthlyBalance(ScopeToUser(userID).QueryRow(query, monthYear))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute monthly balance: %v", err)
+	}
+	return monthlyBalance, nil
+}
+
+// GetUsersWithPlaidAccounts returns the users with at least one linked Plaid account
+func GetUsersWithPlaidAccounts() ([]int, error) {
+	rows, err := DB.Query("SELECT DISTINCT user_id FROM plaid_accounts WHERE user_id IS NOT NULL" + activeUserFilter("plaid_accounts.user_id"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users with plaid accounts: %v", err)
+	}
+	defer rows.Close()
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users with plaid accounts: %v", err)
+	}
+	return userIDs, nil
+}
+
+// ********** SAVING GOALS **********
+
+const savingsGoalColumns = "id, user_id, name, currently_saved, total, redeemed, target_date, monthly_pace, required_monthly_contribution, projected_completion_date, calculated_at, archived_at, is_emergency_fund, created_at, updated_at"
+
+func scanSavingsGoal(row interface{ Scan(...interface{}) error }) (*SavingsGoal, error) {
+	var savingsGoal SavingsGoal
+	err := row.Scan(&savingsGoal.ID, &savingsGoal.UserID, &savingsGoal.Name, &savingsGoal.CurrentSaved, &savingsGoal.TotalAmount, &savingsGoal.Redeemed, &savingsGoal.TargetDate, &savingsGoal.MonthlyPace, &savingsGoal.RequiredMonthlyContribution, &savingsGoal.ProjectedCompletionDate, &savingsGoal.CalculatedAt, &savingsGoal.ArchivedAt, &savingsGoal.IsEmergencyFund, &savingsGoal.CreatedAt, &savingsGoal.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &savingsGoal, nil
+}
+
+func GetSavingsGoals(userID int) ([]SavingsGoal, error) {
+	query := "SELECT " + savingsGoalColumns + " FROM saving_goal WHERE user_id = $1"
+	rows, err := ScopeToUser(userID).Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query savings goals: %v", err)
+	}
+	defer rows.Close()
+
+	var savingsGoals []SavingsGoal
+	for rows.Next() {
+		savingsGoal, err := scanSavingsGoal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan savings goal: %v", err)
+		}
+		savingsGoals = append(savingsGoals, *savingsGoal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating savings goals: %v", err)
+	}
+
+	return savingsGoals, nil
+}
+
+// Savings goal statuses, derived from redeemed and archived_at for filtering. Redeeming takes precedence, as
+// redeemed goals are archived too.
+const (
+	SavingsGoalActive   = "active"
+	SavingsGoalRedeemed = "redeemed"
+	SavingsGoalArchived = "archived"
+)
+
+const savingsGoalStatus = "CASE WHEN redeemed THEN 'redeemed' WHEN archived_at IS NOT NULL THEN 'archived' ELSE 'active' END"
+
+// SavingsGoalFilters are the fields savings goal lists can be filtered and sorted by. progress is the fraction of
+// the total saved so far.
+var SavingsGoalFilters = FilterSchema{
+	"status":      {Column: savingsGoalStatus, Type: FilterString, Ops: stringFilterOps},
+	"name":        {Column: "name", Type: FilterString, Ops: textFilterOps, Sortable: true},
+	"total":       {Column: "total", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"progress":    {Column: "currently_saved / NULLIF(total, 0)", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"target_date": {Column: "target_date", Type: FilterDate, Ops: rangeFilterOps, Sortable: true},
+	"created_at":  {Column: "created_at", Type: FilterDate, Ops: rangeFilterOps, Sortable: true},
+}
+
+// ListSavingsGoals returns a page of the user's savings goals matching the filters, newest first by default.
+// Archived and redeemed goals are left out unless the filters ask for a status.
+func ListSavingsGoals(userID int, listQuery ListQuery) ([]SavingsGoal, error) {
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("user_id")
+	if !slices.ContainsFunc(listQuery.Filters, func(filter Filter) bool { return filter.Field == "status" }) {
+		qb.Where("archived_at IS NULL")
+	}
+	if err := qb.Apply(SavingsGoalFilters, listQuery.Filters); err != nil {
+		return nil, err
+	}
+	if listQuery.Sort == "" {
+		listQuery.Desc = true
+	}
+	orderAndPage, err := qb.OrderAndPage(SavingsGoalFilters, listQuery, "created_at", "id")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := scope.ReadQuery("SELECT "+savingsGoalColumns+" FROM saving_goal"+qb.WhereClause()+orderAndPage, qb.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list savings goals: %v", err)
+	}
+	defer rows.Close()
+	savingsGoals := []SavingsGoal{}
+	for rows.Next() {
+		savingsGoal, err := scanSavingsGoal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan savings goal: %v", err)
+		}
+		savingsGoals = append(savingsGoals, *savingsGoal)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating savings goals: %v", err)
+	}
+	return savingsGoals, nil
+}
+
+// SetSavingsGoalArchived archives or restores one of the user's savings goals, returning nil when there is no
+// such goal
+func SetSavingsGoalArchived(userID int, goalID int, archived bool) (*SavingsGoal, error) {
+	query := "UPDATE saving_goal SET archived_at = CASE WHEN $3 THEN COALESCE(archived_at, CURRENT_TIMESTAMP) END" +
+		" WHERE user_id = $1 AND id = $2 RETURNING " + savingsGoalColumns
+	savingsGoal, err := scanSavingsGoal(ScopeToUser(userID).QueryRow(query, goalID, archived))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive savings goal: %v", err)
+	}
+	return savingsGoal, nil
+}
+
+// ErrSavingsGoalRedeemed is returned when redeeming a goal that was already redeemed
+var ErrSavingsGoalRedeemed = errors.New("savings goal is already redeemed")
+
+// SavingsGoalRedemption is the outcome of redeeming a savings goal
+type SavingsGoalRedemption struct {
+	SavingsGoal *SavingsGoal `json:"savings_goal"`
+	// Expense is the occurred planned expense recording the spend, when one was asked for and the goal saved
+	// anything
+	Expense *PlannedExpense `json:"expense"`
+	// MonthlySummary is this month's summary after the redeemed amount came off its saved amount, nil when the
+	// month has no budget
+	MonthlySummary *MonthlySummary `json:"monthly_summary"`
+}
+
+// RedeemSavingsGoal marks one of the user's goals redeemed and archives it, returning nil when there is no such
+// goal. What the goal saved is spent, so it comes off this month's saved amount (not below zero), and with
+// recordExpense it is recorded as a planned expense that occurred today, which reserves nothing from the budget.
+// Round-ups stop accruing to the goal.
+func RedeemSavingsGoal(userID int, goalID int, recordExpense bool) (*SavingsGoalRedemption, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	scope := ScopeToUser(userID).InTx(tx)
+
+	var redeemed bool
+	err = scope.QueryRow("SELECT redeemed FROM saving_goal WHERE user_id = $1 AND id = $2 FOR UPDATE", goalID).Scan(&redeemed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get savings goal: %v", err)
+	}
+	if redeemed {
+		return nil, ErrSavingsGoalRedeemed
+	}
+
+	query := "UPDATE saving_goal SET redeemed = TRUE, archived_at = COALESCE(archived_at, CURRENT_TIMESTAMP)" +
+		" WHERE user_id = $1 AND id = $2 RETURNING " + savingsGoalColumns
+	savingsGoal, err := scanSavingsGoal(scope.QueryRow(query, goalID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem savings goal: %v", err)
+	}
+	redemption := &SavingsGoalRedemption{SavingsGoal: savingsGoal}
+	amount := money.FromFloat(savingsGoal.CurrentSaved)
+	monthYear := ToMonthYear(time.Now())
+
+	if recordExpense && amount.IsPositive() {
+		query := "INSERT INTO planned_expenses (user_id, description, amount, target_monthyear, status, occurred_on)" +
+			" VALUES ($1, $2, $3, $4, 'occurred', CURRENT_DATE) RETURNING " + plannedExpenseColumns
+		expense, err := scanPlannedExpense(scope.QueryRow(query, savingsGoal.Name, amount, monthYear))
+		if err != nil {
+			return nil, fmt.Errorf("failed to record savings goal expense: %v", err)
+		}
+		redemption.Expense = &expense
+	}
+
+	var summary MonthlySummary
+	query = "UPDATE monthly_summary SET saved_amount = GREATEST(saved_amount - $3, 0) WHERE user_id = $1 AND monthyear = $2" +
+		" RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, budget_period, period_anchor_date, budget_start_date, created_at, updated_at"
+	err = scope.QueryRow(query, monthYear, amount).Scan(&summary.ID, &summary.UserID, &summary.MonthYear, &summary.TotalSpent, &summary.StartingBalance, &summary.Income, &summary.SavedAmount, &summary.Invested, &summary.FixedExpenses, &summary.SavingTargetPercentage, &summary.BudgetPeriod, &summary.PeriodAnchorDate, &summary.BudgetStartDate, &summary.CreatedAt, &summary.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to update monthly summary: %v", err)
+	}
+	if err == nil {
+		redemption.MonthlySummary = &summary
+	}
+
+	if _, err := scope.Exec("UPDATE round_up_settings SET saving_goal_id = NULL WHERE user_id = $1 AND saving_goal_id = $2", goalID); err != nil {
+		return nil, fmt.Errorf("failed to detach round-ups from savings goal: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit savings goal redemption: %v", err)
+	}
+	return redemption, nil
+}
+
+func GetSavingsGoal(userID int, goalID int) (*SavingsGoal, error) {
+	query := "SELECT " + savingsGoalColumns + " FROM saving_goal WHERE user_id = $1 AND id = $2"
+	savingsGoal, err := scanSavingsGoal(ScopeToUser(userID).QueryRow(query, goalID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get savings goal: %v", err)
+	}
+	return savingsGoal, nil
+}
+
+func CreateSavingsGoal(userID int, name string, totalAmount float64, currentSaved float64, targetDate *time.Time) (*SavingsGoal, error) {
+	query := "INSERT INTO saving_goal (user_id, name, total, redeemed, currently_saved, target_date) VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + savingsGoalColumns
+	savingsGoal, err := scanSavingsGoal(ScopeToUser(userID).QueryRow(query, name, totalAmount, false, currentSaved, targetDate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create savings goal: %v", err)
+	}
+	return savingsGoal, nil
+}
+
+// AddSavingsGoalContribution records a deposit towards the goal and adds it to the amount saved
+func AddSavingsGoalContribution(userID int, goalID int, amount money.Money, contributedAt time.Time) (*SavingsGoalContribution, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	contribution, err := addSavingsGoalContribution(tx, userID, goalID, amount, contributedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit savings goal contribution: %v", err)
+	}
+	return contribution, nil
+}
+
+func addSavingsGoalContribution(tx *sql.Tx, userID int, goalID int, amount money.Money, contributedAt time.Time) (*SavingsGoalContribution, error) {
+	result, err := ScopeToUser(userID).InTx(tx).Exec("UPDATE saving_goal SET currently_saved = currently_saved + $2 WHERE user_id = $1 AND id = $3", amount, goalID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update savings goal: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("savings goal not found")
+	}
+
+	query := "INSERT INTO saving_goal_contributions (user_id, saving_goal_id, amount, contributed_at) VALUES ($1, $2, $3, $4) RETURNING id, saving_goal_id, user_id, amount, contributed_at"
+	var contribution SavingsGoalContribution
+	err = ScopeToUser(userID).InTx(tx).QueryRow(query, goalID, amount, contributedAt).Scan(&contribution.ID, &contribution.SavingGoalID, &contribution.UserID, &contribution.Amount, &contribution.ContributedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create savings goal contribution: %v", err)
+	}
+	return &contribution, nil
+}
+
+// GetSavingsGoalContributionTotal sums contributions to the user's goal made on or after since
+func GetSavingsGoalContributionTotal(userID int, goalID int, since time.Time) (float64, error) {
+	query := "SELECT COALESCE(SUM(amount), 0) FROM saving_goal_contributions WHERE user_id = $1 AND saving_goal_id = $2 AND contributed_at >= $3"
+	var total float64
+	err := ScopeToUser(userID).QueryRow(query, goalID, since).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum savings goal contributions: %v", err)
+	}
+	return total, nil
+}
+
+// GetUsersWithActiveSavingsGoals returns the ids of users with at least one unredeemed goal
+func GetUsersWithActiveSavingsGoals() ([]int, error) {
+	rows, err := DB.Query("SELECT DISTINCT user_id FROM saving_goal WHERE redeemed = FALSE" + activeUserFilter("saving_goal.user_id"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users with savings goals: %v", err)
+	}
+	defer rows.Close()
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users with savings goals: %v", err)
+	}
+	return userIDs, nil
+}
+
+func UpdateSavingsGoalProjection(savingsGoal SavingsGoal) error {
+	query := "UPDATE saving_goal SET monthly_pace = $2, required_monthly_contribution = $3, projected_completion_date = $4, calculated_at = $5 WHERE user_id = $1 AND id = $6"
+	_, err := ScopeToUser(savingsGoal.UserID).Exec(query, savingsGoal.MonthlyPace, savingsGoal.RequiredMonthlyContribution, savingsGoal.ProjectedCompletionDate, savingsGoal.CalculatedAt, savingsGoal.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update savings goal projection: %v", err)
+	}
+	return nil
+}
+
+// AverageDaysPerMonth converts between daily and monthly rates for projections
+const AverageDaysPerMonth = 365.25 / 12
+
+// SavingsPaceWindowMonths is how many recent months of contributions make up a goal's saving pace
+const SavingsPaceWindowMonths = 3
+
+// ProjectSavingsGoal fills in the goal's computed fields from its monthly saving pace.
+// The required contribution spreads what is left over the months until the target date, or is the
+// whole remainder once the target date has passed. The completion date is only projected while the pace is positive.
+func ProjectSavingsGoal(savingsGoal *SavingsGoal, monthlyPace float64, now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	remaining := savingsGoal.TotalAmount - savingsGoal.CurrentSaved
+	if remaining < 0 {
+		remaining = 0
+	}
+	savingsGoal.MonthlyPace = monthlyPace
+	savingsGoal.CalculatedAt = &now
+
+	savingsGoal.RequiredMonthlyContribution = nil
+	if savingsGoal.TargetDate != nil {
+		required := remaining
+		target := time.Date(savingsGoal.TargetDate.Year(), savingsGoal.TargetDate.Month(), savingsGoal.TargetDate.Day(), 0, 0, 0, 0, time.UTC)
+		if monthsLeft := float64(DaysInPeriod(today, target)) / AverageDaysPerMonth; monthsLeft > 1 {
+			required = remaining / monthsLeft
+		}
+		savingsGoal.RequiredMonthlyContribution = &required
+	}
+
+	savingsGoal.ProjectedCompletionDate = nil
+	if remaining == 0 {
+		savingsGoal.ProjectedCompletionDate = &today
+	} else if monthlyPace > 0 {
+		completion := today.AddDate(0, 0, int(remaining/monthlyPace*AverageDaysPerMonth+0.5))
+		savingsGoal.ProjectedCompletionDate = &completion
+	}
+}
+
+// ********** EMERGENCY FUND **********
+
+// EmergencyFundExpenseMonths is how many complete months of expenses the emergency fund is measured against
+const EmergencyFundExpenseMonths = 6
+
+// EmergencyFund is what the savings goals and accounts a user designated as their emergency fund hold, measured in
+// months of their average expenses
+type EmergencyFund struct {
+	// Designated is false until the user marks a goal or account as their emergency fund
+	Designated bool        `json:"designated"`
+	Balance    money.Money `json:"balance"`
+	// AverageMonthlyExpenses is the fixed expenses entered on each month's summary plus the month's budgeted
+	// spend, averaged over MonthsAveraged complete months
+	AverageMonthlyExpenses money.Money `json:"average_monthly_expenses"`
+	MonthsAveraged         int         `json:"months_averaged"`
+	// MonthsCovered is nil while there are no expenses to measure against
+	MonthsCovered   *float64 `json:"months_covered"`
+	ThresholdMonths *float64 `json:"threshold_months"`
+	BelowThreshold  bool     `json:"below_threshold"`
+}
+
+// SetSavingsGoalEmergencyFund designates one of the user's goals as part of their emergency fund or stops it being
+// one, returning nil when there is no such goal
+func SetSavingsGoalEmergencyFund(userID int, goalID int, isEmergencyFund bool) (*SavingsGoal, error) {
+	query := "UPDATE saving_goal SET is_emergency_fund = $3 WHERE user_id = $1 AND id = $2 RETURNING " + savingsGoalColumns
+	savingsGoal, err := scanSavingsGoal(ScopeToUser(userID).QueryRow(query, goalID, isEmergencyFund))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update savings goal: %v", err)
+	}
+	return savingsGoal, nil
+}
+
+// GetEmergencyFund measures the user's emergency fund against their expenses over the EmergencyFundExpenseMonths
+// complete months before the MMYYYY month. Unredeemed goals count what they have saved and Plaid accounts their
+// current balance; Teller balances aren't stored, so Teller accounts add nothing. Months before the user's first
+// spend or summary in that range aren't averaged.
+func GetEmergencyFund(userID int, monthYear int) (*EmergencyFund, error) {
+	fund := EmergencyFund{}
+	query := `
+		SELECT
+			(SELECT COALESCE(SUM(currently_saved), 0) FROM saving_goal WHERE user_id = $1 AND is_emergency_fund AND NOT redeemed),
+			(SELECT COALESCE(SUM(p.current_balance), 0) FROM plaid_accounts p
+				JOIN account_settings s ON s.user_id = p.user_id AND s.provider_type = 'plaid' AND s.account_ref = p.id
+				WHERE p.user_id = $1 AND s.is_emergency_fund),
+			EXISTS (SELECT 1 FROM saving_goal WHERE user_id = $1 AND is_emergency_fund AND NOT redeemed)
+				OR EXISTS (SELECT 1 FROM account_settings WHERE user_id = $1 AND is_emergency_fund)
+	`
+	var goalBalance, accountBalance money.Money
+	if err := ScopeToUser(userID).ReadQueryRow(query).Scan(&goalBalance, &accountBalance, &fund.Designated); err != nil {
+		return nil, fmt.Errorf("failed to get emergency fund balance: %v", err)
+	}
+	fund.Balance = goalBalance.Add(accountBalance)
+
+	monthStart := MonthYearStart(monthYear)
+	query = `
+		WITH summaries AS (
+			SELECT monthyear, fixed_expenses FROM monthly_summary WHERE user_id = $1
+		)
+		SELECT
+			COALESCE((SELECT SUM(spend_amount) FROM daily_category_spend d
+				WHERE d.user_id = $1 AND d.date >= m.month AND d.date < m.month + INTERVAL '1 month'), 0),
+			s.fixed_expenses IS NOT NULL, COALESCE(s.fixed_expenses, 0)
+		FROM generate_series($2::date, $3::date, INTERVAL '1 month') AS m(month)
+		LEFT JOIN summaries s ON s.monthyear = EXTRACT(MONTH FROM m.month)::int * 10000 + EXTRACT(YEAR FROM m.month)::int
+		ORDER BY m.month
+	`
+	rows, err := ScopeToUser(userID).ReadQuery(query, monthStart.AddDate(0, -EmergencyFundExpenseMonths, 0), monthStart.AddDate(0, -1, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emergency fund expenses: %v", err)
+	}
+	defer rows.Close()
+	var total money.Money
+	for rows.Next() {
+		var spend, fixed money.Money
+		var hasSummary bool
+		if err := rows.Scan(&spend, &hasSummary, &fixed); err != nil {
+			return nil, fmt.Errorf("failed to scan emergency fund expenses: %v", err)
+		}
+		if fund.MonthsAveraged == 0 && spend.IsZero() && !hasSummary {
+			continue
+		}
+		fund.MonthsAveraged++
+		total = total.Add(spend).Add(fixed)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating emergency fund expenses: %v", err)
+	}
+	if fund.MonthsAveraged > 0 {
+		fund.AverageMonthlyExpenses = total.Prorate(1, int64(fund.MonthsAveraged))
+	}
+	if fund.AverageMonthlyExpenses.IsPositive() {
+		covered := math.Round(fund.Balance.Ratio(fund.AverageMonthlyExpenses)*10) / 10
+		fund.MonthsCovered = &covered
+	}
+
+	preferences, err := GetUserPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	fund.ThresholdMonths = preferences.EmergencyFundThresholdMonths
+	fund.BelowThreshold = fund.Designated && fund.ThresholdMonths != nil && fund.MonthsCovered != nil &&
+		*fund.MonthsCovered < *fund.ThresholdMonths
+	return &fund, nil
+}
+
+// GetUsersWithEmergencyFundThreshold returns the ids of users who set a threshold to be alerted below
+func GetUsersWithEmergencyFundThreshold() ([]int, error) {
+	rows, err := DB.Query("SELECT user_id FROM user_preferences WHERE emergency_fund_threshold_months IS NOT NULL" + activeUserFilter("user_preferences.user_id"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users with an emergency fund threshold: %v", err)
+	}
+	defer rows.Close()
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users with an emergency fund threshold: %v", err)
+	}
+	return userIDs, nil
+}
+
+// ********** PLANNED EXPENSES **********
+
+const (
+	PlannedExpensePlanned  = "planned"
+	PlannedExpenseOccurred = "occurred"
+)
+
+// plannedExpenseMatchTolerance is how far, as a fraction of the planned amount, a transaction's amount may be
+// from it and still match, as the final price of a planned purchase is rarely exact
+const plannedExpenseMatchTolerance = 0.1
+
+// PlannedExpense is a one-off expense the budget saves towards each month until its target month
+type PlannedExpense struct {
+	ID              int         `json:"id"`
+	UserID          int         `json:"user_id"`
+	Description     string      `json:"description"`
+	Amount          money.Money `json:"amount"`
+	TargetMonthYear int         `json:"target_monthyear"`
+	Merchant        *string     `json:"merchant"`
+	Status          string      `json:"status"`
+	TransactionID   *string     `json:"transaction_id"`
+	OccurredOn      *time.Time  `json:"occurred_on"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// PlannedExpenseUpdate holds the fields a planned expense update changes; nil fields are left as they are.
+// An empty Merchant clears it.
+type PlannedExpenseUpdate struct {
+	Description     *string
+	Amount          *money.Money
+	TargetMonthYear *int
+	Merchant        *string
+	Status          *string
+}
+
+const plannedExpenseColumns = "id, user_id, description, amount, target_monthyear, merchant, status, transaction_id, occurred_on, created_at, updated_at"
+
+func scanPlannedExpense(row interface{ Scan(...interface{}) error }) (PlannedExpense, error) {
+	var expense PlannedExpense
+	err := row.Scan(&expense.ID, &expense.UserID, &expense.Description, &expense.Amount, &expense.TargetMonthYear, &expense.Merchant, &expense.Status, &expense.TransactionID, &expense.OccurredOn, &expense.CreatedAt, &expense.UpdatedAt)
+	return expense, err
+}
+
+func CreatePlannedExpense(userID int, description string, amount money.Money, targetMonthYear int, merchant *string) (*PlannedExpense, error) {
+	query := "INSERT INTO planned_expenses (user_id, description, amount, target_monthyear, merchant) VALUES ($1, $2, $3, $4, NULLIF($5, ''))" +
+		" RETURNING " + plannedExpenseColumns
+	expense, err := scanPlannedExpense(ScopeToUser(userID).QueryRow(query, description, amount, targetMonthYear, merchant))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create planned expense: %v", err)
+	}
+	return &expense, nil
+}
+
+// GetPlannedExpenses returns the user's planned expenses, optionally only those with the given status, soonest
+// target month first
+func GetPlannedExpenses(userID int, status string) ([]PlannedExpense, error) {
+	query := "SELECT " + plannedExpenseColumns + " FROM planned_expenses WHERE user_id = $1 AND ($2 = '' OR status = $2)" +
+		" ORDER BY target_monthyear % 10000, target_monthyear / 10000, id"
+	rows, err := ScopeToUser(userID).ReadQuery(query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query planned expenses: %v", err)
+	}
+	defer rows.Close()
+	expenses := []PlannedExpense{}
+	for rows.Next() {
+		expense, err := scanPlannedExpense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan planned expense: %v", err)
+		}
+		expenses = append(expenses, expense)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating planned expenses: %v", err)
+	}
+	return expenses, nil
+}
+
+// UpdatePlannedExpense changes one of the user's planned expenses, returning nil when there is no such expense.
+// Setting the status back to planned forgets the matched transaction, and marking it occurred by hand dates it today.
+func UpdatePlannedExpense(userID int, expenseID int, update PlannedExpenseUpdate) (*PlannedExpense, error) {
+	query := `
+		UPDATE planned_expenses SET
+			description = COALESCE($3, description),
+			amount = COALESCE($4, amount),
+			target_monthyear = COALESCE($5, target_monthyear),
+			merchant = CASE WHEN $6::text IS NULL THEN merchant ELSE NULLIF($6::text, '') END,
+			status = COALESCE($7::text, status),
+			transaction_id = CASE WHEN $7::text = 'planned' THEN NULL ELSE transaction_id END,
+			occurred_on = CASE WHEN $7::text = 'planned' THEN NULL
+				WHEN $7::text = 'occurred' THEN COALESCE(occurred_on, CURRENT_DATE) ELSE occurred_on END
+		WHERE user_id = $1 AND id = $2
+		RETURNING ` + plannedExpenseColumns
+	expense, err := scanPlannedExpense(ScopeToUser(userID).QueryRow(query, expenseID, update.Description, update.Amount, update.TargetMonthYear, update.Merchant, update.Status))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update planned expense: %v", err)
+	}
+	return &expense, nil
+}
+
+func DeletePlannedExpense(userID int, expenseID int) error {
+	result, err := ScopeToUser(userID).Exec("DELETE FROM planned_expenses WHERE user_id = $1 AND id = $2", expenseID)
+	if err != nil {
+		return fmt.Errorf("failed to delete planned expense: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("planned expense not found")
+	}
+	return nil
+}
+
+// MonthlyReservation returns what the expense sets aside in the MMYYYY month: an even share of its amount over
+// the months from the one it was planned in through its target month, with the rounding left in the last share.
+// Nothing is set aside outside those months or once the expense has occurred.
+func (e PlannedExpense) MonthlyReservation(monthYear int) money.Money {
+	if e.Status != PlannedExpensePlanned {
+		return money.Money{}
+	}
+	first := time.Date(e.CreatedAt.Year(), e.CreatedAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+	target := MonthYearStart(e.TargetMonthYear)
+	if target.Before(first) {
+		first = target
+	}
+	month := MonthYearStart(monthYear)
+	if month.Before(first) || month.After(target) {
+		return money.Money{}
+	}
+	months := int64((target.Year()-first.Year())*12 + int(target.Month()) - int(first.Month()) + 1)
+	share := e.Amount.Prorate(1, months)
+	if month.Equal(target) {
+		return e.Amount.Sub(share.Prorate(months-1, 1))
+	}
+	return share
+}
+
+// GetPlannedExpenseReservation sums what the user's planned expenses set aside in the MMYYYY month
+func GetPlannedExpenseReservation(userID int, monthYear int) (money.Money, error) {
+	expenses, err := GetPlannedExpenses(userID, PlannedExpensePlanned)
+	if err != nil {
+		return money.Money{}, err
+	}
+	var reserved money.Money
+	for _, expense := range expenses {
+		reserved = reserved.Add(expense.MonthlyReservation(monthYear))
+	}
+	return reserved, nil
+}
+
+// MatchPlannedExpenses marks the user's planned expenses as occurred when an outflow that isn't a transfer
+// appears within plannedExpenseMatchTolerance of the amount, dated from the month before the target month
+// (but not before the expense was planned) through the month after it, and naming the merchant if one is set.
+// Each expense takes its closest candidate and each transaction settles at most one expense. It returns the
+// expenses it marked.
+func MatchPlannedExpenses(ctx context.Context, userID int) ([]PlannedExpense, error) {
+	query := `
+		WITH candidates AS (
+			SELECT p.id AS expense_id, t.id AS matched_transaction_id, t.date AS matched_date,
+				ROW_NUMBER() OVER (PARTITION BY p.id ORDER BY ABS(t.amount - p.amount), t.date, t.id) AS expense_rank,
+				ROW_NUMBER() OVER (PARTITION BY t.id ORDER BY ABS(t.amount - p.amount), p.id) AS transaction_rank
+			FROM planned_expenses p
+			CROSS JOIN LATERAL (SELECT make_date(p.target_monthyear % 10000, p.target_monthyear / 10000, 1) AS target_month) m
+			JOIN transactions t ON t.user_id = p.user_id AND t.amount > 0 AND NOT t.is_transfer AND NOT t.is_flagged
+				AND t.amount BETWEEN p.amount * (1 - $2::numeric) AND p.amount * (1 + $2::numeric)
+				AND t.date >= GREATEST(p.created_at::date, m.target_month - INTERVAL '1 month')
+				AND t.date < m.target_month + INTERVAL '2 months'
+				AND (p.merchant IS NULL OR strpos(LOWER(COALESCE(t.merchant, '') || ' ' || t.description), LOWER(p.merchant)) > 0)
+			WHERE p.user_id = $1 AND p.status = 'planned'
+				AND NOT EXISTS (SELECT 1 FROM planned_expenses o WHERE o.transaction_id = t.id)
+		)
+		UPDATE planned_expenses SET status = 'occurred', transaction_id = matched_transaction_id, occurred_on = matched_date
+		FROM candidates
+		WHERE user_id = $1 AND id = expense_id AND expense_rank = 1 AND transaction_rank = 1
+		RETURNING ` + plannedExpenseColumns
+	rows, err := ScopeToUser(userID).QueryContext(ctx, query, plannedExpenseMatchTolerance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match planned expenses: %v", err)
+	}
+	defer rows.Close()
+	var matched []PlannedExpense
+	for rows.Next() {
+		expense, err := scanPlannedExpense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan planned expense: %v", err)
+		}
+		matched = append(matched, expense)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating matched planned expenses: %v", err)
+	}
+	return matched, nil
+}
+
+// ********** TRIPS **********
+
+// MaxTripDays is the longest a trip can last
+const MaxTripDays = 366
+
+// Trip is a stretch of days, such as a holiday, whose spending is reviewed on its own. Purchases dated within it
+// belong to it, only those made in one of its cities or countries when it has any.
+type Trip struct {
+	ID        int          `json:"id"`
+	UserID    int          `json:"user_id"`
+	Name      string       `json:"name"`
+	StartDate time.Time    `json:"start_date"`
+	EndDate   time.Time    `json:"end_date"`
+	Cities    []string     `json:"cities"`
+	Countries []string     `json:"countries"`
+	Budget    *money.Money `json:"budget"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// TripUpdate holds the fields a trip update changes; nil fields are left as they are. Empty Cities or Countries
+// stop filtering on them, and a zero Budget clears it.
+type TripUpdate struct {
+	Name      *string
+	StartDate *time.Time
+	EndDate   *time.Time
+	Cities    *[]string
+	Countries *[]string
+	Budget    *money.Money
+}
+
+// TripSummary is the spending of a trip: its total, a breakdown by category, city and day, and what is left of its
+// budget
+type TripSummary struct {
+	Trip             Trip            `json:"trip"`
+	TotalSpent       money.Money     `json:"total_spent"`
+	TransactionCount int             `json:"transaction_count"`
+	DailyAverage     money.Money     `json:"daily_average"`
+	RemainingBudget  *money.Money    `json:"remaining_budget"`
+	Categories       []CategorySpend `json:"categories"`
+	Cities           []CitySpend     `json:"cities"`
+	Days             []DaySpend      `json:"days"`
+}
+
+const tripColumns = "id, user_id, name, start_date, end_date, cities, countries, budget, created_at, updated_at"
+
+func scanTrip(row interface{ Scan(...interface{}) error }) (Trip, error) {
+	var trip Trip
+	err := row.Scan(&trip.ID, &trip.UserID, &trip.Name, &trip.StartDate, &trip.EndDate, pq.Array(&trip.Cities), pq.Array(&trip.Countries), &trip.Budget, &trip.CreatedAt, &trip.UpdatedAt)
+	return trip, err
+}
+
+// Days returns how many days the trip lasts, counting both its first and last day
+func (trip Trip) Days() int {
+	return int(trip.EndDate.Sub(trip.StartDate).Hours()/24) + 1
+}
+
+func CreateTrip(userID int, trip Trip) (*Trip, error) {
+	query := "INSERT INTO trips (user_id, name, start_date, end_date, cities, countries, budget) VALUES ($1, $2, $3, $4, $5, $6, $7)" +
+		" RETURNING " + tripColumns
+	created, err := scanTrip(ScopeToUser(userID).QueryRow(query, trip.Name, trip.StartDate, trip.EndDate, pq.Array(trip.Cities), pq.Array(trip.Countries), trip.Budget))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trip: %v", err)
+	}
+	return &created, nil
+}
+
+// GetTrips returns the user's trips, most recent first
+func GetTrips(userID int) ([]Trip, error) {
+	rows, err := ScopeToUser(userID).ReadQuery("SELECT " + tripColumns + " FROM trips WHERE user_id = $1 ORDER BY start_date DESC, id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trips: %v", err)
+	}
+	defer rows.Close()
+	trips := []Trip{}
+	for rows.Next() {
+		trip, err := scanTrip(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trip: %v", err)
+		}
+		trips = append(trips, trip)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trips: %v", err)
+	}
+	return trips, nil
+}
+
+// GetTrip returns one of the user's trips, or nil when there is no such trip
+func GetTrip(userID int, tripID int) (*Trip, error) {
+	trip, err := scanTrip(ScopeToUser(userID).QueryRow("SELECT "+tripColumns+" FROM trips WHERE user_id = $1 AND id = $2", tripID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trip: %v", err)
+	}
+	return &trip, nil
+}
+
+// UpdateTrip changes one of the user's trips, returning nil when there is no such trip
+func UpdateTrip(userID int, tripID int, update TripUpdate) (*Trip, error) {
+	var cities, countries interface{}
+	if update.Cities != nil {
+		cities = pq.Array(*update.Cities)
+	}
+	if update.Countries != nil {
+		countries = pq.Array(*update.Countries)
+	}
+	query := `
+		UPDATE trips SET
+			name = COALESCE($3, name),
+			start_date = COALESCE($4, start_date),
+			end_date = COALESCE($5, end_date),
+			cities = COALESCE($6, cities),
+			countries = COALESCE($7, countries),
+			budget = CASE WHEN $8::numeric IS NULL THEN budget ELSE NULLIF($8::numeric, 0) END
+		WHERE user_id = $1 AND id = $2
+		RETURNING ` + tripColumns
+	trip, err := scanTrip(ScopeToUser(userID).QueryRow(query, tripID, update.Name, update.StartDate, update.EndDate, cities, countries, update.Budget))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update trip: %v", err)
+	}
+	return &trip, nil
+}
+
+func DeleteTrip(userID int, tripID int) error {
+	result, err := ScopeToUser(userID).Exec("DELETE FROM trips WHERE user_id = $1 AND id = $2", tripID)
+	if err != nil {
+		return fmt.Errorf("failed to delete trip: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("trip not found")
+	}
+	return nil
+}
+
+// tripTransactions returns a query builder with the conditions picking the trip's purchases from transactions,
+// and the scope of the trip's user to run it with. Like other spend reports it leaves out transfers and loan
+// payments.
+func tripTransactions(trip Trip) (UserScope, *QueryBuilder) {
+	scope := ScopeToUser(trip.UserID)
+	qb := scope.QueryBuilder("transactions.user_id")
+	qb.Where("transactions.date >= " + qb.Arg(trip.StartDate) + " AND transactions.date <= " + qb.Arg(trip.EndDate))
+	qb.Where(strings.TrimPrefix(reportSpendFilter, " AND "))
+	if len(trip.Cities) == 0 && len(trip.Countries) == 0 {
+		return scope, qb
+	}
+	cities := make([]string, 0, len(trip.Cities))
+	for _, city := range trip.Cities {
+		cities = append(cities, strings.ToLower(city))
+	}
+	qb.Where("(LOWER(transactions.location_city) = ANY(" + qb.Arg(pq.Array(cities)) + ")" +
+		" OR UPPER(transactions.location_country) = ANY(" + qb.Arg(pq.Array(trip.Countries)) + "))")
+	return scope, qb
+}
+
+// ListTripTransactions returns a page of the trip's purchases matching the filters
+func ListTripTransactions(trip Trip, listQuery ListQuery) ([]Transaction, error) {
+	scope, qb := tripTransactions(trip)
+	return listTransactions(scope, qb, listQuery)
+}
+
+// GetTripSummary totals the trip's purchases by category, city and day. Every day of the trip is listed, and the
+// daily average is over the days the trip has lasted so far.
+func GetTripSummary(trip Trip, now time.Time) (*TripSummary, error) {
+	summary := TripSummary{Trip: trip, Categories: []CategorySpend{}, Days: []DaySpend{}}
+
+	scope, qb := tripTransactions(trip)
+	query := "SELECT " + reportCategoryLabel + " AS category, SUM(transactions.amount::numeric), COUNT(*)" +
+		" FROM transactions" + mappedCategoryJoin + qb.WhereClause() + " GROUP BY 1 ORDER BY 2 DESC"
+	rows, err := scope.ReadQuery(query, qb.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trip spend by category: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var spend CategorySpend
+		if err := rows.Scan(&spend.Category, &spend.TotalSpent, &spend.TransactionCount); err != nil {
+			return nil, fmt.Errorf("failed to scan trip category spend: %v", err)
+		}
+		summary.Categories = append(summary.Categories, spend)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trip category spend: %v", err)
+	}
+
+	scope, qb = tripTransactions(trip)
+	query = "SELECT to_char(transactions.date, 'YYYY-MM-DD'), SUM(transactions.amount::numeric), COUNT(*)" +
+		" FROM transactions" + qb.WhereClause() + " GROUP BY 1"
+	dayRows, err := scope.ReadQuery(query, qb.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trip spend by day: %v", err)
+	}
+	defer dayRows.Close()
+	spendByDay := map[string]DaySpend{}
+	for dayRows.Next() {
+		var day DaySpend
+		if err := dayRows.Scan(&day.Date, &day.TotalSpent, &day.TransactionCount); err != nil {
+			return nil, fmt.Errorf("failed to scan trip day spend: %v", err)
+		}
+		spendByDay[day.Date] = day
+	}
+	if err = dayRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trip day spend: %v", err)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	daysSoFar := 0
+	for day := trip.StartDate; !day.After(trip.EndDate); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		spend, ok := spendByDay[date]
+		if !ok {
+			spend = DaySpend{Date: date}
+		}
+		summary.Days = append(summary.Days, spend)
+		summary.TotalSpent = summary.TotalSpent.Add(spend.TotalSpent)
+		summary.TransactionCount += spend.TransactionCount
+		if !day.After(today) {
+			daysSoFar++
+		}
+	}
+	if daysSoFar > 0 {
+		summary.DailyAverage = summary.TotalSpent.Prorate(1, int64(daysSoFar))
+	}
+	if trip.Budget != nil {
+		remaining := trip.Budget.Sub(summary.TotalSpent)
+		summary.RemainingBudget = &remaining
+	}
+
+	scope, qb = tripTransactions(trip)
+	summary.Cities, err = spendByCity(scope, qb, "transactions")
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// ********** ROUND UPS **********
+
+// RoundUpSettings is a user's opt-in to round-up savings and the goal round-ups accrue against
+type RoundUpSettings struct {
+	UserID       int        `json:"user_id"`
+	Enabled      bool       `json:"enabled"`
+	SavingGoalID *int       `json:"saving_goal_id"`
+	EnabledAt    *time.Time `json:"enabled_at"`
+}
+
+// RoundUp is the spare change from a single card purchase
+type RoundUp struct {
+	ID              int       `json:"id"`
+	TransactionID   string    `json:"transaction_id"`
+	SavingGoalID    int       `json:"saving_goal_id"`
+	Amount          float64   `json:"amount"`
+	TransactionDate time.Time `json:"transaction_date"`
+	ContributionID  *int      `json:"contribution_id"`
+	Description     string    `json:"description"`
+}
+
+// GetRoundUpSettings returns the user's round-up settings, or disabled settings if they never opted in
+func GetRoundUpSettings(userID int) (*RoundUpSettings, error) {
+	query := "SELECT user_id, enabled, saving_goal_id, enabled_at FROM round_up_settings WHERE user_id = $1"
+	settings := RoundUpSettings{UserID: userID}
+	var savingGoalID sql.NullInt64
+	err := ScopeToUser(userID).QueryRow(query).Scan(&settings.UserID, &settings.Enabled, &savingGoalID, &settings.EnabledAt)
+	if err == sql.ErrNoRows {
+		return &settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get round up settings: %v", err)
+	}
+	if savingGoalID.Valid {
+		id := int(savingGoalID.Int64)
+		settings.SavingGoalID = &id
+	}
+	return &settings, nil
+}
+
+// UpsertRoundUpSettings saves the user's round-up settings. Round-ups only accrue on purchases made after
+// the feature was last switched on, so enabled_at is reset whenever it goes from off to on.
+func UpsertRoundUpSettings(userID int, enabled bool, savingGoalID *int) (*RoundUpSettings, error) {
+	query := `
+		INSERT INTO round_up_settings (user_id, enabled, saving_goal_id, enabled_at)
+		VALUES ($1, $2, $3, CASE WHEN $2 THEN CURRENT_TIMESTAMP END)
+		ON CONFLICT (user_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			saving_goal_id = EXCLUDED.saving_goal_id,
+			enabled_at = CASE WHEN EXCLUDED.enabled AND NOT round_up_settings.enabled THEN CURRENT_TIMESTAMP ELSE round_up_settings.enabled_at END
+		RETURNING enabled_at
+	`
+	settings := RoundUpSettings{UserID: userID, Enabled: enabled, SavingGoalID: savingGoalID}
+	err := ScopeToUser(userID).QueryRow(query, enabled, savingGoalID).Scan(&settings.EnabledAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert round up settings: %v", err)
+	}
+	return &settings, nil
+}
+
+// AccrueRoundUps records round-ups for the user's posted card purchases made since round-ups were enabled.
+// Card purchases are Plaid outflows paid online or in store, or any outflow on a credit account.
+// Transactions that already have a round-up are skipped, so this is safe to run after every sync.
+func AccrueRoundUps(userID int) (int64, error) {
+	query := `
+		INSERT INTO round_ups (user_id, transaction_id, saving_goal_id, amount, transaction_date)
+		SELECT t.user_id, t.id, s.saving_goal_id, CEIL(t.amount::numeric) - t.amount::numeric, t.date
+		FROM round_up_settings s
+		JOIN transactions t ON t.user_id = s.user_id
+		LEFT JOIN plaid_accounts a ON t.plaid_account_id = a.id
+		WHERE s.user_id = $1 AND s.enabled AND s.saving_goal_id IS NOT NULL
+			AND t.provider_type = 'plaid' AND t.status = 'posted'
+			AND t.date >= s.enabled_at::date
+			AND t.amount::numeric > 0 AND CEIL(t.amount::numeric) > t.amount::numeric
+			AND (t.type IN ('online', 'in store') OR a.account_type = 'credit')
+		ON CONFLICT (transaction_id) DO NOTHING
+	`
+	result, err := ScopeToUser(userID).Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to accrue round ups: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetRoundUps returns the user's round-ups for purchases between startDate and endDate, newest first
+func GetRoundUps(userID int, startDate time.Time, endDate time.Time) ([]RoundUp, error) {
+	query := `
+		SELECT r.id, r.transaction_id, r.saving_goal_id, r.amount, r.transaction_date, r.contribution_id, t.description
+		FROM round_ups r
+		JOIN transactions t ON r.transaction_id = t.id
+		WHERE r.user_id = $1 AND r.transaction_date >= $2 AND r.transaction_date < $3
+		ORDER BY r.transaction_date DESC, r.id DESC
+	`
+	rows, err := ScopeToUser(userID).Query(query, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query round ups: %v", err)
+	}
+	defer rows.Close()
+	var roundUps []RoundUp
+	for rows.Next() {
+		var roundUp RoundUp
+		var contributionID sql.NullInt64
+		if err := rows.Scan(&roundUp.ID, &roundUp.TransactionID, &roundUp.SavingGoalID, &roundUp.Amount, &roundUp.TransactionDate, &contributionID, &roundUp.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan round up: %v", err)
+		}
+		if contributionID.Valid {
+			id := int(contributionID.Int64)
+			roundUp.ContributionID = &id
+		}
+		roundUps = append(roundUps, roundUp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating round ups: %v", err)
+	}
+	return roundUps, nil
+}
+
+// GetUsersWithPendingRoundUps returns the ids of users with round-ups not yet contributed to a goal
+func GetUsersWithPendingRoundUps() ([]int, error) {
+	rows, err := DB.Query("SELECT DISTINCT user_id FROM round_ups WHERE contribution_id IS NULL" + activeUserFilter("round_ups.user_id"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users with pending round ups: %v", err)
+	}
+	defer rows.Close()
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users with pending round ups: %v", err)
+	}
+	return userIDs, nil
+}
+
+// ContributeRoundUps turns the user's pending round-ups into one contribution per savings goal
+// and links each round-up to the contribution it was paid into
+func ContributeRoundUps(userID int, contributedAt time.Time) ([]SavingsGoalContribution, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	scope := ScopeToUser(userID).InTx(tx)
+	rows, err := scope.Query("SELECT id, saving_goal_id, amount FROM round_ups WHERE user_id = $1 AND contribution_id IS NULL FOR UPDATE")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending round ups: %v", err)
+	}
+	pendingAmounts := map[int]money.Money{}
+	pendingIDs := map[int][]int64{}
+	for rows.Next() {
+		var id int64
+		var goalID int
+		var amount money.Money
+		if err := rows.Scan(&id, &goalID, &amount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan pending round up: %v", err)
+		}
+		pendingAmounts[goalID] = pendingAmounts[goalID].Add(amount)
+		pendingIDs[goalID] = append(pendingIDs[goalID], id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pending round ups: %v", err)
+	}
+
+	var contributions []SavingsGoalContribution
+	for goalID, amount := range pendingAmounts {
+		contribution, err := addSavingsGoalContribution(tx, userID, goalID, amount, contributedAt)
+		if err != nil {
+			return nil, err
+		}
+		_, err = scope.Exec("UPDATE round_ups SET contribution_id = $2 WHERE user_id = $1 AND id = ANY($3::int[])", contribution.ID, pq.Array(pendingIDs[goalID]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to link round ups to contribution: %v", err)
+		}
+		contributions = append(contributions, *contribution)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit round up contributions: %v", err)
+	}
+	return contributions, nil
+}
+
+// ********** DEBT PLANS **********
+
+const (
+	DebtStrategyAvalanche = "avalanche"
+	DebtStrategySnowball  = "snowball"
+)
+
+// maxDebtPlanMonths caps payoff simulations, e.g. for debts whose minimum payment never covers the interest
+const maxDebtPlanMonths = 600
+
+// Debt is a liability account pulled from Plaid
+type Debt struct {
+	ID             int       `json:"id"`
+	UserID         int       `json:"user_id"`
+	PlaidAccountID string    `json:"plaid_account_id"`
+	Name           string    `json:"name"`
+	DebtType       string    `json:"debt_type"`
+	Balance        float64   `json:"balance"`
+	APRPercentage  float64   `json:"apr_percentage"`
+	MinimumPayment float64   `json:"minimum_payment"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// DebtPayment is what one debt receives in one month of a payoff schedule
+type DebtPayment struct {
+	DebtID           int     `json:"debt_id"`
+	Name             string  `json:"name"`
+	Payment          float64 `json:"payment"`
+	Interest         float64 `json:"interest"`
+	RemainingBalance float64 `json:"remaining_balance"`
+}
+
+// DebtScheduleMonth is one month of a payoff schedule
+type DebtScheduleMonth struct {
+	Month            int           `json:"month"`
+	Date             string        `json:"date"`
+	Payments         []DebtPayment `json:"payments"`
+	TotalPaid        float64       `json:"total_paid"`
+	TotalInterest    float64       `json:"total_interest"`
+	RemainingBalance float64       `json:"remaining_balance"`
+}
+
+// DebtPayoffResult is the outcome of simulating a payoff strategy
+type DebtPayoffResult struct {
+	Schedule       []DebtScheduleMonth
+	MonthsToPayoff int
+	TotalInterest  float64
+	PaidOff        bool
+}
+
+// DebtPlan is a user's payoff strategy and the schedule generated for it
+type DebtPlan struct {
+	UserID              int                 `json:"user_id"`
+	Strategy            string              `json:"strategy"`
+	ExtraMonthlyPayment float64             `json:"extra_monthly_payment"`
+	MonthsToPayoff      int                 `json:"months_to_payoff"`
+	PayoffDate          *time.Time          `json:"payoff_date"`
+	TotalInterest       float64             `json:"total_interest"`
+	BaselineInterest    float64             `json:"baseline_interest"`
+	InterestSaved       float64             `json:"interest_saved"`
+	Schedule            []DebtScheduleMonth `json:"schedule"`
+	CalculatedAt        *time.Time          `json:"calculated_at"`
+}
+
+func GetPlaidAccessTokensByUserID(userID int) ([]string, error) {
+	rows, err := ScopeToUser(userID).Query("SELECT access_token FROM plaid_tokens WHERE user_id = $1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plaid access tokens: %v", err)
+	}
+	defer rows.Close()
+	var accessTokens []string
+	for rows.Next() {
+		var accessToken string
+		if err := rows.Scan(&accessToken); err != nil {
+			return nil, fmt.Errorf("failed to scan plaid access token: %v", err)
+		}
+		accessTokens = append(accessTokens, accessToken)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plaid access tokens: %v", err)
+	}
+	return accessTokens, nil
+}
+
+// UpsertDebtsFromLiabilities stores each liability as a debt, taking the balance from its account.
+// Credit cards use their purchase APR, falling back to the highest APR reported.
+func UpsertDebtsFromLiabilities(userID int, accounts []plaid.AccountBase, liabilities plaid.LiabilitiesObject) error {
+	type liability struct {
+		accountID      string
+		debtType       string
+		apr            float64
+		minimumPayment float64
+	}
+	var found []liability
+	for _, credit := range liabilities.GetCredit() {
+		apr := 0.0
+		for _, rate := range credit.GetAprs() {
+			if rate.GetAprType() == "purchase_apr" {
+				apr = rate.GetAprPercentage()
+				break
+			}
+			apr = math.Max(apr, rate.GetAprPercentage())
+		}
+		found = append(found, liability{credit.GetAccountId(), "credit", apr, credit.GetMinimumPaymentAmount()})
+	}
+	for _, student := range liabilities.GetStudent() {
+		found = append(found, liability{student.GetAccountId(), "student", student.GetInterestRatePercentage(), student.GetMinimumPaymentAmount()})
+	}
+	for _, mortgage := range liabilities.GetMortgage() {
+		rate := mortgage.GetInterestRate()
+		found = append(found, liability{mortgage.GetAccountId(), "mortgage", rate.GetPercentage(), mortgage.GetNextMonthlyPayment()})
+	}
+
+	accountsByID := map[string]plaid.AccountBase{}
+	for _, account := range accounts {
+		accountsByID[account.GetAccountId()] = account
+	}
+	query := `
+		INSERT INTO debts (user_id, plaid_account_id, name, debt_type, balance, apr_percentage, minimum_payment)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (plaid_account_id) DO UPDATE SET
+			name = EXCLUDED.name, debt_type = EXCLUDED.debt_type, balance = EXCLUDED.balance,
+			apr_percentage = EXCLUDED.apr_percentage, minimum_payment = EXCLUDED.minimum_payment
+	`
+	for _, debt := range found {
+		account, ok := accountsByID[debt.accountID]
+		if !ok || debt.accountID == "" {
+			continue
+		}
+		balance := 0.0
+		if account.Balances.Current.Get() != nil {
+			balance = *account.Balances.Current.Get()
+		}
+		_, err := ScopeToUser(userID).Exec(query, debt.accountID, account.GetName(), debt.debtType, balance, debt.apr, debt.minimumPayment)
+		if err != nil {
+			return fmt.Errorf("failed to upsert debt: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetDebts returns the user's debts with an outstanding balance
+func GetDebts(userID int) ([]Debt, error) {
+	query := "SELECT id, user_id, plaid_account_id, name, debt_type, balance, apr_percentage, minimum_payment, updated_at FROM debts WHERE user_id = $1 AND balance > 0 ORDER BY id"
+	rows, err := ScopeToUser(userID).Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query debts: %v", err)
+	}
+	defer rows.Close()
+	var debts []Debt
+	for rows.Next() {
+		var debt Debt
+		if err := rows.Scan(&debt.ID, &debt.UserID, &debt.PlaidAccountID, &debt.Name, &debt.DebtType, &debt.Balance, &debt.APRPercentage, &debt.MinimumPayment, &debt.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan debt: %v", err)
+		}
+		debts = append(debts, debt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating debts: %v", err)
+	}
+	return debts, nil
+}
+
+func GetDebtPlan(userID int) (*DebtPlan, error) {
+	query := "SELECT user_id, strategy, extra_monthly_payment, months_to_payoff, payoff_date, total_interest, baseline_interest, interest_saved, schedule, calculated_at FROM debt_plans WHERE user_id = $1"
+	var plan DebtPlan
+	var schedule []byte
+	err := ScopeToUser(userID).QueryRow(query).Scan(&plan.UserID, &plan.Strategy, &plan.ExtraMonthlyPayment, &plan.MonthsToPayoff, &plan.PayoffDate, &plan.TotalInterest, &plan.BaselineInterest, &plan.InterestSaved, &schedule, &plan.CalculatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get debt plan: %v", err)
+	}
+	if err := json.Unmarshal(schedule, &plan.Schedule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal debt plan schedule: %v", err)
+	}
+	return &plan, nil
+}
+
+func UpsertDebtPlan(plan DebtPlan) error {
+	schedule, err := json.Marshal(plan.Schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal debt plan schedule: %v", err)
+	}
+	query := `
+		INSERT INTO debt_plans (user_id, strategy, extra_monthly_payment, months_to_payoff, payoff_date, total_interest, baseline_interest, interest_saved, schedule, calculated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (user_id) DO UPDATE SET
+			strategy = EXCLUDED.strategy, extra_monthly_payment = EXCLUDED.extra_monthly_payment,
+			months_to_payoff = EXCLUDED.months_to_payoff, payoff_date = EXCLUDED.payoff_date,
+			total_interest = EXCLUDED.total_interest, baseline_interest = EXCLUDED.baseline_interest,
+			interest_saved = EXCLUDED.interest_saved, schedule = EXCLUDED.schedule, calculated_at = EXCLUDED.calculated_at
+	`
+	_, err = ScopeToUser(plan.UserID).Exec(query, plan.Strategy, plan.ExtraMonthlyPayment, plan.MonthsToPayoff, plan.PayoffDate, plan.TotalInterest, plan.BaselineInterest, plan.InterestSaved, string(schedule), plan.CalculatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert debt plan: %v", err)
+	}
+	return nil
+}
+
+// GetDebtPlanUserIDs returns the ids of users who have a debt plan
+func GetDebtPlanUserIDs() ([]int, error) {
+	rows, err := DB.Query("SELECT d.user_id FROM debt_plans d JOIN users u ON u.user_id = d.user_id WHERE u.deactivated_at IS NULL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query debt plans: %v", err)
+	}
+	defer rows.Close()
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating debt plans: %v", err)
+	}
+	return userIDs, nil
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// minimumDebtPayment is the debt's reported minimum payment, or an estimate of 1% of the balance
+// plus a month's interest (at least $25) when the provider doesn't report one
+func minimumDebtPayment(debt Debt) float64 {
+	if debt.MinimumPayment > 0 {
+		return debt.MinimumPayment
+	}
+	return math.Max(25, debt.Balance*(0.01+debt.APRPercentage/1200))
+}
+
+// SimulateDebtPayoff walks the debts forward month by month from start. Every debt gets its minimum payment.
+// When rollover is true the extra payment, plus the minimums of debts already paid off, goes to the
+// highest-APR debt first for avalanche or the smallest balance first for snowball.
+func SimulateDebtPayoff(debts []Debt, strategy string, extraMonthlyPayment float64, rollover bool, start time.Time) DebtPayoffResult {
+	order := make([]int, len(debts))
+	balances := make([]float64, len(debts))
+	minimums := make([]float64, len(debts))
+	monthlyBudget := extraMonthlyPayment
+	for i, debt := range debts {
+		order[i] = i
+		balances[i] = debt.Balance
+		minimums[i] = minimumDebtPayment(debt)
+		monthlyBudget += minimums[i]
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		debtA, debtB := debts[order[a]], debts[order[b]]
+		if strategy == DebtStrategySnowball {
+			if debtA.Balance != debtB.Balance {
+				return debtA.Balance < debtB.Balance
+			}
+			return debtA.APRPercentage > debtB.APRPercentage
+		}
+		if debtA.APRPercentage != debtB.APRPercentage {
+			return debtA.APRPercentage > debtB.APRPercentage
+		}
+		return debtA.Balance < debtB.Balance
+	})
+
+	result := DebtPayoffResult{PaidOff: true}
+	monthStart := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for month := 1; ; month++ {
+		remaining := 0.0
+		for _, balance := range balances {
+			remaining += balance
+		}
+		if remaining < 0.005 {
+			break
+		}
+		if month > maxDebtPlanMonths {
+			result.PaidOff = false
+			break
+		}
+
+		scheduleMonth := DebtScheduleMonth{
+			Month:    month,
+			Date:     monthStart.AddDate(0, month, 0).Format("2006-01"),
+			Payments: make([]DebtPayment, len(debts)),
+		}
+		available := monthlyBudget
+		for i, debt := range debts {
+			interest := balances[i] * debt.APRPercentage / 100 / 12
+			balances[i] += interest
+			payment := math.Min(minimums[i], balances[i])
+			balances[i] -= payment
+			available -= payment
+			scheduleMonth.Payments[i] = DebtPayment{DebtID: debt.ID, Name: debt.Name, Payment: payment, Interest: interest}
+			scheduleMonth.TotalInterest += interest
+		}
+		if rollover {
+			for _, i := range order {
+				if available <= 0 {
+					break
+				}
+				payment := math.Min(available, balances[i])
+				balances[i] -= payment
+				available -= payment
+				scheduleMonth.Payments[i].Payment += payment
+			}
+		}
+		for i := range scheduleMonth.Payments {
+			scheduleMonth.TotalPaid += scheduleMonth.Payments[i].Payment
+			scheduleMonth.RemainingBalance += balances[i]
+			scheduleMonth.Payments[i].Payment = roundCents(scheduleMonth.Payments[i].Payment)
+			scheduleMonth.Payments[i].Interest = roundCents(scheduleMonth.Payments[i].Interest)
+			scheduleMonth.Payments[i].RemainingBalance = roundCents(balances[i])
+		}
+		result.TotalInterest += scheduleMonth.TotalInterest
+		scheduleMonth.TotalPaid = roundCents(scheduleMonth.TotalPaid)
+		scheduleMonth.TotalInterest = roundCents(scheduleMonth.TotalInterest)
+		scheduleMonth.RemainingBalance = roundCents(scheduleMonth.RemainingBalance)
+		result.Schedule = append(result.Schedule, scheduleMonth)
+		result.MonthsToPayoff = month
+	}
+	result.TotalInterest = roundCents(result.TotalInterest)
+	return result
+}
+
+// BuildDebtPlan generates the payoff schedule for the strategy and compares its interest against
+// paying only the minimums on every debt
+func BuildDebtPlan(userID int, debts []Debt, strategy string, extraMonthlyPayment float64, now time.Time) DebtPlan {
+	planned := SimulateDebtPayoff(debts, strategy, extraMonthlyPayment, true, now)
+	baseline := SimulateDebtPayoff(debts, strategy, 0, false, now)
+	plan := DebtPlan{
+		UserID:              userID,
+		Strategy:            strategy,
+		ExtraMonthlyPayment: extraMonthlyPayment,
+		MonthsToPayoff:      planned.MonthsToPayoff,
+		TotalInterest:       planned.TotalInterest,
+		BaselineInterest:    baseline.TotalInterest,
+		InterestSaved:       roundCents(math.Max(0, baseline.TotalInterest-planned.TotalInterest)),
+		Schedule:            planned.Schedule,
+		CalculatedAt:        &now,
+	}
+	if plan.Schedule == nil {
+		plan.Schedule = []DebtScheduleMonth{}
+	}
+	if planned.PaidOff {
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		payoffDate := monthStart.AddDate(0, planned.MonthsToPayoff+1, -1)
+		plan.PayoffDate = &payoffDate
+	}
+	return plan
+}
+
+// ********** MONTHLY REPORTS **********
+
+// reportCategoryLabel names a transaction's category for reports: its mapped budget category, then its
+// personal finance category, then the top level of the legacy category array. Requires mappedCategoryJoin.
+const reportCategoryLabel = `COALESCE(mc.mapped_category,
+	INITCAP(REPLACE(LOWER(transactions.personal_finance_category_primary), '_', ' ')),
+	CASE WHEN jsonb_typeof(transactions.category) = 'array' THEN transactions.category->>0 END,
+	'Uncategorized')`
+
+// reportSpendFilter limits report spending to budgeted purchases, leaving out inflows and money moved between accounts
+const reportSpendFilter = ` AND transactions.amount::numeric > 0` + reportSpendCategoryFilter + budgetedTransactionFilter
+
+// reportDailySpendFilter is reportSpendFilter for dailySpendSource, whose spend_amount already leaves out inflows
+const reportDailySpendFilter = ` AND transactions.spend_count > 0` + reportSpendCategoryFilter
+
+const reportSpendCategoryFilter = `
+	AND COALESCE(transactions.personal_finance_category_primary, '') NOT IN ('TRANSFER_IN', 'TRANSFER_OUT', 'LOAN_PAYMENTS')`
+
+// ReportCategory is a category's spend in a report month compared with the month before
+type ReportCategory struct {
+	Category           string   `json:"category"`
+	TotalSpent         float64  `json:"total_spent"`
+	PreviousMonthSpent float64  `json:"previous_month_spent"`
+	ChangePct          *float64 `json:"change_pct"`
+}
+
+// ReportPurchase is one of the month's largest purchases
+type ReportPurchase struct {
+	Description string  `json:"description"`
+	Date        string  `json:"date"`
+	Amount      float64 `json:"amount"`
+	Category    string  `json:"category"`
+}
+
+// MonthlyReportData is the content of a "month in review" report
+type MonthlyReportData struct {
+	MonthYear                int              `json:"monthyear"`
+	TotalSpent               float64          `json:"total_spent"`
+	TransactionCount         int              `json:"transaction_count"`
+	PreviousMonthSpent       float64          `json:"previous_month_spent"`
+	ThreeMonthAverageSpent   float64          `json:"three_month_average_spent"`
+	ChangeVsPreviousMonthPct *float64         `json:"change_vs_previous_month_pct"`
+	ChangeVsAveragePct       *float64         `json:"change_vs_average_pct"`
+	Income                   float64          `json:"income"`
+	SavingsRate              *float64         `json:"savings_rate"`
+	TopCategories            []ReportCategory `json:"top_categories"`
+	BiggestPurchases         []ReportPurchase `json:"biggest_purchases"`
+}
+
+// MonthlyReport is a persisted report for one user and month
+type MonthlyReport struct {
+	ID          int               `json:"id"`
+	UserID      int               `json:"user_id"`
+	MonthYear   int               `json:"monthyear"`
+	Report      MonthlyReportData `json:"report"`
+	GeneratedAt time.Time         `json:"generated_at"`
+}
+
+const (
+	reportTopCategories    = 5
+	reportBiggestPurchases = 5
+)
+
+// PercentChange returns the percent change from previous to current, or nil when there is no baseline
+func PercentChange(current float64, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := (current - previous) / previous * 100
+	return &change
+}
+
+// getReportCategoryTotals returns spend per report category between startDate and endDate
+func getReportCategoryTotals(userID int, startDate time.Time, endDate time.Time) (map[string]float64, error) {
+	query := "SELECT " + reportCategoryLabel + ", SUM(transactions.spend_amount) FROM " + dailySpendSource + mappedCategoryJoin +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3" + reportDailySpendFilter +
+		" GROUP BY 1"
+	rows, err := ScopeToUser(userID).Query(query, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query report category totals: %v", err)
+	}
+	defer rows.Close()
+	totals := map[string]float64{}
+	for rows.Next() {
+		var category string
+		var total float64
+		if err := rows.Scan(&category, &total); err != nil {
+			return nil, fmt.Errorf("failed to scan report category total: %v", err)
+		}
+		totals[category] = total
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating report category totals: %v", err)
+	}
+	return totals, nil
+}
+
+// getBiggestPurchases returns the largest purchases between startDate and endDate
+func getBiggestPurchases(userID int, startDate time.Time, endDate time.Time, limit int) ([]ReportPurchase, error) {
+	query := "SELECT transactions.description, transactions.date, transactions.amount::numeric, " + reportCategoryLabel + " FROM transactions" + mappedCategoryJoin +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3" + reportSpendFilter +
+		" ORDER BY transactions.amount::numeric DESC LIMIT $4"
+	rows, err := ScopeToUser(userID).Query(query, startDate, endDate, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query biggest purchases: %v", err)
+	}
+	defer rows.Close()
+	purchases := []ReportPurchase{}
+	for rows.Next() {
+		var purchase ReportPurchase
+		var date time.Time
+		if err := rows.Scan(&purchase.Description, &date, &purchase.Amount, &purchase.Category); err != nil {
+			return nil, fmt.Errorf("failed to scan purchase: %v", err)
+		}
+		purchase.Date = date.Format("2006-01-02")
+		purchases = append(purchases, purchase)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating biggest purchases: %v", err)
+	}
+	return purchases, nil
+}
+
+// getReportSpendTotal returns total spend and the number of purchases between startDate and endDate
+func getReportSpendTotal(userID int, startDate time.Time, endDate time.Time) (float64, int, error) {
+	query := "SELECT COALESCE(SUM(transactions.amount::numeric), 0), COUNT(*) FROM transactions" +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3" + reportSpendFilter
+	var total float64
+	var count int
+	if err := ScopeToUser(userID).QueryRow(query, startDate, endDate).Scan(&total, &count); err != nil {
+		return 0, 0, fmt.Errorf("failed to get report spend total: %v", err)
+	}
+	return total, count, nil
+}
+
+// getIncomeTotal returns the income deposited between startDate and endDate as a positive amount
+func getIncomeTotal(userID int, startDate time.Time, endDate time.Time) (float64, error) {
+	query := "SELECT COALESCE(-SUM(amount::numeric), 0) FROM transactions WHERE user_id = $1 AND date >= $2 AND date < $3 AND amount::numeric < 0 AND personal_finance_category_primary = 'INCOME'"
+	var total float64
+	if err := ScopeToUser(userID).QueryRow(query, startDate, endDate).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to get income total: %v", err)
+	}
+	return total, nil
+}
+
+// BuildMonthlyReport gathers the month's spending, top categories, biggest purchases and savings rate,
+// compared against the previous month and the three months before it. Income comes from the monthly
+// summary when the user has set one, otherwise from detected income deposits.
+func BuildMonthlyReport(userID int, monthYear int) (*MonthlyReportData, error) {
+	monthStart := MonthYearStart(monthYear)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	previousStart := monthStart.AddDate(0, -1, 0)
+
+	report := MonthlyReportData{MonthYear: monthYear}
+	var err error
+	report.TotalSpent, report.TransactionCount, err = getReportSpendTotal(userID, monthStart, monthEnd)
+	if err != nil {
+		return nil, err
+	}
+	report.PreviousMonthSpent, _, err = getReportSpendTotal(userID, previousStart, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	threeMonthTotal, _, err := getReportSpendTotal(userID, monthStart.AddDate(0, -3, 0), monthStart)
+	if err != nil {
+		return nil, err
+	}
+	report.ThreeMonthAverageSpent = threeMonthTotal / 3
+	report.ChangeVsPreviousMonthPct = PercentChange(report.TotalSpent, report.PreviousMonthSpent)
+	report.ChangeVsAveragePct = PercentChange(report.TotalSpent, report.ThreeMonthAverageSpent)
+
+	if summary, err := GetMonthlySummary(userID, monthYear); err == nil && summary.Income.IsPositive() {
+		report.Income = summary.Income.Float64()
+	} else {
+		report.Income, err = getIncomeTotal(userID, monthStart, monthEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if report.Income > 0 {
+		savingsRate := (report.Income - report.TotalSpent) / report.Income * 100
+		report.SavingsRate = &savingsRate
+	}
+
+	categoryTotals, err := getReportCategoryTotals(userID, monthStart, monthEnd)
+	if err != nil {
+		return nil, err
+	}
+	previousCategoryTotals, err := getReportCategoryTotals(userID, previousStart, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	report.TopCategories = []ReportCategory{}
+	for category, total := range categoryTotals {
+		report.TopCategories = append(report.TopCategories, ReportCategory{
+			Category:           category,
+			TotalSpent:         total,
+			PreviousMonthSpent: previousCategoryTotals[category],
+			ChangePct:          PercentChange(total, previousCategoryTotals[category]),
+		})
+	}
+	sort.Slice(report.TopCategories, func(i, j int) bool {
+		return report.TopCategories[i].TotalSpent > report.TopCategories[j].TotalSpent
+	})
+	if len(report.TopCategories) > reportTopCategories {
+		report.TopCategories = report.TopCategories[:reportTopCategories]
+	}
+
+	report.BiggestPurchases, err = getBiggestPurchases(userID, monthStart, monthEnd, reportBiggestPurchases)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func UpsertMonthlyReport(userID int, monthYear int, report MonthlyReportData) (*MonthlyReport, error) {
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal monthly report: %v", err)
+	}
+	query := "INSERT INTO monthly_reports (user_id, monthyear, report) VALUES ($1, $2, $3) ON CONFLICT (user_id, monthyear) DO UPDATE SET report = EXCLUDED.report, generated_at = CURRENT_TIMESTAMP RETURNING id, generated_at"
+	monthlyReport := MonthlyReport{UserID: userID, MonthYear: monthYear, Report: report}
+	if err := ScopeToUser(userID).QueryRow(query, monthYear, string(reportJSON)).Scan(&monthlyReport.ID, &monthlyReport.GeneratedAt); err != nil {
+		return nil, fmt.Errorf("failed to upsert monthly report: %v", err)
+	}
+	return &monthlyReport, nil
+}
+
+func GetMonthlyReport(userID int, monthYear int) (*MonthlyReport, error) {
+	query := "SELECT id, user_id, monthyear, report, generated_at FROM monthly_reports WHERE user_id = $1 AND monthyear = $2"
+	var monthlyReport MonthlyReport
+	var reportJSON []byte
+	err := ScopeToUser(userID).ReadQueryRow(query, monthYear).Scan(&monthlyReport.ID, &monthlyReport.UserID, &monthlyReport.MonthYear, &reportJSON, &monthlyReport.GeneratedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly report: %v", err)
+	}
+	if err := json.Unmarshal(reportJSON, &monthlyReport.Report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal monthly report: %v", err)
+	}
+	return &monthlyReport, nil
+}
+
+// GetUsersMissingMonthlyReport returns the ids of users with transactions in the month but no report for it yet
+func GetUsersMissingMonthlyReport(monthYear int) ([]int, error) {
+	monthStart := MonthYearStart(monthYear)
+	query := `
+		SELECT DISTINCT t.user_id FROM transactions t
+		WHERE t.date >= $1 AND t.date < $2
+			AND NOT EXISTS (SELECT 1 FROM monthly_reports r WHERE r.user_id = t.user_id AND r.monthyear = $3)` +
+		activeUserFilter("t.user_id")
+	rows, err := DB.Query(query, monthStart, monthStart.AddDate(0, 1, 0), monthYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users missing monthly report: %v", err)
+	}
+	defer rows.Close()
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users missing monthly report: %v", err)
+	}
+	return userIDs, nil
+}
+
+// ********** USER PREFERENCES **********
+
+const (
+	DigestFrequencyNone    = "none"
+	DigestFrequencyWeekly  = "weekly"
+	DigestFrequencyMonthly = "monthly"
+)
+
+const (
+	// IncomeModeFixed budgets from the income entered on the monthly summary
+	IncomeModeFixed = "fixed"
+	// IncomeModeSmoothed budgets from a rolling average of detected income, for users paid irregularly
+	IncomeModeSmoothed = "smoothed"
+)
+
+const (
+	DefaultIncomeSmoothingMonths = 6
+	MinIncomeSmoothingMonths     = 2
+	MaxIncomeSmoothingMonths     = 24
+)
+
+// DefaultTimezone is the time zone of users who haven't set theirs
+const DefaultTimezone = "UTC"
+
+// How many months of transaction history Plaid accounts can be backfilled with. Users get the most unless they
+// ask for less.
+const (
+	DefaultSyncHistoryMonths = MaxSyncHistoryMonths
+	MinSyncHistoryMonths     = 1
+	MaxSyncHistoryMonths     = 24
+)
+
+// UserPreferences holds a user's notification and budgeting settings. Users without a row get the defaults.
+type UserPreferences struct {
+	UserID           int        `json:"user_id"`
+	DigestFrequency  string     `json:"digest_frequency"`
+	UnsubscribeToken string     `json:"-"`
+	LastDigestSentAt *time.Time `json:"last_digest_sent_at"`
+	// AllowanceStrategy is how allowances are shared between budget categories, nil for the default strategy
+	AllowanceStrategy *string `json:"allowance_strategy"`
+	// DigestEmail is false when the digest only goes to the user's notification channels
+	DigestEmail bool `json:"digest_email"`
+	// IncomeMode is where the income baseline comes from, and IncomeSmoothingMonths how many months smoothing averages
+	IncomeMode            string `json:"income_mode"`
+	IncomeSmoothingMonths int    `json:"income_smoothing_months"`
+	// EmergencyFundThresholdMonths is the months of expenses the emergency fund should cover, nil for no alerts
+	EmergencyFundThresholdMonths *float64 `json:"emergency_fund_threshold_months"`
+	// Timezone is the user's IANA time zone, which decides when their day starts
+	Timezone string `json:"timezone"`
+	// SyncHistoryMonths is how many months of history newly linked accounts are backfilled with
+	SyncHistoryMonths int `json:"sync_history_months"`
+}
+
+// UserPreferencesUpdate changes the preferences that are set. An empty AllowanceStrategy goes back to the default,
+// and a zero EmergencyFundThresholdMonths turns emergency fund alerts off.
+type UserPreferencesUpdate struct {
+	DigestFrequency              *string
+	AllowanceStrategy            *string
+	DigestEmail                  *bool
+	IncomeMode                   *string
+	IncomeSmoothingMonths        *int
+	EmergencyFundThresholdMonths *float64
+	Timezone                     *string
+	SyncHistoryMonths            *int
+}
+
+const userPreferencesColumns = "user_id, digest_frequency, unsubscribe_token, last_digest_sent_at, allowance_strategy, digest_email, income_mode, income_smoothing_months, emergency_fund_threshold_months, timezone, sync_history_months"
+
+func scanUserPreferences(row interface{ Scan(...interface{}) error }, preferences *UserPreferences) error {
+	return row.Scan(&preferences.UserID, &preferences.DigestFrequency, &preferences.UnsubscribeToken, &preferences.LastDigestSentAt, &preferences.AllowanceStrategy, &preferences.DigestEmail, &preferences.IncomeMode, &preferences.IncomeSmoothingMonths, &preferences.EmergencyFundThresholdMonths, &preferences.Timezone, &preferences.SyncHistoryMonths)
+}
+
+func IsValidDigestFrequency(frequency string) bool {
+	return frequency == DigestFrequencyNone || frequency == DigestFrequencyWeekly || frequency == DigestFrequencyMonthly
+}
+
+func IsValidIncomeMode(mode string) bool {
+	return mode == IncomeModeFixed || mode == IncomeModeSmoothed
+}
+
+func GetUserPreferences(userID int) (*UserPreferences, error) {
+	query := "SELECT " + userPreferencesColumns + " FROM user_preferences WHERE user_id = $1"
+	preferences := UserPreferences{UserID: userID, DigestFrequency: DigestFrequencyNone, DigestEmail: true, IncomeMode: IncomeModeFixed, IncomeSmoothingMonths: DefaultIncomeSmoothingMonths, Timezone: DefaultTimezone, SyncHistoryMonths: DefaultSyncHistoryMonths}
+	err := scanUserPreferences(ScopeToUser(userID).QueryRow(query), &preferences)
+	if err == sql.ErrNoRows {
+		return &preferences, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user preferences: %v", err)
+	}
+	return &preferences, nil
+}
+
+func UpdateUserPreferences(userID int, update UserPreferencesUpdate) (*UserPreferences, error) {
+	query := `INSERT INTO user_preferences (user_id, digest_frequency, allowance_strategy, digest_email, income_mode, income_smoothing_months, emergency_fund_threshold_months, timezone, sync_history_months)
+		VALUES ($1, COALESCE($2, 'none'), NULLIF($3, ''), COALESCE($4, TRUE), COALESCE($5, 'fixed'), COALESCE($6, 6), NULLIF($7::numeric, 0), COALESCE($8, 'UTC'), COALESCE($9, 24))
+		ON CONFLICT (user_id) DO UPDATE SET
+			digest_frequency = COALESCE($2, user_preferences.digest_frequency),
+			allowance_strategy = CASE WHEN $3::text IS NULL THEN user_preferences.allowance_strategy ELSE NULLIF($3, '') END,
+			digest_email = COALESCE($4, user_preferences.digest_email),
+			income_mode = COALESCE($5, user_preferences.income_mode),
+			income_smoothing_months = COALESCE($6, user_preferences.income_smoothing_months),
+			emergency_fund_threshold_months = CASE WHEN $7::numeric IS NULL THEN user_preferences.emergency_fund_threshold_months ELSE NULLIF($7::numeric, 0) END,
+			timezone = COALESCE($8, user_preferences.timezone),
+			sync_history_months = COALESCE($9, user_preferences.sync_history_months)
+		RETURNING ` + userPreferencesColumns
+	var preferences UserPreferences
+	err := scanUserPreferences(ScopeToUser(userID).QueryRow(query, update.DigestFrequency, update.AllowanceStrategy, update.DigestEmail, update.IncomeMode, update.IncomeSmoothingMonths, update.EmergencyFundThresholdMonths, update.Timezone, update.SyncHistoryMonths), &preferences)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user preferences: %v", err)
+	}
+	return &preferences, nil
+}
+
+// GetDailyBalanceUsersByTimezone returns the active users with a budget, grouped by their time zone. Users who
+// haven't set one are in DefaultTimezone.
+func GetDailyBalanceUsersByTimezone() (map[string][]int, error) {
+	query := `SELECT u.user_id, COALESCE(p.timezone, '` + DefaultTimezone + `')
+		FROM users u
+		LEFT JOIN user_preferences p ON p.user_id = u.user_id
+		WHERE EXISTS (SELECT 1 FROM monthly_summary s WHERE s.user_id = u.user_id)` + activeUserFilter("u.user_id") + `
+		ORDER BY u.user_id`
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users by timezone: %v", err)
+	}
+	defer rows.Close()
+	usersByTimezone := map[string][]int{}
+	for rows.Next() {
+		var userID int
+		var timezone string
+		if err := rows.Scan(&userID, &timezone); err != nil {
+			return nil, fmt.Errorf("failed to scan user timezone: %v", err)
+		}
+		usersByTimezone[timezone] = append(usersByTimezone[timezone], userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users by timezone: %v", err)
+	}
+	return usersByTimezone, nil
+}
+
+// UnsubscribeDigest stops emailing the digest to whoever owns the unsubscribe token, reporting whether the token
+// matched. Their notification channels keep receiving it.
+func UnsubscribeDigest(token string) (bool, error) {
+	query := "UPDATE user_preferences SET digest_email = FALSE WHERE unsubscribe_token::text = $1"
+	result, err := DB.Exec(query, token)
+	if err != nil {
+		return false, fmt.Errorf("failed to unsubscribe digest: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// ********** DASHBOARD CONFIG **********
+
+const (
+	DashboardWidgetSafeToSpend = "safe_to_spend"
+	DashboardWidgetGoals       = "goals"
+	DashboardWidgetPacing      = "pacing"
+	DashboardWidgetNetWorth    = "net_worth"
+)
+
+// DefaultDashboardWidgets is the layout of users who haven't arranged their dashboard
+var DefaultDashboardWidgets = []string{DashboardWidgetSafeToSpend, DashboardWidgetPacing, DashboardWidgetGoals, DashboardWidgetNetWorth}
+
+// DashboardConfig is the widgets a user shows on their dashboard, in order
+type DashboardConfig struct {
+	Widgets   []string   `json:"widgets"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// ValidateDashboardWidgets checks that every widget is known and appears at most once
+func ValidateDashboardWidgets(widgets []string) error {
+	seen := make(map[string]bool, len(widgets))
+	for _, widget := range widgets {
+		if !slices.Contains(DefaultDashboardWidgets, widget) {
+			return fmt.Errorf("unknown widget %q", widget)
+		}
+		if seen[widget] {
+			return fmt.Errorf("widget %q is listed more than once", widget)
+		}
+		seen[widget] = true
+	}
+	return nil
+}
+
+// GetDashboardConfig returns the user's dashboard layout, or the default layout if they haven't saved one
+func GetDashboardConfig(userID int) (*DashboardConfig, error) {
+	query := "SELECT widgets, updated_at FROM dashboard_configs WHERE user_id = $1"
+	var config DashboardConfig
+	err := ScopeToUser(userID).QueryRow(query).Scan(pq.Array(&config.Widgets), &config.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &DashboardConfig{Widgets: append([]string(nil), DefaultDashboardWidgets...)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dashboard config: %v", err)
+	}
+	if config.Widgets == nil {
+		config.Widgets = []string{}
+	}
+	return &config, nil
+}
+
+// UpsertDashboardConfig saves the user's dashboard layout. Widgets must already be validated.
+func UpsertDashboardConfig(userID int, widgets []string) (*DashboardConfig, error) {
+	query := `INSERT INTO dashboard_configs (user_id, widgets) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET widgets = EXCLUDED.widgets
+		RETURNING widgets, updated_at`
+	var config DashboardConfig
+	err := ScopeToUser(userID).QueryRow(query, pq.Array(widgets)).Scan(pq.Array(&config.Widgets), &config.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert dashboard config: %v", err)
+	}
+	if config.Widgets == nil {
+		config.Widgets = []string{}
+	}
+	return &config, nil
+}
+
+// ********** HOUSEHOLDS **********
+
+// MaxHouseholdMembers caps how many users can join one household
+const MaxHouseholdMembers = 6
+
+var (
+	// ErrAlreadyInHousehold is returned when a user who belongs to a household creates or joins another
+	ErrAlreadyInHousehold = errors.New("user already belongs to a household")
+	// ErrHouseholdFull is returned when joining a household that already has MaxHouseholdMembers members
+	ErrHouseholdFull = errors.New("household is full")
+)
+
+// Household is a group of users who budget together, with the budget categories they share
+type Household struct {
+	ID               int                    `json:"id"`
+	Name             string                 `json:"name"`
+	InviteCode       string                 `json:"invite_code"`
+	CreatedBy        *int                   `json:"created_by"`
+	CreatedAt        time.Time              `json:"created_at"`
+	Members          []HouseholdMember      `json:"members"`
+	SharedCategories []SharedBudgetCategory `json:"shared_categories"`
+}
+
+type HouseholdMember struct {
+	UserID   int       `json:"user_id"`
+	Email    string    `json:"email"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// SharedBudgetCategory is a budget category split between household members
+type SharedBudgetCategory struct {
+	Category string          `json:"category"`
+	Splits   []CategorySplit `json:"splits"`
+}
+
+// CategorySplit is one member's percent of a shared budget category's spend
+type CategorySplit struct {
+	UserID  int     `json:"user_id"`
+	Percent float64 `json:"percent"`
+}
+
+// CreateHousehold creates a household with the user as its first member, returning ErrAlreadyInHousehold when
+// they already belong to one
+func CreateHousehold(userID int, name string) (*Household, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var householdID int
+	query := "INSERT INTO households (name, invite_code, created_by) VALUES ($1, substr(replace(gen_random_uuid()::text, '-', ''), 1, 12), $2) RETURNING id"
+	if err := tx.QueryRow(query, name, userID).Scan(&householdID); err != nil {
+		return nil, fmt.Errorf("failed to create household: %v", err)
+	}
+	if err := addHouseholdMember(tx, householdID, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit household: %v", err)
+	}
+	return GetHousehold(userID)
+}
+
+// JoinHousehold adds the user to the household with the invite code, returning nil when no household has the code.
+// It returns ErrAlreadyInHousehold when the user belongs to a household and ErrHouseholdFull when it has no room.
+func JoinHousehold(userID int, inviteCode string) (*Household, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the household keeps concurrent joins from overfilling it
+	var householdID, members int
+	err = tx.QueryRow("SELECT id FROM households WHERE invite_code = $1 FOR UPDATE", inviteCode).Scan(&householdID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get household: %v", err)
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM household_members WHERE household_id = $1", householdID).Scan(&members); err != nil {
+		return nil, fmt.Errorf("failed to count household members: %v", err)
+	}
+	if members >= MaxHouseholdMembers {
+		return nil, ErrHouseholdFull
+	}
+	if err := addHouseholdMember(tx, householdID, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit household membership: %v", err)
+	}
+	return GetHousehold(userID)
+}
+
+func addHouseholdMember(tx *sql.Tx, householdID int, userID int) error {
+	result, err := ScopeToUser(userID).InTx(tx).Exec("INSERT INTO household_members (user_id, household_id) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING", householdID)
+	if err != nil {
+		return fmt.Errorf("failed to add household member: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAlreadyInHousehold
+	}
+	return nil
+}
+
+// GetHousehold returns the household the user belongs to with its members and shared categories, or nil
+func GetHousehold(userID int) (*Household, error) {
+	query := "SELECT h.id, h.name, h.invite_code, h.created_by, h.created_at FROM households h" +
+		" JOIN household_members m ON m.household_id = h.id WHERE m.user_id = $1"
+	var household Household
+	err := ScopeToUser(userID).QueryRow(query).Scan(&household.ID, &household.Name, &household.InviteCode, &household.CreatedBy, &household.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get household: %v", err)
+	}
+
+	rows, err := DB.Query("SELECT m.user_id, u.email, m.joined_at FROM household_members m JOIN users u ON u.user_id = m.user_id"+
+		" WHERE m.household_id = $1 ORDER BY m.joined_at, m.user_id", household.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query household members: %v", err)
+	}
+	defer rows.Close()
+	household.Members = []HouseholdMember{}
+	for rows.Next() {
+		var member HouseholdMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.JoinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan household member: %v", err)
+		}
+		household.Members = append(household.Members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating household members: %v", err)
+	}
+
+	splitRows, err := DB.Query("SELECT category, user_id, split_percent FROM household_category_splits"+
+		" WHERE household_id = $1 ORDER BY LOWER(category), split_percent DESC, user_id", household.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query household category splits: %v", err)
+	}
+	defer splitRows.Close()
+	household.SharedCategories = []SharedBudgetCategory{}
+	for splitRows.Next() {
+		var category string
+		var split CategorySplit
+		if err := splitRows.Scan(&category, &split.UserID, &split.Percent); err != nil {
+			return nil, fmt.Errorf("failed to scan household category split: %v", err)
+		}
+		last := len(household.SharedCategories) - 1
+		if last < 0 || !strings.EqualFold(household.SharedCategories[last].Category, category) {
+			household.SharedCategories = append(household.SharedCategories, SharedBudgetCategory{Category: category})
+			last++
+		}
+		household.SharedCategories[last].Splits = append(household.SharedCategories[last].Splits, split)
+	}
+	if err = splitRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating household category splits: %v", err)
+	}
+	return &household, nil
+}
+
+// LeaveHousehold removes the user from their household, reporting whether they were in one. Categories shared
+// with them stop being shared, as their splits no longer add up, and a household left empty is deleted.
+func LeaveHousehold(userID int) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var householdID int
+	err = ScopeToUser(userID).InTx(tx).QueryRow("DELETE FROM household_members WHERE user_id = $1 RETURNING household_id").Scan(&householdID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to leave household: %v", err)
+	}
+	_, err = tx.Exec(`
+		DELETE FROM household_category_splits
+		WHERE household_id = $1 AND LOWER(category) IN (
+			SELECT LOWER(category) FROM household_category_splits WHERE household_id = $1 AND user_id = $2
+		)
+	`, householdID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unshare household categories: %v", err)
+	}
+	_, err = tx.Exec("DELETE FROM households WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM household_members WHERE household_id = $1)", householdID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete empty household: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit leaving household: %v", err)
+	}
+	return true, nil
+}
+
+// SetHouseholdCategorySplits shares the category between household members, replacing any earlier split. The
+// caller checks the splits are for members and add up to 100.
+func SetHouseholdCategorySplits(householdID int, category string, splits []CategorySplit) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM household_category_splits WHERE household_id = $1 AND LOWER(category) = LOWER($2)", householdID, category); err != nil {
+		return fmt.Errorf("failed to clear household category splits: %v", err)
+	}
+	for _, split := range splits {
+		_, err := tx.Exec("INSERT INTO household_category_splits (household_id, category, user_id, split_percent) VALUES ($1, $2, $3, $4)",
+			householdID, category, split.UserID, split.Percent)
+		if err != nil {
+			return fmt.Errorf("failed to save household category split: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit household category splits: %v", err)
+	}
+	return nil
+}
+
+// DeleteHouseholdCategorySplits stops sharing the category, reporting whether it was shared
+func DeleteHouseholdCategorySplits(householdID int, category string) (bool, error) {
+	result, err := DB.Exec("DELETE FROM household_category_splits WHERE household_id = $1 AND LOWER(category) = LOWER($2)", householdID, category)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete household category splits: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// getSharedCategorySplits returns the splits of the household categories shared with the user, keyed by the
+// lowercased category
+func getSharedCategorySplits(userID int) (map[string][]CategorySplit, error) {
+	query := `
+		SELECT LOWER(s.category), s.user_id, s.split_percent
+		FROM household_category_splits s
+		JOIN household_members m ON m.household_id = s.household_id AND m.user_id = $1
+		WHERE LOWER(s.category) IN (
+			SELECT LOWER(category) FROM household_category_splits WHERE household_id = s.household_id AND user_id = $1
+		)
+	`
+	rows, err := DB.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shared category splits: %v", err)
+	}
+	defer rows.Close()
+	splits := map[string][]CategorySplit{}
+	for rows.Next() {
+		var category string
+		var split CategorySplit
+		if err := rows.Scan(&category, &split.UserID, &split.Percent); err != nil {
+			return nil, fmt.Errorf("failed to scan shared category split: %v", err)
+		}
+		splits[category] = append(splits[category], split)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating shared category splits: %v", err)
+	}
+	return splits, nil
+}
+
+// GetAttributedCategorySpendInRange is GetBudgetCategorySpendInRange for budgeting. A category the user shares in
+// their household counts their percent of the spend of every member in the split, each matched by that member's
+// own category mappings.
+func GetAttributedCategorySpendInRange(userID int, categories []string, startDate time.Time, endDate time.Time) (map[string]money.Money, error) {
+	spend, err := GetBudgetCategorySpendInRange(userID, categories, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	sharedSplits, err := getSharedCategorySplits(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		splits, shared := sharedSplits[strings.ToLower(category)]
+		if !shared {
+			continue
+		}
+		var combined money.Money
+		var shareBasisPoints int64
+		for _, split := range splits {
+			if split.UserID == userID {
+				shareBasisPoints = int64(math.Round(split.Percent * 100))
+				combined = combined.Add(spend[category])
+				continue
+			}
+			memberSpend, err := GetBudgetCategorySpendInRange(split.UserID, []string{category}, startDate, endDate)
+			if err != nil {
+				return nil, err
+			}
+			combined = combined.Add(memberSpend[category])
+		}
+		spend[category] = combined.Prorate(shareBasisPoints, 10000)
+	}
+	return spend, nil
+}
+
+// ********** EMAIL DIGESTS **********
+
+// DigestRecipient is a user due a digest
+type DigestRecipient struct {
+	UserID           int
+	Email            string
+	DigestFrequency  string
+	UnsubscribeToken string
+	// DigestEmail is false when the digest only goes to the user's notification channels
+	DigestEmail bool
+	// DigestChannels is set when the user has a notification channel subscribed to the digest
+	DigestChannels bool
+}
+
+// DigestBudgetCategory is a budget category's spend so far this month
+type DigestBudgetCategory struct {
+	Category   string  `json:"category"`
+	Budget     float64 `json:"budget"`
+	TotalSpent float64 `json:"total_spent"`
+	Remaining  float64 `json:"remaining"`
+}
+
+// UpcomingBill is a charge expected to recur within the digest's look ahead
+type UpcomingBill struct {
+	Description  string  `json:"description"`
+	Amount       float64 `json:"amount"`
+	ExpectedDate string  `json:"expected_date"`
+}
+
+// Digest is the content of a digest email
+type Digest struct {
+	Frequency        string                 `json:"frequency"`
+	PeriodStart      time.Time              `json:"period_start"`
+	PeriodEnd        time.Time              `json:"period_end"`
+	TotalSpent       float64                `json:"total_spent"`
+	MonthBudget      float64                `json:"month_budget"`
+	MonthSpent       float64                `json:"month_spent"`
+	BudgetCategories []DigestBudgetCategory `json:"budget_categories"`
+	UpcomingBills    []UpcomingBill         `json:"upcoming_bills"`
+}
+
+// DigestPeriod returns how far back a digest of the given frequency looks, which is also how far ahead it looks for bills
+func DigestPeriod(frequency string, now time.Time) time.Time {
+	if frequency == DigestFrequencyMonthly {
+		return now.AddDate(0, -1, 0)
+	}
+	return now.AddDate(0, 0, -7)
+}
+
+// GetUsersDueForDigest returns users whose digest frequency has elapsed since their last digest
+func GetUsersDueForDigest(now time.Time) ([]DigestRecipient, error) {
+	query := `
+		SELECT p.user_id, u.email, p.digest_frequency, p.unsubscribe_token, p.digest_email,
+			EXISTS (SELECT 1 FROM notification_channels c WHERE c.user_id = p.user_id AND c.active
+				AND (cardinality(c.notification_types) = 0 OR $5 = ANY(c.notification_types)))
+		FROM user_preferences p
+		JOIN users u ON u.user_id = p.user_id
+		WHERE u.deactivated_at IS NULL
+			AND ((p.digest_frequency = $1 AND (p.last_digest_sent_at IS NULL OR p.last_digest_sent_at <= $3))
+				OR (p.digest_frequency = $2 AND (p.last_digest_sent_at IS NULL OR p.last_digest_sent_at <= $4)))
+	`
+	rows, err := DB.Query(query, DigestFrequencyWeekly, DigestFrequencyMonthly, DigestPeriod(DigestFrequencyWeekly, now), DigestPeriod(DigestFrequencyMonthly, now), NotificationTypeDigest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users due for digest: %v", err)
+	}
+	defer rows.Close()
+	var recipients []DigestRecipient
+	for rows.Next() {
+		var recipient DigestRecipient
+		if err := rows.Scan(&recipient.UserID, &recipient.Email, &recipient.DigestFrequency, &recipient.UnsubscribeToken, &recipient.DigestEmail, &recipient.DigestChannels); err != nil {
+			return nil, fmt.Errorf("failed to scan digest recipient: %v", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating digest recipients: %v", err)
+	}
+	return recipients, nil
+}
+
+func MarkDigestSent(userID int, sentAt time.Time) error {
+	query := "UPDATE user_preferences SET last_digest_sent_at = $2 WHERE user_id = $1"
+	if _, err := ScopeToUser(userID).Exec(query, sentAt); err != nil {
+		return fmt.Errorf("failed to mark digest sent: %v", err)
+	}
+	return nil
+}
+
+// GetUpcomingBills estimates bills due between now and until from charges that recurred in each of the last three
+// months, assuming each repeats a month after its most recent charge
+func GetUpcomingBills(userID int, now time.Time, until time.Time) ([]UpcomingBill, error) {
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -3, 0)
+	charges, err := GetRecurringCharges(userID, since, now)
+	if err != nil {
+		return nil, err
+	}
+	bills := []UpcomingBill{}
+	for _, charge := range charges {
+		expectedDate := NextRecurrence(charge.LastDate)
+		if expectedDate.Before(now) || !expectedDate.Before(until) {
+			continue
+		}
+		bills = append(bills, UpcomingBill{Description: charge.Description, Amount: charge.Amount.Float64(), ExpectedDate: expectedDate.Format("2006-01-02")})
+	}
+	sort.Slice(bills, func(i, j int) bool { return bills[i].ExpectedDate < bills[j].ExpectedDate })
+	return bills, nil
+}
+
+// BuildDigest gathers spending over the digest period, budget progress for the current month and the bills
+// expected over the next period of the same length
+func BuildDigest(userID int, frequency string, now time.Time) (*Digest, error) {
+	periodStart := DigestPeriod(frequency, now)
+	digest := Digest{Frequency: frequency, PeriodStart: periodStart, PeriodEnd: now, BudgetCategories: []DigestBudgetCategory{}}
+	var err error
+	digest.TotalSpent, _, err = getReportSpendTotal(userID, periodStart, now)
+	if err != nil {
+		return nil, err
+	}
+
+	spends, err := GetCategorySpendByMonth(userID, ToMonthYear(now), 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, spend := range spends {
+		digest.BudgetCategories = append(digest.BudgetCategories, DigestBudgetCategory{
+			Category:   spend.Category,
+			Budget:     spend.Budget,
+			TotalSpent: spend.TotalSpent,
+			Remaining:  spend.Budget - spend.TotalSpent,
+		})
+		digest.MonthBudget += spend.Budget
+		digest.MonthSpent += spend.TotalSpent
+	}
+
+	digest.UpcomingBills, err = GetUpcomingBills(userID, now, now.Add(now.Sub(periodStart)))
+	if err != nil {
+		return nil, err
+	}
+	return &digest, nil
+}
+
+// ********** NOTIFICATIONS **********
+
+const (
+	NotificationTypeSyncFailure    = "sync_failure"
+	NotificationTypeBudgetWarning  = "budget_warning"
+	NotificationTypeInsight        = "insight"
+	NotificationTypeReauthRequired = "reauth_required"
+	// NotificationTypeEmergencyFundLow is sent when the emergency fund covers fewer months than the user asked for
+	NotificationTypeEmergencyFundLow = "emergency_fund_low"
+	// NotificationTypeUnusualTransaction asks the user to review a transaction that looks unusual for them
+	NotificationTypeUnusualTransaction = "unusual_transaction"
+	// NotificationTypeNewAccounts asks the user whether accounts their bank added to a linked login count towards
+	// their budget
+	NotificationTypeNewAccounts = "new_accounts"
+	// NotificationTypeDigest is the spending digest, which is emailed and sent to channels rather than stored
+	NotificationTypeDigest = "digest"
+)
+
+type Notification struct {
+	ID        int                    `json:"id"`
+	UserID    int                    `json:"user_id"`
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Body      string                 `json:"body"`
+	Data      map[string]interface{} `json:"data"`
+	ReadAt    *time.Time             `json:"read_at"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// CreateNotification stores a notification for the user and queues it for their notification channels. When
+// dedupeKey is set and the user already has a notification with that key nothing is stored or queued, and false
+// is returned.
+func CreateNotification(userID int, notificationType string, title string, body string, data map[string]interface{}, dedupeKey string) (bool, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal notification data: %v", err)
+	}
+	var key *string
+	if dedupeKey != "" {
+		key = &dedupeKey
+	}
+	// Postgres runs the queued insert even though nothing selects from it
+	query := `
+		WITH created AS (
+			INSERT INTO notifications (user_id, type, title, body, data, dedupe_key) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id, dedupe_key) DO NOTHING
+			RETURNING user_id, type, title, body
+		), queued AS (
+			INSERT INTO notification_channel_messages (user_id, channel_id, notification_type, title, body)
+			SELECT $1, c.id, created.type, created.title, created.body
+			FROM created JOIN notification_channels c ON c.user_id = created.user_id AND c.active
+				AND (cardinality(c.notification_types) = 0 OR created.type = ANY(c.notification_types))
+		)
+		SELECT COUNT(*) FROM created
+	`
+	var created int
+	if err := ScopeToUser(userID).QueryRow(query, notificationType, title, body, string(dataJSON), key).Scan(&created); err != nil {
+		return false, fmt.Errorf("failed to create notification: %v", err)
+	}
+	return created > 0, nil
+}
+
+// GetNotifications returns the user's most recent notifications, newest first
+func GetNotifications(userID int, unreadOnly bool, limit int) ([]Notification, error) {
+	query := "SELECT id, user_id, type, title, body, data, read_at, created_at FROM notifications WHERE user_id = $1"
+	if unreadOnly {
+		query += " AND read_at IS NULL"
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT $2"
+	rows, err := ScopeToUser(userID).ReadQuery(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %v", err)
+	}
+	defer rows.Close()
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var dataJSON []byte
+		if err := rows.Scan(&notification.ID, &notification.UserID, &notification.Type, &notification.Title, &notification.Body, &dataJSON, &notification.ReadAt, &notification.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %v", err)
+		}
+		if err := json.Unmarshal(dataJSON, &notification.Data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal notification data: %v", err)
+		}
+		notifications = append(notifications, notification)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notifications: %v", err)
+	}
+	return notifications, nil
+}
+
+func GetUnreadNotificationCount(userID int) (int, error) {
+	query := "SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL"
+	var count int
+	if err := ScopeToUser(userID).ReadQueryRow(query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %v", err)
+	}
+	return count, nil
+}
+
+func MarkNotificationRead(userID int, notificationID int) error {
+	query := "UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE user_id = $1 AND id = $2"
+	result, err := ScopeToUser(userID).Exec(query, notificationID)
+	if err != nil {
+		return fmt.Errorf("failed to mark notification read: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("notification not found")
+	}
+	return nil
+}
+
+func MarkAllNotificationsRead(userID int) error {
+	query := "UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND read_at IS NULL"
+	if _, err := ScopeToUser(userID).Exec(query); err != nil {
+		return fmt.Errorf("failed to mark notifications read: %v", err)
+	}
+	return nil
+}
+
+// ********** NOTIFICATION CHANNELS **********
+
+const (
+	NotificationChannelSlack   = "slack"
+	NotificationChannelDiscord = "discord"
+)
+
+// NotificationChannelTypes are the notification types a channel can subscribe to. The digest is only sent to
+// channels; the others are also shown in the app.
+var NotificationChannelTypes = []string{NotificationTypeSyncFailure, NotificationTypeBudgetWarning, NotificationTypeInsight, NotificationTypeReauthRequired, NotificationTypeEmergencyFundLow, NotificationTypeUnusualTransaction, NotificationTypeNewAccounts, NotificationTypeDigest}
+
+// NotificationChannel is a Slack or Discord incoming webhook the user's notifications are also sent to
+type NotificationChannel struct {
+	ID                int       `json:"id"`
+	UserID            int       `json:"user_id"`
+	Kind              string    `json:"kind"`
+	WebhookURL        string    `json:"webhook_url"`
+	Name              string    `json:"name"`
+	NotificationTypes []string  `json:"notification_types"`
+	Active            bool      `json:"active"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// DueChannelMessage is a claimed message with the channel it goes to
+type DueChannelMessage struct {
+	ID               int
+	ChannelID        int
+	UserID           int
+	NotificationType string
+	Title            string
+	Body             string
+	Attempts         int
+	Kind             string
+	WebhookURL       string
+}
+
+func CreateNotificationChannel(userID int, kind string, webhookURL string, name string, notificationTypes []string) (*NotificationChannel, error) {
+	if notificationTypes == nil {
+		notificationTypes = []string{}
+	}
+	channel := NotificationChannel{UserID: userID, Kind: kind, WebhookURL: webhookURL, Name: name, NotificationTypes: notificationTypes}
+	query := "INSERT INTO notification_channels (user_id, kind, webhook_url, name, notification_types) VALUES ($1, $2, $3, $4, $5) RETURNING id, active, created_at"
+	if err := ScopeToUser(userID).QueryRow(query, kind, webhookURL, name, pq.Array(notificationTypes)).Scan(&channel.ID, &channel.Active, &channel.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to create notification channel: %v", err)
+	}
+	return &channel, nil
+}
+
+// GetNotificationChannels returns the user's channels, newest first
+func GetNotificationChannels(userID int) ([]NotificationChannel, error) {
+	query := "SELECT id, user_id, kind, webhook_url, name, notification_types, active, created_at FROM notification_channels WHERE user_id = $1 ORDER BY created_at DESC, id DESC"
+	rows, err := ScopeToUser(userID).ReadQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification channels: %v", err)
+	}
+	defer rows.Close()
+	channels := []NotificationChannel{}
+	for rows.Next() {
+		var channel NotificationChannel
+		if err := rows.Scan(&channel.ID, &channel.UserID, &channel.Kind, &channel.WebhookURL, &channel.Name, pq.Array(&channel.NotificationTypes), &channel.Active, &channel.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan notification channel: %v", err)
+		}
+		channels = append(channels, channel)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notification channels: %v", err)
+	}
+	return channels, nil
+}
+
+// DeleteNotificationChannel removes a channel along with its undelivered messages
+func DeleteNotificationChannel(userID int, channelID int) error {
+	result, err := ScopeToUser(userID).Exec("DELETE FROM notification_channels WHERE user_id = $1 AND id = $2", channelID)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification channel: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("notification channel not found")
+	}
+	return nil
+}
+
+// DeactivateNotificationChannel stops sending to a channel whose webhook Slack or Discord reports was removed
+func DeactivateNotificationChannel(ctx context.Context, channelID int) error {
+	if _, err := DB.ExecContext(ctx, "UPDATE notification_channels SET active = FALSE WHERE id = $1", channelID); err != nil {
+		return fmt.Errorf("failed to deactivate notification channel: %v", err)
+	}
+	return nil
+}
+
+// QueueChannelMessages queues a message for every active channel of the user subscribed to its type and
+// returns how many were queued. CreateNotification does this for the notifications it stores.
+func QueueChannelMessages(userID int, notificationType string, title string, body string) (int64, error) {
+	query := "INSERT INTO notification_channel_messages (user_id, channel_id, notification_type, title, body)" +
+		" SELECT user_id, id, $2, $3, $4 FROM notification_channels WHERE user_id = $1 AND active" +
+		" AND (cardinality(notification_types) = 0 OR $2 = ANY(notification_types))"
+	result, err := ScopeToUser(userID).Exec(query, notificationType, title, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue channel messages: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected, nil
+}
+
+// ClaimDueChannelMessages takes up to limit pending messages whose next attempt is due, the same way
+// ClaimDueWebhookDeliveries claims deliveries
+func ClaimDueChannelMessages(ctx context.Context, limit int, lease time.Duration) ([]DueChannelMessage, error) {
+	query := `
+		WITH due AS (
+			SELECT id FROM notification_channel_messages
+			WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE notification_channel_messages m
+		SET attempts = m.attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		FROM due, notification_channels c
+		WHERE m.id = due.id AND c.id = m.channel_id
+		RETURNING m.id, m.channel_id, m.user_id, m.notification_type, m.title, m.body, m.attempts, c.kind, c.webhook_url
+	`
+	rows, err := DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim channel messages: %v", err)
+	}
+	defer rows.Close()
+	messages := []DueChannelMessage{}
+	for rows.Next() {
+		var message DueChannelMessage
+		if err := rows.Scan(&message.ID, &message.ChannelID, &message.UserID, &message.NotificationType, &message.Title, &message.Body, &message.Attempts, &message.Kind, &message.WebhookURL); err != nil {
+			return nil, fmt.Errorf("failed to scan channel message: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating channel messages: %v", err)
+	}
+	return messages, nil
+}
+
+// RecordChannelMessageAttempt stores the outcome of sending a message; nextAttemptAt only matters while the
+// message is still pending
+func RecordChannelMessageAttempt(ctx context.Context, messageID int, status string, lastError string, nextAttemptAt time.Time) error {
+	query := "UPDATE notification_channel_messages SET status = $2, last_error = NULLIF($3, ''), next_attempt_at = $4," +
+		" delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP ELSE delivered_at END WHERE id = $1"
+	if _, err := DB.ExecContext(ctx, query, messageID, status, lastError, nextAttemptAt); err != nil {
+		return fmt.Errorf("failed to record channel message attempt: %v", err)
+	}
+	return nil
+}
+
+// ********** WEBHOOKS **********
+
+const (
+	WebhookEventTransactionCreated = "transaction.created"
+	WebhookEventBudgetExceeded     = "budget.exceeded"
+	WebhookEventSyncCompleted      = "sync.completed"
+)
+
+// WebhookEventTypes are the events a webhook endpoint can subscribe to
+var WebhookEventTypes = []string{WebhookEventTransactionCreated, WebhookEventBudgetExceeded, WebhookEventSyncCompleted}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint is a URL a user registered to receive events. Secret is only returned when the endpoint is created.
+type WebhookEndpoint struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	EventTypes  []string  `json:"event_types"`
+	Secret      string    `json:"secret,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent, or still to be sent, to one endpoint
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	EndpointID     int             `json:"endpoint_id"`
+	UserID         int             `json:"user_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      *string         `json:"last_error"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// DueWebhookDelivery is a claimed delivery with what the worker needs to send it
+type DueWebhookDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+func CreateWebhookEndpoint(userID int, url string, description string, eventTypes []string, secret string) (*WebhookEndpoint, error) {
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	endpoint := WebhookEndpoint{UserID: userID, URL: url, Description: description, EventTypes: eventTypes, Secret: secret}
+	query := "INSERT INTO webhook_endpoints (user_id, url, description, event_types, secret) VALUES ($1, $2, $3, $4, $5) RETURNING id, active, created_at"
+	if err := ScopeToUser(userID).QueryRow(query, url, description, pq.Array(eventTypes), secret).Scan(&endpoint.ID, &endpoint.Active, &endpoint.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to create webhook endpoint: %v", err)
+	}
+	return &endpoint, nil
+}
+
+// GetWebhookEndpoints returns the user's endpoints without their secrets, newest first
+func GetWebhookEndpoints(userID int) ([]WebhookEndpoint, error) {
+	query := "SELECT id, user_id, url, description, event_types, active, created_at FROM webhook_endpoints WHERE user_id = $1 ORDER BY created_at DESC, id DESC"
+	rows, err := ScopeToUser(userID).ReadQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook endpoints: %v", err)
+	}
+	defer rows.Close()
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		var endpoint WebhookEndpoint
+		if err := rows.Scan(&endpoint.ID, &endpoint.UserID, &endpoint.URL, &endpoint.Description, pq.Array(&endpoint.EventTypes), &endpoint.Active, &endpoint.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook endpoint: %v", err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook endpoints: %v", err)
+	}
+	return endpoints, nil
+}
+
+// DeleteWebhookEndpoint removes an endpoint along with its delivery log
+func DeleteWebhookEndpoint(userID int, endpointID int) error {
+	result, err := ScopeToUser(userID).Exec("DELETE FROM webhook_endpoints WHERE user_id = $1 AND id = $2", endpointID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook endpoint: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook endpoint not found")
+	}
+	return nil
+}
+
+// CreateWebhookDeliveries queues an event for every active endpoint of the user subscribed to its type and
+// returns how many were queued. eventID makes this idempotent, so an event emitted twice is delivered once.
+func CreateWebhookDeliveries(userID int, eventID string, eventType string, payload json.RawMessage) (int64, error) {
+	query := "INSERT INTO webhook_deliveries (user_id, endpoint_id, event_id, event_type, payload)" +
+		" SELECT user_id, id, $2, $3, $4 FROM webhook_endpoints" +
+		" WHERE user_id = $1 AND active AND (cardinality(event_types) = 0 OR $3 = ANY(event_types))" +
+		" ON CONFLICT (endpoint_id, event_id) DO NOTHING"
+	result, err := ScopeToUser(userID).Exec(query, eventID, eventType, string(payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook deliveries: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected, nil
+}
+
+// ClaimDueWebhookDeliveries takes up to limit pending deliveries whose next attempt is due, counting the attempt
+// and pushing the next one back by lease so another worker doesn't send them at the same time. A worker that
+// dies mid-delivery leaves them to be retried once the lease runs out.
+func ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]DueWebhookDelivery, error) {
+	query := `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		FROM due, webhook_endpoints e
+		WHERE d.id = due.id AND e.id = d.endpoint_id
+		RETURNING d.id, d.endpoint_id, d.user_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.created_at, e.url, e.secret
+	`
+	rows, err := DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+	deliveries := []DueWebhookDelivery{}
+	for rows.Next() {
+		var delivery DueWebhookDelivery
+		var payload []byte
+		if err := rows.Scan(&delivery.ID, &delivery.EndpointID, &delivery.UserID, &delivery.EventID, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts, &delivery.CreatedAt, &delivery.URL, &delivery.Secret); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+		delivery.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook deliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookDeliveryAttempt stores the outcome of sending a delivery. responseStatus is 0 when no response
+// came back; nextAttemptAt only matters while the delivery is still pending.
+func RecordWebhookDeliveryAttempt(ctx context.Context, deliveryID int, status string, responseStatus int, lastError string, nextAttemptAt time.Time) error {
+	query := "UPDATE webhook_deliveries SET status = $2, response_status = NULLIF($3, 0), last_error = NULLIF($4, ''), next_attempt_at = $5," +
+		" delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP ELSE delivered_at END WHERE id = $1"
+	if _, err := DB.ExecContext(ctx, query, deliveryID, status, responseStatus, lastError, nextAttemptAt); err != nil {
+		return fmt.Errorf("failed to record webhook delivery attempt: %v", err)
+	}
+	return nil
+}
+
+// WebhookDeliveryFilters are the fields the delivery log can be filtered by
+var WebhookDeliveryFilters = FilterSchema{
+	"status":      {Column: "status", Type: FilterString, Ops: stringFilterOps},
+	"event_type":  {Column: "event_type", Type: FilterString, Ops: stringFilterOps},
+	"endpoint_id": {Column: "endpoint_id", Type: FilterNumber, Ops: []FilterOp{FilterEq, FilterIn}},
+	"created_at":  {Column: "created_at", Type: FilterDate, Ops: rangeFilterOps, Sortable: true},
+	"attempts":    {Column: "attempts", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+}
+
+const webhookDeliveryColumns = "id, endpoint_id, user_id, event_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, delivered_at, created_at"
+
+// ListWebhookDeliveries returns a page of the user's delivery log matching the filters
+func ListWebhookDeliveries(userID int, listQuery ListQuery) ([]WebhookDelivery, error) {
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("user_id")
+	if err := qb.Apply(WebhookDeliveryFilters, listQuery.Filters); err != nil {
+		return nil, err
+	}
+	if listQuery.Sort == "" {
+		listQuery.Desc = true
+	}
+	orderAndPage, err := qb.OrderAndPage(WebhookDeliveryFilters, listQuery, "created_at", "id")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := scope.ReadQuery("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries"+qb.WhereClause()+orderAndPage, qb.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		if err := rows.Scan(&delivery.ID, &delivery.EndpointID, &delivery.UserID, &delivery.EventID, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts, &delivery.ResponseStatus, &delivery.LastError, &delivery.NextAttemptAt, &delivery.DeliveredAt, &delivery.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+		delivery.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook deliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues a delivery to be sent again right away with a fresh set of attempts
+func RedeliverWebhook(userID int, deliveryID int) error {
+	query := "UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id = $2"
+	result, err := ScopeToUser(userID).Exec(query, deliveryID)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook delivery not found")
+	}
+	return nil
+}
+
+// ********** API KEYS **********
+
+const (
+	APIKeyScopeRead  = "read"
+	APIKeyScopeWrite = "write"
+)
+
+// APIKeyScopes are the permissions a key can be granted: read covers GET requests, write everything else
+var APIKeyScopes = []string{APIKeyScopeRead, APIKeyScopeWrite}
+
+// APIKey is a key a user created for programmatic access. The key itself is only known when it's created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (key *APIKey) HasScope(scope string) bool {
+	for _, granted := range key.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func CreateAPIKey(userID int, name string, prefix string, keyHash string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	key := APIKey{UserID: userID, Name: name, Prefix: prefix, Scopes: scopes, ExpiresAt: expiresAt}
+	query := "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at"
+	if err := ScopeToUser(userID).QueryRow(query, name, prefix, keyHash, pq.Array(scopes), expiresAt).Scan(&key.ID, &key.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to create api key: %v", err)
+	}
+	return &key, nil
+}
+
+// GetAPIKeys returns the user's keys that haven't been revoked, newest first, including expired ones
+func GetAPIKeys(userID int) ([]APIKey, error) {
+	query := "SELECT id, user_id, name, prefix, scopes, last_used_at, expires_at, created_at FROM api_keys" +
+		" WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC, id DESC"
+	rows, err := ScopeToUser(userID).ReadQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %v", err)
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.LastUsedAt, &key.ExpiresAt, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api keys: %v", err)
+	}
+	return keys, nil
+}
+
+// GetActiveAPIKeyByHash looks up a key that is neither revoked nor expired and whose user hasn't deactivated
+// their account. It reads the primary so a revoked key stops working straight away.
+func GetActiveAPIKeyByHash(keyHash string) (*APIKey, error) {
+	query := "SELECT id, user_id, name, prefix, scopes, last_used_at, expires_at, created_at FROM api_keys" +
+		" WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)" +
+		activeUserFilter("api_keys.user_id")
+	var key APIKey
+	err := DB.QueryRow(query, keyHash).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.LastUsedAt, &key.ExpiresAt, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("api key not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api key: %v", err)
+	}
+	return &key, nil
+}
+
+// TouchAPIKey records that a key was used, at most once a minute so busy scripts don't write on every request
+func TouchAPIKey(keyID int) error {
+	query := "UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')"
+	if _, err := DB.Exec(query, keyID); err != nil {
+		return fmt.Errorf("failed to touch api key: %v", err)
+	}
+	return nil
+}
+
+func RevokeAPIKey(userID int, keyID int) error {
+	query := "UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL"
+	result, err := ScopeToUser(userID).Exec(query, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("api key not found")
+	}
+	return nil
+}
+
+// ********** ADVISOR ACCESS **********
+
+// DefaultAdvisorAccessDays is how long advisor access lasts when the user doesn't say
+const DefaultAdvisorAccessDays = 30
+
+// AdvisorAccess is read-only access a user shared with an advisor through a link. The link's code is only known
+// when the access is created.
+type AdvisorAccess struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const advisorAccessColumns = "id, user_id, name, last_used_at, expires_at, created_at"
+
+func (access *AdvisorAccess) dest() []interface{} {
+	return []interface{}{&access.ID, &access.UserID, &access.Name, &access.LastUsedAt, &access.ExpiresAt, &access.CreatedAt}
+}
+
+func CreateAdvisorAccess(userID int, name string, codeHash string, expiresAt time.Time) (*AdvisorAccess, error) {
+	access := AdvisorAccess{UserID: userID, Name: name, ExpiresAt: expiresAt}
+	query := "INSERT INTO advisor_access (user_id, name, code_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at"
+	if err := ScopeToUser(userID).QueryRow(query, name, codeHash, expiresAt).Scan(&access.ID, &access.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to create advisor access: %v", err)
+	}
+	return &access, nil
+}
+
+// GetAdvisorAccesses returns the user's advisor access that hasn't been revoked, newest first, including expired
+func GetAdvisorAccesses(userID int) ([]AdvisorAccess, error) {
+	query := "SELECT " + advisorAccessColumns + " FROM advisor_access WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC, id DESC"
+	rows, err := ScopeToUser(userID).ReadQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query advisor access: %v", err)
+	}
+	defer rows.Close()
+	accesses := []AdvisorAccess{}
+	for rows.Next() {
+		var access AdvisorAccess
+		if err := rows.Scan(access.dest()...); err != nil {
+			return nil, fmt.Errorf("failed to scan advisor access: %v", err)
+		}
+		accesses = append(accesses, access)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating advisor access: %v", err)
+	}
+	return accesses, nil
+}
+
+// GetActiveAdvisorAccessByHash looks up advisor access by its code's hash, when it is neither revoked nor expired
+// and its user hasn't deactivated their account
+func GetActiveAdvisorAccessByHash(codeHash string) (*AdvisorAccess, error) {
+	return getActiveAdvisorAccess("code_hash = $1", codeHash)
+}
+
+// GetActiveAdvisorAccess looks up advisor access that is neither revoked nor expired. It reads the primary so
+// revoked access stops working straight away.
+func GetActiveAdvisorAccess(accessID int) (*AdvisorAccess, error) {
+	return getActiveAdvisorAccess("id = $1", accessID)
+}
+
+func getActiveAdvisorAccess(condition string, arg interface{}) (*AdvisorAccess, error) {
+	query := "SELECT " + advisorAccessColumns + " FROM advisor_access WHERE " + condition +
+		" AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP" + activeUserFilter("advisor_access.user_id")
+	var access AdvisorAccess
+	err := DB.QueryRow(query, arg).Scan(access.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("advisor access not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query advisor access: %v", err)
+	}
+	return &access, nil
+}
+
+// TouchAdvisorAccess records that an advisor used their access, at most once a minute
+func TouchAdvisorAccess(accessID int) error {
+	query := "UPDATE advisor_access SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')"
+	if _, err := DB.Exec(query, accessID); err != nil {
+		return fmt.Errorf("failed to touch advisor access: %v", err)
+	}
+	return nil
+}
+
+func RevokeAdvisorAccess(userID int, accessID int) error {
+	query := "UPDATE advisor_access SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL"
+	result, err := ScopeToUser(userID).Exec(query, accessID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke advisor access: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("advisor access not found")
+	}
+	return nil
+}
+
+// ********** ACCOUNT DEACTIVATION **********
+
+// AccountDeactivation is when a user deactivated their account, and when its data is purged unless they
+// reactivate it first
+type AccountDeactivation struct {
+	DeactivatedAt time.Time `json:"deactivated_at"`
+	PurgeAfter    time.Time `json:"purge_after"`
+}
+
+// activeUserFilter leaves out users who deactivated their account from queries that pick who scheduled jobs run for
+func activeUserFilter(userIDColumn string) string {
+	return " AND NOT EXISTS (SELECT 1 FROM users deactivated WHERE deactivated.user_id = " + userIDColumn +
+		" AND deactivated.deactivated_at IS NOT NULL)"
+}
+
+// DeactivateUser deactivates the user's account if password matches, returning nil when it doesn't. Every session
+// is signed out, and the account's data is purged once retention has passed unless it is reactivated before then.
+func DeactivateUser(userID int, password string, retention time.Duration, request AuditRequest) (*AccountDeactivation, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var deactivation AccountDeactivation
+	err = ScopeToUser(userID).InTx(tx).QueryRow(`
+		UPDATE users SET deactivated_at = CURRENT_TIMESTAMP, purge_after = CURRENT_TIMESTAMP + make_interval(secs => $3),
+			session_version = session_version + 1
+		WHERE user_id = $1 AND password = $2 AND deactivated_at IS NULL
+		RETURNING deactivated_at, purge_after
+	`, password, retention.Seconds()).Scan(&deactivation.DeactivatedAt, &deactivation.PurgeAfter)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate user: %v", err)
+	}
+	details := map[string]interface{}{"purge_after": deactivation.PurgeAfter}
+	if err := recordAuditEvent(tx, userID, AuditAccountDeactivated, details, request); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit deactivation: %v", err)
+	}
+	return &deactivation, nil
+}
+
+// GetAccountDeactivation returns when the user deactivated their account, or nil while it is active. It reads the
+// primary so a deactivation takes effect straight away.
+func GetAccountDeactivation(userID int) (*AccountDeactivation, error) {
+	var deactivation AccountDeactivation
+	err := ScopeToUser(userID).QueryRow("SELECT deactivated_at, purge_after FROM users WHERE user_id = $1 AND deactivated_at IS NOT NULL").
+		Scan(&deactivation.DeactivatedAt, &deactivation.PurgeAfter)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account deactivation: %v", err)
+	}
+	return &deactivation, nil
+}
+
+// ReactivateUser reactivates a deactivated account whose data hasn't been purged yet, returning nil when the
+// email and password don't match such an account
+func ReactivateUser(email string, password string, request AuditRequest) (*DBUser, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var user DBUser
+	err = tx.QueryRow(`
+		UPDATE users SET deactivated_at = NULL, purge_after = NULL, session_version = session_version + 1
+		WHERE LOWER(email) = LOWER($1) AND password = $2 AND deactivated_at IS NOT NULL AND purge_after > CURRENT_TIMESTAMP
+		RETURNING user_id, email, password, session_version
+	`, email, password).Scan(&user.UserID, &user.Email, &user.Password, &user.SessionVersion)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reactivate user: %v", err)
+	}
+	if err := recordAuditEvent(tx, user.UserID, AuditAccountReactivated, nil, request); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit reactivation: %v", err)
+	}
+	return &user, nil
+}
+
+// GetUsersDueForPurge returns the ids of deactivated users whose retention window has passed
+func GetUsersDueForPurge(now time.Time) ([]int, error) {
+	rows, err := DB.Query("SELECT user_id FROM users WHERE deactivated_at IS NOT NULL AND purge_after <= $1 ORDER BY purge_after", now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users due for purge: %v", err)
+	}
+	defer rows.Close()
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users due for purge: %v", err)
+	}
+	return userIDs, nil
+}
+
+// PurgeUser deletes a deactivated user whose retention window has passed along with all their data, reporting
+// whether they were purged. Most tables cascade from users; transactions are deleted first so their spend
+// aggregates unwind before the user goes, and the tables that don't cascade are cleared explicitly.
+func PurgeUser(ctx context.Context, userID int) (bool, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	scope := ScopeToUser(userID).InTx(tx)
+
+	var householdID *int
+	err = scope.QueryRowContext(ctx, `
+		SELECT m.household_id FROM users u LEFT JOIN household_members m ON m.user_id = u.user_id
+		WHERE u.user_id = $1 AND u.deactivated_at IS NOT NULL AND u.purge_after <= CURRENT_TIMESTAMP
+		FOR UPDATE OF u
+	`).Scan(&householdID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to lock user for purge: %v", err)
+	}
+	for _, query := range []string{
+		"DELETE FROM transactions WHERE user_id = $1",
+		"DELETE FROM transactions_archive WHERE user_id = $1",
+		"DELETE FROM monthly_budget_spend_category WHERE user_id = $1",
+		"DELETE FROM users WHERE user_id = $1",
+	} {
+		if _, err := scope.ExecContext(ctx, query); err != nil {
+			return false, fmt.Errorf("failed to purge user: %v", err)
+		}
+	}
+	if householdID != nil {
+		_, err := tx.ExecContext(ctx, "DELETE FROM households WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM household_members WHERE household_id = $1)", *householdID)
+		if err != nil {
+			return false, fmt.Errorf("failed to delete empty household: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit purge: %v", err)
+	}
+	return true, nil
+}
+
+// ********** AUDIT LOG **********
+
+const (
+	AuditPasswordChanged    = "password_changed"
+	AuditEmailChanged       = "email_changed"
+	AuditAccountDeactivated = "account_deactivated"
+	AuditAccountReactivated = "account_reactivated"
+)
+
+// AuditRequest is where a change recorded in the audit log came from
+type AuditRequest struct {
+	IPAddress string
+	UserAgent string
+}
+
+// recordAuditEvent adds an entry to the user's audit log as part of tx, so it is only kept if the change is
+func recordAuditEvent(tx *sql.Tx, userID int, action string, details map[string]interface{}, request AuditRequest) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %v", err)
+	}
+	query := "INSERT INTO audit_log (user_id, action, ip_address, user_agent, details) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)"
+	if _, err := ScopeToUser(userID).InTx(tx).Exec(query, action, request.IPAddress, request.UserAgent, string(detailsJSON)); err != nil {
+		return fmt.Errorf("failed to record audit event: %v", err)
+	}
+	return nil
+}
+
+// ********** SYNC HEALTH **********
+
+const (
+	JobRunQueued    = "queued"
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+// Connection states, from best to worst
+const (
+	SyncStateHealthy = "healthy"
+	SyncStatePending = "pending"
+	SyncStateSyncing = "syncing"
+	SyncStateStale   = "stale"
+	SyncStateError   = "error"
+	// SyncStateReauthRequired is a connection the user has to reconnect before it syncs again
+	SyncStateReauthRequired = "reauth_required"
+)
+
+var syncStateRank = map[string]int{SyncStateHealthy: 0, SyncStatePending: 1, SyncStateSyncing: 2, SyncStateStale: 3, SyncStateError: 4, SyncStateReauthRequired: 5}
+
+// syncHealthWindow is how far back failed jobs are counted
+const syncHealthWindow = "7 days"
+
+// RecordJobRun stores a job's latest status. userID is 0 and provider empty for jobs that aren't about a user or
+// don't sync a provider, and accountID is empty for jobs that aren't about one account. A nil payload keeps the
+// one already recorded.
+func RecordJobRun(jobID string, jobType string, userID int, provider string, accountID string, status string, jobErr string, payload json.RawMessage, payloadRedacted bool) error {
+	query := `
+		INSERT INTO job_runs (job_id, job_type, user_id, provider, account_id, status, error, started_at, finished_at, payload, payload_redacted)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), NULLIF($5, ''), $6::varchar, NULLIF($7::varchar, ''),
+			CASE WHEN $6 <> 'queued' THEN CURRENT_TIMESTAMP END,
+			CASE WHEN $6 IN ('succeeded', 'failed') THEN CURRENT_TIMESTAMP END,
+			$8::jsonb, $9)
+		ON CONFLICT (job_id) DO UPDATE SET
+			status = EXCLUDED.status,
+			error = EXCLUDED.error,
+			started_at = COALESCE(job_runs.started_at, EXCLUDED.started_at),
+			finished_at = EXCLUDED.finished_at,
+			payload = COALESCE(EXCLUDED.payload, job_runs.payload),
+			payload_redacted = CASE WHEN EXCLUDED.payload IS NULL THEN job_runs.payload_redacted ELSE EXCLUDED.payload_redacted END
+	`
+	var payloadArg *string
+	if payload != nil {
+		payloadJSON := string(payload)
+		payloadArg = &payloadJSON
+	}
+	if _, err := DB.Exec(query, jobID, jobType, userID, provider, accountID, status, jobErr, payloadArg, payloadRedacted); err != nil {
+		return fmt.Errorf("failed to record job run: %v", err)
+	}
+	return nil
+}
+
+// RecordAccountSync stores the outcome of syncing one account; a nil syncErr is a success
+func RecordAccountSync(provider string, accountID string, userID int, syncErr error) error {
+	var query string
+	var args []interface{}
+	if syncErr == nil {
+		query = `
+			INSERT INTO account_sync_status (user_id, provider, account_id, last_attempt_at, last_success_at)
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT (provider, account_id) DO UPDATE SET
+				last_attempt_at = CURRENT_TIMESTAMP,
+				last_success_at = CURRENT_TIMESTAMP,
+				consecutive_failures = 0,
+				stale_since = NULL
+		`
+		args = []interface{}{provider, accountID}
+	} else {
+		query = `
+			INSERT INTO account_sync_status (user_id, provider, account_id, last_attempt_at, last_error, last_error_at, consecutive_failures)
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP, $4, CURRENT_TIMESTAMP, 1)
+			ON CONFLICT (provider, account_id) DO UPDATE SET
+				last_attempt_at = CURRENT_TIMESTAMP,
+				last_error = EXCLUDED.last_error,
+				last_error_at = CURRENT_TIMESTAMP,
+				consecutive_failures = account_sync_status.consecutive_failures + 1
+		`
+		args = []interface{}{provider, accountID, syncErr.Error()}
+	}
+	if _, err := ScopeToUser(userID).Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to record account sync: %v", err)
+	}
+	return nil
+}
+
+// StaleAccount is an account FlagStaleAccounts found had gone too long without a successful sync
+type StaleAccount struct {
+	UserID       int
+	Provider     string
+	AccountID    string
+	Name         string
+	LastSyncedAt *time.Time
+}
+
+// FlagStaleAccounts marks every account without a successful sync since staleAfter ago as stale and returns
+// the ones that weren't already. Accounts that never synced count from when they were linked. Hidden accounts
+// and Teller enrollments waiting to be reconnected, which users are already reminded about, are left out, as
+// are the accounts of deactivated users, which stop syncing on purpose.
+func FlagStaleAccounts(staleAfter time.Duration) ([]StaleAccount, error) {
+	query := `
+		WITH accounts AS (
+			SELECT 'plaid' AS provider, a.id AS account_id, a.user_id, COALESCE(a.account_name, '') AS name, t.created_at AS linked_at
+			FROM plaid_accounts a JOIN plaid_tokens t ON t.id = a.plaid_token_id
+			UNION ALL
+			SELECT 'teller', a.id::text, a.user_id, a.account_name, a.created_at
+			FROM teller_accounts a JOIN teller_institutions i ON i.id = a.teller_institution_id
+			WHERE a.deleted_at IS NULL AND i.deleted_at IS NULL AND i.status <> $2
+		),
+		stale AS (
+			SELECT a.provider, a.account_id, a.user_id, a.name, s.last_success_at
+			FROM accounts a
+			LEFT JOIN account_sync_status s ON s.provider = a.provider AND s.account_id = a.account_id
+			WHERE s.stale_since IS NULL AND COALESCE(s.last_success_at, a.linked_at) < $1
+				AND NOT account_hidden(a.user_id, a.provider, a.account_id)` + activeUserFilter("a.user_id") + `
+		),
+		flagged AS (
+			INSERT INTO account_sync_status (provider, account_id, user_id, last_attempt_at, stale_since)
+			SELECT provider, account_id, user_id, NULL, CURRENT_TIMESTAMP FROM stale
+			ON CONFLICT (provider, account_id) DO UPDATE SET stale_since = EXCLUDED.stale_since
+			RETURNING provider, account_id
+		)
+		SELECT stale.user_id, stale.provider, stale.account_id, stale.name, stale.last_success_at
+		FROM stale JOIN flagged USING (provider, account_id)
+		ORDER BY stale.user_id, stale.name
+	`
+	rows, err := DB.Query(query, time.Now().Add(-staleAfter), TellerEnrollmentReauthRequired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to flag stale accounts: %v", err)
+	}
+	defer rows.Close()
+	accounts := []StaleAccount{}
+	for rows.Next() {
+		var account StaleAccount
+		if err := rows.Scan(&account.UserID, &account.Provider, &account.AccountID, &account.Name, &account.LastSyncedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan stale account: %v", err)
+		}
+		accounts = append(accounts, account)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stale accounts: %v", err)
+	}
+	return accounts, nil
+}
+
+// JobRun is a job the worker processed, with the data it ran with
+type JobRun struct {
+	ID      int    `json:"id"`
+	JobID   string `json:"job_id"`
+	JobType string `json:"job_type"`
+	UserID  *int   `json:"user_id"`
+	Status  string `json:"status"`
+	// Payload is nil for runs recorded before payloads were, or while they are turned off
+	Payload         json.RawMessage `json:"payload"`
+	PayloadRedacted bool            `json:"payload_redacted"`
+	Error           *string         `json:"error"`
+	EnqueuedAt      time.Time       `json:"enqueued_at"`
+	FinishedAt      *time.Time      `json:"finished_at"`
+}
+
+// GetJobRun returns the job run with this id or job id, or nil when there is none
+func GetJobRun(ref string) (*JobRun, error) {
+	query := "SELECT id, job_id, job_type, user_id, status, payload, payload_redacted, error, enqueued_at, finished_at" +
+		" FROM job_runs WHERE id::text = $1 OR job_id = $1 ORDER BY id LIMIT 1"
+	var run JobRun
+	var payload []byte
+	err := DB.QueryRow(query, ref).Scan(&run.ID, &run.JobID, &run.JobType, &run.UserID, &run.Status, &payload, &run.PayloadRedacted, &run.Error, &run.EnqueuedAt, &run.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job run: %v", err)
+	}
+	if payload != nil {
+		run.Payload = json.RawMessage(payload)
+	}
+	return &run, nil
+}
+
+// PruneJobRuns deletes job runs enqueued before the cutoff
+func PruneJobRuns(before time.Time) (int64, error) {
+	result, err := DB.Exec("DELETE FROM job_runs WHERE enqueued_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune job runs: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected, nil
+}
+
+// AccountSyncHealth is the sync state of one linked account. ErrorCount counts failed sync jobs over the
+// last week; LastSyncedAt falls back to the latest transaction update for accounts synced before syncs
+// were tracked.
+type AccountSyncHealth struct {
+	AccountID             string     `json:"account_id"`
+	Name                  string     `json:"name"`
+	Type                  string     `json:"type"`
+	State                 string     `json:"state"`
+	LastSyncedAt          *time.Time `json:"last_synced_at"`
+	LastAttemptAt         *time.Time `json:"last_attempt_at"`
+	LastError             *string    `json:"last_error"`
+	LastErrorAt           *time.Time `json:"last_error_at"`
+	ConsecutiveFailures   int        `json:"consecutive_failures"`
+	ErrorCount            int        `json:"error_count"`
+	PendingJobs           int        `json:"pending_jobs"`
+	LatestTransactionDate *time.Time `json:"latest_transaction_date"`
+	// StaleSince is set by FlagStaleAccounts and cleared by the next successful sync
+	StaleSince *time.Time `json:"stale_since"`
+}
+
+// InstitutionSyncHealth is a bank connection, a Plaid item or a Teller enrollment, with its accounts.
+// Its state is the worst of its accounts'.
+type InstitutionSyncHealth struct {
+	Provider      string              `json:"provider"`
+	InstitutionID string              `json:"institution_id"`
+	Name          string              `json:"name"`
+	State         string              `json:"state"`
+	LastSyncedAt  *time.Time          `json:"last_synced_at"`
+	ErrorCount    int                 `json:"error_count"`
+	PendingJobs   int                 `json:"pending_jobs"`
+	Accounts      []AccountSyncHealth `json:"accounts"`
+}
+
+// SyncHealth summarizes every connection of a user. PendingJobs and ErrorCount include jobs that cover a
+// whole connection, such as a first sync, which aren't attributed to one account.
+type SyncHealth struct {
+	State        string                  `json:"state"`
+	PendingJobs  int                     `json:"pending_jobs"`
+	ErrorCount   int                     `json:"error_count"`
+	Institutions []InstitutionSyncHealth `json:"institutions"`
+}
+
+// accountSyncState derives an account's state; a sync in progress only hides staleness, never an error
+func accountSyncState(account AccountSyncHealth, staleAfter time.Duration, now time.Time) string {
+	switch {
+	case account.ConsecutiveFailures > 0:
+		return SyncStateError
+	case account.PendingJobs > 0:
+		return SyncStateSyncing
+	case account.StaleSince != nil:
+		return SyncStateStale
+	case account.LastSyncedAt == nil:
+		return SyncStatePending
+	case now.Sub(*account.LastSyncedAt) > staleAfter:
+		return SyncStateStale
+	default:
+		return SyncStateHealthy
+	}
+}
+
+// GetSyncHealth assembles the state of the user's connections from the linked accounts, their latest sync
+// outcomes, recent job runs and transaction freshness. Accounts not synced within staleAfter are stale.
+func GetSyncHealth(userID int, staleAfter time.Duration) (*SyncHealth, error) {
+	health := &SyncHealth{State: SyncStateHealthy, Institutions: []InstitutionSyncHealth{}}
+	institutionIndex := map[string]int{}
+	reauthRequired := map[string]bool{}
+
+	rows, err := ScopeToUser(userID).ReadQuery(`
+		SELECT 'plaid', plaid_tokens.id::text, COALESCE(institutions.name, ''), 'active' FROM plaid_tokens
+		LEFT JOIN institutions ON institutions.institution_id = plaid_tokens.institution_id WHERE plaid_tokens.user_id = $1
+		UNION ALL
+		SELECT 'teller', id::text, name, status FROM teller_institutions WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY 1, 2
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query institutions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		institution := InstitutionSyncHealth{State: SyncStatePending, Accounts: []AccountSyncHealth{}}
+		var status string
+		if err := rows.Scan(&institution.Provider, &institution.InstitutionID, &institution.Name, &status); err != nil {
+			return nil, fmt.Errorf("failed to scan institution: %v", err)
+		}
+		key := institution.Provider + ":" + institution.InstitutionID
+		reauthRequired[key] = status == TellerEnrollmentReauthRequired
+		institutionIndex[key] = len(health.Institutions)
+		health.Institutions = append(health.Institutions, institution)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating institutions: %v", err)
+	}
+
+	accountRows, err := ScopeToUser(userID).ReadQuery(`
+		WITH accounts AS (
+			SELECT 'plaid' AS provider, id AS account_id, COALESCE(account_name, '') AS name, COALESCE(account_type, '') AS type,
+				COALESCE(plaid_token_id::text, '') AS institution_id, '' AS institution_name, COALESCE(is_processed, FALSE) AS synced
+			FROM plaid_accounts WHERE user_id = $1
+			UNION ALL
+			SELECT 'teller', id::text, account_name, account_type, teller_institution_id::text, institution_name, TRUE
+			FROM teller_accounts WHERE user_id = $1 AND deleted_at IS NULL
+		),
+		freshness AS (
+			SELECT COALESCE(plaid_account_id, teller_account_id::text) AS account_id, MAX(date) AS latest_date, MAX(updated_at) AS last_update
+			FROM transactions WHERE user_id = $1
+			GROUP BY 1
+		),
+		runs AS (
+			SELECT provider, account_id,
+				COUNT(*) FILTER (WHERE status = 'failed') AS error_count,
+				COUNT(*) FILTER (WHERE status IN ('queued', 'running') AND enqueued_at > CURRENT_TIMESTAMP - INTERVAL '1 day') AS pending_jobs
+			FROM job_runs
+			WHERE user_id = $1 AND provider IS NOT NULL AND enqueued_at > CURRENT_TIMESTAMP - INTERVAL '` + syncHealthWindow + `'
+			GROUP BY provider, account_id
+		),
+		statuses AS (
+			SELECT * FROM account_sync_status WHERE user_id = $1
+		)
+		SELECT a.provider, a.account_id, a.name, a.type, a.institution_id, a.institution_name,
+			COALESCE(s.last_success_at, CASE WHEN a.synced THEN f.last_update END), s.last_attempt_at, s.last_error, s.last_error_at,
+			COALESCE(s.consecutive_failures, 0), COALESCE(r.error_count, 0), COALESCE(r.pending_jobs, 0), f.latest_date, s.stale_since
+		FROM accounts a
+		LEFT JOIN statuses s ON s.provider = a.provider AND s.account_id = a.account_id
+		LEFT JOIN freshness f ON f.account_id = a.account_id
+		LEFT JOIN runs r ON r.provider = a.provider AND r.account_id = a.account_id
+		ORDER BY a.provider, a.institution_id, a.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query account sync health: %v", err)
+	}
+	defer accountRows.Close()
+	now := time.Now()
+	for accountRows.Next() {
+		var account AccountSyncHealth
+		var provider, institutionID, institutionName string
+		if err := accountRows.Scan(&provider, &account.AccountID, &account.Name, &account.Type, &institutionID, &institutionName,
+			&account.LastSyncedAt, &account.LastAttemptAt, &account.LastError, &account.LastErrorAt,
+			&account.ConsecutiveFailures, &account.ErrorCount, &account.PendingJobs, &account.LatestTransactionDate, &account.StaleSince); err != nil {
+			return nil, fmt.Errorf("failed to scan account sync health: %v", err)
+		}
+		account.State = accountSyncState(account, staleAfter, now)
+
+		key := provider + ":" + institutionID
+		index, ok := institutionIndex[key]
+		if !ok {
+			institutionIndex[key] = len(health.Institutions)
+			index = len(health.Institutions)
+			health.Institutions = append(health.Institutions, InstitutionSyncHealth{Provider: provider, InstitutionID: institutionID, Accounts: []AccountSyncHealth{}})
+		}
+		institution := &health.Institutions[index]
+		if institution.Name == "" {
+			institution.Name = institutionName
+		}
+		if len(institution.Accounts) == 0 || syncStateRank[account.State] > syncStateRank[institution.State] {
+			institution.State = account.State
+		}
+		if account.LastSyncedAt != nil && (institution.LastSyncedAt == nil || account.LastSyncedAt.After(*institution.LastSyncedAt)) {
+			institution.LastSyncedAt = account.LastSyncedAt
+		}
+		institution.ErrorCount += account.ErrorCount
+		institution.PendingJobs += account.PendingJobs
+		institution.Accounts = append(institution.Accounts, account)
+	}
+	if err = accountRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating account sync health: %v", err)
+	}
+
+	// Jobs covering a whole connection only count towards the totals
+	query := `
+		SELECT COUNT(*) FILTER (WHERE status = 'failed'),
+			COUNT(*) FILTER (WHERE status IN ('queued', 'running') AND enqueued_at > CURRENT_TIMESTAMP - INTERVAL '1 day')
+		FROM job_runs
+		WHERE user_id = $1 AND provider IS NOT NULL AND account_id IS NULL AND enqueued_at > CURRENT_TIMESTAMP - INTERVAL '` + syncHealthWindow + `'
+	`
+	if err := ScopeToUser(userID).ReadQueryRow(query).Scan(&health.ErrorCount, &health.PendingJobs); err != nil {
+		return nil, fmt.Errorf("failed to query job runs: %v", err)
+	}
+	for i := range health.Institutions {
+		institution := &health.Institutions[i]
+		if reauthRequired[institution.Provider+":"+institution.InstitutionID] {
+			institution.State = SyncStateReauthRequired
+		}
+		health.ErrorCount += institution.ErrorCount
+		health.PendingJobs += institution.PendingJobs
+		if syncStateRank[institution.State] > syncStateRank[health.State] {
+			health.State = institution.State
+		}
+	}
+	if health.PendingJobs > 0 && health.State == SyncStatePending {
+		health.State = SyncStateSyncing
+	}
+	return health, nil
+}
+
+// ********** SUBSCRIPTIONS **********
+
+const (
+	PlanFree    = "free"
+	PlanPremium = "premium"
+
+	PlanSourceStripe = "stripe"
+)
+
+// Entitlement is a user's subscription state, whichever billing provider it came from
+type Entitlement struct {
+	UserID               int        `json:"user_id"`
+	Plan                 string     `json:"plan"`
+	PlanStatus           string     `json:"plan_status"`
+	PlanSource           *string    `json:"plan_source"`
+	PlanExpiresAt        *time.Time `json:"plan_expires_at"`
+	StripeCustomerID     *string    `json:"-"`
+	StripeSubscriptionID *string    `json:"-"`
+}
+
+// IsActive reports whether the user currently has access to paid features. Past due subscriptions keep
+// access while the provider retries payment.
+func (e Entitlement) IsActive(now time.Time) bool {
+	if e.Plan == PlanFree {
+		return false
+	}
+	if e.PlanStatus != "active" && e.PlanStatus != "trialing" && e.PlanStatus != "past_due" {
+		return false
+	}
+	return e.PlanExpiresAt == nil || e.PlanExpiresAt.After(now)
+}
+
+func GetEntitlement(userID int) (*Entitlement, error) {
+	query := "SELECT user_id, plan, plan_status, plan_source, plan_expires_at, stripe_customer_id, stripe_subscription_id FROM users WHERE user_id = $1"
+	var entitlement Entitlement
+	err := ScopeToUser(userID).QueryRow(query).Scan(&entitlement.UserID, &entitlement.Plan, &entitlement.PlanStatus, &entitlement.PlanSource, &entitlement.PlanExpiresAt, &entitlement.StripeCustomerID, &entitlement.StripeSubscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entitlement: %v", err)
+	}
+	return &entitlement, nil
+}
+
+func SetStripeCustomerID(userID int, customerID string) error {
+	query := "UPDATE users SET stripe_customer_id = $2 WHERE user_id = $1"
+	if _, err := ScopeToUser(userID).Exec(query, customerID); err != nil {
+		return fmt.Errorf("failed to set stripe customer id: %v", err)
+	}
+	return nil
+}
+
+// UpdateStripeSubscription syncs a Stripe subscription onto the user that owns the customer, returning the user's
+// id, or 0 when no user has that customer, and whether the user was updated. Stripe delivers events out of order,
+// so an event older than the one the plan was last synced from is skipped.
+func UpdateStripeSubscription(customerID string, subscriptionID string, plan string, status string, expiresAt *time.Time, eventAt time.Time) (int, bool, error) {
+	query := `
+		UPDATE users SET plan = $1, plan_status = $2, plan_source = $3, plan_expires_at = $4, stripe_subscription_id = $5,
+			plan_updated_at = $7
+		WHERE stripe_customer_id = $6 AND (plan_updated_at IS NULL OR plan_updated_at <= $7)
+		RETURNING user_id
+	`
+	var userID int
+	err := DB.QueryRow(query, plan, status, PlanSourceStripe, expiresAt, subscriptionID, customerID, eventAt).Scan(&userID)
+	if err == nil {
+		return userID, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, fmt.Errorf("failed to update stripe subscription: %v", err)
+	}
+	err = DB.QueryRow("SELECT user_id FROM users WHERE stripe_customer_id = $1", customerID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get stripe customer: %v", err)
+	}
+	return userID, false, nil
+}
+
+// ********** GOOGLE SHEETS **********
+
+const (
+	GoogleSheetsActive         = "active"
+	GoogleSheetsReauthRequired = "reauth_required"
+)
+
+// GoogleSheetsConnection is a user's authorization to export to Google Sheets and the spreadsheet they picked
+type GoogleSheetsConnection struct {
+	UserID                int        `json:"user_id"`
+	RefreshToken          string     `json:"-"`
+	Status                string     `json:"status"`
+	SpreadsheetID         *string    `json:"spreadsheet_id"`
+	SpreadsheetURL        *string    `json:"spreadsheet_url"`
+	LastExportedMonthYear *int       `json:"last_exported_monthyear"`
+	LastExportedAt        *time.Time `json:"last_exported_at"`
+	LastError             *string    `json:"last_error"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+const googleSheetsConnectionColumns = "user_id, refresh_token, status, spreadsheet_id, spreadsheet_url, last_exported_monthyear, last_exported_at, last_error, created_at, updated_at"
+
+func scanGoogleSheetsConnection(row interface{ Scan(...interface{}) error }) (*GoogleSheetsConnection, error) {
+	var connection GoogleSheetsConnection
+	err := row.Scan(&connection.UserID, &connection.RefreshToken, &connection.Status, &connection.SpreadsheetID, &connection.SpreadsheetURL, &connection.LastExportedMonthYear, &connection.LastExportedAt, &connection.LastError, &connection.CreatedAt, &connection.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+// UpsertGoogleSheetsConnection stores the refresh token from the user authorizing Watson. Authorizing again keeps
+// the picked spreadsheet and clears a reauthorization request.
+func UpsertGoogleSheetsConnection(userID int, refreshToken string) (*GoogleSheetsConnection, error) {
+	query := `
+		INSERT INTO google_sheets_connections (user_id, refresh_token) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET refresh_token = EXCLUDED.refresh_token, status = $3, last_error = NULL
+		RETURNING ` + googleSheetsConnectionColumns
+	connection, err := scanGoogleSheetsConnection(ScopeToUser(userID).QueryRow(query, refreshToken, GoogleSheetsActive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert google sheets connection: %v", err)
+	}
+	return connection, nil
+}
+
+// GetGoogleSheetsConnection returns the user's connection, or nil when they haven't authorized Watson
+func GetGoogleSheetsConnection(userID int) (*GoogleSheetsConnection, error) {
+	query := "SELECT " + googleSheetsConnectionColumns + " FROM google_sheets_connections WHERE user_id = $1"
+	connection, err := scanGoogleSheetsConnection(ScopeToUser(userID).QueryRow(query))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get google sheets connection: %v", err)
+	}
+	return connection, nil
+}
+
+// SetGoogleSheetsSpreadsheet points the user's exports at a spreadsheet, returning nil when they haven't authorized
+// Watson. Exports to a new spreadsheet start again from the last completed month.
+func SetGoogleSheetsSpreadsheet(userID int, spreadsheetID string, spreadsheetURL string) (*GoogleSheetsConnection, error) {
+	query := `
+		UPDATE google_sheets_connections SET spreadsheet_id = $2, spreadsheet_url = $3,
+			last_exported_monthyear = CASE WHEN spreadsheet_id IS DISTINCT FROM $2 THEN NULL ELSE last_exported_monthyear END
+		WHERE user_id = $1
+		RETURNING ` + googleSheetsConnectionColumns
+	connection, err := scanGoogleSheetsConnection(ScopeToUser(userID).QueryRow(query, spreadsheetID, spreadsheetURL))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set google sheets spreadsheet: %v", err)
+	}
+	return connection, nil
+}
+
+// DeleteGoogleSheetsConnection removes the user's connection, returning it so its token can be revoked, or nil
+// when there was none
+func DeleteGoogleSheetsConnection(userID int) (*GoogleSheetsConnection, error) {
+	query := "DELETE FROM google_sheets_connections WHERE user_id = $1 RETURNING " + googleSheetsConnectionColumns
+	connection, err := scanGoogleSheetsConnection(ScopeToUser(userID).QueryRow(query))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete google sheets connection: %v", err)
+	}
+	return connection, nil
+}
+
+// GetGoogleSheetsConnectionsDueForExport returns active connections with a spreadsheet that haven't had monthYear
+// exported yet
+func GetGoogleSheetsConnectionsDueForExport(monthYear int) ([]GoogleSheetsConnection, error) {
+	query := "SELECT " + googleSheetsConnectionColumns + " FROM google_sheets_connections" +
+		" WHERE status = $1 AND spreadsheet_id IS NOT NULL AND last_exported_monthyear IS DISTINCT FROM $2" +
+		activeUserFilter("google_sheets_connections.user_id")
+	rows, err := DB.Query(query, GoogleSheetsActive, monthYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query google sheets connections due for export: %v", err)
+	}
+	defer rows.Close()
+	var connections []GoogleSheetsConnection
+	for rows.Next() {
+		connection, err := scanGoogleSheetsConnection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan google sheets connection: %v", err)
+		}
+		connections = append(connections, *connection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating google sheets connections: %v", err)
+	}
+	return connections, nil
+}
+
+// MarkGoogleSheetsExported records that monthYear has been appended to the user's spreadsheet
+func MarkGoogleSheetsExported(userID int, monthYear int) error {
+	query := "UPDATE google_sheets_connections SET last_exported_monthyear = $2, last_exported_at = CURRENT_TIMESTAMP, last_error = NULL WHERE user_id = $1"
+	if _, err := ScopeToUser(userID).Exec(query, monthYear); err != nil {
+		return fmt.Errorf("failed to mark google sheets exported: %v", err)
+	}
+	return nil
+}
+
+// RecordGoogleSheetsExportError notes why an export failed. When Google refused the user's authorization,
+// exports stop until they authorize again.
+func RecordGoogleSheetsExportError(userID int, exportErr string, reauthRequired bool) error {
+	query := "UPDATE google_sheets_connections SET last_error = $2, status = CASE WHEN $3 THEN $4 ELSE status END WHERE user_id = $1"
+	if _, err := ScopeToUser(userID).Exec(query, exportErr, reauthRequired, GoogleSheetsReauthRequired); err != nil {
+		return fmt.Errorf("failed to record google sheets export error: %v", err)
+	}
+	return nil
+}
+
+// ExportTransaction is a transaction as written to an export, labelled with its report category
+type ExportTransaction struct {
+	Date        time.Time
+	Description string
+	Merchant    string
+	Category    string
+	Amount      float64
+	Currency    string
+}
+
+// GetExportTransactions returns the user's transactions between startDate and endDate, oldest first
+func GetExportTransactions(userID int, startDate time.Time, endDate time.Time) ([]ExportTransaction, error) {
+	query := "SELECT transactions.date, COALESCE(transactions.description, ''), COALESCE(transactions.merchant, ''), " + reportCategoryLabel +
+		", transactions.amount::numeric, COALESCE(transactions.currency, '') FROM transactions" + mappedCategoryJoin +
+		" WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3" +
+		" ORDER BY transactions.date, transactions.id"
+	rows, err := ScopeToUser(userID).Query(query, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query export transactions: %v", err)
+	}
+	defer rows.Close()
+	transactions := []ExportTransaction{}
+	for rows.Next() {
+		var transaction ExportTransaction
+		if err := rows.Scan(&transaction.Date, &transaction.Description, &transaction.Merchant, &transaction.Category, &transaction.Amount, &transaction.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan export transaction: %v", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating export transactions: %v", err)
+	}
+	return transactions, nil
+}
+
+// ********** ONBOARDING **********
+
+const (
+	OnboardingRegistered        = "registered"
+	OnboardingBankLinked        = "bank_linked"
+	OnboardingFirstSyncComplete = "first_sync_complete"
+	OnboardingBudgetCreated     = "budget_created"
+)
+
+// OnboardingSteps lists the onboarding steps in the order users move through them
+var OnboardingSteps = []string{OnboardingRegistered, OnboardingBankLinked, OnboardingFirstSyncComplete, OnboardingBudgetCreated}
+
+var onboardingStepColumns = map[string]string{
+	OnboardingRegistered:        "registered_at",
+	OnboardingBankLinked:        "bank_linked_at",
+	OnboardingFirstSyncComplete: "first_sync_completed_at",
+	OnboardingBudgetCreated:     "budget_created_at",
+}
+
+// OnboardingStepStatus is when a step was reached, nil if it hasn't been
+type OnboardingStepStatus struct {
+	Step        string     `json:"step"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+type OnboardingProgress struct {
+	UserID int `json:"user_id"`
+	// NextStep is the first step not yet reached and Step the one before it. Steps can be reached out of
+	// order, e.g. a budget created before linking a bank, so the app resumes at the earliest gap.
+	// NextStep is empty once every step is complete.
+	Step      string                 `json:"step"`
+	NextStep  string                 `json:"next_step"`
+	Completed bool                   `json:"completed"`
+	Steps     []OnboardingStepStatus `json:"steps"`
+}
+
+// CompleteOnboardingStep records that the user reached a step. Steps already reached keep their original time.
+func CompleteOnboardingStep(userID int, step string) error {
+	column, ok := onboardingStepColumns[step]
+	if !ok {
+		return fmt.Errorf("unknown onboarding step: %s", step)
+	}
+	query := "INSERT INTO onboarding_progress (user_id, " + column + ") VALUES ($1, CURRENT_TIMESTAMP) ON CONFLICT (user_id) DO UPDATE SET " +
+		column + " = COALESCE(onboarding_progress." + column + ", EXCLUDED." + column + ")"
+	if _, err := ScopeToUser(userID).Exec(query); err != nil {
+		return fmt.Errorf("failed to complete onboarding step: %v", err)
+	}
+	return nil
+}
+
+// GetOnboardingProgress returns the user's onboarding progress. Users without a row are treated as just registered.
+func GetOnboardingProgress(userID int) (*OnboardingProgress, error) {
+	query := "SELECT registered_at, bank_linked_at, first_sync_completed_at, budget_created_at FROM onboarding_progress WHERE user_id = $1"
+	completedAt := make([]*time.Time, len(OnboardingSteps))
+	err := ScopeToUser(userID).QueryRow(query).Scan(&completedAt[0], &completedAt[1], &completedAt[2], &completedAt[3])
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get onboarding progress: %v", err)
+	}
+
+	progress := OnboardingProgress{UserID: userID, Step: OnboardingRegistered}
+	for i, step := range OnboardingSteps {
+		progress.Steps = append(progress.Steps, OnboardingStepStatus{Step: step, CompletedAt: completedAt[i]})
+		if progress.NextStep != "" {
+			continue
+		}
+		if completedAt[i] == nil && i > 0 {
+			progress.NextStep = step
+		} else {
+			progress.Step = step
+		}
+	}
+	progress.Completed = progress.NextStep == ""
+	return &progress, nil
+}
+
+// ********** DEMO DATA **********
+
+// MaxDemoMonths caps how much history a demo seed generates
+const MaxDemoMonths = 12
+
+// demoMerchant describes transactions at one merchant, in the shape Plaid reports them
+type demoMerchant struct {
+	Name           string
+	Category       []string
+	PFCPrimary     string
+	PFCDetailed    string
+	PaymentChannel string
+	MinAmount      float64
+	MaxAmount      float64
+}
+
+// demoRecurring is a merchant charged on the same day each month. Negative amounts are deposits.
+type demoRecurring struct {
+	Merchant demoMerchant
+	Day      int
+	Checking bool
+}
+
+// demoDailySpend is a group of merchants with the chance of a purchase from one of them on any given day
+type demoDailySpend struct {
+	Chance    float64
+	Merchants []demoMerchant
+}
+
+var demoRecurringCharges = []demoRecurring{
+	{Merchant: demoMerchant{"Acme Corp Payroll", []string{"Transfer", "Payroll"}, "INCOME", "INCOME_WAGES", "other", -2750, -2750}, Day: 1, Checking: true},
+	{Merchant: demoMerchant{"Acme Corp Payroll", []string{"Transfer", "Payroll"}, "INCOME", "INCOME_WAGES", "other", -2750, -2750}, Day: 15, Checking: true},
+	{Merchant: demoMerchant{"Parkview Apartments", []string{"Payment", "Rent"}, "RENT_AND_UTILITIES", "RENT_AND_UTILITIES_RENT", "other", 1850, 1850}, Day: 1, Checking: true},
+	{Merchant: demoMerchant{"City Power & Light", []string{"Service", "Utilities"}, "RENT_AND_UTILITIES", "RENT_AND_UTILITIES_GAS_AND_ELECTRICITY", "online", 70, 130}, Day: 18, Checking: true},
+	{Merchant: demoMerchant{"Verizon Wireless", []string{"Service", "Telecommunication Services"}, "RENT_AND_UTILITIES", "RENT_AND_UTILITIES_TELEPHONE", "online", 65, 65}, Day: 22},
+	{Merchant: demoMerchant{"Netflix", []string{"Service", "Subscription"}, "ENTERTAINMENT", "ENTERTAINMENT_TV_AND_MOVIES", "online", 15.49, 15.49}, Day: 12},
+	{Merchant: demoMerchant{"Spotify", []string{"Service", "Subscription"}, "ENTERTAINMENT", "ENTERTAINMENT_MUSIC_AND_AUDIO", "online", 10.99, 10.99}, Day: 20},
+	{Merchant: demoMerchant{"Planet Fitness", []string{"Recreation", "Gyms and Fitness Centers"}, "PERSONAL_CARE", "PERSONAL_CARE_GYMS_AND_FITNESS_CENTERS", "other", 24.99, 24.99}, Day: 5},
+}
+
+var demoDailySpending = []demoDailySpend{
+	{Chance: 0.25, Merchants: []demoMerchant{
+		{"Whole Foods Market", []string{"Shops", "Supermarkets and Groceries"}, "FOOD_AND_DRINK", "FOOD_AND_DRINK_GROCERIES", "in store", 35, 160},
+		{"Trader Joe's", []string{"Shops", "Supermarkets and Groceries"}, "FOOD_AND_DRINK", "FOOD_AND_DRINK_GROCERIES", "in store", 25, 110},
+	}},
+	{Chance: 0.5, Merchants: []demoMerchant{
+		{"Starbucks", []string{"Food and Drink", "Restaurants", "Coffee Shop"}, "FOOD_AND_DRINK", "FOOD_AND_DRINK_COFFEE", "in store", 4.5, 9},
+		{"Chipotle", []string{"Food and Drink", "Restaurants", "Fast Food"}, "FOOD_AND_DRINK", "FOOD_AND_DRINK_FAST_FOOD", "in store", 11, 18},
+		{"Sweetgreen", []string{"Food and Drink", "Restaurants"}, "FOOD_AND_DRINK", "FOOD_AND_DRINK_RESTAURANT", "in store", 13, 19},
+		{"DoorDash", []string{"Food and Drink", "Restaurants"}, "FOOD_AND_DRINK", "FOOD_AND_DRINK_RESTAURANT", "online", 22, 55},
+	}},
+	{Chance: 0.2, Merchants: []demoMerchant{
+		{"Uber", []string{"Travel", "Taxi"}, "TRANSPORTATION", "TRANSPORTATION_TAXIS_AND_RIDE_SHARES", "online", 9, 38},
+		{"Shell", []string{"Travel", "Gas Stations"}, "TRANSPORTATION", "TRANSPORTATION_GAS", "in store", 30, 65},
+	}},
+	{Chance: 0.15, Merchants: []demoMerchant{
+		{"Amazon", []string{"Shops", "Digital Purchase"}, "GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_ONLINE_MARKETPLACES", "online", 12, 120},
+		{"Target", []string{"Shops", "Department Stores"}, "GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_SUPERSTORES", "in store", 18, 140},
+	}},
+	{Chance: 0.05, Merchants: []demoMerchant{
+		{"AMC Theatres", []string{"Recreation", "Arts and Entertainment"}, "ENTERTAINMENT", "ENTERTAINMENT_TV_AND_MOVIES", "in store", 14, 40},
+	}},
+}
+
+// demoBudgets are the budget categories created for each demo month. "general" catches everything else.
+var demoBudgets = map[string]float64{
+	"food and drink":      650,
+	"transportation":      250,
+	"general merchandise": 300,
+	"entertainment":       80,
+}
+
+const demoGeneralBudget = 2600
+
+// DemoSeedResult summarises what a demo seed created
+type DemoSeedResult struct {
+	Accounts     int `json:"accounts"`
+	Transactions int `json:"transactions"`
+	Months       int `json:"months"`
+	SavingsGoals int `json:"savings_goals"`
+}
+
+// demoTransaction is a generated transaction waiting to be inserted
+type demoTransaction struct {
+	AccountID string
+	Merchant  demoMerchant
+	Amount    float64
+	Date      time.Time
+}
+
+// SeedDemoData fills a user's account with the given number of months of made up Plaid accounts, transactions,
+// budgets and savings goals, ending today. Reseeding replaces the previous demo transactions. The demo Plaid item's
+// access token is not real, so jobs that call Plaid for it will fail; this is only meant for sandbox and development.
+func SeedDemoData(userID int, months int, now time.Time, rng *rand.Rand) (*DemoSeedResult, error) {
+	if months < 1 || months > MaxDemoMonths {
+		return nil, fmt.Errorf("months must be between 1 and %d", MaxDemoMonths)
+	}
+	checkingID := fmt.Sprintf("demo-%d-checking", userID)
+	creditID := fmt.Sprintf("demo-%d-credit", userID)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -(months - 1), 0)
+
+	var generated []demoTransaction
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		for _, recurring := range demoRecurringCharges {
+			if day.Day() != recurring.Day {
+				continue
+			}
+			accountID := creditID
+			if recurring.Checking {
+				accountID = checkingID
+			}
+			generated = append(generated, demoTransaction{accountID, recurring.Merchant, demoAmount(recurring.Merchant, rng), day})
+		}
+		for _, spend := range demoDailySpending {
+			if rng.Float64() >= spend.Chance {
+				continue
+			}
+			merchant := spend.Merchants[rng.Intn(len(spend.Merchants))]
+			generated = append(generated, demoTransaction{creditID, merchant, demoAmount(merchant, rng), day})
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	scope := ScopeToUser(userID).InTx(tx)
+	var plaidTokenID string
+	err = scope.QueryRow(`
+		INSERT INTO plaid_tokens (user_id, access_token, item_id, is_processed) VALUES ($1, $2, $3, TRUE)
+		ON CONFLICT (item_id) DO UPDATE SET is_processed = TRUE
+		RETURNING id
+	`, fmt.Sprintf("demo-access-%d", userID), fmt.Sprintf("demo-item-%d", userID)).Scan(&plaidTokenID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create demo plaid token: %v", err)
+	}
+	accountQuery := `
+		INSERT INTO plaid_accounts (user_id, id, plaid_token_id, available_balance, current_balance, account_limit, currency, account_name, official_name, account_type, account_subtype, is_processed)
+		VALUES ($1, $2, $3, $4, $5, $6, 'USD', $7, $8, $9, $10, TRUE)
+		ON CONFLICT (id) DO UPDATE SET available_balance = EXCLUDED.available_balance, current_balance = EXCLUDED.current_balance
+	`
+	if _, err := scope.Exec(accountQuery, checkingID, plaidTokenID, 4215.37, 4215.37, nil, "Demo Checking", "Demo Bank Everyday Checking", "depository", "checking"); err != nil {
+		return nil, fmt.Errorf("failed to create demo checking account: %v", err)
+	}
+	if _, err := scope.Exec(accountQuery, creditID, plaidTokenID, 4168.55, 831.45, 5000, "Demo Rewards Card", "Demo Bank Rewards Visa", "credit", "credit card"); err != nil {
+		return nil, fmt.Errorf("failed to create demo credit account: %v", err)
+	}
+	if _, err := scope.Exec("DELETE FROM transactions WHERE user_id = $1 AND plaid_account_id IN ($2, $3)", checkingID, creditID); err != nil {
+		return nil, fmt.Errorf("failed to clear demo transactions: %v", err)
+	}
+
+	// Insert in batches to stay well under Postgres' bind parameter limit
+	const batchSize = 500
+	for batchStart := 0; batchStart < len(generated); batchStart += batchSize {
+		batch := generated[batchStart:min(batchStart+batchSize, len(generated))]
+		query := "INSERT INTO transactions (user_id, plaid_account_id, plaid_transaction_id, amount, date, description, category, currency, status, type, provider_type, personal_finance_category_primary, personal_finance_category_detailed, provider_transaction_id, account_ref, merchant) VALUES "
+		values := make([]interface{}, 0, len(batch)*9)
+		placeholders := make([]string, 0, len(batch))
+		for i, transaction := range batch {
+			offset := 1 + i*9
+			placeholders = append(placeholders, fmt.Sprintf("($1, $%d, $%d, $%d, $%d, $%d, $%d, 'USD', 'posted', $%d, 'plaid', $%d, $%d, $%d, $%d, $%d)",
+				offset+1, offset+2, offset+3, offset+4, offset+5, offset+6, offset+7, offset+8, offset+9, offset+2, offset+1, offset+5))
+			categoryJSON, err := json.Marshal(transaction.Merchant.Category)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal category: %v", err)
+			}
+			values = append(values,
+				transaction.AccountID,
+				fmt.Sprintf("demo-%d-%d", userID, batchStart+i),
+				fmt.Sprintf("%.2f", transaction.Amount),
+				transaction.Date,
+				transaction.Merchant.Name,
+				string(categoryJSON),
+				transaction.Merchant.PaymentChannel,
+				transaction.Merchant.PFCPrimary,
+				transaction.Merchant.PFCDetailed,
+			)
+		}
+		if _, err := scope.Exec(query+strings.Join(placeholders, ", "), values...); err != nil {
+			return nil, fmt.Errorf("failed to insert demo transactions: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit demo data: %v", err)
+	}
+
+	result := DemoSeedResult{Accounts: 2, Transactions: len(generated), Months: months}
+	for month := start; !month.After(today); month = month.AddDate(0, 1, 0) {
+		monthYear := ToMonthYear(month)
+		summary, err := UpsertMonthlySummary(userID, monthYear, money.Money{}, money.FromFloat(4000), money.FromFloat(5500), money.FromFloat(500), money.Money{}, money.FromFloat(2030), 10, money.FromFloat(demoGeneralBudget), BudgetPeriodMonthly, nil)
+		if err != nil {
+			return nil, err
+		}
+		for category, budget := range demoBudgets {
+			if _, err := GetMonthlyBudgetSpendCategory(userID, summary.ID, monthYear, category); err == nil {
+				continue
+			}
+			if _, err := CreateMonthlyBudgetSpendCategory(userID, summary.ID, monthYear, category, money.FromFloat(budget), defaultCategoryStrictness); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	demoGoals := []struct {
+		Name   string
+		Total  float64
+		Saved  float64
+		Months int
+	}{
+		{"Emergency Fund", 10000, 2500, 18},
+		{"Japan Trip", 4000, 900, 9},
+	}
+	for _, goal := range demoGoals {
+		var exists bool
+		if err := ScopeToUser(userID).QueryRow("SELECT EXISTS (SELECT 1 FROM saving_goal WHERE user_id = $1 AND name = $2)", goal.Name).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to check demo savings goal: %v", err)
+		}
+		if exists {
+			continue
+		}
+		targetDate := today.AddDate(0, goal.Months, 0)
+		if _, err := CreateSavingsGoal(userID, goal.Name, goal.Total, goal.Saved, &targetDate); err != nil {
+			return nil, err
+		}
+		result.SavingsGoals++
+	}
+
+	for _, step := range OnboardingSteps {
+		if err := CompleteOnboardingStep(userID, step); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// demoAmount picks an amount in the merchant's range, rounded to cents
+func demoAmount(merchant demoMerchant, rng *rand.Rand) float64 {
+	return roundCents(merchant.MinAmount + rng.Float64()*(merchant.MaxAmount-merchant.MinAmount))
+}
+
+// ********** FIXTURES **********
+
+// Fixture is a fixed set of users with their Plaid accounts, transactions and budgets, read from a JSON file
+// under database/testdata/fixtures. Unlike demo data nothing is generated: account and transaction ids and
+// dates are written as given, so loading a fixture always produces the same rows.
+type Fixture struct {
+	Users []FixtureUser `json:"users"`
+}
+
+// FixtureUser is a user, found by email or created with the given password
+type FixtureUser struct {
+	Email        string               `json:"email"`
+	Password     string               `json:"password"`
+	Accounts     []FixtureAccount     `json:"accounts"`
+	Transactions []FixtureTransaction `json:"transactions"`
+	Budgets      []FixtureBudget      `json:"budgets"`
+}
+
+// FixtureAccount is a Plaid account. Its id must be unique across the fixture.
+type FixtureAccount struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	OfficialName     string       `json:"official_name"`
+	Type             string       `json:"type"`
+	Subtype          string       `json:"subtype"`
+	CurrentBalance   money.Money  `json:"current_balance"`
+	AvailableBalance *money.Money `json:"available_balance"`
+	Limit            *money.Money `json:"limit"`
+}
+
+// FixtureTransaction is a Plaid transaction on one of the user's fixture accounts, dated YYYY-MM-DD.
+// Positive amounts are outflows, as Plaid reports them.
+type FixtureTransaction struct {
+	ID                              string      `json:"id"`
+	AccountID                       string      `json:"account_id"`
+	Date                            string      `json:"date"`
+	Description                     string      `json:"description"`
+	Amount                          money.Money `json:"amount"`
+	Category                        []string    `json:"category"`
+	PersonalFinanceCategoryPrimary  string      `json:"personal_finance_category_primary"`
+	PersonalFinanceCategoryDetailed string      `json:"personal_finance_category_detailed"`
+	Status                          string      `json:"status"`
+	Type                            string      `json:"type"`
+}
+
+// FixtureBudget is one month's summary and category budgets
+type FixtureBudget struct {
+	MonthYear       int                    `json:"monthyear"`
+	StartingBalance money.Money            `json:"starting_balance"`
+	Income          money.Money            `json:"income"`
+	FixedExpenses   money.Money            `json:"fixed_expenses"`
+	Budget          money.Money            `json:"budget"`
+	Categories      map[string]money.Money `json:"categories"`
+}
+
+// FixtureResult summarises what a fixture load wrote, with the id each fixture user ended up with
+type FixtureResult struct {
+	UserIDs      map[string]int `json:"user_ids"`
+	Accounts     int            `json:"accounts"`
+	Transactions int            `json:"transactions"`
+	Budgets      int            `json:"budgets"`
+}
+
+// ReadFixture reads and checks a fixture file
+func ReadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %v", err)
+	}
+	var fixture Fixture
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %v", path, err)
+	}
+	if err := fixture.validate(); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %v", path, err)
+	}
+	return &fixture, nil
+}
+
+// validate checks the references and dates in a fixture before anything is written
+func (fixture *Fixture) validate() error {
+	accountIDs := map[string]bool{}
+	transactionIDs := map[string]bool{}
+	for _, user := range fixture.Users {
+		if user.Email == "" || user.Password == "" {
+			return fmt.Errorf("every user needs an email and password")
+		}
+		userAccounts := map[string]bool{}
+		for _, account := range user.Accounts {
+			if account.ID == "" || accountIDs[account.ID] {
+				return fmt.Errorf("account id %q is missing or repeated", account.ID)
+			}
+			accountIDs[account.ID] = true
+			userAccounts[account.ID] = true
+		}
+		for _, transaction := range user.Transactions {
+			if transaction.ID == "" || transactionIDs[transaction.ID] {
+				return fmt.Errorf("transaction id %q is missing or repeated", transaction.ID)
+			}
+			transactionIDs[transaction.ID] = true
+			if !userAccounts[transaction.AccountID] {
+				return fmt.Errorf("transaction %s is on account %q, which %s doesn't have", transaction.ID, transaction.AccountID, user.Email)
+			}
+			if _, err := time.Parse("2006-01-02", transaction.Date); err != nil {
+				return fmt.Errorf("transaction %s has invalid date %q", transaction.ID, transaction.Date)
+			}
+		}
+		for _, budget := range user.Budgets {
+			if month := budget.MonthYear / 10000; month < 1 || month > 12 {
+				return fmt.Errorf("budget has invalid monthyear %d", budget.MonthYear)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFixture writes a fixture's users, accounts, transactions and budgets. Loading it again brings the data
+// back to the fixture: accounts and budgets are overwritten and the accounts' transactions replaced.
+func LoadFixture(fixture *Fixture) (*FixtureResult, error) {
+	if err := fixture.validate(); err != nil {
+		return nil, fmt.Errorf("invalid fixture: %v", err)
+	}
+	result := FixtureResult{UserIDs: map[string]int{}}
+	for _, user := range fixture.Users {
+		userID, err := loadFixtureUser(&user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load fixture user %s: %v", user.Email, err)
+		}
+		result.UserIDs[user.Email] = userID
+		result.Accounts += len(user.Accounts)
+		result.Transactions += len(user.Transactions)
+		result.Budgets += len(user.Budgets)
+	}
+	return &result, nil
+}
+
+// loadFixtureUser writes one fixture user, returning their id
+func loadFixtureUser(user *FixtureUser) (int, error) {
+	dbUser, err := GetUserByEmail(user.Email)
+	if err != nil {
+		dbUser, err = CreateUser(user.Email, user.Password)
+		if err != nil {
+			return 0, err
+		}
+	}
+	userID := dbUser.UserID
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	scope := ScopeToUser(userID).InTx(tx)
+
+	if len(user.Accounts) > 0 {
+		var plaidTokenID string
+		err = scope.QueryRow(`
+			INSERT INTO plaid_tokens (user_id, access_token, item_id, is_processed) VALUES ($1, $2, $3, TRUE)
+			ON CONFLICT (item_id) DO UPDATE SET is_processed = TRUE
+			RETURNING id
+		`, fmt.Sprintf("fixture-access-%d", userID), fmt.Sprintf("fixture-item-%d", userID)).Scan(&plaidTokenID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create fixture plaid token: %v", err)
+		}
+		accountIDs := make([]string, 0, len(user.Accounts))
+		for _, account := range user.Accounts {
+			available := account.CurrentBalance
+			if account.AvailableBalance != nil {
+				available = *account.AvailableBalance
+			}
+			_, err := scope.Exec(`
+				INSERT INTO plaid_accounts (user_id, id, plaid_token_id, available_balance, current_balance, account_limit, currency, account_name, official_name, account_type, account_subtype, is_processed)
+				VALUES ($1, $2, $3, $4, $5, $6, 'USD', $7, $8, $9, $10, TRUE)
+				ON CONFLICT (id) DO UPDATE SET user_id = EXCLUDED.user_id, plaid_token_id = EXCLUDED.plaid_token_id,
+					available_balance = EXCLUDED.available_balance, current_balance = EXCLUDED.current_balance,
+					account_limit = EXCLUDED.account_limit, account_name = EXCLUDED.account_name, official_name = EXCLUDED.official_name,
+					account_type = EXCLUDED.account_type, account_subtype = EXCLUDED.account_subtype
+			`, account.ID, plaidTokenID, available, account.CurrentBalance, account.Limit, account.Name, account.OfficialName, account.Type, account.Subtype)
+			if err != nil {
+				return 0, fmt.Errorf("failed to write fixture account %s: %v", account.ID, err)
+			}
+			accountIDs = append(accountIDs, account.ID)
+		}
+		if _, err := scope.Exec("DELETE FROM transactions WHERE user_id = $1 AND plaid_account_id = ANY($2)", pq.Array(accountIDs)); err != nil {
+			return 0, fmt.Errorf("failed to clear fixture transactions: %v", err)
+		}
+	}
+
+	for _, transaction := range user.Transactions {
+		category := transaction.Category
+		if category == nil {
+			category = []string{}
+		}
+		categoryJSON, err := json.Marshal(category)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal category: %v", err)
+		}
+		status := transaction.Status
+		if status == "" {
+			status = "posted"
+		}
+		_, err = scope.Exec(`
+			INSERT INTO transactions (user_id, plaid_account_id, plaid_transaction_id, amount, date, description, category, currency, status, type, provider_type, personal_finance_category_primary, personal_finance_category_detailed, provider_transaction_id, account_ref, merchant)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, 'USD', $8, $9, 'plaid', NULLIF($10, ''), NULLIF($11, ''), $3, $2, $6)
+		`, transaction.AccountID, transaction.ID, transaction.Amount, transaction.Date, transaction.Description,
+			string(categoryJSON), status, transaction.Type, transaction.PersonalFinanceCategoryPrimary, transaction.PersonalFinanceCategoryDetailed)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write fixture transaction %s: %v", transaction.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit fixture data: %v", err)
+	}
+
+	for _, budget := range user.Budgets {
+		summary, err := UpsertMonthlySummary(userID, budget.MonthYear, money.Money{}, budget.StartingBalance, budget.Income, money.Money{}, money.Money{}, budget.FixedExpenses, 0, budget.Budget, BudgetPeriodMonthly, nil)
+		if err != nil {
+			return 0, err
+		}
+		for category, amount := range budget.Categories {
+			existing, err := GetMonthlyBudgetSpendCategory(userID, summary.ID, budget.MonthYear, category)
+			if err != nil {
+				if _, err := CreateMonthlyBudgetSpendCategory(userID, summary.ID, budget.MonthYear, category, amount, defaultCategoryStrictness); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			if _, err := ScopeToUser(userID).Exec("UPDATE monthly_budget_spend_category SET budget = $2 WHERE user_id = $1 AND id = $3", amount, existing.ID); err != nil {
+				return 0, fmt.Errorf("failed to update fixture budget category: %v", err)
+			}
+		}
+	}
+	return userID, nil
+}
+
+// ********** HISTORY IMPORTS **********
+
+// Where an import is, as stored in data_imports.status
+const (
+	DataImportQueued    = "queued"
+	DataImportRunning   = "running"
+	DataImportCompleted = "completed"
+	DataImportFailed    = "failed"
+)
+
+// DataImport is one upload of another budgeting app's export files, loaded by the import_history job
+type DataImport struct {
+	ID                   int        `json:"id"`
+	UserID               int        `json:"user_id"`
+	Source               string     `json:"source"`
+	Status               string     `json:"status"`
+	TransactionsImported int        `json:"transactions_imported"`
+	TransactionsSkipped  int        `json:"transactions_skipped"`
+	BudgetMonthsImported int        `json:"budget_months_imported"`
+	Error                *string    `json:"error"`
+	CreatedAt            time.Time  `json:"created_at"`
+	CompletedAt          *time.Time `json:"completed_at"`
+}
+
+const dataImportColumns = "id, user_id, source, status, transactions_imported, transactions_skipped, budget_months_imported, error, created_at, completed_at"
+
+func (i *DataImport) dest() []interface{} {
+	return []interface{}{&i.ID, &i.UserID, &i.Source, &i.Status, &i.TransactionsImported, &i.TransactionsSkipped, &i.BudgetMonthsImported, &i.Error, &i.CreatedAt, &i.CompletedAt}
+}
+
+// DataImportFiles are the export files of an upload, stored apart from the job that imports them
+type DataImportFiles struct {
+	Register []byte
+	// Budget is nil when the export had no budget file
+	Budget []byte
+}
+
+// CreateDataImport records an upload about to be queued for import along with its files
+func CreateDataImport(userID int, source string, files DataImportFiles) (*DataImport, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	scope := ScopeToUser(userID).InTx(tx)
+	query := "INSERT INTO data_imports (user_id, source) VALUES ($1, $2) RETURNING " + dataImportColumns
+	var dataImport DataImport
+	if err := scope.QueryRow(query, source).Scan(dataImport.dest()...); err != nil {
+		return nil, fmt.Errorf("failed to create data import: %v", err)
+	}
+	_, err = scope.Exec("INSERT INTO data_import_files (user_id, import_id, register, budget) VALUES ($1, $2, $3, $4)",
+		dataImport.ID, files.Register, files.Budget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store data import files: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit data import: %v", err)
+	}
+	return &dataImport, nil
+}
+
+// GetDataImportFiles returns the files of one of the user's imports, or nil once they have been deleted
+func GetDataImportFiles(userID int, importID int) (*DataImportFiles, error) {
+	var files DataImportFiles
+	err := ScopeToUser(userID).QueryRow("SELECT register, budget FROM data_import_files WHERE user_id = $1 AND import_id = $2", importID).
+		Scan(&files.Register, &files.Budget)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data import files: %v", err)
+	}
+	return &files, nil
+}
+
+// PruneDataImportFiles deletes the files of imports uploaded before the cutoff that never completed
+func PruneDataImportFiles(before time.Time) (int64, error) {
+	result, err := DB.Exec("DELETE FROM data_import_files WHERE created_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune data import files: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return rowsAffected, nil
+}
+
+// GetDataImports returns the user's imports, newest first
+func GetDataImports(userID int) ([]DataImport, error) {
+	rows, err := ScopeToUser(userID).ReadQuery("SELECT " + dataImportColumns + " FROM data_imports WHERE user_id = $1 ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data imports: %v", err)
+	}
+	defer rows.Close()
+	dataImports := []DataImport{}
+	for rows.Next() {
+		var dataImport DataImport
+		if err := rows.Scan(dataImport.dest()...); err != nil {
+			return nil, fmt.Errorf("failed to scan data import: %v", err)
+		}
+		dataImports = append(dataImports, dataImport)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating data imports: %v", err)
+	}
+	return dataImports, nil
+}
+
+// GetDataImport returns one of the user's imports, or nil when they have no such import
+func GetDataImport(userID int, importID int) (*DataImport, error) {
+	var dataImport DataImport
+	err := ScopeToUser(userID).QueryRow("SELECT "+dataImportColumns+" FROM data_imports WHERE user_id = $1 AND id = $2", importID).Scan(dataImport.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data import: %v", err)
+	}
+	return &dataImport, nil
+}
+
+// StartDataImport marks an import as running, clearing the error of an earlier attempt
+func StartDataImport(importID int) error {
+	if _, err := DB.Exec("UPDATE data_imports SET status = $1, error = NULL WHERE id = $2", DataImportRunning, importID); err != nil {
+		return fmt.Errorf("failed to start data import: %v", err)
+	}
+	return nil
+}
+
+// CompleteDataImport records what an import loaded and deletes its files, which are no longer needed
+func CompleteDataImport(importID int, transactionsImported int, transactionsSkipped int, budgetMonthsImported int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE data_imports SET status = $1, transactions_imported = $2, transactions_skipped = $3, budget_months_imported = $4,
+			error = NULL, completed_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+	`
+	if _, err := tx.Exec(query, DataImportCompleted, transactionsImported, transactionsSkipped, budgetMonthsImported, importID); err != nil {
+		return fmt.Errorf("failed to complete data import: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM data_import_files WHERE import_id = $1", importID); err != nil {
+		return fmt.Errorf("failed to delete data import files: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit data import: %v", err)
+	}
+	return nil
+}
+
+// FailDataImport records why an import failed
+func FailDataImport(importID int, message string) error {
+	query := "UPDATE data_imports SET status = $1, error = $2, completed_at = CURRENT_TIMESTAMP WHERE id = $3"
+	if _, err := DB.Exec(query, DataImportFailed, message, importID); err != nil {
+		return fmt.Errorf("failed to record data import failure: %v", err)
+	}
+	return nil
+}
+
+// ImportTransactions stores transactions from another app's export as posted transactions of the source app, with
+// the export's account names as their accounts. Transactions imported before, including ones archived since, are
+// skipped, and so are those dated on or after the user's first synced transaction, which their linked accounts
+// already cover. It returns the transactions stored and how many were skipped.
+func ImportTransactions(ctx context.Context, userID int, source string, transactions []importers.Transaction) ([]Transaction, int, error) {
+	var firstSynced sql.NullTime
+	query := "SELECT MIN(date) FROM transactions WHERE user_id = $1 AND provider_type IN ($2, $3)"
+	if err := ScopeToUser(userID).QueryRowContext(ctx, query, ProviderPlaid, ProviderTeller).Scan(&firstSynced); err != nil {
+		return nil, 0, fmt.Errorf("failed to get first synced transaction date: %v", err)
+	}
+	// Only the live table has a unique index on provider_transaction_id, so archived imports are checked by hand
+	archived := map[string]bool{}
+	rows, err := ScopeToUser(userID).QueryContext(ctx, "SELECT provider_transaction_id FROM transactions_archive WHERE user_id = $1 AND provider_type = $2", source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get archived imported transactions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan archived imported transaction: %v", err)
+		}
+		archived[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating archived imported transactions: %v", err)
+	}
+
+	importable := make([]importers.Transaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		if firstSynced.Valid && !transaction.Date.Before(firstSynced.Time) {
+			continue
+		}
+		if archived[importedTransactionID(userID, transaction)] {
+			continue
+		}
+		importable = append(importable, transaction)
+	}
+
+	// Insert in batches to stay well under Postgres' bind parameter limit
+	const batchSize = 500
+	created := []Transaction{}
+	for batchStart := 0; batchStart < len(importable); batchStart += batchSize {
+		batch := importable[batchStart:min(batchStart+batchSize, len(importable))]
+		query := "INSERT INTO transactions (user_id, amount, date, description, category, currency, status, type, provider_type, personal_finance_category_primary, personal_finance_category_detailed, provider_transaction_id, account_ref, merchant) VALUES "
+		values := make([]interface{}, 0, len(batch)*10)
+		placeholders := make([]string, 0, len(batch))
+		for i, transaction := range batch {
+			offset := 1 + i*10
+			placeholders = append(placeholders, fmt.Sprintf("($1, $%d, $%d, $%d, $%d, '%s', 'posted', 'other', $%d, NULLIF($%d, ''), NULLIF($%d, ''), $%d, $%d, NULLIF($%d, ''))",
+				offset+1, offset+2, offset+3, offset+4, money.DefaultCurrency, offset+5, offset+6, offset+7, offset+8, offset+9, offset+10))
+			category := transaction.Category
+			if category == nil {
+				category = []string{}
+			}
+			categoryJSON, err := json.Marshal(category)
+			if err != nil {
+				return created, 0, fmt.Errorf("failed to marshal category: %v", err)
 			}
-			category = string(categoryJSON)
+			values = append(values,
+				transaction.Amount,
+				transaction.Date,
+				transaction.Description,
+				string(categoryJSON),
+				source,
+				transaction.PFCPrimary,
+				transaction.PFCDetailed,
+				importedTransactionID(userID, transaction),
+				transaction.Account,
+				transaction.Merchant,
+			)
+		}
+		query += strings.Join(placeholders, ", ") + " ON CONFLICT (provider_type, provider_transaction_id) DO NOTHING" +
+			" RETURNING id, amount::numeric, date, COALESCE(description, ''), currency, status, type," +
+			" COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, '')"
+		if created, err = scanImportedTransactions(ctx, userID, source, query, values, created); err != nil {
+			return created, 0, err
+		}
+	}
+	return created, len(transactions) - len(created), nil
+}
+
+// scanImportedTransactions runs one batch of ImportTransactions, appending the transactions it stored to created
+func scanImportedTransactions(ctx context.Context, userID int, source string, query string, values []interface{}, created []Transaction) ([]Transaction, error) {
+	rows, err := ScopeToUser(userID).QueryContext(ctx, query, values...)
+	if err != nil {
+		return created, fmt.Errorf("failed to insert imported transactions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		transaction := Transaction{UserID: userID, ProviderType: source}
+		if err := rows.Scan(&transaction.TransactionID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.PersonalFinanceCategoryPrimary, &transaction.PersonalFinanceCategoryDetailed); err != nil {
+			return created, fmt.Errorf("failed to scan imported transaction: %v", err)
+		}
+		created = append(created, transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return created, fmt.Errorf("error iterating imported transactions: %v", err)
+	}
+	return created, nil
+}
+
+// importedTransactionID is the provider_transaction_id of an imported transaction. Provider ids are unique per
+// provider across users, so it includes the user's id for two people importing the same export.
+func importedTransactionID(userID int, transaction importers.Transaction) string {
+	return fmt.Sprintf("%d-%s", userID, transaction.ID)
+}
+
+// ********** DEAD LETTER JOBS **********
+
+// DeadLetterJob is a worker job that failed
+type DeadLetterJob struct {
+	ID          int             `json:"id"`
+	JobID       string          `json:"job_id"`
+	JobType     string          `json:"job_type"`
+	Data        json.RawMessage `json:"data"`
+	Error       string          `json:"error"`
+	FailedAt    time.Time       `json:"failed_at"`
+	ReplayCount int             `json:"replay_count"`
+	ReplayedAt  *time.Time      `json:"replayed_at"`
+	TimedOut    bool            `json:"timed_out"`
+}
+
+// DeadLetterFilter narrows dead letters by job type and a case insensitive substring of the error,
+// optionally to jobs that timed out
+type DeadLetterFilter struct {
+	JobType         string
+	ErrorContains   string
+	TimedOut        bool
+	IncludeReplayed bool
+	Limit           int
+}
+
+// CreateDeadLetterJob records a failed job. data should have its secrets redacted; the secrets split out of it,
+// if any, are stored apart so only a replay reads them.
+func CreateDeadLetterJob(jobID string, jobType string, data json.RawMessage, secrets json.RawMessage, jobErr string, timedOut bool) error {
+	if len(data) == 0 {
+		data = json.RawMessage(`{}`)
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO dead_letter_jobs (job_id, job_type, data, error, timed_out) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	var deadLetterID int
+	if err := tx.QueryRow(query, jobID, jobType, string(data), jobErr, timedOut).Scan(&deadLetterID); err != nil {
+		return fmt.Errorf("failed to create dead letter job: %v", err)
+	}
+	if len(secrets) > 0 {
+		_, err := tx.Exec("INSERT INTO dead_letter_job_secrets (dead_letter_id, secrets) VALUES ($1, $2)", deadLetterID, string(secrets))
+		if err != nil {
+			return fmt.Errorf("failed to store dead letter job secrets: %v", err)
 		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit dead letter job: %v", err)
+	}
+	return nil
+}
 
-		var status string
-		if transaction.GetPending() {
-			status = "pending"
-		} else {
-			status = "posted"
+// GetDeadLetterJobSecrets returns the secrets split out of a dead letter's data, or nil if it had none
+func GetDeadLetterJobSecrets(id int) (json.RawMessage, error) {
+	var secrets []byte
+	err := DB.QueryRow("SELECT secrets FROM dead_letter_job_secrets WHERE dead_letter_id = $1", id).Scan(&secrets)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter job secrets: %v", err)
+	}
+	return json.RawMessage(secrets), nil
+}
+
+const deadLetterColumns = "id, job_id, job_type, data, error, failed_at, replay_count, replayed_at, timed_out"
+
+var deadLetterFilters = FilterSchema{
+	"job_type":  {Column: "job_type", Type: FilterString, Ops: []FilterOp{FilterEq}},
+	"error":     {Column: "error", Type: FilterString, Ops: []FilterOp{FilterContains}},
+	"timed_out": {Column: "timed_out", Type: FilterBool, Ops: []FilterOp{FilterEq}},
+}
+
+func scanDeadLetterJobs(rows *sql.Rows) ([]DeadLetterJob, error) {
+	defer rows.Close()
+	deadLetters := []DeadLetterJob{}
+	for rows.Next() {
+		var deadLetter DeadLetterJob
+		var data []byte
+		if err := rows.Scan(&deadLetter.ID, &deadLetter.JobID, &deadLetter.JobType, &data, &deadLetter.Error, &deadLetter.FailedAt, &deadLetter.ReplayCount, &deadLetter.ReplayedAt, &deadLetter.TimedOut); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter job: %v", err)
 		}
+		deadLetter.Data = json.RawMessage(data)
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dead letter jobs: %v", err)
+	}
+	return deadLetters, nil
+}
 
-		values = append(values,
-			userID,
-			accountID,
-			transaction.GetTransactionId(),
-			transaction.GetAmount(),
-			transaction.GetDate(),
-			transaction.GetName(),
-			category,
-			transaction.GetIsoCurrencyCode(),
-			status,
-			transaction.GetPaymentChannel(),
-			"plaid",
-		)
+// GetDeadLetterJobs returns dead letters matching the filter, most recent failures first
+func GetDeadLetterJobs(filter DeadLetterFilter) ([]DeadLetterJob, error) {
+	qb := &QueryBuilder{}
+	filters := []Filter{}
+	if filter.JobType != "" {
+		filters = append(filters, Filter{Field: "job_type", Op: FilterEq, Value: filter.JobType})
+	}
+	if filter.ErrorContains != "" {
+		filters = append(filters, Filter{Field: "error", Op: FilterContains, Value: filter.ErrorContains})
+	}
+	if filter.TimedOut {
+		filters = append(filters, Filter{Field: "timed_out", Op: FilterEq, Value: "true"})
+	}
+	if err := qb.Apply(deadLetterFilters, filters); err != nil {
+		return nil, err
 	}
+	if !filter.IncludeReplayed {
+		qb.Where("replayed_at IS NULL")
+	}
+	query := "SELECT " + deadLetterColumns + " FROM dead_letter_jobs" + qb.WhereClause() +
+		" ORDER BY failed_at DESC, id DESC LIMIT " + qb.Arg(filter.Limit)
+	rows, err := readQuery(query, qb.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letter jobs: %v", err)
+	}
+	return scanDeadLetterJobs(rows)
+}
 
-	query += strings.Join(placeholders, ", ")
-	_, err := DB.Exec(query, values...)
+func GetDeadLetterJob(id int) (*DeadLetterJob, error) {
+	rows, err := DB.Query("SELECT "+deadLetterColumns+" FROM dead_letter_jobs WHERE id = $1", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letter job: %v", err)
+	}
+	deadLetters, err := scanDeadLetterJobs(rows)
 	if err != nil {
-		return fmt.Errorf("failed to upsert plaid transactions: %v", err)
+		return nil, err
+	}
+	if len(deadLetters) == 0 {
+		return nil, fmt.Errorf("dead letter job not found")
 	}
+	return &deadLetters[0], nil
+}
 
+// MarkDeadLetterReplayed records that a dead letter was enqueued again. If the replay fails too,
+// the worker records it as a new dead letter.
+func MarkDeadLetterReplayed(id int) error {
+	query := "UPDATE dead_letter_jobs SET replay_count = replay_count + 1, replayed_at = CURRENT_TIMESTAMP WHERE id = $1"
+	if _, err := DB.Exec(query, id); err != nil {
+		return fmt.Errorf("failed to mark dead letter replayed: %v", err)
+	}
 	return nil
 }
 
-// ********** MONTHLY SUMMARY **********
+// ********** CATEGORY MAPPINGS **********
 
-func GetTransactionsExcludingCategories(userID int, categoriesToExclude []string, monthYear int) ([]Transaction, error) {
-	year := monthYear % 10000
-	month := monthYear / 10000
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	endDate := startDate.AddDate(0, 1, 0)
-	log.Printf("Getting transactions for user %d, categories to exclude %v, month %d", userID, categoriesToExclude, monthYear)
-	log.Printf("Start date: %s, End date: %s", startDate, endDate)
-	// Build the query to exclude transactions that contain any of the specified categories
-	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type FROM transactions WHERE user_id = $1 AND date BETWEEN $2 AND $3"
+// CategoryMapping maps a provider category onto a budget category. A nil UserID marks a global default.
+type CategoryMapping struct {
+	ID               int       `json:"id"`
+	UserID           *int      `json:"user_id"`
+	Provider         string    `json:"provider"`
+	ProviderCategory string    `json:"provider_category"`
+	BudgetCategory   string    `json:"budget_category"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
 
-	var rows *sql.Rows
-	var err error
+// GetCategoryMappings returns the user's own mappings followed by the global defaults
+func GetCategoryMappings(userID int) ([]CategoryMapping, error) {
+	query := "SELECT id, user_id, provider, provider_category, budget_category, created_at, updated_at FROM category_mappings WHERE (user_id = $1 OR user_id IS NULL) ORDER BY user_id IS NULL, provider, provider_category"
+	rows, err := ScopeToUser(userID).Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category mappings: %v", err)
+	}
+	defer rows.Close()
+	var mappings []CategoryMapping
+	for rows.Next() {
+		var mapping CategoryMapping
+		var mappingUserID sql.NullInt64
+		err := rows.Scan(&mapping.ID, &mappingUserID, &mapping.Provider, &mapping.ProviderCategory, &mapping.BudgetCategory, &mapping.CreatedAt, &mapping.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan category mapping: %v", err)
+		}
+		if mappingUserID.Valid {
+			id := int(mappingUserID.Int64)
+			mapping.UserID = &id
+		}
+		mappings = append(mappings, mapping)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating category mappings: %v", err)
+	}
+	return mappings, nil
+}
 
-	// If there are categories to exclude, add the exclusion condition
-	if len(categoriesToExclude) > 0 {
-		query += " AND NOT (category ?| $4::text[])"
-		// Convert []string to pq.StringArray for PostgreSQL
-		rows, err = DB.Query(query, userID, startDate, endDate, pq.Array(categoriesToExclude))
+// UpsertCategoryMapping creates or updates a mapping. Pass a nil userID for a global default.
+func UpsertCategoryMapping(userID *int, provider string, providerCategory string, budgetCategory string) (*CategoryMapping, error) {
+	query := "INSERT INTO category_mappings (user_id, provider, provider_category, budget_category) VALUES ($1, $2, $3, $4) ON CONFLICT (COALESCE(user_id, 0), provider, provider_category) DO UPDATE SET budget_category = EXCLUDED.budget_category RETURNING id, provider, provider_category, budget_category, created_at, updated_at"
+	mapping := CategoryMapping{UserID: userID}
+	err := DB.QueryRow(query, userID, provider, providerCategory, budgetCategory).Scan(&mapping.ID, &mapping.Provider, &mapping.ProviderCategory, &mapping.BudgetCategory, &mapping.CreatedAt, &mapping.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert category mapping: %v", err)
+	}
+	return &mapping, nil
+}
+
+// DeleteCategoryMapping deletes a mapping owned by the user, or a global mapping when userID is nil
+func DeleteCategoryMapping(userID *int, mappingID int) error {
+	var result sql.Result
+	var err error
+	if userID == nil {
+		result, err = DB.Exec("DELETE FROM category_mappings WHERE id = $1 AND user_id IS NULL", mappingID)
 	} else {
-		// If no categories to exclude, just get all transactions
-		rows, err = DB.Query(query, userID, startDate, endDate)
+		result, err = ScopeToUser(*userID).Exec("DELETE FROM category_mappings WHERE user_id = $1 AND id = $2", mappingID)
 	}
 	if err != nil {
-		log.Printf("Failed to query transactions: %v", err)
-		return nil, fmt.Errorf("failed to query transactions: %v", err)
+		return fmt.Errorf("failed to delete category mapping: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("category mapping not found")
+	}
+	return nil
+}
+
+// ********** ANALYTICS **********
+
+// CategoryMonthSpend is one category's spend within one month
+type CategoryMonthSpend struct {
+	Category   string  `json:"category"`
+	Budget     float64 `json:"budget"`
+	MonthYear  int     `json:"monthyear"`
+	TotalSpent float64 `json:"total_spent"`
+}
+
+// GetCategorySpendByMonth returns spend per budget category of monthYear for that month and the `against` months before it.
+// Each transaction is attributed to the first matching non-general category, falling back to "general".
+// Months with no spend for a category are omitted.
+func GetCategorySpendByMonth(userID int, monthYear int, against int) ([]CategoryMonthSpend, error) {
+	monthStart := MonthYearStart(monthYear)
+	rangeStart := monthStart.AddDate(0, -against, 0)
+	rangeEnd := monthStart.AddDate(0, 1, 0)
+	query := `
+		WITH budget AS (
+			SELECT category, budget FROM monthly_budget_spend_category WHERE user_id = $1 AND month_year = $2
+		),
+		categorized AS (
+			SELECT transactions.total_amount AS amount, date_trunc('month', transactions.date) AS month,
+				COALESCE((SELECT b.category FROM budget b WHERE b.category <> 'general' AND ` + budgetCategoryMatch + ` LIMIT 1), 'general') AS category
+			FROM ` + dailySpendSource + mappedCategoryJoin + `
+			WHERE transactions.user_id = $1 AND transactions.date >= $3 AND transactions.date < $4
+		)
+		SELECT b.category, b.budget,
+			(EXTRACT(MONTH FROM c.month)::int * 10000 + EXTRACT(YEAR FROM c.month)::int) AS monthyear,
+			COALESCE(SUM(c.amount), 0)
+		FROM budget b
+		JOIN categorized c ON c.category = b.category
+		GROUP BY b.category, b.budget, c.month
+		ORDER BY b.category, c.month
+	`
+	rows, err := ScopeToUser(userID).ReadQuery(query, monthYear, rangeStart, rangeEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category spend by month: %v", err)
 	}
 	defer rows.Close()
-	var transactions []Transaction
+	var spends []CategoryMonthSpend
 	for rows.Next() {
-		var transaction Transaction
-		err := rows.Scan(&transaction.TransactionID, &transaction.UserID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Category, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.ProviderType)
-		if err != nil {
-			log.Printf("Failed to scan transaction: %v", err)
-			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		var spend CategoryMonthSpend
+		if err := rows.Scan(&spend.Category, &spend.Budget, &spend.MonthYear, &spend.TotalSpent); err != nil {
+			return nil, fmt.Errorf("failed to scan category spend: %v", err)
 		}
-		transactions = append(transactions, transaction)
+		spends = append(spends, spend)
 	}
-	return transactions, nil
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating category spend: %v", err)
+	}
+	return spends, nil
 }
 
-func GetTransactionsByCategory(userID int, category string, monthYear int) ([]Transaction, error) {
+const (
+	// BudgetSuggestionMonths is how many months before the budgeted one suggestions average
+	BudgetSuggestionMonths = 3
+	// DefaultBudgetSuggestionTrim is the share of a category's highest spending days capped as outliers
+	DefaultBudgetSuggestionTrim = 0.05
+	MaxBudgetSuggestionTrim     = 0.5
+)
 
-	year := monthYear % 10000
-	month := monthYear / 10000
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	endDate := startDate.AddDate(0, 1, 0)
-	log.Printf("Getting transactions for user %d, category %s, month %d", userID, category, monthYear)
-	log.Printf("Start date: %s, End date: %s", startDate, endDate)
-	var rows *sql.Rows
-	var err error
-	// if category == "general" {
-	// 	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type FROM transactions WHERE user_id = $1 AND date BETWEEN $2 AND $3"
-	// 	rows, err = DB.Query(query, userID, startDate, endDate)
-	// 	if err != nil {
-	// 		log.Printf("Failed to query transactions: %v", err)
-	// 		return nil, fmt.Errorf("failed to query transactions: %v", err)
-	// 	}
-	// 	defer rows.Close()
-	// } else {
-	// Create the JSON array string properly
-	categoryJSON := fmt.Sprintf(`["%s"]`, category)
-	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type FROM transactions WHERE user_id = $1 AND date BETWEEN $2 AND $3 AND category @> $4::jsonb"
-	rows, err = DB.Query(query, userID, startDate, endDate, categoryJSON)
+// BudgetSuggestion is a budget proposed for a category from its recent spending
+type BudgetSuggestion struct {
+	Category string `json:"category"`
+	// SuggestedBudget is the average monthly spend with outlier days trimmed, rounded up to whole dollars
+	SuggestedBudget money.Money `json:"suggested_budget"`
+	AverageSpend    money.Money `json:"average_spend"`
+}
+
+// GetBudgetSuggestions proposes a budget per category for monthYear from the average monthly spend of the
+// BudgetSuggestionMonths months before it. Outliers are trimmed by capping each spending day at the category's
+// (1 - trim) percentile of daily spend, so one large purchase doesn't inflate the budget. Averages are over the
+// months the user has any spending in, which it also returns, so newer users aren't suggested too little.
+// Uncategorized spending is suggested as general.
+func GetBudgetSuggestions(userID int, monthYear int, trim float64) ([]BudgetSuggestion, int, error) {
+	monthStart := MonthYearStart(monthYear)
+	query := `
+		WITH daily AS (
+			SELECT ` + reportCategoryLabel + ` AS category, transactions.date, SUM(transactions.spend_amount) AS spend
+			FROM ` + dailySpendSource + mappedCategoryJoin + `
+			WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3` + reportDailySpendFilter + `
+			GROUP BY 1, 2
+		),
+		caps AS (
+			SELECT category, percentile_cont(1 - $4::float8) WITHIN GROUP (ORDER BY spend)::numeric AS cap
+			FROM daily GROUP BY category
+		)
+		SELECT d.category, SUM(d.spend), SUM(LEAST(d.spend, c.cap)),
+			(SELECT COUNT(DISTINCT date_trunc('month', date)) FROM daily)
+		FROM daily d
+		JOIN caps c ON c.category = d.category
+		GROUP BY d.category
+		ORDER BY 3 DESC
+	`
+	rows, err := ScopeToUser(userID).ReadQuery(query, monthStart.AddDate(0, -BudgetSuggestionMonths, 0), monthStart, trim)
 	if err != nil {
-		log.Printf("Failed to query transactions: %v", err)
-		return nil, fmt.Errorf("failed to query transactions: %v", err)
+		return nil, 0, fmt.Errorf("failed to query budget suggestions: %v", err)
 	}
 	defer rows.Close()
-	// }
-	var transactions []Transaction
+	type categoryTotals struct {
+		category       string
+		total, trimmed money.Money
+	}
+	var totals []categoryTotals
+	historyMonths := 0
 	for rows.Next() {
-		var transaction Transaction
-		err := rows.Scan(&transaction.TransactionID, &transaction.UserID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Category, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.ProviderType)
+		var row categoryTotals
+		if err := rows.Scan(&row.category, &row.total, &row.trimmed, &historyMonths); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan budget suggestion: %v", err)
+		}
+		totals = append(totals, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating budget suggestions: %v", err)
+	}
+
+	suggestions := make([]BudgetSuggestion, 0, len(totals))
+	for _, row := range totals {
+		category := row.category
+		if category == "Uncategorized" {
+			category = "general"
+		}
+		trimmedAverage := row.trimmed.Prorate(1, int64(historyMonths))
+		suggestions = append(suggestions, BudgetSuggestion{
+			Category:        category,
+			SuggestedBudget: money.FromCents((trimmedAverage.Cents() + 99) / 100 * 100).In(trimmedAverage.Currency()),
+			AverageSpend:    row.total.Prorate(1, int64(historyMonths)),
+		})
+	}
+	return suggestions, historyMonths, nil
+}
+
+// GetCategorySpendForTransactions returns monthYear's spend for only the budget categories the given transactions
+// are attributed to, as GetCategorySpendByMonth does. Transactions outside the month are ignored. It reads the
+// primary, so transactions a sync has just written are counted.
+func GetCategorySpendForTransactions(userID int, monthYear int, transactionIDs []string) ([]CategoryMonthSpend, error) {
+	if len(transactionIDs) == 0 {
+		return []CategoryMonthSpend{}, nil
+	}
+	monthStart := MonthYearStart(monthYear)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	query := `
+		WITH budget AS (
+			SELECT category, budget FROM monthly_budget_spend_category WHERE user_id = $1 AND month_year = $2
+		),
+		affected AS (
+			SELECT DISTINCT COALESCE((SELECT b.category FROM budget b WHERE b.category <> 'general' AND ` + budgetCategoryMatch + ` LIMIT 1), 'general') AS category
+			FROM transactions` + mappedCategoryJoin + `
+			WHERE transactions.user_id = $1 AND transactions.id::text = ANY($5) AND transactions.date >= $3 AND transactions.date < $4` + budgetedTransactionFilter + `
+		),
+		categorized AS (
+			SELECT transactions.total_amount AS amount,
+				COALESCE((SELECT b.category FROM budget b WHERE b.category <> 'general' AND ` + budgetCategoryMatch + ` LIMIT 1), 'general') AS category
+			FROM ` + dailySpendSource + mappedCategoryJoin + `
+			WHERE transactions.user_id = $1 AND transactions.date >= $3 AND transactions.date < $4
+		)
+		SELECT b.category, b.budget, COALESCE(SUM(c.amount), 0)
+		FROM budget b
+		JOIN affected a ON a.category = b.category
+		JOIN categorized c ON c.category = b.category
+		GROUP BY b.category, b.budget
+		ORDER BY b.category
+	`
+	rows, err := ScopeToUser(userID).Query(query, monthYear, monthStart, monthEnd, pq.Array(transactionIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category spend for transactions: %v", err)
+	}
+	defer rows.Close()
+	spends := []CategoryMonthSpend{}
+	for rows.Next() {
+		spend := CategoryMonthSpend{MonthYear: monthYear}
+		if err := rows.Scan(&spend.Category, &spend.Budget, &spend.TotalSpent); err != nil {
+			return nil, fmt.Errorf("failed to scan category spend: %v", err)
+		}
+		spends = append(spends, spend)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating category spend: %v", err)
+	}
+	return spends, nil
+}
+
+// ********** SAFE TO SPEND **********
+
+// GetDepositoryAvailableBalance sums the available balance of the user's linked Plaid and Teller depository accounts
+// that are included in budgeting, falling back to the current balance for accounts that don't report one. It also
+// returns how many accounts were summed.
+func GetDepositoryAvailableBalance(userID int) (money.Money, int, error) {
+	query := `
+		SELECT COALESCE(SUM(balance), 0), COUNT(*) FROM (
+			SELECT COALESCE(available_balance, current_balance, 0) AS balance FROM plaid_accounts
+			WHERE user_id = $1 AND account_type = 'depository' AND NOT ` + plaidAccountExcludedFromBudget + `
+			UNION ALL
+			SELECT COALESCE(available_balance, ledger_balance, 0) FROM teller_accounts
+			WHERE user_id = $1 AND account_type = 'depository' AND deleted_at IS NULL
+				AND NOT account_excluded_from_budget(user_id, 'teller', id::text)
+		) balances
+	`
+	var balance money.Money
+	var count int
+	err := ScopeToUser(userID).QueryRow(query).Scan(&balance, &count)
+	if err != nil {
+		return money.Money{}, 0, fmt.Errorf("failed to get depository available balance: %v", err)
+	}
+	return balance, count, nil
+}
+
+// GetRecentIncomeDates returns the dates of the user's most recent income deposits, newest first.
+// Plaid reports inflows as negative amounts, so income is an inflow categorised as INCOME.
+func GetRecentIncomeDates(userID int, limit int) ([]time.Time, error) {
+	query := "SELECT DISTINCT date FROM transactions WHERE user_id = $1 AND amount::numeric < 0 AND personal_finance_category_primary = 'INCOME' ORDER BY date DESC LIMIT $2"
+	rows, err := ScopeToUser(userID).Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query income dates: %v", err)
+	}
+	defer rows.Close()
+	var dates []time.Time
+	for rows.Next() {
+		var date time.Time
+		if err := rows.Scan(&date); err != nil {
+			return nil, fmt.Errorf("failed to scan income date: %v", err)
+		}
+		dates = append(dates, date)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating income dates: %v", err)
+	}
+	return dates, nil
+}
+
+// NextPayday projects the next payday after now from the most recent income dates (newest first),
+// repeating the gap between the last two paychecks. It returns false when there isn't enough history,
+// in which case callers should assume the user is paid at the start of next month.
+func NextPayday(incomeDates []time.Time, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if len(incomeDates) < 2 {
+		return time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0), false
+	}
+	last := time.Date(incomeDates[0].Year(), incomeDates[0].Month(), incomeDates[0].Day(), 0, 0, 0, 0, time.UTC)
+	previous := time.Date(incomeDates[1].Year(), incomeDates[1].Month(), incomeDates[1].Day(), 0, 0, 0, 0, time.UTC)
+	intervalDays := DaysInPeriod(previous, last)
+	if intervalDays <= 0 {
+		return time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0), false
+	}
+	payday := last.AddDate(0, 0, intervalDays)
+	for !payday.After(today) {
+		payday = payday.AddDate(0, 0, intervalDays)
+	}
+	return payday, true
+}
+
+// ********** CALENDAR **********
+
+const (
+	CalendarEventBill                = "bill"
+	CalendarEventFixedExpenses       = "fixed_expenses"
+	CalendarEventPlannedExpense      = "planned_expense"
+	CalendarEventIncome              = "income"
+	CalendarEventSavingsContribution = "savings_contribution"
+)
+
+const (
+	CalendarEventExpected = "expected"
+	CalendarEventOccurred = "occurred"
+)
+
+// recurringChargeActiveDays is how long after its last charge a recurring charge is still expected to repeat.
+// Monthly charges drift by a few days, so it allows a little over a month.
+const recurringChargeActiveDays = 45
+
+// RecurringCharge is a description charged in at least three different months, with its most recent charge
+type RecurringCharge struct {
+	Description string
+	Amount      money.Money
+	LastDate    time.Time
+}
+
+// CalendarEvent is a dated money movement in a calendar month. Expected events are projected from the user's
+// history; occurred events happened.
+type CalendarEvent struct {
+	Date             string      `json:"date"`
+	Kind             string      `json:"kind"`
+	Status           string      `json:"status"`
+	Title            string      `json:"title"`
+	Amount           money.Money `json:"amount"`
+	SavingsGoalID    *int        `json:"savings_goal_id,omitempty"`
+	PlannedExpenseID *int        `json:"planned_expense_id,omitempty"`
+}
+
+// GetRecurringCharges returns the outflows dated in [since, until) whose description was charged in at least three
+// different months, with the amount and date of the most recent charge, oldest last charge first
+func GetRecurringCharges(userID int, since time.Time, until time.Time) ([]RecurringCharge, error) {
+	query := `
+		WITH recurring AS (
+			SELECT description, MAX(date) AS last_date
+			FROM transactions
+			WHERE user_id = $1 AND date >= $2 AND date < $3 AND amount > 0 AND NOT is_transfer AND NOT is_flagged
+			GROUP BY description
+			HAVING COUNT(DISTINCT date_trunc('month', date)) >= 3
+		)
+		SELECT r.description, t.amount, r.last_date
+		FROM recurring r
+		JOIN LATERAL (
+			SELECT amount FROM transactions
+			WHERE user_id = $1 AND description = r.description AND date = r.last_date AND amount > 0
+			LIMIT 1
+		) t ON true
+		ORDER BY r.last_date, r.description
+	`
+	rows, err := ScopeToUser(userID).ReadQuery(query, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recurring charges: %v", err)
+	}
+	defer rows.Close()
+	var charges []RecurringCharge
+	for rows.Next() {
+		var charge RecurringCharge
+		if err := rows.Scan(&charge.Description, &charge.Amount, &charge.LastDate); err != nil {
+			return nil, fmt.Errorf("failed to scan recurring charge: %v", err)
+		}
+		charges = append(charges, charge)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recurring charges: %v", err)
+	}
+	return charges, nil
+}
+
+// NextRecurrence returns the same day of the following month as date, or that month's last day when it is shorter
+func NextRecurrence(date time.Time) time.Time {
+	return dayInMonth(time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0), date.Day())
+}
+
+// dayInMonth returns the day of the month starting at monthStart, or the month's last day when it is shorter
+func dayInMonth(monthStart time.Time, day int) time.Time {
+	if lastDay := monthStart.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+	return monthStart.AddDate(0, 0, day-1)
+}
+
+// IncomeDeposit is the income deposited on one day, as a positive amount
+type IncomeDeposit struct {
+	Date   time.Time
+	Amount money.Money
+}
+
+// GetIncomeDeposits returns the income deposited each day in [since, until), oldest first. Like
+// GetRecentIncomeDates, income is an inflow categorised as INCOME.
+func GetIncomeDeposits(userID int, since time.Time, until time.Time) ([]IncomeDeposit, error) {
+	query := "SELECT date, -SUM(amount) FROM transactions WHERE user_id = $1 AND date >= $2 AND date < $3" +
+		" AND amount < 0 AND personal_finance_category_primary = 'INCOME' GROUP BY date ORDER BY date"
+	rows, err := ScopeToUser(userID).ReadQuery(query, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query income deposits: %v", err)
+	}
+	defer rows.Close()
+	var deposits []IncomeDeposit
+	for rows.Next() {
+		var deposit IncomeDeposit
+		if err := rows.Scan(&deposit.Date, &deposit.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan income deposit: %v", err)
+		}
+		deposits = append(deposits, deposit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating income deposits: %v", err)
+	}
+	return deposits, nil
+}
+
+// BuildCalendar assembles the money movements of the month starting at monthStart: recurring bills, the month's
+// fixed expenses, planned expenses, income and savings contributions. What already happened is taken from the
+// user's transactions and contributions; from today on, bills and paydays are projected from the last three
+// months, and savings goals expect their required monthly contribution on the first payday still to come.
+func BuildCalendar(userID int, monthStart time.Time, now time.Time) ([]CalendarEvent, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	historyEnd := monthEnd
+	if tomorrow := today.AddDate(0, 0, 1); tomorrow.Before(historyEnd) {
+		historyEnd = tomorrow
+	}
+	historyStart := monthStart
+	if currentMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC); currentMonth.Before(historyStart) {
+		historyStart = currentMonth
+	}
+	historyStart = historyStart.AddDate(0, -3, 0)
+	inMonth := func(date time.Time) bool { return !date.Before(monthStart) && date.Before(monthEnd) }
+	events := []CalendarEvent{}
+	addEvent := func(date time.Time, kind string, status string, title string, amount money.Money) *CalendarEvent {
+		events = append(events, CalendarEvent{Date: date.Format("2006-01-02"), Kind: kind, Status: status, Title: title, Amount: amount})
+		return &events[len(events)-1]
+	}
+
+	charges, err := GetRecurringCharges(userID, historyStart, historyEnd)
+	if err != nil {
+		return nil, err
+	}
+	for _, charge := range charges {
+		if inMonth(charge.LastDate) {
+			addEvent(charge.LastDate, CalendarEventBill, CalendarEventOccurred, charge.Description, charge.Amount)
+			continue
+		}
+		expectedDate := dayInMonth(monthStart, charge.LastDate.Day())
+		if !expectedDate.Before(today) && DaysInPeriod(charge.LastDate, today) <= recurringChargeActiveDays {
+			addEvent(expectedDate, CalendarEventBill, CalendarEventExpected, charge.Description, charge.Amount)
+		}
+	}
+
+	// Fixed expenses are tracked as a monthly total, so they fall on the first of the month
+	if summary, err := GetMonthlySummary(userID, ToMonthYear(monthStart)); err == nil && summary.FixedExpenses.IsPositive() {
+		status := CalendarEventExpected
+		if !monthEnd.After(today) {
+			status = CalendarEventOccurred
+		}
+		addEvent(monthStart, CalendarEventFixedExpenses, status, "Fixed expenses", summary.FixedExpenses)
+	}
+
+	plannedExpenses, err := GetPlannedExpenses(userID, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, expense := range plannedExpenses {
+		var event *CalendarEvent
+		if expense.Status == PlannedExpenseOccurred && expense.OccurredOn != nil && inMonth(*expense.OccurredOn) {
+			event = addEvent(*expense.OccurredOn, CalendarEventPlannedExpense, CalendarEventOccurred, expense.Description, expense.Amount)
+		} else if expense.Status == PlannedExpensePlanned && expense.TargetMonthYear == ToMonthYear(monthStart) {
+			event = addEvent(monthStart, CalendarEventPlannedExpense, CalendarEventExpected, expense.Description, expense.Amount)
+		}
+		if event != nil {
+			event.PlannedExpenseID = &expense.ID
+		}
+	}
+
+	deposits, err := GetIncomeDeposits(userID, historyStart, historyEnd)
+	if err != nil {
+		return nil, err
+	}
+	var paydays []time.Time
+	for _, deposit := range deposits {
+		if inMonth(deposit.Date) {
+			addEvent(deposit.Date, CalendarEventIncome, CalendarEventOccurred, "Income", deposit.Amount)
+		}
+	}
+	// Paydays repeat the gap between the last two deposits, as NextPayday does
+	if n := len(deposits); n >= 2 {
+		last, previous := deposits[n-1], deposits[n-2]
+		if intervalDays := DaysInPeriod(previous.Date, last.Date); intervalDays > 0 {
+			for payday := last.Date.AddDate(0, 0, intervalDays); payday.Before(monthEnd); payday = payday.AddDate(0, 0, intervalDays) {
+				if !payday.Before(today) && inMonth(payday) {
+					addEvent(payday, CalendarEventIncome, CalendarEventExpected, "Expected income", last.Amount)
+					paydays = append(paydays, payday)
+				}
+			}
+		}
+	}
+
+	contributed, err := addSavingsContributionEvents(userID, monthStart, historyEnd, addEvent)
+	if err != nil {
+		return nil, err
+	}
+	if monthEnd.After(today) {
+		contributionDate := monthStart
+		if today.After(contributionDate) {
+			contributionDate = today
+		}
+		if len(paydays) > 0 {
+			contributionDate = paydays[0]
+		}
+		goals, err := GetSavingsGoals(userID)
 		if err != nil {
-			log.Printf("Failed to scan transaction: %v", err)
-			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+			return nil, err
+		}
+		for _, goal := range goals {
+			if goal.Redeemed || contributed[goal.ID] || goal.RequiredMonthlyContribution == nil || *goal.RequiredMonthlyContribution <= 0 {
+				continue
+			}
+			if goal.TargetDate != nil && goal.TargetDate.Before(monthStart) {
+				continue
+			}
+			event := addEvent(contributionDate, CalendarEventSavingsContribution, CalendarEventExpected, goal.Name, money.FromFloat(*goal.RequiredMonthlyContribution))
+			event.SavingsGoalID = &goal.ID
 		}
-		transactions = append(transactions, transaction)
 	}
 
-	return transactions, nil
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Date < events[j].Date })
+	return events, nil
 }
 
-func GetAllTransactions(userID int, monthYear int) ([]Transaction, error) {
-	year := monthYear % 10000
-	month := monthYear / 10000
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	endDate := startDate.AddDate(0, 1, 0)
-	log.Printf("Getting all transactions for user %d, month %d", userID, monthYear)
-	log.Printf("Start date: %s, End date: %s", startDate, endDate)
-	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type FROM transactions WHERE user_id = $1 AND date BETWEEN $2 AND $3"
-	rows, err := DB.Query(query, userID, startDate, endDate)
+// addSavingsContributionEvents adds the contributions made to the user's savings goals in [since, until) as
+// occurred events and returns the goals that were contributed to
+func addSavingsContributionEvents(userID int, since time.Time, until time.Time, addEvent func(time.Time, string, string, string, money.Money) *CalendarEvent) (map[int]bool, error) {
+	query := "SELECT c.saving_goal_id, g.name, c.amount, c.contributed_at FROM saving_goal_contributions c" +
+		" JOIN saving_goal g ON g.id = c.saving_goal_id" +
+		" WHERE c.user_id = $1 AND c.contributed_at >= $2 AND c.contributed_at < $3 ORDER BY c.contributed_at"
+	rows, err := ScopeToUser(userID).ReadQuery(query, since, until)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get all transactions: %v", err)
+		return nil, fmt.Errorf("failed to query savings contributions: %v", err)
 	}
 	defer rows.Close()
-	var transactions []Transaction
+	contributed := map[int]bool{}
 	for rows.Next() {
-		var transaction Transaction
-		err := rows.Scan(&transaction.TransactionID, &transaction.UserID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Category, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.ProviderType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		var goalID int
+		var name string
+		var amount money.Money
+		var contributedAt time.Time
+		if err := rows.Scan(&goalID, &name, &amount, &contributedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan savings contribution: %v", err)
 		}
-		transactions = append(transactions, transaction)
+		event := addEvent(contributedAt, CalendarEventSavingsContribution, CalendarEventOccurred, name, amount)
+		event.SavingsGoalID = &goalID
+		contributed[goalID] = true
 	}
-	return transactions, nil
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating savings contributions: %v", err)
+	}
+	return contributed, nil
 }
 
-// func GetOrCreateMonthlySummary(userID int, monthYear int) (*MonthlySummary, error) {
-// 	monthlySummary, _ := GetMonthlySummary(userID, monthYear)
-// 	if monthlySummary != nil {
-// 		return monthlySummary, nil
-// 	}
-// 	monthlySummary, err := CreateMonthlySummary(userID, monthYear)
-// 	if err != nil {
-// 		log.Printf("Failed to create monthly summary: %v", err)
-// 		return nil, fmt.Errorf("failed to create monthly summary: %v", err)
-// 	}
-// 	return monthlySummary, nil
-// }
+// ********** QUERY FILTERS **********
 
-func UpsertMonthlySummary(userID int, monthYear int, totalSpent float64, startingBalance float64, income float64, savedAmount float64, invested float64, fixedExpenses float64, savingTargetPercentage float64, budget float64) (*MonthlySummary, error) {
-	existingMonthlySummary, _ := GetMonthlySummary(userID, monthYear)
-	if existingMonthlySummary == nil {
-		return CreateMonthlySummary(userID, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, budget)
-	}
+// FilterType is how a filter value from a request is parsed before it is bound as a parameter
+type FilterType int
 
-	query := "INSERT INTO monthly_summary (user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (user_id, monthyear) DO UPDATE SET total_spent = $3, starting_balance = $4, income = $5, saved_amount = $6, invested = $7, fixed_expenses = $8, saving_target_percentage = $9 RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, created_at, updated_at"
-	var monthlySummary MonthlySummary
+const (
+	FilterString FilterType = iota
+	FilterNumber
+	FilterDate
+	FilterBool
+)
 
-	err := DB.QueryRow(query, userID, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
-	if err != nil {
-		log.Printf("Failed to upsert monthly summary: %v", err)
-		return nil, fmt.Errorf("failed to upsert monthly summary: %v", err)
-	}
-	return &monthlySummary, nil
+// FilterOp is a comparison a caller may ask for
+type FilterOp string
+
+const (
+	FilterEq       FilterOp = "eq"
+	FilterNeq      FilterOp = "neq"
+	FilterGt       FilterOp = "gt"
+	FilterGte      FilterOp = "gte"
+	FilterLt       FilterOp = "lt"
+	FilterLte      FilterOp = "lte"
+	FilterIn       FilterOp = "in"
+	FilterContains FilterOp = "contains"
+)
+
+var filterOpSQL = map[FilterOp]string{
+	FilterEq:  "=",
+	FilterNeq: "<>",
+	FilterGt:  ">",
+	FilterGte: ">=",
+	FilterLt:  "<",
+	FilterLte: "<=",
 }
 
-func CreateMonthlySummary(userID int, monthYear int, totalSpent float64, startingBalance float64, income float64, savedAmount float64, invested float64, fixedExpenses float64, savingTargetPercentage float64, budget float64) (*MonthlySummary, error) {
-	query := "INSERT INTO monthly_summary (user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, created_at, updated_at"
-	var monthlySummary MonthlySummary
+var (
+	stringFilterOps = []FilterOp{FilterEq, FilterNeq, FilterIn}
+	rangeFilterOps  = []FilterOp{FilterEq, FilterNeq, FilterGt, FilterGte, FilterLt, FilterLte}
+	textFilterOps   = []FilterOp{FilterEq, FilterContains}
+)
 
-	err := DB.QueryRow(query, userID, monthYear, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
-	if err != nil {
-		log.Printf("Failed to create monthly summary: %v", err)
-		return nil, fmt.Errorf("failed to create monthly summary: %v", err)
-	}
+// maxFilterInValues caps the values of an "in" filter
+const maxFilterInValues = 50
 
-	_, err = CreateMonthlyBudgetSpendCategory(userID, monthlySummary.ID, monthYear, "general", budget)
-	if err != nil {
-		log.Printf("Failed to create monthly budget spend category: %v", err)
-		return nil, fmt.Errorf("failed to create monthly budget spend category: %v", err)
-	}
-	return &monthlySummary, nil
+// FilterField whitelists a field for filtering and sorting. Column is trusted SQL written in this package
+// and is the only thing interpolated into queries; request values are always bound as parameters.
+type FilterField struct {
+	Column   string
+	Type     FilterType
+	Ops      []FilterOp
+	Sortable bool
 }
 
-func HasAnyMonthlySummaries(userID int) (bool, error) {
-	var count int
-	query := "SELECT COUNT(*) FROM monthly_summary WHERE user_id = $1"
-	err := DB.QueryRow(query, userID).Scan(&count)
-	if err != nil {
-		return false, fmt.Errorf("failed to count monthly summaries: %v", err)
+// FilterSchema maps the field names an endpoint exposes to their columns
+type FilterSchema map[string]FilterField
+
+// Filter is one condition as it arrives in a request; Value is parsed according to the field's type.
+// For FilterIn, Value is a comma separated list.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value string
+}
+
+// ListQuery is a filtered, sorted page of a list endpoint
+type ListQuery struct {
+	Filters []Filter
+	Sort    string
+	Desc    bool
+	Limit   int
+	Offset  int
+	// IncludeArchived also searches transactions moved to the archive; ignored by non-transaction lists
+	IncludeArchived bool
+	// IncludeHidden also lists accounts the user hid; ignored by non-account lists
+	IncludeHidden bool
+}
+
+// FilterError reports a filter the schema doesn't allow, so handlers can answer 400 instead of 500
+type FilterError struct {
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return e.Message
+}
+
+// QueryBuilder collects WHERE conditions and numbers their bind parameters
+type QueryBuilder struct {
+	conditions []string
+	args       []interface{}
+	// reserved is how many placeholders whoever runs the query binds before the builder's own
+	reserved int
+}
+
+// Arg binds a value and returns its placeholder
+func (qb *QueryBuilder) Arg(value interface{}) string {
+	qb.args = append(qb.args, value)
+	return fmt.Sprintf("$%d", qb.reserved+len(qb.args))
+}
+
+// Where adds a trusted condition. Values must go through Arg, never into the condition string.
+func (qb *QueryBuilder) Where(condition string) {
+	qb.conditions = append(qb.conditions, condition)
+}
+
+// Args returns the bound parameters in placeholder order, after any reserved ones
+func (qb *QueryBuilder) Args() []interface{} {
+	return qb.args
+}
+
+// WhereClause returns the conditions joined into a WHERE clause, or an empty string when there are none
+func (qb *QueryBuilder) WhereClause() string {
+	if len(qb.conditions) == 0 {
+		return ""
 	}
-	return count > 0, nil
+	return " WHERE " + strings.Join(qb.conditions, " AND ")
 }
 
-func GetMonthlySummary(userID int, monthYear int) (*MonthlySummary, error) {
-	query := "SELECT id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, created_at, updated_at FROM monthly_summary WHERE user_id = $1 AND monthyear = $2"
-	var monthlySummary MonthlySummary
-	err := DB.QueryRow(query, userID, monthYear).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
-	if err != nil {
-		log.Printf("Failed to get monthly summary: %v", err)
-		return nil, fmt.Errorf("failed to get monthly summary: %v", err)
+// Apply validates filters against the schema and adds them as conditions
+func (qb *QueryBuilder) Apply(schema FilterSchema, filters []Filter) error {
+	for _, filter := range filters {
+		field, ok := schema[filter.Field]
+		if !ok {
+			return &FilterError{Message: fmt.Sprintf("cannot filter on %q", filter.Field)}
+		}
+		allowed := false
+		for _, op := range field.Ops {
+			if op == filter.Op {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &FilterError{Message: fmt.Sprintf("operator %q is not supported for %q", filter.Op, filter.Field)}
+		}
+
+		switch filter.Op {
+		case FilterIn:
+			rawValues := strings.Split(filter.Value, ",")
+			if len(rawValues) > maxFilterInValues {
+				return &FilterError{Message: fmt.Sprintf("too many values for %q, at most %d are allowed", filter.Field, maxFilterInValues)}
+			}
+			placeholders := make([]string, 0, len(rawValues))
+			for _, rawValue := range rawValues {
+				value, err := parseFilterValue(field.Type, strings.TrimSpace(rawValue))
+				if err != nil {
+					return &FilterError{Message: fmt.Sprintf("invalid value for %q: %v", filter.Field, err)}
+				}
+				placeholders = append(placeholders, qb.Arg(value))
+			}
+			qb.Where(field.Column + " IN (" + strings.Join(placeholders, ", ") + ")")
+		case FilterContains:
+			// Escape LIKE wildcards so the value only ever matches literally
+			escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(filter.Value)
+			qb.Where(field.Column + " ILIKE " + qb.Arg("%"+escaped+"%"))
+		default:
+			value, err := parseFilterValue(field.Type, filter.Value)
+			if err != nil {
+				return &FilterError{Message: fmt.Sprintf("invalid value for %q: %v", filter.Field, err)}
+			}
+			qb.Where(field.Column + " " + filterOpSQL[filter.Op] + " " + qb.Arg(value))
+		}
 	}
-	return &monthlySummary, nil
+	return nil
 }
 
-func UpdateMonthlySummaryTotalSpent(monthlySummary MonthlySummary) (*MonthlySummary, error) {
-	query := "UPDATE monthly_summary SET total_spent = $1 WHERE id = $2 RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, created_at, updated_at"
-	var updatedMonthlySummary MonthlySummary
-	err := DB.QueryRow(query, monthlySummary.TotalSpent, monthlySummary.ID).Scan(&updatedMonthlySummary.ID, &updatedMonthlySummary.UserID, &updatedMonthlySummary.MonthYear, &updatedMonthlySummary.TotalSpent, &updatedMonthlySummary.StartingBalance, &updatedMonthlySummary.Income, &updatedMonthlySummary.SavedAmount, &updatedMonthlySummary.Invested, &updatedMonthlySummary.FixedExpenses, &updatedMonthlySummary.SavingTargetPercentage, &updatedMonthlySummary.CreatedAt, &updatedMonthlySummary.UpdatedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update monthly summary: %v", err)
+// OrderAndPage returns the ORDER BY, LIMIT and OFFSET for a list query. tieBreaker is a trusted column
+// appended to the sort so pages are stable.
+func (qb *QueryBuilder) OrderAndPage(schema FilterSchema, listQuery ListQuery, defaultSort string, tieBreaker string) (string, error) {
+	sortName := listQuery.Sort
+	if sortName == "" {
+		sortName = defaultSort
 	}
-	return &updatedMonthlySummary, nil
+	field, ok := schema[sortName]
+	if !ok || !field.Sortable {
+		return "", &FilterError{Message: fmt.Sprintf("cannot sort by %q", sortName)}
+	}
+	direction := "ASC"
+	if listQuery.Desc {
+		direction = "DESC"
+	}
+	clause := fmt.Sprintf(" ORDER BY %s %s, %s %s", field.Column, direction, tieBreaker, direction)
+	clause += " LIMIT " + qb.Arg(listQuery.Limit) + " OFFSET " + qb.Arg(listQuery.Offset)
+	return clause, nil
 }
 
-func UpdateMonthlySummary(userID int, monthYear int, totalSpent float64, startingBalance float64, income float64, savedAmount float64, invested float64, fixedExpenses float64, savingTargetPercentage float64) (*MonthlySummary, error) {
-	query := "UPDATE monthly_summary SET total_spent = $1, starting_balance = $2, income = $3, saved_amount = $4, invested = $5, fixed_expenses = $6, saving_target_percentage = $7 WHERE user_id = $8 AND monthyear = $9 RETURNING id, user_id, monthyear, total_spent, starting_balance, income, saved_amount, invested, fixed_expenses, saving_target_percentage, created_at, updated_at"
-	var monthlySummary MonthlySummary
-	err := DB.QueryRow(query, totalSpent, startingBalance, income, savedAmount, invested, fixedExpenses, savingTargetPercentage, userID, monthYear).Scan(&monthlySummary.ID, &monthlySummary.UserID, &monthlySummary.MonthYear, &monthlySummary.TotalSpent, &monthlySummary.StartingBalance, &monthlySummary.Income, &monthlySummary.SavedAmount, &monthlySummary.Invested, &monthlySummary.FixedExpenses, &monthlySummary.SavingTargetPercentage, &monthlySummary.CreatedAt, &monthlySummary.UpdatedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update monthly summary: %v", err)
+func parseFilterValue(filterType FilterType, raw string) (interface{}, error) {
+	switch filterType {
+	case FilterNumber:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("expected a number")
+		}
+		return value, nil
+	case FilterDate:
+		value, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected a date formatted YYYY-MM-DD")
+		}
+		return value, nil
+	case FilterBool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false")
+		}
+		return value, nil
+	default:
+		if raw == "" {
+			return nil, fmt.Errorf("expected a value")
+		}
+		return raw, nil
 	}
-	return &monthlySummary, nil
 }
 
-// ********** MONTHLY BUDGET SPEND CATEGORY **********
+// TransactionFilters are the fields transaction lists and analytics can be filtered by
+var TransactionFilters = FilterSchema{
+	"date":              {Column: "transactions.date", Type: FilterDate, Ops: rangeFilterOps, Sortable: true},
+	"amount":            {Column: "transactions.amount::numeric", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"description":       {Column: "transactions.description", Type: FilterString, Ops: textFilterOps, Sortable: true},
+	"category":          {Column: "transactions.personal_finance_category_primary", Type: FilterString, Ops: stringFilterOps},
+	"detailed_category": {Column: "transactions.personal_finance_category_detailed", Type: FilterString, Ops: stringFilterOps},
+	"account_id":        {Column: "transactions.plaid_account_id", Type: FilterString, Ops: stringFilterOps},
+	"status":            {Column: "transactions.status", Type: FilterString, Ops: stringFilterOps},
+	"provider_type":     {Column: "transactions.provider_type", Type: FilterString, Ops: stringFilterOps},
+	"city":              {Column: "transactions.location_city", Type: FilterString, Ops: textFilterOps},
+	"country":           {Column: "transactions.location_country", Type: FilterString, Ops: stringFilterOps},
+	"is_business":       {Column: "transactions.is_business", Type: FilterBool, Ops: []FilterOp{FilterEq}},
+}
 
-func GetMonthlyBudgetSpendCategory(userID int, monthlySummaryID int, monthYear int, category string) (*MonthlyBudgetSpendCategory, error) {
-	query := "SELECT id, user_id, monthly_summary_id, month_year, category, budget, total_spent, daily_allowance, created_at, updated_at FROM monthly_budget_spend_category WHERE user_id = $1 AND monthly_summary_id = $2 AND month_year = $3 AND category = $4"
-	var monthlyBudgetSpendCategory MonthlyBudgetSpendCategory
-	err := DB.QueryRow(query, userID, monthlySummaryID, monthYear, category).Scan(&monthlyBudgetSpendCategory.ID, &monthlyBudgetSpendCategory.UserID, &monthlyBudgetSpendCategory.MonthlySummaryID, &monthlyBudgetSpendCategory.MonthYear, &monthlyBudgetSpendCategory.Category, &monthlyBudgetSpendCategory.Budget, &monthlyBudgetSpendCategory.TotalSpent, &monthlyBudgetSpendCategory.DailyAllowance, &monthlyBudgetSpendCategory.CreatedAt, &monthlyBudgetSpendCategory.UpdatedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get monthly budget spend category: %v", err)
+// AccountFilters are the fields account lists can be filtered by
+var AccountFilters = FilterSchema{
+	"name":                {Column: "account_name", Type: FilterString, Ops: textFilterOps, Sortable: true},
+	"type":                {Column: "account_type", Type: FilterString, Ops: stringFilterOps, Sortable: true},
+	"subtype":             {Column: "account_subtype", Type: FilterString, Ops: stringFilterOps},
+	"current_balance":     {Column: "current_balance", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"available_balance":   {Column: "available_balance", Type: FilterNumber, Ops: rangeFilterOps, Sortable: true},
+	"is_processed":        {Column: "is_processed", Type: FilterBool, Ops: []FilterOp{FilterEq}},
+	"exclude_from_budget": {Column: plaidAccountExcludedFromBudget, Type: FilterBool, Ops: []FilterOp{FilterEq}},
+	"nickname":            {Column: "nickname", Type: FilterString, Ops: textFilterOps, Sortable: true},
+	"hidden":              {Column: "hidden", Type: FilterBool, Ops: []FilterOp{FilterEq}},
+}
+
+const (
+	plaidAccountExcludedFromBudget = "account_excluded_from_budget(user_id, 'plaid', id)"
+	plaidAccountHidden             = "account_hidden(user_id, 'plaid', id)"
+)
+
+// plaidAccountSource is plaid_accounts with each account's display settings and institution alongside it
+const plaidAccountSource = "(SELECT plaid_accounts.*, account_settings.nickname, account_settings.color, account_settings.icon," +
+	" COALESCE(account_settings.hidden, FALSE) AS hidden, institutions.institution_id, institutions.name AS institution_name," +
+	" institutions.logo AS institution_logo, institutions.primary_color AS institution_primary_color, institutions.url AS institution_url" +
+	" FROM plaid_accounts LEFT JOIN account_settings" +
+	" ON account_settings.user_id = plaid_accounts.user_id AND account_settings.provider_type = 'plaid'" +
+	" AND account_settings.account_ref = plaid_accounts.id" +
+	" LEFT JOIN plaid_tokens ON plaid_tokens.id = plaid_accounts.plaid_token_id" +
+	" LEFT JOIN institutions ON institutions.institution_id = plaid_tokens.institution_id) plaid_accounts"
+
+// ListTransactions returns a page of the user's transactions matching the filters
+func ListTransactions(userID int, listQuery ListQuery) ([]Transaction, error) {
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("transactions.user_id")
+	return listTransactions(scope, qb, listQuery)
+}
+
+// ListAccountTransactions returns a page of the transactions on one of the user's accounts matching the filters.
+// Accounts are matched on the provider's own account id column, which is indexed with the date.
+func ListAccountTransactions(userID int, provider string, accountID string, listQuery ListQuery) ([]Transaction, error) {
+	accountColumn := "transactions.plaid_account_id"
+	if provider == ProviderTeller {
+		accountColumn = "transactions.teller_account_id"
 	}
-	return &monthlyBudgetSpendCategory, nil
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("transactions.user_id")
+	qb.Where(accountColumn + " = " + qb.Arg(accountID))
+	return listTransactions(scope, qb, listQuery)
 }
 
-func CreateMonthlyBudgetSpendCategory(userID int, monthlySummaryID int, monthYear int, category string, budget float64) (*MonthlyBudgetSpendCategory, error) {
-	query := "INSERT INTO monthly_budget_spend_category (user_id, monthly_summary_id, month_year, category, budget, total_spent) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, user_id, monthly_summary_id, month_year, category, budget, total_spent, created_at, updated_at"
-	var monthlyBudgetSpendCategory MonthlyBudgetSpendCategory
-	err := DB.QueryRow(query, userID, monthlySummaryID, monthYear, category, budget, 0).Scan(&monthlyBudgetSpendCategory.ID, &monthlyBudgetSpendCategory.UserID, &monthlyBudgetSpendCategory.MonthlySummaryID, &monthlyBudgetSpendCategory.MonthYear, &monthlyBudgetSpendCategory.Category, &monthlyBudgetSpendCategory.Budget, &monthlyBudgetSpendCategory.TotalSpent, &monthlyBudgetSpendCategory.CreatedAt, &monthlyBudgetSpendCategory.UpdatedAt)
-	if err != nil {
-		log.Printf("Failed to create monthly budget spend category: %v", err)
-		return nil, fmt.Errorf("failed to create monthly budget spend category: %v", err)
+// maxSearchTerms bounds how many words of a search are matched
+const maxSearchTerms = 8
+
+// TransactionSearchResult is a transaction matching a search, with the matched words in its description,
+// merchant and notes wrapped in <mark> tags
+type TransactionSearchResult struct {
+	Transaction
+	Merchant             string  `json:"merchant"`
+	Notes                string  `json:"notes"`
+	Rank                 float64 `json:"rank"`
+	DescriptionHighlight string  `json:"description_highlight"`
+	MerchantHighlight    string  `json:"merchant_highlight"`
+	NotesHighlight       string  `json:"notes_highlight"`
+}
+
+// searchTsQuery turns what the user typed into a tsquery matching transactions containing every word, the last
+// words as prefixes too so results appear while typing. It returns "" when nothing searchable was typed.
+func searchTsQuery(search string) string {
+	words := strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
 	}
-	return &monthlyBudgetSpendCategory, nil
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = word + ":*"
+	}
+	return strings.Join(terms, " & ")
 }
 
-func GetCategoriesToExclude(userID int, monthYear int) ([]string, error) {
-	monthlySummary, err := GetMonthlySummary(userID, monthYear)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get monthly summary: %w", err)
+// SearchTransactions returns a page of the user's transactions whose merchant, description or notes match the search
+// and the filters, most relevant first unless listQuery sorts otherwise
+func SearchTransactions(userID int, search string, listQuery ListQuery) ([]TransactionSearchResult, error) {
+	results := []TransactionSearchResult{}
+	tsQuery := searchTsQuery(search)
+	if tsQuery == "" {
+		return results, nil
 	}
-	monthlyBudgetSpendCategories, _, err := GetMonthlyBudgetSpendCategories(monthlySummary.ID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get monthly budget spend categories: %w", err)
+
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("transactions.user_id")
+	queryArg := "to_tsquery('simple', " + qb.Arg(tsQuery) + ")"
+	qb.Where("transactions.search_vector @@ " + queryArg)
+	if err := qb.Apply(TransactionFilters, listQuery.Filters); err != nil {
+		return nil, err
 	}
-	log.Printf("🔄 Monthly budget spend categories: %v", monthlyBudgetSpendCategories)
-	// Build a list of all categories except "general"
-	categoriesToExclude := []string{}
-	for _, category := range monthlyBudgetSpendCategories {
-		if category.Category != "general" {
-			categoriesToExclude = append(categoriesToExclude, category.Category)
+	orderAndPage := " ORDER BY rank DESC, transactions.date DESC, transactions.id LIMIT " + qb.Arg(listQuery.Limit) + " OFFSET " + qb.Arg(listQuery.Offset)
+	if listQuery.Sort != "" {
+		var err error
+		orderAndPage, err = qb.OrderAndPage(TransactionFilters, listQuery, "date", "transactions.id")
+		if err != nil {
+			return nil, err
 		}
 	}
-	return categoriesToExclude, nil
-}
 
-func GetMonthlyBudgetSpendCategories(monthlySummaryID int) ([]MonthlyBudgetSpendCategory, float64, error) {
-	query := "SELECT id, user_id, monthly_summary_id, month_year, category, budget, total_spent, daily_allowance, created_at, updated_at FROM monthly_budget_spend_category WHERE monthly_summary_id = $1"
-	var monthlyBudgetSpendCategories []MonthlyBudgetSpendCategory
-	rows, err := DB.Query(query, monthlySummaryID)
+	// ts_headline is costly enough that Postgres only runs it on the rows left after the LIMIT
+	const highlightOptions = "'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'"
+	query := "SELECT id, user_id, amount, date, COALESCE(description, ''), category, currency, status, type, provider_type," +
+		" COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, ''), COALESCE(merchant, '')," +
+		" COALESCE(notes, ''), is_business, " + transactionLocationColumns + "," +
+		" ts_rank(search_vector, " + queryArg + ") AS rank," +
+		" ts_headline('simple', COALESCE(description, ''), " + queryArg + ", " + highlightOptions + ")," +
+		" ts_headline('simple', COALESCE(merchant, ''), " + queryArg + ", " + highlightOptions + ")," +
+		" ts_headline('simple', COALESCE(notes, ''), " + queryArg + ", " + highlightOptions + ")" +
+		" FROM " + transactionSource(listQuery.IncludeArchived) + qb.WhereClause() + orderAndPage
+	rows, err := scope.ReadQuery(query, qb.Args()...)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get monthly budget spend categories: %v", err)
+		return nil, fmt.Errorf("failed to search transactions: %v", err)
 	}
 	defer rows.Close()
-	totalDailyAllowance := 0.0
 	for rows.Next() {
-		var monthlyBudgetSpendCategory MonthlyBudgetSpendCategory
-		err := rows.Scan(&monthlyBudgetSpendCategory.ID, &monthlyBudgetSpendCategory.UserID, &monthlyBudgetSpendCategory.MonthlySummaryID, &monthlyBudgetSpendCategory.MonthYear, &monthlyBudgetSpendCategory.Category, &monthlyBudgetSpendCategory.Budget, &monthlyBudgetSpendCategory.TotalSpent, &monthlyBudgetSpendCategory.DailyAllowance, &monthlyBudgetSpendCategory.CreatedAt, &monthlyBudgetSpendCategory.UpdatedAt)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to scan monthly budget spend category: %v", err)
+		var result TransactionSearchResult
+		var location TransactionLocation
+		dest := append([]interface{}{&result.TransactionID, &result.UserID, &result.Amount, &result.TransactionDate, &result.Description, &result.Category, &result.Currency, &result.Status, &result.Type, &result.ProviderType, &result.PersonalFinanceCategoryPrimary, &result.PersonalFinanceCategoryDetailed, &result.Merchant, &result.Notes, &result.IsBusiness}, location.dest()...)
+		if err := rows.Scan(append(dest, &result.Rank, &result.DescriptionHighlight, &result.MerchantHighlight, &result.NotesHighlight)...); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction search result: %v", err)
 		}
-		monthlyBudgetSpendCategories = append(monthlyBudgetSpendCategories, monthlyBudgetSpendCategory)
-		totalDailyAllowance += monthlyBudgetSpendCategory.DailyAllowance
+		result.Location = location.orNil()
+		results = append(results, result)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("error iterating monthly budget spend categories: %v", err)
+		return nil, fmt.Errorf("error iterating transaction search results: %v", err)
 	}
-	return monthlyBudgetSpendCategories, totalDailyAllowance, nil
+	return results, nil
 }
 
-func UpdateMonthlyBudgetSpendCategory(monthlyBudgetSpendCategory MonthlyBudgetSpendCategory) error {
-	query := "UPDATE monthly_budget_spend_category SET total_spent = $1, daily_allowance = $2 WHERE id = $3"
-	_, err := DB.Exec(query, monthlyBudgetSpendCategory.TotalSpent, monthlyBudgetSpendCategory.DailyAllowance, monthlyBudgetSpendCategory.ID)
+// listTransactions returns a page of the user's transactions matching qb's conditions and the filters
+func listTransactions(scope UserScope, qb *QueryBuilder, listQuery ListQuery) ([]Transaction, error) {
+	if err := qb.Apply(TransactionFilters, listQuery.Filters); err != nil {
+		return nil, err
+	}
+	orderAndPage, err := qb.OrderAndPage(TransactionFilters, listQuery, "date", "transactions.id")
 	if err != nil {
-		return fmt.Errorf("failed to update monthly budget spend category: %v", err)
+		return nil, err
 	}
-	return nil
+	query := "SELECT id, user_id, amount, date, description, category, currency, status, type, provider_type," +
+		" COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, ''), is_business, " + transactionLocationColumns +
+		" FROM " + transactionSource(listQuery.IncludeArchived) + qb.WhereClause() + orderAndPage
+	rows, err := scope.ReadQuery(query, qb.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions: %v", err)
+	}
+	defer rows.Close()
+	transactions := []Transaction{}
+	for rows.Next() {
+		var transaction Transaction
+		var location TransactionLocation
+		dest := []interface{}{&transaction.TransactionID, &transaction.UserID, &transaction.Amount, &transaction.TransactionDate, &transaction.Description, &transaction.Category, &transaction.Currency, &transaction.Status, &transaction.Type, &transaction.ProviderType, &transaction.PersonalFinanceCategoryPrimary, &transaction.PersonalFinanceCategoryDetailed, &transaction.IsBusiness}
+		if err := rows.Scan(append(dest, location.dest()...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transaction.Location = location.orNil()
+		transactions = append(transactions, transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transactions: %v", err)
+	}
+	return transactions, nil
 }
 
-// ********** MONTHLY BALANCE **********
+// PlaidAccount is a linked account as stored from Plaid, without its access token
+type PlaidAccount struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	OfficialName     string  `json:"official_name"`
+	Type             string  `json:"type"`
+	Subtype          string  `json:"subtype"`
+	Currency         string  `json:"currency"`
+	CurrentBalance   float64 `json:"current_balance"`
+	AvailableBalance float64 `json:"available_balance"`
+	IsProcessed      bool    `json:"is_processed"`
+	// ExcludeFromBudget, Nickname, Color, Icon and Hidden are set with UpsertAccountSettings
+	ExcludeFromBudget bool    `json:"exclude_from_budget"`
+	Nickname          *string `json:"nickname"`
+	Color             *string `json:"color"`
+	Icon              *string `json:"icon"`
+	Hidden            bool    `json:"hidden"`
+	// Institution is nil until the refresh_institutions job has fetched the bank's details
+	Institution *Institution `json:"institution"`
+}
 
-func GetOrCreateMonthlyBalance(userID int, monthYear int) (*MonthlyBalance, error) {
-	monthlyBalance, _ := GetMonthlyBalance(userID, monthYear)
-	if monthlyBalance != nil {
-		return monthlyBalance, nil
+// ListPlaidAccounts returns a page of the user's linked accounts matching the filters. Hidden accounts are left
+// out unless listQuery.IncludeHidden is set.
+func ListPlaidAccounts(userID int, listQuery ListQuery) ([]PlaidAccount, error) {
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("user_id")
+	if !listQuery.IncludeHidden {
+		qb.Where("NOT hidden")
 	}
-	monthlyBalance, err := CreateMonthlyBalance(userID, monthYear)
+	if err := qb.Apply(AccountFilters, listQuery.Filters); err != nil {
+		return nil, err
+	}
+	orderAndPage, err := qb.OrderAndPage(AccountFilters, listQuery, "name", "id")
 	if err != nil {
-		log.Printf("Failed to create monthly balance: %v", err)
-		return nil, fmt.Errorf("failed to create monthly balance: %v", err)
+		return nil, err
 	}
-	return monthlyBalance, nil
-}
-
-func HasAnyMonthlyBalances(userID int) (bool, error) {
-	var count int
-	query := "SELECT COUNT(*) FROM monthly_balance WHERE user_id = $1"
-	err := DB.QueryRow(query, userID).Scan(&count)
+	query := "SELECT id, COALESCE(account_name, ''), COALESCE(official_name, ''), COALESCE(account_type, ''), COALESCE(account_subtype, '')," +
+		" COALESCE(currency, ''), COALESCE(current_balance, 0), COALESCE(available_balance, 0), COALESCE(is_processed, FALSE), " +
+		plaidAccountExcludedFromBudget + ", nickname, color, icon, hidden, institution_id, institution_name, institution_logo," +
+		" institution_primary_color, institution_url FROM " + plaidAccountSource + qb.WhereClause() + orderAndPage
+	rows, err := scope.ReadQuery(query, qb.Args()...)
 	if err != nil {
-		return false, fmt.Errorf("failed to count monthly balances: %v", err)
+		return nil, fmt.Errorf("failed to list plaid accounts: %v", err)
 	}
-	return count > 0, nil
+	defer rows.Close()
+	accounts := []PlaidAccount{}
+	for rows.Next() {
+		var account PlaidAccount
+		var institutionID, institutionName sql.NullString
+		var institution Institution
+		if err := rows.Scan(&account.ID, &account.Name, &account.OfficialName, &account.Type, &account.Subtype, &account.Currency, &account.CurrentBalance, &account.AvailableBalance, &account.IsProcessed, &account.ExcludeFromBudget, &account.Nickname, &account.Color, &account.Icon, &account.Hidden,
+			&institutionID, &institutionName, &institution.Logo, &institution.PrimaryColor, &institution.URL); err != nil {
+			return nil, fmt.Errorf("failed to scan plaid account: %v", err)
+		}
+		if institutionID.Valid {
+			institution.InstitutionID, institution.Name = institutionID.String, institutionName.String
+			account.Institution = &institution
+		}
+		accounts = append(accounts, account)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plaid accounts: %v", err)
+	}
+	return accounts, nil
 }
 
-func GetMonthlyBalance(userID int, monthYear int) (*MonthlyBalance, error) {
-	query := "SELECT id, user_id, monthyear, total_owing, net_cash, available_balance, current_balance, created_at, updated_at FROM monthly_balance WHERE user_id = $1 AND monthyear = $2"
-	var monthlyBalance MonthlyBalance
-	err := DB.QueryRow(query, userID, monthYear).Scan(&monthlyBalance.ID, &monthlyBalance.UserID, &monthlyBalance.MonthYear, &monthlyBalance.TotalOwing, &monthlyBalance.NetCash, &monthlyBalance.AvailableBalance, &monthlyBalance.CurrentBalance, &monthlyBalance.CreatedAt, &monthlyBalance.UpdatedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get monthly balance: %v", err)
-	}
-	return &monthlyBalance, nil
+// CategorySpend is the total spend of one category
+type CategorySpend struct {
+	Category         string  `json:"category"`
+	TotalSpent       float64 `json:"total_spent"`
+	TransactionCount int     `json:"transaction_count"`
 }
 
-func CreateMonthlyBalance(userID int, monthYear int) (*MonthlyBalance, error) {
-	query := "INSERT INTO monthly_balance (user_id, monthyear, total_owing, net_cash, available_balance, current_balance) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, user_id, monthyear, total_owing, net_cash, available_balance, current_balance, created_at, updated_at"
-	var monthlyBalance MonthlyBalance
-	err := DB.QueryRow(query, userID, monthYear, 0, 0, 0, 0).Scan(&monthlyBalance.ID, &monthlyBalance.UserID, &monthlyBalance.MonthYear, &monthlyBalance.TotalOwing, &monthlyBalance.NetCash, &monthlyBalance.AvailableBalance, &monthlyBalance.CurrentBalance, &monthlyBalance.CreatedAt, &monthlyBalance.UpdatedAt)
+// DaySpend is the total spend of one calendar day
+type DaySpend struct {
+	Date             string      `json:"date"`
+	TotalSpent       money.Money `json:"total_spent"`
+	TransactionCount int         `json:"transaction_count"`
+}
+
+// GetDailySpend totals the user's spend on each day of monthYear from the daily aggregates, counting what
+// GetSpendByCategory counts. Every day of the month is returned, days without spend as zero.
+func GetDailySpend(userID int, monthYear int) ([]DaySpend, error) {
+	monthStart := MonthYearStart(monthYear)
+	query := `
+		WITH spend AS (
+			SELECT transactions.date, transactions.spend_amount, transactions.spend_count
+			FROM ` + dailySpendSource + `
+			WHERE transactions.user_id = $1 AND transactions.date >= $2 AND transactions.date < $3` + reportDailySpendFilter + `
+		)
+		SELECT to_char(d.day, 'YYYY-MM-DD'), COALESCE(SUM(spend.spend_amount), 0), COALESCE(SUM(spend.spend_count), 0)
+		FROM generate_series($2::date, $3::date - 1, INTERVAL '1 day') AS d(day)
+		LEFT JOIN spend ON spend.date = d.day::date
+		GROUP BY d.day
+		ORDER BY d.day
+	`
+	rows, err := ScopeToUser(userID).ReadQuery(query, monthStart, monthStart.AddDate(0, 1, 0))
 	if err != nil {
-		log.Printf("Failed to create monthly balance: %v", err)
-		return nil, fmt.Errorf("failed to create monthly balance: %v", err)
+		return nil, fmt.Errorf("failed to query daily spend: %v", err)
 	}
-	return &monthlyBalance, nil
+	defer rows.Close()
+	days := []DaySpend{}
+	for rows.Next() {
+		var day DaySpend
+		if err := rows.Scan(&day.Date, &day.TotalSpent, &day.TransactionCount); err != nil {
+			return nil, fmt.Errorf("failed to scan daily spend: %v", err)
+		}
+		days = append(days, day)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating daily spend: %v", err)
+	}
+	return days, nil
 }
 
-func UpdateMonthlyBalance(userID int, monthYear int, totalOwing float64, netCash float64, availableBalance float64, currentBalance float64) (*MonthlyBalance, error) {
-	query := "UPDATE monthly_balance SET total_owing = $1, net_cash = $2, available_balance = $3, current_balance = $4 WHERE user_id = $5 AND monthyear = $6 RETURNING id, user_id, monthyear, total_owing, net_cash, available_balance, current_balance, created_at, updated_at"
-	var monthlyBalance MonthlyBalance
-	err := DB.QueryRow(query, totalOwing, netCash, availableBalance, currentBalance, userID, monthYear).Scan(&monthlyBalance.ID, &monthlyBalance.UserID, &monthlyBalance.MonthYear, &monthlyBalance.TotalOwing, &monthlyBalance.NetCash, &monthlyBalance.AvailableBalance, &monthlyBalance.CurrentBalance, &monthlyBalance.CreatedAt, &monthlyBalance.UpdatedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update monthly balance: %v", err)
+// dailySpendFilterFields are the TransactionFilters fields daily_category_spend keeps
+var dailySpendFilterFields = map[string]bool{"date": true, "category": true, "detailed_category": true}
+
+// canUseDailySpend reports whether a spend query over the live transactions can be answered from daily_category_spend
+func canUseDailySpend(listQuery ListQuery) bool {
+	if listQuery.IncludeArchived {
+		return false
 	}
-	return &monthlyBalance, nil
+	for _, filter := range listQuery.Filters {
+		if !dailySpendFilterFields[filter.Field] {
+			return false
+		}
+	}
+	return true
 }
 
-// ********** SAVING GOALS **********
+// CitySpend is the total spend in one city, with the average position of the purchases made there
+type CitySpend struct {
+	City             string   `json:"city"`
+	Region           string   `json:"region"`
+	Country          string   `json:"country"`
+	TotalSpent       float64  `json:"total_spent"`
+	TransactionCount int      `json:"transaction_count"`
+	Lat              *float64 `json:"lat"`
+	Lon              *float64 `json:"lon"`
+}
 
-func GetSavingsGoals(userID int) ([]SavingsGoal, error) {
-	query := "SELECT id, user_id, name, currently_saved, total, redeemed, created_at, updated_at FROM saving_goal WHERE user_id = $1"
-	rows, err := DB.Query(query, userID)
+// GetSpendByCity totals the user's spend per city over the transactions matching the filters, largest first.
+// Only purchases with a known city count, so online spending is left out along with transfers and loan payments.
+func GetSpendByCity(userID int, listQuery ListQuery) ([]CitySpend, error) {
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("transactions.user_id")
+	qb.Where(strings.TrimPrefix(reportSpendFilter, " AND "))
+	if err := qb.Apply(TransactionFilters, listQuery.Filters); err != nil {
+		return nil, err
+	}
+	return spendByCity(scope, qb, transactionSource(listQuery.IncludeArchived))
+}
+
+// spendByCity totals the spend per city of the user's transactions in source matching qb's conditions, largest first
+func spendByCity(scope UserScope, qb *QueryBuilder, source string) ([]CitySpend, error) {
+	qb.Where("transactions.location_city IS NOT NULL")
+	query := "SELECT transactions.location_city, COALESCE(transactions.location_region, ''), COALESCE(transactions.location_country, '')," +
+		" SUM(transactions.amount::numeric), COUNT(*), AVG(transactions.location_lat), AVG(transactions.location_lon)" +
+		" FROM " + source + qb.WhereClause() +
+		" GROUP BY 1, 2, 3 ORDER BY 4 DESC"
+	rows, err := scope.ReadQuery(query, qb.Args()...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query savings goals: %v", err)
+		return nil, fmt.Errorf("failed to query spend by city: %v", err)
 	}
 	defer rows.Close()
-
-	var savingsGoals []SavingsGoal
+	spends := []CitySpend{}
 	for rows.Next() {
-		var savingsGoal SavingsGoal
-		err := rows.Scan(&savingsGoal.ID, &savingsGoal.UserID, &savingsGoal.Name, &savingsGoal.CurrentSaved, &savingsGoal.TotalAmount, &savingsGoal.Redeemed, &savingsGoal.CreatedAt, &savingsGoal.UpdatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan savings goal: %v", err)
+		var spend CitySpend
+		if err := rows.Scan(&spend.City, &spend.Region, &spend.Country, &spend.TotalSpent, &spend.TransactionCount, &spend.Lat, &spend.Lon); err != nil {
+			return nil, fmt.Errorf("failed to scan city spend: %v", err)
 		}
-		savingsGoals = append(savingsGoals, savingsGoal)
+		spends = append(spends, spend)
 	}
-
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating savings goals: %v", err)
+		return nil, fmt.Errorf("error iterating city spend: %v", err)
 	}
-
-	return savingsGoals, nil
+	return spends, nil
 }
 
-func CreateSavingsGoal(userID int, name string, totalAmount float64, currentSaved float64) (*SavingsGoal, error) {
-	query := "INSERT INTO saving_goal (user_id, name, total, redeemed, currently_saved) VALUES ($1, $2, $3, $4, $5) RETURNING id, user_id, name, total, redeemed, currently_saved, created_at, updated_at"
-	var savingsGoal SavingsGoal
-	err := DB.QueryRow(query, userID, name, totalAmount, false, currentSaved).Scan(&savingsGoal.ID, &savingsGoal.UserID, &savingsGoal.Name, &savingsGoal.TotalAmount, &savingsGoal.Redeemed, &savingsGoal.CurrentSaved, &savingsGoal.CreatedAt, &savingsGoal.UpdatedAt)
+// GetSpendByCategory totals the user's spend per category over the transactions matching the filters,
+// largest first. Transfers and loan payments aren't spending and are left out. Filters on date and category
+// alone are answered from the daily aggregates; anything else scans the transactions.
+func GetSpendByCategory(userID int, listQuery ListQuery) ([]CategorySpend, error) {
+	scope := ScopeToUser(userID)
+	qb := scope.QueryBuilder("transactions.user_id")
+	source, totals := transactionSource(listQuery.IncludeArchived), "SUM(transactions.amount::numeric), COUNT(*)"
+	if canUseDailySpend(listQuery) {
+		source, totals = dailySpendSource, "SUM(transactions.spend_amount), SUM(transactions.spend_count)"
+		qb.Where(strings.TrimPrefix(reportDailySpendFilter, " AND "))
+	} else {
+		qb.Where(strings.TrimPrefix(reportSpendFilter, " AND "))
+	}
+	if err := qb.Apply(TransactionFilters, listQuery.Filters); err != nil {
+		return nil, err
+	}
+	query := "SELECT " + reportCategoryLabel + " AS category, " + totals +
+		" FROM " + source + mappedCategoryJoin + qb.WhereClause() +
+		" GROUP BY 1 ORDER BY 2 DESC"
+	rows, err := scope.ReadQuery(query, qb.Args()...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create savings goal: %v", err)
+		return nil, fmt.Errorf("failed to query spend by category: %v", err)
 	}
-	return &savingsGoal, nil
+	defer rows.Close()
+	spends := []CategorySpend{}
+	for rows.Next() {
+		var spend CategorySpend
+		if err := rows.Scan(&spend.Category, &spend.TotalSpent, &spend.TransactionCount); err != nil {
+			return nil, fmt.Errorf("failed to scan category spend: %v", err)
+		}
+		spends = append(spends, spend)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating category spend: %v", err)
+	}
+	return spends, nil
 }
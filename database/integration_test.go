@@ -0,0 +1,248 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+	"watson/money"
+	"watson/testenv"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) {
+	var err error
+	env, err = testenv.Start()
+	if err != nil {
+		log.Fatalf("Failed to start test environment: %v", err)
+	}
+	code := func() int {
+		defer env.Close()
+		if err := InitDB(env.DatabaseURL); err != nil {
+			log.Printf("Failed to connect to test database: %v", err)
+			return 1
+		}
+		defer CloseDB()
+		if err := testenv.Migrate(DB); err != nil {
+			log.Printf("Failed to migrate test database: %v", err)
+			return 1
+		}
+		return m.Run()
+	}()
+	os.Exit(code)
+}
+
+// createTestUser registers a user with an email no other test uses
+func createTestUser(t *testing.T) *DBUser {
+	t.Helper()
+	user, err := CreateUser(fmt.Sprintf("%s-%d@example.com", t.Name(), time.Now().UnixNano()), "password")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func TestMigrationsDownAndUpAgain(t *testing.T) {
+	url, err := env.NewDatabase("watson_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := testenv.Migrate(db); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if err := testenv.MigrateDown(db); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if err := testenv.Migrate(db); err != nil {
+		t.Fatalf("up after down: %v", err)
+	}
+}
+
+func TestCreateUserEmailTaken(t *testing.T) {
+	user := createTestUser(t)
+
+	if _, err := CreateUser(user.Email, "password"); err != ErrEmailTaken {
+		t.Errorf("CreateUser with a registered email = %v, want ErrEmailTaken", err)
+	}
+	found, err := GetUserByEmail(user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if found == nil || found.UserID != user.UserID {
+		t.Errorf("GetUserByEmail = %+v, want user %d", found, user.UserID)
+	}
+}
+
+func TestUpsertMonthlySummaryKeepsBudgetPeriod(t *testing.T) {
+	user := createTestUser(t)
+	monthYear := ToMonthYear(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	anchor := time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC)
+
+	_, err := UpsertMonthlySummary(user.UserID, monthYear, money.FromCents(0), money.FromCents(500000), money.FromCents(400000),
+		money.FromCents(0), money.FromCents(0), money.FromCents(150000), 20, money.FromCents(200000), BudgetPeriodBiweekly, &anchor)
+	if err != nil {
+		t.Fatalf("UpsertMonthlySummary: %v", err)
+	}
+	_, err = UpsertMonthlySummary(user.UserID, monthYear, money.FromCents(1234), money.FromCents(500000), money.FromCents(400000),
+		money.FromCents(0), money.FromCents(0), money.FromCents(150000), 20, money.FromCents(200000), BudgetPeriodBiweekly, &anchor)
+	if err != nil {
+		t.Fatalf("UpsertMonthlySummary again: %v", err)
+	}
+
+	summary, err := GetMonthlySummary(user.UserID, monthYear)
+	if err != nil {
+		t.Fatalf("GetMonthlySummary: %v", err)
+	}
+	if summary == nil {
+		t.Fatal("GetMonthlySummary found no summary")
+	}
+	if summary.TotalSpent.Cents() != 1234 {
+		t.Errorf("total spent = %s, want 12.34", summary.TotalSpent)
+	}
+	if summary.BudgetPeriod != BudgetPeriodBiweekly || summary.PeriodAnchorDate == nil || !summary.PeriodAnchorDate.Equal(anchor) {
+		t.Errorf("budget period = %s anchored %v, want biweekly anchored %s", summary.BudgetPeriod, summary.PeriodAnchorDate, anchor)
+	}
+}
+
+func TestTellerAccountsSyncedOnceEachHasSynced(t *testing.T) {
+	user := createTestUser(t)
+	institution, err := UpsertTellerInstitution(user.UserID, "Test Bank", fmt.Sprintf("enr_%d", user.UserID), fmt.Sprintf("token_%d", user.UserID))
+	if err != nil {
+		t.Fatalf("UpsertTellerInstitution: %v", err)
+	}
+	accountIDs := []string{fmt.Sprintf("acc_%d_1", user.UserID), fmt.Sprintf("acc_%d_2", user.UserID)}
+	for _, accountID := range accountIDs {
+		_, err := DB.Exec(`
+			INSERT INTO teller_accounts (id, user_id, teller_institution_id, enrollment_id, account_name, account_type,
+				account_subtype, currency, last_four, institution_id, institution_name)
+			VALUES ($1, $2, $3, $4, 'Checking', 'depository', 'checking', 'USD', '1234', 'test_bank', 'Test Bank')
+		`, accountID, user.UserID, institution.ID, institution.TellerID)
+		if err != nil {
+			t.Fatalf("failed to insert teller account: %v", err)
+		}
+	}
+
+	for i, accountID := range accountIDs {
+		if synced, err := GetAllTellerAccountsSynced(user.UserID); err != nil || synced {
+			t.Fatalf("GetAllTellerAccountsSynced with %d of %d synced = %v, %v; want false", i, len(accountIDs), synced, err)
+		}
+		if err := RecordAccountSync("teller", accountID, user.UserID, nil); err != nil {
+			t.Fatalf("RecordAccountSync: %v", err)
+		}
+	}
+	if synced, err := GetAllTellerAccountsSynced(user.UserID); err != nil || !synced {
+		t.Errorf("GetAllTellerAccountsSynced with every account synced = %v, %v; want true", synced, err)
+	}
+}
+
+func TestDeadLetterJob(t *testing.T) {
+	jobID := fmt.Sprintf("job_%d", time.Now().UnixNano())
+	if err := CreateDeadLetterJob(jobID, "fetch_transactions", nil, nil, "teller returned 502", false); err != nil {
+		t.Fatalf("CreateDeadLetterJob: %v", err)
+	}
+
+	deadLetters, err := GetDeadLetterJobs(DeadLetterFilter{JobType: "fetch_transactions", ErrorContains: "502", Limit: 100})
+	if err != nil {
+		t.Fatalf("GetDeadLetterJobs: %v", err)
+	}
+	for _, deadLetter := range deadLetters {
+		if deadLetter.JobID == jobID {
+			if string(deadLetter.Data) != "{}" || deadLetter.ReplayCount != 0 {
+				t.Errorf("dead letter = %+v, want empty data never replayed", deadLetter)
+			}
+			return
+		}
+	}
+	t.Errorf("GetDeadLetterJobs didn't return job %s", jobID)
+}
+
+func TestSearchTransactionsMatchesNotes(t *testing.T) {
+	user := createTestUser(t)
+	var transactionID string
+	err := DB.QueryRow(`
+		INSERT INTO transactions (user_id, amount, date, description, category, currency, status, type, provider_type, provider_transaction_id, merchant)
+		VALUES ($1, 249.99, '2025-03-14', 'IKEA 0123', '[]', 'USD', 'posted', 'other', 'plaid', $2, 'IKEA')
+		RETURNING id
+	`, user.UserID, fmt.Sprintf("search_%d", user.UserID)).Scan(&transactionID)
+	if err != nil {
+		t.Fatalf("failed to insert transaction: %v", err)
+	}
+	if updated, err := SetTransactionNotes(user.UserID, transactionID, "Desk for the home office"); err != nil || !updated {
+		t.Fatalf("SetTransactionNotes = %v, %v; want true", updated, err)
+	}
+
+	results, err := SearchTransactions(user.UserID, "desk", ListQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchTransactions: %v", err)
+	}
+	if len(results) != 1 || results[0].TransactionID != transactionID {
+		t.Fatalf("SearchTransactions found %+v, want transaction %s", results, transactionID)
+	}
+	if results[0].NotesHighlight != "<mark>Desk</mark> for the home office" {
+		t.Errorf("notes highlight = %q", results[0].NotesHighlight)
+	}
+	if updated, err := SetTransactionNotes(user.UserID+1, transactionID, "mine now"); err != nil || updated {
+		t.Errorf("SetTransactionNotes for another user = %v, %v; want false", updated, err)
+	}
+}
+
+func TestDataImportFilesDeletedOnCompletion(t *testing.T) {
+	user := createTestUser(t)
+	dataImport, err := CreateDataImport(user.UserID, "ynab", DataImportFiles{Register: []byte("Date,Payee\n")})
+	if err != nil {
+		t.Fatalf("CreateDataImport: %v", err)
+	}
+	files, err := GetDataImportFiles(user.UserID, dataImport.ID)
+	if err != nil || files == nil || string(files.Register) != "Date,Payee\n" || files.Budget != nil {
+		t.Fatalf("GetDataImportFiles = %+v, %v; want the register without a budget", files, err)
+	}
+	if files, err := GetDataImportFiles(user.UserID+1, dataImport.ID); err != nil || files != nil {
+		t.Errorf("GetDataImportFiles for another user = %+v, %v; want nil", files, err)
+	}
+
+	if err := CompleteDataImport(dataImport.ID, 0, 0, 0); err != nil {
+		t.Fatalf("CompleteDataImport: %v", err)
+	}
+	if files, err := GetDataImportFiles(user.UserID, dataImport.ID); err != nil || files != nil {
+		t.Errorf("GetDataImportFiles after completion = %+v, %v; want nil", files, err)
+	}
+}
+
+func TestArchiveTransactionsBeforeKeepsColumns(t *testing.T) {
+	user := createTestUser(t)
+	var transactionID string
+	err := DB.QueryRow(`
+		INSERT INTO transactions (user_id, amount, date, description, category, currency, status, type, provider_type, provider_transaction_id, merchant, notes, location_city)
+		VALUES ($1, 12.50, '2000-01-15', 'CAFE 42', '[]', 'USD', 'posted', 'other', 'plaid', $2, 'Cafe', 'Team lunch', 'Lisbon')
+		RETURNING id
+	`, user.UserID, fmt.Sprintf("archive_%d", user.UserID)).Scan(&transactionID)
+	if err != nil {
+		t.Fatalf("failed to insert transaction: %v", err)
+	}
+	if _, err := ArchiveTransactionsBefore(context.Background(), time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("ArchiveTransactionsBefore: %v", err)
+	}
+
+	var merchant, notes, city string
+	var archivedAt time.Time
+	err = DB.QueryRow("SELECT merchant, notes, location_city, archived_at FROM transactions_archive WHERE id = $1", transactionID).
+		Scan(&merchant, &notes, &city, &archivedAt)
+	if err != nil {
+		t.Fatalf("failed to read archived transaction: %v", err)
+	}
+	if merchant != "Cafe" || notes != "Team lunch" || city != "Lisbon" || archivedAt.IsZero() {
+		t.Errorf("archived transaction = %q, %q, %q, %v; want its columns as they were", merchant, notes, city, archivedAt)
+	}
+}
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_transactions_plaid_transaction_id;
+
+DROP TRIGGER IF EXISTS update_plaid_backfill_progress_updated_at ON plaid_backfill_progress;
+
+DROP TABLE IF EXISTS plaid_backfill_progress;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS plaid_backfill_progress (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    plaid_account_id VARCHAR NOT NULL UNIQUE REFERENCES plaid_accounts(id) ON DELETE CASCADE,
+    months_requested INTEGER NOT NULL DEFAULT 24,
+    months_completed INTEGER NOT NULL DEFAULT 0,
+    next_offset INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_plaid_backfill_progress_user_id ON plaid_backfill_progress(user_id);
+
+CREATE TRIGGER update_plaid_backfill_progress_updated_at
+    BEFORE UPDATE ON plaid_backfill_progress
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Remove duplicate Plaid transactions so re-fetched pages can be upserted
+DELETE FROM transactions a
+    USING transactions b
+    WHERE a.plaid_transaction_id IS NOT NULL
+    AND a.plaid_transaction_id = b.plaid_transaction_id
+    AND a.ctid > b.ctid;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_plaid_transaction_id ON transactions(plaid_transaction_id);
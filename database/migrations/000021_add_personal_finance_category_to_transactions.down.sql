@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_transactions_pfc_detailed;
+DROP INDEX IF EXISTS idx_transactions_pfc_primary;
+
+ALTER TABLE transactions
+    DROP COLUMN personal_finance_category_primary,
+    DROP COLUMN personal_finance_category_detailed;
@@ -0,0 +1,6 @@
+ALTER TABLE transactions
+    ADD COLUMN personal_finance_category_primary VARCHAR(100),
+    ADD COLUMN personal_finance_category_detailed VARCHAR(150);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_pfc_primary ON transactions(personal_finance_category_primary);
+CREATE INDEX IF NOT EXISTS idx_transactions_pfc_detailed ON transactions(personal_finance_category_detailed);
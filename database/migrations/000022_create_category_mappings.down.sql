@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS mapped_budget_category(INTEGER, VARCHAR, JSONB, VARCHAR, VARCHAR);
+
+DROP TRIGGER IF EXISTS update_category_mappings_updated_at ON category_mappings;
+
+DROP TABLE IF EXISTS category_mappings;
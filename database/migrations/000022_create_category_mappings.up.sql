@@ -0,0 +1,47 @@
+-- Maps provider categories (Plaid legacy paths, personal finance categories, Teller categories)
+-- onto budget category names. Rows with a NULL user_id are global defaults managed by admins.
+CREATE TABLE IF NOT EXISTS category_mappings (
+    id serial PRIMARY KEY,
+    user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+    provider VARCHAR(20) NOT NULL CHECK (provider IN ('plaid', 'teller')),
+    provider_category VARCHAR(255) NOT NULL,
+    budget_category VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_category_mappings_unique ON category_mappings(COALESCE(user_id, 0), provider, provider_category);
+CREATE INDEX IF NOT EXISTS idx_category_mappings_provider_category ON category_mappings(provider_category);
+
+CREATE TRIGGER update_category_mappings_updated_at
+    BEFORE UPDATE ON category_mappings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Resolves the budget category a transaction maps to. User mappings win over global ones, and more specific
+-- provider categories (PFC detailed, full legacy path) win over broader ones (PFC primary, top-level legacy category).
+-- Only mappings for the transaction's provider apply. Transactions from anywhere but Teller carry Plaid categories.
+CREATE OR REPLACE FUNCTION mapped_budget_category(p_user_id INTEGER, p_provider VARCHAR, p_category JSONB, p_pfc_primary VARCHAR, p_pfc_detailed VARCHAR)
+RETURNS VARCHAR AS $$
+    WITH legacy AS (
+        SELECT
+            CASE WHEN jsonb_typeof(p_category) = 'array' THEN
+                (SELECT string_agg(value, ' > ' ORDER BY ordinality) FROM jsonb_array_elements_text(p_category) WITH ORDINALITY)
+            END AS full_path,
+            CASE WHEN jsonb_typeof(p_category) = 'array' THEN p_category->>0 END AS top_level
+    )
+    SELECT m.budget_category
+    FROM category_mappings m, legacy l
+    WHERE (m.user_id = p_user_id OR m.user_id IS NULL)
+        AND m.provider = CASE WHEN p_provider = 'teller' THEN 'teller' ELSE 'plaid' END
+        AND m.provider_category IN (p_pfc_detailed, l.full_path, p_pfc_primary, l.top_level)
+    ORDER BY
+        m.user_id IS NULL,
+        CASE m.provider_category
+            WHEN p_pfc_detailed THEN 0
+            WHEN l.full_path THEN 1
+            WHEN p_pfc_primary THEN 2
+            ELSE 3
+        END
+    LIMIT 1
+$$ LANGUAGE sql STABLE;
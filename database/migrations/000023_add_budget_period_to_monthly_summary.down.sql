@@ -0,0 +1,3 @@
+ALTER TABLE monthly_summary
+    DROP COLUMN budget_period,
+    DROP COLUMN period_anchor_date;
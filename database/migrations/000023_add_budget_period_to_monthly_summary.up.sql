@@ -0,0 +1,3 @@
+ALTER TABLE monthly_summary
+    ADD COLUMN budget_period VARCHAR(10) NOT NULL DEFAULT 'monthly' CHECK (budget_period IN ('weekly', 'biweekly', 'monthly')),
+    ADD COLUMN period_anchor_date DATE;
@@ -0,0 +1 @@
+ALTER TABLE monthly_summary DROP COLUMN budget_start_date;
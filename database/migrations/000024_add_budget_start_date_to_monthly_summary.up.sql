@@ -0,0 +1,2 @@
+-- Day the user started budgeting, for users who sign up part way through a period
+ALTER TABLE monthly_summary ADD COLUMN budget_start_date DATE;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS saving_goal_contributions;
+
+ALTER TABLE saving_goal
+    DROP COLUMN IF EXISTS target_date,
+    DROP COLUMN IF EXISTS monthly_pace,
+    DROP COLUMN IF EXISTS required_monthly_contribution,
+    DROP COLUMN IF EXISTS projected_completion_date,
+    DROP COLUMN IF EXISTS calculated_at;
@@ -0,0 +1,18 @@
+ALTER TABLE saving_goal
+    ADD COLUMN target_date DATE,
+    ADD COLUMN monthly_pace DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    ADD COLUMN required_monthly_contribution DECIMAL(10,2),
+    ADD COLUMN projected_completion_date DATE,
+    ADD COLUMN calculated_at TIMESTAMP WITH TIME ZONE;
+
+-- Individual deposits towards a goal, used to work out the user's saving pace
+CREATE TABLE IF NOT EXISTS saving_goal_contributions (
+    id serial PRIMARY KEY,
+    saving_goal_id INTEGER NOT NULL REFERENCES saving_goal(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    amount DECIMAL(10,2) NOT NULL,
+    contributed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_saving_goal_contributions_goal_date ON saving_goal_contributions(saving_goal_id, contributed_at);
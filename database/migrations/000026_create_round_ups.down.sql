@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS round_ups;
+DROP TABLE IF EXISTS round_up_settings;
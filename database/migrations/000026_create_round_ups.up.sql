@@ -0,0 +1,29 @@
+-- Opt-in round-up savings. Card purchases are rounded up to the next dollar and the
+-- difference is accrued against the chosen savings goal, then contributed weekly.
+CREATE TABLE IF NOT EXISTS round_up_settings (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    saving_goal_id INTEGER REFERENCES saving_goal(id) ON DELETE SET NULL,
+    enabled_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_round_up_settings_updated_at
+    BEFORE UPDATE ON round_up_settings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE IF NOT EXISTS round_ups (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    transaction_id UUID NOT NULL UNIQUE REFERENCES transactions(id) ON DELETE CASCADE,
+    saving_goal_id INTEGER NOT NULL REFERENCES saving_goal(id) ON DELETE CASCADE,
+    amount DECIMAL(10,2) NOT NULL,
+    transaction_date DATE NOT NULL,
+    contribution_id INTEGER REFERENCES saving_goal_contributions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_round_ups_user_date ON round_ups(user_id, transaction_date);
+CREATE INDEX IF NOT EXISTS idx_round_ups_pending ON round_ups(user_id) WHERE contribution_id IS NULL;
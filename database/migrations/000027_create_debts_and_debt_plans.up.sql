@@ -0,0 +1,41 @@
+-- Debts pulled from Plaid's liabilities product, one row per liability account
+CREATE TABLE IF NOT EXISTS debts (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    plaid_account_id VARCHAR NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    debt_type VARCHAR(20) NOT NULL CHECK (debt_type IN ('credit', 'student', 'mortgage')),
+    balance DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+    apr_percentage DECIMAL(6,3) NOT NULL DEFAULT 0.000,
+    minimum_payment DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_debts_user_id ON debts(user_id);
+
+CREATE TRIGGER update_debts_updated_at
+    BEFORE UPDATE ON debts
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- A user's chosen payoff strategy and the most recently generated schedule for it
+CREATE TABLE IF NOT EXISTS debt_plans (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    strategy VARCHAR(20) NOT NULL CHECK (strategy IN ('avalanche', 'snowball')),
+    extra_monthly_payment DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    months_to_payoff INTEGER NOT NULL DEFAULT 0,
+    payoff_date DATE,
+    total_interest DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+    baseline_interest DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+    interest_saved DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+    schedule JSONB NOT NULL DEFAULT '[]',
+    calculated_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_debt_plans_updated_at
+    BEFORE UPDATE ON debt_plans
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
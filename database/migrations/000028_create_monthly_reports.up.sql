@@ -0,0 +1,9 @@
+-- Generated "month in review" reports, stored as JSON so the report layout can evolve without migrations
+CREATE TABLE IF NOT EXISTS monthly_reports (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    monthyear INTEGER NOT NULL,
+    report JSONB NOT NULL,
+    generated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, monthyear)
+);
@@ -0,0 +1,14 @@
+-- Per-user preferences, starting with how often to send the spending digest email
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    digest_frequency VARCHAR(20) NOT NULL DEFAULT 'none' CHECK (digest_frequency IN ('none', 'weekly', 'monthly')),
+    unsubscribe_token UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(),
+    last_digest_sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_user_preferences_updated_at
+    BEFORE UPDATE ON user_preferences
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,7 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS plan,
+    DROP COLUMN IF EXISTS plan_status,
+    DROP COLUMN IF EXISTS plan_source,
+    DROP COLUMN IF EXISTS plan_expires_at,
+    DROP COLUMN IF EXISTS stripe_customer_id,
+    DROP COLUMN IF EXISTS stripe_subscription_id;
@@ -0,0 +1,8 @@
+-- Subscription plan and entitlement state, kept in sync by whichever billing provider the user subscribed through
+ALTER TABLE users
+    ADD COLUMN plan VARCHAR(20) NOT NULL DEFAULT 'free',
+    ADD COLUMN plan_status VARCHAR(30) NOT NULL DEFAULT 'none',
+    ADD COLUMN plan_source VARCHAR(20),
+    ADD COLUMN plan_expires_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN stripe_customer_id VARCHAR(255) UNIQUE,
+    ADD COLUMN stripe_subscription_id VARCHAR(255);
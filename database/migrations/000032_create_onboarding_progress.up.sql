@@ -0,0 +1,24 @@
+-- When each onboarding step was first reached; the furthest reached step is the user's current step
+CREATE TABLE IF NOT EXISTS onboarding_progress (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    registered_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    bank_linked_at TIMESTAMP WITH TIME ZONE,
+    first_sync_completed_at TIMESTAMP WITH TIME ZONE,
+    budget_created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_onboarding_progress_updated_at
+    BEFORE UPDATE ON onboarding_progress
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Backfill existing users from the data they already have
+INSERT INTO onboarding_progress (user_id, bank_linked_at, first_sync_completed_at, budget_created_at)
+SELECT u.user_id,
+    CASE WHEN EXISTS (SELECT 1 FROM plaid_tokens p WHERE p.user_id = u.user_id)
+        OR EXISTS (SELECT 1 FROM teller_accounts t WHERE t.user_id = u.user_id) THEN CURRENT_TIMESTAMP END,
+    CASE WHEN EXISTS (SELECT 1 FROM transactions tr WHERE tr.user_id = u.user_id) THEN CURRENT_TIMESTAMP END,
+    CASE WHEN EXISTS (SELECT 1 FROM monthly_summary m WHERE m.user_id = u.user_id) THEN CURRENT_TIMESTAMP END
+FROM users u
+ON CONFLICT (user_id) DO NOTHING;
@@ -0,0 +1,14 @@
+-- Worker jobs that failed, kept so operators can inspect and replay them
+CREATE TABLE IF NOT EXISTS dead_letter_jobs (
+    id serial PRIMARY KEY,
+    job_id VARCHAR(255) NOT NULL,
+    job_type VARCHAR(100) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    error TEXT NOT NULL,
+    failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    replay_count INTEGER NOT NULL DEFAULT 0,
+    replayed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letter_jobs_type_failed_at ON dead_letter_jobs(job_type, failed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_dead_letter_jobs_pending ON dead_letter_jobs(failed_at DESC) WHERE replayed_at IS NULL;
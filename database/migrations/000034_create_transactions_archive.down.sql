@@ -0,0 +1,14 @@
+-- Move archived rows back before dropping the archive so no history is lost. The columns are named rather than
+-- taken positionally, since transactions may carry generated columns that can't be inserted into.
+INSERT INTO transactions (id, user_id, teller_institution_id, teller_account_id, teller_transaction_id, amount, description,
+    date, type, status, running_balance, processing_status, counterparty_name, counterparty_type, self_link, account_link,
+    created_at, updated_at, plaid_transaction_id, plaid_account_id, currency, provider_type, category,
+    personal_finance_category_primary, personal_finance_category_detailed)
+SELECT id, user_id, teller_institution_id, teller_account_id, teller_transaction_id, amount, description,
+    date, type, status, running_balance, processing_status, counterparty_name, counterparty_type, self_link, account_link,
+    created_at, updated_at, plaid_transaction_id, plaid_account_id, currency, provider_type, category,
+    personal_finance_category_primary, personal_finance_category_detailed
+FROM transactions_archive
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS transactions_archive;
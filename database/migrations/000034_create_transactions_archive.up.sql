@@ -0,0 +1,7 @@
+-- Transactions older than the archive cutoff are moved here by the archive_old_transactions job so the live
+-- table and its indexes stay small. Columns mirror transactions, plus archived_at, so a migration that adds a
+-- column to transactions must add it here too.
+CREATE TABLE IF NOT EXISTS transactions_archive (LIKE transactions INCLUDING DEFAULTS INCLUDING INDEXES);
+
+ALTER TABLE transactions_archive
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
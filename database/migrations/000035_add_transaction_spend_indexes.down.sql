@@ -0,0 +1,9 @@
+CREATE INDEX IF NOT EXISTS idx_transactions_user_id ON transactions(user_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_pfc_primary ON transactions(personal_finance_category_primary);
+CREATE INDEX IF NOT EXISTS idx_transactions_pfc_detailed ON transactions(personal_finance_category_detailed);
+CREATE INDEX IF NOT EXISTS idx_transactions_category_gin ON transactions USING GIN (category);
+
+DROP INDEX IF EXISTS idx_transactions_user_category_gin;
+DROP INDEX IF EXISTS idx_transactions_plaid_account_date;
+DROP INDEX IF EXISTS idx_transactions_user_pfc_detailed_date;
+DROP INDEX IF EXISTS idx_transactions_user_pfc_primary_date;
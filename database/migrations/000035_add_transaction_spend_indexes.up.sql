@@ -0,0 +1,22 @@
+-- Spend queries always narrow by user and date range first, then match a category. These composite indexes
+-- let the category branches be answered from the index instead of filtering every row in the user's range.
+CREATE INDEX IF NOT EXISTS idx_transactions_user_pfc_primary_date
+    ON transactions(user_id, personal_finance_category_primary, date);
+CREATE INDEX IF NOT EXISTS idx_transactions_user_pfc_detailed_date
+    ON transactions(user_id, personal_finance_category_detailed, date);
+CREATE INDEX IF NOT EXISTS idx_transactions_plaid_account_date
+    ON transactions(plaid_account_id, date);
+
+-- Legacy category arrays are matched by element (?|) within one user's transactions; btree_gin lets the GIN
+-- index lead with user_id so it no longer scans every user's rows holding the same category
+CREATE EXTENSION IF NOT EXISTS btree_gin;
+CREATE INDEX IF NOT EXISTS idx_transactions_user_category_gin
+    ON transactions USING GIN (user_id, category);
+
+-- Superseded by the indexes above and idx_transactions_user_date
+DROP INDEX IF EXISTS idx_transactions_category_gin;
+DROP INDEX IF EXISTS idx_transactions_pfc_primary;
+DROP INDEX IF EXISTS idx_transactions_pfc_detailed;
+DROP INDEX IF EXISTS idx_transactions_user_id;
+
+ANALYZE transactions;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS update_daily_category_spend_update ON transactions;
+DROP TRIGGER IF EXISTS update_daily_category_spend_insert_delete ON transactions;
+DROP FUNCTION IF EXISTS update_daily_category_spend();
+DROP FUNCTION IF EXISTS apply_daily_category_spend(INTEGER, DATE, JSONB, VARCHAR, VARCHAR, NUMERIC, INTEGER);
+DROP TABLE IF EXISTS daily_category_spend;
@@ -0,0 +1,91 @@
+-- Spend per user per day, grouped by the transaction fields budget category matching reads. Spend queries scan
+-- these rows instead of every transaction; category_mappings are still applied when reading, so changing a
+-- mapping needs no rebuild. NULL categories are stored as '' / 'null' so they can be part of the key.
+-- Kept in step with transactions by trigger, so archived transactions drop out just as they do from the live table.
+CREATE TABLE IF NOT EXISTS daily_category_spend (
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    category JSONB NOT NULL DEFAULT 'null',
+    personal_finance_category_primary VARCHAR(100) NOT NULL DEFAULT '',
+    personal_finance_category_detailed VARCHAR(150) NOT NULL DEFAULT '',
+    total_amount NUMERIC NOT NULL DEFAULT 0,
+    transaction_count INTEGER NOT NULL DEFAULT 0,
+    -- Outflows only (amount > 0), for spending that leaves out refunds and income
+    spend_amount NUMERIC NOT NULL DEFAULT 0,
+    spend_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, date, personal_finance_category_primary, personal_finance_category_detailed, category)
+);
+
+-- Adds (p_sign = 1) or removes (p_sign = -1) one transaction's amount, dropping groups left with no transactions.
+-- Removal only updates existing groups so deletes cascading from users can't recreate their rows.
+CREATE OR REPLACE FUNCTION apply_daily_category_spend(p_user_id INTEGER, p_date DATE, p_category JSONB, p_pfc_primary VARCHAR, p_pfc_detailed VARCHAR, p_amount NUMERIC, p_sign INTEGER)
+RETURNS VOID AS $$
+DECLARE
+    v_spend NUMERIC := CASE WHEN p_amount > 0 THEN p_amount ELSE 0 END;
+    v_spend_count INTEGER := CASE WHEN p_amount > 0 THEN 1 ELSE 0 END;
+BEGIN
+    IF p_sign > 0 THEN
+        INSERT INTO daily_category_spend AS d (user_id, date, category, personal_finance_category_primary, personal_finance_category_detailed,
+            total_amount, transaction_count, spend_amount, spend_count)
+        VALUES (p_user_id, p_date, COALESCE(p_category, 'null'), COALESCE(p_pfc_primary, ''), COALESCE(p_pfc_detailed, ''),
+            p_amount, 1, v_spend, v_spend_count)
+        ON CONFLICT (user_id, date, personal_finance_category_primary, personal_finance_category_detailed, category) DO UPDATE SET
+            total_amount = d.total_amount + EXCLUDED.total_amount,
+            transaction_count = d.transaction_count + EXCLUDED.transaction_count,
+            spend_amount = d.spend_amount + EXCLUDED.spend_amount,
+            spend_count = d.spend_count + EXCLUDED.spend_count;
+        RETURN;
+    END IF;
+
+    UPDATE daily_category_spend SET
+        total_amount = total_amount - p_amount,
+        transaction_count = transaction_count - 1,
+        spend_amount = spend_amount - v_spend,
+        spend_count = spend_count - v_spend_count
+    WHERE user_id = p_user_id AND date = p_date AND category = COALESCE(p_category, 'null')
+        AND personal_finance_category_primary = COALESCE(p_pfc_primary, '')
+        AND personal_finance_category_detailed = COALESCE(p_pfc_detailed, '');
+
+    DELETE FROM daily_category_spend
+    WHERE user_id = p_user_id AND date = p_date AND category = COALESCE(p_category, 'null')
+        AND personal_finance_category_primary = COALESCE(p_pfc_primary, '')
+        AND personal_finance_category_detailed = COALESCE(p_pfc_detailed, '')
+        AND transaction_count <= 0;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION update_daily_category_spend()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM apply_daily_category_spend(OLD.user_id, OLD.date, OLD.category, OLD.personal_finance_category_primary,
+            OLD.personal_finance_category_detailed, OLD.amount::numeric, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM apply_daily_category_spend(NEW.user_id, NEW.date, NEW.category, NEW.personal_finance_category_primary,
+            NEW.personal_finance_category_detailed, NEW.amount::numeric, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_daily_category_spend_insert_delete
+    AFTER INSERT OR DELETE ON transactions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_daily_category_spend();
+
+-- Sync upserts rewrite unchanged rows constantly, so only updates that move spend touch the aggregate
+CREATE TRIGGER update_daily_category_spend_update
+    AFTER UPDATE ON transactions
+    FOR EACH ROW
+    WHEN ((OLD.user_id, OLD.date, OLD.amount, OLD.category, OLD.personal_finance_category_primary, OLD.personal_finance_category_detailed)
+        IS DISTINCT FROM (NEW.user_id, NEW.date, NEW.amount, NEW.category, NEW.personal_finance_category_primary, NEW.personal_finance_category_detailed))
+    EXECUTE FUNCTION update_daily_category_spend();
+
+INSERT INTO daily_category_spend (user_id, date, category, personal_finance_category_primary, personal_finance_category_detailed,
+    total_amount, transaction_count, spend_amount, spend_count)
+SELECT user_id, date, COALESCE(category, 'null'), COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, ''),
+    SUM(amount::numeric), COUNT(*),
+    COALESCE(SUM(amount::numeric) FILTER (WHERE amount::numeric > 0), 0), COUNT(*) FILTER (WHERE amount::numeric > 0)
+FROM transactions
+GROUP BY 1, 2, 3, 4, 5;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_dead_letter_jobs_timed_out;
+ALTER TABLE dead_letter_jobs DROP COLUMN IF EXISTS timed_out;
@@ -0,0 +1,4 @@
+-- Jobs that ran past their timeout are flagged so they can be told apart from jobs that failed outright
+ALTER TABLE dead_letter_jobs ADD COLUMN IF NOT EXISTS timed_out BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_dead_letter_jobs_timed_out ON dead_letter_jobs(failed_at DESC) WHERE timed_out;
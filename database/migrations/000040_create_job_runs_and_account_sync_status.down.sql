@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS account_sync_status;
+DROP TABLE IF EXISTS job_runs;
@@ -0,0 +1,33 @@
+-- Sync jobs as they move through the worker, so users can see what is queued, running or failing for their
+-- connections. account_id is set for jobs that sync a single account.
+CREATE TABLE IF NOT EXISTS job_runs (
+    id serial PRIMARY KEY,
+    job_id VARCHAR(64) NOT NULL UNIQUE,
+    job_type VARCHAR(100) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    provider VARCHAR(20) NOT NULL,
+    account_id VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+    error TEXT,
+    enqueued_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_user_enqueued_at ON job_runs(user_id, enqueued_at DESC);
+CREATE INDEX IF NOT EXISTS idx_job_runs_enqueued_at ON job_runs(enqueued_at);
+
+-- The outcome of the latest sync of each linked account, Plaid or Teller
+CREATE TABLE IF NOT EXISTS account_sync_status (
+    provider VARCHAR(20) NOT NULL,
+    account_id VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    last_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_success_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    last_error_at TIMESTAMP WITH TIME ZONE,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (provider, account_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_sync_status_user_id ON account_sync_status(user_id);
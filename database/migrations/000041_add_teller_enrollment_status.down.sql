@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_teller_institutions_reauth_required;
+ALTER TABLE teller_institutions
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS last_success_at,
+    DROP COLUMN IF EXISTS auth_failed_at,
+    DROP COLUMN IF EXISTS last_auth_error,
+    DROP COLUMN IF EXISTS reauth_reminded_at,
+    DROP COLUMN IF EXISTS reauth_reminder_count;
@@ -0,0 +1,11 @@
+-- Teller enrollments stop working when the user's bank session lapses and Teller answers 401. The worker then
+-- flags the enrollment, stops syncing it and reminds the user to reconnect until they do.
+ALTER TABLE teller_institutions
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'reauth_required')),
+    ADD COLUMN IF NOT EXISTS last_success_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS auth_failed_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS last_auth_error TEXT,
+    ADD COLUMN IF NOT EXISTS reauth_reminded_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS reauth_reminder_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_teller_institutions_reauth_required ON teller_institutions(reauth_reminded_at) WHERE status = 'reauth_required';
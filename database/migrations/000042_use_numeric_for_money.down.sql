@@ -0,0 +1,14 @@
+ALTER TABLE monthly_summary ALTER COLUMN fixed_expenses TYPE FLOAT USING fixed_expenses::float;
+ALTER TABLE monthly_budget_spend_category ALTER COLUMN daily_allowance TYPE FLOAT USING daily_allowance::float;
+
+DROP TRIGGER IF EXISTS update_daily_category_spend_update ON transactions;
+
+ALTER TABLE transactions_archive ALTER COLUMN amount TYPE VARCHAR(50) USING amount::text;
+ALTER TABLE transactions ALTER COLUMN amount TYPE VARCHAR(50) USING amount::text;
+
+CREATE TRIGGER update_daily_category_spend_update
+    AFTER UPDATE ON transactions
+    FOR EACH ROW
+    WHEN ((OLD.user_id, OLD.date, OLD.amount, OLD.category, OLD.personal_finance_category_primary, OLD.personal_finance_category_detailed)
+        IS DISTINCT FROM (NEW.user_id, NEW.date, NEW.amount, NEW.category, NEW.personal_finance_category_primary, NEW.personal_finance_category_detailed))
+    EXECUTE FUNCTION update_daily_category_spend();
@@ -0,0 +1,17 @@
+-- Money is stored exactly: transaction amounts were kept as the strings Teller sends, and the daily allowance
+-- and fixed expenses as floats, which drifted once summed and prorated. The update trigger on transactions
+-- names amount in its WHEN clause, so it is dropped while the column type changes.
+DROP TRIGGER IF EXISTS update_daily_category_spend_update ON transactions;
+
+ALTER TABLE transactions ALTER COLUMN amount TYPE NUMERIC(14,2) USING amount::numeric;
+ALTER TABLE transactions_archive ALTER COLUMN amount TYPE NUMERIC(14,2) USING amount::numeric;
+
+CREATE TRIGGER update_daily_category_spend_update
+    AFTER UPDATE ON transactions
+    FOR EACH ROW
+    WHEN ((OLD.user_id, OLD.date, OLD.amount, OLD.category, OLD.personal_finance_category_primary, OLD.personal_finance_category_detailed)
+        IS DISTINCT FROM (NEW.user_id, NEW.date, NEW.amount, NEW.category, NEW.personal_finance_category_primary, NEW.personal_finance_category_detailed))
+    EXECUTE FUNCTION update_daily_category_spend();
+
+ALTER TABLE monthly_budget_spend_category ALTER COLUMN daily_allowance TYPE NUMERIC(12,2) USING round(daily_allowance::numeric, 2);
+ALTER TABLE monthly_summary ALTER COLUMN fixed_expenses TYPE NUMERIC(12,2) USING round(fixed_expenses::numeric, 2);
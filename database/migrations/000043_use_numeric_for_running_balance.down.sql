@@ -0,0 +1,2 @@
+ALTER TABLE transactions_archive ALTER COLUMN running_balance TYPE VARCHAR(50) USING running_balance::text;
+ALTER TABLE transactions ALTER COLUMN running_balance TYPE VARCHAR(50) USING running_balance::text;
@@ -0,0 +1,4 @@
+-- Teller running balances were kept as the strings it sends, with a missing balance stored as ''. They are
+-- parsed when saved now, so store them exactly like amounts, with a missing balance as NULL.
+ALTER TABLE transactions ALTER COLUMN running_balance TYPE NUMERIC(14,2) USING NULLIF(running_balance, '')::numeric;
+ALTER TABLE transactions_archive ALTER COLUMN running_balance TYPE NUMERIC(14,2) USING NULLIF(running_balance, '')::numeric;
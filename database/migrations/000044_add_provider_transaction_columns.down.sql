@@ -0,0 +1,12 @@
+ALTER TABLE transactions_archive
+    DROP COLUMN IF EXISTS merchant,
+    DROP COLUMN IF EXISTS account_ref,
+    DROP COLUMN IF EXISTS provider_transaction_id;
+
+DROP INDEX IF EXISTS idx_transactions_user_account_ref;
+DROP INDEX IF EXISTS idx_transactions_provider_transaction_id;
+
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS merchant,
+    DROP COLUMN IF EXISTS account_ref,
+    DROP COLUMN IF EXISTS provider_transaction_id;
@@ -0,0 +1,35 @@
+-- Every provider's transactions are keyed and described by the same columns: provider_type names the provider,
+-- provider_transaction_id and account_ref are its ids for the transaction and account, and merchant is who the
+-- money went to. The teller_* and plaid_* columns stay for the foreign keys and queries that still use them.
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS provider_transaction_id VARCHAR,
+    ADD COLUMN IF NOT EXISTS account_ref VARCHAR,
+    ADD COLUMN IF NOT EXISTS merchant VARCHAR(255);
+
+UPDATE transactions SET provider_type = 'plaid' WHERE plaid_transaction_id IS NOT NULL;
+UPDATE transactions SET
+    provider_transaction_id = COALESCE(plaid_transaction_id, teller_transaction_id),
+    account_ref = COALESCE(plaid_account_id, teller_account_id::text),
+    merchant = counterparty_name;
+
+-- Teller saves never matched an existing row, so re-synced transactions were stored again
+DELETE FROM transactions a
+    USING transactions b
+    WHERE a.provider_transaction_id IS NOT NULL
+    AND a.provider_type = b.provider_type
+    AND a.provider_transaction_id = b.provider_transaction_id
+    AND a.ctid > b.ctid;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_provider_transaction_id ON transactions(provider_type, provider_transaction_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_user_account_ref ON transactions(user_id, account_ref);
+
+ALTER TABLE transactions_archive
+    ADD COLUMN IF NOT EXISTS provider_transaction_id VARCHAR,
+    ADD COLUMN IF NOT EXISTS account_ref VARCHAR,
+    ADD COLUMN IF NOT EXISTS merchant VARCHAR(255);
+
+UPDATE transactions_archive SET
+    provider_type = CASE WHEN plaid_transaction_id IS NOT NULL THEN 'plaid' ELSE provider_type END,
+    provider_transaction_id = COALESCE(plaid_transaction_id, teller_transaction_id),
+    account_ref = COALESCE(plaid_account_id, teller_account_id::text),
+    merchant = counterparty_name;
@@ -0,0 +1,27 @@
+DROP TRIGGER IF EXISTS apply_account_budget_exclusion ON account_settings;
+DROP FUNCTION IF EXISTS apply_account_budget_exclusion();
+
+-- Put excluded accounts' transactions back into daily_category_spend before the exclusions go
+SELECT apply_daily_category_spend(t.user_id, t.date, t.category, t.personal_finance_category_primary,
+    t.personal_finance_category_detailed, t.amount::numeric, 1)
+FROM transactions t
+JOIN account_settings s ON s.user_id = t.user_id AND s.provider_type = t.provider_type AND s.account_ref = t.account_ref
+WHERE s.exclude_from_budget;
+
+CREATE OR REPLACE FUNCTION update_daily_category_spend()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM apply_daily_category_spend(OLD.user_id, OLD.date, OLD.category, OLD.personal_finance_category_primary,
+            OLD.personal_finance_category_detailed, OLD.amount::numeric, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM apply_daily_category_spend(NEW.user_id, NEW.date, NEW.category, NEW.personal_finance_category_primary,
+            NEW.personal_finance_category_detailed, NEW.amount::numeric, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS account_excluded_from_budget(INTEGER, VARCHAR, VARCHAR);
+DROP TABLE IF EXISTS account_settings;
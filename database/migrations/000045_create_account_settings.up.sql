@@ -0,0 +1,67 @@
+-- Settings a user keeps per linked account, for any provider. Accounts are keyed the way transactions name them,
+-- by provider_type and account_ref. exclude_from_budget leaves an account's transactions, such as those on a
+-- business card or a partner's account, out of spend.
+CREATE TABLE IF NOT EXISTS account_settings (
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    provider_type VARCHAR NOT NULL,
+    account_ref VARCHAR NOT NULL,
+    exclude_from_budget BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, provider_type, account_ref)
+);
+
+CREATE TRIGGER update_account_settings_updated_at
+    BEFORE UPDATE ON account_settings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE OR REPLACE FUNCTION account_excluded_from_budget(p_user_id INTEGER, p_provider_type VARCHAR, p_account_ref VARCHAR)
+RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM account_settings
+        WHERE user_id = p_user_id AND provider_type = p_provider_type AND account_ref = p_account_ref AND exclude_from_budget
+    );
+$$ LANGUAGE sql STABLE;
+
+-- daily_category_spend only counts transactions on accounts included in budgeting
+CREATE OR REPLACE FUNCTION update_daily_category_spend()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND NOT account_excluded_from_budget(OLD.user_id, OLD.provider_type, OLD.account_ref) THEN
+        PERFORM apply_daily_category_spend(OLD.user_id, OLD.date, OLD.category, OLD.personal_finance_category_primary,
+            OLD.personal_finance_category_detailed, OLD.amount::numeric, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NOT account_excluded_from_budget(NEW.user_id, NEW.provider_type, NEW.account_ref) THEN
+        PERFORM apply_daily_category_spend(NEW.user_id, NEW.date, NEW.category, NEW.personal_finance_category_primary,
+            NEW.personal_finance_category_detailed, NEW.amount::numeric, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Excluding an account takes its transactions out of daily_category_spend and including it puts them back.
+-- Settings are only deleted along with their user, so deletes are left alone.
+CREATE OR REPLACE FUNCTION apply_account_budget_exclusion()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_was_excluded BOOLEAN := FALSE;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        v_was_excluded := OLD.exclude_from_budget;
+    END IF;
+    IF NEW.exclude_from_budget = v_was_excluded THEN
+        RETURN NULL;
+    END IF;
+    PERFORM apply_daily_category_spend(t.user_id, t.date, t.category, t.personal_finance_category_primary,
+        t.personal_finance_category_detailed, t.amount::numeric, CASE WHEN NEW.exclude_from_budget THEN -1 ELSE 1 END)
+    FROM transactions t
+    WHERE t.user_id = NEW.user_id AND t.provider_type = NEW.provider_type AND t.account_ref = NEW.account_ref;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER apply_account_budget_exclusion
+    AFTER INSERT OR UPDATE OF exclude_from_budget ON account_settings
+    FOR EACH ROW
+    EXECUTE FUNCTION apply_account_budget_exclusion();
@@ -0,0 +1,65 @@
+-- Money moved between a user's own accounts, such as a credit card payment or a savings transfer, shows up as an
+-- outflow on one account and an equal inflow on another. Matched pairs are marked is_transfer and left out of
+-- spend. Pairs the matcher isn't sure of wait in transfer_matches for the user to confirm or reject.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS is_transfer BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS is_transfer BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS transfer_matches (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    outflow_transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    inflow_transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('confirmed', 'pending_review', 'rejected')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (outflow_transaction_id, inflow_transaction_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_transfer_matches_user_status ON transfer_matches(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_transfer_matches_inflow ON transfer_matches(inflow_transaction_id);
+
+-- daily_category_spend leaves out transfers as well as transactions on excluded accounts
+CREATE OR REPLACE FUNCTION update_daily_category_spend()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND NOT OLD.is_transfer
+        AND NOT account_excluded_from_budget(OLD.user_id, OLD.provider_type, OLD.account_ref) THEN
+        PERFORM apply_daily_category_spend(OLD.user_id, OLD.date, OLD.category, OLD.personal_finance_category_primary,
+            OLD.personal_finance_category_detailed, OLD.amount::numeric, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NOT NEW.is_transfer
+        AND NOT account_excluded_from_budget(NEW.user_id, NEW.provider_type, NEW.account_ref) THEN
+        PERFORM apply_daily_category_spend(NEW.user_id, NEW.date, NEW.category, NEW.personal_finance_category_primary,
+            NEW.personal_finance_category_detailed, NEW.amount::numeric, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_daily_category_spend_update ON transactions;
+CREATE TRIGGER update_daily_category_spend_update
+    AFTER UPDATE ON transactions
+    FOR EACH ROW
+    WHEN ((OLD.user_id, OLD.date, OLD.amount, OLD.category, OLD.personal_finance_category_primary, OLD.personal_finance_category_detailed, OLD.is_transfer)
+        IS DISTINCT FROM (NEW.user_id, NEW.date, NEW.amount, NEW.category, NEW.personal_finance_category_primary, NEW.personal_finance_category_detailed, NEW.is_transfer))
+    EXECUTE FUNCTION update_daily_category_spend();
+
+CREATE OR REPLACE FUNCTION apply_account_budget_exclusion()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_was_excluded BOOLEAN := FALSE;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        v_was_excluded := OLD.exclude_from_budget;
+    END IF;
+    IF NEW.exclude_from_budget = v_was_excluded THEN
+        RETURN NULL;
+    END IF;
+    PERFORM apply_daily_category_spend(t.user_id, t.date, t.category, t.personal_finance_category_primary,
+        t.personal_finance_category_detailed, t.amount::numeric, CASE WHEN NEW.exclude_from_budget THEN -1 ELSE 1 END)
+    FROM transactions t
+    WHERE t.user_id = NEW.user_id AND t.provider_type = NEW.provider_type AND t.account_ref = NEW.account_ref AND NOT t.is_transfer;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
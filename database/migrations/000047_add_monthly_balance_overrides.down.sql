@@ -0,0 +1,3 @@
+ALTER TABLE monthly_balance
+    DROP COLUMN IF EXISTS computed_at,
+    DROP COLUMN IF EXISTS manual_fields;
@@ -0,0 +1,13 @@
+-- Monthly balances are computed from linked account balances by the compute_monthly_balances job. Fields the user
+-- sets by hand are listed in manual_fields and left alone by the job until the override is cleared.
+ALTER TABLE monthly_balance
+    ADD COLUMN IF NOT EXISTS manual_fields TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS computed_at TIMESTAMP WITH TIME ZONE;
+
+-- Every value stored so far was entered by hand, so keep the ones that were set
+UPDATE monthly_balance SET manual_fields = ARRAY_REMOVE(ARRAY[
+    CASE WHEN total_owing <> 0 THEN 'total_owing' END,
+    CASE WHEN net_cash <> 0 THEN 'net_cash' END,
+    CASE WHEN available_balance <> 0 THEN 'available_balance' END,
+    CASE WHEN current_balance <> 0 THEN 'current_balance' END
+], NULL);
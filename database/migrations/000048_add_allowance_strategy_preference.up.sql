@@ -0,0 +1,4 @@
+-- How the daily-balance job shares allowances between budget categories for this user. NULL uses the
+-- deployment's default strategy.
+ALTER TABLE user_preferences
+    ADD COLUMN IF NOT EXISTS allowance_strategy VARCHAR(20) CHECK (allowance_strategy IN ('proportional', 'strict', 'pooled', 'envelope'));
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS investment_contributions;
+DROP FUNCTION IF EXISTS account_is_investment(INTEGER, VARCHAR, VARCHAR);
+ALTER TABLE account_settings DROP COLUMN IF EXISTS is_investment;
@@ -0,0 +1,32 @@
+-- Transfers into brokerage accounts count towards the monthly summary's invested amount. Plaid investment accounts
+-- are brokerage accounts by type, and users can tag any other account, such as a Teller savings account they
+-- invest from, with is_investment.
+ALTER TABLE account_settings ADD COLUMN IF NOT EXISTS is_investment BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE OR REPLACE FUNCTION account_is_investment(p_user_id INTEGER, p_provider_type VARCHAR, p_account_ref VARCHAR)
+RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM account_settings
+        WHERE user_id = p_user_id AND provider_type = p_provider_type AND account_ref = p_account_ref AND is_investment
+    ) OR EXISTS (
+        SELECT 1 FROM plaid_accounts
+        WHERE p_provider_type = 'plaid' AND user_id = p_user_id AND id = p_account_ref AND account_type IN ('investment', 'brokerage')
+    );
+$$ LANGUAGE sql STABLE;
+
+-- One row per transfer detected as an investment contribution. applied_amount is what has been added to
+-- monthly_summary.invested for the month so far, NULL until the month has a summary, so corrections and
+-- rejections adjust invested by the difference rather than overwriting what the user entered by hand.
+CREATE TABLE IF NOT EXISTS investment_contributions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    transaction_id UUID NOT NULL UNIQUE REFERENCES transactions(id) ON DELETE CASCADE,
+    month_year INTEGER NOT NULL,
+    amount NUMERIC(14,2) NOT NULL CHECK (amount > 0),
+    applied_amount NUMERIC(14,2),
+    status VARCHAR(20) NOT NULL DEFAULT 'detected' CHECK (status IN ('detected', 'confirmed', 'rejected')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_investment_contributions_user_month ON investment_contributions(user_id, month_year);
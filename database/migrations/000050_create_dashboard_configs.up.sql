@@ -0,0 +1,13 @@
+-- Which dashboard widgets a user shows and in what order, kept server-side so the layout follows them across
+-- devices. Users without a row get the default layout.
+CREATE TABLE IF NOT EXISTS dashboard_configs (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    widgets TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_dashboard_configs_updated_at
+    BEFORE UPDATE ON dashboard_configs
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
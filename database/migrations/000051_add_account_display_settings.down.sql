@@ -0,0 +1,6 @@
+DROP FUNCTION IF EXISTS account_hidden(INTEGER, VARCHAR, VARCHAR);
+ALTER TABLE account_settings
+    DROP COLUMN IF EXISTS hidden,
+    DROP COLUMN IF EXISTS icon,
+    DROP COLUMN IF EXISTS color,
+    DROP COLUMN IF EXISTS nickname;
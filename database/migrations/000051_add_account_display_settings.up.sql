@@ -0,0 +1,16 @@
+-- How an account is shown to its user. nickname replaces the bank's name, such as "Joint Chequing" for
+-- "TD ****1234", color and icon style it, and hidden takes a closed or unused account out of the accounts list
+-- and the balances summed into net worth.
+ALTER TABLE account_settings
+    ADD COLUMN IF NOT EXISTS nickname VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS color VARCHAR(7) CHECK (color ~ '^#[0-9A-Fa-f]{6}$'),
+    ADD COLUMN IF NOT EXISTS icon VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE OR REPLACE FUNCTION account_hidden(p_user_id INTEGER, p_provider_type VARCHAR, p_account_ref VARCHAR)
+RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM account_settings
+        WHERE user_id = p_user_id AND provider_type = p_provider_type AND account_ref = p_account_ref AND hidden
+    );
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS audit_log;
+ALTER TABLE users DROP COLUMN IF EXISTS session_version;
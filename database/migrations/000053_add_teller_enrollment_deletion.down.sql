@@ -0,0 +1,5 @@
+ALTER TABLE teller_accounts DROP COLUMN IF EXISTS deleted_at;
+DELETE FROM teller_institutions WHERE access_token IS NULL;
+ALTER TABLE teller_institutions
+    DROP COLUMN IF EXISTS deleted_at,
+    ALTER COLUMN access_token SET NOT NULL;
@@ -0,0 +1,7 @@
+-- Users can remove a Teller enrollment. Its access token is dropped so nothing can sync it again, and the
+-- enrollment and its accounts are kept, marked deleted, so the transactions the user chose to keep still name them.
+ALTER TABLE teller_institutions
+    ALTER COLUMN access_token DROP NOT NULL,
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE teller_accounts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE teller_institutions DROP CONSTRAINT IF EXISTS teller_institutions_teller_id_key;
+CREATE INDEX IF NOT EXISTS idx_teller_institutions_teller_id ON teller_institutions(teller_id);
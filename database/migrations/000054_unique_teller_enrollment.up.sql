@@ -0,0 +1,27 @@
+-- Relinking used to insert another teller_institutions row for the same enrollment. Keep one row per enrollment,
+-- preferring one not deleted and then the newest since it holds the latest access token, move the duplicates'
+-- accounts and transactions onto it, and make the enrollment id unique so relinks update the row instead.
+CREATE TEMPORARY TABLE teller_institution_duplicates AS
+SELECT id, keep_id FROM (
+    SELECT id, FIRST_VALUE(id) OVER (
+        PARTITION BY teller_id ORDER BY deleted_at IS NULL DESC, created_at DESC, id
+    ) AS keep_id
+    FROM teller_institutions
+) ranked
+WHERE id <> keep_id;
+
+UPDATE teller_accounts a SET teller_institution_id = d.keep_id
+FROM teller_institution_duplicates d WHERE a.teller_institution_id = d.id;
+
+UPDATE transactions t SET teller_institution_id = d.keep_id
+FROM teller_institution_duplicates d WHERE t.teller_institution_id = d.id;
+
+UPDATE transactions_archive t SET teller_institution_id = d.keep_id
+FROM teller_institution_duplicates d WHERE t.teller_institution_id = d.id;
+
+DELETE FROM teller_institutions t USING teller_institution_duplicates d WHERE t.id = d.id;
+
+DROP TABLE teller_institution_duplicates;
+
+DROP INDEX IF EXISTS idx_teller_institutions_teller_id;
+ALTER TABLE teller_institutions ADD CONSTRAINT teller_institutions_teller_id_key UNIQUE (teller_id);
@@ -0,0 +1,4 @@
+-- An account's ledger is listed by its provider account id in date order. Plaid accounts are covered by
+-- idx_transactions_plaid_account_date; this is the Teller equivalent.
+CREATE INDEX IF NOT EXISTS idx_transactions_teller_account_date
+    ON transactions(teller_account_id, date);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_transactions_archive_user_search_vector;
+ALTER TABLE transactions_archive DROP COLUMN IF EXISTS search_vector;
+
+DROP INDEX IF EXISTS idx_transactions_user_search_vector;
+ALTER TABLE transactions DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,18 @@
+-- Full-text search over what a transaction is called. search_vector weights the merchant above the bank's
+-- description and is kept up to date by Postgres as a generated column. The 'simple' configuration keeps words
+-- as written, without stemming, so prefix matches behave predictably as the user types.
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple'::regconfig, COALESCE(merchant, '')), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, COALESCE(description, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_user_search_vector ON transactions USING GIN (user_id, search_vector);
+
+-- Archived rows are copied from transactions with their vector, so the archive stores it as a plain column
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS search_vector tsvector;
+UPDATE transactions_archive SET
+    search_vector = setweight(to_tsvector('simple'::regconfig, COALESCE(merchant, '')), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, COALESCE(description, '')), 'B');
+
+CREATE INDEX IF NOT EXISTS idx_transactions_archive_user_search_vector ON transactions_archive USING GIN (user_id, search_vector);
@@ -0,0 +1,10 @@
+-- The daily-balance job overwrites each budget category's spend and daily allowance. A row per category and day
+-- keeps what they were, so the category's allowance can be charted over the month.
+CREATE TABLE IF NOT EXISTS budget_allowance_history (
+    budget_category_id UUID NOT NULL REFERENCES monthly_budget_spend_category(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    total_spent NUMERIC(12,2) NOT NULL,
+    daily_allowance NUMERIC(12,2) NOT NULL,
+    recorded_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (budget_category_id, date)
+);
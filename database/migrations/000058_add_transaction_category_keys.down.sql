@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS idx_transactions_user_category_gin
+    ON transactions USING GIN (user_id, category);
+DROP INDEX IF EXISTS idx_transactions_user_category_keys_gin;
+
+DROP FUNCTION IF EXISTS category_keys(JSONB);
@@ -0,0 +1,16 @@
+-- Legacy and Teller categories are stored as written by the provider: Plaid sends "Food and Drink", Teller sends
+-- "dining". category_keys normalizes each element the way CategoryKey does, so a budget category matches its
+-- provider category whatever the casing or spacing. It is IMMUTABLE so it can back an expression index.
+CREATE OR REPLACE FUNCTION category_keys(p_category JSONB)
+RETURNS TEXT[] AS $$
+    SELECT CASE WHEN jsonb_typeof(p_category) = 'array' THEN
+        ARRAY(SELECT UPPER(regexp_replace(trim(value), '\s+', '_', 'g')) FROM jsonb_array_elements_text(p_category))
+    ELSE '{}'::TEXT[] END
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Replaces idx_transactions_user_category_gin for by-category lookups, which now match on the normalized keys
+CREATE INDEX IF NOT EXISTS idx_transactions_user_category_keys_gin
+    ON transactions USING GIN (user_id, category_keys(category));
+DROP INDEX IF EXISTS idx_transactions_user_category_gin;
+
+ANALYZE transactions;
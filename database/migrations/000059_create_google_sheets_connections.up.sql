@@ -0,0 +1,20 @@
+-- A user's Google Sheets connection. refresh_token is from the user authorizing Watson with Google; the
+-- export_google_sheets job uses it to append each completed month to spreadsheet_id once one is picked.
+-- last_exported_monthyear is the last month appended, so each month is exported once.
+CREATE TABLE IF NOT EXISTS google_sheets_connections (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    refresh_token TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'reauth_required')),
+    spreadsheet_id VARCHAR(255),
+    spreadsheet_url TEXT,
+    last_exported_monthyear INTEGER,
+    last_exported_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_google_sheets_connections_updated_at
+    BEFORE UPDATE ON google_sheets_connections
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
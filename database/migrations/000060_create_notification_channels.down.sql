@@ -0,0 +1,3 @@
+ALTER TABLE user_preferences DROP COLUMN IF EXISTS digest_email;
+DROP TABLE IF EXISTS notification_channel_messages;
+DROP TABLE IF EXISTS notification_channels;
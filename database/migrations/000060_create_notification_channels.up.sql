@@ -0,0 +1,41 @@
+-- Slack and Discord incoming webhooks users add as extra places to receive their notifications. An empty
+-- notification_types list sends every type, including the digest.
+CREATE TABLE IF NOT EXISTS notification_channels (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('slack', 'discord')),
+    webhook_url TEXT NOT NULL,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    notification_types TEXT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_channels_user_id ON notification_channels(user_id) WHERE active;
+
+CREATE TRIGGER update_notification_channels_updated_at
+    BEFORE UPDATE ON notification_channels
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- One row per message per channel, retried with backoff like webhook deliveries
+CREATE TABLE IF NOT EXISTS notification_channel_messages (
+    id serial PRIMARY KEY,
+    channel_id INTEGER NOT NULL REFERENCES notification_channels(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    notification_type VARCHAR(50) NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_channel_messages_due ON notification_channel_messages(next_attempt_at) WHERE status = 'pending';
+
+-- Lets users take their digest in Slack or Discord instead of by email
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS digest_email BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_plaid_tokens_institution_id;
+ALTER TABLE plaid_tokens DROP COLUMN IF EXISTS institution_id;
+
+DROP TABLE IF EXISTS institutions;
@@ -0,0 +1,24 @@
+-- Institution branding from Plaid's /institutions/get_by_id, shared by every user linked to the institution.
+-- logo is a base64 encoded PNG. The refresh_institutions job refetches rows older than a week.
+CREATE TABLE IF NOT EXISTS institutions (
+    institution_id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    logo TEXT,
+    primary_color VARCHAR(16),
+    url TEXT,
+    fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_institutions_fetched_at ON institutions(fetched_at);
+
+CREATE TRIGGER update_institutions_updated_at
+    BEFORE UPDATE ON institutions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- The institution a Plaid item is at, from /item/get. Items linked before this are filled in by refresh_institutions.
+ALTER TABLE plaid_tokens ADD COLUMN IF NOT EXISTS institution_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_plaid_tokens_institution_id ON plaid_tokens(institution_id);
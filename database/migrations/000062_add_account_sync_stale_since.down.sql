@@ -0,0 +1,3 @@
+UPDATE account_sync_status SET last_attempt_at = COALESCE(stale_since, CURRENT_TIMESTAMP) WHERE last_attempt_at IS NULL;
+ALTER TABLE account_sync_status ALTER COLUMN last_attempt_at SET NOT NULL;
+ALTER TABLE account_sync_status DROP COLUMN IF EXISTS stale_since;
@@ -0,0 +1,4 @@
+-- When the flag_stale_accounts job found the account had gone without a successful sync for too long. It is
+-- cleared by the next successful sync. Accounts that never synced get a row when flagged, without an attempt.
+ALTER TABLE account_sync_status ADD COLUMN IF NOT EXISTS stale_since TIMESTAMP WITH TIME ZONE;
+ALTER TABLE account_sync_status ALTER COLUMN last_attempt_at DROP NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE monthly_budget_spend_category DROP COLUMN IF EXISTS strictness;
@@ -0,0 +1,3 @@
+-- Hard categories never borrow from or lend to other categories when allowances are redistributed; soft ones do
+ALTER TABLE monthly_budget_spend_category
+    ADD COLUMN IF NOT EXISTS strictness VARCHAR(10) NOT NULL DEFAULT 'soft' CHECK (strictness IN ('soft', 'hard'));
@@ -0,0 +1,26 @@
+-- One-off expenses a user plans for, such as an annual insurance premium or a holiday. Each month up to the target
+-- month the budget sets aside an even share of the amount from general spending, like a sinking fund. Once a
+-- transaction matching the expense appears it is marked occurred and nothing more is set aside.
+-- target_monthyear is MMYYYY like monthly_summary.monthyear. merchant, when set, must appear in the transaction's
+-- merchant or description for it to match.
+CREATE TABLE IF NOT EXISTS planned_expenses (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    description VARCHAR(255) NOT NULL,
+    amount NUMERIC(14,2) NOT NULL CHECK (amount > 0),
+    target_monthyear INTEGER NOT NULL,
+    merchant VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'planned' CHECK (status IN ('planned', 'occurred')),
+    transaction_id UUID REFERENCES transactions(id) ON DELETE SET NULL,
+    occurred_on DATE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_planned_expenses_user_status ON planned_expenses(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_planned_expenses_transaction ON planned_expenses(transaction_id);
+
+CREATE TRIGGER update_planned_expenses_updated_at
+    BEFORE UPDATE ON planned_expenses
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
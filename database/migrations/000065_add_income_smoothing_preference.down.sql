@@ -0,0 +1,3 @@
+ALTER TABLE user_preferences
+    DROP COLUMN IF EXISTS income_smoothing_months,
+    DROP COLUMN IF EXISTS income_mode;
@@ -0,0 +1,6 @@
+-- Where the budget's income baseline comes from. 'fixed' uses the income the user entered on the monthly summary;
+-- 'smoothed' suits irregular earners and averages the income detected over the last income_smoothing_months
+-- complete months instead.
+ALTER TABLE user_preferences
+    ADD COLUMN IF NOT EXISTS income_mode VARCHAR(20) NOT NULL DEFAULT 'fixed' CHECK (income_mode IN ('fixed', 'smoothed')),
+    ADD COLUMN IF NOT EXISTS income_smoothing_months INTEGER NOT NULL DEFAULT 6 CHECK (income_smoothing_months BETWEEN 2 AND 24);
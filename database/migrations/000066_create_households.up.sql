@@ -0,0 +1,36 @@
+-- A household groups users who budget together, such as partners. A user belongs to at most one household and
+-- joins with the household's invite code.
+CREATE TABLE IF NOT EXISTS households (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    invite_code VARCHAR(32) NOT NULL UNIQUE,
+    created_by INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_households_updated_at
+    BEFORE UPDATE ON households
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE IF NOT EXISTS household_members (
+    household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES users(user_id) ON DELETE CASCADE,
+    joined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (household_id, user_id)
+);
+
+-- A budget category the household shares, split between its members by percent. Each member's spend in the
+-- category is the combined spend of every member in the split, times their percent, so their allowance only covers
+-- their share. The percents of a category add up to 100. Categories are matched case insensitively.
+CREATE TABLE IF NOT EXISTS household_category_splits (
+    household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+    category VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    split_percent NUMERIC(5,2) NOT NULL CHECK (split_percent > 0 AND split_percent <= 100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_household_category_splits_member ON household_category_splits(household_id, LOWER(category), user_id);
+CREATE INDEX IF NOT EXISTS idx_household_category_splits_user ON household_category_splits(user_id);
@@ -0,0 +1,50 @@
+CREATE OR REPLACE FUNCTION apply_account_budget_exclusion()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_was_excluded BOOLEAN := FALSE;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        v_was_excluded := OLD.exclude_from_budget;
+    END IF;
+    IF NEW.exclude_from_budget = v_was_excluded THEN
+        RETURN NULL;
+    END IF;
+    PERFORM apply_daily_category_spend(t.user_id, t.date, t.category, t.personal_finance_category_primary,
+        t.personal_finance_category_detailed, t.amount::numeric, CASE WHEN NEW.exclude_from_budget THEN -1 ELSE 1 END)
+    FROM transactions t
+    WHERE t.user_id = NEW.user_id AND t.provider_type = NEW.provider_type AND t.account_ref = NEW.account_ref AND NOT t.is_transfer;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Clearing the flags puts flagged transactions back into daily_category_spend through the update trigger
+UPDATE transactions SET is_flagged = FALSE WHERE is_flagged;
+
+DROP TRIGGER IF EXISTS update_daily_category_spend_update ON transactions;
+CREATE TRIGGER update_daily_category_spend_update
+    AFTER UPDATE ON transactions
+    FOR EACH ROW
+    WHEN ((OLD.user_id, OLD.date, OLD.amount, OLD.category, OLD.personal_finance_category_primary, OLD.personal_finance_category_detailed, OLD.is_transfer)
+        IS DISTINCT FROM (NEW.user_id, NEW.date, NEW.amount, NEW.category, NEW.personal_finance_category_primary, NEW.personal_finance_category_detailed, NEW.is_transfer))
+    EXECUTE FUNCTION update_daily_category_spend();
+
+CREATE OR REPLACE FUNCTION update_daily_category_spend()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND NOT OLD.is_transfer
+        AND NOT account_excluded_from_budget(OLD.user_id, OLD.provider_type, OLD.account_ref) THEN
+        PERFORM apply_daily_category_spend(OLD.user_id, OLD.date, OLD.category, OLD.personal_finance_category_primary,
+            OLD.personal_finance_category_detailed, OLD.amount::numeric, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NOT NEW.is_transfer
+        AND NOT account_excluded_from_budget(NEW.user_id, NEW.provider_type, NEW.account_ref) THEN
+        PERFORM apply_daily_category_spend(NEW.user_id, NEW.date, NEW.category, NEW.personal_finance_category_primary,
+            NEW.personal_finance_category_detailed, NEW.amount::numeric, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS transaction_flags;
+ALTER TABLE transactions_archive DROP COLUMN IF EXISTS is_flagged;
+ALTER TABLE transactions DROP COLUMN IF EXISTS is_flagged;
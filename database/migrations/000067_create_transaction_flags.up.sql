@@ -0,0 +1,64 @@
+-- Users flag transactions they suspect are fraudulent or incorrect. A flagged transaction is marked is_flagged and
+-- left out of spend until it is resolved: resolving it as valid counts it again, while one resolved as invalid
+-- stays out for good.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS is_flagged BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS is_flagged BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS transaction_flags (
+    transaction_id UUID PRIMARY KEY REFERENCES transactions(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('fraud', 'incorrect')),
+    note TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'flagged' CHECK (status IN ('flagged', 'resolved')),
+    resolution VARCHAR(20) CHECK (resolution IN ('valid', 'invalid')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_flags_user_status ON transaction_flags(user_id, status);
+
+-- daily_category_spend leaves out flagged transactions as well as transfers and transactions on excluded accounts
+CREATE OR REPLACE FUNCTION update_daily_category_spend()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND NOT OLD.is_transfer AND NOT OLD.is_flagged
+        AND NOT account_excluded_from_budget(OLD.user_id, OLD.provider_type, OLD.account_ref) THEN
+        PERFORM apply_daily_category_spend(OLD.user_id, OLD.date, OLD.category, OLD.personal_finance_category_primary,
+            OLD.personal_finance_category_detailed, OLD.amount::numeric, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NOT NEW.is_transfer AND NOT NEW.is_flagged
+        AND NOT account_excluded_from_budget(NEW.user_id, NEW.provider_type, NEW.account_ref) THEN
+        PERFORM apply_daily_category_spend(NEW.user_id, NEW.date, NEW.category, NEW.personal_finance_category_primary,
+            NEW.personal_finance_category_detailed, NEW.amount::numeric, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_daily_category_spend_update ON transactions;
+CREATE TRIGGER update_daily_category_spend_update
+    AFTER UPDATE ON transactions
+    FOR EACH ROW
+    WHEN ((OLD.user_id, OLD.date, OLD.amount, OLD.category, OLD.personal_finance_category_primary, OLD.personal_finance_category_detailed, OLD.is_transfer, OLD.is_flagged)
+        IS DISTINCT FROM (NEW.user_id, NEW.date, NEW.amount, NEW.category, NEW.personal_finance_category_primary, NEW.personal_finance_category_detailed, NEW.is_transfer, NEW.is_flagged))
+    EXECUTE FUNCTION update_daily_category_spend();
+
+CREATE OR REPLACE FUNCTION apply_account_budget_exclusion()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_was_excluded BOOLEAN := FALSE;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        v_was_excluded := OLD.exclude_from_budget;
+    END IF;
+    IF NEW.exclude_from_budget = v_was_excluded THEN
+        RETURN NULL;
+    END IF;
+    PERFORM apply_daily_category_spend(t.user_id, t.date, t.category, t.personal_finance_category_primary,
+        t.personal_finance_category_detailed, t.amount::numeric, CASE WHEN NEW.exclude_from_budget THEN -1 ELSE 1 END)
+    FROM transactions t
+    WHERE t.user_id = NEW.user_id AND t.provider_type = NEW.provider_type AND t.account_ref = NEW.account_ref AND NOT t.is_transfer AND NOT t.is_flagged;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
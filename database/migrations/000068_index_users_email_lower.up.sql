@@ -0,0 +1,2 @@
+-- Registration and login look users up by their email ignoring case
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));
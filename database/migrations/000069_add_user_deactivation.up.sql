@@ -0,0 +1,7 @@
+-- A deactivated user can't log in and has nothing synced until they reactivate. Once purge_after passes, the
+-- purge job deletes the user and all their data.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS purge_after TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_purge_after ON users(purge_after) WHERE purge_after IS NOT NULL;
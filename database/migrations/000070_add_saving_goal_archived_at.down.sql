@@ -0,0 +1 @@
+ALTER TABLE saving_goal DROP COLUMN IF EXISTS archived_at;
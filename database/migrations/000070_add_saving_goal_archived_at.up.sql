@@ -0,0 +1,2 @@
+-- Archived goals are kept for their history but left out of the active list
+ALTER TABLE saving_goal ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
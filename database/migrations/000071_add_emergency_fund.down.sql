@@ -0,0 +1,3 @@
+ALTER TABLE user_preferences DROP COLUMN IF EXISTS emergency_fund_threshold_months;
+ALTER TABLE account_settings DROP COLUMN IF EXISTS is_emergency_fund;
+ALTER TABLE saving_goal DROP COLUMN IF EXISTS is_emergency_fund;
@@ -0,0 +1,7 @@
+-- A user's emergency fund is the savings goals and linked accounts they designate as one. Its balance is measured in
+-- months of their average expenses, and emergency_fund_threshold_months, when set, is the cover below which they
+-- are alerted.
+ALTER TABLE saving_goal ADD COLUMN IF NOT EXISTS is_emergency_fund BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE account_settings ADD COLUMN IF NOT EXISTS is_emergency_fund BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user_preferences
+    ADD COLUMN IF NOT EXISTS emergency_fund_threshold_months NUMERIC(4,1) CHECK (emergency_fund_threshold_months > 0);
@@ -0,0 +1,6 @@
+DELETE FROM job_runs WHERE user_id IS NULL OR provider IS NULL;
+ALTER TABLE job_runs
+    DROP COLUMN IF EXISTS payload_redacted,
+    DROP COLUMN IF EXISTS payload,
+    ALTER COLUMN provider SET NOT NULL,
+    ALTER COLUMN user_id SET NOT NULL;
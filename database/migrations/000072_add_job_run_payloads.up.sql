@@ -0,0 +1,8 @@
+-- job_runs now records every job the worker processes, not only syncs, with the data it ran with so a run can be
+-- replayed while debugging. Jobs that aren't about a user or a provider leave those empty; sync health only reads
+-- runs with a provider. payload has secrets such as access tokens replaced, and payload_redacted says so.
+ALTER TABLE job_runs
+    ALTER COLUMN user_id DROP NOT NULL,
+    ALTER COLUMN provider DROP NOT NULL,
+    ADD COLUMN IF NOT EXISTS payload JSONB,
+    ADD COLUMN IF NOT EXISTS payload_redacted BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+-- The IANA time zone a user lives in, e.g. America/Toronto. Their daily balance is recomputed shortly after their
+-- local midnight.
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
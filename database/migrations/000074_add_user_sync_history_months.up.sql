@@ -0,0 +1,4 @@
+-- How many months of transaction history a user's Plaid accounts are backfilled with when they are linked, from 1
+-- to 24. Resyncs can ask for a different depth.
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS sync_history_months SMALLINT NOT NULL DEFAULT 24
+    CHECK (sync_history_months BETWEEN 1 AND 24);
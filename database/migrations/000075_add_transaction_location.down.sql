@@ -0,0 +1,12 @@
+ALTER TABLE transactions_archive
+    DROP COLUMN IF EXISTS location_city,
+    DROP COLUMN IF EXISTS location_region,
+    DROP COLUMN IF EXISTS location_country,
+    DROP COLUMN IF EXISTS location_lat,
+    DROP COLUMN IF EXISTS location_lon;
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS location_city,
+    DROP COLUMN IF EXISTS location_region,
+    DROP COLUMN IF EXISTS location_country,
+    DROP COLUMN IF EXISTS location_lat,
+    DROP COLUMN IF EXISTS location_lon;
@@ -0,0 +1,15 @@
+-- Where a purchase was made, as Plaid reports it for in store transactions. Online purchases and other providers'
+-- transactions have no location.
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS location_city VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS location_region VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS location_country VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS location_lat DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS location_lon DOUBLE PRECISION;
+
+ALTER TABLE transactions_archive
+    ADD COLUMN IF NOT EXISTS location_city VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS location_region VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS location_country VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS location_lat DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS location_lon DOUBLE PRECISION;
@@ -0,0 +1,23 @@
+-- Trips group the spending between their start and end dates, so a holiday can be reviewed on its own. When cities
+-- or countries are given, only purchases made there count, which leaves out the spending at home during the trip.
+-- Countries are the two letter codes providers report. budget, when set, is what the user means to spend.
+CREATE TABLE IF NOT EXISTS trips (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    cities TEXT[] NOT NULL DEFAULT '{}',
+    countries TEXT[] NOT NULL DEFAULT '{}',
+    budget NUMERIC(14,2) CHECK (budget > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (end_date >= start_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_trips_user_start_date ON trips(user_id, start_date);
+
+CREATE TRIGGER update_trips_updated_at
+    BEFORE UPDATE ON trips
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_transactions_user_business_date;
+ALTER TABLE transactions_archive DROP COLUMN IF EXISTS is_business;
+ALTER TABLE transactions DROP COLUMN IF EXISTS is_business;
@@ -0,0 +1,7 @@
+-- Users mark the transactions that are business expenses, so freelancers can export them for their accountant.
+-- Marking a transaction doesn't change whether it counts towards budgets.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS is_business BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_user_business_date ON transactions(user_id, date) WHERE is_business;
+
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS is_business BOOLEAN NOT NULL DEFAULT FALSE;
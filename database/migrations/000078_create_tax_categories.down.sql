@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS update_tax_categories_updated_at ON tax_categories;
+
+DROP TABLE IF EXISTS tax_categories;
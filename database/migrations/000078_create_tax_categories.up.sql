@@ -0,0 +1,23 @@
+-- Designates categories as tax relevant for the yearly tax report. category is a budget category name as reports
+-- label it, or a Plaid personal finance category (primary or detailed). Rows with a NULL user_id are global
+-- defaults; a user's own row wins over them, and tax_type 'none' lets a user opt a category out.
+CREATE TABLE IF NOT EXISTS tax_categories (
+    id serial PRIMARY KEY,
+    user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+    category VARCHAR(255) NOT NULL,
+    tax_type VARCHAR(20) NOT NULL CHECK (tax_type IN ('donations', 'medical', 'business', 'none')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tax_categories_unique ON tax_categories(COALESCE(user_id, 0), LOWER(category));
+
+CREATE TRIGGER update_tax_categories_updated_at
+    BEFORE UPDATE ON tax_categories
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+INSERT INTO tax_categories (user_id, category, tax_type) VALUES
+    (NULL, 'GOVERNMENT_AND_NON_PROFIT_DONATIONS', 'donations'),
+    (NULL, 'MEDICAL', 'medical')
+ON CONFLICT DO NOTHING;
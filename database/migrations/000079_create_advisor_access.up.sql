@@ -0,0 +1,15 @@
+-- Read-only access users share with an advisor or accountant through a link. Only a SHA-256 hash of the link's
+-- code is stored. Redeeming the code gives the advisor a token limited to viewing summaries, reports and
+-- transactions, until the access expires or is revoked.
+CREATE TABLE IF NOT EXISTS advisor_access (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    code_hash CHAR(64) NOT NULL UNIQUE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_advisor_access_user_id ON advisor_access(user_id) WHERE revoked_at IS NULL;
@@ -0,0 +1,16 @@
+-- History imported from another budgeting app's export files. The files are parsed and loaded by the
+-- import_history job; a row tracks one upload from queued to completed or failed, with what was loaded.
+CREATE TABLE IF NOT EXISTS data_imports (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    source VARCHAR(20) NOT NULL CHECK (source IN ('ynab', 'mint')),
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    transactions_imported INTEGER NOT NULL DEFAULT 0,
+    transactions_skipped INTEGER NOT NULL DEFAULT 0,
+    budget_months_imported INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_imports_user_id ON data_imports(user_id, created_at DESC);
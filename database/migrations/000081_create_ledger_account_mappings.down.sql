@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS update_ledger_account_mappings_updated_at ON ledger_account_mappings;
+
+DROP TABLE IF EXISTS ledger_account_mappings;
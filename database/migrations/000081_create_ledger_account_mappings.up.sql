@@ -0,0 +1,18 @@
+-- Names the plain-text accounting accounts the ledger export posts to, in place of the names it makes up. kind
+-- 'account' maps one of the user's accounts by its account_ref; kind 'category' maps a category as reports label it.
+CREATE TABLE IF NOT EXISTS ledger_account_mappings (
+    id serial PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('account', 'category')),
+    source VARCHAR(255) NOT NULL,
+    ledger_account VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_account_mappings_unique ON ledger_account_mappings(user_id, kind, LOWER(source));
+
+CREATE TRIGGER update_ledger_account_mappings_updated_at
+    BEFORE UPDATE ON ledger_account_mappings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
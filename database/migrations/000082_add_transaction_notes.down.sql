@@ -0,0 +1,18 @@
+-- search_vector goes back to the merchant and description. Archived vectors were copied from transactions, so
+-- those of rows with notes are recomputed without them.
+UPDATE transactions_archive SET
+    search_vector = setweight(to_tsvector('simple'::regconfig, COALESCE(merchant, '')), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, COALESCE(description, '')), 'B')
+WHERE notes IS NOT NULL;
+ALTER TABLE transactions_archive DROP COLUMN IF EXISTS notes;
+
+DROP INDEX IF EXISTS idx_transactions_user_search_vector;
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS notes;
+ALTER TABLE transactions
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple'::regconfig, COALESCE(merchant, '')), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, COALESCE(description, '')), 'B')
+    ) STORED;
+CREATE INDEX IF NOT EXISTS idx_transactions_user_search_vector ON transactions USING GIN (user_id, search_vector);
@@ -0,0 +1,19 @@
+-- Users keep notes on their transactions, and search finds a transaction by them as well as by its merchant and
+-- description. Postgres can't change a generated column's expression, so search_vector is dropped and added
+-- again with the notes weighted below the description.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS notes TEXT;
+
+DROP INDEX IF EXISTS idx_transactions_user_search_vector;
+ALTER TABLE transactions DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE transactions
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple'::regconfig, COALESCE(merchant, '')), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, COALESCE(description, '')), 'B') ||
+        setweight(to_tsvector('simple'::regconfig, COALESCE(notes, '')), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_user_search_vector ON transactions USING GIN (user_id, search_vector);
+
+-- The archive stores search_vector as a plain column copied from transactions, so only notes is added. Archived
+-- rows have no notes yet, so their vectors stay as they are.
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS notes TEXT;
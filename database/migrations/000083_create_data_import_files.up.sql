@@ -0,0 +1,18 @@
+-- The export files of a history import, kept apart from the import_history job so the worker's job records and
+-- dead letters don't hold a copy of the user's financial history. An import's files are deleted once it
+-- completes; those of imports that didn't are pruned after a week, leaving time to replay the job.
+CREATE TABLE IF NOT EXISTS data_import_files (
+    import_id INTEGER PRIMARY KEY REFERENCES data_imports(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    register BYTEA NOT NULL,
+    budget BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_import_files_created_at ON data_import_files(created_at);
+
+-- Jobs recorded before carried the files in their data
+UPDATE job_runs SET payload = payload - 'register' - 'budget'
+WHERE job_type = 'import_history' AND jsonb_typeof(payload) = 'object';
+UPDATE dead_letter_jobs SET data = data - 'register' - 'budget'
+WHERE job_type = 'import_history' AND jsonb_typeof(data) = 'object';
@@ -0,0 +1,4 @@
+ALTER TABLE teller_accounts
+    DROP COLUMN IF EXISTS available_balance,
+    DROP COLUMN IF EXISTS ledger_balance,
+    DROP COLUMN IF EXISTS balances_updated_at;
@@ -0,0 +1,6 @@
+-- Teller accounts keep the balances last fetched from their balances link, so balance sums such as safe-to-spend
+-- include Teller-only users. Teller calls the current balance the ledger balance; either can be missing.
+ALTER TABLE teller_accounts
+    ADD COLUMN IF NOT EXISTS available_balance NUMERIC(14,2),
+    ADD COLUMN IF NOT EXISTS ledger_balance NUMERIC(14,2),
+    ADD COLUMN IF NOT EXISTS balances_updated_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,11 @@
+-- Put the secrets back into their dead letters' data. Only top-level keys can be, which is all job data has.
+UPDATE dead_letter_jobs d
+SET data = d.data || COALESCE((
+    SELECT jsonb_object_agg(replace(replace(substr(e.key, 2), '~1', '/'), '~0', '~'), e.value)
+    FROM jsonb_each(s.secrets) e
+    WHERE position('/' IN substr(e.key, 2)) = 0
+), '{}'::jsonb)
+FROM dead_letter_job_secrets s
+WHERE s.dead_letter_id = d.id AND jsonb_typeof(d.data) = 'object';
+
+DROP TABLE IF EXISTS dead_letter_job_secrets;
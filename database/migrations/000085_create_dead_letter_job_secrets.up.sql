@@ -0,0 +1,25 @@
+-- Secrets such as access tokens are split out of a dead letter's data, which keeps [REDACTED] in their place, so
+-- listing dead letters never shows them. They are only read back to replay the job.
+CREATE TABLE IF NOT EXISTS dead_letter_job_secrets (
+    dead_letter_id INTEGER PRIMARY KEY REFERENCES dead_letter_jobs(id) ON DELETE CASCADE,
+    secrets JSONB NOT NULL
+);
+
+-- Dead letters recorded before kept their secrets in their data. Job data is flat, so their top-level keys are
+-- the ones checked, with the same key parts as jobs.IsSecretKey.
+INSERT INTO dead_letter_job_secrets (dead_letter_id, secrets)
+SELECT d.id, jsonb_object_agg('/' || replace(replace(e.key, '~', '~0'), '/', '~1'), e.value)
+FROM dead_letter_jobs d, jsonb_each(d.data) e
+WHERE jsonb_typeof(d.data) = 'object' AND e.value <> 'null'::jsonb
+    AND replace(replace(lower(e.key), '_', ''), '-', '') ~ '(token|secret|password|apikey|authorization|credential|signature|webhookurl|privatekey)'
+GROUP BY d.id;
+
+UPDATE dead_letter_jobs d
+SET data = d.data || (
+    SELECT jsonb_object_agg(e.key, '"[REDACTED]"'::jsonb)
+    FROM jsonb_each(d.data) e
+    WHERE e.value <> 'null'::jsonb
+        AND replace(replace(lower(e.key), '_', ''), '-', '') ~ '(token|secret|password|apikey|authorization|credential|signature|webhookurl|privatekey)'
+)
+FROM dead_letter_job_secrets s
+WHERE s.dead_letter_id = d.id;
@@ -0,0 +1,3 @@
+-- When the plan was last synced from a billing event. Stripe doesn't deliver events in order, so events older than
+-- this are skipped rather than overwriting newer state.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan_updated_at TIMESTAMP WITH TIME ZONE;
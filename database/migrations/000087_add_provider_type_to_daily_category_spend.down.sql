@@ -0,0 +1,98 @@
+DROP TRIGGER IF EXISTS update_daily_category_spend_update ON transactions;
+
+DROP FUNCTION IF EXISTS apply_daily_category_spend(INTEGER, VARCHAR, DATE, JSONB, VARCHAR, VARCHAR, NUMERIC, INTEGER);
+
+-- Groups of different providers collapse into one, so the rows are rebuilt without the provider
+DELETE FROM daily_category_spend;
+ALTER TABLE daily_category_spend DROP CONSTRAINT IF EXISTS daily_category_spend_pkey;
+ALTER TABLE daily_category_spend DROP COLUMN IF EXISTS provider_type;
+ALTER TABLE daily_category_spend
+    ADD PRIMARY KEY (user_id, date, personal_finance_category_primary, personal_finance_category_detailed, category);
+
+CREATE OR REPLACE FUNCTION apply_daily_category_spend(p_user_id INTEGER, p_date DATE, p_category JSONB, p_pfc_primary VARCHAR, p_pfc_detailed VARCHAR, p_amount NUMERIC, p_sign INTEGER)
+RETURNS VOID AS $$
+DECLARE
+    v_spend NUMERIC := CASE WHEN p_amount > 0 THEN p_amount ELSE 0 END;
+    v_spend_count INTEGER := CASE WHEN p_amount > 0 THEN 1 ELSE 0 END;
+BEGIN
+    IF p_sign > 0 THEN
+        INSERT INTO daily_category_spend AS d (user_id, date, category, personal_finance_category_primary, personal_finance_category_detailed,
+            total_amount, transaction_count, spend_amount, spend_count)
+        VALUES (p_user_id, p_date, COALESCE(p_category, 'null'), COALESCE(p_pfc_primary, ''), COALESCE(p_pfc_detailed, ''),
+            p_amount, 1, v_spend, v_spend_count)
+        ON CONFLICT (user_id, date, personal_finance_category_primary, personal_finance_category_detailed, category) DO UPDATE SET
+            total_amount = d.total_amount + EXCLUDED.total_amount,
+            transaction_count = d.transaction_count + EXCLUDED.transaction_count,
+            spend_amount = d.spend_amount + EXCLUDED.spend_amount,
+            spend_count = d.spend_count + EXCLUDED.spend_count;
+        RETURN;
+    END IF;
+
+    UPDATE daily_category_spend SET
+        total_amount = total_amount - p_amount,
+        transaction_count = transaction_count - 1,
+        spend_amount = spend_amount - v_spend,
+        spend_count = spend_count - v_spend_count
+    WHERE user_id = p_user_id AND date = p_date AND category = COALESCE(p_category, 'null')
+        AND personal_finance_category_primary = COALESCE(p_pfc_primary, '')
+        AND personal_finance_category_detailed = COALESCE(p_pfc_detailed, '');
+
+    DELETE FROM daily_category_spend
+    WHERE user_id = p_user_id AND date = p_date AND category = COALESCE(p_category, 'null')
+        AND personal_finance_category_primary = COALESCE(p_pfc_primary, '')
+        AND personal_finance_category_detailed = COALESCE(p_pfc_detailed, '')
+        AND transaction_count <= 0;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION update_daily_category_spend()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND NOT OLD.is_transfer AND NOT OLD.is_flagged
+        AND NOT account_excluded_from_budget(OLD.user_id, OLD.provider_type, OLD.account_ref) THEN
+        PERFORM apply_daily_category_spend(OLD.user_id, OLD.date, OLD.category, OLD.personal_finance_category_primary,
+            OLD.personal_finance_category_detailed, OLD.amount::numeric, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NOT NEW.is_transfer AND NOT NEW.is_flagged
+        AND NOT account_excluded_from_budget(NEW.user_id, NEW.provider_type, NEW.account_ref) THEN
+        PERFORM apply_daily_category_spend(NEW.user_id, NEW.date, NEW.category, NEW.personal_finance_category_primary,
+            NEW.personal_finance_category_detailed, NEW.amount::numeric, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_daily_category_spend_update
+    AFTER UPDATE ON transactions
+    FOR EACH ROW
+    WHEN ((OLD.user_id, OLD.date, OLD.amount, OLD.category, OLD.personal_finance_category_primary, OLD.personal_finance_category_detailed, OLD.is_transfer, OLD.is_flagged)
+        IS DISTINCT FROM (NEW.user_id, NEW.date, NEW.amount, NEW.category, NEW.personal_finance_category_primary, NEW.personal_finance_category_detailed, NEW.is_transfer, NEW.is_flagged))
+    EXECUTE FUNCTION update_daily_category_spend();
+
+CREATE OR REPLACE FUNCTION apply_account_budget_exclusion()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_was_excluded BOOLEAN := FALSE;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        v_was_excluded := OLD.exclude_from_budget;
+    END IF;
+    IF NEW.exclude_from_budget = v_was_excluded THEN
+        RETURN NULL;
+    END IF;
+    PERFORM apply_daily_category_spend(t.user_id, t.date, t.category, t.personal_finance_category_primary,
+        t.personal_finance_category_detailed, t.amount::numeric, CASE WHEN NEW.exclude_from_budget THEN -1 ELSE 1 END)
+    FROM transactions t
+    WHERE t.user_id = NEW.user_id AND t.provider_type = NEW.provider_type AND t.account_ref = NEW.account_ref AND NOT t.is_transfer AND NOT t.is_flagged;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+INSERT INTO daily_category_spend (user_id, date, category, personal_finance_category_primary, personal_finance_category_detailed,
+    total_amount, transaction_count, spend_amount, spend_count)
+SELECT user_id, date, COALESCE(category, 'null'), COALESCE(personal_finance_category_primary, ''), COALESCE(personal_finance_category_detailed, ''),
+    SUM(amount), COUNT(*),
+    COALESCE(SUM(amount) FILTER (WHERE amount > 0), 0), COUNT(*) FILTER (WHERE amount > 0)
+FROM transactions
+WHERE NOT is_transfer AND NOT is_flagged AND NOT account_excluded_from_budget(user_id, provider_type, account_ref)
+GROUP BY 1, 2, 3, 4, 5;
@@ -0,0 +1,608 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ********** USER SCOPE **********
+
+// Per-user queries go through a UserScope rather than DB directly. The scope passes the user's id as $1 and
+// refuses any query that isn't confined to it, so a query missing its user_id condition fails instead of reading
+// or changing other users' data.
+//
+// A select, update or delete is confined to the user when its WHERE clause has user_id = $1 as one of its
+// top-level AND conditions, with no top-level OR that could let other rows through; a CASE expression counts as
+// one condition, whatever it holds. Each part of a UNION is checked on its own, as is any insert, update or
+// delete in a WITH clause. An insert is confined when its first column is user_id and every row it inserts gives
+// $1 for it. Conditions inside subqueries, comments and string literals don't count.
+
+// ErrUnscopedQuery is returned for a query run through a UserScope that doesn't filter on the scope's user
+var ErrUnscopedQuery = errors.New("query is not scoped to the user")
+
+// queryer is what a UserScope runs its queries on, the primary or a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// UserScope runs queries on behalf of one user
+type UserScope struct {
+	userID int
+	db     queryer
+}
+
+// ScopeToUser returns a scope running the user's queries on the primary
+func ScopeToUser(userID int) UserScope {
+	return UserScope{userID: userID, db: DB}
+}
+
+// InTx returns the scope running its queries as part of tx
+func (scope UserScope) InTx(tx *sql.Tx) UserScope {
+	return UserScope{userID: scope.userID, db: tx}
+}
+
+// scopedRow is a single row result that may have been refused before the query ran
+type scopedRow struct {
+	row *sql.Row
+	err error
+}
+
+func (row *scopedRow) Scan(dest ...interface{}) error {
+	if row.err != nil {
+		return row.err
+	}
+	return row.row.Scan(dest...)
+}
+
+// bind checks the query is confined to the scope's user and puts the user's id first in its arguments
+func (scope UserScope) bind(query string, args []interface{}) ([]interface{}, error) {
+	if scope.userID <= 0 {
+		return nil, fmt.Errorf("%w: invalid user id %d", ErrUnscopedQuery, scope.userID)
+	}
+	if !scopedToUser(query) {
+		return nil, ErrUnscopedQuery
+	}
+	return append([]interface{}{scope.userID}, args...), nil
+}
+
+// Query runs a query for the user with args as $2 onwards
+func (scope UserScope) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return scope.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext is Query, cancelled with ctx
+func (scope UserScope) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	bound, err := scope.bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return scope.db.QueryContext(ctx, query, bound...)
+}
+
+// ReadQuery runs a read-only query for the user on the replica like readQuery. Inside a transaction it runs on
+// the transaction.
+func (scope UserScope) ReadQuery(query string, args ...interface{}) (*sql.Rows, error) {
+	bound, err := scope.bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if scope.db != DB {
+		return scope.db.QueryContext(context.Background(), query, bound...)
+	}
+	return readQuery(query, bound...)
+}
+
+// QueryRow runs a single row query for the user with args as $2 onwards
+func (scope UserScope) QueryRow(query string, args ...interface{}) *scopedRow {
+	return scope.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext is QueryRow, cancelled with ctx
+func (scope UserScope) QueryRowContext(ctx context.Context, query string, args ...interface{}) *scopedRow {
+	bound, err := scope.bind(query, args)
+	if err != nil {
+		return &scopedRow{err: err}
+	}
+	return &scopedRow{row: scope.db.QueryRowContext(ctx, query, bound...)}
+}
+
+// ReadQueryRow runs a single row read-only query for the user on the replica like readQueryRow. Inside a
+// transaction it runs on the transaction.
+func (scope UserScope) ReadQueryRow(query string, args ...interface{}) *scopedRow {
+	bound, err := scope.bind(query, args)
+	if err != nil {
+		return &scopedRow{err: err}
+	}
+	if scope.db != DB {
+		return &scopedRow{row: scope.db.QueryRowContext(context.Background(), query, bound...)}
+	}
+	return &scopedRow{row: readQueryRow(query, bound...)}
+}
+
+// Exec runs a statement for the user with args as $2 onwards
+func (scope UserScope) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return scope.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext is Exec, cancelled with ctx
+func (scope UserScope) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	bound, err := scope.bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return scope.db.ExecContext(ctx, query, bound...)
+}
+
+// QueryBuilder returns a builder for the user's queries, its first condition confining column to the user. Its
+// placeholders start at $2, so its Args go to the scope's queries as they are.
+func (scope UserScope) QueryBuilder(column string) *QueryBuilder {
+	qb := &QueryBuilder{reserved: 1}
+	qb.Where(column + " = $1")
+	return qb
+}
+
+// sqlToken is a word, parameter or symbol of a query. Words are lower cased, and string literals are all "'".
+type sqlToken struct {
+	text string
+	// depth is how many parentheses the token is inside
+	depth int
+}
+
+// tokenizeSQL splits a query into tokens, dropping comments and the contents of string literals. A comment or
+// literal left open ends the tokens with "/*".
+func tokenizeSQL(query string) []sqlToken {
+	var tokens []sqlToken
+	depth := 0
+	add := func(text string) {
+		tokens = append(tokens, sqlToken{text: text, depth: depth})
+	}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				add("/*")
+				return tokens
+			}
+			i += 2 + end + 2
+		case c == '\'' || c == '"':
+			// Quotes inside a literal or identifier are doubled
+			end := i + 1
+			for end < len(query) && (query[end] != c || strings.HasPrefix(query[end:], string([]byte{c, c}))) {
+				if query[end] == c {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) {
+				add("/*")
+				return tokens
+			}
+			if c == '"' {
+				add(strings.ToLower(strings.ReplaceAll(query[i+1:end], `""`, `"`)))
+			} else {
+				add("'")
+			}
+			i = end + 1
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			end := i + 1
+			for end < len(query) && isDigit(query[end]) {
+				end++
+			}
+			add(query[i:end])
+			i = end
+		case c == '$':
+			// A dollar quoted literal, $tag$...$tag$
+			tagEnd := strings.IndexByte(query[i+1:], '$')
+			if tagEnd < 0 {
+				add("/*")
+				return tokens
+			}
+			tag := query[i : i+1+tagEnd+1]
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				add("/*")
+				return tokens
+			}
+			add("'")
+			i += len(tag) + end + len(tag)
+		case isWordByte(c):
+			end := i
+			for end < len(query) && (isWordByte(query[end]) || query[end] == '$') {
+				end++
+			}
+			add(strings.ToLower(query[i:end]))
+			i = end
+		case c == '(':
+			add("(")
+			depth++
+			i++
+		case c == ')':
+			depth--
+			add(")")
+			i++
+		case strings.IndexByte(sqlOperatorBytes, c) >= 0:
+			end := i
+			for end < len(query) && strings.IndexByte(sqlOperatorBytes, query[end]) >= 0 && !strings.HasPrefix(query[end:], "--") && !strings.HasPrefix(query[end:], "/*") {
+				end++
+			}
+			add(query[i:end])
+			i = end
+		default:
+			add(string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+// sqlOperatorBytes are the characters operators are made of
+const sqlOperatorBytes = "+-*/<>=~!@#%^&|`?:"
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWordByte reports whether c can be part of a keyword, identifier or number. Bytes of non-ASCII characters
+// are, as Postgres allows them in identifiers.
+func isWordByte(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// scopedToUser reports whether a query only reads and changes the rows of the user bound as $1
+func scopedToUser(query string) bool {
+	tokens := tokenizeSQL(query)
+	if len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	for _, token := range tokens {
+		// Unbalanced parentheses, an unterminated comment or literal, or a second statement
+		if token.depth < 0 || token.text == "/*" || token.text == ";" {
+			return false
+		}
+	}
+	return statementScoped(tokens, nil)
+}
+
+// withTables records whether each WITH table in scope only holds the user's rows
+type withTables map[string]bool
+
+// statementScoped checks a statement, all the tokens of which sit at least as deep as its first
+func statementScoped(tokens []sqlToken, tables withTables) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	depth := tokens[0].depth
+	if tokens[0].text == "with" {
+		var ok bool
+		if tokens, tables, ok = withScoped(tokens[1:], depth, tables); !ok {
+			return false
+		}
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	switch tokens[0].text {
+	case "insert":
+		return insertScoped(tokens, depth, tables)
+	case "update", "delete":
+		return whereScoped(tokens, depth)
+	case "select":
+		for _, part := range splitAt(tokens, depth, "union", "intersect", "except") {
+			if len(part) > 0 && (part[0].text == "all" || part[0].text == "distinct") {
+				part = part[1:]
+			}
+			if len(part) == 0 || !(whereScoped(part, depth) || fromScoped(part, depth, tables)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// withScoped checks the WITH clause of a statement, returning the statement after it and its WITH tables along
+// with those already in scope. Inserts, updates and deletes in it must be confined to the user; queries only
+// read, so their tables are just noted as confined or not.
+func withScoped(tokens []sqlToken, depth int, outer withTables) ([]sqlToken, withTables, bool) {
+	tables := withTables{}
+	for name, scoped := range outer {
+		tables[name] = scoped
+	}
+	if len(tokens) > 0 && tokens[0].text == "recursive" {
+		tokens = tokens[1:]
+	}
+	for {
+		// name [(columns)] AS [NOT] [MATERIALIZED] (statement)
+		if len(tokens) == 0 {
+			return nil, nil, false
+		}
+		name, i := tokens[0].text, 1
+		if i < len(tokens) && tokens[i].text == "(" {
+			i = closingParen(tokens, i) + 1
+		}
+		if i <= 0 || i >= len(tokens) || tokens[i].text != "as" {
+			return nil, nil, false
+		}
+		for i++; i < len(tokens) && (tokens[i].text == "not" || tokens[i].text == "materialized"); i++ {
+		}
+		if i >= len(tokens) || tokens[i].text != "(" {
+			return nil, nil, false
+		}
+		end := closingParen(tokens, i)
+		if end < 0 {
+			return nil, nil, false
+		}
+		body := tokens[i+1 : end]
+		scoped := statementScoped(body, tables)
+		if !scoped && (len(body) == 0 || body[0].text != "select") {
+			return nil, nil, false
+		}
+		tables[name] = scoped
+		tokens = tokens[end+1:]
+		if len(tokens) == 0 || tokens[0].depth != depth || tokens[0].text != "," {
+			return tokens, tables, true
+		}
+		tokens = tokens[1:]
+	}
+}
+
+// closingParen returns the index of the parenthesis closing the one at open, or -1
+func closingParen(tokens []sqlToken, open int) int {
+	for i := open + 1; i < len(tokens); i++ {
+		if tokens[i].text == ")" && tokens[i].depth == tokens[open].depth {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitAt splits tokens at the words given, where they sit at depth
+func splitAt(tokens []sqlToken, depth int, words ...string) [][]sqlToken {
+	var parts [][]sqlToken
+	start := 0
+	for i, token := range tokens {
+		if token.depth == depth && slices.Contains(words, token.text) {
+			parts = append(parts, tokens[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, tokens[start:])
+}
+
+// clauseAfter returns the tokens after the first of word at depth up to any of ends at depth, and whether word
+// was found
+func clauseAfter(tokens []sqlToken, depth int, word string, ends []string) ([]sqlToken, bool) {
+	start := slices.IndexFunc(tokens, func(token sqlToken) bool {
+		return token.depth == depth && token.text == word
+	})
+	if start < 0 {
+		return nil, false
+	}
+	clause := tokens[start+1:]
+	for i, token := range clause {
+		if token.depth == depth && slices.Contains(ends, token.text) {
+			return clause[:i], true
+		}
+	}
+	return clause, true
+}
+
+// whereClauseEnds are the words ending a WHERE clause
+var whereClauseEnds = []string{"group", "having", "window", "order", "limit", "offset", "fetch", "for", "returning", "on"}
+
+// whereScoped checks a statement's own WHERE clause holds user_id = $1 as a top-level condition
+func whereScoped(tokens []sqlToken, depth int) bool {
+	clause, ok := clauseAfter(tokens, depth, "where", whereClauseEnds)
+	if !ok {
+		return false
+	}
+	var conditions [][]sqlToken
+	begin, between, cases := 0, false, 0
+	for i, token := range clause {
+		if token.depth != depth {
+			continue
+		}
+		// A CASE expression is a single condition, whatever ORs and ANDs it holds
+		switch token.text {
+		case "case":
+			cases++
+		case "end":
+			if cases--; cases < 0 {
+				return false
+			}
+		}
+		if cases > 0 {
+			continue
+		}
+		switch token.text {
+		case "or":
+			return false
+		case "between":
+			between = true
+		case "and":
+			// The AND of BETWEEN x AND y is part of the condition
+			if between {
+				between = false
+				continue
+			}
+			conditions = append(conditions, clause[begin:i])
+			begin = i + 1
+		}
+	}
+	conditions = append(conditions, clause[begin:])
+	return slices.ContainsFunc(conditions, isUserCondition)
+}
+
+// isUserCondition reports whether a condition is user_id = $1, with the column optionally qualified, or the
+// user's rows along with the shared ones: (user_id = $1 OR user_id IS NULL)
+func isUserCondition(condition []sqlToken) bool {
+	texts := make([]string, len(condition))
+	for i, token := range condition {
+		texts[i] = token.text
+	}
+	isColumn := func(words []string) bool {
+		return (len(words) == 1 && words[0] == "user_id") || (len(words) == 3 && words[1] == "." && words[2] == "user_id")
+	}
+	n := len(texts)
+	if n >= 3 && texts[n-2] == "=" && texts[n-1] == "$1" && isColumn(texts[:n-2]) {
+		return true
+	}
+	if n >= 3 && texts[0] == "$1" && texts[1] == "=" && isColumn(texts[2:]) {
+		return true
+	}
+	if n < 2 || texts[0] != "(" || closingParen(condition, 0) != n-1 {
+		return false
+	}
+	either := splitAt(condition[1:n-1], condition[0].depth+1, "or")
+	if len(either) == 1 {
+		return isUserCondition(either[0])
+	}
+	if len(either) != 2 || !isUserCondition(either[0]) {
+		return false
+	}
+	shared := either[1]
+	return len(shared) >= 3 && shared[len(shared)-2].text == "is" && shared[len(shared)-1].text == "null" &&
+		isColumn(texts[n-1-len(shared):n-3])
+}
+
+// fromClauseEnds are the words ending a FROM clause
+var fromClauseEnds = []string{"where", "group", "having", "window", "order", "limit", "offset", "fetch", "for"}
+
+// joinWords are the words joining the items of a FROM clause
+var joinWords = []string{"join", "inner", "left", "right", "full", "outer", "cross", "natural", "lateral"}
+
+// rowlessFunctions read no tables, so may appear in a FROM clause
+var rowlessFunctions = []string{"generate_series", "unnest"}
+
+// fromScoped checks a select only reads rows confined to the user: every item of its FROM clause is a confined
+// WITH table, a confined subquery or a function reading no tables. A select with no FROM clause reads through
+// the subqueries in it, which must all be confined.
+func fromScoped(tokens []sqlToken, depth int, tables withTables) bool {
+	clause, ok := clauseAfter(tokens, depth, "from", fromClauseEnds)
+	if !ok {
+		subqueries := 0
+		for i, token := range tokens {
+			if token.depth == depth && token.text == "(" && i+1 < len(tokens) && tokens[i+1].text == "select" {
+				end := closingParen(tokens, i)
+				if end < 0 || !statementScoped(tokens[i+1:end], tables) {
+					return false
+				}
+				subqueries++
+			}
+		}
+		return subqueries > 0
+	}
+
+	for i := 0; i < len(clause); {
+		for i < len(clause) && slices.Contains(joinWords, clause[i].text) {
+			i++
+		}
+		if i >= len(clause) {
+			return false
+		}
+		switch {
+		case clause[i].text == "(":
+			end := closingParen(clause, i)
+			if end < 0 || !statementScoped(clause[i+1:end], tables) {
+				return false
+			}
+			i = end + 1
+		case i+1 < len(clause) && clause[i+1].text == "(":
+			end := closingParen(clause, i+1)
+			if !slices.Contains(rowlessFunctions, clause[i].text) || end < 0 ||
+				slices.ContainsFunc(clause[i+2:end], func(token sqlToken) bool { return token.text == "select" }) {
+				return false
+			}
+			i = end + 1
+		default:
+			if !tables[clause[i].text] {
+				return false
+			}
+			i++
+		}
+		// Skip the alias and join condition up to the next item
+		for i < len(clause) && !(clause[i].depth == depth && (clause[i].text == "," || slices.Contains(joinWords, clause[i].text))) {
+			i++
+		}
+		if i < len(clause) && clause[i].text == "," {
+			i++
+		}
+	}
+	return true
+}
+
+// insertScoped checks an insert's first column is user_id and each row inserted gives it $1
+func insertScoped(tokens []sqlToken, depth int, tables withTables) bool {
+	// INSERT INTO table [AS alias] (user_id, ...)
+	open := -1
+	for i, token := range tokens {
+		if token.depth == depth && token.text == "(" {
+			open = i
+			break
+		}
+		if token.text == "values" || token.text == "select" {
+			return false
+		}
+	}
+	if open < 0 || open+2 >= len(tokens) || tokens[open+1].text != "user_id" || tokens[open+2].text != "," {
+		return false
+	}
+	end := closingParen(tokens, open)
+	if end < 0 || end+1 >= len(tokens) {
+		return false
+	}
+	rest := tokens[end+1:]
+
+	switch rest[0].text {
+	case "values":
+		// Every row starts with $1: VALUES ($1, ...), ($1, ...)
+		rows := rest[1:]
+		for {
+			if len(rows) < 3 || rows[0].text != "(" || rows[1].text != "$1" || rows[2].text != "," {
+				return false
+			}
+			end := closingParen(rows, 0)
+			if end < 0 {
+				return false
+			}
+			rows = rows[end+1:]
+			if len(rows) == 0 || rows[0].text != "," {
+				return true
+			}
+			rows = rows[1:]
+		}
+	case "select":
+		// SELECT $1, ... inserts the user's id outright, SELECT user_id, ... copies it from rows confined to the user
+		if slices.ContainsFunc(rest, func(token sqlToken) bool {
+			return token.depth == depth && slices.Contains([]string{"union", "intersect", "except"}, token.text)
+		}) {
+			return false
+		}
+		if len(rest) > 2 && rest[1].text == "$1" && rest[2].text == "," {
+			return true
+		}
+		first := 1
+		if len(rest) > 3 && rest[2].text == "." {
+			first = 3
+		}
+		return len(rest) > first+1 && rest[first].text == "user_id" && rest[first+1].text == "," &&
+			(whereScoped(rest, depth) || fromScoped(rest, depth, tables))
+	}
+	return false
+}
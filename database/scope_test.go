@@ -0,0 +1,493 @@
+package database
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestScopedToUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		scoped bool
+	}{
+		{"user condition", "SELECT id FROM transactions WHERE user_id = $1 AND date >= $2", true},
+		{"qualified column", "SELECT t.id FROM transactions t JOIN teller_accounts a ON a.id = t.teller_account_id WHERE t.user_id = $1", true},
+		{"placeholder first", "SELECT id FROM transactions WHERE $1 = user_id", true},
+		{"between", "SELECT id FROM transactions WHERE date BETWEEN $2 AND $3 AND user_id = $1", true},
+		{"or inside parentheses", "SELECT id FROM transactions WHERE user_id = $1 AND (category = $2 OR merchant = $2)", true},
+		{"or inside a case", "SELECT id FROM transactions WHERE user_id = $1 AND CASE WHEN $2 THEN amount > 0 OR amount < 0 ELSE TRUE END", true},
+		{"shared rows", "SELECT id FROM category_mappings WHERE (user_id = $1 OR user_id IS NULL) ORDER BY id", true},
+		{"update", "UPDATE saving_goal SET total = $2 WHERE user_id = $1 AND id = $3 RETURNING id", true},
+		{"delete", "DELETE FROM api_keys WHERE id = $2 AND user_id = $1", true},
+		{"every union part", "SELECT id FROM plaid_accounts WHERE user_id = $1 UNION ALL SELECT id::text FROM teller_accounts WHERE user_id = $1", true},
+		{"from a scoped cte", "WITH mine AS (SELECT * FROM transactions WHERE user_id = $1) SELECT COUNT(*) FROM mine m", true},
+		{"from a scoped subquery", "SELECT COUNT(*) FROM (SELECT id FROM transactions WHERE user_id = $1) t", true},
+		{"exists without from", "SELECT EXISTS (SELECT 1 FROM transactions WHERE user_id = $1)", true},
+		{"insert values", "INSERT INTO saving_goal (user_id, name) VALUES ($1, $2), ($1, $3) ON CONFLICT DO NOTHING", true},
+		{"insert select", "INSERT INTO notifications (user_id, channel_id) SELECT user_id, id FROM notification_channels WHERE user_id = $1", true},
+		{"insert in a cte", "WITH added AS (INSERT INTO round_ups (user_id, amount) VALUES ($1, $2) RETURNING id) SELECT id FROM added", true},
+		{"trailing semicolon", "SELECT id FROM transactions WHERE user_id = $1;", true},
+
+		{"no user condition", "SELECT id FROM transactions WHERE date >= $2", false},
+		{"no where clause", "SELECT id FROM transactions", false},
+		{"or true", "SELECT id FROM transactions WHERE user_id = $1 OR TRUE", false},
+		{"or another condition", "UPDATE transactions SET notes = $2 WHERE user_id = $1 OR id = $3", false},
+		{"another user's placeholder", "SELECT id FROM transactions WHERE user_id = $2", false},
+		{"compared to something else", "SELECT id FROM transactions WHERE user_id = $1 + 1", false},
+		{"only in a subquery", "SELECT id FROM transactions WHERE id IN (SELECT transaction_id FROM round_ups WHERE user_id = $1)", false},
+		{"only in a comment", "SELECT id FROM transactions -- WHERE user_id = $1\n", false},
+		{"only in a block comment", "SELECT id FROM transactions /* WHERE user_id = $1 */", false},
+		{"only in a string literal", "SELECT id FROM transactions WHERE description = 'user_id = $1'", false},
+		{"unterminated literal", "SELECT id FROM transactions WHERE user_id = $1 AND description = 'x", false},
+		{"unscoped union part", "SELECT id FROM plaid_accounts WHERE user_id = $1 UNION SELECT id FROM plaid_accounts", false},
+		{"unscoped cte write", "WITH gone AS (DELETE FROM transactions RETURNING id) SELECT id FROM transactions WHERE user_id = $1", false},
+		{"from an unscoped cte", "WITH every AS (SELECT * FROM transactions) SELECT COUNT(*) FROM every", false},
+		{"insert without user first", "INSERT INTO saving_goal (name, user_id) VALUES ($2, $1)", false},
+		{"insert for another user", "INSERT INTO saving_goal (user_id, name) VALUES ($1, $2), ($3, $2)", false},
+		{"insert selecting every user", "INSERT INTO notifications (user_id, channel_id) SELECT user_id, id FROM notification_channels", false},
+		{"second statement", "SELECT id FROM transactions WHERE user_id = $1; DELETE FROM transactions", false},
+		{"unbalanced parentheses", "SELECT id FROM transactions WHERE (user_id = $1", false},
+	}
+	for _, tt := range tests {
+		if got := scopedToUser(tt.query); got != tt.scoped {
+			t.Errorf("%s: scopedToUser(%q) = %v, want %v", tt.name, tt.query, got, tt.scoped)
+		}
+	}
+}
+
+func TestUserScopeRefusesUnscopedQueries(t *testing.T) {
+	// The query is refused before it would reach the database, so none is needed
+	scope := ScopeToUser(7)
+	if _, err := scope.Query("SELECT id FROM transactions WHERE user_id = $1 OR TRUE"); !errors.Is(err, ErrUnscopedQuery) {
+		t.Errorf("Query = %v, want ErrUnscopedQuery", err)
+	}
+	if _, err := scope.Exec("DELETE FROM transactions WHERE id = $2", "id"); !errors.Is(err, ErrUnscopedQuery) {
+		t.Errorf("Exec = %v, want ErrUnscopedQuery", err)
+	}
+	var id int
+	if err := scope.QueryRow("SELECT id FROM transactions").Scan(&id); !errors.Is(err, ErrUnscopedQuery) {
+		t.Errorf("QueryRow = %v, want ErrUnscopedQuery", err)
+	}
+	if _, err := ScopeToUser(0).Query("SELECT id FROM transactions WHERE user_id = $1"); !errors.Is(err, ErrUnscopedQuery) {
+		t.Errorf("Query without a user = %v, want ErrUnscopedQuery", err)
+	}
+}
+
+func TestUserScopeQueryBuilderPlaceholders(t *testing.T) {
+	qb := ScopeToUser(7).QueryBuilder("transactions.user_id")
+	qb.Where("date >= " + qb.Arg("2025-01-01"))
+	qb.Where("merchant = " + qb.Arg("Cafe"))
+
+	query := "SELECT id FROM transactions" + qb.WhereClause()
+	if want := "SELECT id FROM transactions WHERE transactions.user_id = $1 AND date >= $2 AND merchant = $3"; query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+	if !scopedToUser(query) {
+		t.Errorf("scopedToUser(%q) = false", query)
+	}
+	if args := qb.Args(); len(args) != 2 || args[0] != "2025-01-01" || args[1] != "Cafe" {
+		t.Errorf("args = %v, want the builder's own two", args)
+	}
+}
+
+// Why a function in unscopedQueryFunctions may run queries outside a UserScope
+const (
+	acrossUsers     = "runs for every user from a scheduled job"
+	byProviderID    = "finds the user's rows by a provider's id, from a webhook or sync"
+	byRowID         = "finds the row by its id, from a job that already knows whose it is"
+	acrossHousehold = "reads or changes the rows of every member of a household"
+)
+
+// unscopedQueryFunctions are the functions of database.go allowed to run queries on per-user tables outside a
+// UserScope, with why. They work across users, or find the user from something other than their id.
+var unscopedQueryFunctions = map[string]string{
+	"CreateUser":                "registers the user",
+	"GetUserByEmail":            "finds the user by their email",
+	"GetUserByEmailAndPassword": "finds the user by their email",
+	"ReactivateUser":            "finds the user by their email",
+	"UnsubscribeDigest":         "finds the user by their unsubscribe token",
+	"GetActiveAPIKeyByHash":     "finds the user by their API key",
+	"getActiveAdvisorAccess":    "finds the access by its token",
+	"UpdateStripeSubscription":  "finds the user by their Stripe customer",
+	"PurgeUser":                 "checks whether anyone else is left in the user's household",
+	"RecordJobRun":              "records jobs that aren't about a user too",
+	"GetJobRun":                 "looks up any job run for admins",
+	"UpsertCategoryMapping":     "also changes the global mappings every user shares",
+	"DeleteCategoryMapping":     "also changes the global mappings every user shares",
+
+	"DetectTransactionAnomalies":             acrossUsers,
+	"DetectSubscriptionPriceIncreases":       acrossUsers,
+	"ArchiveTransactionsBefore":              acrossUsers,
+	"GetTellerEnrollmentsDueReauthReminder":  acrossUsers,
+	"GetPlaidItemsMissingInstitution":        acrossUsers,
+	"GetInstitutionsDueForRefresh":           acrossUsers,
+	"GetUsersWithPlaidAccounts":              acrossUsers,
+	"GetUsersWithActiveSavingsGoals":         acrossUsers,
+	"GetUsersWithEmergencyFundThreshold":     acrossUsers,
+	"GetUsersWithPendingRoundUps":            acrossUsers,
+	"GetDebtPlanUserIDs":                     acrossUsers,
+	"GetUsersMissingMonthlyReport":           acrossUsers,
+	"GetDailyBalanceUsersByTimezone":         acrossUsers,
+	"GetUsersDueForDigest":                   acrossUsers,
+	"GetUsersDueForPurge":                    acrossUsers,
+	"GetGoogleSheetsConnectionsDueForExport": acrossUsers,
+	"FlagStaleAccounts":                      acrossUsers,
+	"ClaimDueChannelMessages":                acrossUsers,
+	"ClaimDueWebhookDeliveries":              acrossUsers,
+	"PruneJobRuns":                           acrossUsers,
+	"PruneDataImportFiles":                   acrossUsers,
+
+	"MarkPlaidAccountAsSynced":                        byProviderID,
+	"GetAccessTokenFromAccountID":                     byProviderID,
+	"GetUserIdFromAccessToken":                        byProviderID,
+	"GetUserIdFromItemID":                             byProviderID,
+	"GetPlaidItemToken":                               byProviderID,
+	"GetPlaidItemAccountIDs":                          byProviderID,
+	"MarkPlaidTokenAsProcessed":                       byProviderID,
+	"SetPlaidItemInstitution":                         byProviderID,
+	"UpdatePlaidBackfillProgress":                     byProviderID,
+	"UpdatePlaidTransactionPersonalFinanceCategories": byProviderID,
+
+	"MarkTellerReauthReminded":      byRowID,
+	"DeactivateNotificationChannel": byRowID,
+	"RecordChannelMessageAttempt":   byRowID,
+	"RecordWebhookDeliveryAttempt":  byRowID,
+	"TouchAPIKey":                   byRowID,
+	"TouchAdvisorAccess":            byRowID,
+	"StartDataImport":               byRowID,
+	"CompleteDataImport":            byRowID,
+	"FailDataImport":                byRowID,
+
+	"JoinHousehold":                 acrossHousehold,
+	"GetHousehold":                  acrossHousehold,
+	"LeaveHousehold":                acrossHousehold,
+	"SetHouseholdCategorySplits":    acrossHousehold,
+	"DeleteHouseholdCategorySplits": acrossHousehold,
+	"getSharedCategorySplits":       acrossHousehold,
+}
+
+// scopeMethods are the UserScope methods running a query, with the index of the query among their arguments
+var scopeMethods = map[string]int{
+	"Query": 0, "QueryContext": 1, "ReadQuery": 0, "QueryRow": 0, "QueryRowContext": 1, "ReadQueryRow": 0,
+	"Exec": 0, "ExecContext": 1,
+}
+
+// TestPerUserQueriesGoThroughScope reads database.go and fails on any query run on DB, a transaction or the
+// replica that reads or writes a per-user table, unless its function is in unscopedQueryFunctions. Queries run
+// through a UserScope whose SQL is known up front, a literal or a query := built once, must pass scopedToUser,
+// rather than waiting for the scope to refuse them at runtime.
+func TestPerUserQueriesGoThroughScope(t *testing.T) {
+	tables := perUserTables(t)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "database.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse database.go: %v", err)
+	}
+	consts := stringConsts(file)
+
+	allowed := map[string]bool{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || fn.Name.Name == "readQuery" || fn.Name.Name == "readQueryRow" {
+			continue
+		}
+		scopes := scopeVariables(fn)
+		ast.Inspect(fn.Body, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			position := fset.Position(call.Pos())
+			if index, ok := scopeQueryIndex(call, scopes); ok {
+				if index < len(call.Args) {
+					if query, exact := sqlText(call.Args[index], fn, consts); exact && !scopedToUser(query) {
+						t.Errorf("%s: %s runs a query the user scope will refuse: %s", position, fn.Name.Name, query)
+					}
+				}
+				return true
+			}
+			index, ok := unscopedQueryIndex(call)
+			if !ok || index >= len(call.Args) {
+				return true
+			}
+			query, _ := sqlText(call.Args[index], fn, consts)
+			table := perUserTableIn(query, tables)
+			if query != "" && table == "" {
+				return true
+			}
+			if _, ok := unscopedQueryFunctions[fn.Name.Name]; ok {
+				allowed[fn.Name.Name] = true
+				return true
+			}
+			if table == "" {
+				t.Errorf("%s: %s runs a query outside a user scope whose SQL can't be read", position, fn.Name.Name)
+			} else {
+				t.Errorf("%s: %s queries %s outside a user scope", position, fn.Name.Name, table)
+			}
+			return true
+		})
+	}
+	for name := range unscopedQueryFunctions {
+		if !allowed[name] {
+			t.Errorf("%s no longer queries per-user tables outside a user scope, remove it from unscopedQueryFunctions", name)
+		}
+	}
+}
+
+// perUserTables reads the migrations for the tables with a user_id column
+func perUserTables(t *testing.T) map[string]bool {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("migrations", "*.up.sql"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("failed to find the migrations: %v", err)
+	}
+	sort.Strings(paths)
+	tables := map[string]bool{}
+	for _, path := range paths {
+		migration, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+		tokens := tokenizeSQL(string(migration))
+		texts := make([]string, len(tokens))
+		for i, token := range tokens {
+			texts[i] = token.text
+		}
+		for i := 0; i+2 < len(tokens); i++ {
+			switch {
+			case texts[i] == "create" && texts[i+1] == "table":
+				name, open := tableName(texts, i+2)
+				if open >= len(tokens) || texts[open] != "(" {
+					continue
+				}
+				end := closingParen(tokens, open)
+				if end < 0 {
+					continue
+				}
+				// Column definitions start the table's body or follow a comma in it
+				for j := open + 1; j < end; j++ {
+					if tokens[j].depth != tokens[open].depth+1 || (j != open+1 && texts[j-1] != ",") {
+						continue
+					}
+					if texts[j] == "user_id" {
+						tables[name] = true
+					}
+					if texts[j] == "like" && j+1 < end && tables[texts[j+1]] {
+						tables[name] = true
+					}
+				}
+			case texts[i] == "alter" && texts[i+1] == "table":
+				name, j := tableName(texts, i+2)
+				for ; j < len(texts) && texts[j] != ";"; j++ {
+					if texts[j] != "add" {
+						continue
+					}
+					column := j + 1
+					for column < len(texts) && slices.Contains([]string{"column", "if", "not", "exists"}, texts[column]) {
+						column++
+					}
+					if column < len(texts) && texts[column] == "user_id" {
+						tables[name] = true
+					}
+				}
+			case texts[i] == "drop" && texts[i+1] == "table":
+				name, _ := tableName(texts, i+2)
+				delete(tables, name)
+			}
+		}
+	}
+	if !tables["transactions"] {
+		t.Fatal("found no per-user tables in the migrations")
+	}
+	return tables
+}
+
+// tableName returns the table named at i of a CREATE, ALTER or DROP TABLE and the index after it
+func tableName(texts []string, i int) (string, int) {
+	for i < len(texts) && slices.Contains([]string{"if", "not", "exists", "only"}, texts[i]) {
+		i++
+	}
+	if i >= len(texts) {
+		return "", i
+	}
+	return texts[i], i + 1
+}
+
+// perUserTableIn returns a per-user table a query reads or writes, or "" if it has none
+func perUserTableIn(query string, tables map[string]bool) string {
+	tokens := tokenizeSQL(query)
+	for i := 1; i < len(tokens); i++ {
+		if slices.Contains([]string{"from", "join", "update", "into"}, tokens[i-1].text) && tables[tokens[i].text] {
+			return tokens[i].text
+		}
+	}
+	return ""
+}
+
+// stringConsts returns the string constants declared in a file
+func stringConsts(file *ast.File) map[string]string {
+	consts := map[string]string{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				if i < len(value.Values) {
+					if text, exact := sqlText(value.Values[i], nil, consts); exact {
+						consts[name.Name] = text
+					}
+				}
+			}
+		}
+	}
+	return consts
+}
+
+// sqlText returns the SQL an expression in fn evaluates to, and whether it is exactly that. A query assigned more
+// than once, or built with fmt.Sprintf or a QueryBuilder, gives only the literal text in it.
+func sqlText(expr ast.Expr, fn *ast.FuncDecl, consts map[string]string) (string, bool) {
+	resolver := sqlResolver{fn: fn, consts: consts, resolving: map[string]bool{}}
+	return resolver.text(expr)
+}
+
+// sqlResolver follows the variables a query is built from, resolving holding those it is in the middle of
+type sqlResolver struct {
+	fn        *ast.FuncDecl
+	consts    map[string]string
+	resolving map[string]bool
+}
+
+func (resolver sqlResolver) text(expr ast.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		text, err := strconv.Unquote(e.Value)
+		return text, err == nil
+	case *ast.ParenExpr:
+		return resolver.text(e.X)
+	case *ast.BinaryExpr:
+		left, leftExact := resolver.text(e.X)
+		right, rightExact := resolver.text(e.Y)
+		return left + right, e.Op == token.ADD && leftExact && rightExact
+	case *ast.Ident:
+		if text, ok := resolver.consts[e.Name]; ok {
+			return text, true
+		}
+		if resolver.fn == nil || resolver.resolving[e.Name] {
+			return "", false
+		}
+		resolver.resolving[e.Name] = true
+		defer delete(resolver.resolving, e.Name)
+		var texts []string
+		assignments, defined := 0, false
+		ast.Inspect(resolver.fn.Body, func(node ast.Node) bool {
+			assign, ok := node.(*ast.AssignStmt)
+			if !ok || len(assign.Lhs) != len(assign.Rhs) {
+				return true
+			}
+			for i, lhs := range assign.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok && ident.Name == e.Name {
+					text, exact := resolver.text(assign.Rhs[i])
+					texts = append(texts, text)
+					assignments++
+					defined = defined || (assign.Tok == token.DEFINE && exact)
+				}
+			}
+			return true
+		})
+		return strings.Join(texts, " "), assignments == 1 && defined
+	case *ast.CallExpr:
+		var texts []string
+		for _, arg := range e.Args {
+			if text, _ := resolver.text(arg); text != "" {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, " "), false
+	}
+	return "", false
+}
+
+// scopeVariables returns the names a function holds a UserScope in, as a parameter or from ScopeToUser
+func scopeVariables(fn *ast.FuncDecl) map[string]bool {
+	scopes := map[string]bool{}
+	for _, field := range fn.Type.Params.List {
+		if ident, ok := field.Type.(*ast.Ident); ok && ident.Name == "UserScope" {
+			for _, name := range field.Names {
+				scopes[name.Name] = true
+			}
+		}
+	}
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		if assign, ok := node.(*ast.AssignStmt); ok && len(assign.Lhs) == len(assign.Rhs) {
+			for i, lhs := range assign.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok && isScope(assign.Rhs[i], scopes) {
+					scopes[ident.Name] = true
+				}
+			}
+		}
+		return true
+	})
+	return scopes
+}
+
+// isScope reports whether an expression is a UserScope: ScopeToUser(...), a scope variable or a scope's InTx
+func isScope(expr ast.Expr, scopes map[string]bool) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return scopes[e.Name]
+	case *ast.CallExpr:
+		if ident, ok := e.Fun.(*ast.Ident); ok {
+			return ident.Name == "ScopeToUser"
+		}
+		if selector, ok := e.Fun.(*ast.SelectorExpr); ok {
+			return selector.Sel.Name == "InTx" && isScope(selector.X, scopes)
+		}
+	}
+	return false
+}
+
+// scopeQueryIndex returns where the query is among the arguments of a call running it through a UserScope
+func scopeQueryIndex(call *ast.CallExpr, scopes map[string]bool) (int, bool) {
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !isScope(selector.X, scopes) {
+		return 0, false
+	}
+	index, ok := scopeMethods[selector.Sel.Name]
+	return index, ok
+}
+
+// unscopedQueryIndex returns where the query is among the arguments of a call running it on DB, a transaction
+// or the replica
+func unscopedQueryIndex(call *ast.CallExpr) (int, bool) {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return 0, fun.Name == "readQuery" || fun.Name == "readQueryRow"
+	case *ast.SelectorExpr:
+		receiver, ok := fun.X.(*ast.Ident)
+		if !ok || (receiver.Name != "DB" && receiver.Name != "tx") {
+			return 0, false
+		}
+		if fun.Sel.Name == "Prepare" {
+			return 0, true
+		}
+		index, ok := scopeMethods[fun.Sel.Name]
+		return index, ok && !strings.HasPrefix(fun.Sel.Name, "Read")
+	}
+	return 0, false
+}
@@ -0,0 +1,99 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"money": func(amount float64) string { return fmt.Sprintf("$%.2f", amount) },
+}).ParseFS(templateFS, "templates/*.html"))
+
+// ErrNotConfigured is returned by Send when no SMTP host is set
+var ErrNotConfigured = errors.New("email is not configured: SMTP_HOST is not set")
+
+// Config holds the SMTP settings used to send email
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// LoadConfig reads the SMTP settings from the environment
+func LoadConfig() Config {
+	config := Config{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("EMAIL_FROM"),
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	if config.From == "" {
+		config.From = "Watson <no-reply@watson.app>"
+	}
+	return config
+}
+
+// Render executes the named HTML template
+func Render(name string, data interface{}) (string, error) {
+	var body bytes.Buffer
+	if err := templates.ExecuteTemplate(&body, name, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %v", name, err)
+	}
+	return body.String(), nil
+}
+
+// Send delivers an HTML email. When unsubscribeURL is set it is also advertised in the
+// List-Unsubscribe headers so mail clients can offer one click unsubscribe.
+func Send(config Config, to string, subject string, htmlBody string, unsubscribeURL string) error {
+	if config.Host == "" {
+		return ErrNotConfigured
+	}
+	headers := []string{
+		"From: " + config.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/html; charset=\"UTF-8\"",
+	}
+	if unsubscribeURL != "" {
+		headers = append(headers,
+			"List-Unsubscribe: <"+unsubscribeURL+">",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+		)
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + htmlBody
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	if err := smtp.SendMail(config.Host+":"+config.Port, auth, senderAddress(config.From), []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+// senderAddress extracts the bare address from a "Name <address>" From header
+func senderAddress(from string) string {
+	if start := strings.Index(from, "<"); start >= 0 {
+		if end := strings.Index(from[start:], ">"); end >= 0 {
+			return from[start+1 : start+end]
+		}
+	}
+	return from
+}
@@ -0,0 +1,103 @@
+package errorreport
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Reporter sends errors and recovered panics somewhere they will be noticed. Sentry is used when
+// SENTRY_DSN is set, otherwise reports are only logged; SetReporter swaps in anything else.
+type Reporter interface {
+	CaptureError(err error, tags map[string]string, extra map[string]interface{})
+	CapturePanic(recovered interface{}, tags map[string]string, extra map[string]interface{})
+	Flush(timeout time.Duration)
+}
+
+var reporter Reporter = logReporter{}
+
+// Init picks the reporter for a service from the environment
+func Init(serviceName string) {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		log.Printf("SENTRY_DSN not set, errors will only be logged")
+		return
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              dsn,
+		Environment:      os.Getenv("SENTRY_ENVIRONMENT"),
+		ServerName:       serviceName,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		log.Printf("Failed to initialize Sentry, errors will only be logged: %v", err)
+		return
+	}
+	reporter = sentryReporter{}
+	log.Printf("Sentry error reporting enabled for %s", serviceName)
+}
+
+// SetReporter replaces the active reporter
+func SetReporter(r Reporter) {
+	reporter = r
+}
+
+// CaptureError reports an error along with tags to group it by and extra context
+func CaptureError(err error, tags map[string]string, extra map[string]interface{}) {
+	if err == nil {
+		return
+	}
+	reporter.CaptureError(err, tags, extra)
+}
+
+// CapturePanic reports a value recovered from a panic. It should be called from the deferred
+// function that recovered so the stack trace points at the panic.
+func CapturePanic(recovered interface{}, tags map[string]string, extra map[string]interface{}) {
+	reporter.CapturePanic(recovered, tags, extra)
+}
+
+// Flush waits up to timeout for queued reports to be sent, for use before the process exits
+func Flush(timeout time.Duration) {
+	reporter.Flush(timeout)
+}
+
+type sentryReporter struct{}
+
+func (sentryReporter) CaptureError(err error, tags map[string]string, extra map[string]interface{}) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		scope.SetExtras(extra)
+		sentry.CaptureException(err)
+	})
+}
+
+func (sentryReporter) CapturePanic(recovered interface{}, tags map[string]string, extra map[string]interface{}) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		scope.SetExtras(extra)
+		scope.SetLevel(sentry.LevelFatal)
+		sentry.CurrentHub().Recover(recovered)
+	})
+}
+
+func (sentryReporter) Flush(timeout time.Duration) {
+	sentry.Flush(timeout)
+}
+
+// logReporter is the fallback when no error tracking service is configured
+type logReporter struct{}
+
+func (logReporter) CaptureError(err error, tags map[string]string, extra map[string]interface{}) {
+	log.Printf("❌ Error reported: %v tags=%v extra=%v", err, tags, extra)
+}
+
+func (logReporter) CapturePanic(recovered interface{}, tags map[string]string, extra map[string]interface{}) {
+	log.Printf("❌ Panic recovered: %s tags=%v extra=%v\n%s", fmt.Sprint(recovered), tags, extra, debug.Stack())
+}
+
+func (logReporter) Flush(time.Duration) {}
@@ -0,0 +1,194 @@
+package faultinject
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Faults that can be injected into provider requests
+const (
+	// FaultTimeout holds the request until FAULT_INJECTION_TIMEOUT passes or its context ends, then fails it with
+	// a timeout error without sending it
+	FaultTimeout = "timeout"
+	// FaultRateLimit answers with a 429 in the provider's error format without sending the request
+	FaultRateLimit = "rate_limit"
+	// FaultMalformed sends the request but cuts the response body short, so it no longer parses
+	FaultMalformed = "malformed"
+)
+
+var allFaults = []string{FaultTimeout, FaultRateLimit, FaultMalformed}
+
+// Config is what the fault injector does, read from the environment by LoadConfig
+type Config struct {
+	// Rate is the fraction of requests that get a fault, from 0 to 1. Nothing is injected at 0.
+	Rate      float64
+	Faults    []string
+	Providers []string
+	// Timeout is how long a FaultTimeout request hangs before failing
+	Timeout time.Duration
+}
+
+// LoadConfig reads the fault injection settings. Fault injection is off unless FAULT_INJECTION_RATE is set.
+//
+//	FAULT_INJECTION_RATE       fraction of provider requests to fail, e.g. 0.1
+//	FAULT_INJECTION_FAULTS     comma separated faults to pick from: timeout, rate_limit, malformed (default all)
+//	FAULT_INJECTION_PROVIDERS  comma separated providers to fail: plaid, teller (default both)
+//	FAULT_INJECTION_TIMEOUT    how long injected timeouts hang, e.g. 10s (default 5s)
+func LoadConfig() Config {
+	config := Config{Faults: allFaults, Providers: []string{"plaid", "teller"}, Timeout: 5 * time.Second}
+	if val := os.Getenv("FAULT_INJECTION_RATE"); val != "" {
+		rate, err := strconv.ParseFloat(val, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Printf("❌ Invalid FAULT_INJECTION_RATE %q, fault injection is off", val)
+		} else {
+			config.Rate = rate
+		}
+	}
+	if val := os.Getenv("FAULT_INJECTION_FAULTS"); val != "" {
+		var faults []string
+		for _, fault := range splitList(val) {
+			if !slices.Contains(allFaults, fault) {
+				log.Printf("❌ Unknown fault %q in FAULT_INJECTION_FAULTS, ignoring it", fault)
+				continue
+			}
+			faults = append(faults, fault)
+		}
+		config.Faults = faults
+	}
+	if val := os.Getenv("FAULT_INJECTION_PROVIDERS"); val != "" {
+		config.Providers = splitList(val)
+	}
+	if val := os.Getenv("FAULT_INJECTION_TIMEOUT"); val != "" {
+		timeout, err := time.ParseDuration(val)
+		if err != nil || timeout <= 0 {
+			log.Printf("❌ Invalid FAULT_INJECTION_TIMEOUT %q, using %s", val, config.Timeout)
+		} else {
+			config.Timeout = timeout
+		}
+	}
+	return config
+}
+
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// WrapTransport returns base with faults injected into a share of its requests to provider, as configured by
+// the environment. It returns base unchanged when fault injection is off for the provider or environment is
+// "production": faults are only ever injected in staging and development.
+func WrapTransport(provider string, environment string, base http.RoundTripper) http.RoundTripper {
+	config := LoadConfig()
+	if config.Rate == 0 || len(config.Faults) == 0 || !slices.Contains(config.Providers, provider) {
+		return base
+	}
+	if environment == "production" {
+		log.Printf("❌ Fault injection is configured but %s is in production, not injecting faults", provider)
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	log.Printf("⚠️ Injecting %s faults into %.0f%% of %s requests", strings.Join(config.Faults, ", "), config.Rate*100, provider)
+	return &transport{provider: provider, config: config, base: base}
+}
+
+type transport struct {
+	provider string
+	config   Config
+	base     http.RoundTripper
+}
+
+// timeoutError is what an injected timeout fails with. It is a net.Error so callers treat it like a real one.
+type timeoutError struct {
+	provider string
+	after    time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("injected fault: %s request timed out after %s", e.provider, e.after)
+}
+
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rand.Float64() >= t.config.Rate {
+		return t.base.RoundTrip(req)
+	}
+	fault := t.config.Faults[rand.Intn(len(t.config.Faults))]
+	log.Printf("💥 Injecting %s fault into %s %s %s", fault, t.provider, req.Method, req.URL.Path)
+
+	switch fault {
+	case FaultTimeout:
+		timer := time.NewTimer(t.config.Timeout)
+		defer timer.Stop()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-timer.C:
+			return nil, &timeoutError{provider: t.provider, after: t.config.Timeout}
+		}
+	case FaultRateLimit:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return t.rateLimitResponse(req), nil
+	default:
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// Half a JSON document never parses; an empty body gets an unterminated object instead
+		body = body[:len(body)/2]
+		if len(body) == 0 {
+			body = []byte("{")
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+		return resp, nil
+	}
+}
+
+// rateLimitResponse is a 429 shaped like the provider's own, so the provider's error handling runs
+func (t *transport) rateLimitResponse(req *http.Request) *http.Response {
+	body := `{"error":{"code":"rate_limit_exceeded","message":"Injected fault: too many requests"}}`
+	if t.provider == "plaid" {
+		body = `{"error_type":"RATE_LIMIT_EXCEEDED","error_code":"RATE_LIMIT","error_message":"Injected fault: too many requests",` +
+			`"display_message":null,"request_id":"injected-fault"}`
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Retry-After", "1")
+	return &http.Response{
+		Status:        "429 Too Many Requests",
+		StatusCode:    http.StatusTooManyRequests,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,344 @@
+package googlesheets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"watson/telemetry"
+)
+
+const (
+	authURL    = "https://accounts.google.com/o/oauth2/v2/auth"
+	tokenURL   = "https://oauth2.googleapis.com/token"
+	revokeURL  = "https://oauth2.googleapis.com/revoke"
+	apiBaseURL = "https://sheets.googleapis.com/v4/spreadsheets"
+
+	// scope lets Watson create spreadsheets and append to one the user picks by its id
+	scope = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+var (
+	GOOGLE_CLIENT_ID           = ""
+	GOOGLE_CLIENT_SECRET       = ""
+	GOOGLE_SHEETS_REDIRECT_URL = ""
+	httpClient                 = &http.Client{Timeout: 30 * time.Second, Transport: telemetry.WrapTransport(nil)}
+)
+
+// ErrNotConfigured is returned when the Google OAuth client is not set up
+var ErrNotConfigured = errors.New("google sheets is not configured: GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET or GOOGLE_SHEETS_REDIRECT_URL is not set")
+
+// ErrAuthorizationRevoked is returned when Google refuses the user's refresh token, because the user revoked
+// Watson's access or the token expired. The user has to authorize again.
+var ErrAuthorizationRevoked = errors.New("google authorization has been revoked")
+
+// ErrSpreadsheetNotFound is returned when a spreadsheet doesn't exist or isn't shared with the authorized account
+var ErrSpreadsheetNotFound = errors.New("spreadsheet not found or not shared with this google account")
+
+// Sheet names a tab exports are appended to and the header row it starts with
+type Sheet struct {
+	Title  string
+	Header []string
+}
+
+const (
+	SheetMonthlySummary = "Monthly Summary"
+	SheetCategorySpend  = "Category Spend"
+	SheetTransactions   = "Transactions"
+	SheetGoals          = "Goals"
+)
+
+// ExportSheets are the tabs an export spreadsheet holds. Rows appended to a tab follow its header.
+var ExportSheets = []Sheet{
+	{Title: SheetMonthlySummary, Header: []string{"Month", "Income", "Spent", "Purchases", "Savings Rate %", "Change vs Previous Month %"}},
+	{Title: SheetCategorySpend, Header: []string{"Month", "Category", "Budget", "Spent", "Remaining"}},
+	{Title: SheetTransactions, Header: []string{"Month", "Date", "Description", "Merchant", "Category", "Amount", "Currency"}},
+	{Title: SheetGoals, Header: []string{"Month", "Goal", "Saved", "Target", "Target Date", "Monthly Pace", "Redeemed"}},
+}
+
+// InitGoogleSheets loads the Google OAuth client from the environment. The Sheets integration is disabled when it
+// is missing.
+func InitGoogleSheets() {
+	GOOGLE_CLIENT_ID = os.Getenv("GOOGLE_CLIENT_ID")
+	GOOGLE_CLIENT_SECRET = os.Getenv("GOOGLE_CLIENT_SECRET")
+	GOOGLE_SHEETS_REDIRECT_URL = os.Getenv("GOOGLE_SHEETS_REDIRECT_URL")
+	if !configured() {
+		log.Printf("Warning: GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET or GOOGLE_SHEETS_REDIRECT_URL is not set, Google Sheets export is disabled")
+	}
+}
+
+func configured() bool {
+	return GOOGLE_CLIENT_ID != "" && GOOGLE_CLIENT_SECRET != "" && GOOGLE_SHEETS_REDIRECT_URL != ""
+}
+
+// Token is the result of exchanging an authorization code. RefreshToken is only sent on the first consent, which
+// AuthCodeURL forces.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// AuthCodeURL returns the Google consent page a user authorizes Watson on. Google redirects back to
+// GOOGLE_SHEETS_REDIRECT_URL with state and an authorization code.
+func AuthCodeURL(state string) (string, error) {
+	if !configured() {
+		return "", ErrNotConfigured
+	}
+	params := url.Values{}
+	params.Set("client_id", GOOGLE_CLIENT_ID)
+	params.Set("redirect_uri", GOOGLE_SHEETS_REDIRECT_URL)
+	params.Set("response_type", "code")
+	params.Set("scope", scope)
+	params.Set("state", state)
+	params.Set("access_type", "offline")
+	params.Set("prompt", "consent")
+	params.Set("include_granted_scopes", "true")
+	return authURL + "?" + params.Encode(), nil
+}
+
+// ExchangeCode trades the authorization code from the consent redirect for tokens
+func ExchangeCode(ctx context.Context, code string) (*Token, error) {
+	form := url.Values{}
+	form.Set("code", code)
+	form.Set("grant_type", "authorization_code")
+	form.Set("redirect_uri", GOOGLE_SHEETS_REDIRECT_URL)
+	var token Token
+	if err := postToken(ctx, form, &token); err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	if token.RefreshToken == "" {
+		return nil, errors.New("failed to exchange authorization code: google did not return a refresh token")
+	}
+	return &token, nil
+}
+
+// RefreshAccessToken returns a new access token for a stored refresh token
+func RefreshAccessToken(ctx context.Context, refreshToken string) (string, error) {
+	form := url.Values{}
+	form.Set("refresh_token", refreshToken)
+	form.Set("grant_type", "refresh_token")
+	var token Token
+	if err := postToken(ctx, form, &token); err != nil {
+		return "", fmt.Errorf("failed to refresh access token: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+// RevokeToken withdraws Watson's access to the user's Google account
+func RevokeToken(ctx context.Context, token string) error {
+	form := url.Values{}
+	form.Set("token", token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	defer resp.Body.Close()
+	// An already revoked or expired token is reported as invalid, which is what revoking was for
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("failed to revoke token: google returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func postToken(ctx context.Context, form url.Values, out interface{}) error {
+	if !configured() {
+		return ErrNotConfigured
+	}
+	form.Set("client_id", GOOGLE_CLIENT_ID)
+	form.Set("client_secret", GOOGLE_CLIENT_SECRET)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var tokenErr struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		json.Unmarshal(body, &tokenErr)
+		if tokenErr.Error == "invalid_grant" {
+			return fmt.Errorf("%w: %s", ErrAuthorizationRevoked, tokenErr.ErrorDescription)
+		}
+		return fmt.Errorf("google returned status %d: %s %s", resp.StatusCode, tokenErr.Error, tokenErr.ErrorDescription)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// Spreadsheet is the subset of a spreadsheet the export uses
+type Spreadsheet struct {
+	SpreadsheetID  string `json:"spreadsheetId"`
+	SpreadsheetURL string `json:"spreadsheetUrl"`
+	Properties     struct {
+		Title string `json:"title"`
+	} `json:"properties"`
+	Sheets []struct {
+		Properties struct {
+			Title string `json:"title"`
+		} `json:"properties"`
+	} `json:"sheets"`
+}
+
+const spreadsheetFields = "spreadsheetId,spreadsheetUrl,properties.title,sheets.properties.title"
+
+// CreateSpreadsheet creates a spreadsheet in the user's Drive holding the export sheets and their headers
+func CreateSpreadsheet(ctx context.Context, accessToken string, title string) (*Spreadsheet, error) {
+	sheets := make([]map[string]interface{}, 0, len(ExportSheets))
+	for _, sheet := range ExportSheets {
+		sheets = append(sheets, map[string]interface{}{"properties": map[string]string{"title": sheet.Title}})
+	}
+	body := map[string]interface{}{
+		"properties": map[string]string{"title": title},
+		"sheets":     sheets,
+	}
+	var spreadsheet Spreadsheet
+	if err := do(ctx, accessToken, http.MethodPost, apiBaseURL+"?fields="+url.QueryEscape(spreadsheetFields), body, &spreadsheet); err != nil {
+		return nil, fmt.Errorf("failed to create spreadsheet: %w", err)
+	}
+	for _, sheet := range ExportSheets {
+		if err := AppendRows(ctx, accessToken, spreadsheet.SpreadsheetID, sheet.Title, [][]interface{}{headerRow(sheet)}); err != nil {
+			return nil, err
+		}
+	}
+	return &spreadsheet, nil
+}
+
+// GetSpreadsheet returns a spreadsheet the user can access, or an error when they can't
+func GetSpreadsheet(ctx context.Context, accessToken string, spreadsheetID string) (*Spreadsheet, error) {
+	var spreadsheet Spreadsheet
+	endpoint := apiBaseURL + "/" + url.PathEscape(spreadsheetID) + "?fields=" + url.QueryEscape(spreadsheetFields)
+	if err := do(ctx, accessToken, http.MethodGet, endpoint, nil, &spreadsheet); err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet: %w", err)
+	}
+	return &spreadsheet, nil
+}
+
+// PrepareSpreadsheet adds the export sheets a spreadsheet is missing, each with its header row. Sheets the user
+// already has are left as they are.
+func PrepareSpreadsheet(ctx context.Context, accessToken string, spreadsheetID string) error {
+	spreadsheet, err := GetSpreadsheet(ctx, accessToken, spreadsheetID)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(spreadsheet.Sheets))
+	for _, sheet := range spreadsheet.Sheets {
+		existing[sheet.Properties.Title] = true
+	}
+	var missing []Sheet
+	var requests []map[string]interface{}
+	for _, sheet := range ExportSheets {
+		if existing[sheet.Title] {
+			continue
+		}
+		missing = append(missing, sheet)
+		requests = append(requests, map[string]interface{}{
+			"addSheet": map[string]interface{}{"properties": map[string]string{"title": sheet.Title}},
+		})
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	endpoint := apiBaseURL + "/" + url.PathEscape(spreadsheetID) + ":batchUpdate"
+	if err := do(ctx, accessToken, http.MethodPost, endpoint, map[string]interface{}{"requests": requests}, nil); err != nil {
+		return fmt.Errorf("failed to add sheets: %w", err)
+	}
+	for _, sheet := range missing {
+		if err := AppendRows(ctx, accessToken, spreadsheetID, sheet.Title, [][]interface{}{headerRow(sheet)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendRows adds rows after the last row of a sheet. Values are stored as given rather than parsed, so text
+// from transactions can't be read as a formula.
+func AppendRows(ctx context.Context, accessToken string, spreadsheetID string, sheetTitle string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	sheetRange := "'" + strings.ReplaceAll(sheetTitle, "'", "''") + "'!A1"
+	endpoint := apiBaseURL + "/" + url.PathEscape(spreadsheetID) + "/values/" + url.PathEscape(sheetRange) +
+		":append?valueInputOption=RAW&insertDataOption=INSERT_ROWS"
+	if err := do(ctx, accessToken, http.MethodPost, endpoint, map[string]interface{}{"values": rows}, nil); err != nil {
+		return fmt.Errorf("failed to append rows to %s: %w", sheetTitle, err)
+	}
+	return nil
+}
+
+func headerRow(sheet Sheet) []interface{} {
+	row := make([]interface{}, len(sheet.Header))
+	for i, column := range sheet.Header {
+		row[i] = column
+	}
+	return row
+}
+
+func do(ctx context.Context, accessToken string, method string, endpoint string, in interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &apiErr)
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("%w: %s", ErrAuthorizationRevoked, apiErr.Error.Message)
+		case http.StatusForbidden, http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrSpreadsheetNotFound, apiErr.Error.Message)
+		}
+		return fmt.Errorf("google returned status %d: %s", resp.StatusCode, apiErr.Error.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
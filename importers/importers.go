@@ -0,0 +1,234 @@
+package importers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"watson/money"
+)
+
+// Sources history can be imported from, as stored in transactions.provider_type
+const (
+	SourceYNAB = "ynab"
+	SourceMint = "mint"
+)
+
+// Sources are every source history can be imported from
+var Sources = []string{SourceYNAB, SourceMint}
+
+// Transaction is a transaction read from another app's export, in the shape a provider reports one
+type Transaction struct {
+	// ID identifies the transaction across imports of the same export, so importing a file twice doesn't store
+	// its transactions twice. Identical transactions on the same day are told apart by their order in the file.
+	ID      string
+	Account string
+	Date    time.Time
+	// Amount is positive for money out, like Plaid's
+	Amount money.Money
+	// Description is the transaction as the bank reported it, and Merchant who the money went to, when the source
+	// tells them apart
+	Description string
+	Merchant    string
+	// Category is the source app's category, its group first when it has one
+	Category []string
+	// PFCPrimary and PFCDetailed are the Plaid personal finance category the source category maps to, empty when
+	// it doesn't map to one
+	PFCPrimary  string
+	PFCDetailed string
+}
+
+// Budget is what was budgeted for a category in one month of another app's budget
+type Budget struct {
+	Month time.Time
+	// Category is the source app's category, its group first when it has one
+	Category []string
+	Amount   money.Money
+}
+
+// Options change how exports are read
+type Options struct {
+	// DayFirst reads dates like 02/01/2024 as the 2nd of January rather than February 1st
+	DayFirst bool
+}
+
+// ErrNoRows is returned for an export with a header but nothing under it
+var ErrNoRows = errors.New("the file has no rows")
+
+// table is a CSV export read into rows addressed by header name
+type table struct {
+	columns map[string]int
+	rows    [][]string
+}
+
+// readTable reads a CSV export whose first row names its columns. Column names are matched case insensitively.
+func readTable(r io.Reader) (*table, error) {
+	// Exports saved by spreadsheet apps can start with a byte order mark, which would hide a quoted first header
+	buffered := bufio.NewReader(r)
+	if bom, err := buffered.Peek(3); err == nil && bytes.Equal(bom, []byte("\ufeff")) {
+		buffered.Discard(3)
+	}
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("the file isn't a valid CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the file is empty")
+	}
+	t := &table{columns: map[string]int{}, rows: records[1:]}
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, seen := t.columns[name]; !seen {
+			t.columns[name] = i
+		}
+	}
+	if len(t.rows) == 0 {
+		return nil, ErrNoRows
+	}
+	return t, nil
+}
+
+// has reports whether the export has a column
+func (t *table) has(column string) bool {
+	_, ok := t.columns[column]
+	return ok
+}
+
+// require fails unless the export has every column
+func (t *table) require(columns ...string) error {
+	for _, column := range columns {
+		if !t.has(column) {
+			return fmt.Errorf("the file has no %q column", column)
+		}
+	}
+	return nil
+}
+
+// get is a row's value in a column, trimmed, or "" when the row or export doesn't have it
+func (t *table) get(row []string, column string) string {
+	i, ok := t.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// blank reports whether every field of a row is empty
+func blank(row []string) bool {
+	for _, field := range row {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDate reads the date formats exports use: ISO dates, and dates with / or . separators that are month first
+// unless options say day first. Dates with . separators are always day first.
+func parseDate(value string, options Options) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	if date, err := time.Parse("2.1.2006", value); err == nil {
+		return date, nil
+	}
+	layout := "1/2/2006"
+	if options.DayFirst {
+		layout = "2/1/2006"
+	}
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q isn't a date", value)
+	}
+	return date, nil
+}
+
+// parseAmount reads an amount with an optional currency symbol and thousands separators, such as "$1,234.56",
+// "(12.00)" or "1.234,56 €". Empty amounts are zero.
+func parseAmount(value string) (money.Money, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || strings.ContainsRune(".,-()", r) {
+			return r
+		}
+		return -1
+	}, value)
+	negative := false
+	if strings.HasPrefix(cleaned, "(") && strings.HasSuffix(cleaned, ")") {
+		negative, cleaned = true, strings.Trim(cleaned, "()")
+	}
+	if cleaned == "" {
+		return money.Money{}, nil
+	}
+	// The last separator followed by one or two digits is the decimal point, any other is a thousands separator
+	decimal := strings.LastIndexAny(cleaned, ".,")
+	if decimal >= 0 && len(cleaned)-decimal-1 <= 2 {
+		cleaned = strings.NewReplacer(".", "", ",", "").Replace(cleaned[:decimal]) + "." + cleaned[decimal+1:]
+	} else {
+		cleaned = strings.NewReplacer(".", "", ",", "").Replace(cleaned)
+	}
+	amount, err := money.Parse(cleaned)
+	if err != nil {
+		return money.Money{}, fmt.Errorf("%q isn't an amount", value)
+	}
+	if negative {
+		amount = amount.Neg()
+	}
+	return amount, nil
+}
+
+// rowError is a problem with one row of an export. Rows are numbered like a spreadsheet's, the header being 1.
+func rowError(index int, err error) error {
+	return fmt.Errorf("row %d: %w", index+2, err)
+}
+
+// assignIDs gives each transaction an ID from its contents and how many identical transactions came before it
+func assignIDs(source string, transactions []Transaction) {
+	seen := map[string]int{}
+	for i := range transactions {
+		t := &transactions[i]
+		key := strings.Join([]string{source, t.Account, t.Date.Format("2006-01-02"), t.Amount.String(), t.Description, t.Merchant,
+			strings.Join(t.Category, "/")}, "\x1f")
+		occurrence := seen[key]
+		seen[key]++
+		sum := sha256.Sum256([]byte(key + "\x1f" + strconv.Itoa(occurrence)))
+		t.ID = source + "-" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// Parse reads an upload from source: its register of transactions, and for YNAB optionally its budget. budget is
+// nil when the upload has none.
+func Parse(source string, register io.Reader, budget io.Reader, options Options) ([]Transaction, []Budget, error) {
+	var transactions []Transaction
+	var err error
+	switch source {
+	case SourceYNAB:
+		transactions, err = ParseYNABRegister(register, options)
+	case SourceMint:
+		if budget != nil {
+			return nil, nil, errors.New("Mint exports don't include a budget")
+		}
+		transactions, err = ParseMint(register, options)
+	default:
+		return nil, nil, fmt.Errorf("unknown import source %q", source)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("register: %w", err)
+	}
+	var budgets []Budget
+	if budget != nil {
+		if budgets, err = ParseYNABBudget(budget); err != nil {
+			return nil, nil, fmt.Errorf("budget: %w", err)
+		}
+	}
+	return transactions, budgets, nil
+}
@@ -0,0 +1,201 @@
+package importers
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"watson/money"
+)
+
+// openTestdata opens an export sample under testdata, closing it when the test ends
+func openTestdata(t *testing.T, name string) *os.File {
+	t.Helper()
+	file, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", 0},
+		{"12", 1200},
+		{"$1,234.56", 123456},
+		{"1.234,56 €", 123456},
+		{"1 234,56", 123456},
+		{"12,5", 1250},
+		{"12.5", 1250},
+		{"(12.00)", -1200},
+		{"-$45.67", -4567},
+		// Three digits after the only separator make it a thousands separator, not a decimal point
+		{"1,234", 123400},
+		{"1.234", 123400},
+		{"1,234,567", 123456700},
+		{"1.234.567,89", 123456789},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.value)
+		if err != nil {
+			t.Errorf("parseAmount(%q): %v", tt.value, err)
+			continue
+		}
+		if got.Cents() != tt.want {
+			t.Errorf("parseAmount(%q) = %d cents, want %d", tt.value, got.Cents(), tt.want)
+		}
+	}
+}
+
+func TestParseAmountRejectsNonAmounts(t *testing.T) {
+	for _, value := range []string{"-", "..", "1-2"} {
+		if got, err := parseAmount(value); err == nil {
+			t.Errorf("parseAmount(%q) = %v, want an error", value, got)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value    string
+		dayFirst bool
+		want     time.Time
+	}{
+		{"2024-02-01", false, date(2024, time.February, 1)},
+		{"2024-02-01", true, date(2024, time.February, 1)},
+		{"02/01/2024", false, date(2024, time.February, 1)},
+		{"02/01/2024", true, date(2024, time.January, 2)},
+		{"2/1/2024", false, date(2024, time.February, 1)},
+		{"2/1/2024", true, date(2024, time.January, 2)},
+		{"12/25/2024", false, date(2024, time.December, 25)},
+		{"25/12/2024", true, date(2024, time.December, 25)},
+		// Dates with . separators are day first whatever the options say
+		{"02.01.2024", false, date(2024, time.January, 2)},
+		{"02.01.2024", true, date(2024, time.January, 2)},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.value, Options{DayFirst: tt.dayFirst})
+		if err != nil {
+			t.Errorf("parseDate(%q, day first %v): %v", tt.value, tt.dayFirst, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDate(%q, day first %v) = %s, want %s", tt.value, tt.dayFirst, got.Format("2006-01-02"),
+				tt.want.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestParseDateRejectsAmbiguousOrder(t *testing.T) {
+	tests := []struct {
+		value    string
+		dayFirst bool
+	}{
+		{"25/12/2024", false},
+		{"12/25/2024", true},
+		{"yesterday", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got, err := parseDate(tt.value, Options{DayFirst: tt.dayFirst}); err == nil {
+			t.Errorf("parseDate(%q, day first %v) = %s, want an error", tt.value, tt.dayFirst, got.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestAssignIDs(t *testing.T) {
+	coffee := Transaction{Account: "Checking", Date: date(2024, time.January, 9), Amount: money.FromCents(450),
+		Description: "Blue Bottle", Merchant: "Blue Bottle", Category: []string{"Everyday Expenses", "Coffee"}}
+	rent := Transaction{Account: "Checking", Date: date(2024, time.January, 3), Amount: money.FromCents(145000),
+		Description: "Landlord", Merchant: "Landlord", Category: []string{"Monthly Bills", "Rent"}}
+
+	transactions := []Transaction{coffee, rent, coffee}
+	assignIDs(SourceYNAB, transactions)
+	for _, transaction := range transactions {
+		if !strings.HasPrefix(transaction.ID, SourceYNAB+"-") {
+			t.Errorf("ID %q doesn't start with its source", transaction.ID)
+		}
+	}
+	if transactions[0].ID == transactions[2].ID {
+		t.Error("identical transactions were given the same ID")
+	}
+
+	// Importing the same file again gives the same IDs, so nothing is stored twice
+	again := []Transaction{coffee, rent, coffee}
+	assignIDs(SourceYNAB, again)
+	for i := range transactions {
+		if again[i].ID != transactions[i].ID {
+			t.Errorf("transaction %d has ID %q on a second import, want %q", i, again[i].ID, transactions[i].ID)
+		}
+	}
+
+	// An identical transaction added later doesn't change the IDs of those before it
+	more := []Transaction{coffee, rent, coffee, coffee}
+	assignIDs(SourceYNAB, more)
+	for i := range transactions {
+		if more[i].ID != transactions[i].ID {
+			t.Errorf("transaction %d has ID %q once another was added, want %q", i, more[i].ID, transactions[i].ID)
+		}
+	}
+
+	// The same transaction from another source is a different transaction
+	mint := []Transaction{coffee}
+	assignIDs(SourceMint, mint)
+	if mint[0].ID == transactions[0].ID {
+		t.Error("a Mint transaction was given the ID of an identical YNAB one")
+	}
+}
+
+func TestReadTableErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"empty", ""},
+		{"header only", "Date,Description,Amount\n"},
+	}
+	for _, tt := range tests {
+		if _, err := readTable(strings.NewReader(tt.csv)); err == nil {
+			t.Errorf("%s: readTable returned no error", tt.name)
+		}
+	}
+	if _, err := readTable(strings.NewReader("Date,Description,Amount\n")); err != ErrNoRows {
+		t.Errorf("readTable of a header only = %v, want ErrNoRows", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	transactions, budgets, err := Parse(SourceYNAB, openTestdata(t, "ynab_register.csv"), openTestdata(t, "ynab_budget.csv"), Options{})
+	if err != nil {
+		t.Fatalf("Parse YNAB: %v", err)
+	}
+	if len(transactions) != 8 || len(budgets) != 3 {
+		t.Errorf("Parse YNAB = %d transactions and %d budgets, want 8 and 3", len(transactions), len(budgets))
+	}
+
+	transactions, budgets, err = Parse(SourceMint, openTestdata(t, "mint_transactions.csv"), nil, Options{})
+	if err != nil {
+		t.Fatalf("Parse Mint: %v", err)
+	}
+	if len(transactions) != 6 || budgets != nil {
+		t.Errorf("Parse Mint = %d transactions and %d budgets, want 6 and none", len(transactions), len(budgets))
+	}
+
+	if _, _, err := Parse(SourceMint, openTestdata(t, "mint_transactions.csv"), openTestdata(t, "ynab_budget.csv"), Options{}); err == nil {
+		t.Error("Parse of a Mint export with a budget returned no error")
+	}
+	if _, _, err := Parse("quicken", openTestdata(t, "mint_transactions.csv"), nil, Options{}); err == nil {
+		t.Error("Parse of an unknown source returned no error")
+	}
+	if _, _, err := Parse(SourceYNAB, openTestdata(t, "mint_transactions.csv"), nil, Options{}); err == nil {
+		t.Error("Parse of a Mint export as YNAB returned no error")
+	}
+}
@@ -0,0 +1,62 @@
+package importers
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseMint reads the transactions CSV Mint exported. Mint amounts are always positive, with the Transaction Type
+// column saying whether money went out (debit) or came in (credit). Mint doesn't export budgets.
+func ParseMint(r io.Reader, options Options) ([]Transaction, error) {
+	t, err := readTable(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.require("date", "description", "amount", "transaction type"); err != nil {
+		return nil, err
+	}
+	transactions := make([]Transaction, 0, len(t.rows))
+	for i, row := range t.rows {
+		if blank(row) {
+			continue
+		}
+		date, err := parseDate(t.get(row, "date"), options)
+		if err != nil {
+			return nil, rowError(i, err)
+		}
+		amount, err := parseAmount(t.get(row, "amount"))
+		if err != nil {
+			return nil, rowError(i, err)
+		}
+		switch kind := strings.ToLower(t.get(row, "transaction type")); kind {
+		case "debit":
+		case "credit":
+			amount = amount.Neg()
+		default:
+			return nil, rowError(i, fmt.Errorf("transaction type %q must be debit or credit", kind))
+		}
+		merchant := t.get(row, "description")
+		description := t.get(row, "original description")
+		if description == "" {
+			description = merchant
+		}
+		transaction := Transaction{
+			Account:     t.get(row, "account name"),
+			Date:        date,
+			Amount:      amount,
+			Description: description,
+			Merchant:    merchant,
+		}
+		if category := t.get(row, "category"); category != "" && !strings.EqualFold(category, "uncategorized") {
+			transaction.Category = []string{category}
+			transaction.categorize()
+		}
+		transactions = append(transactions, transaction)
+	}
+	if len(transactions) == 0 {
+		return nil, ErrNoRows
+	}
+	assignIDs(SourceMint, transactions)
+	return transactions, nil
+}
@@ -0,0 +1,52 @@
+package importers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMint(t *testing.T) {
+	transactions, err := ParseMint(openTestdata(t, "mint_transactions.csv"), Options{})
+	if err != nil {
+		t.Fatalf("ParseMint: %v", err)
+	}
+	// Credits are money in, and the description Mint cleaned up is the merchant
+	checkTransactions(t, transactions, []wantTransaction{
+		{"CREDIT CARD", date(2024, time.January, 15), 575, "STARBUCKS STORE 05123 SEATTLE WA", "Starbucks", "Coffee Shops", "FOOD_AND_DRINK", "FOOD_AND_DRINK_COFFEE"},
+		{"CREDIT CARD", date(2024, time.January, 14), 4108, "SHELL OIL 57442", "Shell", "Gas & Fuel", "TRANSPORTATION", "TRANSPORTATION_GAS"},
+		{"Checking", date(2024, time.January, 12), -231055, "ACME CORP PAYROLL PPD", "Payroll", "Paycheck", "INCOME", "INCOME_WAGES"},
+		{"CREDIT CARD", date(2024, time.January, 10), -120433, "ONLINE PAYMENT THANK YOU", "Credit Card Payment", "Credit Card Payment", "LOAN_PAYMENTS", "LOAN_PAYMENTS_CREDIT_CARD_PAYMENT"},
+		{"Checking", date(2024, time.January, 8), 2000, "VENMO *J SMITH", "Venmo", "", "", ""},
+		{"CREDIT CARD", date(2024, time.January, 5), 6349, "Target", "Target", "Shopping", "GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_OTHER_GENERAL_MERCHANDISE"},
+	})
+}
+
+func TestParseMintDayFirst(t *testing.T) {
+	csv := "Date,Description,Amount,Transaction Type\n03/02/2024,Tesco,12.40,debit\n"
+	transactions, err := ParseMint(strings.NewReader(csv), Options{DayFirst: true})
+	if err != nil {
+		t.Fatalf("ParseMint: %v", err)
+	}
+	if len(transactions) != 1 || !transactions[0].Date.Equal(date(2024, time.February, 3)) {
+		t.Errorf("ParseMint day first = %+v, want one transaction on 2024-02-03", transactions)
+	}
+}
+
+func TestParseMintErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want string
+	}{
+		{"missing column", "Date,Description,Amount\n1/15/2024,Starbucks,5.75\n", `no "transaction type" column`},
+		{"bad type", "Date,Description,Amount,Transaction Type\n1/15/2024,Starbucks,5.75,refund\n", `row 2: transaction type "refund"`},
+		{"bad date", "Date,Description,Amount,Transaction Type\n15/01/2024,Starbucks,5.75,debit\n", "row 2:"},
+	}
+	for _, tt := range tests {
+		_, err := ParseMint(strings.NewReader(tt.csv), Options{})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ParseMint error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package importers
+
+import "strings"
+
+// category is a Plaid personal finance category
+type category struct {
+	primary  string
+	detailed string
+}
+
+// categoryMap maps the default categories of YNAB and Mint, and the names people commonly give their own, to the
+// Plaid personal finance categories synced transactions carry. Keys are lower case.
+var categoryMap = map[string]category{
+	// Income
+	"income":                  {"INCOME", "INCOME_OTHER_INCOME"},
+	"paycheck":                {"INCOME", "INCOME_WAGES"},
+	"salary":                  {"INCOME", "INCOME_WAGES"},
+	"wages":                   {"INCOME", "INCOME_WAGES"},
+	"bonus":                   {"INCOME", "INCOME_WAGES"},
+	"interest income":         {"INCOME", "INCOME_INTEREST_EARNED"},
+	"dividends":               {"INCOME", "INCOME_DIVIDENDS"},
+	"dividend & cap gains":    {"INCOME", "INCOME_DIVIDENDS"},
+	"returned purchase":       {"INCOME", "INCOME_OTHER_INCOME"},
+	"reimbursement":           {"INCOME", "INCOME_OTHER_INCOME"},
+	"ready to assign":         {"INCOME", "INCOME_OTHER_INCOME"},
+	"to be budgeted":          {"INCOME", "INCOME_OTHER_INCOME"},
+	"inflow: ready to assign": {"INCOME", "INCOME_OTHER_INCOME"},
+	"inflow: to be budgeted":  {"INCOME", "INCOME_OTHER_INCOME"},
+	"tax refund":              {"INCOME", "INCOME_TAX_REFUND"},
+
+	// Transfers and loan payments
+	"transfer":             {"TRANSFER_OUT", "TRANSFER_OUT_ACCOUNT_TRANSFER"},
+	"savings":              {"TRANSFER_OUT", "TRANSFER_OUT_SAVINGS"},
+	"emergency fund":       {"TRANSFER_OUT", "TRANSFER_OUT_SAVINGS"},
+	"investments":          {"TRANSFER_OUT", "TRANSFER_OUT_INVESTMENT_AND_RETIREMENT_FUNDS"},
+	"credit card payment":  {"LOAN_PAYMENTS", "LOAN_PAYMENTS_CREDIT_CARD_PAYMENT"},
+	"credit card payments": {"LOAN_PAYMENTS", "LOAN_PAYMENTS_CREDIT_CARD_PAYMENT"},
+	"mortgage":             {"LOAN_PAYMENTS", "LOAN_PAYMENTS_MORTGAGE_PAYMENT"},
+	"mortgage & rent":      {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_RENT"},
+	"auto payment":         {"LOAN_PAYMENTS", "LOAN_PAYMENTS_CAR_PAYMENT"},
+	"car payment":          {"LOAN_PAYMENTS", "LOAN_PAYMENTS_CAR_PAYMENT"},
+	"student loan":         {"LOAN_PAYMENTS", "LOAN_PAYMENTS_STUDENT_LOAN_PAYMENT"},
+	"student loans":        {"LOAN_PAYMENTS", "LOAN_PAYMENTS_STUDENT_LOAN_PAYMENT"},
+	"loans":                {"LOAN_PAYMENTS", "LOAN_PAYMENTS_OTHER_PAYMENT"},
+	"loan payment":         {"LOAN_PAYMENTS", "LOAN_PAYMENTS_OTHER_PAYMENT"},
+
+	// Food and drink
+	"food & dining":  {"FOOD_AND_DRINK", "FOOD_AND_DRINK_OTHER_FOOD_AND_DRINK"},
+	"groceries":      {"FOOD_AND_DRINK", "FOOD_AND_DRINK_GROCERIES"},
+	"restaurants":    {"FOOD_AND_DRINK", "FOOD_AND_DRINK_RESTAURANT"},
+	"dining out":     {"FOOD_AND_DRINK", "FOOD_AND_DRINK_RESTAURANT"},
+	"eating out":     {"FOOD_AND_DRINK", "FOOD_AND_DRINK_RESTAURANT"},
+	"fast food":      {"FOOD_AND_DRINK", "FOOD_AND_DRINK_FAST_FOOD"},
+	"coffee shops":   {"FOOD_AND_DRINK", "FOOD_AND_DRINK_COFFEE"},
+	"coffee":         {"FOOD_AND_DRINK", "FOOD_AND_DRINK_COFFEE"},
+	"alcohol & bars": {"FOOD_AND_DRINK", "FOOD_AND_DRINK_BEER_WINE_AND_LIQUOR"},
+	"food delivery":  {"FOOD_AND_DRINK", "FOOD_AND_DRINK_RESTAURANT"},
+
+	// Housing and bills
+	"rent/mortgage":     {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_RENT"},
+	"bills & utilities": {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_OTHER_UTILITIES"},
+	"home phone":        {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_TELEPHONE"},
+	"rent":              {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_RENT"},
+	"utilities":         {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_GAS_AND_ELECTRICITY"},
+	"electric":          {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_GAS_AND_ELECTRICITY"},
+	"electricity":       {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_GAS_AND_ELECTRICITY"},
+	"gas & electric":    {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_GAS_AND_ELECTRICITY"},
+	"water":             {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_WATER"},
+	"internet":          {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_INTERNET_AND_CABLE"},
+	"internet & cable":  {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_INTERNET_AND_CABLE"},
+	"television":        {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_INTERNET_AND_CABLE"},
+	"phone":             {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_TELEPHONE"},
+	"mobile phone":      {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_TELEPHONE"},
+	"cell phone":        {"RENT_AND_UTILITIES", "RENT_AND_UTILITIES_TELEPHONE"},
+	"home improvement":  {"HOME_IMPROVEMENT", "HOME_IMPROVEMENT_HARDWARE"},
+	"home maintenance":  {"HOME_IMPROVEMENT", "HOME_IMPROVEMENT_REPAIR_AND_MAINTENANCE"},
+	"furnishings":       {"HOME_IMPROVEMENT", "HOME_IMPROVEMENT_FURNITURE"},
+	"home services":     {"GENERAL_SERVICES", "GENERAL_SERVICES_OTHER_GENERAL_SERVICES"},
+
+	// Transportation and travel
+	"gas & fuel":            {"TRANSPORTATION", "TRANSPORTATION_GAS"},
+	"gas":                   {"TRANSPORTATION", "TRANSPORTATION_GAS"},
+	"fuel":                  {"TRANSPORTATION", "TRANSPORTATION_GAS"},
+	"parking":               {"TRANSPORTATION", "TRANSPORTATION_PARKING"},
+	"public transportation": {"TRANSPORTATION", "TRANSPORTATION_PUBLIC_TRANSIT"},
+	"public transit":        {"TRANSPORTATION", "TRANSPORTATION_PUBLIC_TRANSIT"},
+	"ride share":            {"TRANSPORTATION", "TRANSPORTATION_TAXIS_AND_RIDE_SHARES"},
+	"taxi":                  {"TRANSPORTATION", "TRANSPORTATION_TAXIS_AND_RIDE_SHARES"},
+	"tolls":                 {"TRANSPORTATION", "TRANSPORTATION_TOLLS"},
+	"auto & transport":      {"TRANSPORTATION", "TRANSPORTATION_OTHER_TRANSPORTATION"},
+	"transportation":        {"TRANSPORTATION", "TRANSPORTATION_OTHER_TRANSPORTATION"},
+	"service & parts":       {"GENERAL_SERVICES", "GENERAL_SERVICES_AUTOMOTIVE"},
+	"auto maintenance":      {"GENERAL_SERVICES", "GENERAL_SERVICES_AUTOMOTIVE"},
+	"car maintenance":       {"GENERAL_SERVICES", "GENERAL_SERVICES_AUTOMOTIVE"},
+	"travel":                {"TRAVEL", "TRAVEL_OTHER_TRAVEL"},
+	"vacation":              {"TRAVEL", "TRAVEL_OTHER_TRAVEL"},
+	"air travel":            {"TRAVEL", "TRAVEL_FLIGHTS"},
+	"flights":               {"TRAVEL", "TRAVEL_FLIGHTS"},
+	"hotel":                 {"TRAVEL", "TRAVEL_LODGING"},
+	"rental car & taxi":     {"TRAVEL", "TRAVEL_RENTAL_CARS"},
+
+	// Shopping and entertainment
+	"shopping":               {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_OTHER_GENERAL_MERCHANDISE"},
+	"clothing":               {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_CLOTHING_AND_ACCESSORIES"},
+	"electronics & software": {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_ELECTRONICS"},
+	"electronics":            {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_ELECTRONICS"},
+	"books":                  {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_BOOKSTORES_AND_NEWSSTANDS"},
+	"gifts":                  {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_GIFTS_AND_NOVELTIES"},
+	"gift":                   {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_GIFTS_AND_NOVELTIES"},
+	"hobbies":                {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_OTHER_GENERAL_MERCHANDISE"},
+	"entertainment":          {"ENTERTAINMENT", "ENTERTAINMENT_OTHER_ENTERTAINMENT"},
+	"fun money":              {"ENTERTAINMENT", "ENTERTAINMENT_OTHER_ENTERTAINMENT"},
+	"movies & dvds":          {"ENTERTAINMENT", "ENTERTAINMENT_TV_AND_MOVIES"},
+	"music":                  {"ENTERTAINMENT", "ENTERTAINMENT_MUSIC_AND_AUDIO"},
+	"streaming":              {"ENTERTAINMENT", "ENTERTAINMENT_TV_AND_MOVIES"},
+	"software subscriptions": {"GENERAL_SERVICES", "GENERAL_SERVICES_OTHER_GENERAL_SERVICES"},
+	"gaming":                 {"ENTERTAINMENT", "ENTERTAINMENT_VIDEO_GAMES"},
+	"subscriptions":          {"ENTERTAINMENT", "ENTERTAINMENT_OTHER_ENTERTAINMENT"},
+	"video games":            {"ENTERTAINMENT", "ENTERTAINMENT_VIDEO_GAMES"},
+	"amusement":              {"ENTERTAINMENT", "ENTERTAINMENT_SPORTING_EVENTS_AMUSEMENT_PARKS_AND_MUSEUMS"},
+
+	// Health and personal care
+	"health & fitness": {"MEDICAL", "MEDICAL_OTHER_MEDICAL"},
+	"medical expenses": {"MEDICAL", "MEDICAL_OTHER_MEDICAL"},
+	"medical":          {"MEDICAL", "MEDICAL_OTHER_MEDICAL"},
+	"doctor":           {"MEDICAL", "MEDICAL_PRIMARY_CARE"},
+	"dentist":          {"MEDICAL", "MEDICAL_DENTAL_CARE"},
+	"eyecare":          {"MEDICAL", "MEDICAL_EYE_CARE"},
+	"pharmacy":         {"MEDICAL", "MEDICAL_PHARMACIES_AND_SUPPLEMENTS"},
+	"gym":              {"PERSONAL_CARE", "PERSONAL_CARE_GYMS_AND_FITNESS_CENTERS"},
+	"sports":           {"PERSONAL_CARE", "PERSONAL_CARE_GYMS_AND_FITNESS_CENTERS"},
+	"personal care":    {"PERSONAL_CARE", "PERSONAL_CARE_OTHER_PERSONAL_CARE"},
+	"hair":             {"PERSONAL_CARE", "PERSONAL_CARE_HAIR_AND_BEAUTY"},
+	"spa & massage":    {"PERSONAL_CARE", "PERSONAL_CARE_HAIR_AND_BEAUTY"},
+	"laundry":          {"PERSONAL_CARE", "PERSONAL_CARE_LAUNDRY_AND_DRY_CLEANING"},
+
+	// Services, fees and everything else
+	"renter's/home insurance": {"GENERAL_SERVICES", "GENERAL_SERVICES_INSURANCE"},
+	"business services":       {"GENERAL_SERVICES", "GENERAL_SERVICES_OTHER_GENERAL_SERVICES"},
+	"interest & fees":         {"BANK_FEES", "BANK_FEES_INTEREST_CHARGE"},
+	"gifts & donations":       {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_DONATIONS"},
+	"insurance":               {"GENERAL_SERVICES", "GENERAL_SERVICES_INSURANCE"},
+	"auto insurance":          {"GENERAL_SERVICES", "GENERAL_SERVICES_INSURANCE"},
+	"health insurance":        {"GENERAL_SERVICES", "GENERAL_SERVICES_INSURANCE"},
+	"life insurance":          {"GENERAL_SERVICES", "GENERAL_SERVICES_INSURANCE"},
+	"education":               {"GENERAL_SERVICES", "GENERAL_SERVICES_EDUCATION"},
+	"tuition":                 {"GENERAL_SERVICES", "GENERAL_SERVICES_EDUCATION"},
+	"childcare":               {"GENERAL_SERVICES", "GENERAL_SERVICES_CHILDCARE"},
+	"kids":                    {"GENERAL_SERVICES", "GENERAL_SERVICES_CHILDCARE"},
+	"pets":                    {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_PET_SUPPLIES"},
+	"pet food & supplies":     {"GENERAL_MERCHANDISE", "GENERAL_MERCHANDISE_PET_SUPPLIES"},
+	"veterinary":              {"MEDICAL", "MEDICAL_VETERINARY_SERVICES"},
+	"charity":                 {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_DONATIONS"},
+	"charitable giving":       {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_DONATIONS"},
+	"donations":               {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_DONATIONS"},
+	"giving":                  {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_DONATIONS"},
+	"taxes":                   {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_TAX_PAYMENT"},
+	"federal tax":             {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_TAX_PAYMENT"},
+	"state tax":               {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_TAX_PAYMENT"},
+	"property tax":            {"GOVERNMENT_AND_NON_PROFIT", "GOVERNMENT_AND_NON_PROFIT_TAX_PAYMENT"},
+	"fees & charges":          {"BANK_FEES", "BANK_FEES_OTHER_BANK_FEES"},
+	"bank fee":                {"BANK_FEES", "BANK_FEES_OTHER_BANK_FEES"},
+	"bank fees":               {"BANK_FEES", "BANK_FEES_OTHER_BANK_FEES"},
+	"atm fee":                 {"BANK_FEES", "BANK_FEES_ATM_FEES"},
+	"late fee":                {"BANK_FEES", "BANK_FEES_LATE_PAYMENT"},
+	"finance charge":          {"BANK_FEES", "BANK_FEES_INTEREST_CHARGE"},
+	"interest charges":        {"BANK_FEES", "BANK_FEES_INTEREST_CHARGE"},
+	"cash & atm":              {"TRANSFER_OUT", "TRANSFER_OUT_WITHDRAWAL"},
+	"atm":                     {"TRANSFER_OUT", "TRANSFER_OUT_WITHDRAWAL"},
+}
+
+// MapCategory is the Plaid personal finance category for another app's category, ok being false when there isn't
+// an obvious one. The category is tried first, then its group, so "Bills: Rent" and "Rent" map alike.
+func MapCategory(categoryPath ...string) (primary string, detailed string, ok bool) {
+	for i := len(categoryPath) - 1; i >= 0; i-- {
+		if mapped, found := categoryMap[strings.ToLower(strings.TrimSpace(categoryPath[i]))]; found {
+			return mapped.primary, mapped.detailed, true
+		}
+	}
+	return "", "", false
+}
+
+// BudgetCategory is the budget category name for another app's category: the Plaid primary category it maps to,
+// written like "food and drink" so budgets match synced transactions, or the category's own name when it doesn't
+// map to one. It is "" for income and transfers, which aren't budgeted as spending.
+func BudgetCategory(categoryPath ...string) string {
+	primary, _, ok := MapCategory(categoryPath...)
+	if !ok {
+		if len(categoryPath) == 0 {
+			return ""
+		}
+		return strings.TrimSpace(categoryPath[len(categoryPath)-1])
+	}
+	if primary == "INCOME" || strings.HasPrefix(primary, "TRANSFER_") {
+		return ""
+	}
+	return strings.ToLower(strings.ReplaceAll(primary, "_", " "))
+}
+
+// categorize sets a transaction's personal finance category from its category. Transfer categories name money
+// going out, so money coming in gets the matching transfer in category.
+func (t *Transaction) categorize() {
+	primary, detailed, ok := MapCategory(t.Category...)
+	if !ok {
+		return
+	}
+	if primary == "TRANSFER_OUT" && t.Amount.IsNegative() {
+		primary = "TRANSFER_IN"
+		detailed = strings.Replace(detailed, "TRANSFER_OUT_", "TRANSFER_IN_", 1)
+		if detailed == "TRANSFER_IN_WITHDRAWAL" {
+			detailed = "TRANSFER_IN_DEPOSIT"
+		}
+	}
+	t.PFCPrimary, t.PFCDetailed = primary, detailed
+}
@@ -0,0 +1,140 @@
+package importers
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ynabTransferPrefix starts the payee of a YNAB transfer between two of the user's accounts
+const ynabTransferPrefix = "Transfer : "
+
+// ynabSkippedGroups are YNAB category groups that hold money moving between categories rather than spending
+var ynabSkippedGroups = map[string]bool{
+	"inflow":                   true,
+	"internal master category": true,
+}
+
+// ynabCategory is a YNAB row's category group and category. Current exports have Category Group and Category
+// columns; YNAB 4 exports have Master Category and Sub Category, with Category holding both.
+func ynabCategory(t *table, row []string) (string, string) {
+	if t.has("sub category") {
+		return t.get(row, "master category"), t.get(row, "sub category")
+	}
+	group, category := t.get(row, "category group"), t.get(row, "category")
+	if group == "" && t.has("category group/category") {
+		if combined := t.get(row, "category group/category"); combined != "" {
+			group, category, _ = strings.Cut(combined, ": ")
+		}
+	}
+	return group, category
+}
+
+// categoryPath is a category with its group first, when it has one
+func categoryPath(group string, category string) []string {
+	if group == "" {
+		return []string{category}
+	}
+	return []string{group, category}
+}
+
+// ParseYNABRegister reads the register CSV of a YNAB export, the current app's or YNAB 4's. Transfers between the
+// user's accounts, which YNAB leaves uncategorized, are categorized as transfers.
+func ParseYNABRegister(r io.Reader, options Options) ([]Transaction, error) {
+	t, err := readTable(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.require("account", "date", "payee", "outflow", "inflow"); err != nil {
+		return nil, err
+	}
+	transactions := make([]Transaction, 0, len(t.rows))
+	for i, row := range t.rows {
+		if blank(row) {
+			continue
+		}
+		date, err := parseDate(t.get(row, "date"), options)
+		if err != nil {
+			return nil, rowError(i, err)
+		}
+		outflow, err := parseAmount(t.get(row, "outflow"))
+		if err != nil {
+			return nil, rowError(i, err)
+		}
+		inflow, err := parseAmount(t.get(row, "inflow"))
+		if err != nil {
+			return nil, rowError(i, err)
+		}
+		payee := t.get(row, "payee")
+		transaction := Transaction{
+			Account:     t.get(row, "account"),
+			Date:        date,
+			Amount:      outflow.Sub(inflow),
+			Description: payee,
+			Merchant:    payee,
+		}
+		group, category := ynabCategory(t, row)
+		switch {
+		case category != "":
+			transaction.Category = categoryPath(group, category)
+			transaction.categorize()
+		case strings.HasPrefix(payee, ynabTransferPrefix):
+			transaction.Merchant = ""
+			transaction.Category = []string{"Transfer"}
+			transaction.categorize()
+		}
+		transactions = append(transactions, transaction)
+	}
+	if len(transactions) == 0 {
+		return nil, ErrNoRows
+	}
+	assignIDs(SourceYNAB, transactions)
+	return transactions, nil
+}
+
+// ParseYNABBudget reads the budget CSV of a YNAB export: what was budgeted, or assigned, to each category every
+// month. Categories holding income waiting to be budgeted and months with nothing budgeted are left out.
+func ParseYNABBudget(r io.Reader) ([]Budget, error) {
+	t, err := readTable(r)
+	if err != nil {
+		return nil, err
+	}
+	amountColumn := "budgeted"
+	if !t.has(amountColumn) {
+		amountColumn = "assigned"
+	}
+	if err := t.require("month", amountColumn); err != nil {
+		return nil, err
+	}
+	budgets := []Budget{}
+	for i, row := range t.rows {
+		if blank(row) {
+			continue
+		}
+		month, err := parseMonth(t.get(row, "month"))
+		if err != nil {
+			return nil, rowError(i, err)
+		}
+		amount, err := parseAmount(t.get(row, amountColumn))
+		if err != nil {
+			return nil, rowError(i, err)
+		}
+		group, category := ynabCategory(t, row)
+		if category == "" || ynabSkippedGroups[strings.ToLower(group)] || !amount.IsPositive() {
+			continue
+		}
+		budgets = append(budgets, Budget{Month: month, Category: categoryPath(group, category), Amount: amount})
+	}
+	return budgets, nil
+}
+
+// parseMonth reads a YNAB budget month, such as "Jan 2024" or "January 2024"
+func parseMonth(value string) (time.Time, error) {
+	for _, layout := range []string{"Jan 2006", "January 2006", "2006-01", "01/2006"} {
+		if month, err := time.Parse(layout, value); err == nil {
+			return month, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a month", value)
+}
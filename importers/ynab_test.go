@@ -0,0 +1,193 @@
+package importers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// wantTransaction is what a test expects of a parsed transaction, leaving out its ID
+type wantTransaction struct {
+	account     string
+	date        time.Time
+	cents       int64
+	description string
+	merchant    string
+	category    string
+	pfcPrimary  string
+	pfcDetailed string
+}
+
+func checkTransactions(t *testing.T, got []Transaction, want []wantTransaction) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d transactions, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Account != w.account || !g.Date.Equal(w.date) || g.Amount.Cents() != w.cents || g.Description != w.description ||
+			g.Merchant != w.merchant || strings.Join(g.Category, "/") != w.category || g.PFCPrimary != w.pfcPrimary ||
+			g.PFCDetailed != w.pfcDetailed {
+			t.Errorf("transaction %d = {%s %s %d %q %q %q %s %s}, want {%s %s %d %q %q %q %s %s}", i,
+				g.Account, g.Date.Format("2006-01-02"), g.Amount.Cents(), g.Description, g.Merchant, strings.Join(g.Category, "/"), g.PFCPrimary, g.PFCDetailed,
+				w.account, w.date.Format("2006-01-02"), w.cents, w.description, w.merchant, w.category, w.pfcPrimary, w.pfcDetailed)
+		}
+		if g.ID == "" {
+			t.Errorf("transaction %d has no ID", i)
+		}
+	}
+}
+
+func TestParseYNABRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		options Options
+		want    []wantTransaction
+	}{
+		{
+			name: "current",
+			file: "ynab_register.csv",
+			want: []wantTransaction{
+				{"Checking", date(2024, time.January, 2), 8412, "Whole Foods", "Whole Foods", "Everyday Expenses/Groceries", "FOOD_AND_DRINK", "FOOD_AND_DRINK_GROCERIES"},
+				{"Checking", date(2024, time.January, 3), 145000, "Landlord", "Landlord", "Monthly Bills/Rent", "RENT_AND_UTILITIES", "RENT_AND_UTILITIES_RENT"},
+				{"Checking", date(2024, time.January, 5), -231055, "Acme Corp", "Acme Corp", "Inflow/Ready to Assign", "INCOME", "INCOME_OTHER_INCOME"},
+				{"Checking", date(2024, time.January, 6), 50000, "Transfer : Savings", "", "Transfer", "TRANSFER_OUT", "TRANSFER_OUT_ACCOUNT_TRANSFER"},
+				{"Savings", date(2024, time.January, 6), -50000, "Transfer : Checking", "", "Transfer", "TRANSFER_IN", "TRANSFER_IN_ACCOUNT_TRANSFER"},
+				{"Checking", date(2024, time.January, 9), 450, "Blue Bottle", "Blue Bottle", "Everyday Expenses/Coffee", "FOOD_AND_DRINK", "FOOD_AND_DRINK_COFFEE"},
+				{"Checking", date(2024, time.January, 9), 450, "Blue Bottle", "Blue Bottle", "Everyday Expenses/Coffee", "FOOD_AND_DRINK", "FOOD_AND_DRINK_COFFEE"},
+				{"Checking", date(2024, time.January, 10), 1200, "Corner Store", "Corner Store", "Everyday Expenses/Odds and Ends", "", ""},
+			},
+		},
+		{
+			name:    "YNAB 4",
+			file:    "ynab4_register.csv",
+			options: Options{DayFirst: true},
+			want: []wantTransaction{
+				{"Girokonto", date(2024, time.January, 2), 4530, "Rewe", "Rewe", "Everyday Expenses/Groceries", "FOOD_AND_DRINK", "FOOD_AND_DRINK_GROCERIES"},
+				{"Girokonto", date(2024, time.January, 3), 120000, "Vermieter", "Vermieter", "Monthly Bills/Rent", "RENT_AND_UTILITIES", "RENT_AND_UTILITIES_RENT"},
+				{"Girokonto", date(2024, time.January, 25), -315000, "Arbeitgeber GmbH", "Arbeitgeber GmbH", "Income/Available this month", "INCOME", "INCOME_OTHER_INCOME"},
+				{"Girokonto", date(2024, time.January, 28), 25000, "Transfer : Tagesgeld", "", "Transfer", "TRANSFER_OUT", "TRANSFER_OUT_ACCOUNT_TRANSFER"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transactions, err := ParseYNABRegister(openTestdata(t, tt.file), tt.options)
+			if err != nil {
+				t.Fatalf("ParseYNABRegister: %v", err)
+			}
+			checkTransactions(t, transactions, tt.want)
+		})
+	}
+}
+
+func TestParseYNABRegisterCombinedCategory(t *testing.T) {
+	// Some exports only have the combined column
+	csv := "Account,Date,Payee,Category Group/Category,Outflow,Inflow\nChecking,2024-03-01,Landlord,Monthly Bills: Rent,$900.00,\n"
+	transactions, err := ParseYNABRegister(strings.NewReader(csv), Options{})
+	if err != nil {
+		t.Fatalf("ParseYNABRegister: %v", err)
+	}
+	checkTransactions(t, transactions, []wantTransaction{
+		{"Checking", date(2024, time.March, 1), 90000, "Landlord", "Landlord", "Monthly Bills/Rent", "RENT_AND_UTILITIES", "RENT_AND_UTILITIES_RENT"},
+	})
+}
+
+func TestParseYNABRegisterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		options Options
+		want    string
+	}{
+		{"missing column", "Account,Date,Payee,Outflow\nChecking,2024-03-01,Landlord,$900.00\n", Options{}, `no "inflow" column`},
+		{"bad date", "Account,Date,Payee,Outflow,Inflow\nChecking,2024-03-01,Landlord,$900.00,\nChecking,13/01/2024,Landlord,$900.00,\n", Options{}, "row 3:"},
+		{"bad amount", "Account,Date,Payee,Outflow,Inflow\nChecking,2024-03-01,Landlord,$9-00,\n", Options{}, "row 2:"},
+		{"blank rows only", "Account,Date,Payee,Outflow,Inflow\n,,,,\n", Options{}, ErrNoRows.Error()},
+	}
+	for _, tt := range tests {
+		_, err := ParseYNABRegister(strings.NewReader(tt.csv), tt.options)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ParseYNABRegister error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestParseYNABRegisterIDsAreStable(t *testing.T) {
+	first, err := ParseYNABRegister(openTestdata(t, "ynab_register.csv"), Options{})
+	if err != nil {
+		t.Fatalf("ParseYNABRegister: %v", err)
+	}
+	second, err := ParseYNABRegister(openTestdata(t, "ynab_register.csv"), Options{})
+	if err != nil {
+		t.Fatalf("ParseYNABRegister: %v", err)
+	}
+	ids := map[string]bool{}
+	for i := range first {
+		if first[i].ID != second[i].ID {
+			t.Errorf("transaction %d has ID %q on a second import, want %q", i, second[i].ID, first[i].ID)
+		}
+		if ids[first[i].ID] {
+			t.Errorf("transaction %d has the ID %q of an earlier one", i, first[i].ID)
+		}
+		ids[first[i].ID] = true
+	}
+}
+
+func TestParseYNABBudget(t *testing.T) {
+	budgets, err := ParseYNABBudget(openTestdata(t, "ynab_budget.csv"))
+	if err != nil {
+		t.Fatalf("ParseYNABBudget: %v", err)
+	}
+	// Ready to Assign and categories with nothing assigned are left out
+	want := []struct {
+		month    time.Time
+		category string
+		cents    int64
+	}{
+		{date(2024, time.January, 1), "Monthly Bills/Rent", 145000},
+		{date(2024, time.January, 1), "Everyday Expenses/Groceries", 40000},
+		{date(2024, time.February, 1), "Everyday Expenses/Groceries", 42500},
+	}
+	if len(budgets) != len(want) {
+		t.Fatalf("got %d budgets, want %d", len(budgets), len(want))
+	}
+	for i, w := range want {
+		g := budgets[i]
+		if !g.Month.Equal(w.month) || strings.Join(g.Category, "/") != w.category || g.Amount.Cents() != w.cents {
+			t.Errorf("budget %d = {%s %q %d}, want {%s %q %d}", i, g.Month.Format("2006-01"), strings.Join(g.Category, "/"),
+				g.Amount.Cents(), w.month.Format("2006-01"), w.category, w.cents)
+		}
+	}
+}
+
+func TestParseYNABBudgetYNAB4(t *testing.T) {
+	csv := "Month,Category,Master Category,Sub Category,Budgeted,Outflows,Category Balance\n" +
+		"January 2024,Monthly Bills: Rent,Monthly Bills,Rent,\"1.200,00€\",\"-1.200,00€\",\"0,00€\"\n" +
+		"January 2024,Hidden Categories: Old,Internal Master Category,Old,\"5,00€\",\"0,00€\",\"5,00€\"\n"
+	budgets, err := ParseYNABBudget(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("ParseYNABBudget: %v", err)
+	}
+	if len(budgets) != 1 || strings.Join(budgets[0].Category, "/") != "Monthly Bills/Rent" || budgets[0].Amount.Cents() != 120000 ||
+		!budgets[0].Month.Equal(date(2024, time.January, 1)) {
+		t.Errorf("ParseYNABBudget = %+v, want only January's rent of 1200.00", budgets)
+	}
+}
+
+func TestParseMonth(t *testing.T) {
+	for _, value := range []string{"Mar 2024", "March 2024", "2024-03", "03/2024"} {
+		got, err := parseMonth(value)
+		if err != nil {
+			t.Errorf("parseMonth(%q): %v", value, err)
+			continue
+		}
+		if !got.Equal(date(2024, time.March, 1)) {
+			t.Errorf("parseMonth(%q) = %s, want 2024-03", value, got.Format("2006-01"))
+		}
+	}
+	if _, err := parseMonth("2024"); err == nil {
+		t.Error("parseMonth of a year returned no error")
+	}
+}
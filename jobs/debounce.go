@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"watson/redisconn"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Debouncing coalesces requests for the same job made within a short window into one job. Whoever claims the
+// debounce key with SETNX enqueues the job and records its ID under the key; everyone else gets that ID back
+// until the key expires or the job starts running. The API and the worker share the keys, so a refresh tapped in the app and a sync that
+// just finished don't both queue a recompute.
+
+// DailyBalanceDebounceWindow is how long a process_daily_balance job for a user and month absorbs further
+// requests for the same
+const DailyBalanceDebounceWindow = 30 * time.Second
+
+// debouncePendingID holds a debounce key while the job it guards is being enqueued and its ID isn't known yet
+const debouncePendingID = "pending"
+
+// DailyBalanceDebounceKey is the debounce key of process_daily_balance for a user and month
+func DailyBalanceDebounceKey(userID int, monthYear int) string {
+	return redisconn.Key(fmt.Sprintf("debounce:process_daily_balance:%d:%d", userID, monthYear))
+}
+
+// ClaimDebounce claims key for window. When someone else holds it, it returns false with the ID of the job they
+// enqueued, which is empty while they are still enqueueing it.
+func ClaimDebounce(ctx context.Context, rdb redis.Cmdable, key string, window time.Duration) (bool, string, error) {
+	claimed, err := rdb.SetNX(ctx, key, debouncePendingID, window).Result()
+	if err != nil {
+		return false, "", fmt.Errorf("failed to claim debounce key: %w", err)
+	}
+	if claimed {
+		return true, "", nil
+	}
+	jobID, err := rdb.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		// Expired between the two calls, so the job it guarded is already running; try again
+		return ClaimDebounce(ctx, rdb, key, window)
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read debounce key: %w", err)
+	}
+	if jobID == debouncePendingID {
+		jobID = ""
+	}
+	return false, jobID, nil
+}
+
+// RecordDebouncedJob stores the ID of the job enqueued under a claimed key, keeping the key's expiry
+func RecordDebouncedJob(ctx context.Context, rdb redis.Cmdable, key string, jobID string) error {
+	if err := rdb.SetXX(ctx, key, jobID, redis.KeepTTL).Err(); err != nil {
+		return fmt.Errorf("failed to record debounced job: %w", err)
+	}
+	return nil
+}
+
+// ReleaseDebounce gives up a claimed key whose job couldn't be enqueued, so the next request tries again
+func ReleaseDebounce(ctx context.Context, rdb redis.Cmdable, key string) error {
+	return rdb.Del(ctx, key).Err()
+}
+
+// releaseDebouncedJob deletes a debounce key only while it holds the given job ID, or is still pending
+var releaseDebouncedJob = redis.NewScript(`
+local held = redis.call("GET", KEYS[1])
+if held == ARGV[1] or held == ARGV[2] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ReleaseDebouncedJob frees the key a debounced job was enqueued under once the job starts running. Requests
+// made after then need a job of their own, as the running one may have read the data before they changed it.
+// A key still pending is freed too, since the job can start before its ID is recorded; at worst that queues one
+// extra job. A key another job has claimed since is left alone.
+func ReleaseDebouncedJob(ctx context.Context, rdb redis.Scripter, key string, jobID string) error {
+	if err := releaseDebouncedJob.Run(ctx, rdb, []string{key}, jobID, debouncePendingID).Err(); err != nil {
+		return fmt.Errorf("failed to release debounce key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClaimDebounce(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := context.Background()
+	key := DailyBalanceDebounceKey(7, 202506)
+
+	claimed, _, err := ClaimDebounce(ctx, rdb, key, DailyBalanceDebounceWindow)
+	if err != nil || !claimed {
+		t.Fatalf("first ClaimDebounce = %v, %v; want claimed", claimed, err)
+	}
+	claimed, jobID, err := ClaimDebounce(ctx, rdb, key, DailyBalanceDebounceWindow)
+	if err != nil || claimed || jobID != "" {
+		t.Fatalf("ClaimDebounce while pending = %v, %q, %v; want unclaimed with no job yet", claimed, jobID, err)
+	}
+	if err := RecordDebouncedJob(ctx, rdb, key, "job_1"); err != nil {
+		t.Fatalf("RecordDebouncedJob: %v", err)
+	}
+	claimed, jobID, err = ClaimDebounce(ctx, rdb, key, DailyBalanceDebounceWindow)
+	if err != nil || claimed || jobID != "job_1" {
+		t.Fatalf("ClaimDebounce once recorded = %v, %q, %v; want unclaimed with job_1", claimed, jobID, err)
+	}
+
+	if err := ReleaseDebounce(ctx, rdb, key); err != nil {
+		t.Fatalf("ReleaseDebounce: %v", err)
+	}
+	if claimed, _, err := ClaimDebounce(ctx, rdb, key, DailyBalanceDebounceWindow); err != nil || !claimed {
+		t.Errorf("ClaimDebounce after release = %v, %v; want claimed", claimed, err)
+	}
+}
+
+func TestClaimDebounceExpires(t *testing.T) {
+	server, rdb := newTestClient(t)
+	ctx := context.Background()
+	key := DailyBalanceDebounceKey(7, 202506)
+
+	if claimed, _, err := ClaimDebounce(ctx, rdb, key, DailyBalanceDebounceWindow); err != nil || !claimed {
+		t.Fatalf("ClaimDebounce = %v, %v; want claimed", claimed, err)
+	}
+	server.FastForward(DailyBalanceDebounceWindow)
+	if claimed, _, err := ClaimDebounce(ctx, rdb, key, DailyBalanceDebounceWindow); err != nil || !claimed {
+		t.Errorf("ClaimDebounce after the window = %v, %v; want claimed", claimed, err)
+	}
+}
+
+func TestReleaseDebouncedJob(t *testing.T) {
+	tests := []struct {
+		name         string
+		held         string
+		wantReleased bool
+	}{
+		{"held by the running job", "job_1", true},
+		{"still pending", debouncePendingID, true},
+		{"claimed by a newer job", "job_2", false},
+	}
+	for _, tt := range tests {
+		server, rdb := newTestClient(t)
+		ctx := context.Background()
+		key := DailyBalanceDebounceKey(7, 202506)
+		server.Set(key, tt.held)
+
+		if err := ReleaseDebouncedJob(ctx, rdb, key, "job_1"); err != nil {
+			t.Fatalf("%s: ReleaseDebouncedJob: %v", tt.name, err)
+		}
+		if released := !server.Exists(key); released != tt.wantReleased {
+			t.Errorf("%s: released = %v, want %v", tt.name, released, tt.wantReleased)
+		}
+	}
+	// Nothing to release once the key has expired
+	_, rdb := newTestClient(t)
+	if err := ReleaseDebouncedJob(context.Background(), rdb, DailyBalanceDebounceKey(7, 202506), "job_1"); err != nil {
+		t.Errorf("ReleaseDebouncedJob of a missing key: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"watson/redisconn"
+	"watson/telemetry"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// QueueKey is the Redis list jobs are queued on, namespaced with redisconn.Key. Jobs are pushed on the left
+// and popped from the right, so they run oldest first.
+const QueueKey = "job_queue"
+
+// Job is a task queued for the background worker
+type Job struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	// TraceContext carries the W3C trace headers of whoever enqueued the job so its spans join the same trace
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	// Encoding and DataRef describe data stored compressed or in the blob store by the worker
+	Encoding string `json:"encoding,omitempty"`
+	DataRef  string `json:"data_ref,omitempty"`
+	// WorkflowID is set on the steps of a workflow, whose completion job runs once every step has finished
+	WorkflowID string `json:"workflow_id,omitempty"`
+	// Attempts is how many times the job has been retried, see Retry
+	Attempts int `json:"attempts,omitempty"`
+
+	ctx context.Context
+}
+
+// New builds a job carrying the trace context of the caller
+func New(parent context.Context, jobType string, data json.RawMessage) Job {
+	return Job{
+		ID:           fmt.Sprintf("job_%d", time.Now().UnixNano()),
+		Type:         jobType,
+		Data:         data,
+		CreatedAt:    time.Now(),
+		TraceContext: telemetry.InjectMap(parent),
+	}
+}
+
+// Context returns the context a job is being processed under, carrying its trace span and timeout.
+// Handlers pass it to HTTP and database calls so a job past its timeout stops instead of hanging its worker.
+func (job *Job) Context() context.Context {
+	if job.ctx != nil {
+		return job.ctx
+	}
+	return context.Background()
+}
+
+// SetContext sets the context the job is processed under
+func (job *Job) SetContext(ctx context.Context) {
+	job.ctx = ctx
+}
+
+// EnqueueRequest is the body of the worker's /enqueue endpoint. Data too large to send inline can be
+// uploaded to the blob store first and referenced by its key in DataRef instead.
+type EnqueueRequest struct {
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+	DataRef string          `json:"data_ref"`
+}
+
+// MarshalEnqueueRequest builds the body of an enqueue request for data of any JSON encodable type
+func MarshalEnqueueRequest(jobType string, data interface{}) ([]byte, error) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job data: %w", err)
+	}
+	return json.Marshal(EnqueueRequest{Type: jobType, Data: dataJSON})
+}
+
+// EnqueueResponse is the worker's answer to an enqueue request
+type EnqueueResponse struct {
+	Success bool   `json:"success"`
+	JobID   string `json:"job_id,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Client is the part of the Redis client the queue needs. redis.UniversalClient satisfies it, and so does a
+// client pointed at an in-memory server, which lets the queue be exercised without a real Redis.
+type Client interface {
+	LPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
+	BRPop(ctx context.Context, timeout time.Duration, keys ...string) *redis.StringSliceCmd
+	ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd
+	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
+	ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+}
+
+// Encode serializes a job the way it is stored on the queue
+func Encode(job *Job) ([]byte, error) {
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job: %w", err)
+	}
+	return jobJSON, nil
+}
+
+// Decode reads a job stored by Encode
+func Decode(jobJSON []byte) (*Job, error) {
+	var job Job
+	if err := json.Unmarshal(jobJSON, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	}
+	return &job, nil
+}
+
+// Enqueue adds a job to the queue
+func Enqueue(ctx context.Context, rdb Client, job *Job) error {
+	jobJSON, err := Encode(job)
+	if err != nil {
+		return err
+	}
+	if err := rdb.LPush(ctx, redisconn.Key(QueueKey), jobJSON).Err(); err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+// Dequeue removes and returns the oldest job on the queue, waiting up to timeout for one to arrive.
+// It returns nil without an error when none did.
+func Dequeue(ctx context.Context, rdb Client, timeout time.Duration) (*Job, error) {
+	result, err := rdb.BRPop(ctx, timeout, redisconn.Key(QueueKey)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to dequeue job: %w", err)
+	}
+	// result[0] is the key name, result[1] is the value
+	if len(result) < 2 {
+		return nil, fmt.Errorf("unexpected result format")
+	}
+	return Decode([]byte(result[1]))
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestClient starts an in-memory Redis for the test and returns a client for it
+func newTestClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return server, rdb
+}
+
+func TestEncodeDecode(t *testing.T) {
+	job := Job{
+		ID:           "job_1",
+		Type:         "fetch_transactions",
+		Data:         json.RawMessage(`{"user_id":7}`),
+		CreatedAt:    time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+		TraceContext: map[string]string{"traceparent": "00-abc-def-01"},
+		WorkflowID:   "wf_1",
+		Attempts:     2,
+	}
+	jobJSON, err := Encode(&job)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := Decode(jobJSON)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if decoded.ID != job.ID || decoded.Type != job.Type || string(decoded.Data) != string(job.Data) ||
+		!decoded.CreatedAt.Equal(job.CreatedAt) || decoded.TraceContext["traceparent"] != "00-abc-def-01" ||
+		decoded.WorkflowID != job.WorkflowID || decoded.Attempts != job.Attempts {
+		t.Errorf("Decode(Encode(job)) = %+v, want %+v", decoded, job)
+	}
+
+	if _, err := Decode([]byte("not json")); err == nil {
+		t.Error("Decode of invalid JSON returned no error")
+	}
+}
+
+func TestEnqueueDequeueOldestFirst(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := context.Background()
+
+	for _, jobType := range []string{"first", "second", "third"} {
+		job := New(ctx, jobType, json.RawMessage(`{}`))
+		if err := Enqueue(ctx, rdb, &job); err != nil {
+			t.Fatalf("Enqueue(%s): %v", jobType, err)
+		}
+	}
+	for _, want := range []string{"first", "second", "third"} {
+		job, err := Dequeue(ctx, rdb, time.Second)
+		if err != nil {
+			t.Fatalf("Dequeue: %v", err)
+		}
+		if job == nil || job.Type != want {
+			t.Fatalf("Dequeue = %+v, want a %s job", job, want)
+		}
+	}
+}
+
+func TestDequeueEmptyQueue(t *testing.T) {
+	_, rdb := newTestClient(t)
+
+	job, err := Dequeue(context.Background(), rdb, time.Second)
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if job != nil {
+		t.Errorf("Dequeue of an empty queue = %+v, want nil", job)
+	}
+}
+
+func TestEnqueueUnreachableRedis(t *testing.T) {
+	server, rdb := newTestClient(t)
+	server.Close()
+
+	job := New(context.Background(), "fetch_transactions", json.RawMessage(`{}`))
+	if err := Enqueue(context.Background(), rdb, &job); err == nil {
+		t.Error("Enqueue with Redis down returned no error")
+	}
+}
@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"watson/redisconn"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A failed job can be retried a few times with a growing delay before it is given up on and dead lettered. Jobs
+// waiting to be retried sit in the RetryKey sorted set, scored by the Unix time they are due, until
+// PromoteDueRetries moves them back onto the queue.
+
+// RetryKey is the sorted set of jobs waiting to be retried, namespaced with redisconn.Key
+const RetryKey = QueueKey + ":retry"
+
+// MaxAttempts is how many times a job runs, its first attempt included, before it is dead lettered
+const MaxAttempts = 3
+
+// retryBaseDelay is the wait before a job's first retry, doubled for each retry after it
+const retryBaseDelay = 30 * time.Second
+
+// RetryDelay is how long a job waits before its nth retry
+func RetryDelay(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+	return retryBaseDelay << (retry - 1)
+}
+
+// Retry schedules a failed job to run again after RetryDelay, counting the attempt on job. It returns false
+// without scheduling anything once the job has run MaxAttempts times, when the caller should dead letter it.
+func Retry(ctx context.Context, rdb Client, job *Job, now time.Time) (bool, error) {
+	if job.Attempts+1 >= MaxAttempts {
+		return false, nil
+	}
+	retry := *job
+	retry.Attempts++
+	jobJSON, err := Encode(&retry)
+	if err != nil {
+		return false, err
+	}
+	due := now.Add(RetryDelay(retry.Attempts))
+	if err := rdb.ZAdd(ctx, redisconn.Key(RetryKey), redis.Z{Score: float64(due.Unix()), Member: jobJSON}).Err(); err != nil {
+		return false, fmt.Errorf("failed to schedule job retry: %w", err)
+	}
+	job.Attempts = retry.Attempts
+	return true, nil
+}
+
+// PromoteDueRetries moves the retries due by now onto the queue and returns how many it moved. Only whoever
+// removes a retry from the set queues it, so replicas promoting at the same time never run one twice.
+func PromoteDueRetries(ctx context.Context, rdb Client, now time.Time) (int, error) {
+	due, err := rdb.ZRangeByScore(ctx, redisconn.Key(RetryKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read due job retries: %w", err)
+	}
+	promoted := 0
+	for _, jobJSON := range due {
+		removed, err := rdb.ZRem(ctx, redisconn.Key(RetryKey), jobJSON).Result()
+		if err != nil {
+			return promoted, fmt.Errorf("failed to take job retry: %w", err)
+		}
+		if removed == 0 {
+			continue
+		}
+		if err := rdb.LPush(ctx, redisconn.Key(QueueKey), jobJSON).Err(); err != nil {
+			// Put it back so the next promotion tries again rather than losing the job
+			rdb.ZAdd(ctx, redisconn.Key(RetryKey), redis.Z{Score: float64(now.Unix()), Member: jobJSON})
+			return promoted, fmt.Errorf("failed to queue job retry: %w", err)
+		}
+		promoted++
+	}
+	return promoted, nil
+}
@@ -0,0 +1,123 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+	"watson/redisconn"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := RetryDelay(tt.retry); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+}
+
+func TestRetryIsQueuedOnceDue(t *testing.T) {
+	server, rdb := newTestClient(t)
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	job := New(ctx, "fetch_transactions", json.RawMessage(`{"user_id":7}`))
+	retried, err := Retry(ctx, rdb, &job, now)
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if !retried || job.Attempts != 1 {
+		t.Fatalf("Retry = %v with %d attempts, want true with 1", retried, job.Attempts)
+	}
+	if members, _ := server.ZMembers(redisconn.Key(RetryKey)); len(members) != 1 {
+		t.Fatalf("retry set has %d members, want 1", len(members))
+	}
+
+	promoted, err := PromoteDueRetries(ctx, rdb, now.Add(RetryDelay(1)-time.Second))
+	if err != nil {
+		t.Fatalf("PromoteDueRetries: %v", err)
+	}
+	if promoted != 0 {
+		t.Fatalf("PromoteDueRetries before the retry is due moved %d jobs, want 0", promoted)
+	}
+
+	promoted, err = PromoteDueRetries(ctx, rdb, now.Add(RetryDelay(1)))
+	if err != nil {
+		t.Fatalf("PromoteDueRetries: %v", err)
+	}
+	if promoted != 1 {
+		t.Fatalf("PromoteDueRetries once the retry is due moved %d jobs, want 1", promoted)
+	}
+	if server.Exists(redisconn.Key(RetryKey)) {
+		t.Error("promoted retry was left in the retry set")
+	}
+
+	dequeued, err := Dequeue(ctx, rdb, time.Second)
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if dequeued == nil || dequeued.ID != job.ID || dequeued.Attempts != 1 || string(dequeued.Data) != `{"user_id":7}` {
+		t.Errorf("Dequeue = %+v, want job %s on its first retry", dequeued, job.ID)
+	}
+}
+
+func TestRetryExhaustedIsDeadLettered(t *testing.T) {
+	server, rdb := newTestClient(t)
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	job := New(ctx, "fetch_transactions", json.RawMessage(`{}`))
+	for attempt := 1; attempt < MaxAttempts; attempt++ {
+		retried, err := Retry(ctx, rdb, &job, now)
+		if err != nil {
+			t.Fatalf("Retry: %v", err)
+		}
+		if !retried {
+			t.Fatalf("Retry after attempt %d = false, want true", attempt)
+		}
+		server.Del(redisconn.Key(RetryKey))
+	}
+
+	retried, err := Retry(ctx, rdb, &job, now)
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if retried {
+		t.Error("Retry after the last attempt = true, want false so the job is dead lettered")
+	}
+	if job.Attempts != MaxAttempts-1 {
+		t.Errorf("job has %d attempts, want %d", job.Attempts, MaxAttempts-1)
+	}
+	if server.Exists(redisconn.Key(RetryKey)) {
+		t.Error("exhausted job was scheduled for another retry")
+	}
+}
+
+func TestPromoteDueRetriesKeepsJobWhenQueueFails(t *testing.T) {
+	server, rdb := newTestClient(t)
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	job := New(ctx, "fetch_transactions", json.RawMessage(`{}`))
+	if _, err := Retry(ctx, rdb, &job, now); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	// A string under the queue key makes LPUSH fail with WRONGTYPE
+	server.Set(redisconn.Key(QueueKey), "not a list")
+
+	if _, err := PromoteDueRetries(ctx, rdb, now.Add(RetryDelay(1))); err == nil {
+		t.Fatal("PromoteDueRetries with an unusable queue returned no error")
+	}
+	if members, _ := server.ZMembers(redisconn.Key(RetryKey)); len(members) != 1 {
+		t.Errorf("retry set has %d members after a failed promotion, want 1", len(members))
+	}
+}
@@ -0,0 +1,139 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RedactedValue replaces secrets in job data that is recorded or reported
+const RedactedValue = "[REDACTED]"
+
+// secretKeyParts mark a key of job data as holding a secret, matched against the key lowercased without _ or -
+var secretKeyParts = []string{"token", "secret", "password", "apikey", "authorization", "credential", "signature", "webhookurl", "privatekey"}
+
+// IsSecretKey reports whether a key of job data holds a secret such as an access token
+func IsSecretKey(key string) bool {
+	key = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitSecrets returns job data with the values of secret keys replaced by RedactedValue, along with the secrets
+// keyed by their JSON pointer so RestoreSecrets can put them back. secrets is nil when the data holds none, in
+// which case the data is returned unchanged.
+func SplitSecrets(data json.RawMessage) (redacted json.RawMessage, secrets json.RawMessage, err error) {
+	value, err := decodeData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	found := map[string]interface{}{}
+	splitValue(value, "", found)
+	if len(found) == 0 {
+		return data, nil, nil
+	}
+	if redacted, err = json.Marshal(value); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode job data: %w", err)
+	}
+	if secrets, err = json.Marshal(found); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode job secrets: %w", err)
+	}
+	return redacted, secrets, nil
+}
+
+// splitValue replaces secrets in a decoded JSON value in place, adding each to found under its pointer
+func splitValue(value interface{}, pointer string, found map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			fieldPointer := pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+			if IsSecretKey(key) && field != nil {
+				found[fieldPointer] = field
+				v[key] = RedactedValue
+			} else {
+				splitValue(field, fieldPointer, found)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			splitValue(item, pointer+"/"+strconv.Itoa(i), found)
+		}
+	}
+}
+
+// RestoreSecrets puts secrets split out by SplitSecrets back into the redacted data
+func RestoreSecrets(data json.RawMessage, secrets json.RawMessage) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(secrets)) == 0 {
+		return data, nil
+	}
+	value, err := decodeData(data)
+	if err != nil {
+		return nil, err
+	}
+	var found map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(secrets))
+	decoder.UseNumber()
+	if err := decoder.Decode(&found); err != nil {
+		return nil, fmt.Errorf("failed to parse job secrets: %w", err)
+	}
+	for pointer, secret := range found {
+		if err := setPointer(value, pointer, secret); err != nil {
+			return nil, err
+		}
+	}
+	restored, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job data: %w", err)
+	}
+	return restored, nil
+}
+
+// setPointer sets the value a JSON pointer names, which must already exist
+func setPointer(value interface{}, pointer string, secret interface{}) error {
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, token := range tokens {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		last := i == len(tokens)-1
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if _, ok := v[token]; !ok {
+				return fmt.Errorf("job data has no %s for its secret", pointer)
+			}
+			if last {
+				v[token] = secret
+				return nil
+			}
+			value = v[token]
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(v) {
+				return fmt.Errorf("job data has no %s for its secret", pointer)
+			}
+			if last {
+				v[index] = secret
+				return nil
+			}
+			value = v[index]
+		default:
+			return fmt.Errorf("job data has no %s for its secret", pointer)
+		}
+	}
+	return nil
+}
+
+// decodeData decodes job data keeping numbers as written, so ids survive a round trip
+func decodeData(data json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to parse job data: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSplitAndRestoreSecrets(t *testing.T) {
+	data := json.RawMessage(`{"user_id":12345678901234567,"access_token":"access-sandbox-1","accounts":[{"id":"acc_1","api_key":"k1"}],"a/b~c_secret":"s","webhook_secret":null}`)
+	redacted, secrets, err := SplitSecrets(data)
+	if err != nil {
+		t.Fatalf("SplitSecrets: %v", err)
+	}
+	for _, secret := range []string{"access-sandbox-1", "k1", `"s"`} {
+		if strings.Contains(string(redacted), secret) {
+			t.Errorf("redacted data %s still has %s", redacted, secret)
+		}
+	}
+	if !strings.Contains(string(redacted), `"webhook_secret":null`) || !strings.Contains(string(redacted), "12345678901234567") {
+		t.Errorf("redacted data %s changed more than its secrets", redacted)
+	}
+
+	restored, err := RestoreSecrets(redacted, secrets)
+	if err != nil {
+		t.Fatalf("RestoreSecrets: %v", err)
+	}
+	var want, got interface{}
+	json.Unmarshal(data, &want)
+	json.Unmarshal(restored, &got)
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("restored data = %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+func TestSplitSecretsWithoutSecrets(t *testing.T) {
+	data := json.RawMessage(`{"user_id": 7, "account_id": "acc_1"}`)
+	redacted, secrets, err := SplitSecrets(data)
+	if err != nil || secrets != nil || string(redacted) != string(data) {
+		t.Errorf("SplitSecrets = %s, %s, %v; want the data unchanged and no secrets", redacted, secrets, err)
+	}
+	if restored, err := RestoreSecrets(redacted, nil); err != nil || string(restored) != string(data) {
+		t.Errorf("RestoreSecrets without secrets = %s, %v; want the data unchanged", restored, err)
+	}
+	if _, _, err := SplitSecrets(json.RawMessage(`not json`)); err == nil {
+		t.Error("SplitSecrets of data that isn't JSON succeeded")
+	}
+}
+
+func TestRestoreSecretsMissingKey(t *testing.T) {
+	if _, err := RestoreSecrets(json.RawMessage(`{"user_id":7}`), json.RawMessage(`{"/access_token":"x"}`)); err == nil {
+		t.Error("RestoreSecrets into data without the secret's key succeeded")
+	}
+}
@@ -0,0 +1,259 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of amounts that don't name one. Every amount stored so far is in it.
+const DefaultCurrency = "USD"
+
+// Money is an amount held as a whole number of cents, so sums and prorations don't drift the way float64
+// does. It is stored in NUMERIC columns and serialized to JSON as a plain number with two decimals, keeping
+// responses the same shape they had as float64. Combining amounts in different currencies panics, as it is a
+// programming error rather than something a caller can recover from.
+type Money struct {
+	cents    int64
+	currency string
+}
+
+// FromCents returns an amount in DefaultCurrency
+func FromCents(cents int64) Money {
+	return Money{cents: cents}
+}
+
+// FromFloat rounds a float64 amount, such as those Plaid reports, to the nearest cent
+func FromFloat(amount float64) Money {
+	return Money{cents: int64(math.Round(amount * 100))}
+}
+
+// Parse reads a decimal amount such as "-12.34", as Teller and Postgres report them. Amounts with more than
+// two decimals are rounded to the nearest cent, halves away from zero.
+func Parse(amount string) (Money, error) {
+	amount = strings.TrimSpace(amount)
+	rat, ok := new(big.Rat).SetString(amount)
+	if !ok || strings.ContainsAny(amount, "/eE") {
+		return Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	rat.Mul(rat, big.NewRat(100, 1))
+	cents, err := roundRat(rat)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", amount, err)
+	}
+	return Money{cents: cents}, nil
+}
+
+// roundRat rounds to the nearest integer, halves away from zero
+func roundRat(rat *big.Rat) (int64, error) {
+	num, den := new(big.Int).Set(rat.Num()), rat.Denom()
+	negative := num.Sign() < 0
+	num.Abs(num)
+	quotient, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	if remainder.Mul(remainder, big.NewInt(2)).Cmp(den) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("amount out of range")
+	}
+	if negative {
+		return -quotient.Int64(), nil
+	}
+	return quotient.Int64(), nil
+}
+
+// In returns the same amount in the given currency
+func (m Money) In(currency string) Money {
+	m.currency = currency
+	return m
+}
+
+// Cents returns the amount in cents
+func (m Money) Cents() int64 {
+	return m.cents
+}
+
+// Currency returns the amount's ISO 4217 currency code
+func (m Money) Currency() string {
+	if m.currency == "" {
+		return DefaultCurrency
+	}
+	return m.currency
+}
+
+// Float64 returns the amount in whole units, for display and for ratios that don't need to be exact
+func (m Money) Float64() float64 {
+	return float64(m.cents) / 100
+}
+
+// String formats the amount with two decimals, e.g. "-12.34"
+func (m Money) String() string {
+	sign, cents := "", m.cents
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// sameCurrency returns the currency two amounts share, panicking if they don't
+func sameCurrency(a Money, b Money) string {
+	if a.currency == b.currency || b.currency == "" {
+		return a.currency
+	}
+	if a.currency == "" {
+		return b.currency
+	}
+	if a.Currency() != b.Currency() {
+		panic(fmt.Sprintf("money: cannot combine %s and %s", a.Currency(), b.Currency()))
+	}
+	return a.currency
+}
+
+// Add returns m + other
+func (m Money) Add(other Money) Money {
+	return Money{cents: m.cents + other.cents, currency: sameCurrency(m, other)}
+}
+
+// Sub returns m - other
+func (m Money) Sub(other Money) Money {
+	return Money{cents: m.cents - other.cents, currency: sameCurrency(m, other)}
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	m.cents = -m.cents
+	return m
+}
+
+// Mul scales the amount by factor, rounding to the nearest cent
+func (m Money) Mul(factor float64) Money {
+	m.cents = int64(math.Round(float64(m.cents) * factor))
+	return m
+}
+
+// Prorate returns numerator/denominator of the amount, rounded to the nearest cent with halves away from
+// zero. It is exact, unlike Mul, so splitting a budget over the days of a period doesn't drift.
+func (m Money) Prorate(numerator int64, denominator int64) Money {
+	if denominator == 0 {
+		return m
+	}
+	rat := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(m.cents), big.NewInt(numerator)), big.NewInt(denominator))
+	cents, err := roundRat(rat)
+	if err != nil {
+		panic(fmt.Sprintf("money: %v", err))
+	}
+	m.cents = cents
+	return m
+}
+
+// Ratio returns m / other, or 0 when other is zero
+func (m Money) Ratio(other Money) float64 {
+	if other.cents == 0 {
+		return 0
+	}
+	sameCurrency(m, other)
+	return float64(m.cents) / float64(other.cents)
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or greater than other
+func (m Money) Cmp(other Money) int {
+	sameCurrency(m, other)
+	switch {
+	case m.cents < other.cents:
+		return -1
+	case m.cents > other.cents:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.cents == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.cents < 0
+}
+
+// IsPositive reports whether the amount is above zero
+func (m Money) IsPositive() bool {
+	return m.cents > 0
+}
+
+// Sum adds up amounts, which must share a currency
+func Sum(amounts ...Money) Money {
+	var total Money
+	for _, amount := range amounts {
+		total = total.Add(amount)
+	}
+	return total
+}
+
+// Scan reads a NUMERIC, integer or float column. NULL reads as zero.
+func (m *Money) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*m = Money{currency: m.currency}
+		return nil
+	case []byte:
+		return m.scanString(string(value))
+	case string:
+		return m.scanString(value)
+	case int64:
+		*m = Money{cents: value * 100, currency: m.currency}
+		return nil
+	case float64:
+		*m = FromFloat(value).In(m.currency)
+		return nil
+	}
+	return fmt.Errorf("money: cannot scan %T", src)
+}
+
+func (m *Money) scanString(value string) error {
+	parsed, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed.In(m.currency)
+	return nil
+}
+
+// Value writes the amount as a decimal string, which Postgres reads into NUMERIC exactly
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// MarshalJSON writes the amount as a number with two decimals
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a number or a quoted decimal string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = Money{currency: m.currency}
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		return m.scanString(value)
+	}
+	value, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("money: invalid amount %s", data)
+	}
+	if strings.ContainsAny(string(data), "eE") {
+		*m = FromFloat(value).In(m.currency)
+		return nil
+	}
+	return m.scanString(string(data))
+}
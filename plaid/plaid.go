@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
-	"time"
 	"watson/database"
+	"watson/faultinject"
+	"watson/telemetry"
 
 	"github.com/joho/godotenv"
 	plaid "github.com/plaid/plaid-go/v31/plaid"
@@ -20,6 +22,7 @@ var (
 	PLAID_PRODUCTS                       = "transactions"
 	PLAID_COUNTRY_CODES                  = []string{"US", "CA"}
 	PLAID_REDIRECT_URI                   = ""
+	PLAID_WEBHOOK_URL                    = ""
 	APP_PORT                             = ""
 	Client              *plaid.APIClient = nil
 )
@@ -43,6 +46,8 @@ func InitPlaid() {
 	PLAID_PRODUCTS = os.Getenv("PLAID_PRODUCTS")
 
 	// PLAID_REDIRECT_URI = os.Getenv("PLAID_REDIRECT_URI")
+	// Where Plaid sends item webhooks, the API's /plaid/webhook. Items don't get a webhook when empty.
+	PLAID_WEBHOOK_URL = os.Getenv("PLAID_WEBHOOK_URL")
 	APP_PORT = os.Getenv("APP_PORT")
 
 	// set defaults
@@ -74,11 +79,12 @@ func InitPlaid() {
 	} else {
 		log.Fatal("Invalid PLAID_ENV. Must be either 'production' or 'sandbox'")
 	}
+	configuration.HTTPClient = &http.Client{Transport: telemetry.WrapTransport(faultinject.WrapTransport("plaid", PLAID_ENV, nil))}
 	Client = plaid.NewAPIClient(configuration)
 	log.Printf("Plaid client initialized")
 }
 
-func CreateLinkToken(userIdInt int) (string, error) {
+func CreateLinkToken(ctx context.Context, userIdInt int) (string, error) {
 
 	request := plaid.NewLinkTokenCreateRequest(
 		"Watson",
@@ -87,7 +93,11 @@ func CreateLinkToken(userIdInt int) (string, error) {
 		*plaid.NewLinkTokenCreateRequestUser(strconv.Itoa(userIdInt)),
 	)
 	request.SetProducts([]plaid.Products{plaid.PRODUCTS_TRANSACTIONS})
-	// request.SetWebhook("https://sample-web-hook.com")
+	// Liabilities power the debt payoff planner, but shouldn't block linking institutions that don't support them
+	request.SetOptionalProducts([]plaid.Products{plaid.PRODUCTS_LIABILITIES})
+	if PLAID_WEBHOOK_URL != "" {
+		request.SetWebhook(PLAID_WEBHOOK_URL)
+	}
 
 	// Set OAuth redirect URI for institutions that require it (like Chase)
 	redirectUri := os.Getenv("PLAID_REDIRECT_URI")
@@ -96,7 +106,7 @@ func CreateLinkToken(userIdInt int) (string, error) {
 		log.Printf("Set OAuth redirect URI: %s", redirectUri)
 	}
 
-	linkTokenCreateResp, _, err := Client.PlaidApi.LinkTokenCreate(context.Background()).LinkTokenCreateRequest(*request).Execute()
+	linkTokenCreateResp, _, err := Client.PlaidApi.LinkTokenCreate(ctx).LinkTokenCreateRequest(*request).Execute()
 	if err != nil {
 		log.Printf("Failed to create link token: %v", err)
 		return "", err
@@ -106,9 +116,9 @@ func CreateLinkToken(userIdInt int) (string, error) {
 	return linkToken, nil
 }
 
-func ExchangePublicToken(publicToken string, userIdInt int) (string, string, error) {
+func ExchangePublicToken(ctx context.Context, publicToken string, userIdInt int) (string, string, error) {
 	exchangePublicTokenReq := plaid.NewItemPublicTokenExchangeRequest(publicToken)
-	exchangePublicTokenResp, _, err := Client.PlaidApi.ItemPublicTokenExchange(context.Background()).ItemPublicTokenExchangeRequest(
+	exchangePublicTokenResp, _, err := Client.PlaidApi.ItemPublicTokenExchange(ctx).ItemPublicTokenExchangeRequest(
 		*exchangePublicTokenReq,
 	).Execute()
 	accessToken := exchangePublicTokenResp.GetAccessToken()
@@ -121,39 +131,84 @@ func ExchangePublicToken(publicToken string, userIdInt int) (string, string, err
 	return accessToken, itemId, nil
 }
 
-func GetTransactions(accessToken string, startDate string, endDate string) ([]plaid.Transaction, error) {
-	const iso8601TimeFormat = "2006-01-02"
+// PartialFetchError is returned by GetTransactions when some pages were fetched before a later page failed.
+// The transactions fetched so far are still returned alongside it.
+type PartialFetchError struct {
+	Fetched int
+	Total   int
+	Err     error
+}
 
-	startDate = time.Now().Add(-365 * 24 * time.Hour).Format(iso8601TimeFormat)
-	endDate = time.Now().Format(iso8601TimeFormat)
-	// options := plaid.TransactionsGetRequestOptions{
-	// 	IncludePersonalFinanceCategory := true,
-	// }
-	// request.SetOptions(options)
+func (e *PartialFetchError) Error() string {
+	return fmt.Sprintf("fetched %d of %d transactions before failure: %v", e.Fetched, e.Total, e.Err)
+}
 
+func (e *PartialFetchError) Unwrap() error {
+	return e.Err
+}
+
+const transactionsPageSize = 500
+
+// GetTransactions fetches every transaction on the item between startDate and endDate (YYYY-MM-DD),
+// following total_transactions/offset until all pages are retrieved
+func GetTransactions(ctx context.Context, accessToken string, startDate string, endDate string) ([]plaid.Transaction, error) {
+	var transactions []plaid.Transaction
+	total := -1
+	for total < 0 || len(transactions) < total {
+		request := plaid.NewTransactionsGetRequest(
+			accessToken,
+			startDate,
+			endDate,
+		)
+		options := plaid.NewTransactionsGetRequestOptions()
+		options.SetCount(transactionsPageSize)
+		options.SetOffset(int32(len(transactions)))
+		options.SetIncludePersonalFinanceCategory(true)
+		request.SetOptions(*options)
+
+		transactionsResp, _, err := Client.PlaidApi.TransactionsGet(ctx).TransactionsGetRequest(*request).Execute()
+		if err != nil {
+			log.Printf("Failed to get transactions (offset %d): %v", len(transactions), err)
+			if len(transactions) == 0 {
+				return nil, err
+			}
+			return transactions, &PartialFetchError{Fetched: len(transactions), Total: total, Err: err}
+		}
+		page := transactionsResp.GetTransactions()
+		total = int(transactionsResp.GetTotalTransactions())
+		transactions = append(transactions, page...)
+		if len(page) == 0 {
+			break
+		}
+	}
+	return transactions, nil
+}
+
+// GetTransactionsPage fetches a single page of transactions for one account between startDate and endDate (YYYY-MM-DD)
+// and returns the page along with the total number of transactions Plaid has for that window
+func GetTransactionsPage(ctx context.Context, accessToken string, accountID string, startDate string, endDate string, offset int, count int) ([]plaid.Transaction, int, error) {
 	request := plaid.NewTransactionsGetRequest(
 		accessToken,
 		startDate,
 		endDate,
 	)
 
-	// options := plaid.TransactionsGetRequestOptions{
-	// Count:  plaid.PtrInt32(100),
-	// Offset: plaid.PtrInt32(0),
-	// IncludePersonalFinanceCategory: true,
-	// }
+	options := plaid.NewTransactionsGetRequestOptions()
+	options.SetAccountIds([]string{accountID})
+	options.SetCount(int32(count))
+	options.SetOffset(int32(offset))
+	options.SetIncludePersonalFinanceCategory(true)
+	request.SetOptions(*options)
 
-	// request.SetOptions(options)
-
-	transactionsResp, _, err := Client.PlaidApi.TransactionsGet(context.Background()).TransactionsGetRequest(*request).Execute()
+	transactionsResp, _, err := Client.PlaidApi.TransactionsGet(ctx).TransactionsGetRequest(*request).Execute()
 	if err != nil {
-		log.Printf("Failed to get transactions: %v", err)
-		return nil, err
+		log.Printf("Failed to get transactions page (offset %d): %v", offset, err)
+		return nil, 0, err
 	}
-	return transactionsResp.GetTransactions(), nil
+	return transactionsResp.GetTransactions(), int(transactionsResp.GetTotalTransactions()), nil
 }
 
-func GetAccounts(accessToken string) ([]plaid.AccountBase, error) {
+func GetAccounts(ctx context.Context, accessToken string) ([]plaid.AccountBase, error) {
 	// options := plaid.TransactionsGetRequestOptions{
 	// 	IncludePersonalFinanceCategory := true,
 	// }
@@ -171,7 +226,7 @@ func GetAccounts(accessToken string) ([]plaid.AccountBase, error) {
 
 	// request.SetOptions(options)
 
-	accountsResp, _, err := Client.PlaidApi.AccountsGet(context.Background()).AccountsGetRequest(*request).Execute()
+	accountsResp, _, err := Client.PlaidApi.AccountsGet(ctx).AccountsGetRequest(*request).Execute()
 	if err != nil {
 		log.Printf("Failed to get accounts: %v", err)
 		return nil, err
@@ -184,3 +239,117 @@ func GetAccounts(accessToken string) ([]plaid.AccountBase, error) {
 
 	return accounts, nil
 }
+
+// GetLiabilities fetches the credit card, student loan and mortgage liabilities on the item,
+// along with the accounts they belong to for their current balances
+func GetLiabilities(ctx context.Context, accessToken string) ([]plaid.AccountBase, plaid.LiabilitiesObject, error) {
+	request := plaid.NewLiabilitiesGetRequest(accessToken)
+	liabilitiesResp, _, err := Client.PlaidApi.LiabilitiesGet(ctx).LiabilitiesGetRequest(*request).Execute()
+	if err != nil {
+		log.Printf("Failed to get liabilities: %v", err)
+		return nil, plaid.LiabilitiesObject{}, err
+	}
+	return liabilitiesResp.GetAccounts(), liabilitiesResp.GetLiabilities(), nil
+}
+
+// SyncLiabilities refreshes the user's stored debts from every linked Plaid item.
+// Items that don't support liabilities are skipped.
+func SyncLiabilities(ctx context.Context, userID int) error {
+	accessTokens, err := database.GetPlaidAccessTokensByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, accessToken := range accessTokens {
+		accounts, liabilities, err := GetLiabilities(ctx, accessToken)
+		if err != nil {
+			continue
+		}
+		if err := database.UpsertDebtsFromLiabilities(userID, accounts, liabilities); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetItemInstitutionID returns the id of the institution the item is at
+func GetItemInstitutionID(ctx context.Context, accessToken string) (string, error) {
+	request := plaid.NewItemGetRequest(accessToken)
+	itemResp, _, err := Client.PlaidApi.ItemGet(ctx).ItemGetRequest(*request).Execute()
+	if err != nil {
+		log.Printf("Failed to get item: %v", err)
+		return "", err
+	}
+	item := itemResp.GetItem()
+	return item.GetInstitutionId(), nil
+}
+
+// GetInstitution fetches an institution's name and branding: its logo, primary color and website
+func GetInstitution(ctx context.Context, institutionID string) (database.Institution, error) {
+	request := plaid.NewInstitutionsGetByIdRequest(institutionID, []plaid.CountryCode{plaid.COUNTRYCODE_CA, plaid.COUNTRYCODE_US})
+	options := plaid.NewInstitutionsGetByIdRequestOptions()
+	options.SetIncludeOptionalMetadata(true)
+	request.SetOptions(*options)
+
+	institutionResp, _, err := Client.PlaidApi.InstitutionsGetById(ctx).InstitutionsGetByIdRequest(*request).Execute()
+	if err != nil {
+		log.Printf("Failed to get institution %s: %v", institutionID, err)
+		return database.Institution{}, err
+	}
+	institution := institutionResp.GetInstitution()
+	return database.Institution{
+		InstitutionID: institution.GetInstitutionId(),
+		Name:          institution.GetName(),
+		Logo:          optionalString(institution.GetLogo()),
+		PrimaryColor:  optionalString(institution.GetPrimaryColor()),
+		URL:           optionalString(institution.GetUrl()),
+	}, nil
+}
+
+// optionalString returns nil for metadata Plaid doesn't have for an institution
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// SetItemWebhook points the item's webhooks at PLAID_WEBHOOK_URL, for items linked before it was set
+func SetItemWebhook(ctx context.Context, accessToken string) error {
+	request := plaid.NewItemWebhookUpdateRequest(accessToken)
+	request.SetWebhook(PLAID_WEBHOOK_URL)
+	_, _, err := Client.PlaidApi.ItemWebhookUpdate(ctx).ItemWebhookUpdateRequest(*request).Execute()
+	if err != nil {
+		log.Printf("Failed to update item webhook: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FireSandboxWebhook asks the Plaid sandbox to send the item's webhook with the given code. NEW_ACCOUNTS_AVAILABLE
+// is an item webhook; the other codes are transactions webhooks.
+func FireSandboxWebhook(ctx context.Context, accessToken string, webhookCode string) error {
+	request := plaid.NewSandboxItemFireWebhookRequest(accessToken, webhookCode)
+	if webhookCode == "NEW_ACCOUNTS_AVAILABLE" {
+		request.SetWebhookType(plaid.WEBHOOKTYPE_ITEM)
+	} else {
+		request.SetWebhookType(plaid.WEBHOOKTYPE_TRANSACTIONS)
+	}
+	_, _, err := Client.PlaidApi.SandboxItemFireWebhook(ctx).SandboxItemFireWebhookRequest(*request).Execute()
+	if err != nil {
+		log.Printf("Failed to fire sandbox webhook: %v", err)
+		return err
+	}
+	return nil
+}
+
+// RefreshTransactions asks Plaid to check the item's institution for new transactions. In the sandbox this
+// adds new transactions to the item.
+func RefreshTransactions(ctx context.Context, accessToken string) error {
+	request := plaid.NewTransactionsRefreshRequest(accessToken)
+	_, _, err := Client.PlaidApi.TransactionsRefresh(ctx).TransactionsRefreshRequest(*request).Execute()
+	if err != nil {
+		log.Printf("Failed to refresh transactions: %v", err)
+		return err
+	}
+	return nil
+}
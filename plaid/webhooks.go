@@ -0,0 +1,95 @@
+package plaid
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	plaid "github.com/plaid/plaid-go/v31/plaid"
+)
+
+// Plaid signs each webhook with a JWT in the Plaid-Verification header. The JWT carries a SHA-256 of the body
+// and is signed with an ES256 key fetched by its key id from /webhook_verification_key/get. Plaid asks that
+// webhooks older than five minutes are rejected.
+const maxWebhookAge = 5 * time.Minute
+
+// ErrWebhookVerification marks webhooks that weren't signed by Plaid or don't match their body
+var ErrWebhookVerification = errors.New("plaid webhook could not be verified")
+
+var (
+	webhookKeysMu sync.Mutex
+	webhookKeys   = map[string]*ecdsa.PublicKey{}
+)
+
+// VerifyWebhook checks a webhook body against its Plaid-Verification header
+func VerifyWebhook(ctx context.Context, body []byte, verification string) error {
+	if verification == "" {
+		return fmt.Errorf("%w: missing Plaid-Verification header", ErrWebhookVerification)
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(verification, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		if keyID == "" {
+			return nil, errors.New("missing key id")
+		}
+		return webhookVerificationKey(ctx, keyID)
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithIssuedAt())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebhookVerification, err)
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil || time.Since(issuedAt.Time) > maxWebhookAge {
+		return fmt.Errorf("%w: missing or expired iat", ErrWebhookVerification)
+	}
+	expected, _ := claims["request_body_sha256"].(string)
+	sum := sha256.Sum256(body)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(hex.EncodeToString(sum[:]))) != 1 {
+		return fmt.Errorf("%w: body does not match its signature", ErrWebhookVerification)
+	}
+	return nil
+}
+
+// webhookVerificationKey returns the public key Plaid signs webhooks with, fetching it the first time it is used
+func webhookVerificationKey(ctx context.Context, keyID string) (*ecdsa.PublicKey, error) {
+	webhookKeysMu.Lock()
+	key, ok := webhookKeys[keyID]
+	webhookKeysMu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	request := plaid.NewWebhookVerificationKeyGetRequest(keyID)
+	keyResp, _, err := Client.PlaidApi.WebhookVerificationKeyGet(ctx).WebhookVerificationKeyGetRequest(*request).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook verification key: %v", err)
+	}
+	jwk := keyResp.GetKey()
+	if expiredAt, _ := jwk.GetExpiredAtOk(); expiredAt != nil {
+		return nil, errors.New("webhook verification key has expired")
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.GetX())
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook verification key: %v", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.GetY())
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook verification key: %v", err)
+	}
+	key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+
+	webhookKeysMu.Lock()
+	webhookKeys[keyID] = key
+	webhookKeysMu.Unlock()
+	return key, nil
+}
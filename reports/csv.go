@@ -0,0 +1,121 @@
+package reports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strings"
+	"watson/database"
+	"watson/money"
+)
+
+var businessExpensesHeader = []string{"Category", "Date", "Description", "Merchant", "Account", "Amount", "Currency"}
+
+var taxReportHeader = []string{"Tax Type", "Category", "Date", "Description", "Merchant", "Account", "Amount", "Currency"}
+
+// RenderBusinessExpensesCSV lays out business expenses as a CSV for an accountant: each category's expenses
+// followed by a total row, then a grand total. Expenses must be ordered by category, as GetBusinessExpenses returns
+// them. Amounts in different currencies are totalled separately.
+func RenderBusinessExpensesCSV(expenses []database.BusinessExpense) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(businessExpensesHeader)
+
+	grandTotals, categoryTotals := currencyTotals{}, currencyTotals{}
+	for i, expense := range expenses {
+		writer.Write([]string{
+			spreadsheetSafe(expense.Category),
+			expense.Date.Format("2006-01-02"),
+			spreadsheetSafe(expense.Description),
+			spreadsheetSafe(expense.Merchant),
+			spreadsheetSafe(expense.Account),
+			expense.Amount.String(),
+			expense.Amount.Currency(),
+		})
+		categoryTotals.add(expense.Amount)
+		grandTotals.add(expense.Amount)
+		if i == len(expenses)-1 || expenses[i+1].Category != expense.Category {
+			categoryTotals.write(writer, businessExpensesHeader, spreadsheetSafe(expense.Category), "", "Total")
+			categoryTotals = currencyTotals{}
+		}
+	}
+	grandTotals.write(writer, businessExpensesHeader, "All categories", "", "Total")
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderTaxReportCSV lays out a year's tax relevant expenses as a CSV: each category's expenses followed by a total
+// row, and each tax type's categories followed by the tax type's total. Expenses must be ordered by tax type and
+// category, as GetTaxExpenses returns them. Amounts in different currencies are totalled separately.
+func RenderTaxReportCSV(expenses []database.TaxExpense) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(taxReportHeader)
+
+	taxTypeTotals, categoryTotals := currencyTotals{}, currencyTotals{}
+	for i, expense := range expenses {
+		writer.Write([]string{
+			expense.TaxType,
+			spreadsheetSafe(expense.Category),
+			expense.Date.Format("2006-01-02"),
+			spreadsheetSafe(expense.Description),
+			spreadsheetSafe(expense.Merchant),
+			spreadsheetSafe(expense.Account),
+			expense.Amount.String(),
+			expense.Amount.Currency(),
+		})
+		categoryTotals.add(expense.Amount)
+		taxTypeTotals.add(expense.Amount)
+		last := i == len(expenses)-1
+		if last || expenses[i+1].TaxType != expense.TaxType || expenses[i+1].Category != expense.Category {
+			categoryTotals.write(writer, taxReportHeader, expense.TaxType, spreadsheetSafe(expense.Category), "", "Total")
+			categoryTotals = currencyTotals{}
+		}
+		if last || expenses[i+1].TaxType != expense.TaxType {
+			taxTypeTotals.write(writer, taxReportHeader, expense.TaxType, "All categories", "", "Total")
+			taxTypeTotals = currencyTotals{}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// currencyTotals sums amounts by currency, since amounts in different currencies can't be added together
+type currencyTotals map[string]money.Money
+
+func (totals currencyTotals) add(amount money.Money) {
+	currency := amount.Currency()
+	totals[currency] = totals[currency].In(currency).Add(amount)
+}
+
+// write adds a total row per currency, in currency order: the leading columns, blank columns up to the last two of
+// header, then the amount and its currency
+func (totals currencyTotals) write(writer *csv.Writer, header []string, columns ...string) {
+	currencies := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		row := make([]string, len(header))
+		copy(row, columns)
+		row[len(row)-2], row[len(row)-1] = totals[currency].String(), currency
+		writer.Write(row)
+	}
+}
+
+// spreadsheetSafe keeps a bank's text from being run as a formula when the CSV is opened in a spreadsheet
+func spreadsheetSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
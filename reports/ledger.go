@@ -0,0 +1,256 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"watson/database"
+	"watson/money"
+)
+
+// Plain-text accounting formats RenderLedger writes
+const (
+	LedgerFormatBeancount = "beancount"
+	LedgerFormatLedger    = "ledger"
+)
+
+// Ledger accounts the export posts to for opening balances, and for transfers nothing maps elsewhere
+const (
+	ledgerOpeningBalances = "Equity:Opening-Balances"
+	ledgerTransfers       = "Equity:Transfers"
+)
+
+// ledgerAccountPattern matches account names beancount accepts, which ledger accepts too: one of beancount's five
+// root accounts followed by components starting with a capital letter or digit
+var ledgerAccountPattern = regexp.MustCompile(`^(Assets|Liabilities|Equity|Income|Expenses)(:[\p{Lu}\p{Nd}][\p{L}\p{Nd}-]*)+$`)
+
+// IsValidLedgerAccount reports whether name can be mapped to, like Expenses:Food or Liabilities:Visa-1234
+func IsValidLedgerAccount(name string) bool {
+	return ledgerAccountPattern.MatchString(name)
+}
+
+// LedgerExport is what RenderLedger lays out
+type LedgerExport struct {
+	Format string
+	// From and To are the first and last days exported
+	From         time.Time
+	To           time.Time
+	Accounts     []database.LedgerAccount
+	Transactions []database.LedgerTransaction
+	Mappings     []database.LedgerAccountMapping
+	// ActivityFrom and ActivityAfter are each account's activity from From on and after To, as
+	// GetLedgerActivitySince returns it. They work the accounts' current balances back to either end of the export.
+	ActivityFrom  map[database.LedgerAccountKey]money.Money
+	ActivityAfter map[database.LedgerAccountKey]money.Money
+}
+
+// RenderLedger writes transactions in beancount or ledger syntax, for double entry into plain-text accounting
+// tools. Each transaction moves its amount between the account it was made on and an income, expense or transfer
+// account for its category. Accounts with a known balance open with it and end with an assertion of the balance
+// after To, so the tool checks that nothing is missing. Accounts and categories are named from the user's mappings,
+// or made up from their names.
+func RenderLedger(export LedgerExport) []byte {
+	names := newLedgerNames(export.Accounts, export.Mappings)
+	beancount := export.Format != LedgerFormatLedger
+	dateLayout := "2006/01/02"
+	if beancount {
+		dateLayout = "2006-01-02"
+	}
+	opened := map[string]bool{}
+	var entries bytes.Buffer
+	posting := func(account string, amount money.Money) {
+		opened[account] = true
+		fmt.Fprintf(&entries, "  %s  %s %s\n", account, amount, amount.Currency())
+	}
+
+	var closing bytes.Buffer
+	for _, account := range export.Accounts {
+		opened[names.account(account.LedgerAccountKey)] = true
+		if account.Balance == nil {
+			continue
+		}
+		// Ledgers keep what is owed on credit and loans as a negative balance
+		current := *account.Balance
+		if account.Type == "credit" || account.Type == "loan" {
+			current = current.Neg()
+		}
+		opening, ok := workBalanceBack(current, export.ActivityFrom[account.LedgerAccountKey])
+		if !ok {
+			continue
+		}
+		final, _ := workBalanceBack(current, export.ActivityAfter[account.LedgerAccountKey])
+		name := names.account(account.LedgerAccountKey)
+		writeLedgerHeader(&entries, beancount, export.From.Format(dateLayout), "", "Opening balance")
+		posting(name, opening)
+		opened[ledgerOpeningBalances] = true
+		fmt.Fprintf(&entries, "  %s\n\n", ledgerOpeningBalances)
+
+		date := export.To.AddDate(0, 0, 1).Format(dateLayout)
+		if beancount {
+			fmt.Fprintf(&closing, "%s balance %s  %s %s\n", date, name, final, final.Currency())
+		} else {
+			fmt.Fprintf(&closing, "%s * Balance\n  %s  0 %s = %s %s\n\n", date, name, final.Currency(), final, final.Currency())
+		}
+	}
+
+	for _, transaction := range export.Transactions {
+		writeLedgerHeader(&entries, beancount, transaction.Date.Format(dateLayout), transaction.Merchant, transaction.Description)
+		if beancount {
+			fmt.Fprintf(&entries, "  watson_id: %s\n", beancountString(transaction.TransactionID))
+		} else {
+			fmt.Fprintf(&entries, "  ; watson_id: %s\n", transaction.TransactionID)
+		}
+		posting(names.category(transaction), transaction.Amount)
+		posting(names.account(transaction.Account), transaction.Amount.Neg())
+		entries.WriteString("\n")
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "; Watson export of %s to %s\n\n", export.From.Format("2006-01-02"), export.To.Format("2006-01-02"))
+	accounts := make([]string, 0, len(opened))
+	for account := range opened {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	for _, account := range accounts {
+		if beancount {
+			fmt.Fprintf(&out, "%s open %s\n", export.From.Format(dateLayout), account)
+		} else {
+			fmt.Fprintf(&out, "account %s\n", account)
+		}
+	}
+	out.WriteString("\n")
+	out.Write(entries.Bytes())
+	out.Write(closing.Bytes())
+	return out.Bytes()
+}
+
+// workBalanceBack is what a ledger balance was before activity, positive for money out. It fails when the two are
+// in different currencies.
+func workBalanceBack(balance money.Money, activity money.Money) (money.Money, bool) {
+	if !activity.IsZero() && activity.Currency() != balance.Currency() {
+		return money.Money{}, false
+	}
+	return balance.Add(activity), true
+}
+
+// writeLedgerHeader starts a cleared transaction. Beancount takes the payee and narration as quoted strings; ledger
+// takes one unquoted payee, so the narration follows as a note.
+func writeLedgerHeader(buf *bytes.Buffer, beancount bool, date string, payee string, narration string) {
+	payee, narration = strings.Join(strings.Fields(payee), " "), strings.Join(strings.Fields(narration), " ")
+	if beancount {
+		if payee == "" {
+			fmt.Fprintf(buf, "%s * %s\n", date, beancountString(narration))
+			return
+		}
+		fmt.Fprintf(buf, "%s * %s %s\n", date, beancountString(payee), beancountString(narration))
+		return
+	}
+	if payee == "" || payee == narration {
+		fmt.Fprintf(buf, "%s * %s\n", date, narration)
+		return
+	}
+	fmt.Fprintf(buf, "%s * %s\n  ; %s\n", date, payee, narration)
+}
+
+// beancountString quotes a string for beancount
+func beancountString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// ledgerComponent turns a name into an account name component, its words capitalized and joined by dashes, so
+// "food and drink" becomes Food-And-Drink
+func ledgerComponent(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "Unknown"
+	}
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	component := strings.Join(words, "-")
+	// Letters without a capital, as in many scripts, can't start a component
+	if first := []rune(component)[0]; !unicode.IsUpper(first) && !unicode.IsDigit(first) {
+		component = "X-" + component
+	}
+	return component
+}
+
+// ledgerNames names the ledger accounts an export posts to
+type ledgerNames struct {
+	accounts        map[database.LedgerAccountKey]string
+	accountMappings map[string]string
+	categories      map[string]string
+}
+
+func newLedgerNames(accounts []database.LedgerAccount, mappings []database.LedgerAccountMapping) *ledgerNames {
+	names := &ledgerNames{
+		accounts:        map[database.LedgerAccountKey]string{},
+		accountMappings: map[string]string{},
+		categories:      map[string]string{},
+	}
+	for _, mapping := range mappings {
+		if mapping.Kind == database.LedgerMappingAccount {
+			names.accountMappings[strings.ToLower(mapping.Source)] = mapping.LedgerAccount
+		} else {
+			names.categories[strings.ToLower(mapping.Source)] = mapping.LedgerAccount
+		}
+	}
+	taken := map[string]bool{}
+	for _, account := range accounts {
+		if mapped, ok := names.accountMappings[strings.ToLower(account.AccountRef)]; ok {
+			names.accounts[account.LedgerAccountKey] = mapped
+			continue
+		}
+		root := "Assets"
+		if account.Type == "credit" || account.Type == "loan" {
+			root = "Liabilities"
+		}
+		name := root + ":" + ledgerComponent(account.Name)
+		// Two accounts with the same name, like two cards called Visa, are told apart by the end of their ids
+		if taken[name] {
+			ref := account.AccountRef
+			name += "-" + ledgerComponent(ref[max(len(ref)-4, 0):])
+		}
+		taken[name] = true
+		names.accounts[account.LedgerAccountKey] = name
+	}
+	return names
+}
+
+// account names the ledger account for one of the user's accounts. Accounts that aren't linked, such as those of
+// imported history, are assets named after their source and account.
+func (n *ledgerNames) account(key database.LedgerAccountKey) string {
+	if name, ok := n.accounts[key]; ok {
+		return name
+	}
+	name, ok := n.accountMappings[strings.ToLower(key.AccountRef)]
+	if !ok {
+		name = "Assets:" + ledgerComponent(key.Provider) + ":" + ledgerComponent(key.AccountRef)
+	}
+	n.accounts[key] = name
+	return name
+}
+
+// category names the account a transaction's other side posts to: the category's mapping, the transfers account
+// for money moved between the user's accounts, or an income or expense account named after the category
+func (n *ledgerNames) category(transaction database.LedgerTransaction) string {
+	if mapped, ok := n.categories[strings.ToLower(transaction.Category)]; ok {
+		return mapped
+	}
+	switch {
+	case transaction.IsTransfer || strings.HasPrefix(transaction.PFCPrimary, "TRANSFER_"):
+		return ledgerTransfers
+	case transaction.PFCPrimary == "INCOME" || (transaction.Category == "Uncategorized" && transaction.Amount.IsNegative()):
+		return "Income:" + ledgerComponent(transaction.Category)
+	}
+	return "Expenses:" + ledgerComponent(transaction.Category)
+}
@@ -0,0 +1,128 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"watson/database"
+)
+
+const (
+	pdfPageWidth    = 612 // US Letter, in points
+	pdfPageHeight   = 792
+	pdfMargin       = 56
+	pdfFontSize     = 11
+	pdfLineHeight   = 16
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+)
+
+// RenderMonthlyReportPDF renders a monthly report as a plain text PDF suitable for attaching to an email
+func RenderMonthlyReportPDF(report database.MonthlyReport) []byte {
+	return renderTextPDF(MonthlyReportLines(report))
+}
+
+// MonthlyReportLines lays out a monthly report as lines of text
+func MonthlyReportLines(report database.MonthlyReport) []string {
+	data := report.Report
+	month := database.MonthYearStart(report.MonthYear).Format("January 2006")
+	lines := []string{
+		"Month in Review: " + month,
+		"",
+		fmt.Sprintf("Total spent: $%.2f across %d purchases", data.TotalSpent, data.TransactionCount),
+		fmt.Sprintf("Previous month: $%.2f%s", data.PreviousMonthSpent, formatChange(data.ChangeVsPreviousMonthPct)),
+		fmt.Sprintf("3 month average: $%.2f%s", data.ThreeMonthAverageSpent, formatChange(data.ChangeVsAveragePct)),
+		fmt.Sprintf("Income: $%.2f", data.Income),
+	}
+	if data.SavingsRate != nil {
+		lines = append(lines, fmt.Sprintf("Savings rate: %.1f%%", *data.SavingsRate))
+	}
+
+	lines = append(lines, "", "Top categories")
+	for _, category := range data.TopCategories {
+		lines = append(lines, fmt.Sprintf("  %s: $%.2f%s", category.Category, category.TotalSpent, formatChange(category.ChangePct)))
+	}
+
+	lines = append(lines, "", "Biggest purchases")
+	for _, purchase := range data.BiggestPurchases {
+		lines = append(lines, fmt.Sprintf("  %s  %s: $%.2f (%s)", purchase.Date, purchase.Description, purchase.Amount, purchase.Category))
+	}
+
+	lines = append(lines, "", "Generated "+report.GeneratedAt.Format(time.RFC1123))
+	return lines
+}
+
+func formatChange(changePct *float64) string {
+	if changePct == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.1f%%)", *changePct)
+}
+
+// renderTextPDF writes lines of text into a minimal PDF using the built in Helvetica font,
+// starting a new page whenever one fills up
+func renderTextPDF(lines []string) []byte {
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects 1-3 are the catalog, page tree and font; each page then takes a page object and a content stream
+	var objects []string
+	var pageRefs []string
+	for i := range pages {
+		pageRefs = append(pageRefs, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(pageRefs, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	for i, pageLines := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range pageLines {
+			fmt.Fprintf(&content, "(%s) '\n", escapePDFText(line))
+		}
+		content.WriteString("ET")
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xrefOffset := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+	return pdf.Bytes()
+}
+
+// escapePDFText escapes a line for a PDF string literal. The standard fonts only cover ASCII reliably,
+// so anything else is replaced.
+func escapePDFText(text string) string {
+	var escaped strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			escaped.WriteRune('\\')
+			escaped.WriteRune(r)
+		case r < 32 || r > 126:
+			escaped.WriteRune('?')
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,203 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"watson/telemetry"
+)
+
+const apiBaseURL = "https://api.stripe.com/v1"
+
+// webhookTolerance is how old a webhook's signature timestamp may be before it is rejected as a replay
+const webhookTolerance = 5 * time.Minute
+
+var (
+	STRIPE_SECRET_KEY     = ""
+	STRIPE_WEBHOOK_SECRET = ""
+	STRIPE_PRICE_ID       = ""
+	httpClient            = &http.Client{Timeout: 30 * time.Second, Transport: telemetry.WrapTransport(nil)}
+)
+
+// ErrNotConfigured is returned when STRIPE_SECRET_KEY is not set
+var ErrNotConfigured = errors.New("stripe is not configured: STRIPE_SECRET_KEY is not set")
+
+// InitStripe loads the Stripe settings from the environment. Billing endpoints are disabled when they are missing.
+func InitStripe() {
+	STRIPE_SECRET_KEY = os.Getenv("STRIPE_SECRET_KEY")
+	STRIPE_WEBHOOK_SECRET = os.Getenv("STRIPE_WEBHOOK_SECRET")
+	STRIPE_PRICE_ID = os.Getenv("STRIPE_PRICE_ID")
+	if STRIPE_SECRET_KEY == "" || STRIPE_PRICE_ID == "" {
+		log.Printf("Warning: STRIPE_SECRET_KEY or STRIPE_PRICE_ID is not set, Stripe billing is disabled")
+	}
+}
+
+// Event is a webhook event. Data.Object is decoded by the handler according to Type.
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Created is when the event happened, in Unix seconds. Stripe doesn't deliver events in order.
+	Created int64 `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// CheckoutSession is the subset of a Checkout Session the API uses
+type CheckoutSession struct {
+	ID                string `json:"id"`
+	URL               string `json:"url"`
+	Customer          string `json:"customer"`
+	ClientReferenceID string `json:"client_reference_id"`
+	Subscription      string `json:"subscription"`
+}
+
+// Subscription is the subset of a Subscription the API uses
+type Subscription struct {
+	ID               string `json:"id"`
+	Customer         string `json:"customer"`
+	Status           string `json:"status"`
+	CurrentPeriodEnd int64  `json:"current_period_end"`
+	Items            struct {
+		Data []struct {
+			CurrentPeriodEnd int64 `json:"current_period_end"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// PeriodEnd returns when the current billing period ends. Newer API versions report it per item
+// instead of on the subscription.
+func (s Subscription) PeriodEnd() *time.Time {
+	end := s.CurrentPeriodEnd
+	if end == 0 && len(s.Items.Data) > 0 {
+		end = s.Items.Data[0].CurrentPeriodEnd
+	}
+	if end == 0 {
+		return nil
+	}
+	periodEnd := time.Unix(end, 0)
+	return &periodEnd
+}
+
+// CreateCustomer creates a Stripe customer tagged with the Watson user id
+func CreateCustomer(email string, userID int) (string, error) {
+	form := url.Values{}
+	form.Set("email", email)
+	form.Set("metadata[user_id]", strconv.Itoa(userID))
+	var customer struct {
+		ID string `json:"id"`
+	}
+	if err := post("/customers", form, &customer); err != nil {
+		return "", fmt.Errorf("failed to create customer: %w", err)
+	}
+	return customer.ID, nil
+}
+
+// CreateCheckoutSession starts a subscription checkout for the configured price
+func CreateCheckoutSession(customerID string, userID int, successURL string, cancelURL string) (*CheckoutSession, error) {
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("customer", customerID)
+	form.Set("client_reference_id", strconv.Itoa(userID))
+	form.Set("line_items[0][price]", STRIPE_PRICE_ID)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", successURL)
+	form.Set("cancel_url", cancelURL)
+	var session CheckoutSession
+	if err := post("/checkout/sessions", form, &session); err != nil {
+		return nil, fmt.Errorf("failed to create checkout session: %w", err)
+	}
+	return &session, nil
+}
+
+func post(path string, form url.Values, out interface{}) error {
+	if STRIPE_SECRET_KEY == "" {
+		return ErrNotConfigured
+	}
+	req, err := http.NewRequest(http.MethodPost, apiBaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(STRIPE_SECRET_KEY, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &apiErr)
+		return fmt.Errorf("stripe returned status %d: %s", resp.StatusCode, apiErr.Error.Message)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// ConstructEvent verifies the Stripe-Signature header against the webhook secret and decodes the event
+func ConstructEvent(payload []byte, signatureHeader string, now time.Time) (*Event, error) {
+	if STRIPE_WEBHOOK_SECRET == "" {
+		return nil, errors.New("STRIPE_WEBHOOK_SECRET is not set")
+	}
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signatureHeader, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return nil, errors.New("malformed signature header")
+	}
+	if now.Sub(time.Unix(signedAt, 0)) > webhookTolerance {
+		return nil, errors.New("signature timestamp is outside the tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(STRIPE_WEBHOOK_SECRET))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	verified := false
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("no matching signature")
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	return &event, nil
+}
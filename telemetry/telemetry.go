@@ -0,0 +1,108 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Init sets up the global tracer provider and W3C trace context propagation for a service.
+// Spans are only exported when OTEL_EXPORTER_OTLP_ENDPOINT is set; otherwise tracing is a no-op
+// apart from propagating incoming trace context. The returned function flushes pending spans and
+// should be called on shutdown.
+func Init(serviceName string) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		log.Printf("OTEL_EXPORTER_OTLP_ENDPOINT not set, tracing disabled")
+		return func(context.Context) error { return nil }
+	}
+
+	// The exporter reads its endpoint, headers and TLS settings from the standard OTEL_EXPORTER_OTLP_* env vars
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		log.Printf("Failed to create OTLP trace exporter, tracing disabled: %v", err)
+		return func(context.Context) error { return nil }
+	}
+
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		serviceName = name
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		res = resource.Default()
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("Tracing enabled for %s", serviceName)
+	return provider.Shutdown
+}
+
+// Tracer returns a named tracer from the global provider
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// InjectMap serializes the trace context of ctx into a string map, for carrying it through
+// transports that aren't HTTP such as Redis job payloads
+func InjectMap(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// ExtractMap restores trace context previously serialized by InjectMap
+func ExtractMap(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// WrapTransport instruments an outbound HTTP transport so every request gets a client span
+// and carries trace headers. A nil base uses http.DefaultTransport.
+func WrapTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// HTTPClient is an instrumented replacement for http.DefaultClient
+var HTTPClient = &http.Client{Transport: WrapTransport(nil)}
+
+// OpenDB opens a database connection whose queries are recorded as spans
+func OpenDB(driverName, dataSourceName string) (*sql.DB, error) {
+	db, err := otelsql.Open(driverName, dataSourceName,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// WrapHandler instruments an inbound HTTP handler, continuing any trace started by the caller
+func WrapHandler(handler http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(handler, operation)
+}
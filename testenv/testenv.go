@@ -0,0 +1,192 @@
+//go:build integration
+
+// Package testenv starts the Postgres and Redis the integration tests run against, in Docker containers that
+// are removed when the tests finish. Integration tests carry the integration build tag and need a Docker
+// daemon:
+//
+//	go test -tags=integration ./...
+package testenv
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/redis/go-redis/v9"
+)
+
+// Images the containers run, matching the versions deployed
+const (
+	postgresImage = "postgres"
+	postgresTag   = "16-alpine"
+	redisImage    = "redis"
+	redisTag      = "7-alpine"
+)
+
+// containerExpiry is how long Docker keeps a container around if the tests die without removing it, in seconds
+const containerExpiry = 600
+
+// Env is a running Postgres and Redis
+type Env struct {
+	// DatabaseURL connects to an empty database the migrations haven't been applied to
+	DatabaseURL string
+	// RedisAddr is the host:port of an empty Redis
+	RedisAddr string
+
+	pool      *dockertest.Pool
+	resources []*dockertest.Resource
+}
+
+// Start starts the containers and waits until both accept connections. Close removes them.
+func Start() (*Env, error) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to docker: %w", err)
+	}
+	if err := pool.Client.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to reach docker: %w", err)
+	}
+	pool.MaxWait = 2 * time.Minute
+	env := &Env{pool: pool}
+
+	postgres, err := env.run(&dockertest.RunOptions{
+		Repository: postgresImage,
+		Tag:        postgresTag,
+		Env:        []string{"POSTGRES_USER=watson", "POSTGRES_PASSWORD=watson", "POSTGRES_DB=watson"},
+	})
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.DatabaseURL = databaseURL(postgres.GetHostPort("5432/tcp"), "watson")
+	if err := pool.Retry(func() error {
+		db, err := sql.Open("postgres", env.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	}); err != nil {
+		env.Close()
+		return nil, fmt.Errorf("postgres did not come up: %w", err)
+	}
+
+	redisContainer, err := env.run(&dockertest.RunOptions{Repository: redisImage, Tag: redisTag})
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.RedisAddr = redisContainer.GetHostPort("6379/tcp")
+	if err := pool.Retry(func() error {
+		rdb := redis.NewClient(&redis.Options{Addr: env.RedisAddr})
+		defer rdb.Close()
+		return rdb.Ping(context.Background()).Err()
+	}); err != nil {
+		env.Close()
+		return nil, fmt.Errorf("redis did not come up: %w", err)
+	}
+	return env, nil
+}
+
+// run starts a container that Docker removes once it stops
+func (env *Env) run(options *dockertest.RunOptions) (*dockertest.Resource, error) {
+	resource, err := env.pool.RunWithOptions(options, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s:%s: %w", options.Repository, options.Tag, err)
+	}
+	env.resources = append(env.resources, resource)
+	resource.Expire(containerExpiry)
+	return resource, nil
+}
+
+// Close stops and removes the containers
+func (env *Env) Close() {
+	for _, resource := range env.resources {
+		env.pool.Purge(resource)
+	}
+}
+
+// NewDatabase creates another empty database on the same server and returns its URL, for tests that need a
+// database of their own
+func (env *Env) NewDatabase(name string) (string, error) {
+	db, err := sql.Open("postgres", env.DatabaseURL)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE DATABASE " + name); err != nil {
+		return "", fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+	return strings.Replace(env.DatabaseURL, "/watson?", "/"+name+"?", 1), nil
+}
+
+func databaseURL(hostPort string, name string) string {
+	return fmt.Sprintf("postgres://watson:watson@%s/%s?sslmode=disable", hostPort, name)
+}
+
+// MigrationsDir is the directory holding the database migrations
+func MigrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "database", "migrations")
+}
+
+// Migrate applies every up migration in order, as migrate up does
+func Migrate(db *sql.DB) error {
+	files, err := migrationFiles(".up.sql")
+	if err != nil {
+		return err
+	}
+	return applyMigrations(db, files)
+}
+
+// MigrateDown applies every down migration in reverse order, as migrate down does
+func MigrateDown(db *sql.DB) error {
+	files, err := migrationFiles(".down.sql")
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return applyMigrations(db, files)
+}
+
+// migrationFiles lists the migrations with the suffix in version order
+func migrationFiles(suffix string) ([]string, error) {
+	entries, err := os.ReadDir(MigrationsDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), suffix) {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// applyMigrations runs each file as a single statement batch, like migrate's postgres driver
+func applyMigrations(db *sql.DB, files []string) error {
+	for _, file := range files {
+		migration, err := os.ReadFile(filepath.Join(MigrationsDir(), file))
+		if err != nil {
+			return fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+		if _, err := db.Exec(string(migration)); err != nil {
+			return fmt.Errorf("migration %s failed: %w", file, err)
+		}
+	}
+	return nil
+}